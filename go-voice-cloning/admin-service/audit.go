@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Audited actions
+const (
+	ActionRequeueClones = "requeue_clones"
+	ActionPurgeUser     = "purge_user"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// AuditEntry is an operation an admin ran through the service. Details hold
+// what it did, such as the clones it requeued.
+type AuditEntry struct {
+	ID         int64           `json:"id" db:"id"`
+	AdminID    int             `json:"admin_id" db:"admin_id"`
+	Action     string          `json:"action" db:"action"`
+	TargetType string          `json:"target_type" db:"target_type"`
+	TargetID   *string         `json:"target_id,omitempty" db:"target_id"`
+	Details    json.RawMessage `json:"details,omitempty" db:"details"`
+	RequestID  *string         `json:"request_id,omitempty" db:"request_id"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+}
+
+type auditCursor struct {
+	ID int64 `json:"id"`
+}
+
+// auditSort lists the newest entries first
+var auditSort = types.PageSort{Name: "-id", Column: "id", Descending: true}
+
+// audit records an operation the caller ran. The operation already
+// happened, so a failure to record it is only logged.
+func (s *AdminService) audit(r *http.Request, action, targetType, targetID string, details interface{}) {
+	doc, err := json.Marshal(details)
+	if err == nil {
+		_, err = s.db.ExecContext(r.Context(),
+			`INSERT INTO admin_audit_log (admin_id, action, target_type, target_id, details, request_id)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''))`,
+			middleware.UserID(r), action, targetType, targetID, doc, r.Header.Get(utils.RequestIDHeader))
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record admin action", "action", action, "target_id", targetID, "error", err)
+	}
+}
+
+// listAudit pages through the audit log, newest first, optionally of one
+// admin, action or target
+func (s *AdminService) listAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, err := types.ParsePageRequest(query, defaultAuditPageSize, maxAuditPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{"TRUE"}
+	if v := query.Get("admin_id"); v != "" {
+		adminID, err := strconv.Atoi(v)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid admin_id")
+			return
+		}
+		where = append(where, "admin_id = "+arg(adminID))
+	}
+	for _, filter := range []string{"action", "target_type", "target_id"} {
+		if value := query.Get(filter); value != "" {
+			where = append(where, filter+" = "+arg(value))
+		}
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM admin_audit_log WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list audit log")
+		return
+	}
+
+	var cursor auditCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, auditSort.After(nil, cursor.ID, arg))
+	}
+
+	entries := []AuditEntry{}
+	err = s.db.SelectContext(r.Context(), &entries,
+		`SELECT id, admin_id, action, target_type, target_id, details, request_id, created_at
+		FROM admin_audit_log
+		WHERE `+strings.Join(where, " AND ")+" "+auditSort.OrderBy()+" "+page.LimitClause(arg), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list audit log")
+		return
+	}
+
+	utils.SuccessResponse(w, types.NewPage(page, entries, total, func(last AuditEntry) interface{} {
+		return auditCursor{ID: last.ID}
+	}))
+}
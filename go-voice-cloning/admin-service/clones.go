@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// StuckClone is a clone whose job has been pending or processing for longer
+// than a clone takes
+type StuckClone struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Status     string     `json:"status" db:"status"`
+	Stage      *string    `json:"stage,omitempty" db:"stage"`
+	Progress   int        `json:"progress" db:"progress"`
+	Priority   string     `json:"priority" db:"priority"`
+	RetryCount int        `json:"retry_count" db:"retry_count"`
+	StartedAt  *time.Time `json:"started_at,omitempty" db:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// stuckCondition selects clones pending since before $1 that aren't
+// scheduled for later, and clones that started processing before $1.
+// Progress doesn't touch updated_at, so a clone still training is told
+// apart by how long ago it started.
+const stuckCondition = `deleted_at IS NULL AND archived_at IS NULL AND (
+	(status = 'pending' AND process_after IS NULL AND updated_at < $1)
+	OR (status = 'processing' AND COALESCE(started_at, updated_at) < $1))`
+
+const stuckColumns = "id, user_id, name, status, stage, progress, priority, retry_count, started_at, updated_at"
+
+// queueDepth reports the job queue's backlog by priority tier
+func (s *AdminService) queueDepth(w http.ResponseWriter, r *http.Request) {
+	depth, err := s.queue.Depth(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to read job queue depth", "error", err)
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Job queue unavailable")
+		return
+	}
+	utils.SuccessResponse(w, depth)
+}
+
+// stuckCutoff reads ?older_than_minutes=, defaulting to the configured
+// threshold
+func (s *AdminService) stuckCutoff(r *http.Request) (time.Time, bool) {
+	after := s.stuckAfter
+	if v := r.URL.Query().Get("older_than_minutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 1 {
+			return time.Time{}, false
+		}
+		after = time.Duration(minutes) * time.Minute
+	}
+	return time.Now().Add(-after), true
+}
+
+// listStuckClones lists the clones stuck longest first
+func (s *AdminService) listStuckClones(w http.ResponseWriter, r *http.Request) {
+	cutoff, ok := s.stuckCutoff(r)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "older_than_minutes must be a positive number")
+		return
+	}
+
+	clones := []StuckClone{}
+	err := s.db.SelectContext(r.Context(), &clones,
+		"SELECT "+stuckColumns+" FROM voice_clones WHERE "+stuckCondition+" ORDER BY updated_at LIMIT $2",
+		cutoff, s.requeueLimit)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list stuck clones")
+		return
+	}
+	utils.SuccessResponse(w, clones)
+}
+
+// requeueStuckClones puts stuck clones back to pending and queues a new job
+// for each, optionally only the clone_ids given. A worker still holding one
+// drops it at its next progress checkpoint, as the clone is no longer
+// processing. Clones that are no longer stuck are left alone.
+func (s *AdminService) requeueStuckClones(w http.ResponseWriter, r *http.Request) {
+	cutoff, ok := s.stuckCutoff(r)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "older_than_minutes must be a positive number")
+		return
+	}
+	var req struct {
+		CloneIDs []int `json:"clone_ids"`
+	}
+	if r.ContentLength != 0 && !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	clones, err := s.resetStuckClones(r.Context(), cutoff, req.CloneIDs)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to requeue stuck clones")
+		return
+	}
+
+	requeued, failed := []int{}, []int{}
+	for _, clone := range clones {
+		if err := s.queue.Enqueue(r.Context(), jobqueue.Job{CloneID: clone.ID, Priority: clone.Priority}); err != nil {
+			// Left pending; it shows up as stuck again
+			logging.FromContext(r.Context()).Error("Failed to requeue voice clone", "clone_id", clone.ID, "error", err)
+			failed = append(failed, clone.ID)
+			continue
+		}
+		requeued = append(requeued, clone.ID)
+	}
+
+	result := map[string]interface{}{
+		"requeued":    requeued,
+		"failed":      failed,
+		"stuck_since": cutoff,
+	}
+	if len(clones) > 0 {
+		s.audit(r, ActionRequeueClones, "voice_clone", "", result)
+	}
+	utils.SuccessResponse(w, result)
+}
+
+// resetStuckClones marks up to requeueLimit stuck clones pending again and
+// returns them. Clones another admin is requeueing are skipped.
+func (s *AdminService) resetStuckClones(ctx context.Context, cutoff time.Time, ids []int) ([]StuckClone, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + stuckColumns + " FROM voice_clones WHERE " + stuckCondition
+	args := []interface{}{cutoff, s.requeueLimit}
+	if len(ids) > 0 {
+		query += " AND id = ANY($3)"
+		args = append(args, pq.Array(ids))
+	}
+	clones := []StuckClone{}
+	if err := tx.SelectContext(ctx, &clones, query+" ORDER BY updated_at LIMIT $2 FOR UPDATE SKIP LOCKED", args...); err != nil {
+		return nil, err
+	}
+
+	for _, clone := range clones {
+		if err := resetClone(ctx, tx, clone); err != nil {
+			return nil, err
+		}
+	}
+	return clones, tx.Commit()
+}
+
+// resetClone returns a clone to pending, announcing the transition of one
+// that was processing
+func resetClone(ctx context.Context, tx *sqlx.Tx, clone StuckClone) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE voice_clones SET status = $1, progress = 0, stage = NULL, started_at = NULL, updated_at = $2 WHERE id = $3",
+		types.StatusPending, time.Now(), clone.ID)
+	if err != nil {
+		return err
+	}
+	if clone.Status == types.StatusPending {
+		return nil
+	}
+	return events.PublishCloneStatus(ctx, tx, clone.ID, types.StatusPending)
+}
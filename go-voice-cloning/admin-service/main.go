@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/httpclient"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/schema"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const serviceName = "admin-service"
+
+// userCallTimeout bounds a call to the user service
+const userCallTimeout = 10 * time.Second
+
+// AdminService runs the operations admins otherwise need a shell and psql
+// for, across the other services' data, and keeps an audit log of them
+type AdminService struct {
+	db             *sqlx.DB
+	queue          *jobqueue.Queue
+	userServiceURL string
+	client         *http.Client
+	stuckAfter     time.Duration
+	requeueLimit   int
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad(serviceName, &cfg)
+	if err := logging.Setup(serviceName, cfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+	config.LogSummary(&cfg)
+
+	tlsSource, err := mtls.Setup(cfg.TLS)
+	if err != nil {
+		log.Fatal("Invalid mTLS configuration: ", err)
+	}
+
+	// Database connection
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if cfg.MigrateOnStart {
+		if _, err := schema.Up(context.Background(), db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	queue, err := jobqueue.New(context.Background(), cfg.RedisURL, jobqueue.Options{})
+	if err != nil {
+		log.Fatal("Failed to connect to job queue:", err)
+	}
+	defer queue.Close()
+
+	service := &AdminService{
+		db:             db,
+		queue:          queue,
+		userServiceURL: cfg.UserServiceURL,
+		client:         httpclient.New(httpclient.Options{Timeout: userCallTimeout}),
+		stuckAfter:     cfg.StuckAfter,
+		requeueLimit:   cfg.RequeueLimit,
+	}
+
+	checks := health.New(serviceName)
+	checks.Add("postgres", health.DB(db))
+	checks.Add("job_queue", queue.Ping)
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pool{Name: "primary", DB: db})).Methods("GET")
+
+	// Everything else is for admins only
+	admin := r.PathPrefix("/").Subrouter()
+	admin.Use(requireAdmin)
+	admin.HandleFunc("/queue", service.queueDepth).Methods("GET")
+	admin.HandleFunc("/clones/stuck", service.listStuckClones).Methods("GET")
+	admin.HandleFunc("/clones/requeue", service.requeueStuckClones).Methods("POST")
+	admin.HandleFunc("/users/{id}/purge", service.purgeUser).Methods("POST")
+	admin.HandleFunc("/audit", service.listAudit).Methods("GET")
+
+	slog.Info("Admin Service starting", "port", cfg.Port)
+	log.Fatal(tlsSource.ListenAndServe(&http.Server{Addr: ":" + cfg.Port, Handler: middleware.Chain(middleware.UserLanguage(db)(r))}))
+}
+
+// requireAdmin refuses callers without the admin role. The gateway checks
+// the role too; this keeps the service safe from callers that bypass it.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.UserID(r) == 0 {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+			return
+		}
+		if !middleware.IsAdmin(r) {
+			utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
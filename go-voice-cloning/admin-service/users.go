@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// purgeUser deletes a user's account and all their data. The user service
+// owns account deletion: it closes the account and orchestrates the removal
+// of the user's clones, files and profile, whose progress it reports. Its
+// answer is passed on as is.
+func (s *AdminService) purgeUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodDelete, fmt.Sprintf("%s/admin/users/%d", s.userServiceURL, userID), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to purge user")
+		return
+	}
+	for _, header := range []string{middleware.UserIDHeader, middleware.UserRoleHeader, utils.RequestIDHeader} {
+		req.Header.Set(header, r.Header.Get(header))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to reach user service", "user_id", userID, "error", err)
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.Unavailable, "User service unavailable")
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.Unavailable, "User service unavailable")
+		return
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		var deletion struct {
+			EventID int `json:"event_id"`
+		}
+		if envelope, err := utils.ReadEnvelope(resp.StatusCode, body); err == nil {
+			json.Unmarshal(envelope.Data, &deletion)
+		}
+		s.audit(r, ActionPurgeUser, "user", strconv.Itoa(userID), map[string]interface{}{"event_id": deletion.EventID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/analytics"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// SourceSDK is the source of the events clients track through the gateway
+const SourceSDK = "sdk"
+
+const (
+	// maxEventAge is how far back an event may have happened; older ones
+	// are refused rather than added to reports already read
+	maxEventAge = 7 * 24 * time.Hour
+	// maxClockSkew is how far ahead of the service's clock a client's event
+	// may be
+	maxClockSkew = 5 * time.Minute
+	// retryBusy is how long callers are told to wait while the buffer is full
+	retryBusy = 2 * time.Second
+)
+
+// ingestEvents accepts a batch of events from a service, which names the
+// users they are of and itself as their source
+func (s *AnalyticsService) ingestEvents(w http.ResponseWriter, r *http.Request) {
+	var batch analytics.Batch
+	if !validation.DecodeJSON(w, r, &batch) {
+		return
+	}
+	for i := range batch.Events {
+		if batch.Events[i].Source == "" {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("events[%d].source is required", i))
+			return
+		}
+	}
+	s.accept(w, batch.Events)
+}
+
+// trackEvents accepts a batch of events a client tracked through the
+// gateway. They are the caller's, whatever user the body names.
+func (s *AnalyticsService) trackEvents(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var batch analytics.Batch
+	if !validation.DecodeJSON(w, r, &batch) {
+		return
+	}
+	for i := range batch.Events {
+		batch.Events[i].UserID = userID
+		batch.Events[i].Source = SourceSDK
+	}
+	s.accept(w, batch.Events)
+}
+
+// accept checks a batch's names and times and queues it for writing. A
+// batch is accepted or refused whole, so callers can retry it as is.
+func (s *AnalyticsService) accept(w http.ResponseWriter, events []analytics.Event) {
+	now := time.Now().UTC()
+	var details []apierror.Detail
+	for i := range events {
+		e := &events[i]
+		field := fmt.Sprintf("events[%d]", i)
+		if !analytics.ValidName(e.Name) {
+			details = append(details, apierror.Detail{Field: field + ".name", Code: apierror.InvalidParameter,
+				Message: field + ".name must be lowercase letters, digits and underscores, starting with a letter"})
+		}
+		if e.OccurredAt.IsZero() {
+			e.OccurredAt = now
+		}
+		e.OccurredAt = e.OccurredAt.UTC()
+		if e.OccurredAt.Before(now.Add(-maxEventAge)) || e.OccurredAt.After(now.Add(maxClockSkew)) {
+			details = append(details, apierror.Detail{Field: field + ".occurred_at", Code: apierror.InvalidParameter,
+				Message: field + ".occurred_at must be within the last 7 days"})
+		}
+	}
+	if details != nil {
+		e := apierror.New(http.StatusBadRequest, apierror.ValidationFailed, "Request validation failed")
+		e.Details = details
+		utils.WriteError(w, e)
+		return
+	}
+
+	if !s.writer.Enqueue(events) {
+		utils.RetryResponse(w, http.StatusServiceUnavailable, "Too many events queued, try again later",
+			utils.NewRetryGuidance(utils.RetryReasonOverloaded, retryBusy))
+		return
+	}
+	utils.JSONResponse(w, http.StatusAccepted, map[string]int{"accepted": len(events)})
+}
+
+// Writer writes accepted events to the store in batches, from the
+// background, so ingestion keeps up with bursts the store would slow down
+type Writer struct {
+	store         Store
+	batchSize     int
+	flushInterval time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	events    chan analytics.Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWriter starts writing to store batches of up to batchSize events, at
+// least every flushInterval, holding up to bufferSize while it catches up
+func NewWriter(store Store, bufferSize, batchSize int, flushInterval time.Duration) *Writer {
+	wr := &Writer{
+		store:         store,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		events:        make(chan analytics.Event, bufferSize),
+		done:          make(chan struct{}),
+	}
+	go wr.run()
+	return wr
+}
+
+// Enqueue queues events for writing, all of them or, when there isn't room
+// for them all, none
+func (wr *Writer) Enqueue(events []analytics.Event) bool {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if wr.closed || cap(wr.events)-len(wr.events) < len(events) {
+		return false
+	}
+	for _, e := range events {
+		wr.events <- e
+	}
+	return true
+}
+
+// Close writes the queued events and stops the writer
+func (wr *Writer) Close() {
+	wr.closeOnce.Do(func() {
+		wr.mu.Lock()
+		wr.closed = true
+		close(wr.events)
+		wr.mu.Unlock()
+		<-wr.done
+	})
+}
+
+func (wr *Writer) run() {
+	defer close(wr.done)
+	ticker := time.NewTicker(wr.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]analytics.Event, 0, wr.batchSize)
+	for {
+		select {
+		case e, ok := <-wr.events:
+			if !ok {
+				wr.write(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) < wr.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		wr.write(batch)
+		batch = batch[:0]
+	}
+}
+
+// write inserts a batch, retrying with backoff. Events the store keeps
+// refusing are dropped, with the count logged.
+func (wr *Writer) write(batch []analytics.Event) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= 5; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = wr.store.Insert(ctx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		slog.Warn("Failed to write analytics events", "events", len(batch), "attempt", attempt, "error", err)
+		if attempt < 5 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	slog.Error("Dropped analytics events", "events", len(batch), "error", err)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/schema"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const serviceName = "analytics-service"
+
+// AnalyticsService ingests the product events of the services and clients,
+// and aggregates them for the admin dashboard and users' stats pages
+type AnalyticsService struct {
+	store  Store
+	writer *Writer
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad(serviceName, &cfg)
+	if err := logging.Setup(serviceName, cfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+	config.LogSummary(&cfg)
+
+	tlsSource, err := mtls.Setup(cfg.TLS)
+	if err != nil {
+		log.Fatal("Invalid mTLS configuration: ", err)
+	}
+
+	// Database connection
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if cfg.MigrateOnStart {
+		if _, err := schema.Up(context.Background(), db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	checks := health.New(serviceName)
+	checks.Add("postgres", health.DB(db))
+
+	var store Store
+	switch cfg.Store {
+	case StorePostgres:
+		store = &postgresStore{db: db}
+	case StoreClickHouse:
+		if cfg.ClickHouseURL == "" {
+			log.Fatal("CLICKHOUSE_URL is required with ANALYTICS_STORE=clickhouse")
+		}
+		clickhouse, err := newClickHouseStore(context.Background(), cfg.ClickHouseURL)
+		if err != nil {
+			log.Fatal("Failed to connect to ClickHouse:", err)
+		}
+		checks.Add("clickhouse", clickhouse.Ping)
+		store = clickhouse
+	default:
+		log.Fatalf("Invalid ANALYTICS_STORE %q: want postgres or clickhouse", cfg.Store)
+	}
+
+	writer := NewWriter(store, cfg.BufferSize, cfg.BatchSize, cfg.FlushInterval)
+	defer writer.Close()
+
+	service := &AnalyticsService{store: store, writer: writer}
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pool{Name: "primary", DB: db})).Methods("GET")
+
+	// Events of the services, and of clients through the gateway
+	r.HandleFunc("/events", service.ingestEvents).Methods("POST")
+	r.HandleFunc("/track", service.trackEvents).Methods("POST")
+	r.HandleFunc("/stats", service.getStats).Methods("GET")
+
+	// The admin dashboard's figures
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.HandleFunc("/overview", service.getOverview).Methods("GET")
+	admin.HandleFunc("/series", service.getSeries).Methods("GET")
+
+	slog.Info("Analytics Service starting", "port", cfg.Port, "store", cfg.Store)
+	log.Fatal(tlsSource.ListenAndServe(&http.Server{Addr: ":" + cfg.Port, Handler: middleware.Chain(middleware.UserLanguage(db)(r))}))
+}
+
+// requireAdmin refuses callers without the admin role. The gateway only
+// checks that they are signed in.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.UserID(r) == 0 {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+			return
+		}
+		if !middleware.IsAdmin(r) {
+			utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/analytics"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultRangeDays = 30
+	maxRangeDays     = 366
+	// maxHourlyDays bounds hourly series, which have 24 buckets a day
+	maxHourlyDays = 31
+	dateLayout    = "2006-01-02"
+)
+
+// parseQuery reads the events a report is about: those from ?from= to ?to=
+// (inclusive dates, UTC), the last 30 days by default, of the names listed
+// in ?events=, all by default, bucketed by ?interval= (day or hour). It
+// answers an invalid query with 400 and reports whether the handler can go
+// on.
+func parseQuery(w http.ResponseWriter, r *http.Request) (Query, bool) {
+	params := r.URL.Query()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, from := today, today.AddDate(0, 0, 1-defaultRangeDays)
+
+	var err error
+	if v := params.Get("to"); v != "" {
+		if to, err = time.Parse(dateLayout, v); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "to must be a YYYY-MM-DD date")
+			return Query{}, false
+		}
+		from = to.AddDate(0, 0, 1-defaultRangeDays)
+	}
+	if v := params.Get("from"); v != "" {
+		if from, err = time.Parse(dateLayout, v); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "from must be a YYYY-MM-DD date")
+			return Query{}, false
+		}
+	}
+	if from.After(to) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "from must not be after to")
+		return Query{}, false
+	}
+	days := int(to.Sub(from).Hours()/24) + 1
+	if days > maxRangeDays {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "The range is limited to 366 days")
+		return Query{}, false
+	}
+
+	q := Query{From: from, To: to.AddDate(0, 0, 1), Interval: IntervalDay}
+	switch interval := params.Get("interval"); interval {
+	case "", IntervalDay:
+	case IntervalHour:
+		if days > maxHourlyDays {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Hourly series are limited to 31 days")
+			return Query{}, false
+		}
+		q.Interval = IntervalHour
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "interval must be day or hour")
+		return Query{}, false
+	}
+
+	if v := params.Get("events"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if !analytics.ValidName(name) {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid event name: "+name)
+				return Query{}, false
+			}
+			q.Names = append(q.Names, name)
+		}
+	}
+	return q, true
+}
+
+// getStats serves the caller's stats page: their totals of each event and
+// their series over the range
+func (s *AnalyticsService) getStats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	q, ok := parseQuery(w, r)
+	if !ok {
+		return
+	}
+	q.UserID = userID
+
+	totals, err := s.store.Totals(r.Context(), q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to total events", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch stats")
+		return
+	}
+	series, err := s.store.Series(r.Context(), q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to chart events", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch stats")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"from":     q.From.Format(dateLayout),
+		"to":       q.To.AddDate(0, 0, -1).Format(dateLayout),
+		"interval": q.Interval,
+		"totals":   totals,
+		"series":   series,
+	})
+}
+
+// getOverview serves the admin dashboard's figures: how many users were
+// active and the platform's totals of each event over the range
+func (s *AnalyticsService) getOverview(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseQuery(w, r)
+	if !ok {
+		return
+	}
+
+	active, err := s.store.ActiveUsers(r.Context(), q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to count active users", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch overview")
+		return
+	}
+	totals, err := s.store.Totals(r.Context(), q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to total events", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch overview")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"from":         q.From.Format(dateLayout),
+		"to":           q.To.AddDate(0, 0, -1).Format(dateLayout),
+		"active_users": active,
+		"totals":       totals,
+	})
+}
+
+// getSeries serves the admin dashboard's charts: the platform's series of
+// each event over the range, or one user's with ?user_id=
+func (s *AnalyticsService) getSeries(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseQuery(w, r)
+	if !ok {
+		return
+	}
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		userID, err := strconv.Atoi(v)
+		if err != nil || userID <= 0 {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user_id")
+			return
+		}
+		q.UserID = userID
+	}
+
+	series, err := s.store.Series(r.Context(), q)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to chart events", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch series")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"from":     q.From.Format(dateLayout),
+		"to":       q.To.AddDate(0, 0, -1).Format(dateLayout),
+		"interval": q.Interval,
+		"series":   series,
+	})
+}
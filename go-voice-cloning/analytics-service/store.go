@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/analytics"
+)
+
+// Stores selected with ANALYTICS_STORE
+const (
+	StorePostgres   = "postgres"
+	StoreClickHouse = "clickhouse"
+)
+
+// Intervals of a series
+const (
+	IntervalDay  = "day"
+	IntervalHour = "hour"
+)
+
+// Query selects the events aggregated: those in [From, To), of one user
+// unless UserID is 0, and of the listed names unless Names is empty.
+// Interval is the bucket of a series.
+type Query struct {
+	From     time.Time
+	To       time.Time
+	UserID   int
+	Names    []string
+	Interval string
+}
+
+// Total is how many times an event happened, and to how many users
+type Total struct {
+	Name   string `json:"name" db:"name"`
+	Events int64  `json:"events" db:"events"`
+	Users  int64  `json:"users" db:"users"`
+}
+
+// Point is an event's total within a bucket of a series, starting at Bucket
+type Point struct {
+	Bucket time.Time `json:"bucket" db:"bucket"`
+	Name   string    `json:"name" db:"name"`
+	Events int64     `json:"events" db:"events"`
+	Users  int64     `json:"users" db:"users"`
+}
+
+// Store keeps events and aggregates them
+type Store interface {
+	Insert(ctx context.Context, events []analytics.Event) error
+	// Totals are the events' totals by name, most frequent first
+	Totals(ctx context.Context, q Query) ([]Total, error)
+	// Series are the events' totals by bucket and name, oldest first
+	Series(ctx context.Context, q Query) ([]Point, error)
+	// ActiveUsers is how many users had any of the events
+	ActiveUsers(ctx context.Context, q Query) (int64, error)
+	Ping(ctx context.Context) error
+}
+
+// postgresStore keeps events in the analytics_events table of the shared
+// database
+type postgresStore struct {
+	db *sqlx.DB
+}
+
+func (s *postgresStore) Insert(ctx context.Context, events []analytics.Event) error {
+	rows := make([]string, len(events))
+	args := make([]interface{}, 0, 5*len(events))
+	for i, e := range events {
+		var properties interface{}
+		if len(e.Properties) > 0 {
+			doc, err := json.Marshal(e.Properties)
+			if err != nil {
+				return err
+			}
+			properties = string(doc)
+		}
+		n := len(args)
+		rows[i] = fmt.Sprintf("($%d, NULLIF($%d, 0), $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, e.Name, e.UserID, e.Source, properties, e.OccurredAt)
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO analytics_events (name, user_id, source, properties, occurred_at) VALUES "+strings.Join(rows, ", "),
+		args...)
+	return err
+}
+
+// where is the condition selecting q's events, with its arguments
+func (q Query) where() (string, []interface{}) {
+	where := []string{"occurred_at >= $1", "occurred_at < $2"}
+	args := []interface{}{q.From, q.To}
+	if q.UserID != 0 {
+		args = append(args, q.UserID)
+		where = append(where, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if len(q.Names) > 0 {
+		args = append(args, pq.Array(q.Names))
+		where = append(where, fmt.Sprintf("name = ANY($%d)", len(args)))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+func (s *postgresStore) Totals(ctx context.Context, q Query) ([]Total, error) {
+	where, args := q.where()
+	totals := []Total{}
+	err := s.db.SelectContext(ctx, &totals,
+		`SELECT name, COUNT(*) AS events, COUNT(DISTINCT user_id) AS users
+		FROM analytics_events WHERE `+where+`
+		GROUP BY name ORDER BY events DESC, name`, args...)
+	return totals, err
+}
+
+func (s *postgresStore) Series(ctx context.Context, q Query) ([]Point, error) {
+	where, args := q.where()
+	args = append(args, q.Interval)
+	points := []Point{}
+	err := s.db.SelectContext(ctx, &points, fmt.Sprintf(
+		`SELECT date_trunc($%d, occurred_at) AS bucket, name, COUNT(*) AS events, COUNT(DISTINCT user_id) AS users
+		FROM analytics_events WHERE %s
+		GROUP BY bucket, name ORDER BY bucket, name`, len(args), where), args...)
+	return points, err
+}
+
+func (s *postgresStore) ActiveUsers(ctx context.Context, q Query) (int64, error) {
+	where, args := q.where()
+	var users int64
+	err := s.db.GetContext(ctx, &users, "SELECT COUNT(DISTINCT user_id) FROM analytics_events WHERE "+where, args...)
+	return users, err
+}
+
+func (s *postgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
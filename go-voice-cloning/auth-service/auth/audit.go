@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/voice-cloning/shared/audit"
+)
+
+// Operations recorded to the audit log
+const (
+	auditRegister         = "register"
+	auditLogin            = "login"
+	auditLogout           = "logout"
+	auditAcceptInvitation = "accept_invitation"
+	auditEmailTemplate    = "update_email_template"
+	auditResetTemplate    = "reset_email_template"
+	auditEmailBranding    = "update_email_branding"
+)
+
+// auditUser records an operation a user ran on their own account before
+// being authenticated, such as signing in. userID is 0 when the account
+// isn't known.
+func (s *AuthService) auditUser(r *http.Request, userID int, action string, outcome audit.Outcome, details map[string]interface{}) {
+	rec := audit.FromRequest(r)
+	rec.ActorID = userID
+	rec.Action = action
+	rec.ResourceType = "user"
+	if userID != 0 {
+		rec.ResourceID = strconv.Itoa(userID)
+	}
+	rec.Outcome = outcome
+	rec.Details = details
+	s.audit.Record(r.Context(), rec)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+)
+
+// recordLogin remembers the device of a login and records a
+// login.new_device activity the first time one is seen. A user's first
+// login isn't reported: every device is new then. Failures are logged; the
+// login goes ahead.
+func (s *AuthService) recordLogin(r *http.Request, userID int) {
+	userAgent := r.UserAgent()
+	sum := sha256.Sum256([]byte(userAgent))
+	fingerprint := hex.EncodeToString(sum[:])
+	ip := clientIP(r)
+
+	logger := logging.FromContext(r.Context()).With("user_id", userID)
+	ctx := context.Background()
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to record login device", "error", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var known, seen bool
+	err = tx.GetContext(ctx, &known, "SELECT EXISTS (SELECT 1 FROM login_devices WHERE user_id = $1)", userID)
+	if err == nil {
+		err = tx.GetContext(ctx, &seen, "SELECT EXISTS (SELECT 1 FROM login_devices WHERE user_id = $1 AND fingerprint = $2)", userID, fingerprint)
+	}
+	if err != nil {
+		logger.Error("Failed to record login device", "error", err)
+		return
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO login_devices (user_id, fingerprint, user_agent, last_ip) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, fingerprint) DO UPDATE SET last_ip = EXCLUDED.last_ip, last_seen_at = `+s.dialect.Now(),
+		userID, fingerprint, userAgent, ip)
+	if err != nil {
+		logger.Error("Failed to record login device", "error", err)
+		return
+	}
+	if known && !seen {
+		err := events.RecordActivity(ctx, tx, userID, types.ActivityNewDeviceLogin, "", map[string]string{
+			"user_agent": userAgent,
+			"ip":         ip,
+		})
+		if err != nil {
+			logger.Error("Failed to record new device login", "error", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("Failed to record login device", "error", err)
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// orgTemplate loads an org's override of a template kind
+func (s *AuthService) orgTemplate(org, kind string) (mail.Template, error) {
+	var t mail.Template
+	if org == "" {
+		return t, nil
+	}
+	err := s.db.QueryRow(
+		"SELECT subject, text_body, html_body FROM email_templates WHERE org = $1 AND kind = $2",
+		org, kind).Scan(&t.Subject, &t.Text, &t.HTML)
+	if err == sql.ErrNoRows {
+		return mail.Template{}, nil
+	}
+	return t, err
+}
+
+// orgBranding loads an org's branding override
+func (s *AuthService) orgBranding(org string) (mail.Branding, error) {
+	var b mail.Branding
+	if org == "" {
+		return b, nil
+	}
+	err := s.db.Get(&b, "SELECT name, logo_url, primary_color, support_email FROM email_branding WHERE org = $1", org)
+	if err == sql.ErrNoRows {
+		return mail.Branding{}, nil
+	}
+	return b, err
+}
+
+// renderEmail resolves a template for an org and renders it
+func (s *AuthService) renderEmail(kind, org string, data mail.Data) (mail.Message, error) {
+	override, err := s.orgTemplate(org, kind)
+	if err != nil {
+		return mail.Message{}, err
+	}
+	t, err := s.emails.Resolve(kind, override)
+	if err != nil {
+		return mail.Message{}, err
+	}
+	brand, err := s.orgBranding(org)
+	if err != nil {
+		return mail.Message{}, err
+	}
+	data.Brand = s.emails.Brand(brand)
+	return mail.Render(t, data)
+}
+
+// sendEmail renders and sends a templated email. Failures are logged since
+// callers should not fail the user's request over a mail outage.
+func (s *AuthService) sendEmail(kind, org string, data mail.Data) {
+	msg, err := s.renderEmail(kind, org, data)
+	if err == nil {
+		err = s.mailer.SendMessage(msg)
+	}
+	if err != nil {
+		slog.Error("Failed to send email", "kind", kind, "recipient", data.Recipient.Email, "error", err)
+	}
+}
+
+// getEmailTemplate returns the effective template of a kind for an org
+// (?org=, the deployment template when omitted) and the org's override
+func (s *AuthService) getEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	kind := mux.Vars(r)["kind"]
+	org := r.URL.Query().Get("org")
+
+	override, err := s.orgTemplate(org, kind)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch template")
+		return
+	}
+	effective, err := s.emails.Resolve(kind, override)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Unknown email template")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"kind":      kind,
+		"org":       org,
+		"override":  override,
+		"effective": effective,
+	})
+}
+
+// putEmailTemplate stores an org's override after checking it renders
+func (s *AuthService) putEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditEmailTemplate, "email_template", r.URL.Query().Get("org")+"/"+mux.Vars(r)["kind"], audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	kind := mux.Vars(r)["kind"]
+	org := r.URL.Query().Get("org")
+	if org == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "org is required; deployment templates are set with EMAIL_TEMPLATE_DIR")
+		return
+	}
+
+	var override mail.Template
+	if !validation.DecodeJSON(w, r, &override) {
+		return
+	}
+	effective, err := s.emails.Resolve(kind, override)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Unknown email template")
+		return
+	}
+	if err := mail.Validate(effective); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid template: "+err.Error())
+		return
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO email_templates (org, kind, subject, text_body, html_body, updated_at)
+		VALUES ($1, $2, $3, $4, $5, `+s.dialect.Now()+`)
+		ON CONFLICT (org, kind) DO UPDATE SET subject = EXCLUDED.subject, text_body = EXCLUDED.text_body,
+			html_body = EXCLUDED.html_body, updated_at = EXCLUDED.updated_at`,
+		org, kind, override.Subject, override.Text, override.HTML)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save template")
+		return
+	}
+	s.audit.Request(r, auditEmailTemplate, "email_template", org+"/"+kind, audit.Success, nil)
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"kind":      kind,
+		"org":       org,
+		"override":  override,
+		"effective": effective,
+	})
+}
+
+// deleteEmailTemplate reverts an org to the deployment template
+func (s *AuthService) deleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditResetTemplate, "email_template", r.URL.Query().Get("org")+"/"+mux.Vars(r)["kind"], audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	kind := mux.Vars(r)["kind"]
+	org := r.URL.Query().Get("org")
+
+	if _, err := s.db.Exec("DELETE FROM email_templates WHERE org = $1 AND kind = $2", org, kind); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete template")
+		return
+	}
+	s.audit.Request(r, auditResetTemplate, "email_template", org+"/"+kind, audit.Success, nil)
+	utils.SuccessResponse(w, map[string]string{"message": "Template override removed"})
+}
+
+// previewEmailTemplate renders a template with sample data. A template in
+// the body is previewed as an unsaved override; otherwise the stored
+// template for ?org= is used.
+func (s *AuthService) previewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	kind := mux.Vars(r)["kind"]
+	org := r.URL.Query().Get("org")
+
+	var draft mail.Template
+	if r.ContentLength != 0 {
+		if !validation.DecodeJSON(w, r, &draft) {
+			return
+		}
+	}
+
+	override, err := s.orgTemplate(org, kind)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch template")
+		return
+	}
+	effective, err := s.emails.Resolve(kind, override)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Unknown email template")
+		return
+	}
+	if err := mail.Validate(draft); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid template: "+err.Error())
+		return
+	}
+	effective, _ = s.emails.Resolve(kind, override.Over(draft))
+
+	brand, err := s.orgBranding(org)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch branding")
+		return
+	}
+	data := mail.SampleData()
+	data.Brand = s.emails.Brand(brand)
+
+	msg, err := mail.Render(effective, data)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid template: "+err.Error())
+		return
+	}
+	utils.SuccessResponse(w, msg)
+}
+
+// putEmailBranding stores an org's branding override
+func (s *AuthService) putEmailBranding(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditEmailBranding, "email_branding", r.URL.Query().Get("org"), audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	org := r.URL.Query().Get("org")
+	if org == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "org is required; deployment branding is set with BRAND_* variables")
+		return
+	}
+
+	var b mail.Branding
+	if !validation.DecodeJSON(w, r, &b) {
+		return
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO email_branding (org, name, logo_url, primary_color, support_email, updated_at)
+		VALUES ($1, $2, $3, $4, $5, `+s.dialect.Now()+`)
+		ON CONFLICT (org) DO UPDATE SET name = EXCLUDED.name, logo_url = EXCLUDED.logo_url,
+			primary_color = EXCLUDED.primary_color, support_email = EXCLUDED.support_email, updated_at = EXCLUDED.updated_at`,
+		org, b.Name, b.LogoURL, b.PrimaryColor, b.SupportEmail)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save branding")
+		return
+	}
+	s.audit.Request(r, auditEmailBranding, "email_branding", org, audit.Success, nil)
+
+	utils.SuccessResponse(w, map[string]interface{}{"org": org, "branding": s.emails.Brand(b)})
+}
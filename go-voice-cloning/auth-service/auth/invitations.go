@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// acceptInvitation lets a user created by a bulk import set their password
+// and sign in for the first time. Invitations are issued by the user service.
+func (s *AuthService) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Token == "" || len(req.Password) < 8 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Token and a password of at least 8 characters are required")
+		return
+	}
+
+	hashedPassword, algo, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to hash password")
+		return
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	defer tx.Rollback()
+
+	// Claiming the invitation and setting the password happen in one
+	// transaction, so of two concurrent accepts only one claims the row
+	now := time.Now()
+	var userID int
+	err = tx.Get(&userID,
+		`UPDATE user_invitations SET accepted_at = $1
+		WHERE token = $2 AND accepted_at IS NULL
+			AND (expires_at IS NULL OR expires_at > `+s.dialect.Now()+`)
+		RETURNING user_id`,
+		now, req.Token)
+	if err == sql.ErrNoRows {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.InvitationNotFound, "Invitation not found, expired or already accepted")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	if _, err := tx.Exec("UPDATE users SET password = $1, password_algo = $2, updated_at = $3 WHERE id = $4", hashedPassword, algo, now, userID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	var user types.User
+	if err := tx.Get(&user, "SELECT id, email, username, role, created_at, updated_at FROM users WHERE id = $1", userID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	s.auditUser(r, user.ID, auditAcceptInvitation, audit.Success, nil)
+
+	token, err := utils.GenerateToken(user.ID, user.Email, user.Username, user.Role)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to generate token")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, types.AuthResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		User:      user,
+	})
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// errTokenRevoked means a token was signed out before it expired
+var errTokenRevoked = errors.New("token revoked")
+
+// tokenHash identifies a token without storing it
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkToken validates a token and rejects ones revoked by logout
+func (s *AuthService) checkToken(token string) (*utils.Claims, error) {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	var revoked bool
+	err = s.db.Get(&revoked, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_hash = $1)", tokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errTokenRevoked
+	}
+	return claims, nil
+}
+
+// logout revokes an access token until it expires. Tokens are the whole
+// session, so this signs the session out everywhere it is used.
+func (s *AuthService) logout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	claims, err := utils.ValidateToken(req.Token)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidToken, "Invalid token")
+		return
+	}
+	expiresAt := time.Now().Add(24 * time.Hour)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO revoked_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (token_hash) DO NOTHING`,
+		tokenHash(req.Token), claims.UserID, expiresAt)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to revoke token")
+		return
+	}
+	s.auditUser(r, claims.UserID, auditLogout, audit.Success, nil)
+
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"revoked":    true,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash algorithms recorded per user
+const (
+	AlgoBcrypt   = "bcrypt"
+	AlgoArgon2id = "argon2id"
+)
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// Argon2Params are the Argon2id cost parameters
+type Argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// PasswordHasher hashes new passwords with Argon2id and verifies both
+// Argon2id and legacy bcrypt hashes
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+// PasswordConfig sets the Argon2id cost of new password hashes. The bounds
+// keep each value in range of its parameter.
+type PasswordConfig struct {
+	Argon2MemoryKB int `env:"ARGON2_MEMORY_KB" default:"65536" min:"8" max:"4294967295"`
+	Argon2Time     int `env:"ARGON2_TIME" default:"3" min:"1" max:"4294967295"`
+	Argon2Threads  int `env:"ARGON2_THREADS" default:"2" min:"1" max:"255"`
+}
+
+func newPasswordHasher(cfg PasswordConfig) (*PasswordHasher, error) {
+	// Argon2 needs at least 8 KiB of memory per thread
+	if cfg.Argon2MemoryKB < 8*cfg.Argon2Threads {
+		return nil, fmt.Errorf("ARGON2_MEMORY_KB must be at least 8 times ARGON2_THREADS (%d), got %d",
+			8*cfg.Argon2Threads, cfg.Argon2MemoryKB)
+	}
+	return &PasswordHasher{params: Argon2Params{
+		Memory:  uint32(cfg.Argon2MemoryKB),
+		Time:    uint32(cfg.Argon2Time),
+		Threads: uint8(cfg.Argon2Threads),
+		SaltLen: 16,
+		KeyLen:  32,
+	}}, nil
+}
+
+// Hash returns the PHC-encoded Argon2id hash and the algorithm name
+func (h *PasswordHasher) Hash(password string) (string, string, error) {
+	salt := make([]byte, h.params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", "", err
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+	return encoded, AlgoArgon2id, nil
+}
+
+// Verify checks a password against a stored hash. needsRehash is true when
+// the password matched but the hash uses bcrypt or outdated Argon2 parameters.
+func (h *PasswordHasher) Verify(password, hash, algo string) (ok bool, needsRehash bool) {
+	switch algo {
+	case AlgoArgon2id:
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false, false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(candidate, key) != 1 {
+			return false, false
+		}
+		return true, params.Memory != h.params.Memory || params.Time != h.params.Time || params.Threads != h.params.Threads
+	default:
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		return true, true
+	}
+}
+
+func decodeArgon2Hash(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgoArgon2id {
+		return p, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	return p, salt, key, nil
+}
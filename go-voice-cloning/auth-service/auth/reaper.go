@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/dialect"
+)
+
+// reapTarget describes one kind of expired row to purge. Targets whose table
+// does not exist yet are skipped, so new auth tables can register here before
+// every deployment has migrated.
+type reapTarget struct {
+	name  string
+	table string
+	where string
+}
+
+func defaultReapTargets(d dialect.Dialect) []reapTarget {
+	now := d.Now()
+	return []reapTarget{
+		{"revoked_tokens", "revoked_tokens", "expires_at < " + now},
+		{"invitations", "user_invitations", "accepted_at IS NULL AND expires_at < " + now},
+		{"org_invitations", "org_invitations", "accepted_at IS NULL AND expires_at < " + now},
+	}
+}
+
+// Reaper deletes expired auth rows, as the session-purge job
+type Reaper struct {
+	service *AuthService
+	targets []reapTarget
+
+	mu      sync.Mutex
+	deleted map[string]int64
+	runs    int64
+	lastRun time.Time
+}
+
+func newReaper(service *AuthService) *Reaper {
+	return &Reaper{
+		service: service,
+		targets: defaultReapTargets(service.dialect),
+		deleted: map[string]int64{},
+	}
+}
+
+// reap purges every target, failing if any of them couldn't be purged. The
+// scheduler keeps replicas from reaping at the same time.
+func (rp *Reaper) reap(ctx context.Context) error {
+	db, d := rp.service.db, rp.service.dialect
+	var errs []error
+	for _, t := range rp.targets {
+		exists, err := d.TableExists(ctx, db, t.table)
+		if err != nil {
+			slog.Error("Reaper failed to check table", "target", t.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+			continue
+		}
+		if !exists {
+			continue
+		}
+
+		res, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", t.table, t.where))
+		if err != nil {
+			slog.Error("Reaper failed to purge", "target", t.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+			continue
+		}
+		n, _ := res.RowsAffected()
+		if n > 0 {
+			slog.Info("Reaper purged expired rows", "target", t.name, "rows", n)
+		}
+		rp.record(t.name, n)
+	}
+
+	rp.mu.Lock()
+	rp.runs++
+	rp.lastRun = time.Now()
+	rp.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+func (rp *Reaper) record(name string, n int64) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.deleted[name] += n
+}
+
+// metrics writes the reaper counters in Prometheus text format
+func (rp *Reaper) metrics(w http.ResponseWriter, r *http.Request) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	names := make([]string, 0, len(rp.targets))
+	for _, t := range rp.targets {
+		names = append(names, t.name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP auth_reaper_rows_deleted_total Expired rows removed by the reaper.")
+	fmt.Fprintln(w, "# TYPE auth_reaper_rows_deleted_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "auth_reaper_rows_deleted_total{target=%q} %d\n", name, rp.deleted[name])
+	}
+	fmt.Fprintln(w, "# HELP auth_reaper_runs_total Completed reaper runs.")
+	fmt.Fprintln(w, "# TYPE auth_reaper_runs_total counter")
+	fmt.Fprintf(w, "auth_reaper_runs_total %d\n", rp.runs)
+	if !rp.lastRun.IsZero() {
+		fmt.Fprintln(w, "# HELP auth_reaper_last_run_timestamp_seconds Time of the last completed run.")
+		fmt.Fprintln(w, "# TYPE auth_reaper_last_run_timestamp_seconds gauge")
+		fmt.Fprintf(w, "auth_reaper_last_run_timestamp_seconds %d\n", rp.lastRun.Unix())
+	}
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/cron"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/dialect"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/referrals"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+type AuthService struct {
+	db                   *sqlx.DB
+	dialect              dialect.Dialect
+	audit                *audit.Log
+	challenge            ChallengeVerifier
+	introspectionClients map[string]string
+	tokenClientID        string
+	hasher               *PasswordHasher
+	mailer               *mail.Mailer
+	emails               *mail.Renderer
+}
+
+// New builds the auth service on a migrated database and starts its reaper.
+// It returns the service's handler, and a function closing its audit log
+// and event bus once it no longer serves.
+func New(cfg Config, db *sqlx.DB) (http.Handler, func()) {
+	challenge, err := newChallengeVerifier(cfg.Captcha)
+	if err != nil {
+		log.Fatal("Invalid CAPTCHA configuration:", err)
+	}
+
+	emails, err := mail.NewRenderer(cfg.Mail)
+	if err != nil {
+		log.Fatal("Invalid email templates:", err)
+	}
+
+	// Security-relevant operations go to the central audit log, which may
+	// be shipped over the event bus
+	eventBus, err := events.BusFromEnv()
+	if err != nil {
+		log.Fatal("Failed to connect to event bus:", err)
+	}
+	auditLog, err := audit.Open("auth-service", cfg.Audit, db, eventBus)
+	if err != nil {
+		log.Fatal("Invalid audit configuration:", err)
+	}
+
+	hasher, err := newPasswordHasher(cfg.Password)
+	if err != nil {
+		log.Fatal("Invalid password hashing configuration: ", err)
+	}
+
+	service := &AuthService{
+		db:                   db,
+		dialect:              dialect.Of(db),
+		audit:                auditLog,
+		challenge:            challenge,
+		introspectionClients: introspectionClients(cfg.Introspection),
+		tokenClientID:        cfg.Introspection.ClientID,
+		hasher:               hasher,
+		mailer:               mail.NewMailer(cfg.Mail),
+		emails:               emails,
+	}
+
+	// Maintenance jobs
+	reaper := newReaper(service)
+	jobs, err := cron.New("auth-service", cfg.Cron, db, auditLog)
+	if err != nil {
+		log.Fatal("Invalid cron configuration:", err)
+	}
+	if err := jobs.Add("session-purge", cron.Spec(cfg.ReaperSchedule, cfg.ReaperInterval), reaper.reap); err != nil {
+		log.Fatal("Invalid REAPER_SCHEDULE:", err)
+	}
+	jobs.Start()
+
+	checks := health.New("auth-service")
+	checks.Add("postgres", health.DB(db))
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		reaper.metrics(w, r)
+		dbpool.WriteMetrics(w, dbpool.Pool{Name: "primary", DB: db})
+	}).Methods("GET")
+	r.HandleFunc("/register", service.register).Methods("POST")
+	r.HandleFunc("/login", service.login).Methods("POST")
+	r.HandleFunc("/validate", service.validateToken).Methods("POST")
+	r.HandleFunc("/logout", service.logout).Methods("POST")
+	r.HandleFunc("/introspect", service.introspect).Methods("POST")
+	r.HandleFunc("/invitations/accept", service.acceptInvitation).Methods("POST")
+	r.HandleFunc("/admin/email-templates/{kind}", service.getEmailTemplate).Methods("GET")
+	r.HandleFunc("/admin/email-templates/{kind}", service.putEmailTemplate).Methods("PUT")
+	r.HandleFunc("/admin/email-templates/{kind}", service.deleteEmailTemplate).Methods("DELETE")
+	r.HandleFunc("/admin/email-templates/{kind}/preview", service.previewEmailTemplate).Methods("POST")
+	r.HandleFunc("/admin/email-branding", service.putEmailBranding).Methods("PUT")
+	r.PathPrefix("/admin/jobs").Handler(jobs)
+
+	return middleware.Chain(middleware.UserLanguage(db)(r)), func() {
+		jobs.Close()
+		auditLog.Close()
+		if eventBus != nil {
+			eventBus.Close()
+		}
+	}
+}
+
+func (s *AuthService) register(w http.ResponseWriter, r *http.Request) {
+	var req types.RegisterRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	// Verify the registration challenge before touching the database
+	if s.challenge != nil {
+		if err := s.challenge.Verify(r.Context(), req.CaptchaToken, clientIP(r)); err != nil {
+			if errors.Is(err, ErrChallengeFailed) {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.ChallengeFailed, "Challenge verification failed")
+			} else {
+				logging.FromContext(r.Context()).Error("Challenge verification error", "error", err)
+				utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Challenge verification unavailable")
+			}
+			return
+		}
+	}
+
+	// Check if user already exists
+	var existingID int
+	err := s.db.Get(&existingID, "SELECT id FROM users WHERE email = $1 OR username = $2", req.Email, req.Username)
+	if err == nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.UserExists, "User already exists")
+		return
+	}
+
+	// Resolve the referral code up front so a mistyped one can be corrected
+	var referrerID int
+	if strings.TrimSpace(req.ReferralCode) != "" {
+		referrerID, err = referrals.Referrer(r.Context(), s.db, req.ReferralCode)
+		if errors.Is(err, referrals.ErrUnknownCode) {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidReferralCode, "Unknown referral code")
+			return
+		}
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to check referral code")
+			return
+		}
+	}
+
+	// Hash password
+	hashedPassword, algo, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to hash password")
+		return
+	}
+
+	// Insert user
+	var userID int
+	err = s.db.QueryRow(
+		"INSERT INTO users (email, username, password, password_algo, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		req.Email, req.Username, hashedPassword, algo, time.Now(), time.Now(),
+	).Scan(&userID)
+
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create user")
+		return
+	}
+	if referrerID != 0 {
+		if err := referrals.Record(r.Context(), s.db, referrerID, userID, req.ReferralCode); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to record referral", "user_id", userID, "referrer_id", referrerID, "error", err)
+		}
+	}
+	s.auditUser(r, userID, auditRegister, audit.Success, nil)
+
+	// Generate token
+	token, err := utils.GenerateToken(userID, req.Email, req.Username, types.RoleUser)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to generate token")
+		return
+	}
+
+	user := types.User{
+		ID:        userID,
+		Email:     req.Email,
+		Username:  req.Username,
+		Role:      types.RoleUser,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	utils.JSONResponse(w, http.StatusCreated, types.AuthResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		User:      user,
+	})
+}
+
+func (s *AuthService) login(w http.ResponseWriter, r *http.Request) {
+	var req types.LoginRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	// Get user from database
+	var user types.User
+	err := s.db.Get(&user, "SELECT id, email, username, password, password_algo, role, created_at, updated_at FROM users WHERE email = $1", req.Email)
+	if err != nil {
+		s.auditUser(r, 0, auditLogin, audit.Failure, map[string]interface{}{"email": req.Email, "reason": "unknown_email"})
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	// Verify password
+	ok, needsRehash := s.hasher.Verify(req.Password, user.Password, user.PasswordAlgo)
+	if !ok {
+		s.auditUser(r, user.ID, auditLogin, audit.Failure, map[string]interface{}{"reason": "wrong_password"})
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	// Transparently upgrade bcrypt and outdated Argon2id hashes
+	if needsRehash {
+		s.rehashPassword(user.ID, req.Password)
+	}
+	s.recordLogin(r, user.ID)
+	s.auditUser(r, user.ID, auditLogin, audit.Success, nil)
+
+	// Generate token
+	token, err := utils.GenerateToken(user.ID, user.Email, user.Username, user.Role)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to generate token")
+		return
+	}
+
+	// Clear password before sending response
+	user.Password = ""
+
+	utils.JSONResponse(w, http.StatusOK, types.AuthResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		User:      user,
+	})
+}
+
+// rehashPassword upgrades a stored hash to the current algorithm and cost
+func (s *AuthService) rehashPassword(userID int, password string) {
+	hashedPassword, algo, err := s.hasher.Hash(password)
+	if err != nil {
+		slog.Error("Failed to rehash password", "user_id", userID, "error", err)
+		return
+	}
+	_, err = s.db.Exec("UPDATE users SET password = $1, password_algo = $2, updated_at = $3 WHERE id = $4",
+		hashedPassword, algo, time.Now(), userID)
+	if err != nil {
+		slog.Error("Failed to store rehashed password", "user_id", userID, "error", err)
+	}
+}
+
+// validateToken is the legacy validation contract used by the gateway. New
+// integrations should use the RFC 7662 /introspect endpoint instead.
+func (s *AuthService) validateToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	claims, err := s.checkToken(req.Token)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidToken, "Invalid token")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"valid":  true,
+		"claims": claims,
+	})
+}
@@ -0,0 +1,51 @@
+module github.com/voice-cloning/all
+
+go 1.21
+
+replace (
+	github.com/voice-cloning/auth-service => ../../auth-service
+	github.com/voice-cloning/gateway => ../../gateway
+	github.com/voice-cloning/shared => ../../shared
+	github.com/voice-cloning/storage-service => ../../storage-service
+	github.com/voice-cloning/user-service => ../../user-service
+	github.com/voice-cloning/voice-service => ../../voice-service
+)
+
+require (
+	github.com/lib/pq v1.10.9
+	github.com/voice-cloning/auth-service v0.0.0-00010101000000-000000000000
+	github.com/voice-cloning/gateway v0.0.0-00010101000000-000000000000
+	github.com/voice-cloning/shared v0.0.0-00010101000000-000000000000
+	github.com/voice-cloning/storage-service v0.0.0-00010101000000-000000000000
+	github.com/voice-cloning/user-service v0.0.0-00010101000000-000000000000
+	github.com/voice-cloning/voice-service v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
+	github.com/go-playground/locales v0.14.1 // indirect
+	github.com/go-playground/universal-translator v0.18.1 // indirect
+	github.com/go-playground/validator/v10 v10.22.1 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/jmoiron/sqlx v1.3.5 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
+	github.com/leodido/go-urn v1.4.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.33 // indirect
+	github.com/nats-io/nats.go v1.37.0 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/redis/go-redis/v9 v9.5.1 // indirect
+	github.com/segmentio/kafka-go v0.4.47 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/grpc v1.64.1 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,152 @@
+// Command all runs the gateway and the auth, voice, user and storage
+// services in one process, for small deployments and local development.
+// The gateway is served on PORT and calls the services in memory instead
+// of over HTTP between processes, and the services share one database
+// pool. The voice-worker, notification-service and admin-service still run
+// apart; they reach the composed services on the ports in Config.
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	_ "github.com/lib/pq"
+
+	"github.com/voice-cloning/auth-service/auth"
+	"github.com/voice-cloning/gateway/gateway"
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/inproc"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/schema"
+	"github.com/voice-cloning/storage-service/storage"
+	"github.com/voice-cloning/user-service/user"
+	"github.com/voice-cloning/voice-service/voice"
+)
+
+// composed are the services run in this process
+var composed = []string{"auth-service", "user-service", "storage-service", "voice-service"}
+
+func main() {
+	// The services find each other at the URLs of their listeners, set
+	// before any configuration is loaded
+	inproc.Install()
+	listeners := map[string]*inproc.Listener{}
+	for _, name := range composed {
+		ln, url := inproc.Listen(name)
+		listeners[name] = ln
+		os.Setenv(strings.ToUpper(strings.ReplaceAll(name, "-", "_"))+"_URL", url)
+	}
+
+	var cfg Config
+	config.MustLoad("voice-cloning", &cfg)
+	var gatewayCfg gateway.Config
+	config.MustLoad("gateway", &gatewayCfg)
+	var authCfg auth.Config
+	config.MustLoad("auth-service", &authCfg)
+	var userCfg user.Config
+	config.MustLoad("user-service", &userCfg)
+	var storageCfg storage.Config
+	config.MustLoad("storage-service", &storageCfg)
+	var voiceCfg voice.Config
+	config.MustLoad("voice-service", &voiceCfg)
+
+	if err := logging.Setup("voice-cloning", gatewayCfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+	config.LogSummary(&cfg)
+
+	tlsSource, err := mtls.Setup(cfg.TLS)
+	if err != nil {
+		log.Fatal("Invalid mTLS configuration: ", err)
+	}
+
+	// One pool serves every composed service
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if cfg.MigrateOnStart {
+		if _, err := schema.Up(context.Background(), db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	// Each composed service, and the port it is also served on, if any
+	services := []struct {
+		name  string
+		port  string
+		build func() (http.Handler, func())
+	}{
+		{"auth-service", cfg.AuthPort, func() (http.Handler, func()) { return auth.New(authCfg, db) }},
+		{"user-service", cfg.UserPort, func() (http.Handler, func()) { return user.New(userCfg, db) }},
+		{"storage-service", cfg.StoragePort, func() (http.Handler, func()) { return storage.New(storageCfg, db) }},
+		{"voice-service", cfg.VoicePort, func() (http.Handler, func()) { return voice.New(voiceCfg, db) }},
+	}
+
+	var servers []*http.Server
+	for _, s := range services {
+		handler, closeService := s.build()
+		defer closeService()
+
+		server := &http.Server{Handler: handler}
+		servers = append(servers, server)
+		go serve(s.name, server, listeners[s.name])
+
+		if s.port != "" {
+			server := &http.Server{Addr: ":" + s.port, Handler: handler}
+			servers = append(servers, server)
+			slog.Info("Serving composed service on the network", "service", s.name, "port", s.port)
+			go func(name string) {
+				if err := tlsSource.ListenAndServe(server); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("Failed to serve %s: %v", name, err)
+				}
+			}(s.name)
+		}
+	}
+
+	server := &http.Server{Addr: ":" + gatewayCfg.Port, Handler: gateway.New(gatewayCfg)}
+	servers = append(servers, server)
+	go func() {
+		slog.Info("API Gateway starting", "port", gatewayCfg.Port, "services", composed)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop accepting requests and let in-flight ones finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), gatewayCfg.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			server.Shutdown(ctx)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// serve runs a composed service's server on its listener in this process
+func serve(name string, server *http.Server, ln net.Listener) {
+	if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to serve %s: %v", name, err)
+	}
+}
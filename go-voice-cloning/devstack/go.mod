@@ -0,0 +1,15 @@
+module github.com/voice-cloning/devstack
+
+go 1.21
+
+require (
+	github.com/alicebob/miniredis/v2 v2.33.0
+	github.com/fergusstrange/embedded-postgres v1.25.0
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/lib/pq v1.10.4 // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
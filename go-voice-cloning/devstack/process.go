@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// process is a running service
+type process struct {
+	name   string
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// build compiles a service of this tree into the state directory
+func build(ctx context.Context, root, name string) (string, error) {
+	binary := filepath.Join(root, "bin", name)
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", binary, ".")
+	cmd.Dir = filepath.Join("..", name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build %s: %w\n%s", name, err, out)
+	}
+	return binary, nil
+}
+
+// start runs a service with its output prefixed by its name, sending the
+// name on exited if it stops on its own
+func start(name, binary string, env []string, exited chan<- string) (*process, error) {
+	cmd := exec.Command(binary)
+	cmd.Env = env
+	out := newPrefixWriter(name)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	p := &process{name: name, cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.exited)
+		exited <- name
+	}()
+	return p, nil
+}
+
+// waitHealthy waits for the service's health check to pass, as it
+// migrates the database and connects to its dependencies
+func (p *process) waitHealthy(ctx context.Context, url string) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.After(2 * time.Minute)
+	for {
+		resp, err := client.Get(url + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-p.exited:
+			return fmt.Errorf("%s exited during startup", p.name)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("%s didn't become healthy at %s/health", p.name, url)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// stop interrupts the service, killing it if it doesn't exit in time
+func (p *process) stop() {
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.exited:
+	case <-time.After(15 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// output serializes the lines of the services and Postgres on stdout
+var output sync.Mutex
+
+// prefixWriter writes whole lines to stdout, each prefixed with a name
+type prefixWriter struct {
+	prefix  []byte
+	pending []byte
+}
+
+func newPrefixWriter(name string) io.Writer {
+	return &prefixWriter{prefix: []byte(fmt.Sprintf("%-20s | ", name))}
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	w.pending = append(w.pending, b...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		output.Lock()
+		os.Stdout.Write(w.prefix)
+		os.Stdout.Write(w.pending[:i+1])
+		output.Unlock()
+		w.pending = w.pending[i+1:]
+	}
+}
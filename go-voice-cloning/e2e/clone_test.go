@@ -0,0 +1,157 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+)
+
+// TestCloneFlow follows a user through the platform: registering, uploading
+// a sample, cloning a voice from it with the mock engine, downloading the
+// result and speaking with the clone
+func TestCloneFlow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+	client := newUser(ctx, t, "flow")
+
+	sample := toneWAV(12)
+	upload, err := client.Upload(ctx, "sample.wav", "", bytes.NewReader(sample))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if upload.Size != int64(len(sample)) {
+		t.Fatalf("Upload stored %d bytes, sent %d", upload.Size, len(sample))
+	}
+
+	job, err := client.CreateClone(ctx, sdk.CreateCloneRequest{Name: "e2e voice", SourceFile: upload.ID})
+	if err != nil {
+		t.Fatalf("CreateClone: %v", err)
+	}
+	var seen []string
+	status, err := client.WaitForCloneCompletion(ctx, job.ID, &sdk.WaitOptions{
+		PollInterval: 250 * time.Millisecond,
+		Progress:     func(s sdk.CloneStatus) { seen = append(seen, fmt.Sprintf("%s %d%%", s.Status, s.Progress)) },
+	})
+	if err != nil {
+		t.Fatalf("clone %d didn't complete: %v (seen %v)", job.ID, err, seen)
+	}
+	if status.Progress != 100 {
+		t.Errorf("completed clone at %d%%", status.Progress)
+	}
+
+	clone, err := client.GetClone(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("GetClone: %v", err)
+	}
+	if clone.Status != sdk.StatusCompleted || clone.OutputFile == "" {
+		t.Fatalf("clone %d is %s with output %q", clone.ID, clone.Status, clone.OutputFile)
+	}
+	var output bytes.Buffer
+	if _, err := client.Download(ctx, clone.OutputFile, &output); err != nil {
+		t.Fatalf("Download of the clone's output: %v", err)
+	}
+	if output.Len() == 0 {
+		t.Error("clone output is empty")
+	}
+
+	synthesis, err := client.Synthesize(ctx, clone.ID, sdk.SynthesisRequest{Text: "Hello from the end-to-end tests."})
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	synthesis, err = client.WaitForSynthesis(ctx, clone.ID, synthesis.ID, &sdk.WaitOptions{PollInterval: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("synthesis %d didn't complete: %v", synthesis.ID, err)
+	}
+	var speech bytes.Buffer
+	if _, err := client.StreamSynthesis(ctx, clone.ID, synthesis.ID, &speech); err != nil {
+		t.Fatalf("StreamSynthesis: %v", err)
+	}
+	if !bytes.HasPrefix(speech.Bytes(), []byte("RIFF")) {
+		t.Errorf("synthesis output isn't a WAV file (%d bytes)", speech.Len())
+	}
+}
+
+// TestOwnership checks that the services keep one user's clones and files
+// from another
+func TestOwnership(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	owner := newUser(ctx, t, "owner")
+	other := newUser(ctx, t, "other")
+
+	upload, err := owner.Upload(ctx, "sample.wav", "", bytes.NewReader(toneWAV(12)))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	job, err := owner.CreateClone(ctx, sdk.CreateCloneRequest{Name: "private voice", SourceFile: upload.ID})
+	if err != nil {
+		t.Fatalf("CreateClone: %v", err)
+	}
+
+	_, err = other.GetClone(ctx, job.ID)
+	expectStatus(t, "GetClone of another user's clone", err, http.StatusNotFound)
+	_, err = other.Download(ctx, upload.ID, &bytes.Buffer{})
+	expectStatus(t, "Download of another user's file", err, http.StatusNotFound)
+	_, err = other.CreateClone(ctx, sdk.CreateCloneRequest{Name: "stolen voice", SourceFile: upload.ID})
+	expectStatus(t, "CreateClone from another user's file", err, http.StatusUnprocessableEntity)
+
+	anonymous := sdk.NewClient(gatewayURL)
+	_, err = anonymous.GetClone(ctx, job.ID)
+	expectStatus(t, "GetClone without a token", err, http.StatusUnauthorized)
+}
+
+// newUser registers a user with a unique name, which must be at most five
+// characters for the username to fit, and returns its client
+func newUser(ctx context.Context, t *testing.T, name string) *sdk.Client {
+	t.Helper()
+	client := sdk.NewClient(gatewayURL)
+	suffix := time.Now().UnixNano() % 1e9
+	email := fmt.Sprintf("e2e-%s-%d@example.com", name, suffix)
+	if _, err := client.Register(ctx, email, fmt.Sprintf("e2e_%s_%d", name, suffix), "e2e-password-1"); err != nil {
+		t.Fatalf("Register %s: %v", email, err)
+	}
+	return client
+}
+
+func expectStatus(t *testing.T, what string, err error, status int) {
+	t.Helper()
+	var apiErr *sdk.APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("%s: got %v, want a %d", what, err, status)
+		return
+	}
+	if apiErr.StatusCode != status {
+		t.Errorf("%s: got %d %s (%s), want a %d", what, apiErr.StatusCode, apiErr.Code, apiErr.Message, status)
+	}
+}
+
+// toneWAV returns a mono 16-bit PCM WAV of a 220 Hz tone lasting seconds
+func toneWAV(seconds int) []byte {
+	const rate = 16000
+	samples := seconds * rate
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+samples*2))
+	buf.WriteString("WAVEfmt ")
+	// PCM format chunk: size, format, channels, sample rate, byte rate, block align, bits
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(samples*2))
+	for i := 0; i < samples; i++ {
+		binary.Write(&buf, binary.LittleEndian, int16(8000*math.Sin(2*math.Pi*220*float64(i)/rate)))
+	}
+	return buf.Bytes()
+}
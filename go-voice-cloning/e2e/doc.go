@@ -0,0 +1,10 @@
+// Package e2e holds the end-to-end tests, built with the e2e tag. They
+// start Postgres and Redis in containers (testcontainers-go, so Docker must
+// be running), build and run the services against them with the mock
+// engine, and drive the platform through the gateway with the SDK:
+//
+//	cd e2e && go test -tags=e2e -v ./...
+//
+// Service logs are written to a temporary directory, kept when a test
+// fails.
+package e2e
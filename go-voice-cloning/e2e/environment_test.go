@@ -0,0 +1,255 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// gatewayURL is the gateway of the environment the tests run against, and
+// databaseURL its database, for the tests that set up rows the API doesn't
+// expose
+var gatewayURL, databaseURL string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	env, err := startEnvironment(ctx)
+	cancel()
+	if env != nil {
+		defer env.close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "e2e: failed to start the environment:", err)
+		if env != nil {
+			env.keep = true
+			fmt.Fprintln(os.Stderr, "e2e: service logs are in", env.dir)
+		}
+		return 1
+	}
+	gatewayURL = env.urls["gateway"]
+	databaseURL = env.databaseURL
+
+	code := m.Run()
+	if code != 0 {
+		env.keep = true
+		fmt.Fprintln(os.Stderr, "e2e: service logs are in", env.dir)
+	}
+	return code
+}
+
+// services are started in order, each with its dependencies before it
+var services = []string{"auth-service", "user-service", "storage-service", "voice-service", "voice-worker", "gateway"}
+
+// environment is the platform under test: its containers, and the services
+// running as processes built from this tree
+type environment struct {
+	dir         string
+	keep        bool
+	containers  []testcontainers.Container
+	processes   []*process
+	urls        map[string]string
+	databaseURL string
+}
+
+// process is a running service, whose output goes to its log
+type process struct {
+	name   string
+	cmd    *exec.Cmd
+	log    *os.File
+	exited chan struct{}
+}
+
+func startEnvironment(ctx context.Context) (*environment, error) {
+	dir, err := os.MkdirTemp("", "voice-cloning-e2e-")
+	if err != nil {
+		return nil, err
+	}
+	env := &environment{dir: dir, urls: map[string]string{}}
+
+	pg, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage("postgres:15-alpine"),
+		postgres.WithDatabase("voice_cloning"),
+		postgres.WithUsername("postgres"),
+		postgres.WithPassword("postgres"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		return env, fmt.Errorf("postgres: %w", err)
+	}
+	env.containers = append(env.containers, pg)
+	databaseURL, err := pg.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return env, fmt.Errorf("postgres: %w", err)
+	}
+	env.databaseURL = databaseURL
+
+	rd, err := redis.RunContainer(ctx, testcontainers.WithImage("redis:7-alpine"))
+	if err != nil {
+		return env, fmt.Errorf("redis: %w", err)
+	}
+	env.containers = append(env.containers, rd)
+	redisURL, err := rd.ConnectionString(ctx)
+	if err != nil {
+		return env, fmt.Errorf("redis: %w", err)
+	}
+
+	ports := map[string]string{}
+	for _, name := range services {
+		if ports[name], err = freePort(); err != nil {
+			return env, err
+		}
+		env.urls[name] = "http://127.0.0.1:" + ports[name]
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	common := []string{
+		"DATABASE_URL=" + databaseURL,
+		"REDIS_URL=" + redisURL + "/0",
+		"JWT_SECRET=" + hex.EncodeToString(secret),
+		"LOG_LEVEL=debug",
+		"AUTH_SERVICE_URL=" + env.urls["auth-service"],
+		"USER_SERVICE_URL=" + env.urls["user-service"],
+		"STORAGE_SERVICE_URL=" + env.urls["storage-service"],
+		"VOICE_SERVICE_URL=" + env.urls["voice-service"],
+	}
+	extra := map[string][]string{
+		"storage-service": {
+			"STORAGE_BACKEND=local",
+			"STORAGE_PATH=" + filepath.Join(dir, "storage"),
+			"UPLOAD_SESSION_DIR=" + filepath.Join(dir, "uploads"),
+		},
+		"voice-worker": {"ENGINE=mock", "ENGINE_MOCK_TRAINING_DURATION=2s"},
+		// The services not started are left unreachable
+		"gateway": {"NOTIFICATION_SERVICE_URL=http://127.0.0.1:1", "ADMIN_SERVICE_URL=http://127.0.0.1:1"},
+	}
+
+	for _, name := range services {
+		binary, err := build(ctx, dir, name)
+		if err != nil {
+			return env, err
+		}
+		vars := append(append(append(os.Environ(), common...), extra[name]...), "PORT="+ports[name])
+		p, err := start(dir, name, binary, vars)
+		if err != nil {
+			return env, err
+		}
+		env.processes = append(env.processes, p)
+		if err := p.waitHealthy(ctx, env.urls[name]); err != nil {
+			return env, err
+		}
+	}
+	return env, nil
+}
+
+// build compiles a service of this tree into dir
+func build(ctx context.Context, dir, name string) (string, error) {
+	binary := filepath.Join(dir, "bin", name)
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", binary, ".")
+	cmd.Dir = filepath.Join("..", name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("building %s: %w\n%s", name, err, out)
+	}
+	return binary, nil
+}
+
+func start(dir, name, binary string, env []string) (*process, error) {
+	log, err := os.Create(filepath.Join(dir, name+".log"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(binary)
+	cmd.Env = env
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		return nil, fmt.Errorf("starting %s: %w", name, err)
+	}
+	p := &process{name: name, cmd: cmd, log: log, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// waitHealthy waits for the service's health check to pass, as it
+// migrates the database and connects to its dependencies
+func (p *process) waitHealthy(ctx context.Context, url string) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	for {
+		resp, err := client.Get(url + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-p.exited:
+			return fmt.Errorf("%s exited during startup, see %s", p.name, p.log.Name())
+		case <-ctx.Done():
+			return fmt.Errorf("%s not healthy: %w", p.name, ctx.Err())
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// stop interrupts the service, killing it if it doesn't exit in time
+func (p *process) stop() {
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.exited:
+	case <-time.After(10 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	p.log.Close()
+}
+
+func (env *environment) close() {
+	for i := len(env.processes) - 1; i >= 0; i-- {
+		env.processes[i].stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, c := range env.containers {
+		if err := c.Terminate(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "e2e: failed to remove a container:", err)
+		}
+	}
+	if !env.keep {
+		os.RemoveAll(env.dir)
+	}
+}
+
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
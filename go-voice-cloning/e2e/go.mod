@@ -0,0 +1,13 @@
+module github.com/voice-cloning/e2e
+
+go 1.21
+
+replace github.com/voice-cloning/sdk => ../sdk
+
+require (
+	github.com/lib/pq v1.10.9
+	github.com/testcontainers/testcontainers-go v0.31.0
+	github.com/testcontainers/testcontainers-go/modules/postgres v0.31.0
+	github.com/testcontainers/testcontainers-go/modules/redis v0.31.0
+	github.com/voice-cloning/sdk v0.0.0-00010101000000-000000000000
+)
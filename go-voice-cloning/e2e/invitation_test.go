@@ -0,0 +1,80 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// TestInvitationAcceptedOnce checks that an invitation signs its user in
+// once: accepting the same token again is refused rather than resetting the
+// password
+func TestInvitationAcceptedOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	token := newInvitation(ctx, t, "inv")
+
+	if status := acceptInvitation(ctx, t, token, "first-password-1"); status != http.StatusOK {
+		t.Fatalf("first accept: got %d, want %d", status, http.StatusOK)
+	}
+	if status := acceptInvitation(ctx, t, token, "second-password-2"); status != http.StatusNotFound {
+		t.Errorf("second accept: got %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+// newInvitation creates an invited user with a pending invitation and
+// returns its token. The name must be at most five characters, as for
+// newUser. Imports only email the token, so the rows are written
+// to the database directly.
+func newInvitation(ctx context.Context, t *testing.T, name string) string {
+	t.Helper()
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	defer db.Close()
+
+	suffix := time.Now().UnixNano() % 1e9
+	token := fmt.Sprintf("e2e-%s-%d", name, suffix)
+	var userID int
+	err = db.QueryRowContext(ctx,
+		"INSERT INTO users (email, username, password, role, created_at, updated_at) VALUES ($1, $2, '!', 'user', NOW(), NOW()) RETURNING id",
+		fmt.Sprintf("e2e-%s-%d@example.com", name, suffix), fmt.Sprintf("e2e_%s_%d", name, suffix)).Scan(&userID)
+	if err != nil {
+		t.Fatalf("creating the invited user: %v", err)
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO user_invitations (user_id, token, created_at, expires_at) VALUES ($1, $2, NOW(), NOW() + INTERVAL '1 hour')",
+		userID, token)
+	if err != nil {
+		t.Fatalf("creating the invitation: %v", err)
+	}
+	return token
+}
+
+// acceptInvitation accepts an invitation through the gateway and returns
+// the response status
+func acceptInvitation(ctx context.Context, t *testing.T, token, password string) int {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"token": token, "password": password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gatewayURL+"/api/auth/invitations/accept", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("building the request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("accepting the invitation: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
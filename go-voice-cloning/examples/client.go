@@ -122,7 +122,7 @@ func register(email, username, password string) (*RegisterResponse, error) {
 	defer resp.Body.Close()
 
 	var result RegisterResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeData(resp, &result); err != nil {
 		return nil, err
 	}
 
@@ -148,7 +148,7 @@ func createVoiceClone(token, name, sourceFile string) (*CloneResponse, error) {
 	defer resp.Body.Close()
 
 	var result CloneResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeData(resp, &result); err != nil {
 		return nil, err
 	}
 
@@ -169,7 +169,7 @@ func getCloneStatus(token string, cloneID int) (string, error) {
 	var result struct {
 		Status string `json:"status"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := decodeData(resp, &result); err != nil {
 		return "", err
 	}
 
@@ -188,7 +188,7 @@ func listClones(token string) ([]Clone, error) {
 	defer resp.Body.Close()
 
 	var clones []Clone
-	if err := json.NewDecoder(resp.Body).Decode(&clones); err != nil {
+	if err := decodeData(resp, &clones); err != nil {
 		return nil, err
 	}
 
@@ -207,7 +207,7 @@ func getUserProfile(token string) (*Profile, error) {
 	defer resp.Body.Close()
 
 	var profile Profile
-	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+	if err := decodeData(resp, &profile); err != nil {
 		return nil, err
 	}
 
@@ -226,13 +226,21 @@ func getUserStats(token string) (*Stats, error) {
 	defer resp.Body.Close()
 
 	var stats Stats
-	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+	if err := decodeData(resp, &stats); err != nil {
 		return nil, err
 	}
 
 	return &stats, nil
 }
 
+// decodeData reads the data member of the response envelope into v
+func decodeData(resp *http.Response, v interface{}) error {
+	envelope := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	return json.NewDecoder(resp.Body).Decode(&envelope)
+}
+
 func uploadFile(token, filePath string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
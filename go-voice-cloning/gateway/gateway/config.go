@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/voice-cloning/shared/cache"
+	"github.com/voice-cloning/shared/mtls"
+)
+
+// Config is the gateway's configuration, from the environment or the
+// file named by CONFIG_FILE
+type Config struct {
+	Port              string `env:"PORT" default:"8080"`
+	LogLevel          string `env:"LOG_LEVEL" default:"info"`
+	AuthServiceURL    string `env:"AUTH_SERVICE_URL" default:"http://localhost:8081"`
+	VoiceServiceURL   string `env:"VOICE_SERVICE_URL" default:"http://localhost:8082"`
+	StorageServiceURL string `env:"STORAGE_SERVICE_URL" default:"http://localhost:8083"`
+	UserServiceURL    string `env:"USER_SERVICE_URL" default:"http://localhost:8084"`
+	// NotificationServiceURL serves users' notification webhooks and
+	// delivery history
+	NotificationServiceURL string `env:"NOTIFICATION_SERVICE_URL" default:"http://localhost:8086"`
+	// AdminServiceURL serves the admin operations under /api/admin
+	AdminServiceURL string `env:"ADMIN_SERVICE_URL" default:"http://localhost:8087"`
+	// AnalyticsServiceURL takes clients' product events and serves the
+	// stats aggregated from them under /api/analytics
+	AnalyticsServiceURL string `env:"ANALYTICS_SERVICE_URL" default:"http://localhost:8088"`
+	TraceBufferSize     int    `env:"TRACE_BUFFER_SIZE" default:"10000" min:"1"`
+	// InternalTimeout bounds each attempt of the gateway's own calls to the
+	// services, such as token validation
+	InternalTimeout time.Duration `env:"INTERNAL_TIMEOUT_MS" default:"5000" unit:"ms" min:"1"`
+	// TLS is the certificate the gateway presents to services requiring
+	// mTLS, whose URLs are then https://. MTLS_REQUIRE doesn't apply to the
+	// gateway, which serves the public.
+	TLS mtls.Config
+
+	// Cache holds validated tokens and users' entitlements. Tokens are
+	// kept for TokenCacheTTL; 0 validates every request at the auth
+	// service.
+	Cache         cache.Config
+	TokenCacheTTL time.Duration `env:"TOKEN_CACHE_SECONDS" default:"30" min:"0"`
+
+	// MaintenanceMode sheds every API request with a Retry-After of
+	// MaintenanceRetryAfter
+	MaintenanceMode       bool          `env:"MAINTENANCE_MODE" default:"false"`
+	MaintenanceRetryAfter time.Duration `env:"MAINTENANCE_RETRY_AFTER_SECONDS" default:"300" min:"1"`
+
+	// LegacyResponses lets clients ask for the response shapes used before
+	// the envelope with X-Response-Format: legacy, until
+	// LegacyResponsesSunset (a 2006-01-02 date) when set
+	LegacyResponses       bool   `env:"LEGACY_RESPONSES" default:"true"`
+	LegacyResponsesSunset string `env:"LEGACY_RESPONSES_SUNSET"`
+
+	// GRPCSegmentTimeout bounds the synthesis of each segment of a gRPC
+	// synthesis stream once its job is queued, until the end of its audio.
+	// GRPCMaxPendingSegments bounds the segments of a stream queued ahead of
+	// the one playing.
+	GRPCSegmentTimeout     time.Duration `env:"GRPC_SEGMENT_TIMEOUT_SECONDS" default:"120" min:"1"`
+	GRPCMaxPendingSegments int           `env:"GRPC_MAX_PENDING_SEGMENTS" default:"4" min:"1"`
+
+	// ShutdownTimeout bounds the draining of connections on exit
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT_SECONDS" default:"30" min:"1"`
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/dbroute"
+)
+
+// readOnlyRoutes are served from read replicas when fetched with GET. They
+// list or aggregate data, so a few seconds of replication lag is harmless;
+// reads that must see a write just made (clone status, events) stay on the
+// primary.
+var readOnlyRoutes = map[string]bool{
+	"/api/voice/clones":                 true,
+	"/api/voice/clones/{id}/artifacts":  true,
+	"/api/voice/clones/{id}/deliveries": true,
+	"/api/voice/clones/{id}/syntheses":  true,
+	"/api/voice/clones/{id}/models":     true,
+	"/api/voice/clones/{id}/shares":     true,
+	"/api/voice/library":                true,
+	"/api/voice/library/shared":         true,
+	"/api/voice/quota":                  true,
+	"/api/voice/clones/analytics":       true,
+	"/api/storage/usage":                true,
+	"/api/storage/access-log":           true,
+	"/api/user/stats":                   true,
+	"/api/search":                       true,
+	"/api/user/calendar":                true,
+	"/api/user/orgs/{org}/activity":     true,
+	"/api/gallery":                      true,
+	"/api/gallery/categories":           true,
+}
+
+// dbIntentMiddleware tags requests for downstream database routing,
+// replacing any hint sent by the client
+func dbIntentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		intent := dbroute.IntentWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil && readOnlyRoutes[tmpl] {
+					intent = dbroute.IntentRead
+				}
+			}
+		}
+		r.Header.Set(dbroute.IntentHeader, intent)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// applyEntitlements passes the caller's plan and the highest priority tier
+// it allows on to the services, and refuses an upload larger than the
+// plan's whole sample storage before its body is forwarded. The services
+// enforce the rest of the plan themselves, and all of it when the user
+// service can't be reached: the headers are then left out. It writes the
+// error response and reports false when the request can't go ahead.
+func (g *Gateway) applyEntitlements(w http.ResponseWriter, r *http.Request, userID int) bool {
+	r.Header.Del(types.PlanHeader)
+	r.Header.Del(types.PriorityTierHeader)
+	e, err := g.entitlements.Get(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Failed to fetch entitlements", "error", err)
+		return true
+	}
+	r.Header.Set(types.PlanHeader, e.Plan)
+	r.Header.Set(types.PriorityTierHeader, e.MaxPriority)
+
+	if r.Method == http.MethodPost && r.URL.Path == "/api/storage/upload" &&
+		e.SampleStorageBytes > 0 && r.ContentLength > e.SampleStorageBytes {
+		body := utils.ErrorBody(w, apierror.QuotaExceeded,
+			fmt.Sprintf("The %s plan includes %d bytes of sample storage", e.Plan, e.SampleStorageBytes))
+		body["quota"] = types.QuotaStorage
+		body["plan"] = e.Plan
+		body["limit_bytes"] = e.SampleStorageBytes
+		body["required_bytes"] = r.ContentLength
+		utils.JSONResponse(w, http.StatusRequestEntityTooLarge, body)
+		return false
+	}
+	return true
+}
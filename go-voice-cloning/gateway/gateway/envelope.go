@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/utils"
+)
+
+// legacyFormatHeader asks for responses in the shapes they had before the
+// response envelope, while the gateway still offers them
+const legacyFormatHeader = "X-Response-Format"
+
+// maxEnvelopeBody bounds the JSON bodies the gateway reads to normalize;
+// larger ones are passed on as the service sent them
+const maxEnvelopeBody = 8 << 20
+
+// envelopeMiddleware puts every JSON response in the response envelope,
+// converting the bodies of services that still answer in the shapes used
+// before it. During the deprecation window, while legacy is on and until
+// sunset (when set), clients sending X-Response-Format: legacy get those
+// shapes back instead, marked with Deprecation and Sunset headers.
+func envelopeMiddleware(legacy bool, sunset time.Time, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if legacy {
+			w.Header().Add("Vary", legacyFormatHeader)
+		}
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &envelopeRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		wantsLegacy := legacy && strings.EqualFold(r.Header.Get(legacyFormatHeader), "legacy") &&
+			(sunset.IsZero() || time.Now().Before(sunset))
+		if wantsLegacy {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		rec.finish(wantsLegacy)
+	})
+}
+
+// envelopeRecorder holds back JSON bodies until they are complete, to
+// send them in the envelope. Other bodies, such as audio and event
+// streams, go straight through.
+type envelopeRecorder struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (rec *envelopeRecorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") && status != http.StatusNoContent {
+		rec.buffering = true
+		return
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *envelopeRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.buffering {
+		return rec.ResponseWriter.Write(b)
+	}
+	if rec.body.Len()+len(b) > maxEnvelopeBody {
+		// Too large to hold; pass it on untouched
+		rec.buffering = false
+		rec.ResponseWriter.WriteHeader(rec.status)
+		if _, err := rec.ResponseWriter.Write(rec.body.Bytes()); err != nil {
+			return 0, err
+		}
+		rec.body.Reset()
+		return rec.ResponseWriter.Write(b)
+	}
+	return rec.body.Write(b)
+}
+
+// finish sends the body held back, in the envelope or its legacy shape. A
+// body that isn't JSON after all is sent as it is.
+func (rec *envelopeRecorder) finish(legacy bool) {
+	if !rec.buffering {
+		return
+	}
+	data := rec.body.Bytes()
+	if envelope, err := utils.ReadEnvelope(rec.status, data); err == nil {
+		var body []byte
+		if legacy {
+			body, err = envelope.Legacy()
+		} else {
+			body, err = json.Marshal(envelope)
+		}
+		if err == nil {
+			data = append(body, '\n')
+		}
+	}
+	rec.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rec.ResponseWriter.WriteHeader(rec.status)
+	rec.ResponseWriter.Write(data)
+}
+
+// Flush passes flushes on for bodies that aren't held back
+func (rec *envelopeRecorder) Flush() {
+	if rec.buffering {
+		return
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets proxied WebSocket upgrades take over the connection
+func (rec *envelopeRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (rec *envelopeRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,455 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/voice-cloning/gateway/synthesispb"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	// audioChunkSize bounds the audio in each message of a synthesis stream
+	audioChunkSize = 32 << 10
+	// synthesisPoll is how often a segment that can't be streamed is checked
+	// on until its job ends
+	synthesisPoll = time.Second
+	// errorCodeTrailer carries the API error code of a stream that failed,
+	// as the "code" of a REST error response would
+	errorCodeTrailer = "error-code"
+)
+
+// withGRPC serves gRPC calls, HTTP/2 requests with a gRPC content type, with
+// server and every other request with rest. HTTP/2 is accepted in cleartext
+// (h2c) so gRPC clients reach the gateway on its HTTP port.
+func withGRPC(server *grpc.Server, rest http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			server.ServeHTTP(w, r)
+			return
+		}
+		rest.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+// newGRPCServer builds the gateway's gRPC server, serving the Synthesis API.
+// Streams are refused outright in maintenance mode.
+func (g *Gateway) newGRPCServer(cfg Config) *grpc.Server {
+	interceptor := g.grpcAuth
+	if cfg.MaintenanceMode {
+		interceptor = grpcMaintenance
+	}
+	server := grpc.NewServer(grpc.StreamInterceptor(interceptor))
+	synthesispb.RegisterSynthesisServer(server, &synthesisServer{
+		gateway: g,
+		// Redirects point streams whose audio is complete to their
+		// download, which is fetched from the storage service instead
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+		segmentTimeout: cfg.GRPCSegmentTimeout,
+		maxPending:     cfg.GRPCMaxPendingSegments,
+	})
+	return server
+}
+
+func grpcMaintenance(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return status.Error(codes.Unavailable, "Service is down for maintenance")
+}
+
+// grpcCaller is the user who opened a gRPC stream, and what the gateway
+// tells the services about them
+type grpcCaller struct {
+	claims      *utils.Claims
+	requestID   string
+	plan        string
+	maxPriority string
+}
+
+type grpcCallerKey struct{}
+
+// request builds a request to a service on behalf of the caller, with the
+// headers authMiddleware and applyEntitlements would have set
+func (c *grpcCaller) request(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-User-ID", strconv.Itoa(c.claims.UserID))
+	req.Header.Set("X-User-Email", c.claims.Email)
+	req.Header.Set("X-User-Username", c.claims.Username)
+	req.Header.Set("X-User-Role", c.claims.Role)
+	req.Header.Set(utils.RequestIDHeader, c.requestID)
+	if c.plan != "" {
+		req.Header.Set(types.PlanHeader, c.plan)
+		req.Header.Set(types.PriorityTierHeader, c.maxPriority)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// grpcStream is a server stream with the context of its authenticated
+// caller
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcAuth authenticates a stream with the bearer token of its
+// authorization metadata, as authMiddleware does requests. The stream ends
+// when its token logs out. Its request ID is sent back in the
+// x-request-id header metadata.
+func (g *Gateway) grpcAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	caller := &grpcCaller{requestID: utils.NewRequestID()}
+	ctx := logging.With(ss.Context(), "request_id", caller.requestID)
+	ss.SetHeader(metadata.Pairs(utils.RequestIDHeader, caller.requestID))
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "Missing authorization metadata")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return status.Error(codes.Unauthenticated, "Invalid authorization metadata format")
+	}
+
+	claims, err := g.validateToken(ctx, token)
+	var unavailable *authUnavailableError
+	if errors.As(err, &unavailable) {
+		logging.FromContext(ctx).Error("Token validation failed", "error", err)
+		return status.Error(codes.Unavailable, "Authentication temporarily unavailable")
+	}
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "Invalid token")
+	}
+	caller.claims = claims
+	ctx = logging.With(ctx, "user_id", claims.UserID)
+
+	// Without entitlements the services enforce the plan themselves
+	if e, err := g.entitlements.Get(ctx, claims.UserID); err != nil {
+		logging.FromContext(ctx).Warn("Failed to fetch entitlements", "error", err)
+	} else {
+		caller.plan, caller.maxPriority = e.Plan, e.MaxPriority
+	}
+
+	ctx, done := g.streams.Track(ctx, token)
+	defer done()
+	ctx = context.WithValue(ctx, grpcCallerKey{}, caller)
+
+	start := time.Now()
+	err = handler(srv, &grpcStream{ServerStream: ss, ctx: ctx})
+	slog.Info("Stream completed", "request_id", caller.requestID, "method", info.FullMethod,
+		"code", status.Code(err).String(), "duration_ms", time.Since(start).Milliseconds(), "user_id", claims.UserID)
+	return err
+}
+
+// synthesisServer serves the Synthesis API. Each segment is a synthesis job
+// of the voice service, whose audio is relayed as the worker produces it.
+type synthesisServer struct {
+	synthesispb.UnimplementedSynthesisServer
+	gateway *Gateway
+	// client streams audio from the services, bounded by the segment's
+	// context rather than a timeout of its own
+	client *http.Client
+	// segmentTimeout bounds the playing of each segment once its job is
+	// queued, until the end of its audio
+	segmentTimeout time.Duration
+	// maxPending bounds the segments queued as jobs ahead of the one
+	// playing
+	maxPending int
+}
+
+// pendingSegment is a segment whose job was queued, or failed to be, and
+// waits to play
+type pendingSegment struct {
+	id  string
+	job types.SynthesisJob
+	err error
+}
+
+// Synthesize queues each segment's job as soon as it arrives, in order, so
+// the worker renders the segments that follow while one plays. A stream ends at the
+// first segment that can't be synthesized; errors the services answered
+// with keep their API error code in the error-code trailer.
+func (s *synthesisServer) Synthesize(stream synthesispb.Synthesis_SynthesizeServer) error {
+	err := s.synthesize(stream)
+	var e *apierror.Error
+	if errors.As(err, &e) {
+		stream.SetTrailer(metadata.Pairs(errorCodeTrailer, string(e.Code)))
+		return status.Error(grpcCode(e), e.Message)
+	}
+	return err
+}
+
+func (s *synthesisServer) synthesize(stream synthesispb.Synthesis_SynthesizeServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	caller := ctx.Value(grpcCallerKey{}).(*grpcCaller)
+
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if first.CloneId <= 0 {
+		return status.Error(codes.InvalidArgument, "clone_id is required in the first message")
+	}
+	cloneID, modelVersion := first.CloneId, int(first.ModelVersion)
+
+	segments := make(chan pendingSegment, s.maxPending)
+	received := make(chan error, 1)
+	go func() {
+		defer close(segments)
+		req := first
+		for {
+			job, err := s.queueJob(ctx, caller, cloneID,
+				types.SynthesisRequest{Text: req.Text, SSML: req.Ssml, ModelVersion: modelVersion})
+			select {
+			case segments <- pendingSegment{id: req.SegmentId, job: job, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			if req, err = stream.Recv(); err != nil {
+				if err != io.EOF {
+					received <- err
+				}
+				return
+			}
+		}
+	}()
+
+	for segment := range segments {
+		if segment.err != nil {
+			return segment.err
+		}
+		if err := s.play(ctx, stream, caller, segment.id, segment.job); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-received:
+		return err
+	default:
+		return nil
+	}
+}
+
+// queueJob creates a segment's synthesis job, as POST
+// /clones/{id}/synthesize does
+func (s *synthesisServer) queueJob(ctx context.Context, caller *grpcCaller, cloneID int64, synthesis types.SynthesisRequest) (types.SynthesisJob, error) {
+	var job types.SynthesisJob
+	body, _ := json.Marshal(synthesis)
+	req, err := caller.request(ctx, http.MethodPost,
+		fmt.Sprintf("%s/clones/%d/synthesize", s.gateway.voiceServiceURL, cloneID), bytes.NewReader(body))
+	if err != nil {
+		return job, err
+	}
+	resp, err := s.gateway.client.Do(req)
+	if err != nil {
+		return job, upstreamStatus(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return job, utils.DecodeError(resp)
+	}
+	if err := utils.DecodeData(resp, &job); err != nil {
+		return job, upstreamStatus(ctx, err)
+	}
+	return job, nil
+}
+
+// play sends a segment's audio as the worker produces it, then the message
+// ending the segment. Audio complete before it is listened to comes from its
+// download; so does all of it, once the job ends, for users the streaming
+// feature is off for.
+func (s *synthesisServer) play(ctx context.Context, stream synthesispb.Synthesis_SynthesizeServer, caller *grpcCaller, segmentID string, job types.SynthesisJob) error {
+	ctx, cancel := context.WithTimeout(ctx, s.segmentTimeout)
+	defer cancel()
+
+	req, err := caller.request(ctx, http.MethodGet,
+		fmt.Sprintf("%s/clones/%d/syntheses/%d/stream", s.gateway.voiceServiceURL, job.CloneID, job.ID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return upstreamStatus(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = sendAudio(ctx, stream, segmentID, job.ID, resp.Body)
+		if err == nil {
+			// A job failing midway ends its stream early
+			_, err = s.fetchJob(ctx, caller, job)
+		}
+	case http.StatusFound:
+		err = s.download(ctx, stream, caller, segmentID, job.ID, resp.Header.Get("Location"))
+	case http.StatusNotFound:
+		if job, err = s.waitForJob(ctx, caller, job); err == nil {
+			err = s.download(ctx, stream, caller, segmentID, job.ID, job.DownloadURL)
+		}
+	default:
+		err = utils.DecodeError(resp)
+	}
+	if err != nil {
+		return err
+	}
+	return stream.Send(&synthesispb.SynthesizeResponse{SegmentId: segmentID, SynthesisId: int64(job.ID), SegmentEnd: true})
+}
+
+// fetchJob returns a synthesis job's current state, or the error of a job
+// that failed
+func (s *synthesisServer) fetchJob(ctx context.Context, caller *grpcCaller, job types.SynthesisJob) (types.SynthesisJob, error) {
+	req, err := caller.request(ctx, http.MethodGet,
+		fmt.Sprintf("%s/clones/%d/syntheses/%d", s.gateway.voiceServiceURL, job.CloneID, job.ID), nil)
+	if err != nil {
+		return job, err
+	}
+	resp, err := s.gateway.client.Do(req)
+	if err != nil {
+		return job, upstreamStatus(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return job, utils.DecodeError(resp)
+	}
+	if err := utils.DecodeData(resp, &job); err != nil {
+		return job, upstreamStatus(ctx, err)
+	}
+	if job.Status == types.StatusFailed {
+		return job, apierror.New(http.StatusConflict, apierror.SynthesisFailed, "Synthesis job failed: "+job.Error)
+	}
+	return job, nil
+}
+
+// waitForJob polls a synthesis job until it completes
+func (s *synthesisServer) waitForJob(ctx context.Context, caller *grpcCaller, job types.SynthesisJob) (types.SynthesisJob, error) {
+	ticker := time.NewTicker(synthesisPoll)
+	defer ticker.Stop()
+	for {
+		current, err := s.fetchJob(ctx, caller, job)
+		if err != nil || current.Status == types.StatusCompleted {
+			return current, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return current, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// download sends a completed segment's audio from the storage service.
+// location is the gateway path of the download.
+func (s *synthesisServer) download(ctx context.Context, stream synthesispb.Synthesis_SynthesizeServer, caller *grpcCaller, segmentID string, synthesisID int, location string) error {
+	file, ok := strings.CutPrefix(location, "/api/storage/download/")
+	if !ok {
+		logging.FromContext(ctx).Error("Unexpected synthesis download", "synthesis_id", synthesisID, "location", location)
+		return status.Error(codes.Internal, "Synthesis audio unavailable")
+	}
+	req, err := caller.request(ctx, http.MethodGet, s.gateway.storageServiceURL+"/download/"+file, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return upstreamStatus(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return utils.DecodeError(resp)
+	}
+	return sendAudio(ctx, stream, segmentID, synthesisID, resp.Body)
+}
+
+// sendAudio relays audio to a stream as it is read, in chunks of at most
+// audioChunkSize
+func sendAudio(ctx context.Context, stream synthesispb.Synthesis_SynthesizeServer, segmentID string, synthesisID int, audio io.Reader) error {
+	for {
+		chunk := make([]byte, audioChunkSize)
+		n, err := audio.Read(chunk)
+		if n > 0 {
+			if err := stream.Send(&synthesispb.SynthesizeResponse{SegmentId: segmentID, SynthesisId: int64(synthesisID), Audio: chunk[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return upstreamStatus(ctx, err)
+		}
+	}
+}
+
+// upstreamStatus is the status of a call to a service that got no answer:
+// the stream's own end or timeout, or the service being unavailable
+func upstreamStatus(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	logging.FromContext(ctx).Error("Upstream request failed", "error", err)
+	return status.Error(codes.Unavailable, "Service temporarily unavailable")
+}
+
+// grpcCode is the gRPC code closest to an error's. Exceeded quotas are
+// refused with 403 or 429 depending on the quota.
+func grpcCode(e *apierror.Error) codes.Code {
+	if e.Code == apierror.QuotaExceeded {
+		return codes.ResourceExhausted
+	}
+	switch e.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusPaymentRequired, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/httpclient"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// StreamRegistry tracks the long-lived streams (WebSockets and server-sent
+// events) opened with each token, so logout can end them
+type StreamRegistry struct {
+	mu      sync.Mutex
+	nextID  int
+	streams map[string]map[int]context.CancelFunc
+}
+
+func NewStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{streams: map[string]map[int]context.CancelFunc{}}
+}
+
+// tokenKey identifies a token without keeping it in memory
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Track registers a stream opened with token. The returned context is
+// cancelled when the token logs out, which closes the proxied connection;
+// call done once the stream ends.
+func (s *StreamRegistry) Track(ctx context.Context, token string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	key := tokenKey(token)
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	if s.streams[key] == nil {
+		s.streams[key] = map[int]context.CancelFunc{}
+	}
+	s.streams[key][id] = cancel
+	s.mu.Unlock()
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.streams[key], id)
+		if len(s.streams[key]) == 0 {
+			delete(s.streams, key)
+		}
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// Close ends every stream opened with token and returns how many there were
+func (s *StreamRegistry) Close(token string) int {
+	key := tokenKey(token)
+	s.mu.Lock()
+	streams := s.streams[key]
+	delete(s.streams, key)
+	s.mu.Unlock()
+
+	for _, cancel := range streams {
+		cancel()
+	}
+	return len(streams)
+}
+
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// bearerToken returns the token of a request's Authorization header
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// logout signs a session out: the auth service revokes the token, open
+// streams of the session are closed and the browser is told to clear what
+// it cached. The token is dropped from the token cache, so it stops working
+// as soon as it is revoked; with an in-memory cache, other gateway instances
+// accept it until their cached validation expires.
+func (g *Gateway) logout(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Missing authorization header")
+		return
+	}
+
+	if err := revokeTokenWithAuthService(r.Context(), g.client, g.authServiceURL, token); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to revoke token", "error", err)
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to log out, try again")
+		return
+	}
+	if err := g.tokens.Delete(r.Context(), tokenKey(token)); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to drop cached token", "error", err)
+	}
+	closed := g.streams.Close(token)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Clear-Site-Data", `"cache", "cookies", "storage"`)
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":        "Logged out",
+		"streams_closed": closed,
+	})
+}
+
+// revokeTokenWithAuthService revokes a token. Revoking it again is
+// harmless, so the call is retried like an idempotent one.
+func revokeTokenWithAuthService(ctx context.Context, client *http.Client, authServiceURL, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"token": token})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authServiceURL+"/logout", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(httpclient.Idempotent(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// MethodOverrideHeader lets clients behind proxies that only pass GET/POST
+// tunnel other methods through a POST
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+var overridableMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+var routableMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// methodHandling wraps the router with method override, OPTIONS and HEAD
+// support, and makes the router answer 405s with an Allow header
+func methodHandling(router *mux.Router) http.Handler {
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowedMethods(router, r), ", "))
+		utils.ErrorResponse(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if override := strings.ToUpper(r.Header.Get(MethodOverrideHeader)); override != "" {
+			if r.Method != http.MethodPost || !overridableMethods[override] {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidHeader, "Invalid method override")
+				return
+			}
+			r.Method = override
+			r.Header.Del(MethodOverrideHeader)
+		}
+
+		switch r.Method {
+		case http.MethodOptions:
+			allowed := allowedMethods(router, r)
+			if len(allowed) == 0 {
+				utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Not found")
+				return
+			}
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodHead:
+			// Serve HEAD as GET and drop the body, keeping headers intact
+			r.Method = http.MethodGet
+			router.ServeHTTP(headResponseWriter{w}, r)
+		default:
+			router.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allowedMethods lists the methods with a route matching the request path
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routableMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+			if method == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	if len(allowed) > 0 {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return allowed
+}
+
+// headResponseWriter discards the body of a response to a HEAD request
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,592 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/cache"
+	"github.com/voice-cloning/shared/entitlements"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/httpclient"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/signedurl"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+type Gateway struct {
+	authServiceURL         string
+	voiceServiceURL        string
+	storageServiceURL      string
+	userServiceURL         string
+	notificationServiceURL string
+	adminServiceURL        string
+	analyticsServiceURL    string
+	client                 *http.Client
+	entitlements           *entitlements.Client
+	tokens                 cache.Cache
+	tokenTTL               time.Duration
+	traces                 *TraceStore
+	streams                *StreamRegistry
+}
+
+// New builds the gateway routing the API to the services at the URLs in
+// its configuration, and returns its handler. gRPC calls are served by the
+// same handler.
+func New(cfg Config) http.Handler {
+	c, err := cache.New(cfg.Cache)
+	if err != nil {
+		log.Fatal("Invalid cache configuration: ", err)
+	}
+
+	gateway := &Gateway{
+		authServiceURL:         cfg.AuthServiceURL,
+		voiceServiceURL:        cfg.VoiceServiceURL,
+		storageServiceURL:      cfg.StorageServiceURL,
+		userServiceURL:         cfg.UserServiceURL,
+		notificationServiceURL: cfg.NotificationServiceURL,
+		adminServiceURL:        cfg.AdminServiceURL,
+		analyticsServiceURL:    cfg.AnalyticsServiceURL,
+		client:                 httpclient.New(httpclient.Options{Timeout: cfg.InternalTimeout}),
+		entitlements:           entitlements.FromEnv(c),
+		tokens:                 cache.Namespace(c, "tokens"),
+		tokenTTL:               cfg.TokenCacheTTL,
+		traces:                 NewTraceStore(cfg.TraceBufferSize),
+		streams:                NewStreamRegistry(),
+	}
+
+	r := mux.NewRouter()
+
+	// Health checks. The gateway holds no connections of its own, so it is
+	// ready whenever it is alive.
+	checks := health.New("api-gateway")
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+
+	// Public routes (no auth required)
+	r.HandleFunc("/api/auth/register", gateway.proxyToAuth).Methods("POST")
+	r.HandleFunc("/api/auth/login", gateway.proxyToAuth).Methods("POST")
+	r.HandleFunc("/api/auth/invitations/accept", gateway.proxyToAuth).Methods("POST")
+	r.HandleFunc("/api/public/download/{id}", gateway.proxyToPublicDownload).Methods("GET")
+	r.HandleFunc("/api/public/files/{id}", gateway.proxyToPublicFile).Methods("GET")
+	r.HandleFunc("/api/billing/webhook", gateway.proxyToBillingWebhook).Methods("POST")
+	r.HandleFunc("/api/users/{username}/public", gateway.proxyToPublicProfile).Methods("GET")
+
+	// Public gallery, open to anonymous visitors
+	gallery := r.PathPrefix("/api/gallery").Subrouter()
+	gallery.Use(dbIntentMiddleware)
+	gallery.HandleFunc("", gateway.proxyToGallery).Methods("GET")
+	gallery.HandleFunc("/categories", gateway.proxyToGallery).Methods("GET")
+	gallery.HandleFunc("/{id}", gateway.proxyToGallery).Methods("GET")
+	gallery.HandleFunc("/{id}/demo", gateway.proxyToGallery).Methods("POST")
+	gallery.HandleFunc("/{id}/reports", gateway.proxyToGallery).Methods("POST")
+
+	// Protected routes (auth required)
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(gateway.authMiddleware)
+	protected.Use(dbIntentMiddleware)
+	protected.HandleFunc("/voice/clones", gateway.proxyToVoice).Methods("GET", "POST")
+	protected.HandleFunc("/voice/clones/direct", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/analytics", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}", gateway.proxyToVoice).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/voice/clones/{id}/status", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/cancel", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/retry", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/retrain", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/models", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/models/{version}", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/archive", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/unarchive", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/restore", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/artifacts", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/deliveries", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/events", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/manifest", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/synthesize", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/clones/{id}/syntheses", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/syntheses/{synthesis_id}", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/syntheses/{synthesis_id}/stream", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/clones/{id}/publish", gateway.proxyToVoice).Methods("PUT", "DELETE")
+	protected.HandleFunc("/voice/clones/{id}/visibility", gateway.proxyToVoice).Methods("PUT")
+	protected.HandleFunc("/voice/clones/{id}/shares", gateway.proxyToVoice).Methods("GET", "POST")
+	protected.HandleFunc("/voice/clones/{id}/shares/{user_id}", gateway.proxyToVoice).Methods("DELETE")
+	protected.HandleFunc("/voice/library", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/library/shared", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/quota", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/capabilities", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/gallery", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/gallery/reports", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/gallery/{id}/review", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/admin/gallery/{id}/takedown", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/admin/jobs", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/jobs/{job}/runs", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/jobs/{job}/run", gateway.proxyToVoice).Methods("POST")
+	protected.HandleFunc("/voice/admin/user-cleanups", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/user-cleanups/{user_id}", gateway.proxyToVoice).Methods("GET")
+	protected.HandleFunc("/voice/admin/clones/{id}/retention", gateway.proxyToVoice).Methods("PUT")
+	protected.HandleFunc("/voice/callback", gateway.proxyToVoice).Methods("GET", "PUT", "DELETE")
+	protected.HandleFunc("/voice/defaults", gateway.proxyToVoice).Methods("GET", "PUT")
+	protected.HandleFunc("/voice/orgs/{org}/defaults", gateway.proxyToVoice).Methods("GET", "PUT")
+	protected.HandleFunc("/storage/upload", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/uploads", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/uploads/direct", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/uploads/direct/{id}", gateway.proxyToStorage).Methods("DELETE")
+	protected.HandleFunc("/storage/uploads/direct/{id}/complete", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/uploads/{id}", gateway.proxyToStorage).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/storage/uploads/{id}/complete", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/download/{id}", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/files", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/usage", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/policies", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/access-log", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/file-access", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/access-events", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/quotas/{user_id}", gateway.proxyToStorage).Methods("PUT")
+	protected.HandleFunc("/storage/admin/files/{id}/scan", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/admin/lifecycle", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/replication", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/clone-gc", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/jobs", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/jobs/{job}/runs", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/admin/jobs/{job}/run", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/files/archive", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/files/{id}", gateway.proxyToStorage).Methods("PUT", "DELETE")
+	protected.HandleFunc("/storage/files/{id}/versions", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/files/{id}/waveform", gateway.proxyToStorage).Methods("GET")
+	protected.HandleFunc("/storage/files/{id}/versions/{version}/restore", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/files/{id}/presign", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/files/{id}/folder", gateway.proxyToStorage).Methods("PUT")
+	protected.HandleFunc("/storage/files/{id}/acl", gateway.proxyToStorage).Methods("GET", "PUT")
+	protected.HandleFunc("/storage/files/{id}/shares", gateway.proxyToStorage).Methods("POST")
+	protected.HandleFunc("/storage/files/{id}/shares/{user_id}", gateway.proxyToStorage).Methods("DELETE")
+	protected.HandleFunc("/storage/folders", gateway.proxyToStorage).Methods("GET", "POST", "DELETE")
+	protected.HandleFunc("/user/profile", gateway.proxyToUser).Methods("GET", "PUT", "PATCH")
+	protected.HandleFunc("/user/profile/avatar", gateway.proxyToUser).Methods("GET", "POST", "DELETE")
+	protected.HandleFunc("/user/preferences", gateway.proxyToUser).Methods("GET", "PUT", "PATCH")
+	protected.HandleFunc("/user/stats", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/activity", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/calendar", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/plans", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/entitlements", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/usage", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/referrals", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/referrals/conversions", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/users/search", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/plans/{plan}", gateway.proxyToUser).Methods("PUT")
+	protected.HandleFunc("/user/billing/checkout", gateway.proxyToUser).Methods("POST")
+	protected.HandleFunc("/user/billing/portal", gateway.proxyToUser).Methods("POST")
+	protected.HandleFunc("/user/billing/subscription", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/users/import", gateway.proxyToUser).Methods("POST")
+	protected.HandleFunc("/user/admin/users", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/users/{id}", gateway.proxyToUser).Methods("GET", "DELETE")
+	protected.HandleFunc("/user/admin/deletions", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/deletions/{id}", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/jobs", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/jobs/{job}/runs", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/admin/jobs/{job}/run", gateway.proxyToUser).Methods("POST")
+	protected.HandleFunc("/user/orgs/{org}/activity", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/orgs", gateway.proxyToUser).Methods("GET", "POST")
+	protected.HandleFunc("/user/orgs/{org}/members", gateway.proxyToUser).Methods("GET")
+	protected.HandleFunc("/user/orgs/{org}/members/{user_id}", gateway.proxyToUser).Methods("PUT", "DELETE")
+	protected.HandleFunc("/user/orgs/{org}/invitations", gateway.proxyToUser).Methods("GET", "POST")
+	protected.HandleFunc("/user/orgs/{org}/invitations/{id}", gateway.proxyToUser).Methods("DELETE")
+	protected.HandleFunc("/user/org-invitations/accept", gateway.proxyToUser).Methods("POST")
+	protected.HandleFunc("/auth/logout", gateway.logout).Methods("POST")
+	protected.HandleFunc("/auth/admin/email-templates/{kind}", gateway.proxyToAuth).Methods("GET", "PUT", "DELETE")
+	protected.HandleFunc("/auth/admin/email-templates/{kind}/preview", gateway.proxyToAuth).Methods("POST")
+	protected.HandleFunc("/auth/admin/email-branding", gateway.proxyToAuth).Methods("PUT")
+	protected.HandleFunc("/auth/admin/jobs", gateway.proxyToAuth).Methods("GET")
+	protected.HandleFunc("/auth/admin/jobs/{job}/runs", gateway.proxyToAuth).Methods("GET")
+	protected.HandleFunc("/auth/admin/jobs/{job}/run", gateway.proxyToAuth).Methods("POST")
+	protected.HandleFunc("/meta/errors/{request_id}", gateway.getErrorTrace).Methods("GET")
+	protected.HandleFunc("/ws", gateway.proxyToNotifications).Methods("GET")
+	protected.HandleFunc("/notifications/webhooks", gateway.proxyToNotificationService).Methods("GET", "POST")
+	protected.HandleFunc("/notifications/webhooks/{id}", gateway.proxyToNotificationService).Methods("DELETE")
+	protected.HandleFunc("/notifications/deliveries", gateway.proxyToNotificationService).Methods("GET")
+	protected.HandleFunc("/notifications/deliveries/{id}/redeliver", gateway.proxyToNotificationService).Methods("POST")
+	protected.HandleFunc("/analytics/events", gateway.proxyToAnalytics).Methods("POST")
+	protected.HandleFunc("/analytics/stats", gateway.proxyToAnalytics).Methods("GET")
+	protected.HandleFunc("/analytics/admin/overview", gateway.proxyToAnalytics).Methods("GET")
+	protected.HandleFunc("/analytics/admin/series", gateway.proxyToAnalytics).Methods("GET")
+	protected.HandleFunc("/search", gateway.proxyToSearch).Methods("GET")
+
+	// Admin operations, refused here to anyone without the admin role
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.HandleFunc("/queue", gateway.proxyToAdmin).Methods("GET")
+	admin.HandleFunc("/clones/stuck", gateway.proxyToAdmin).Methods("GET")
+	admin.HandleFunc("/clones/requeue", gateway.proxyToAdmin).Methods("POST")
+	admin.HandleFunc("/users/{id}/purge", gateway.proxyToAdmin).Methods("POST")
+	admin.HandleFunc("/audit", gateway.proxyToAdmin).Methods("GET")
+
+	var handler http.Handler = methodHandling(r)
+	if cfg.MaintenanceMode {
+		slog.Warn("Maintenance mode on: shedding all API requests")
+		handler = maintenanceMiddleware(cfg.MaintenanceRetryAfter, handler)
+	}
+	var sunset time.Time
+	if cfg.LegacyResponsesSunset != "" {
+		if sunset, err = time.Parse("2006-01-02", cfg.LegacyResponsesSunset); err != nil {
+			log.Fatal("Invalid LEGACY_RESPONSES_SUNSET: ", err)
+		}
+	}
+	handler = envelopeMiddleware(cfg.LegacyResponses, sunset, handler)
+
+	return withGRPC(gateway.newGRPCServer(cfg), gateway.traceMiddleware(handler))
+}
+
+// Auth middleware validates JWT token and adds user ID to header
+func (g *Gateway) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" && isWebSocketUpgrade(r) {
+			// Browsers cannot set headers on WebSocket handshakes
+			authHeader = websocketToken(r)
+		}
+		if authHeader == "" {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Missing authorization header")
+			return
+		}
+
+		// Extract token
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidToken, "Invalid authorization header format")
+			return
+		}
+
+		token := parts[1]
+
+		// Validate token with auth service
+		claims, err := g.validateToken(r.Context(), token)
+		var unavailable *authUnavailableError
+		if errors.As(err, &unavailable) {
+			logging.FromContext(r.Context()).Error("Token validation failed", "error", err)
+			reason := utils.RetryReasonUpstreamUnavailable
+			if errors.Is(err, httpclient.ErrBreakerOpen) {
+				reason = utils.RetryReasonBreakerOpen
+			}
+			utils.RetryResponse(w, http.StatusServiceUnavailable, "Authentication temporarily unavailable",
+				utils.NewRetryGuidance(reason, upstreamRetryAfter))
+			return
+		}
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.InvalidToken, "Invalid token")
+			return
+		}
+
+		// Add user ID to header for downstream services
+		r.Header.Set("X-User-ID", fmt.Sprintf("%d", claims.UserID))
+		r.Header.Set("X-User-Email", claims.Email)
+		r.Header.Set("X-User-Username", claims.Username)
+		r.Header.Set("X-User-Role", claims.Role)
+		r = r.WithContext(logging.With(r.Context(), "user_id", claims.UserID))
+		if !g.authorizeOrg(w, r, claims.UserID) {
+			return
+		}
+		if !g.applyEntitlements(w, r, claims.UserID) {
+			return
+		}
+
+		// Streams end when their session logs out
+		if isWebSocketUpgrade(r) || isEventStream(r) {
+			ctx, done := g.streams.Track(r.Context(), token)
+			defer done()
+			r = r.WithContext(ctx)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// websocketToken moves an access_token query parameter into a bearer header
+// value so the token is not forwarded to the upstream service
+func websocketToken(r *http.Request) string {
+	query := r.URL.Query()
+	token := query.Get("access_token")
+	if token == "" {
+		return ""
+	}
+	query.Del("access_token")
+	r.URL.RawQuery = query.Encode()
+	return "Bearer " + token
+}
+
+// authUnavailableError is a token the auth service couldn't be asked about,
+// as opposed to one it refused
+type authUnavailableError struct {
+	err error
+}
+
+func (e *authUnavailableError) Error() string {
+	return "auth service unavailable: " + e.err.Error()
+}
+
+func (e *authUnavailableError) Unwrap() error {
+	return e.err
+}
+
+// validateTokenWithAuthService asks the auth service for a token's claims.
+// Validation only reads, so it is retried like an idempotent call.
+func validateTokenWithAuthService(ctx context.Context, client *http.Client, authServiceURL, token string) (*utils.Claims, error) {
+	reqBody := map[string]string{"token": token}
+	jsonData, _ := json.Marshal(reqBody)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authServiceURL+"/validate", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(httpclient.Idempotent(req))
+	if err != nil {
+		return nil, &authUnavailableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &authUnavailableError{err: fmt.Errorf("status %d", resp.StatusCode)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token validation failed")
+	}
+
+	var result struct {
+		Valid  bool         `json:"valid"`
+		Claims utils.Claims `json:"claims"`
+	}
+
+	if err := utils.DecodeData(resp, &result); err != nil {
+		return nil, err
+	}
+
+	if !result.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	// An auth service not yet upgraded may answer with older claims
+	if err := utils.UpgradeClaims(&result.Claims); err != nil {
+		return nil, err
+	}
+	return &result.Claims, nil
+}
+
+// Proxy handlers
+func (g *Gateway) proxyToAuth(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.authServiceURL, func(path string) string {
+		// /api/auth/register -> /register, /api/auth/login -> /login
+		return strings.TrimPrefix(path, "/api/auth")
+	})
+}
+
+func (g *Gateway) proxyToVoice(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.voiceServiceURL, func(path string) string {
+		// /api/voice/clones -> /clones
+		return strings.TrimPrefix(path, "/api/voice")
+	})
+}
+
+func (g *Gateway) proxyToNotifications(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.voiceServiceURL, func(path string) string {
+		// /api/ws -> /ws
+		return strings.TrimPrefix(path, "/api")
+	})
+}
+
+func (g *Gateway) proxyToNotificationService(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.notificationServiceURL, func(path string) string {
+		// /api/notifications/webhooks -> /webhooks
+		return strings.TrimPrefix(path, "/api/notifications")
+	})
+}
+
+func (g *Gateway) proxyToAdmin(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.adminServiceURL, func(path string) string {
+		// /api/admin/queue -> /queue
+		return strings.TrimPrefix(path, "/api/admin")
+	})
+}
+
+// proxyToAnalytics forwards clients' events to the analytics service's
+// /track, which takes them as the caller's, and their stats queries as is
+func (g *Gateway) proxyToAnalytics(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.analyticsServiceURL, func(path string) string {
+		// /api/analytics/events -> /track, /api/analytics/stats -> /stats
+		path = strings.TrimPrefix(path, "/api/analytics")
+		if path == "/events" {
+			return "/track"
+		}
+		return path
+	})
+}
+
+// requireAdmin refuses authenticated callers without the admin role
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !middleware.IsAdmin(r) {
+			utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyToGallery forwards anonymous gallery requests. Identity headers are
+// dropped so visitors cannot pose as a user.
+func (g *Gateway) proxyToGallery(w http.ResponseWriter, r *http.Request) {
+	for _, header := range []string{"X-User-ID", "X-User-Email", "X-User-Username", "X-User-Role", types.OrgIDHeader, types.OrgRoleHeader, types.PlanHeader, types.PriorityTierHeader} {
+		r.Header.Del(header)
+	}
+	proxyRequest(w, r, g.voiceServiceURL, func(path string) string {
+		// /api/gallery -> /gallery
+		return strings.TrimPrefix(path, "/api")
+	})
+}
+
+// proxyToPublicDownload serves signed download links without a token. The
+// storage service verifies the signature; unsigned requests never reach it.
+func (g *Gateway) proxyToPublicDownload(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get(signedurl.ParamSig) == "" {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.InvalidLink, "Invalid or expired link")
+		return
+	}
+	r.Header.Del("X-User-ID")
+	r.Header.Del("X-User-Role")
+	r.Header.Del(types.OrgIDHeader)
+	r.Header.Del(types.OrgRoleHeader)
+	r.Header.Del(types.PlanHeader)
+	r.Header.Del(types.PriorityTierHeader)
+	proxyRequest(w, r, g.storageServiceURL, func(path string) string {
+		// /api/public/download/{id} -> /download/{id}
+		return strings.TrimPrefix(path, "/api/public")
+	})
+}
+
+// proxyToPublicFile serves public-read files, such as preview samples,
+// without a token. The storage service refuses any other file.
+func (g *Gateway) proxyToPublicFile(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del("X-User-ID")
+	r.Header.Del("X-User-Role")
+	r.Header.Del(types.OrgIDHeader)
+	r.Header.Del(types.OrgRoleHeader)
+	r.Header.Del(types.PlanHeader)
+	r.Header.Del(types.PriorityTierHeader)
+	proxyRequest(w, r, g.storageServiceURL, func(path string) string {
+		// /api/public/files/{id} -> /public/{id}
+		return "/public/" + strings.TrimPrefix(path, "/api/public/files/")
+	})
+}
+
+// proxyToBillingWebhook forwards Stripe's webhooks without a token. The user
+// service verifies their signature.
+func (g *Gateway) proxyToBillingWebhook(w http.ResponseWriter, r *http.Request) {
+	for _, header := range []string{"X-User-ID", "X-User-Email", "X-User-Username", "X-User-Role", types.OrgIDHeader, types.OrgRoleHeader, types.PlanHeader, types.PriorityTierHeader} {
+		r.Header.Del(header)
+	}
+	proxyRequest(w, r, g.userServiceURL, func(path string) string {
+		// /api/billing/webhook -> /billing/webhook
+		return strings.TrimPrefix(path, "/api")
+	})
+}
+
+// proxyToPublicProfile serves users' public profiles without a token. The
+// user service shows only the profiles their users made public.
+func (g *Gateway) proxyToPublicProfile(w http.ResponseWriter, r *http.Request) {
+	for _, header := range []string{"X-User-ID", "X-User-Email", "X-User-Username", "X-User-Role", types.OrgIDHeader, types.OrgRoleHeader, types.PlanHeader, types.PriorityTierHeader} {
+		r.Header.Del(header)
+	}
+	proxyRequest(w, r, g.userServiceURL, func(path string) string {
+		// /api/users/{username}/public -> /users/{username}/public
+		return strings.TrimPrefix(path, "/api")
+	})
+}
+
+func (g *Gateway) proxyToStorage(w http.ResponseWriter, r *http.Request) {
+	// Only internal callers may store into the output quota class
+	r.Header.Del("X-Storage-Class")
+	proxyRequest(w, r, g.storageServiceURL, func(path string) string {
+		// /api/storage/upload -> /upload
+		return strings.TrimPrefix(path, "/api/storage")
+	})
+}
+
+func (g *Gateway) proxyToUser(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.userServiceURL, func(path string) string {
+		// /api/user/profile -> /profile
+		return strings.TrimPrefix(path, "/api/user")
+	})
+}
+
+// proxyToSearch forwards searches of the caller's clones and files to the
+// user service, which asks the voice and storage services
+func (g *Gateway) proxyToSearch(w http.ResponseWriter, r *http.Request) {
+	proxyRequest(w, r, g.userServiceURL, func(path string) string {
+		// /api/search -> /search
+		return strings.TrimPrefix(path, "/api")
+	})
+}
+
+func proxyRequest(w http.ResponseWriter, r *http.Request, targetBaseURL string, pathMapper func(string) string) {
+	// Parse target URL
+	targetURL, err := url.Parse(targetBaseURL)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Invalid target URL")
+		return
+	}
+
+	// Create reverse proxy
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+
+	// Modify request path
+	originalPath := r.URL.Path
+	servicePath := pathMapper(originalPath)
+	r.URL.Path = servicePath
+
+	// Preserve query parameters
+	r.URL.RawQuery = r.URL.Query().Encode()
+
+	// Set target host
+	r.URL.Host = targetURL.Host
+	r.URL.Scheme = targetURL.Scheme
+	r.Host = targetURL.Host
+
+	// Create a custom director
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.URL.Host = targetURL.Host
+		req.URL.Scheme = targetURL.Scheme
+	}
+
+	// The gateway already set the request ID on the response. The service
+	// knows the caller's locale preference, so its language wins.
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del(utils.RequestIDHeader)
+		if resp.Header.Get(utils.ContentLanguageHeader) != "" {
+			w.Header().Del(utils.ContentLanguageHeader)
+		}
+		return normalizeShedResponse(resp)
+	}
+	proxy.ErrorHandler = upstreamErrorHandler
+
+	// Serve request
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	// upstreamRetryAfter is suggested when a service can't be reached or
+	// sheds load without saying when to come back
+	upstreamRetryAfter = 2 * time.Second
+	maxShedBodyBytes   = 64 << 10
+)
+
+// maintenanceMiddleware sheds every request except health checks with a 503
+// while MAINTENANCE_MODE is set
+func maintenanceMiddleware(retryAfter time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/health") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		utils.RetryResponse(w, http.StatusServiceUnavailable, "Service is down for maintenance",
+			utils.NewRetryGuidance(utils.RetryReasonMaintenance, retryAfter))
+	})
+}
+
+// upstreamErrorHandler answers with retry guidance when a service can't be
+// reached, instead of the reverse proxy's bare 502
+func upstreamErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Error("Upstream request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	if r.Context().Err() != nil {
+		// The client went away; nobody is listening for guidance
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	utils.RetryResponse(w, http.StatusServiceUnavailable, "Service temporarily unavailable",
+		utils.NewRetryGuidance(utils.RetryReasonUpstreamUnavailable, upstreamRetryAfter))
+}
+
+// normalizeShedResponse gives upstream 429 and 503 responses the same retry
+// guidance the gateway sends for load it sheds itself. A Retry-After the
+// service chose is kept; JSON errors gain the "retry" field.
+func normalizeShedResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	if resp.Header.Get(utils.RetryAfterMSHeader) != "" {
+		return nil
+	}
+
+	reason := utils.RetryReasonOverloaded
+	if resp.StatusCode == http.StatusTooManyRequests {
+		reason = utils.RetryReasonRateLimited
+	}
+	retryAfter := upstreamRetryAfter
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	guidance := utils.NewRetryGuidance(reason, retryAfter)
+	utils.SetRetryHeaders(resp.Header, guidance)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxShedBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxShedBodyBytes {
+		// Too large to be an error message; pass it through untouched
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	var fields map[string]interface{}
+	if envelope, err := utils.ReadEnvelope(resp.StatusCode, data); err == nil && json.Unmarshal(envelope.Error, &fields) == nil && fields != nil {
+		if _, ok := fields["retry"]; !ok {
+			fields["retry"] = guidance
+			encoded, _ := json.Marshal(fields)
+			envelope.Error = encoded
+			if encoded, err := json.Marshal(envelope); err == nil {
+				data = append(encoded, '\n')
+			}
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// validateToken returns a token's claims. Tokens the auth service accepted
+// are cached, by hash, for the token cache TTL or until they expire if
+// sooner, and dropped on logout; refused tokens are asked about every time.
+func (g *Gateway) validateToken(ctx context.Context, token string) (*utils.Claims, error) {
+	key := tokenKey(token)
+	if g.tokenTTL > 0 {
+		var claims utils.Claims
+		ok, err := g.tokens.Get(ctx, key, &claims)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Failed to read cached token", "error", err)
+		}
+		if ok {
+			return &claims, nil
+		}
+	}
+
+	claims, err := validateTokenWithAuthService(ctx, g.client, g.authServiceURL, token)
+	if err != nil || g.tokenTTL <= 0 {
+		return claims, err
+	}
+	ttl := g.tokenTTL
+	if claims.ExpiresAt != nil {
+		ttl = min(ttl, time.Until(claims.ExpiresAt.Time))
+	}
+	if ttl > 0 {
+		if err := g.tokens.Set(ctx, key, claims, ttl); err != nil {
+			logging.FromContext(ctx).Warn("Failed to cache token", "error", err)
+		}
+	}
+	return claims, nil
+}
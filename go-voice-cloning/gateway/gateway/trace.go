@@ -0,0 +1,199 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// maxCapturedErrorBody bounds how much of an error response is kept per trace
+const maxCapturedErrorBody = 2048
+
+// ClientRequestIDHeader echoes the request ID a client sent. The gateway
+// mints its own request ID for every request, as the key of its trace, so
+// one caller can't claim or replace another's trace by reusing its ID.
+const ClientRequestIDHeader = "X-Client-Request-ID"
+
+// RequestTrace is the correlated record of a single request through the gateway
+type RequestTrace struct {
+	RequestID       string    `json:"request_id"`
+	ClientRequestID string    `json:"client_request_id,omitempty"`
+	Method          string    `json:"method"`
+	Path            string    `json:"path"`
+	Status          int       `json:"status"`
+	DurationMS      int64     `json:"duration_ms"`
+	UserID          int       `json:"user_id,omitempty"`
+	RemoteAddr      string    `json:"remote_addr"`
+	Error           string    `json:"error,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+}
+
+// TraceStore keeps the most recent request traces in a fixed-size ring buffer
+type TraceStore struct {
+	mu     sync.RWMutex
+	traces []RequestTrace
+	index  map[string]int
+	next   int
+}
+
+func NewTraceStore(size int) *TraceStore {
+	return &TraceStore{
+		traces: make([]RequestTrace, size),
+		index:  make(map[string]int, size),
+	}
+}
+
+func (s *TraceStore) Add(t RequestTrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The index only points here if no later trace has taken the ID over
+	if old := s.traces[s.next]; old.RequestID != "" && s.index[old.RequestID] == s.next {
+		delete(s.index, old.RequestID)
+	}
+	s.traces[s.next] = t
+	s.index[t.RequestID] = s.next
+	s.next = (s.next + 1) % len(s.traces)
+}
+
+func (s *TraceStore) Get(requestID string) (RequestTrace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.index[requestID]
+	if !ok {
+		return RequestTrace{}, false
+	}
+	return s.traces[i], true
+}
+
+// traceRecorder captures the status code and the start of error bodies
+type traceRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *traceRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *traceRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 400 && rec.body.Len() < maxCapturedErrorBody {
+		n := maxCapturedErrorBody - rec.body.Len()
+		if n > len(b) {
+			n = len(b)
+		}
+		rec.body.Write(b[:n])
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *traceRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets proxied WebSocket upgrades take over the connection
+func (rec *traceRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (rec *traceRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// traceMiddleware assigns the request ID, strips identity headers that only
+// the gateway may set, and records and logs a trace once the request
+// completes. The request ID is always the gateway's own; a well-formed ID
+// sent by the client is kept on the trace and echoed in
+// X-Client-Request-ID. Panics are recovered into 500 responses, which are traced too.
+// The gateway's own errors are written in the language Accept-Language
+// prefers.
+func (g *Gateway) traceMiddleware(next http.Handler) http.Handler {
+	next = middleware.Recover(middleware.Language(next))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"X-User-ID", "X-User-Email", "X-User-Username", "X-User-Role"} {
+			r.Header.Del(h)
+		}
+
+		requestID := utils.NewRequestID()
+		clientRequestID := r.Header.Get(utils.RequestIDHeader)
+		r.Header.Del(ClientRequestIDHeader)
+		if utils.ValidRequestID(clientRequestID) {
+			r.Header.Set(ClientRequestIDHeader, clientRequestID)
+			w.Header().Set(ClientRequestIDHeader, clientRequestID)
+		} else {
+			clientRequestID = ""
+		}
+		r.Header.Set(utils.RequestIDHeader, requestID)
+		w.Header().Set(utils.RequestIDHeader, requestID)
+		r = r.WithContext(logging.With(r.Context(), "request_id", requestID))
+
+		start := time.Now()
+		rec := &traceRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		userID := middleware.UserID(r)
+		trace := RequestTrace{
+			RequestID:       requestID,
+			ClientRequestID: clientRequestID,
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Status:          rec.status,
+			DurationMS:      time.Since(start).Milliseconds(),
+			UserID:          userID,
+			RemoteAddr:      r.RemoteAddr,
+			Error:           rec.body.String(),
+			StartedAt:       start,
+		}
+		g.traces.Add(trace)
+
+		slog.Info("Request completed", "request_id", trace.RequestID, "client_request_id", trace.ClientRequestID,
+			"method", trace.Method, "path", trace.Path, "status", trace.Status, "duration_ms", trace.DurationMS,
+			"user_id", trace.UserID)
+	})
+}
+
+// getErrorTrace returns the recorded trace for a request ID. Users can only
+// look up their own requests; admins can look up any request.
+func (g *Gateway) getErrorTrace(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["request_id"]
+
+	trace, ok := g.traces.Get(requestID)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Request not found")
+		return
+	}
+
+	if !middleware.IsAdmin(r) && (trace.UserID == 0 || trace.UserID != middleware.UserID(r)) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Request not found")
+		return
+	}
+
+	utils.SuccessResponse(w, trace)
+}
@@ -7,6 +7,20 @@ replace github.com/voice-cloning/shared => ../shared
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/voice-cloning/shared v0.0.0-00010101000000-000000000000
+	golang.org/x/net v0.26.0
+	golang.org/x/sys v0.21.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.33.0
 )
 
-
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/jmoiron/sqlx v1.3.5 // indirect
+	github.com/lib/pq v1.10.9 // indirect
+	github.com/mattn/go-sqlite3 v1.14.33 // indirect
+	github.com/redis/go-redis/v9 v9.5.1 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Environment passed from a gateway process to the replacement it starts
+const (
+	inheritFDEnv = "GATEWAY_INHERITED_FD"
+	parentPIDEnv = "GATEWAY_PARENT_PID"
+	inheritedFD  = 3 // first entry of exec.Cmd.ExtraFiles
+)
+
+// listen returns the listening socket, reusing the one handed over by a
+// previous gateway process when there is one. With GATEWAY_REUSEPORT set a
+// fresh socket is opened with SO_REUSEPORT so an independently started
+// gateway can bind the same port.
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv(inheritFDEnv) != "" {
+		f := os.NewFile(uintptr(inheritedFD), "gateway-listener")
+		defer f.Close()
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("inherit listener: %w", err)
+		}
+		slog.Info("Inherited listener", "addr", ln.Addr().String())
+		return ln, nil
+	}
+
+	lc := net.ListenConfig{}
+	if os.Getenv("GATEWAY_REUSEPORT") == "true" {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// serve runs the server until SIGINT/SIGTERM, draining in-flight requests
+// before exiting. SIGHUP starts a replacement process (re-reading config
+// from the environment and the binary from disk) that takes over the
+// listener; once it is serving it signals this process to drain and exit,
+// waiting up to shutdownTimeout for in-flight requests.
+func serve(server *http.Server, ln net.Listener, shutdownTimeout time.Duration) {
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	notifyParent()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if err := handOff(ln); err != nil {
+				slog.Error("Reload failed, continuing to serve", "error", err)
+			}
+			continue
+		}
+		break
+	}
+
+	slog.Info("API Gateway draining connections")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Shutdown did not complete", "error", err)
+	}
+}
+
+// handOff starts a new gateway process sharing the listening socket. This
+// process keeps serving until the child reports ready with SIGTERM.
+func handOff(ln net.Listener) error {
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("listener %T cannot be handed off", ln)
+	}
+	f, err := tcp.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), inheritFDEnv+"=1", parentPIDEnv+"="+strconv.Itoa(os.Getpid()))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	slog.Info("Started replacement gateway", "pid", cmd.Process.Pid)
+
+	// A child that dies before taking over leaves this process serving
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			slog.Error("Replacement gateway exited", "error", err)
+		}
+	}()
+	return nil
+}
+
+// notifyParent tells the process that handed over the listener to drain
+func notifyParent() {
+	pid, err := strconv.Atoi(os.Getenv(parentPIDEnv))
+	if err != nil || pid != os.Getppid() {
+		return
+	}
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Signal(syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on linux")
+}
@@ -0,0 +1,5 @@
+// Package synthesispb holds the gateway's gRPC synthesis API, generated
+// from synthesis.proto
+package synthesispb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative synthesis.proto
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/webhooks"
+	"github.com/voice-cloning/shared/workerpool"
+)
+
+// Delivery channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 200
+)
+
+// Delivery is an email or webhook sent, or to be sent, for an event. Target
+// is the email address or the webhook URL.
+type Delivery struct {
+	ID             int64      `json:"id" db:"id"`
+	EventID        string     `json:"event_id" db:"event_id"`
+	EventType      string     `json:"event_type" db:"event_type"`
+	Channel        string     `json:"channel" db:"channel"`
+	WebhookID      *int       `json:"webhook_id,omitempty" db:"webhook_id"`
+	Target         string     `json:"target" db:"target"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	LastStatusCode *int       `json:"last_status_code,omitempty" db:"last_status_code"`
+	LastError      *string    `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+type deliveryCursor struct {
+	ID int64 `json:"id"`
+}
+
+// deliverySort lists the newest deliveries first
+var deliverySort = types.PageSort{Name: "-id", Column: "id", Descending: true}
+
+// runDeliveries sends due deliveries every interval until ctx is cancelled.
+// A batch in flight when ctx is cancelled still finishes and records its
+// results, so its deliveries aren't sent twice.
+func (s *NotificationService) runDeliveries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		if err := s.deliverDue(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("Failed to send notifications", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliveryLease is how long a batch's deliveries are held once claimed.
+// Should the instance sending them stop before recording the results, they
+// are tried again once it expires.
+const deliveryLease = 10 * time.Minute
+
+// deliverDue sends a batch of due deliveries on the sender pool. A failed
+// one is retried with exponential backoff until it has been tried
+// maxAttempts times. The batch is claimed by pushing its next attempt past
+// the lease before sending, so replicas skip each other's rows without a
+// transaction held open across the sends.
+func (s *NotificationService) deliverDue(ctx context.Context) error {
+	due, err := s.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Rows are updated once every send of the batch has finished; a send
+	// that couldn't be queued counts as failed, unless the pool was shutting
+	// down, which releases the delivery untried
+	codes := make([]int, len(due))
+	sendErrs := make([]error, len(due))
+	group := s.senders.Group()
+	for i, delivery := range due {
+		i, delivery := i, delivery
+		err := group.Submit(ctx, func(context.Context) {
+			switch delivery.Channel {
+			case ChannelEmail:
+				sendErrs[i] = s.sendEmail([]byte(delivery.Payload))
+			case ChannelWebhook:
+				secret := ""
+				if delivery.Secret != nil {
+					secret = *delivery.Secret
+				}
+				codes[i], sendErrs[i] = s.sendWebhook(ctx, delivery.EventID, delivery.EventType, delivery.Target, secret, []byte(delivery.Payload))
+			default:
+				sendErrs[i] = fmt.Errorf("unknown channel %q", delivery.Channel)
+			}
+		})
+		if err != nil {
+			sendErrs[i] = err
+		}
+	}
+	group.Wait()
+
+	for i, delivery := range due {
+		code, sendErr := codes[i], sendErrs[i]
+		if errors.Is(sendErr, workerpool.ErrClosed) {
+			if _, err := s.db.ExecContext(ctx, `UPDATE notification_deliveries SET next_attempt_at = NOW() WHERE id = $1`, delivery.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		attempts := delivery.Attempts + 1
+
+		var codeArg interface{}
+		if code != 0 {
+			codeArg = code
+		}
+
+		if sendErr == nil {
+			_, err = s.db.ExecContext(ctx,
+				`UPDATE notification_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = NULL, delivered_at = NOW()
+				WHERE id = $4`,
+				DeliveryDelivered, attempts, codeArg, delivery.ID)
+		} else {
+			slog.Warn("Failed to send notification", "delivery_id", delivery.ID, "channel", delivery.Channel, "attempt", attempts, "error", sendErr)
+			status := DeliveryPending
+			if attempts >= s.maxAttempts {
+				status = DeliveryFailed
+			}
+			_, err = s.db.ExecContext(ctx,
+				`UPDATE notification_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5
+				WHERE id = $6`,
+				status, attempts, codeArg, sendErr.Error(), time.Now().Add(deliveryBackoff(attempts)), delivery.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueDelivery is a claimed delivery with its webhook's secret
+type dueDelivery struct {
+	ID        int64   `db:"id"`
+	EventID   string  `db:"event_id"`
+	EventType string  `db:"event_type"`
+	Channel   string  `db:"channel"`
+	Target    string  `db:"target"`
+	Payload   string  `db:"payload"`
+	Attempts  int     `db:"attempts"`
+	Secret    *string `db:"secret"`
+}
+
+// claimDue claims a batch of due deliveries for deliveryLease
+func (s *NotificationService) claimDue(ctx context.Context) ([]dueDelivery, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var due []dueDelivery
+	err = tx.SelectContext(ctx, &due,
+		`SELECT d.id, d.event_id, d.event_type, d.channel, d.target, d.payload, d.attempts, w.secret
+		FROM notification_deliveries d
+		LEFT JOIN notification_webhooks w ON w.id = d.webhook_id
+		WHERE d.status = $1 AND d.next_attempt_at <= NOW()
+		ORDER BY d.next_attempt_at
+		LIMIT $2
+		FOR UPDATE OF d SKIP LOCKED`,
+		DeliveryPending, s.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(due))
+	for i, delivery := range due {
+		ids[i] = delivery.ID
+	}
+	if len(ids) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE notification_deliveries SET next_attempt_at = $1 WHERE id = ANY($2)`,
+			time.Now().Add(deliveryLease), pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return due, tx.Commit()
+}
+
+// deliveryBackoff is the wait before retrying a delivery tried attempts
+// times: 10s, 20s, 40s ... capped at one hour
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := time.Duration(10<<uint(min(attempts-1, 9))) * time.Second
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	return backoff
+}
+
+// sendEmail sends the message rendered when the delivery was recorded
+func (s *NotificationService) sendEmail(payload []byte) error {
+	var msg mail.Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return err
+	}
+	return s.sender.SendMessage(msg)
+}
+
+// sendWebhook posts an event to a webhook, signed with the webhook's
+// secret like clone callbacks are
+func (s *NotificationService) sendWebhook(ctx context.Context, eventID, eventType, target, secret string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.EventHeader, eventType)
+	req.Header.Set(webhooks.DeliveryHeader, eventID)
+	req.Header.Set(webhooks.SignatureHeader, webhooks.Sign(secret, time.Now().Unix(), body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// listDeliveries pages through the caller's deliveries, newest first,
+// optionally of one channel, status, event type or webhook
+func (s *NotificationService) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	query := r.URL.Query()
+	page, err := types.ParsePageRequest(query, defaultDeliveryPageSize, maxDeliveryPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{"user_id = " + arg(userID)}
+	for _, filter := range []string{"channel", "status", "event_type"} {
+		if value := query.Get(filter); value != "" {
+			where = append(where, filter+" = "+arg(value))
+		}
+	}
+	if value := query.Get("webhook_id"); value != "" {
+		webhookID, err := strconv.Atoi(value)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid webhook_id")
+			return
+		}
+		where = append(where, "webhook_id = "+arg(webhookID))
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM notification_deliveries WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list deliveries")
+		return
+	}
+
+	var cursor deliveryCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, deliverySort.After(nil, cursor.ID, arg))
+	}
+
+	deliveries := []Delivery{}
+	err = s.db.SelectContext(r.Context(), &deliveries,
+		`SELECT id, event_id, event_type, channel, webhook_id, target, status, attempts, last_status_code, last_error,
+			next_attempt_at, created_at, delivered_at
+		FROM notification_deliveries
+		WHERE `+strings.Join(where, " AND ")+" "+deliverySort.OrderBy()+" "+page.LimitClause(arg), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list deliveries")
+		return
+	}
+
+	utils.SuccessResponse(w, types.NewPage(page, deliveries, total, func(last Delivery) interface{} {
+		return deliveryCursor{ID: last.ID}
+	}))
+}
+
+// redeliver sends one of the caller's webhook deliveries again, such as
+// after fixing the endpoint: it is queued as if new, signed with the
+// webhook's current secret, and retried as a new delivery would be. A
+// delivery still pending is left to its retries.
+func (s *NotificationService) redeliver(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid delivery ID")
+		return
+	}
+
+	var delivery Delivery
+	err = s.db.GetContext(r.Context(), &delivery,
+		`UPDATE notification_deliveries
+		SET status = $1, attempts = 0, last_status_code = NULL, last_error = NULL, next_attempt_at = NOW(), delivered_at = NULL
+		WHERE id = $2 AND user_id = $3 AND channel = $4 AND status <> $1
+		RETURNING id, event_id, event_type, channel, webhook_id, target, status, attempts, last_status_code, last_error,
+			next_attempt_at, created_at, delivered_at`,
+		DeliveryPending, id, userID, ChannelWebhook)
+	if errors.Is(err, sql.ErrNoRows) {
+		var status string
+		err = s.db.GetContext(r.Context(), &status,
+			"SELECT status FROM notification_deliveries WHERE id = $1 AND user_id = $2 AND channel = $3", id, userID, ChannelWebhook)
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.ErrorResponse(w, http.StatusNotFound, apierror.DeliveryNotFound, "Delivery not found")
+			return
+		}
+		if err == nil {
+			utils.ErrorResponse(w, http.StatusConflict, apierror.Conflict, "Delivery is still pending")
+			return
+		}
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to redeliver")
+		return
+	}
+	utils.JSONResponse(w, http.StatusAccepted, delivery)
+}
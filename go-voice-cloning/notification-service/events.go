@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/types"
+)
+
+// emailPrefs maps the event types users can be emailed about to the
+// preference opting in or out, and its default as the user service sets it
+var emailPrefs = map[string]struct {
+	key string
+	def bool
+}{
+	events.TypeCloneCreated: {types.PrefNotifyCloneCreated, true},
+	events.TypeFileUploaded: {types.PrefNotifyFileUploaded, false},
+}
+
+// defaultChannels are the notification channels of users who haven't
+// chosen theirs
+var defaultChannels = []string{ChannelEmail, "websocket"}
+
+// handleEvent records the deliveries an event calls for. An event handled
+// twice records them once.
+func (s *NotificationService) handleEvent(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case events.TypeCloneCreated:
+		var created events.CloneCreated
+		if err := event.Decode(&created); err != nil {
+			return err
+		}
+		var name string
+		err := s.db.GetContext(ctx, &name, "SELECT name FROM voice_clones WHERE id = $1", created.CloneID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return s.notify(ctx, event, created.UserID, map[string]interface{}{
+			"clone_id": created.CloneID,
+			"name":     name,
+		})
+
+	case events.TypeCloneCompleted:
+		var completed events.CloneCompleted
+		if err := event.Decode(&completed); err != nil {
+			return err
+		}
+		return s.notify(ctx, event, completed.UserID, nil)
+
+	case events.TypeCloneFailed:
+		var failed events.CloneFailed
+		if err := event.Decode(&failed); err != nil {
+			return err
+		}
+		return s.notify(ctx, event, failed.UserID, nil)
+
+	case events.TypeFileUploaded:
+		var uploaded events.FileUploaded
+		if err := event.Decode(&uploaded); err != nil {
+			return err
+		}
+		// Files no user owns, such as clone outputs, notify nobody
+		if uploaded.UserID == 0 {
+			return nil
+		}
+		return s.notify(ctx, event, uploaded.UserID, map[string]interface{}{
+			"file_id":  uploaded.FileID,
+			"filename": uploaded.Filename,
+			"size":     uploaded.Size,
+			"type":     uploaded.Type,
+		})
+
+	case events.TypeSubscriptionChanged:
+		var changed events.SubscriptionChanged
+		if err := event.Decode(&changed); err != nil {
+			return err
+		}
+		return s.notify(ctx, event, changed.UserID, nil)
+
+	case events.TypeUserDeleted:
+		var deleted events.UserDeleted
+		if err := event.Decode(&deleted); err != nil {
+			return err
+		}
+		return s.forgetUser(ctx, deleted.UserID)
+	}
+	return nil
+}
+
+// notify records a delivery of the event to each of the user's webhooks
+// subscribed to it, and an email for the event types users are emailed
+// about unless the user opted out. Emails due in
+// the user's quiet hours are held until those end.
+func (s *NotificationService) notify(ctx context.Context, event events.Event, userID int, details map[string]interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO notification_deliveries (user_id, event_id, event_type, channel, webhook_id, target, payload, status)
+		SELECT user_id, $1, $2, $3, id, url, $4, $5 FROM notification_webhooks
+		WHERE user_id = $6 AND $2 = ANY(events)
+		ON CONFLICT (event_id, channel, target) DO NOTHING`,
+		event.ID, event.Type, ChannelWebhook, string(body), DeliveryPending, userID)
+	if err != nil {
+		return fmt.Errorf("webhooks: %w", err)
+	}
+
+	pref, ok := emailPrefs[event.Type]
+	if !ok {
+		return nil
+	}
+	var user struct {
+		Email     string     `db:"email"`
+		Username  string     `db:"username"`
+		DeletedAt *time.Time `db:"deleted_at"`
+	}
+	err = s.db.GetContext(ctx, &user, "SELECT email, username, deleted_at FROM users WHERE id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.DeletedAt != nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	prefs, err := s.preferences(ctx, userID)
+	if err != nil {
+		return err
+	}
+	channels := defaultChannels
+	if raw, ok := prefs[types.PrefNotificationChannels]; ok {
+		json.Unmarshal(raw, &channels)
+	}
+	enabled := pref.def
+	if raw, ok := prefs[pref.key]; ok {
+		json.Unmarshal(raw, &enabled)
+	}
+	if !enabled || !contains(channels, ChannelEmail) {
+		return nil
+	}
+
+	settings, err := locale.ForUser(ctx, s.db, userID)
+	if err != nil {
+		return err
+	}
+	msg, err := mail.Render(emailTemplate(event.Type, settings.Locale), mail.Data{
+		Brand:     s.brand,
+		Recipient: mail.Recipient{Email: user.Email, Name: user.Username},
+		Details:   details,
+	})
+	if err != nil {
+		return fmt.Errorf("%s email: %w", event.Type, err)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	sendAt := time.Now()
+	var start, end string
+	json.Unmarshal(prefs[types.PrefQuietHoursStart], &start)
+	json.Unmarshal(prefs[types.PrefQuietHoursEnd], &end)
+	if until, quiet := locale.QuietUntil(start, end, settings.Location(), sendAt); quiet {
+		sendAt = until
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO notification_deliveries (user_id, event_id, event_type, channel, target, payload, status, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (event_id, channel, target) DO NOTHING`,
+		userID, event.ID, event.Type, ChannelEmail, user.Email, string(payload), DeliveryPending, sendAt)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// preferences reads the keys the user set. The user service owns them and
+// fills in the defaults of those left out.
+func (s *NotificationService) preferences(ctx context.Context, userID int) (map[string]json.RawMessage, error) {
+	var doc []byte
+	err := s.db.GetContext(ctx, &doc, "SELECT preferences FROM user_preferences WHERE user_id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return map[string]json.RawMessage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc, &prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// forgetUser removes a deleted user's webhooks and delivery history
+func (s *NotificationService) forgetUser(ctx context.Context, userID int) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM notification_deliveries WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, "DELETE FROM notification_webhooks WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	slog.Info("Removed deleted user's notifications", "user_id", userID, "webhooks", n)
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/schema"
+	"github.com/voice-cloning/shared/workerpool"
+)
+
+// serviceName is also the group the service subscribes to events under, so
+// each event is handled by one replica
+const serviceName = "notification-service"
+
+// subscribeRetry is how long a failed event subscription waits before it
+// is tried again
+const subscribeRetry = 5 * time.Second
+
+// NotificationService turns platform events into emails and webhook
+// deliveries, sends them with retries and reports them to their users
+type NotificationService struct {
+	db          *sqlx.DB
+	sender      mail.Sender
+	brand       mail.Branding
+	client      *http.Client
+	batchSize   int
+	maxAttempts int
+	senders     *workerpool.Pool // sends the deliveries of a batch
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad(serviceName, &cfg)
+	if err := logging.Setup(serviceName, cfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+	config.LogSummary(&cfg)
+
+	tlsSource, err := mtls.Setup(cfg.TLS)
+	if err != nil {
+		log.Fatal("Invalid mTLS configuration: ", err)
+	}
+
+	// Database connection
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	if cfg.MigrateOnStart {
+		if _, err := schema.Up(context.Background(), db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	// Every notification starts with an event on the bus
+	bus, err := events.BusFromEnv()
+	if err != nil {
+		log.Fatal("Failed to connect to event bus:", err)
+	}
+	if bus == nil {
+		log.Fatal("EVENT_BUS is required: notifications are sent for the events on it")
+	}
+	defer bus.Close()
+
+	sender, err := mail.NewSender(cfg.Mail)
+	if err != nil {
+		log.Fatal("Failed to configure mail provider:", err)
+	}
+	renderer, err := mail.NewRenderer(cfg.Mail)
+	if err != nil {
+		log.Fatal("Invalid email templates:", err)
+	}
+
+	service := &NotificationService{
+		db:          db,
+		sender:      sender,
+		brand:       renderer.Brand(mail.Branding{}),
+		client:      &http.Client{Timeout: cfg.WebhookTimeout},
+		batchSize:   cfg.DeliveryBatchSize,
+		maxAttempts: cfg.DeliveryMaxAttempts,
+		senders:     workerpool.New(workerpool.Config{Name: "notification-sender", Workers: cfg.DeliveryConcurrency}),
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	for _, eventType := range append(webhookEvents, events.TypeUserDeleted) {
+		go service.consume(ctx, bus, eventType)
+	}
+	deliveriesDone := make(chan struct{})
+	go func() {
+		service.runDeliveries(ctx, cfg.DeliveryInterval)
+		close(deliveriesDone)
+	}()
+
+	checks := health.New(serviceName)
+	checks.Add("postgres", health.DB(db))
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pool{Name: "primary", DB: db})).Methods("GET")
+	r.HandleFunc("/webhooks", service.listWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks", service.createWebhook).Methods("POST")
+	r.HandleFunc("/webhooks/{id}", service.deleteWebhook).Methods("DELETE")
+	r.HandleFunc("/deliveries", service.listDeliveries).Methods("GET")
+	r.HandleFunc("/deliveries/{id}/redeliver", service.redeliver).Methods("POST")
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: middleware.Chain(middleware.UserLanguage(db)(r))}
+	go func() {
+		slog.Info("Notification Service starting", "port", cfg.Port)
+		if err := tlsSource.ListenAndServe(server); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop consuming and picking up batches, let in-flight requests finish,
+	// then let the batch being sent drain. Deliveries of a batch that doesn't
+	// finish in time stay pending and are sent again.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	slog.Info("Notification Service shutting down")
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	select {
+	case <-deliveriesDone:
+	case <-shutdownCtx.Done():
+	}
+	if err := service.senders.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Notification deliveries did not drain", "error", err)
+	}
+}
+
+// consume handles the events of a type until ctx is cancelled,
+// resubscribing when the subscription fails
+func (s *NotificationService) consume(ctx context.Context, bus events.Subscriber, eventType string) {
+	for ctx.Err() == nil {
+		err := bus.Subscribe(ctx, eventType, serviceName, s.handleEvent)
+		if err == nil {
+			continue
+		}
+		slog.Error("Event subscription failed", "event_type", eventType, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(subscribeRetry):
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/i18n"
+	"github.com/voice-cloning/shared/mail"
+)
+
+const htmlLayoutStart = `<div style="font-family:sans-serif;max-width:560px;margin:0 auto">
+{{if .Brand.LogoURL}}<img src="{{.Brand.LogoURL}}" alt="{{.Brand.Name}}" height="32">{{else}}<h2>{{.Brand.Name}}</h2>{{end}}
+`
+
+const htmlLayoutEnd = `<p style="color:#6b7280;font-size:12px">You can turn these emails off in your notification preferences. Questions? Contact {{.Brand.SupportEmail}}.</p>
+</div>`
+
+// htmlLayout wraps the body of an email with a footer in its language
+func htmlLayout(body, footer string) string {
+	return htmlLayoutStart + body + `<p style="color:#6b7280;font-size:12px">` + footer + `</p>
+</div>`
+}
+
+// emailTemplates are the emails sent for each event type users can be
+// emailed about. .Details holds the event's specifics.
+var emailTemplates = map[string]mail.Template{
+	events.TypeCloneCreated: {
+		Subject: `Your voice clone "{{.Details.name}}" is training`,
+		Text: `Hi {{.Recipient.Name}},
+
+We started training your voice clone "{{.Details.name}}" (#{{.Details.clone_id}}). We'll let you know when it is ready.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+<p>We started training your voice clone <strong>{{.Details.name}}</strong> (#{{.Details.clone_id}}). We'll let you know when it is ready.</p>
+` + htmlLayoutEnd,
+	},
+	events.TypeFileUploaded: {
+		Subject: `{{.Details.filename}} was uploaded to {{.Brand.Name}}`,
+		Text: `Hi {{.Recipient.Name}},
+
+{{.Details.filename}} ({{.Details.size}} bytes) was uploaded to your account.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+<p><strong>{{.Details.filename}}</strong> ({{.Details.size}} bytes) was uploaded to your account.</p>
+` + htmlLayoutEnd,
+	},
+}
+
+// localizedEmailTemplates are the emailTemplates in the other supported
+// languages
+var localizedEmailTemplates = map[string]map[string]mail.Template{
+	"es": {
+		events.TypeCloneCreated: {
+			Subject: `Tu clon de voz "{{.Details.name}}" se está entrenando`,
+			Text: `Hola, {{.Recipient.Name}}:
+
+Empezamos a entrenar tu clon de voz "{{.Details.name}}" (#{{.Details.clone_id}}). Te avisaremos cuando esté listo.
+`,
+			HTML: htmlLayout(`<p>Hola, {{.Recipient.Name}}:</p>
+<p>Empezamos a entrenar tu clon de voz <strong>{{.Details.name}}</strong> (#{{.Details.clone_id}}). Te avisaremos cuando esté listo.</p>
+`, `Puedes desactivar estos correos en tus preferencias de notificación. ¿Dudas? Escribe a {{.Brand.SupportEmail}}.`),
+		},
+		events.TypeFileUploaded: {
+			Subject: `{{.Details.filename}} se subió a {{.Brand.Name}}`,
+			Text: `Hola, {{.Recipient.Name}}:
+
+{{.Details.filename}} ({{.Details.size}} bytes) se subió a tu cuenta.
+`,
+			HTML: htmlLayout(`<p>Hola, {{.Recipient.Name}}:</p>
+<p><strong>{{.Details.filename}}</strong> ({{.Details.size}} bytes) se subió a tu cuenta.</p>
+`, `Puedes desactivar estos correos en tus preferencias de notificación. ¿Dudas? Escribe a {{.Brand.SupportEmail}}.`),
+		},
+	},
+	"fr": {
+		events.TypeCloneCreated: {
+			Subject: `Votre clone vocal « {{.Details.name}} » est en cours d'entraînement`,
+			Text: `Bonjour {{.Recipient.Name}},
+
+Nous avons lancé l'entraînement de votre clone vocal « {{.Details.name}} » (n° {{.Details.clone_id}}). Nous vous préviendrons dès qu'il sera prêt.
+`,
+			HTML: htmlLayout(`<p>Bonjour {{.Recipient.Name}},</p>
+<p>Nous avons lancé l'entraînement de votre clone vocal <strong>{{.Details.name}}</strong> (n° {{.Details.clone_id}}). Nous vous préviendrons dès qu'il sera prêt.</p>
+`, `Vous pouvez désactiver ces e-mails dans vos préférences de notification. Des questions ? Écrivez à {{.Brand.SupportEmail}}.`),
+		},
+		events.TypeFileUploaded: {
+			Subject: `{{.Details.filename}} a été envoyé sur {{.Brand.Name}}`,
+			Text: `Bonjour {{.Recipient.Name}},
+
+{{.Details.filename}} ({{.Details.size}} octets) a été envoyé sur votre compte.
+`,
+			HTML: htmlLayout(`<p>Bonjour {{.Recipient.Name}},</p>
+<p><strong>{{.Details.filename}}</strong> ({{.Details.size}} octets) a été envoyé sur votre compte.</p>
+`, `Vous pouvez désactiver ces e-mails dans vos préférences de notification. Des questions ? Écrivez à {{.Brand.SupportEmail}}.`),
+		},
+	},
+	"de": {
+		events.TypeCloneCreated: {
+			Subject: `Dein Stimmklon „{{.Details.name}}“ wird trainiert`,
+			Text: `Hallo {{.Recipient.Name}},
+
+wir haben mit dem Training deines Stimmklons „{{.Details.name}}“ (#{{.Details.clone_id}}) begonnen. Wir melden uns, sobald er bereit ist.
+`,
+			HTML: htmlLayout(`<p>Hallo {{.Recipient.Name}},</p>
+<p>wir haben mit dem Training deines Stimmklons <strong>{{.Details.name}}</strong> (#{{.Details.clone_id}}) begonnen. Wir melden uns, sobald er bereit ist.</p>
+`, `Du kannst diese E-Mails in deinen Benachrichtigungseinstellungen abschalten. Fragen? Schreib an {{.Brand.SupportEmail}}.`),
+		},
+		events.TypeFileUploaded: {
+			Subject: `{{.Details.filename}} wurde zu {{.Brand.Name}} hochgeladen`,
+			Text: `Hallo {{.Recipient.Name}},
+
+{{.Details.filename}} ({{.Details.size}} Bytes) wurde in dein Konto hochgeladen.
+`,
+			HTML: htmlLayout(`<p>Hallo {{.Recipient.Name}},</p>
+<p><strong>{{.Details.filename}}</strong> ({{.Details.size}} Bytes) wurde in dein Konto hochgeladen.</p>
+`, `Du kannst diese E-Mails in deinen Benachrichtigungseinstellungen abschalten. Fragen? Schreib an {{.Brand.SupportEmail}}.`),
+		},
+	},
+	"pt": {
+		events.TypeCloneCreated: {
+			Subject: `Seu clone de voz "{{.Details.name}}" está em treinamento`,
+			Text: `Olá, {{.Recipient.Name}},
+
+Começamos a treinar seu clone de voz "{{.Details.name}}" (#{{.Details.clone_id}}). Avisaremos quando estiver pronto.
+`,
+			HTML: htmlLayout(`<p>Olá, {{.Recipient.Name}},</p>
+<p>Começamos a treinar seu clone de voz <strong>{{.Details.name}}</strong> (#{{.Details.clone_id}}). Avisaremos quando estiver pronto.</p>
+`, `Você pode desativar estes e-mails nas suas preferências de notificação. Dúvidas? Escreva para {{.Brand.SupportEmail}}.`),
+		},
+		events.TypeFileUploaded: {
+			Subject: `{{.Details.filename}} foi enviado para {{.Brand.Name}}`,
+			Text: `Olá, {{.Recipient.Name}},
+
+{{.Details.filename}} ({{.Details.size}} bytes) foi enviado para sua conta.
+`,
+			HTML: htmlLayout(`<p>Olá, {{.Recipient.Name}},</p>
+<p><strong>{{.Details.filename}}</strong> ({{.Details.size}} bytes) foi enviado para sua conta.</p>
+`, `Você pode desativar estes e-mails nas suas preferências de notificação. Dúvidas? Escreva para {{.Brand.SupportEmail}}.`),
+		},
+	},
+}
+
+// emailTemplate returns the email of an event type in the language of a
+// locale, in English when it has no translation
+func emailTemplate(eventType, userLocale string) mail.Template {
+	if lang, ok := i18n.Match(userLocale); ok {
+		if t, ok := localizedEmailTemplates[lang][eventType]; ok {
+			return t
+		}
+	}
+	return emailTemplates[eventType]
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+	"github.com/voice-cloning/shared/webhooks"
+)
+
+// maxWebhooks bounds the webhooks a user can register
+const maxWebhooks = 10
+
+// webhookEvents are the event types webhooks can subscribe to
+var webhookEvents = []string{
+	events.TypeCloneCreated,
+	events.TypeCloneCompleted,
+	events.TypeCloneFailed,
+	events.TypeFileUploaded,
+	events.TypeSubscriptionChanged,
+}
+
+// Webhook is an endpoint a user registered for some event types. Its secret
+// signs the deliveries to it; it is only shown when the webhook is created.
+type Webhook struct {
+	ID        int            `json:"id" db:"id"`
+	URL       string         `json:"url" db:"url"`
+	Events    pq.StringArray `json:"events" db:"events"`
+	Secret    string         `json:"secret,omitempty" db:"-"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// listWebhooks lists the caller's webhooks
+func (s *NotificationService) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	hooks := []Webhook{}
+	err := s.db.SelectContext(r.Context(), &hooks,
+		"SELECT id, url, events, created_at FROM notification_webhooks WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list webhooks")
+		return
+	}
+	utils.SuccessResponse(w, hooks)
+}
+
+// createWebhook registers an endpoint for some event types, with a new
+// signing secret
+func (s *NotificationService) createWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url" validate:"required"`
+		Events []string `json:"events" validate:"required,min=1"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	for _, event := range req.Events {
+		if !contains(webhookEvents, event) {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "events must be among "+strings.Join(webhookEvents, ", "))
+			return
+		}
+	}
+
+	var count int
+	if err := s.db.GetContext(r.Context(), &count, "SELECT COUNT(*) FROM notification_webhooks WHERE user_id = $1", userID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create webhook")
+		return
+	}
+	if count >= maxWebhooks {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.WebhookLimit, fmt.Sprintf("At most %d webhooks can be registered", maxWebhooks))
+		return
+	}
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create webhook")
+		return
+	}
+	hook := Webhook{URL: req.URL, Events: req.Events, Secret: secret}
+	err = s.db.QueryRowxContext(r.Context(),
+		"INSERT INTO notification_webhooks (user_id, url, events, secret) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		userID, hook.URL, hook.Events, secret).Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create webhook")
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, hook)
+}
+
+// deleteWebhook removes one of the caller's webhooks, with its deliveries
+func (s *NotificationService) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid webhook ID")
+		return
+	}
+
+	res, err := s.db.ExecContext(r.Context(), "DELETE FROM notification_webhooks WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete webhook")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.WebhookNotFound, "Webhook not found")
+		return
+	}
+	utils.SuccessResponse(w, map[string]string{"message": "Webhook deleted successfully"})
+}
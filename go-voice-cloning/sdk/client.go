@@ -0,0 +1,1436 @@
+// Package sdk is a Go client for the Voice Cloning API gateway.
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Clone statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// Client calls the API through the gateway. Set Token, or call Register or
+// Login, before using authenticated endpoints. A Client is safe for
+// concurrent use once its token is set.
+//
+// Requests shed with a 429 or 503 are retried up to MaxRetries times,
+// following the server's retry guidance, as long as the guided wait stays
+// within MaxRetryWait. Set MaxRetries to 0 to handle them yourself.
+type Client struct {
+	BaseURL      string
+	Token        string
+	HTTPClient   *http.Client
+	MaxRetries   int
+	MaxRetryWait time.Duration
+}
+
+// NewClient returns a client for the gateway at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 60 * time.Second},
+		MaxRetries:   DefaultMaxRetries,
+		MaxRetryWait: DefaultMaxRetryWait,
+	}
+}
+
+// APIError is a non-2xx response from the API. Code is the stable,
+// machine-readable kind of the error, such as VOICE_CLONE_NOT_FOUND or
+// QUOTA_EXCEEDED: branch on it rather than on Message, which may be
+// reworded. Details lists the individual problems of errors that have
+// several. Retry is set when the request was shed and may be retried later.
+type APIError struct {
+	StatusCode int            `json:"-"`
+	Code       string         `json:"code,omitempty"`
+	Message    string         `json:"message"`
+	Details    []ErrorDetail  `json:"details,omitempty"`
+	RequestID  string         `json:"-"`
+	Retry      *RetryGuidance `json:"retry,omitempty"`
+}
+
+// ErrorDetail is one problem of a request. Field names the part of the
+// request it is about, such as a field or a file, when there is one.
+type ErrorDetail struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	status := fmt.Sprint(e.StatusCode)
+	if e.Code != "" {
+		status += " " + e.Code
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s %s (request %s)", status, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("%s %s", status, e.Message)
+}
+
+// User is the account returned on authentication
+type User struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthResponse is returned by Register and Login
+type AuthResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      User      `json:"user"`
+}
+
+// Upload is a stored file. ID references it in clone requests and
+// downloads; Filename is the name it was uploaded with. Version counts the
+// file's contents, starting at 1. Checksum is the SHA-256 of the content in
+// hex.
+type Upload struct {
+	ID        string     `json:"id"`
+	Filename  string     `json:"filename"`
+	Size      int64      `json:"size"`
+	Path      string     `json:"path"`
+	Class     string     `json:"class"`
+	Type      string     `json:"type"`
+	Checksum  string     `json:"checksum_sha256"`
+	Folder    string     `json:"folder"`
+	Version   int        `json:"version"`
+	Temporary bool       `json:"temporary"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ErrChecksumMismatch is returned by Download when the content received
+// doesn't match the digest the service sent with it
+var ErrChecksumMismatch = errors.New("sdk: download doesn't match its digest")
+
+// StoredFile is a file as listed by ListFiles. Type, ContentType and
+// Checksum are empty for files stored before they were recorded, and
+// ScanStatus for files that weren't scanned. Quarantined files failed the
+// malware scan and can't be downloaded.
+type StoredFile struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	Type        string     `json:"type"`
+	ContentType string     `json:"content_type"`
+	Checksum    string     `json:"checksum_sha256"`
+	Folder      string     `json:"folder"`
+	ACL         string     `json:"acl"`
+	ScanStatus  string     `json:"scan_status"`
+	Quarantined bool       `json:"quarantined"`
+	Version     int        `json:"version"`
+	Temporary   bool       `json:"temporary"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// FileVersion is a version of a file's content. Current marks the one
+// downloads get by default.
+type FileVersion struct {
+	Version     int       `json:"version"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size_bytes"`
+	ContentType string    `json:"content_type"`
+	Checksum    string    `json:"checksum_sha256"`
+	ScanStatus  string    `json:"scan_status"`
+	Quarantined bool      `json:"quarantined"`
+	CreatedAt   time.Time `json:"created_at"`
+	Current     bool      `json:"current"`
+}
+
+// FileWaveform is the waveform of an audio file, with its format, for
+// drawing it without downloading the file
+type FileWaveform struct {
+	ID       string    `json:"id"`
+	Version  int       `json:"version"`
+	Audio    FileAudio `json:"audio"`
+	Waveform Waveform  `json:"waveform"`
+}
+
+// FileAudio is the format of an audio file. Codec is only set for Ogg files
+// and BitsPerSample for WAV and FLAC files.
+type FileAudio struct {
+	Format        string `json:"format"`
+	Codec         string `json:"codec,omitempty"`
+	Channels      int    `json:"channels"`
+	SampleRate    int    `json:"sample_rate"`
+	BitsPerSample int    `json:"bits_per_sample,omitempty"`
+	DurationMS    int64  `json:"duration_ms"`
+	Size          int64  `json:"size_bytes"`
+}
+
+// Waveform is in audiowaveform's JSON format: Data holds a min, max pair
+// of 8-bit peaks for each run of SamplesPerPixel samples, mixed to mono.
+type Waveform struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"`
+	Data            []int `json:"data"`
+}
+
+// Pagination is the position of a page within a listing. Pass NextCursor
+// to get the next page; it's empty on the last one.
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Folder is one of your folders, with the number and size of the files
+// directly in it
+type Folder struct {
+	Path      string    `json:"path"`
+	Files     int       `json:"files"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// File ACLs: who other than its owner can read a file
+const (
+	ACLPrivate    = "private"
+	ACLShared     = "shared"
+	ACLPublicRead = "public-read"
+)
+
+// FileACL is who can read a file: its ACL and the users it's shared with
+type FileACL struct {
+	ID    string `json:"id"`
+	ACL   string `json:"acl"`
+	Users []int  `json:"users"`
+}
+
+// FileList is a page of ListFiles
+type FileList struct {
+	Data       []StoredFile `json:"data"`
+	Pagination Pagination   `json:"pagination"`
+}
+
+// CloneList is a page of ListClones
+type CloneList struct {
+	Data       []Clone    `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// StorageUsage is your stored files per quota class. A LimitBytes or
+// RetentionDays of 0 is unlimited.
+type StorageUsage struct {
+	Samples    ClassUsage `json:"samples"`
+	Outputs    ClassUsage `json:"outputs"`
+	TotalBytes int64      `json:"total_bytes"`
+}
+
+// ClassUsage is the usage of one quota class
+type ClassUsage struct {
+	Files         int   `json:"files"`
+	Bytes         int64 `json:"bytes"`
+	LimitBytes    int64 `json:"limit_bytes"`
+	RetentionDays int   `json:"retention_days"`
+}
+
+// UploadSession is a resumable upload. Offset is how many bytes of the file
+// have been received; GetUploadSession also reports it as a Percent of Size.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Class     string    `json:"class"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	Percent   int       `json:"percent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DirectUpload is an upload sent straight to the storage bucket: PUT the
+// file to UploadURL before URLExpiresAt, then complete it.
+type DirectUpload struct {
+	ID           string    `json:"id"`
+	Filename     string    `json:"filename"`
+	Class        string    `json:"class"`
+	Size         int64     `json:"size"`
+	Method       string    `json:"method"`
+	UploadURL    string    `json:"upload_url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// PresignedURL is a link that downloads a stored file without a token until
+// it expires. URL is relative to the gateway.
+type PresignedURL struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateCloneRequest starts a clone job from uploaded samples. Set
+// SourceFiles to the IDs of several samples to train on, or SourceFile for
+// one. Sources are trained on at their current version unless SourceVersions
+// pins an earlier one. Model,
+// Language and Locale must be among the Capabilities. Set IdempotencyKey to
+// a unique value per clone so retrying CreateClone, after a timeout for
+// instance, can't create the clone twice.
+type CreateCloneRequest struct {
+	Name           string                 `json:"name"`
+	SourceFile     string                 `json:"source_file,omitempty"`
+	SourceFiles    []string               `json:"source_files,omitempty"`
+	SourceVersions map[string]int         `json:"source_versions,omitempty"`
+	Description    string                 `json:"description,omitempty"`
+	Tags           []string               `json:"tags,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	CallbackURL    string                 `json:"callback_url,omitempty"`
+	Priority       string                 `json:"priority,omitempty"`
+	Model          string                 `json:"model,omitempty"`
+	Language       string                 `json:"language,omitempty"`
+	Locale         string                 `json:"locale,omitempty"`
+	ProcessAfter   *time.Time             `json:"process_after,omitempty"`
+	IdempotencyKey string                 `json:"-"`
+}
+
+// CloneJob is the accepted clone job
+type CloneJob struct {
+	ID           int        `json:"id"`
+	Status       string     `json:"status"`
+	Message      string     `json:"message"`
+	ProcessAfter *time.Time `json:"process_after,omitempty"`
+}
+
+// Clone is a voice clone. ExpiresAt is set once it is due to expire from
+// inactivity.
+type Clone struct {
+	ID              int                    `json:"id"`
+	Name            string                 `json:"name"`
+	Description     string                 `json:"description"`
+	Tags            []string               `json:"tags"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	Status          string                 `json:"status"`
+	SourceFile      string                 `json:"source_file"`
+	SourceFiles     []string               `json:"source_files,omitempty"`
+	SourceVersions  map[string]int         `json:"source_versions,omitempty"`
+	OutputFile      string                 `json:"output_file,omitempty"`
+	PreviewFile     string                 `json:"preview_file,omitempty"`
+	Priority        string                 `json:"priority"`
+	Visibility      string                 `json:"visibility"`
+	Progress        int                    `json:"progress"`
+	Stage           string                 `json:"stage,omitempty"`
+	ErrorCode       string                 `json:"error_code,omitempty"`
+	ErrorMessage    string                 `json:"error_message,omitempty"`
+	ModelVersion    int                    `json:"model_version,omitempty"`
+	Quality         *Quality               `json:"quality,omitempty"`
+	ProcessAfter    *time.Time             `json:"process_after,omitempty"`
+	ExpiresAt       *time.Time             `json:"expires_at,omitempty"`
+	RetentionExempt bool                   `json:"retention_exempt,omitempty"`
+	RetryCount      int                    `json:"retry_count"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	CompletedAt     *time.Time             `json:"completed_at,omitempty"`
+	ArchivedAt      *time.Time             `json:"archived_at,omitempty"`
+	DeletedAt       *time.Time             `json:"deleted_at,omitempty"`
+}
+
+// Quality rates a trained model: how alike its preview sounds to the
+// sample, from 0 to 1, and how clean and long the sample's speech is.
+// Warnings name the metrics that fell short.
+type Quality struct {
+	SimilarityScore    *float64 `json:"similarity_score,omitempty"`
+	SNRDB              *float64 `json:"snr_db,omitempty"`
+	UsableAudioSeconds *float64 `json:"usable_audio_seconds,omitempty"`
+	TotalAudioSeconds  *float64 `json:"total_audio_seconds,omitempty"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// CloneStatus is the progress of a clone job
+type CloneStatus struct {
+	Status       string     `json:"status"`
+	Progress     int        `json:"progress"`
+	Stage        string     `json:"stage,omitempty"`
+	ErrorCode    string     `json:"error_code,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	ProcessAfter *time.Time `json:"process_after,omitempty"`
+}
+
+// Done reports whether the job reached a final status
+func (s CloneStatus) Done() bool {
+	return s.Status == StatusCompleted || s.Status == StatusFailed || s.Status == StatusCancelled
+}
+
+// SynthesisRequest is text, or an SSML <speak> document, to speak with a
+// clone's voice. ModelVersion pins a model version; by default the clone's
+// current one speaks.
+type SynthesisRequest struct {
+	Text         string `json:"text,omitempty"`
+	SSML         string `json:"ssml,omitempty"`
+	ModelVersion int    `json:"model_version,omitempty"`
+}
+
+// Synthesis is a speech synthesis job
+type Synthesis struct {
+	ID           int        `json:"id"`
+	CloneID      int        `json:"clone_id"`
+	Text         string     `json:"text,omitempty"`
+	SSML         string     `json:"ssml,omitempty"`
+	ModelVersion int        `json:"model_version,omitempty"`
+	Status       string     `json:"status"`
+	OutputFile   string     `json:"output_file,omitempty"`
+	DurationMS   int64      `json:"duration_ms,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	DownloadURL  string     `json:"download_url,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// Capabilities lists the models clones can be trained with and the
+// languages and accents they speak
+type Capabilities struct {
+	Models []struct {
+		Name        string               `json:"name"`
+		Description string               `json:"description,omitempty"`
+		Languages   []CapabilityLanguage `json:"languages"`
+	} `json:"models"`
+	Languages     []CapabilityLanguage `json:"languages"`
+	OutputFormats []string             `json:"output_formats"`
+}
+
+// CapabilityLanguage is a supported language. Models is set in
+// Capabilities.Languages, listing the models that speak it.
+type CapabilityLanguage struct {
+	Tag     string   `json:"tag"`
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+	Models  []string `json:"models,omitempty"`
+}
+
+// Model is a version of a clone's trained model
+type Model struct {
+	CloneID        int            `json:"clone_id"`
+	Version        int            `json:"version"`
+	Status         string         `json:"status"`
+	SourceFiles    []string       `json:"source_files"`
+	SourceVersions map[string]int `json:"source_versions,omitempty"`
+	Progress       int            `json:"progress"`
+	ErrorCode      string         `json:"error_code,omitempty"`
+	ErrorMessage   string         `json:"error_message,omitempty"`
+	Quality        *Quality       `json:"quality,omitempty"`
+	PreviewFile    string         `json:"preview_file,omitempty"`
+	Current        bool           `json:"current"`
+	CreatedAt      time.Time      `json:"created_at"`
+	CompletedAt    *time.Time     `json:"completed_at,omitempty"`
+}
+
+// Analytics summarizes the account's jobs over a window of days ending
+// today (UTC): daily figures, their totals, and the number of clones in
+// each status
+type Analytics struct {
+	From   time.Time        `json:"from"`
+	To     time.Time        `json:"to"`
+	Days   []AnalyticsDay   `json:"days"`
+	Totals AnalyticsFigures `json:"totals"`
+	Clones map[string]int   `json:"clones"`
+}
+
+// AnalyticsDay is one day of Analytics, dated YYYY-MM-DD
+type AnalyticsDay struct {
+	Date string `json:"date"`
+	AnalyticsFigures
+}
+
+// AnalyticsFigures are the job figures of a day or a window. FailureRate
+// and AvgProcessingSeconds are nil when no clone job finished.
+type AnalyticsFigures struct {
+	Jobs                 int      `json:"jobs"`
+	Completed            int      `json:"completed"`
+	Failed               int      `json:"failed"`
+	FailureRate          *float64 `json:"failure_rate"`
+	AvgProcessingSeconds *float64 `json:"avg_processing_seconds"`
+	SynthesisSeconds     float64  `json:"synthesis_seconds"`
+}
+
+// ProductEvent is an event of your app, such as a screen viewed, tracked
+// with TrackEvents. Name is lowercase letters, digits and underscores,
+// starting with a letter. OccurredAt, within the last 7 days, defaults to
+// when the API receives the event.
+type ProductEvent struct {
+	Name       string                 `json:"name"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	OccurredAt *time.Time             `json:"occurred_at,omitempty"`
+}
+
+// EventStats are your totals of each product event over a range of dates
+// (YYYY-MM-DD, inclusive), and their series by Interval
+type EventStats struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Interval string       `json:"interval"`
+	Totals   []EventTotal `json:"totals"`
+	Series   []EventPoint `json:"series"`
+}
+
+// EventTotal is how many times an event happened
+type EventTotal struct {
+	Name   string `json:"name"`
+	Events int64  `json:"events"`
+}
+
+// EventPoint is an event's total within the bucket of a series starting at
+// Bucket
+type EventPoint struct {
+	Bucket time.Time `json:"bucket"`
+	Name   string    `json:"name"`
+	Events int64     `json:"events"`
+}
+
+// SearchResults are the matches of Search, most relevant first.
+// Unavailable lists the types that couldn't be searched this time.
+type SearchResults struct {
+	Query       string         `json:"query"`
+	Results     []SearchResult `json:"results"`
+	Unavailable []string       `json:"unavailable"`
+}
+
+// SearchResult is a clone or a file matching a search: Type is "clone",
+// with Clone set, or "file", with File set
+type SearchResult struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Rank  float64         `json:"rank"`
+	Item  json.RawMessage `json:"item"`
+	Clone *Clone          `json:"-"`
+	File  *StoredFile     `json:"-"`
+}
+
+// maxEventBatch is the most events sent at once
+const maxEventBatch = 500
+
+// Register creates an account and authenticates the client with it
+func (c *Client) Register(ctx context.Context, email, username, password string) (*AuthResponse, error) {
+	return c.authenticate(ctx, "/api/auth/register", map[string]string{
+		"email":    email,
+		"username": username,
+		"password": password,
+	})
+}
+
+// Login authenticates the client
+func (c *Client) Login(ctx context.Context, email, password string) (*AuthResponse, error) {
+	return c.authenticate(ctx, "/api/auth/login", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+}
+
+func (c *Client) authenticate(ctx context.Context, path string, body map[string]string) (*AuthResponse, error) {
+	var auth AuthResponse
+	if err := c.do(ctx, http.MethodPost, path, body, &auth); err != nil {
+		return nil, err
+	}
+	c.Token = auth.Token
+	return &auth, nil
+}
+
+// Upload stores content as filename. fileType selects the upload policy and
+// defaults to audio_sample when empty.
+func (c *Client) Upload(ctx context.Context, filename, fileType string, content io.Reader) (*Upload, error) {
+	return c.sendFile(ctx, http.MethodPost, "/api/storage/upload", filename, fileType, "", 0, content)
+}
+
+// UploadToFolder uploads a file into one of your folders, created if it
+// doesn't exist
+func (c *Client) UploadToFolder(ctx context.Context, folder, filename, fileType string, content io.Reader) (*Upload, error) {
+	return c.sendFile(ctx, http.MethodPost, "/api/storage/upload", filename, fileType, folder, 0, content)
+}
+
+// UploadTemporary uploads a file that is removed once ttl has passed. A
+// zero ttl uses the service's default for temporary files.
+func (c *Client) UploadTemporary(ctx context.Context, filename, fileType string, ttl time.Duration, content io.Reader) (*Upload, error) {
+	if ttl <= 0 {
+		ttl = -1
+	}
+	return c.sendFile(ctx, http.MethodPost, "/api/storage/upload", filename, fileType, "", ttl, content)
+}
+
+// ReplaceFile uploads new content for a stored file, which keeps its ID and
+// gets the next version. The previous content stays available as an
+// earlier version.
+func (c *Client) ReplaceFile(ctx context.Context, id, filename string, content io.Reader) (*Upload, error) {
+	return c.sendFile(ctx, http.MethodPut, "/api/storage/files/"+url.PathEscape(id), filename, "", "", 0, content)
+}
+
+// ListFileVersions lists the versions of a file, newest first
+func (c *Client) ListFileVersions(ctx context.Context, id string) ([]FileVersion, error) {
+	var versions struct {
+		Versions []FileVersion `json:"versions"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/storage/files/"+url.PathEscape(id)+"/versions", nil, &versions); err != nil {
+		return nil, err
+	}
+	return versions.Versions, nil
+}
+
+// RestoreFileVersion makes a copy of an earlier version of a file its
+// latest, returning the new version
+func (c *Client) RestoreFileVersion(ctx context.Context, id string, version int) (int, error) {
+	var restored struct {
+		Version int `json:"version"`
+	}
+	path := fmt.Sprintf("/api/storage/files/%s/versions/%d/restore", url.PathEscape(id), version)
+	if err := c.do(ctx, http.MethodPost, path, nil, &restored); err != nil {
+		return 0, err
+	}
+	return restored.Version, nil
+}
+
+// GetWaveform returns the waveform of the current version of an audio
+// file. A positive length resamples it to at most that many peaks.
+func (c *Client) GetWaveform(ctx context.Context, id string, length int) (*FileWaveform, error) {
+	path := "/api/storage/files/" + url.PathEscape(id) + "/waveform"
+	if length > 0 {
+		path += fmt.Sprintf("?length=%d", length)
+	}
+	var waveform FileWaveform
+	if err := c.do(ctx, http.MethodGet, path, nil, &waveform); err != nil {
+		return nil, err
+	}
+	return &waveform, nil
+}
+
+// sendFile sends content as the file of an upload form, followed by its
+// SHA-256, which the service verifies. A nonzero ttl marks the file
+// temporary, kept for ttl when positive.
+func (c *Client) sendFile(ctx context.Context, method, path, filename, fileType, folder string, ttl time.Duration, content io.Reader) (*Upload, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	if fileType != "" {
+		if err := form.WriteField("type", fileType); err != nil {
+			return nil, err
+		}
+	}
+	if folder != "" {
+		if err := form.WriteField("folder", folder); err != nil {
+			return nil, err
+		}
+	}
+	if ttl != 0 {
+		if err := form.WriteField("temporary", "true"); err != nil {
+			return nil, err
+		}
+	}
+	if ttl > 0 {
+		seconds := (ttl + time.Second - 1) / time.Second
+		if err := form.WriteField("ttl", fmt.Sprint(int64(seconds))); err != nil {
+			return nil, err
+		}
+	}
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(part, hash), content); err != nil {
+		return nil, err
+	}
+	if err := form.WriteField("checksum_sha256", hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, method, path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var upload Upload
+	if err := c.send(req, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// CreateUploadSession starts a resumable upload of a file of size bytes
+func (c *Client) CreateUploadSession(ctx context.Context, filename, fileType, contentType string, size int64) (*UploadSession, error) {
+	var session UploadSession
+	body := map[string]interface{}{
+		"filename":     filename,
+		"size":         size,
+		"type":         fileType,
+		"content_type": contentType,
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/storage/uploads", body, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUploadSession returns a resumable upload, whose Offset is where to
+// resume sending and how much has been received
+func (c *Client) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	var session UploadSession
+	if err := c.do(ctx, http.MethodGet, "/api/storage/uploads/"+url.PathEscape(id), nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UploadChunk sends the chunk of a resumable upload that starts at offset
+// and returns the session's new offset
+func (c *Client) UploadChunk(ctx context.Context, id string, offset int64, chunk io.Reader) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/storage/uploads/"+url.PathEscape(id), chunk)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", fmt.Sprint(offset))
+
+	var progress struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := c.send(req, &progress); err != nil {
+		return 0, err
+	}
+	return progress.Offset, nil
+}
+
+// ResumeUpload sends the rest of content, from the session's offset on, in
+// chunks of chunkSize bytes (8 MB when zero) and completes the upload.
+// After a failure, call it again with the same session to continue where the
+// upload stopped.
+func (c *Client) ResumeUpload(ctx context.Context, id string, content io.ReaderAt, chunkSize int64) (*Upload, error) {
+	if chunkSize <= 0 {
+		chunkSize = 8 << 20
+	}
+	session, err := c.GetUploadSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for offset := session.Offset; offset < session.Size; {
+		n := chunkSize
+		if remaining := session.Size - offset; n > remaining {
+			n = remaining
+		}
+		if offset, err = c.UploadChunk(ctx, id, offset, io.NewSectionReader(content, offset, n)); err != nil {
+			return nil, err
+		}
+	}
+	return c.CompleteUpload(ctx, id)
+}
+
+// CompleteUpload stores a fully sent resumable upload
+func (c *Client) CompleteUpload(ctx context.Context, id string) (*Upload, error) {
+	var upload Upload
+	if err := c.do(ctx, http.MethodPost, "/api/storage/uploads/"+url.PathEscape(id)+"/complete", nil, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// CancelUpload discards a resumable upload
+func (c *Client) CancelUpload(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/storage/uploads/"+url.PathEscape(id), nil, nil)
+}
+
+// CreateDirectUpload starts an upload of a file of size bytes straight to
+// the storage bucket, when the service uses S3
+func (c *Client) CreateDirectUpload(ctx context.Context, filename, fileType, contentType string, size int64) (*DirectUpload, error) {
+	var upload DirectUpload
+	body := map[string]interface{}{
+		"filename":     filename,
+		"size":         size,
+		"type":         fileType,
+		"content_type": contentType,
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/storage/uploads/direct", body, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// UploadDirect uploads size bytes of content straight to the storage
+// bucket and stores them as a file. The bucket is sent the content without
+// the client's token or retries.
+func (c *Client) UploadDirect(ctx context.Context, filename, fileType string, size int64, content io.Reader) (*Upload, error) {
+	direct, err := c.CreateDirectUpload(ctx, filename, fileType, "", size)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, direct.Method, direct.UploadURL, content)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: "direct upload to storage failed: " + http.StatusText(resp.StatusCode)}
+	}
+	return c.CompleteDirectUpload(ctx, direct.ID)
+}
+
+// CompleteDirectUpload stores a file sent to its direct upload URL
+func (c *Client) CompleteDirectUpload(ctx context.Context, id string) (*Upload, error) {
+	var upload Upload
+	if err := c.do(ctx, http.MethodPost, "/api/storage/uploads/direct/"+url.PathEscape(id)+"/complete", nil, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// CancelDirectUpload discards a direct upload
+func (c *Client) CancelDirectUpload(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/storage/uploads/direct/"+url.PathEscape(id), nil, nil)
+}
+
+// CreateClone queues a clone job
+func (c *Client) CreateClone(ctx context.Context, clone CreateCloneRequest) (*CloneJob, error) {
+	data, err := json.Marshal(clone)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/voice/clones", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if clone.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", clone.IdempotencyKey)
+	}
+
+	var job CloneJob
+	if err := c.send(req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CreateCloneDirect uploads content as the clone's only source and queues
+// the clone job in one request. The clone's SourceFile and SourceFiles are
+// ignored; if the clone is rejected, the upload is discarded.
+func (c *Client) CreateCloneDirect(ctx context.Context, clone CreateCloneRequest, filename string, content io.Reader) (*CloneJob, error) {
+	clone.SourceFile, clone.SourceFiles = "", nil
+	metadata, err := json.Marshal(clone)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	if err := form.WriteField("metadata", string(metadata)); err != nil {
+		return nil, err
+	}
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/voice/clones/direct", &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var job CloneJob
+	if err := c.send(req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Capabilities lists the supported models, languages and accents
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	var caps Capabilities
+	if err := c.do(ctx, http.MethodGet, "/api/voice/capabilities", nil, &caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// GetClone fetches a clone
+func (c *Client) GetClone(ctx context.Context, id int) (*Clone, error) {
+	var clone Clone
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d", id), nil, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// GetCloneStatus fetches the status and progress of a clone job
+func (c *Client) GetCloneStatus(ctx context.Context, id int) (*CloneStatus, error) {
+	var status CloneStatus
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d/status", id), nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// CloneQuery filters and orders ListClones. Zero fields don't filter.
+// Status is comma-separated statuses. Sort is created_at, updated_at or
+// name, prefixed with - for descending; the default is -created_at. A
+// Cursor only continues a listing with the same Sort.
+type CloneQuery struct {
+	Status   string
+	Name     string // substring of the name
+	Tag      string
+	Archived bool // list archived clones instead of the others
+	Deleted  bool // list deleted clones awaiting purge instead
+	Sort     string
+	Limit    int
+	Cursor   string
+}
+
+// ListClones lists a page of your clones matching the query
+func (c *Client) ListClones(ctx context.Context, q CloneQuery) (*CloneList, error) {
+	query := url.Values{}
+	for param, value := range map[string]string{"status": q.Status, "name": q.Name, "tag": q.Tag, "sort": q.Sort, "cursor": q.Cursor} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	if q.Archived {
+		query.Set("archived", "true")
+	}
+	if q.Deleted {
+		query.Set("deleted", "true")
+	}
+	if q.Limit > 0 {
+		query.Set("limit", fmt.Sprint(q.Limit))
+	}
+	path := "/api/voice/clones"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var clones CloneList
+	if err := c.do(ctx, http.MethodGet, path, nil, &clones); err != nil {
+		return nil, err
+	}
+	return &clones, nil
+}
+
+// CancelClone cancels a pending or processing clone job
+func (c *Client) CancelClone(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/voice/clones/%d/cancel", id), nil, nil)
+}
+
+// ArchiveClone puts a finished clone away
+func (c *Client) ArchiveClone(ctx context.Context, id int) (*Clone, error) {
+	var clone Clone
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/voice/clones/%d/archive", id), nil, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// DeleteClone moves a clone to the trash. A processing clone is only
+// deleted when force is set, which cancels its job.
+func (c *Client) DeleteClone(ctx context.Context, id int, force bool) error {
+	path := fmt.Sprintf("/api/voice/clones/%d", id)
+	if force {
+		path += "?force=true"
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// Retrain queues a new model version of a completed clone, trained on
+// sourceFiles or, when empty, the clone's current sources
+func (c *Client) Retrain(ctx context.Context, cloneID int, sourceFiles []string) (*Model, error) {
+	var model Model
+	body := map[string][]string{"source_files": sourceFiles}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/voice/clones/%d/retrain", cloneID), body, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// ListFiles lists a page of your stored files, newest first. name filters
+// by a substring of the filename; limit 0 uses the server's default page
+// size, and cursor is the previous page's NextCursor.
+func (c *Client) ListFiles(ctx context.Context, name string, limit int, cursor string) (*FileList, error) {
+	return c.SearchFiles(ctx, FileQuery{Name: name, Limit: limit, Cursor: cursor})
+}
+
+// FileQuery filters and orders ListFiles. Zero fields don't filter. Sort is
+// created_at, name or size, prefixed with - for descending; the default is
+// -created_at. A Cursor only continues a listing with the same Sort.
+type FileQuery struct {
+	Name          string // substring of the filename
+	Prefix        string // start of the filename
+	Folder        string // files directly in it, "/" for the root
+	Recursive     bool   // Folder includes the folders under it
+	Type          string
+	ContentType   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Sort          string
+	Limit         int
+	Cursor        string
+}
+
+// SearchFiles lists a page of your stored files matching the query
+func (c *Client) SearchFiles(ctx context.Context, q FileQuery) (*FileList, error) {
+	query := url.Values{}
+	for param, value := range map[string]string{
+		"name": q.Name, "prefix": q.Prefix, "folder": q.Folder, "type": q.Type, "content_type": q.ContentType, "sort": q.Sort, "cursor": q.Cursor,
+	} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	if q.Recursive {
+		query.Set("recursive", "true")
+	}
+	if !q.CreatedAfter.IsZero() {
+		query.Set("created_after", q.CreatedAfter.Format(time.RFC3339))
+	}
+	if !q.CreatedBefore.IsZero() {
+		query.Set("created_before", q.CreatedBefore.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", fmt.Sprint(q.Limit))
+	}
+	path := "/api/storage/files"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var files FileList
+	if err := c.do(ctx, http.MethodGet, path, nil, &files); err != nil {
+		return nil, err
+	}
+	return &files, nil
+}
+
+// Search finds your clones and files whose names, tags, metadata,
+// descriptions or folders contain words starting with each word of query.
+// typ limits it to "clone" or "file" results; limit 0 uses the server's
+// default.
+func (c *Client) Search(ctx context.Context, query, typ string, limit int) (*SearchResults, error) {
+	params := url.Values{"q": {query}}
+	if typ != "" {
+		params.Set("type", typ)
+	}
+	if limit > 0 {
+		params.Set("limit", fmt.Sprint(limit))
+	}
+	var results SearchResults
+	if err := c.do(ctx, http.MethodGet, "/api/search?"+params.Encode(), nil, &results); err != nil {
+		return nil, err
+	}
+	for i := range results.Results {
+		result := &results.Results[i]
+		var err error
+		switch result.Type {
+		case "clone":
+			result.Clone = &Clone{}
+			err = json.Unmarshal(result.Item, result.Clone)
+		case "file":
+			result.File = &StoredFile{}
+			err = json.Unmarshal(result.Item, result.File)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid search result: %w", err)
+		}
+	}
+	return &results, nil
+}
+
+// CreateFolder creates one of your folders, and its parents
+func (c *Client) CreateFolder(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodPost, "/api/storage/folders", map[string]string{"path": path}, nil)
+}
+
+// ListFolders lists your folders directly under parent, "" for the root
+func (c *Client) ListFolders(ctx context.Context, parent string) ([]Folder, error) {
+	path := "/api/storage/folders"
+	if parent != "" {
+		path += "?parent=" + url.QueryEscape(parent)
+	}
+	var folders struct {
+		Folders []Folder `json:"folders"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &folders); err != nil {
+		return nil, err
+	}
+	return folders.Folders, nil
+}
+
+// DeleteFolder deletes one of your folders. A folder that isn't empty is
+// only deleted when recursive, which deletes the files and folders in it.
+func (c *Client) DeleteFolder(ctx context.Context, path string, recursive bool) error {
+	query := url.Values{"path": {path}}
+	if recursive {
+		query.Set("recursive", "true")
+	}
+	return c.do(ctx, http.MethodDelete, "/api/storage/folders?"+query.Encode(), nil, nil)
+}
+
+// MoveFile moves a file to one of your folders, "" for the root
+func (c *Client) MoveFile(ctx context.Context, id, folder string) error {
+	return c.do(ctx, http.MethodPut, "/api/storage/files/"+url.PathEscape(id)+"/folder", map[string]string{"folder": folder}, nil)
+}
+
+// FileACL fetches who can read one of your files
+func (c *Client) FileACL(ctx context.Context, id string) (*FileACL, error) {
+	var acl FileACL
+	if err := c.do(ctx, http.MethodGet, "/api/storage/files/"+url.PathEscape(id)+"/acl", nil, &acl); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// SetFileACL sets a file's ACL. Users, when not nil, replace the users the
+// file is shared with.
+func (c *Client) SetFileACL(ctx context.Context, id, acl string, users []int) (*FileACL, error) {
+	var result FileACL
+	body := map[string]interface{}{"acl": acl, "users": users}
+	if err := c.do(ctx, http.MethodPut, "/api/storage/files/"+url.PathEscape(id)+"/acl", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ShareFile lets a user read one of your files. Sharing a private file
+// makes it shared.
+func (c *Client) ShareFile(ctx context.Context, id string, userID int) (*FileACL, error) {
+	var acl FileACL
+	if err := c.do(ctx, http.MethodPost, "/api/storage/files/"+url.PathEscape(id)+"/shares", map[string]int{"user_id": userID}, &acl); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// UnshareFile stops sharing a file with a user
+func (c *Client) UnshareFile(ctx context.Context, id string, userID int) (*FileACL, error) {
+	var acl FileACL
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/storage/files/%s/shares/%d", url.PathEscape(id), userID), nil, &acl); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// StorageUsage fetches your storage usage and quotas
+func (c *Client) StorageUsage(ctx context.Context) (*StorageUsage, error) {
+	var usage StorageUsage
+	if err := c.do(ctx, http.MethodGet, "/api/storage/usage", nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ListModels lists the model versions of a clone, newest first
+func (c *Client) ListModels(ctx context.Context, cloneID int) ([]Model, error) {
+	var models []Model
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d/models", cloneID), nil, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// Analytics fetches the account's job figures for the last days days, or
+// the last 30 when days is 0
+func (c *Client) Analytics(ctx context.Context, days int) (*Analytics, error) {
+	path := "/api/voice/clones/analytics"
+	if days > 0 {
+		path += fmt.Sprintf("?days=%d", days)
+	}
+	var analytics Analytics
+	if err := c.do(ctx, http.MethodGet, path, nil, &analytics); err != nil {
+		return nil, err
+	}
+	return &analytics, nil
+}
+
+// TrackEvents records product events of your app as yours, in batches of
+// up to 500. Events of a batch are accepted or refused together.
+func (c *Client) TrackEvents(ctx context.Context, events ...ProductEvent) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > maxEventBatch {
+			n = maxEventBatch
+		}
+		body := map[string]interface{}{"events": events[:n]}
+		if err := c.do(ctx, http.MethodPost, "/api/analytics/events", body, nil); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// EventStats fetches your product event totals and daily series from from
+// to to (YYYY-MM-DD dates, inclusive), or over the last 30 days when both
+// are empty
+func (c *Client) EventStats(ctx context.Context, from, to string) (*EventStats, error) {
+	query := url.Values{}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+	path := "/api/analytics/stats"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var stats EventStats
+	if err := c.do(ctx, http.MethodGet, path, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Synthesize queues speech generation with a completed clone
+func (c *Client) Synthesize(ctx context.Context, cloneID int, req SynthesisRequest) (*Synthesis, error) {
+	var synthesis Synthesis
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/voice/clones/%d/synthesize", cloneID), req, &synthesis); err != nil {
+		return nil, err
+	}
+	return &synthesis, nil
+}
+
+// GetSynthesis fetches a synthesis job
+func (c *Client) GetSynthesis(ctx context.Context, cloneID, id int) (*Synthesis, error) {
+	var synthesis Synthesis
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d/syntheses/%d", cloneID, id), nil, &synthesis); err != nil {
+		return nil, err
+	}
+	return &synthesis, nil
+}
+
+// StreamSynthesis writes a synthesis job's WAV audio to w as it is
+// produced and returns the number of bytes written. Call it right after
+// Synthesize; for a job finished a while ago it reads the stored output.
+func (c *Client) StreamSynthesis(ctx context.Context, cloneID, id int, w io.Writer) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d/syntheses/%d/stream", cloneID, id), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, decodeError(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// Download writes the stored file with the ID to w and returns the number of
+// bytes written. If the service sent the content's digest, it's checked
+// once the content is written, and ErrChecksumMismatch returned if it
+// doesn't match.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/storage/download/"+url.PathEscape(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, decodeError(resp)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), resp.Body)
+	if err != nil {
+		return n, err
+	}
+	if expected := digestSHA256(resp.Header.Get("Digest")); expected != nil && !bytes.Equal(expected, hash.Sum(nil)) {
+		return n, ErrChecksumMismatch
+	}
+	return n, nil
+}
+
+// digestSHA256 returns the SHA-256 in a Digest header, nil if it has none
+func digestSHA256(header string) []byte {
+	for _, digest := range strings.Split(header, ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(digest), "=")
+		if !ok || !strings.EqualFold(alg, "sha-256") {
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == sha256.Size {
+			return sum
+		}
+	}
+	return nil
+}
+
+// DownloadArchive writes the stored files with the IDs to w as one ZIP
+// archive, returning the bytes written. Files with the same name are
+// numbered in the archive.
+func (c *Client) DownloadArchive(ctx context.Context, ids []string, w io.Writer) (int64, error) {
+	body, err := json.Marshal(map[string][]string{"files": ids})
+	if err != nil {
+		return 0, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/storage/files/archive", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, decodeError(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// ResumeDownload writes the stored file with the ID from offset on to w, to
+// continue a download that was interrupted after offset bytes
+func (c *Client) ResumeDownload(ctx context.Context, id string, offset int64, w io.Writer) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/storage/download/"+url.PathEscape(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, decodeError(resp)
+	}
+	if resp.StatusCode != http.StatusPartialContent && offset > 0 {
+		return 0, fmt.Errorf("download of %s can't resume: server sent the whole file", id)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// Presign issues a link to the stored file with the ID that is valid for
+// expiresIn, or the server's default when zero
+func (c *Client) Presign(ctx context.Context, id string, expiresIn time.Duration) (*PresignedURL, error) {
+	var link PresignedURL
+	body := map[string]int64{}
+	if expiresIn > 0 {
+		body["expires_in"] = int64(expiresIn / time.Second)
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/storage/files/"+url.PathEscape(id)+"/presign", body, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// do sends body as JSON and decodes the response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	var body envelope
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Path, err)
+	}
+	data := body.Data
+	if len(body.Meta.Pagination) > 0 && string(body.Meta.Pagination) != "null" {
+		// Pages keep their position next to their items
+		data, _ = json.Marshal(map[string]json.RawMessage{"data": body.Data, "pagination": body.Meta.Pagination})
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+// envelope is the body of every JSON response: the result in Data, or
+// what went wrong in Error, with the request ID and a listing's pagination
+// in Meta
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *APIError       `json:"error"`
+	Meta  struct {
+		RequestID  string          `json:"request_id"`
+		Pagination json.RawMessage `json:"pagination"`
+	} `json:"meta"`
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	body := envelope{Error: apiErr}
+	if json.Unmarshal(data, &body) != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	apiErr.RequestID = body.Meta.RequestID
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if apiErr.Retry == nil {
+		apiErr.Retry = retryGuidance(resp)
+	}
+	return apiErr
+}
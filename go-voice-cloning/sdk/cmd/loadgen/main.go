@@ -0,0 +1,129 @@
+// Command loadgen simulates a population of API users against the gateway
+// and reports latency and errors per operation. Each virtual user
+// registers, then repeatedly uploads a sample, creates a clone, polls it to
+// a final status and downloads the output.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+	"github.com/voice-cloning/sdk/internal/loadstats"
+)
+
+// Config of a load test run
+type Config struct {
+	BaseURL        string
+	Users          int
+	Ramp           string
+	RampPeriod     time.Duration
+	Steps          int
+	Duration       time.Duration
+	Iterations     int
+	ThinkTime      time.Duration
+	PollInterval   time.Duration
+	JobTimeout     time.Duration
+	Priority       string
+	SampleFile     string
+	SampleSeconds  int
+	ReportInterval time.Duration
+	RunID          string
+	MaxRetries     int
+
+	sample []byte
+}
+
+func main() {
+	cfg := &Config{}
+	flag.StringVar(&cfg.BaseURL, "url", "http://localhost:8080", "gateway base URL")
+	flag.IntVar(&cfg.Users, "users", 10, "number of virtual users")
+	flag.StringVar(&cfg.Ramp, "ramp", RampLinear, "ramp profile: instant, linear or step")
+	flag.DurationVar(&cfg.RampPeriod, "ramp-period", 30*time.Second, "time over which users are started")
+	flag.IntVar(&cfg.Steps, "steps", 4, "number of batches for the step ramp")
+	flag.DurationVar(&cfg.Duration, "duration", 5*time.Minute, "total run time, including the ramp")
+	flag.IntVar(&cfg.Iterations, "iterations", 0, "clone flows per user, 0 for no limit")
+	flag.DurationVar(&cfg.ThinkTime, "think", 2*time.Second, "pause between a user's clone flows")
+	flag.DurationVar(&cfg.PollInterval, "poll", 2*time.Second, "clone status poll interval")
+	flag.DurationVar(&cfg.JobTimeout, "job-timeout", 5*time.Minute, "how long to wait for a clone job to finish")
+	flag.StringVar(&cfg.Priority, "priority", "", "clone priority tier (default: the plan's highest)")
+	flag.StringVar(&cfg.SampleFile, "sample", "", "audio sample to upload (default: generated silence)")
+	flag.IntVar(&cfg.SampleSeconds, "sample-seconds", 10, "length of the generated sample")
+	flag.DurationVar(&cfg.ReportInterval, "report", 30*time.Second, "interval between interim reports, 0 to disable")
+	flag.StringVar(&cfg.RunID, "run-id", fmt.Sprintf("%x", time.Now().Unix()), "suffix making the run's accounts unique")
+	flag.IntVar(&cfg.MaxRetries, "max-retries", sdk.DefaultMaxRetries, "retries of shed (429/503) requests, following the gateway's guidance")
+	flag.Parse()
+
+	if cfg.Users < 1 {
+		log.Fatal("users must be at least 1")
+	}
+	offsets, err := rampSchedule(cfg.Ramp, cfg.Users, cfg.RampPeriod, cfg.Steps)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.SampleFile != "" {
+		if cfg.sample, err = os.ReadFile(cfg.SampleFile); err != nil {
+			log.Fatalf("Failed to read sample: %v", err)
+		}
+	} else {
+		cfg.sample = silentWAV(cfg.SampleSeconds)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Starting %d users against %s (%s ramp over %s, run %s)",
+		cfg.Users, cfg.BaseURL, cfg.Ramp, cfg.RampPeriod, cfg.RunID)
+
+	stats := loadstats.New(operations, errorClass)
+	if cfg.ReportInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.ReportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					fmt.Printf("\n--- %s elapsed ---\n", stats.Elapsed().Round(time.Second))
+					stats.Summary(os.Stdout)
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i, offset := range offsets {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(started.Add(offset))):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		client := sdk.NewClient(cfg.BaseURL)
+		client.MaxRetries = cfg.MaxRetries
+		user := &virtualUser{id: i, cfg: cfg, stats: stats, client: client}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	fmt.Printf("\n=== Final report (%s) ===\n", stats.Elapsed().Round(time.Second))
+	stats.Summary(os.Stdout)
+}
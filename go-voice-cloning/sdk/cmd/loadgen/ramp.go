@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ramp profiles
+const (
+	RampInstant = "instant" // every user starts at once
+	RampLinear  = "linear"  // users start evenly over the ramp period
+	RampStep    = "step"    // users start in equal batches over the ramp period
+)
+
+// rampSchedule returns the start offset of each of n users
+func rampSchedule(profile string, n int, ramp time.Duration, steps int) ([]time.Duration, error) {
+	offsets := make([]time.Duration, n)
+	switch profile {
+	case RampInstant:
+	case RampLinear:
+		if n > 1 {
+			for i := range offsets {
+				offsets[i] = ramp * time.Duration(i) / time.Duration(n-1)
+			}
+		}
+	case RampStep:
+		if steps < 1 {
+			return nil, fmt.Errorf("steps must be at least 1")
+		}
+		if steps > n {
+			steps = n
+		}
+		for i := range offsets {
+			step := i * steps / n
+			if steps > 1 {
+				offsets[i] = ramp * time.Duration(step) / time.Duration(steps-1)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown ramp profile %q (instant, linear or step)", profile)
+	}
+	return offsets, nil
+}
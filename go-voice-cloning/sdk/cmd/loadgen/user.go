@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+	"github.com/voice-cloning/sdk/internal/loadstats"
+)
+
+// Operations measured by the load generator
+const (
+	OpRegister = "register"
+	OpUpload   = "upload"
+	OpCreate   = "create_clone"
+	OpPoll     = "poll_status"
+	OpDownload = "download"
+	OpJob      = "clone_job" // clone creation to final status
+)
+
+var operations = []string{OpRegister, OpUpload, OpCreate, OpPoll, OpDownload, OpJob}
+
+var errJobTimeout = errors.New("clone job timed out")
+
+// errorClass groups errors as the stats do, counting jobs that ran past
+// the job timeout as timeouts
+func errorClass(err error) string {
+	if errors.Is(err, errJobTimeout) {
+		return "timeout"
+	}
+	return loadstats.ErrorClass(err)
+}
+
+// virtualUser registers an account and then runs the clone flow until ctx
+// is done: upload a sample, create a clone, poll it to a final status and
+// download the output
+type virtualUser struct {
+	id     int
+	cfg    *Config
+	stats  *loadstats.Stats
+	client *sdk.Client
+}
+
+func (u *virtualUser) run(ctx context.Context) {
+	email := fmt.Sprintf("loadgen-%s-%d@example.com", u.cfg.RunID, u.id)
+	username := fmt.Sprintf("loadgen_%s_%d", u.cfg.RunID, u.id)
+	started := time.Now()
+	_, err := u.client.Register(ctx, email, username, "loadgen-password")
+	if ctx.Err() != nil {
+		return
+	}
+	u.stats.Record(OpRegister, time.Since(started), err)
+	if err != nil {
+		return
+	}
+
+	for iteration := 0; ctx.Err() == nil; iteration++ {
+		if u.cfg.Iterations > 0 && iteration >= u.cfg.Iterations {
+			return
+		}
+		u.iterate(ctx, iteration)
+		select {
+		case <-ctx.Done():
+		case <-time.After(u.cfg.ThinkTime):
+		}
+	}
+}
+
+func (u *virtualUser) iterate(ctx context.Context, iteration int) {
+	var upload *sdk.Upload
+	err := u.measure(ctx, OpUpload, func() (err error) {
+		name := fmt.Sprintf("loadgen_%s_%d_%d.wav", u.cfg.RunID, u.id, iteration)
+		upload, err = u.client.Upload(ctx, name, "", bytes.NewReader(u.cfg.sample))
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	var job *sdk.CloneJob
+	err = u.measure(ctx, OpCreate, func() (err error) {
+		job, err = u.client.CreateClone(ctx, sdk.CreateCloneRequest{
+			Name:       fmt.Sprintf("loadgen %d/%d", u.id, iteration),
+			SourceFile: upload.Filename,
+			Priority:   u.cfg.Priority,
+		})
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	if err := u.measure(ctx, OpJob, func() error { return u.wait(ctx, job.ID) }); err != nil {
+		return
+	}
+
+	clone, err := u.client.GetClone(ctx, job.ID)
+	if err != nil || clone.OutputFile == "" {
+		return
+	}
+	u.measure(ctx, OpDownload, func() error {
+		_, err := u.client.Download(ctx, clone.OutputFile, io.Discard)
+		return err
+	})
+}
+
+// wait polls a clone job until it reaches a final status
+func (u *virtualUser) wait(ctx context.Context, id int) error {
+	deadline := time.Now().Add(u.cfg.JobTimeout)
+	for {
+		var status *sdk.CloneStatus
+		err := u.measure(ctx, OpPoll, func() (err error) {
+			status, err = u.client.GetCloneStatus(ctx, id)
+			return err
+		})
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return err
+		case err == nil && status.Status == sdk.StatusCompleted:
+			return nil
+		case err == nil && status.Done():
+			return &sdk.JobError{ID: id, Status: status.Status, Code: status.ErrorCode, Message: status.ErrorMessage}
+		case time.Now().After(deadline):
+			return errJobTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(u.cfg.PollInterval):
+		}
+	}
+}
+
+// measure times fn and records it, except when the run ending cut it short
+func (u *virtualUser) measure(ctx context.Context, op string, fn func() error) error {
+	started := time.Now()
+	err := fn()
+	if ctx.Err() == nil {
+		u.stats.Record(op, time.Since(started), err)
+	}
+	return err
+}
+
+// silentWAV returns a mono 16-bit PCM WAV of the given length
+func silentWAV(seconds int) []byte {
+	const rate = 16000
+	dataLen := uint32(seconds * rate * 2)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+dataLen)
+	buf.WriteString("WAVEfmt ")
+	// PCM format chunk: size, format, channels, sample rate, byte rate, block align, bits
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataLen)
+	buf.Write(make([]byte, dataLen))
+	return buf.Bytes()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+	"github.com/voice-cloning/sdk/internal/loadstats"
+)
+
+// States clones are left in. Failed clones can't be produced through the
+// API, so there are none.
+const (
+	StateCompleted = "completed" // trained to completion
+	StateQueued    = "queued"    // left to the workers, pending or processing
+	StateScheduled = "scheduled" // pending, processed in 30 days
+	StateCancelled = "cancelled" // scheduled, then cancelled
+	StateArchived  = "archived"  // completed, then archived
+	StateDeleted   = "deleted"   // scheduled, then moved to the trash
+)
+
+var cloneStates = []string{StateCompleted, StateQueued, StateScheduled, StateCancelled, StateArchived, StateDeleted}
+
+// Operations measured while seeding
+const (
+	OpRegister = "register"
+	OpUpload   = "upload"
+	OpCreate   = "create_clone"
+	OpJob      = "clone_job" // clone creation to completion
+	OpCancel   = "cancel_clone"
+	OpArchive  = "archive_clone"
+	OpDelete   = "delete_clone"
+)
+
+var seedOperations = []string{OpRegister, OpUpload, OpCreate, OpJob, OpCancel, OpArchive, OpDelete}
+
+// Dataset is what a seed run created, written as JSON to be load tested
+// again with -dataset
+type Dataset struct {
+	RunID     string     `json:"run_id"`
+	Seed      int64      `json:"seed"`
+	BaseURL   string     `json:"base_url"`
+	CreatedAt time.Time  `json:"created_at"`
+	Users     []SeedUser `json:"users"`
+}
+
+// SeedUser is an account of the dataset with its files and clones
+type SeedUser struct {
+	Email    string      `json:"email"`
+	Username string      `json:"username"`
+	Password string      `json:"password"`
+	Files    []string    `json:"files"`
+	Clones   []SeedClone `json:"clones"`
+}
+
+// SeedClone is a clone of the dataset and the state it was left in
+type SeedClone struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+}
+
+func readDataset(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dataset Dataset
+	if err := json.Unmarshal(data, &dataset); err != nil {
+		return nil, err
+	}
+	return &dataset, nil
+}
+
+func (d *Dataset) write(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// seed creates the dataset's users, Concurrency at a time. Users whose
+// account couldn't be created are left out of it.
+func seed(ctx context.Context, cfg *Config, stats *loadstats.Stats) *Dataset {
+	dataset := &Dataset{RunID: cfg.RunID, Seed: cfg.Seed, BaseURL: cfg.BaseURL, CreatedAt: time.Now().UTC()}
+	users := make([]*SeedUser, cfg.Users)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				users[i] = seedUser(ctx, cfg, stats, i)
+			}
+		}()
+	}
+	for i := 0; i < cfg.Users && ctx.Err() == nil; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, user := range users {
+		if user != nil {
+			dataset.Users = append(dataset.Users, *user)
+		}
+	}
+	return dataset
+}
+
+// seedUser creates user number i with its files and clones. Its choices
+// come from its own source, seeded from the run's, so they don't depend on
+// the order users are seeded in.
+func seedUser(ctx context.Context, cfg *Config, stats *loadstats.Stats, i int) *SeedUser {
+	r := rand.New(rand.NewSource(cfg.Seed + int64(i)))
+	client := cfg.newClient()
+	user := &SeedUser{
+		Email:    fmt.Sprintf("seed-%s-%d@example.com", cfg.RunID, i),
+		Username: fmt.Sprintf("seed_%s_%d", cfg.RunID, i),
+		Password: fmt.Sprintf("seed-%s-password", cfg.RunID),
+	}
+	err := measure(ctx, stats, OpRegister, func() error {
+		_, err := client.Register(ctx, user.Email, user.Username, user.Password)
+		var apiErr *sdk.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			// Seeded by an earlier run with the same run ID
+			_, err = client.Login(ctx, user.Email, user.Password)
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("User %d: %v", i, err)
+		return nil
+	}
+
+	for f := 0; f < cfg.Files && ctx.Err() == nil; f++ {
+		sample := toneWAV(cfg.SampleSeconds+r.Intn(5), 110+float64(r.Intn(400)))
+		measure(ctx, stats, OpUpload, func() error {
+			upload, err := client.UploadToFolder(ctx, "seed", fmt.Sprintf("sample-%d.wav", f), "", bytes.NewReader(sample))
+			if err == nil {
+				user.Files = append(user.Files, upload.ID)
+			}
+			return err
+		})
+	}
+	if len(user.Files) == 0 {
+		return user
+	}
+
+	for c := 0; c < cfg.Clones && ctx.Err() == nil; c++ {
+		state := pick(r, cfg.States)
+		req := sdk.CreateCloneRequest{
+			Name:        fmt.Sprintf("Seed voice %d", c+1),
+			SourceFile:  user.Files[r.Intn(len(user.Files))],
+			Description: fmt.Sprintf("Synthetic %s clone of seed run %s", state, cfg.RunID),
+			Tags:        []string{"seed", state},
+			Metadata:    map[string]interface{}{"seed_run": cfg.RunID},
+		}
+		if state == StateScheduled || state == StateCancelled || state == StateDeleted {
+			later := time.Now().Add(30 * 24 * time.Hour)
+			req.ProcessAfter = &later
+		}
+		var job *sdk.CloneJob
+		err := measure(ctx, stats, OpCreate, func() (err error) {
+			job, err = client.CreateClone(ctx, req)
+			return err
+		})
+		if err != nil {
+			continue
+		}
+		if err := settle(ctx, cfg, stats, client, job.ID, state); err != nil {
+			log.Printf("User %d: clone %d wasn't left %s: %v", i, job.ID, state, err)
+		}
+		user.Clones = append(user.Clones, SeedClone{ID: job.ID, State: state})
+	}
+	return user
+}
+
+// settle takes a new clone to the state it is to be left in
+func settle(ctx context.Context, cfg *Config, stats *loadstats.Stats, client *sdk.Client, id int, state string) error {
+	switch state {
+	case StateCompleted, StateArchived:
+		err := measure(ctx, stats, OpJob, func() error {
+			_, err := client.WaitForCloneCompletion(ctx, id, &sdk.WaitOptions{Timeout: cfg.JobTimeout})
+			return err
+		})
+		if err != nil || state == StateCompleted {
+			return err
+		}
+		return measure(ctx, stats, OpArchive, func() error {
+			_, err := client.ArchiveClone(ctx, id)
+			return err
+		})
+	case StateCancelled:
+		return measure(ctx, stats, OpCancel, func() error { return client.CancelClone(ctx, id) })
+	case StateDeleted:
+		return measure(ctx, stats, OpDelete, func() error { return client.DeleteClone(ctx, id, false) })
+	}
+	return nil
+}
+
+// measure times fn and records it, except when the run ending cut it short
+func measure(ctx context.Context, stats *loadstats.Stats, op string, fn func() error) error {
+	started := time.Now()
+	err := fn()
+	if ctx.Err() == nil {
+		stats.Record(op, time.Since(started), err)
+	}
+	return err
+}
+
+// parseWeights parses comma-separated name=weight pairs, with names among
+// known and weights of at least 0, at least one of them positive
+func parseWeights(s string, known []string) (map[string]int, error) {
+	weights := map[string]int{}
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q isn't name=weight", pair)
+		}
+		if !contains(known, name) {
+			return nil, fmt.Errorf("unknown %q (%s)", name, strings.Join(known, ", "))
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of %s must be a whole number of at least 0", name)
+		}
+		weights[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("no positive weight")
+	}
+	return weights, nil
+}
+
+// pick draws a name with a chance proportional to its weight
+func pick(r *rand.Rand, weights map[string]int) string {
+	names := make([]string, 0, len(weights))
+	total := 0
+	for name, weight := range weights {
+		names = append(names, name)
+		total += weight
+	}
+	// Map order is random; the draw must only depend on r
+	sort.Strings(names)
+	n := r.Intn(total)
+	for _, name := range names {
+		if n < weights[name] {
+			return name
+		}
+		n -= weights[name]
+	}
+	return names[len(names)-1]
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// toneWAV returns a mono 16-bit PCM WAV of a tone of the frequency lasting
+// seconds
+func toneWAV(seconds int, frequency float64) []byte {
+	const rate = 16000
+	samples := seconds * rate
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+samples*2))
+	buf.WriteString("WAVEfmt ")
+	// PCM format chunk: size, format, channels, sample rate, byte rate, block align, bits
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(samples*2))
+	pcm := make([]int16, samples)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*frequency*float64(i)/rate))
+	}
+	binary.Write(&buf, binary.LittleEndian, pcm)
+	return buf.Bytes()
+}
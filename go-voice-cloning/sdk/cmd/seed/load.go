@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+	"github.com/voice-cloning/sdk/internal/loadstats"
+)
+
+// Operations of the request mix
+const (
+	OpGetClone    = "get_clone"
+	OpListClones  = "list_clones"
+	OpCloneStatus = "clone_status"
+	OpListFiles   = "list_files"
+	OpDownload    = "download"
+	OpSynthesize  = "synthesize"
+	OpLogin       = "login"
+)
+
+var mixOperations = []string{OpGetClone, OpListClones, OpCloneStatus, OpListFiles, OpDownload, OpSynthesize}
+
+// loadOperations are reported for the request mix, which starts by signing
+// the users in
+var loadOperations = append([]string{OpLogin}, mixOperations...)
+
+// errNothingToUse is an operation drawn for a user without the clone or
+// file it needs; another is drawn instead
+var errNothingToUse = errors.New("nothing to use")
+
+// loadUser is a dataset user signed in for the request mix
+type loadUser struct {
+	SeedUser
+	client *sdk.Client
+}
+
+// runLoad sends the request mix until ctx is done: Workers at a time, at
+// Rate requests per second across them when set. Each request is made by a
+// user of the dataset drawn at random.
+func runLoad(ctx context.Context, cfg *Config, dataset *Dataset, stats *loadstats.Stats) {
+	var users []*loadUser
+	for _, u := range dataset.Users {
+		user := &loadUser{SeedUser: u, client: cfg.newClient()}
+		err := measure(ctx, stats, OpLogin, func() error {
+			_, err := user.client.Login(ctx, u.Email, u.Password)
+			return err
+		})
+		if err != nil {
+			log.Printf("Leaving out %s: %v", u.Email, err)
+			continue
+		}
+		users = append(users, user)
+	}
+	if len(users) == 0 {
+		log.Print("No user of the dataset could sign in")
+		return
+	}
+
+	if cfg.ReportInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.ReportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					fmt.Printf("\n--- %s elapsed ---\n", stats.Elapsed().Round(time.Second))
+					stats.Summary(os.Stdout)
+				}
+			}
+		}()
+	}
+
+	// Without a rate, requests are sent as soon as a worker is free
+	var tokens <-chan time.Time
+	if interval := time.Duration(float64(time.Second) / cfg.Rate); cfg.Rate > 0 && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		r := rand.New(rand.NewSource(dataset.Seed + int64(w)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				user := users[r.Intn(len(users))]
+				for attempt := 0; attempt < 10; attempt++ {
+					op := pick(r, cfg.Mix)
+					if err := user.request(ctx, r, stats, op); !errors.Is(err, errNothingToUse) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// request makes one request of the operation, measured in stats
+func (u *loadUser) request(ctx context.Context, r *rand.Rand, stats *loadstats.Stats, op string) error {
+	var fn func() error
+	switch op {
+	case OpGetClone, OpCloneStatus:
+		clone, ok := u.clone(r, "")
+		if !ok {
+			return errNothingToUse
+		}
+		fn = func() error {
+			var err error
+			if op == OpGetClone {
+				_, err = u.client.GetClone(ctx, clone.ID)
+			} else {
+				_, err = u.client.GetCloneStatus(ctx, clone.ID)
+			}
+			return err
+		}
+	case OpListClones:
+		fn = func() error {
+			_, err := u.client.ListClones(ctx, sdk.CloneQuery{Limit: 20})
+			return err
+		}
+	case OpListFiles:
+		fn = func() error {
+			_, err := u.client.ListFiles(ctx, "", 20, "")
+			return err
+		}
+	case OpDownload:
+		if len(u.Files) == 0 {
+			return errNothingToUse
+		}
+		file := u.Files[r.Intn(len(u.Files))]
+		fn = func() error {
+			_, err := u.client.Download(ctx, file, io.Discard)
+			return err
+		}
+	case OpSynthesize:
+		clone, ok := u.clone(r, StateCompleted)
+		if !ok {
+			return errNothingToUse
+		}
+		fn = func() error {
+			_, err := u.client.Synthesize(ctx, clone.ID, sdk.SynthesisRequest{Text: "This is a load test of the synthesis queue."})
+			return err
+		}
+	default:
+		return errNothingToUse
+	}
+	return measure(ctx, stats, op, fn)
+}
+
+// clone draws one of the user's clones in the state, or in any state but
+// deleted when state is empty
+func (u *loadUser) clone(r *rand.Rand, state string) (SeedClone, bool) {
+	var candidates []SeedClone
+	for _, c := range u.Clones {
+		if (state == "" && c.State != StateDeleted) || c.State == state {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return SeedClone{}, false
+	}
+	return candidates[r.Intn(len(candidates))], true
+}
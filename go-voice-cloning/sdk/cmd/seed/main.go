@@ -0,0 +1,127 @@
+// Command seed creates a synthetic dataset through the gateway: users, each
+// with sample files and clones left in a mix of states, described in a
+// dataset file so runs can be repeated and compared. With -load it then
+// sends a sustained, weighted mix of requests against the dataset, and
+// reports latency and errors per operation.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+	"github.com/voice-cloning/sdk/internal/loadstats"
+)
+
+// Config of a seed run
+type Config struct {
+	BaseURL       string
+	Users         int
+	Files         int
+	Clones        int
+	States        map[string]int
+	SampleSeconds int
+	Seed          int64
+	RunID         string
+	Concurrency   int
+	JobTimeout    time.Duration
+	Out           string
+	Dataset       string
+	MaxRetries    int
+
+	Load           time.Duration
+	Mix            map[string]int
+	Rate           float64
+	Workers        int
+	ReportInterval time.Duration
+}
+
+func main() {
+	cfg := &Config{}
+	var states, mix string
+	flag.StringVar(&cfg.BaseURL, "url", "http://localhost:8080", "gateway base URL")
+	flag.IntVar(&cfg.Users, "users", 10, "number of users to create")
+	flag.IntVar(&cfg.Files, "files", 3, "sample files uploaded per user")
+	flag.IntVar(&cfg.Clones, "clones", 5, "clones created per user")
+	flag.StringVar(&states, "states", "completed=4,scheduled=1,cancelled=1,archived=1,deleted=1", "weights of the states clones are left in")
+	flag.IntVar(&cfg.SampleSeconds, "sample-seconds", 10, "minimum length of the generated samples")
+	flag.Int64Var(&cfg.Seed, "seed", 1, "seed of the dataset's random choices")
+	flag.StringVar(&cfg.RunID, "run-id", fmt.Sprintf("%x", time.Now().Unix()), "suffix making the run's accounts unique")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 4, "users seeded at once")
+	flag.DurationVar(&cfg.JobTimeout, "job-timeout", 5*time.Minute, "how long to wait for a clone job to complete")
+	flag.StringVar(&cfg.Out, "out", "", "file to write the dataset to (default seed-<run-id>.json)")
+	flag.StringVar(&cfg.Dataset, "dataset", "", "dataset file of an earlier run to load test instead of seeding")
+	flag.IntVar(&cfg.MaxRetries, "max-retries", sdk.DefaultMaxRetries, "retries of shed (429/503) requests, following the gateway's guidance")
+	flag.DurationVar(&cfg.Load, "load", 0, "how long to send the request mix after seeding, 0 to only seed")
+	flag.StringVar(&mix, "mix", "get_clone=30,list_clones=20,clone_status=20,list_files=15,download=10,synthesize=5", "weights of the operations in the request mix")
+	flag.Float64Var(&cfg.Rate, "rate", 20, "requests per second of the mix, 0 for as fast as the workers go")
+	flag.IntVar(&cfg.Workers, "workers", 10, "concurrent requests of the mix")
+	flag.DurationVar(&cfg.ReportInterval, "report", 30*time.Second, "interval between interim reports, 0 to disable")
+	flag.Parse()
+
+	var err error
+	if cfg.States, err = parseWeights(states, cloneStates); err != nil {
+		log.Fatalf("Invalid -states: %v", err)
+	}
+	if cfg.Mix, err = parseWeights(mix, mixOperations); err != nil {
+		log.Fatalf("Invalid -mix: %v", err)
+	}
+	if cfg.Users < 1 || cfg.Concurrency < 1 || cfg.Workers < 1 {
+		log.Fatal("users, concurrency and workers must be at least 1")
+	}
+	if cfg.Clones > 0 && cfg.Files < 1 {
+		log.Fatal("files must be at least 1 to create clones from")
+	}
+	if cfg.Out == "" {
+		cfg.Out = fmt.Sprintf("seed-%s.json", cfg.RunID)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var dataset *Dataset
+	if cfg.Dataset != "" {
+		if dataset, err = readDataset(cfg.Dataset); err != nil {
+			log.Fatalf("Failed to read the dataset: %v", err)
+		}
+		log.Printf("Loaded dataset %s: %d users from run %s", cfg.Dataset, len(dataset.Users), dataset.RunID)
+	} else {
+		log.Printf("Seeding %d users with %d files and %d clones each against %s (run %s, seed %d)",
+			cfg.Users, cfg.Files, cfg.Clones, cfg.BaseURL, cfg.RunID, cfg.Seed)
+		stats := loadstats.New(seedOperations, nil)
+		dataset = seed(ctx, cfg, stats)
+		fmt.Printf("\n=== Seeding report (%s) ===\n", stats.Elapsed().Round(time.Second))
+		stats.Summary(os.Stdout)
+		if err := dataset.write(cfg.Out); err != nil {
+			log.Fatalf("Failed to write the dataset: %v", err)
+		}
+		log.Printf("Wrote the dataset of %d users to %s", len(dataset.Users), cfg.Out)
+	}
+
+	if cfg.Load <= 0 || ctx.Err() != nil {
+		return
+	}
+	if len(dataset.Users) == 0 {
+		log.Fatal("The dataset has no users to load test with")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Load)
+	defer cancel()
+	log.Printf("Sending the request mix for %s at %.1f requests/s with %d workers", cfg.Load, cfg.Rate, cfg.Workers)
+	stats := loadstats.New(loadOperations, nil)
+	runLoad(ctx, cfg, dataset, stats)
+	fmt.Printf("\n=== Load report (%s) ===\n", stats.Elapsed().Round(time.Second))
+	stats.Summary(os.Stdout)
+}
+
+// newClient returns a client of the gateway following the run's retries
+func (cfg *Config) newClient() *sdk.Client {
+	client := sdk.NewClient(cfg.BaseURL)
+	client.MaxRetries = cfg.MaxRetries
+	return client
+}
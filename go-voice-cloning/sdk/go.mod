@@ -0,0 +1,3 @@
+module github.com/voice-cloning/sdk
+
+go 1.21
@@ -0,0 +1,137 @@
+// Package loadstats collects the latencies and errors of the operations the
+// load tools run, and reports them per operation.
+package loadstats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/voice-cloning/sdk"
+)
+
+type opStats struct {
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+// Stats collects latencies and errors per operation. Operations are
+// reported in the order given to New; others recorded are left out.
+type Stats struct {
+	mu         sync.Mutex
+	start      time.Time
+	operations []string
+	classify   func(error) string
+	ops        map[string]*opStats
+}
+
+// New returns stats of the operations, grouping errors with classify, or
+// ErrorClass when nil
+func New(operations []string, classify func(error) string) *Stats {
+	if classify == nil {
+		classify = ErrorClass
+	}
+	return &Stats{start: time.Now(), operations: operations, classify: classify, ops: make(map[string]*opStats)}
+}
+
+// Elapsed is the time since the stats were created
+func (s *Stats) Elapsed() time.Duration {
+	return time.Since(s.start)
+}
+
+// Record adds the outcome of one operation
+func (s *Stats) Record(op string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.ops[op]
+	if !ok {
+		o = &opStats{errors: make(map[string]int)}
+		s.ops[op] = o
+	}
+	if err != nil {
+		o.errors[s.classify(err)]++
+		return
+	}
+	o.latencies = append(o.latencies, latency)
+}
+
+// ErrorClass groups errors by status code so reports stay short
+func ErrorClass(err error) string {
+	var apiErr *sdk.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Retry != nil && apiErr.Retry.Reason != "" {
+			return fmt.Sprintf("http_%d_%s", apiErr.StatusCode, apiErr.Retry.Reason)
+		}
+		return fmt.Sprintf("http_%d", apiErr.StatusCode)
+	}
+	var jobErr *sdk.JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Status
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "transport"
+}
+
+// Summary prints a row per operation with throughput, latency percentiles
+// of successful calls and error counts
+func (s *Stats) Summary(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.start)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\tok\terrors\trate/s\tp50\tp95\tp99\tmax\t")
+	for _, op := range s.operations {
+		o, ok := s.ops[op]
+		if !ok {
+			continue
+		}
+		sort.Slice(o.latencies, func(i, j int) bool { return o.latencies[i] < o.latencies[j] })
+		failed := 0
+		for _, n := range o.errors {
+			failed += n
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t\n", op, len(o.latencies), failed,
+			float64(len(o.latencies)+failed)/elapsed.Seconds(),
+			percentile(o.latencies, 50), percentile(o.latencies, 95), percentile(o.latencies, 99),
+			percentile(o.latencies, 100))
+	}
+	tw.Flush()
+
+	for _, op := range s.operations {
+		o, ok := s.ops[op]
+		if !ok || len(o.errors) == 0 {
+			continue
+		}
+		classes := make([]string, 0, len(o.errors))
+		for class := range o.errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		fmt.Fprintf(w, "%s errors:", op)
+		for _, class := range classes {
+			fmt.Fprintf(w, " %s=%d", class, o.errors[class])
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// percentile of sorted latencies, rounded for display
+func percentile(sorted []time.Duration, p int) string {
+	if len(sorted) == 0 {
+		return "-"
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Millisecond).String()
+}
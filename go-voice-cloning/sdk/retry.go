@@ -0,0 +1,104 @@
+package sdk
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default retry limits of clients built by NewClient
+const (
+	DefaultMaxRetries   = 3
+	DefaultMaxRetryWait = time.Minute
+)
+
+// RetryGuidance is the server's advice on retrying a shed request (429 or
+// 503): wait RetryAfterMS plus a random share of JitterMS, multiplying the
+// wait by BackoffMultiplier for each further attempt.
+type RetryGuidance struct {
+	RetryAfterMS      int64   `json:"retry_after_ms"`
+	JitterMS          int64   `json:"jitter_ms"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	Reason            string  `json:"reason"`
+}
+
+// Delay is the wait before retry number attempt (0 for the first retry),
+// with jitter applied
+func (g RetryGuidance) Delay(attempt int) time.Duration {
+	multiplier := g.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(g.RetryAfterMS) * math.Pow(multiplier, float64(attempt))
+	if g.JitterMS > 0 {
+		wait += float64(rand.Int63n(g.JitterMS + 1))
+	}
+	return time.Duration(wait) * time.Millisecond
+}
+
+// retryGuidance reads guidance from a response's headers, falling back to a
+// plain Retry-After
+func retryGuidance(resp *http.Response) *RetryGuidance {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	if ms, err := strconv.ParseInt(resp.Header.Get("X-Retry-After-Ms"), 10, 64); err == nil {
+		g := &RetryGuidance{RetryAfterMS: ms, BackoffMultiplier: 1}
+		g.JitterMS, _ = strconv.ParseInt(resp.Header.Get("X-Retry-Jitter-Ms"), 10, 64)
+		if m, err := strconv.ParseFloat(resp.Header.Get("X-Retry-Backoff-Multiplier"), 64); err == nil {
+			g.BackoffMultiplier = m
+		}
+		return g
+	}
+	if seconds, err := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64); err == nil {
+		return &RetryGuidance{RetryAfterMS: seconds * 1000, BackoffMultiplier: 1}
+	}
+	return nil
+}
+
+// roundTrip sends req, waiting out and retrying shed responses as the
+// server's guidance says. It gives up after MaxRetries retries, when the
+// guided wait exceeds MaxRetryWait, or when the body can't be replayed; the
+// last response is returned.
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		guidance := retryGuidance(resp)
+		if guidance == nil || attempt >= c.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		wait := guidance.Delay(attempt)
+		if wait > c.MaxRetryWait {
+			return resp, nil
+		}
+
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
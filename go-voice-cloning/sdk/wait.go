@@ -0,0 +1,288 @@
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Default polling of the wait helpers
+const (
+	DefaultPollInterval    = time.Second
+	DefaultMaxPollInterval = 30 * time.Second
+	DefaultPollBackoff     = 1.5
+)
+
+// WaitOptions tunes WaitForCloneCompletion and WaitForSynthesis. The zero
+// value, or nil, uses the defaults.
+//
+// Polls start PollInterval apart, and the interval grows by Backoff after
+// each poll that finds nothing new, up to MaxPollInterval. It goes back to
+// PollInterval whenever the job moves on. Timeout, when set, bounds the
+// whole wait on top of the context's deadline.
+type WaitOptions struct {
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+	Backoff         float64
+	Timeout         time.Duration
+	// PollOnly skips the clone's event stream and only polls its status
+	PollOnly bool
+	// Progress is called with each change of the job's status or progress,
+	// starting with the status it had when the wait began. Synthesis jobs
+	// report their status only.
+	Progress func(CloneStatus)
+}
+
+// JobError is returned by the wait helpers when a job ends without
+// completing: it failed, or was cancelled. Code and Message are the
+// failure's, when the service recorded one.
+type JobError struct {
+	ID      int
+	Status  string
+	Code    string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	msg := fmt.Sprintf("job %d %s", e.ID, e.Status)
+	if e.Code != "" {
+		msg += " [" + e.Code + "]"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// errNoStream is returned when the gateway doesn't serve the clone's events
+// as a stream, for the wait to fall back to polling
+var errNoStream = errors.New("event stream unavailable")
+
+// WaitForCloneCompletion waits for a clone job to reach a final status and
+// returns it. It follows the clone's event stream, falling back to polling
+// its status when the stream isn't available, and reconnects after the
+// stream drops. A job that failed or was cancelled returns its status with
+// a *JobError. When the context ends first, the last status seen is
+// returned with the context's error.
+func (c *Client) WaitForCloneCompletion(ctx context.Context, id int, opts *WaitOptions) (*CloneStatus, error) {
+	o := opts.withDefaults()
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	var last *CloneStatus
+	report := func(status CloneStatus) bool {
+		if last != nil && last.Status == status.Status && last.Progress == status.Progress && last.Stage == status.Stage {
+			return false
+		}
+		last = &status
+		if o.Progress != nil {
+			o.Progress(status)
+		}
+		return true
+	}
+
+	streaming := !o.PollOnly
+	interval := o.PollInterval
+	for {
+		var (
+			status *CloneStatus
+			moved  bool
+			err    error
+		)
+		if streaming {
+			status, moved, err = c.streamCloneStatus(ctx, id, report)
+			if errors.Is(err, errNoStream) {
+				streaming = false
+				continue
+			}
+		} else {
+			status, err = c.GetCloneStatus(ctx, id)
+			if err == nil {
+				moved = report(*status)
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			return last, ctx.Err()
+		case err != nil && !transient(err):
+			return last, err
+		case err == nil && status.Done():
+			return c.finishClone(ctx, id, status)
+		}
+
+		if moved {
+			interval = o.PollInterval
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return last, err
+		}
+		interval = o.next(interval)
+	}
+}
+
+// streamCloneStatus follows a clone's event stream, reporting each event,
+// until the stream ends. It returns the last status streamed and whether
+// anything new was reported.
+func (c *Client) streamCloneStatus(ctx context.Context, id int, report func(CloneStatus) bool) (*CloneStatus, bool, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/api/voice/clones/%d/events", id), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream lasts as long as the job, so only the context bounds it
+	client := *c.HTTPClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		// A missing clone is told apart from a missing endpoint by polling
+		return nil, false, errNoStream
+	case resp.StatusCode >= 300:
+		return nil, false, decodeError(resp)
+	case !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"):
+		return nil, false, errNoStream
+	}
+
+	var (
+		status *CloneStatus
+		moved  bool
+		data   strings.Builder
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+		var event CloneStatus
+		if err := json.Unmarshal([]byte(data.String()), &event); err == nil && event.Status != "" {
+			status = &event
+			if report(event) {
+				moved = true
+			}
+			if event.Done() {
+				return status, moved, nil
+			}
+		}
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return status, moved, err
+	}
+	return status, moved, errors.New("event stream closed")
+}
+
+// finishClone returns a clone job's final status, with a *JobError unless
+// it completed. Streamed events don't carry the failure, so it is fetched.
+func (c *Client) finishClone(ctx context.Context, id int, status *CloneStatus) (*CloneStatus, error) {
+	if status.Status == StatusCompleted {
+		return status, nil
+	}
+	if status.ErrorCode == "" && status.ErrorMessage == "" {
+		if fetched, err := c.GetCloneStatus(ctx, id); err == nil && fetched.Done() {
+			status = fetched
+		}
+	}
+	return status, &JobError{ID: id, Status: status.Status, Code: status.ErrorCode, Message: status.ErrorMessage}
+}
+
+// WaitForSynthesis polls a synthesis job until it reaches a final status
+// and returns it. A job that failed returns it with a *JobError. When the
+// context ends first, the last state seen is returned with the context's
+// error.
+func (c *Client) WaitForSynthesis(ctx context.Context, cloneID, id int, opts *WaitOptions) (*Synthesis, error) {
+	o := opts.withDefaults()
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	var last *Synthesis
+	interval := o.PollInterval
+	for {
+		synthesis, err := c.GetSynthesis(ctx, cloneID, id)
+		switch {
+		case ctx.Err() != nil:
+			return last, ctx.Err()
+		case err != nil && !transient(err):
+			return last, err
+		case err == nil:
+			if last == nil || last.Status != synthesis.Status {
+				interval = o.PollInterval
+				if o.Progress != nil {
+					o.Progress(CloneStatus{Status: synthesis.Status, ErrorMessage: synthesis.Error})
+				}
+			}
+			last = synthesis
+			switch synthesis.Status {
+			case StatusCompleted:
+				return synthesis, nil
+			case StatusFailed, StatusCancelled:
+				return synthesis, &JobError{ID: id, Status: synthesis.Status, Message: synthesis.Error}
+			}
+		}
+
+		if err := sleep(ctx, interval); err != nil {
+			return last, err
+		}
+		interval = o.next(interval)
+	}
+}
+
+// transient reports whether a failed poll may succeed if repeated: the
+// connection failed, or the API was unavailable or shed the request
+func transient(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+func (o *WaitOptions) withDefaults() WaitOptions {
+	var opts WaitOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.MaxPollInterval <= 0 {
+		opts.MaxPollInterval = DefaultMaxPollInterval
+	}
+	if opts.MaxPollInterval < opts.PollInterval {
+		opts.MaxPollInterval = opts.PollInterval
+	}
+	if opts.Backoff < 1 {
+		opts.Backoff = DefaultPollBackoff
+	}
+	return opts
+}
+
+// next is the poll interval after interval
+func (o WaitOptions) next(interval time.Duration) time.Duration {
+	interval = time.Duration(float64(interval) * o.Backoff)
+	if interval > o.MaxPollInterval {
+		return o.MaxPollInterval
+	}
+	return interval
+}
@@ -0,0 +1,192 @@
+// Package analytics sends product events, such as a clone created or an
+// upload completed, to the analytics service, which aggregates them for the
+// admin dashboard and users' stats pages. Services track events with a
+// Tracker, which buffers them and sends them in batches from the
+// background, so tracking doesn't slow requests down. Events are a measure
+// rather than a record: once the buffer is full, or the analytics service
+// keeps refusing a batch, they are dropped.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/httpclient"
+)
+
+// Events the services track
+const (
+	EventCloneCreated       = "clone_created"
+	EventSynthesisRequested = "synthesis_requested"
+	EventUploadCompleted    = "upload_completed"
+)
+
+// MaxBatchSize is the most events the analytics service accepts at once
+const MaxBatchSize = 500
+
+// namePattern is what event names look like, so they can be grouped by
+// and filtered on without escaping
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ValidName reports whether name can name an event: lowercase letters,
+// digits and underscores, starting with a letter, up to 64 long
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Event is one product event. UserID is 0 for events of no user; Source is
+// the service that tracked it, or sdk for clients'.
+type Event struct {
+	Name       string                 `json:"name" validate:"required,max=64"`
+	UserID     int                    `json:"user_id,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	OccurredAt time.Time              `json:"occurred_at"`
+}
+
+// Batch is the body of the analytics service's POST /events
+type Batch struct {
+	Events []Event `json:"events" validate:"required,min=1,max=500,dive"`
+}
+
+// Config locates the analytics service. Services hold it in an Analytics
+// field of their own Config; without a URL, events aren't sent.
+type Config struct {
+	ServiceURL string `env:"ANALYTICS_SERVICE_URL"`
+}
+
+const (
+	// bufferSize events are held while the analytics service catches up
+	bufferSize = 4096
+	// flushInterval is the longest an event waits to be sent
+	flushInterval = 2 * time.Second
+	// maxAttempts to send a batch before it is dropped
+	maxAttempts = 3
+)
+
+// Tracker sends a service's events to the analytics service
+type Tracker struct {
+	source string
+	url    string
+	client *http.Client
+
+	events    chan Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts a tracker of source's events. Without a configured URL it
+// discards them. Close it to send what is buffered.
+func New(source string, cfg Config) *Tracker {
+	t := &Tracker{source: source, done: make(chan struct{})}
+	if cfg.ServiceURL == "" {
+		close(t.done)
+		return t
+	}
+	t.url = strings.TrimRight(cfg.ServiceURL, "/") + "/events"
+	t.client = httpclient.New(httpclient.Options{Timeout: 5 * time.Second})
+	t.events = make(chan Event, bufferSize)
+	go t.run()
+	return t
+}
+
+// Track queues an event of a user, or of none with userID 0. It never
+// blocks: an event that finds the buffer full is dropped.
+func (t *Tracker) Track(name string, userID int, properties map[string]interface{}) {
+	if t.events == nil {
+		return
+	}
+	event := Event{Name: name, UserID: userID, Source: t.source, Properties: properties, OccurredAt: time.Now().UTC()}
+	select {
+	case t.events <- event:
+	default:
+		slog.Warn("Analytics buffer full, dropping event", "event", name)
+	}
+}
+
+// Close sends the buffered events and stops the sender
+func (t *Tracker) Close() {
+	t.closeOnce.Do(func() {
+		if t.events != nil {
+			close(t.events)
+		}
+		<-t.done
+	})
+}
+
+// run sends events in batches, once a batch is full or the oldest event
+// has waited the flush interval
+func (t *Tracker) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, MaxBatchSize)
+	for {
+		select {
+		case event, ok := <-t.events:
+			if !ok {
+				t.send(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) < MaxBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// send posts a batch, retrying with backoff, and drops it once the
+// analytics service has refused it maxAttempts times
+func (t *Tracker) send(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(Batch{Events: batch})
+	if err != nil {
+		slog.Error("Failed to encode analytics events", "events", len(batch), "error", err)
+		return
+	}
+	backoff := 200 * time.Millisecond
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = t.post(body); err == nil {
+			return
+		}
+		if attempt < maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	slog.Warn("Dropped analytics events", "events", len(batch), "error", err)
+}
+
+func (t *Tracker) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("analytics service answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package apierror defines the codes of the API's error responses. Every
+// error, in the "error" member of the response envelope, carries a code
+// next to its message:
+//
+//	{"data": null, "error": {"code": "VOICE_CLONE_NOT_FOUND", "message": "Voice clone not found"}, "meta": {"request_id": "..."}}
+//
+// Clients branch on the code; the message is for people and may be
+// reworded. A code never changes meaning once released, and new ones are
+// added here. Errors without a more specific code use the generic code of
+// their status (see ForStatus). Errors about several things at once, such
+// as the fields of a form, list them in a details array.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Code identifies the kind of an error
+type Code string
+
+// Generic codes, one per status
+const (
+	BadRequest           Code = "BAD_REQUEST"
+	Unauthorized         Code = "UNAUTHORIZED"
+	Forbidden            Code = "FORBIDDEN"
+	NotFound             Code = "NOT_FOUND"
+	MethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	Conflict             Code = "CONFLICT"
+	Gone                 Code = "GONE"
+	PreconditionFailed   Code = "PRECONDITION_FAILED"
+	PayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	UnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	Unprocessable        Code = "UNPROCESSABLE"
+	RateLimited          Code = "RATE_LIMITED"
+	Internal             Code = "INTERNAL_ERROR"
+	UpstreamError        Code = "UPSTREAM_ERROR"
+	Unavailable          Code = "SERVICE_UNAVAILABLE"
+	Timeout              Code = "TIMEOUT"
+)
+
+// Malformed requests
+const (
+	InvalidRequestBody Code = "INVALID_REQUEST_BODY"
+	// ValidationFailed is a body whose fields break the request type's
+	// rules; the details name each invalid field
+	ValidationFailed Code = "VALIDATION_FAILED"
+	// InvalidParameter is a missing or invalid field or query parameter
+	InvalidParameter Code = "INVALID_PARAMETER"
+	InvalidHeader    Code = "INVALID_HEADER"
+	InvalidCursor    Code = "INVALID_CURSOR"
+)
+
+// Authentication and authorization
+const (
+	AuthenticationRequired Code = "AUTHENTICATION_REQUIRED"
+	InvalidToken           Code = "INVALID_TOKEN"
+	InvalidCredentials     Code = "INVALID_CREDENTIALS"
+	ChallengeFailed        Code = "CHALLENGE_FAILED"
+	AdminRequired          Code = "ADMIN_REQUIRED"
+	// InvalidLink is a signed link that is malformed or has expired
+	InvalidLink       Code = "INVALID_LINK"
+	NotOrgMember      Code = "NOT_ORG_MEMBER"
+	OrgRoleRequired   Code = "ORG_ROLE_REQUIRED"
+	FileOwnerRequired Code = "FILE_OWNER_REQUIRED"
+)
+
+// Resources that don't exist, or that the caller can't see
+const (
+	VoiceCloneNotFound    Code = "VOICE_CLONE_NOT_FOUND"
+	ListingNotFound       Code = "LISTING_NOT_FOUND"
+	FileNotFound          Code = "FILE_NOT_FOUND"
+	FolderNotFound        Code = "FOLDER_NOT_FOUND"
+	VersionNotFound       Code = "VERSION_NOT_FOUND"
+	UploadNotFound        Code = "UPLOAD_NOT_FOUND"
+	UserNotFound          Code = "USER_NOT_FOUND"
+	OrganizationNotFound  Code = "ORGANIZATION_NOT_FOUND"
+	MemberNotFound        Code = "MEMBER_NOT_FOUND"
+	WebhookNotFound       Code = "WEBHOOK_NOT_FOUND"
+	DeliveryNotFound      Code = "DELIVERY_NOT_FOUND"
+	InvitationNotFound    Code = "INVITATION_NOT_FOUND"
+	PlanNotFound          Code = "PLAN_NOT_FOUND"
+	JobNotFound           Code = "JOB_NOT_FOUND"
+	BillingAccountMissing Code = "BILLING_ACCOUNT_NOT_FOUND"
+)
+
+// Requests the resource's state doesn't allow
+const (
+	UserExists          Code = "USER_EXISTS"
+	AlreadyMember       Code = "ALREADY_MEMBER"
+	AlreadySubscribed   Code = "ALREADY_SUBSCRIBED"
+	LastOwner           Code = "LAST_OWNER"
+	InvitationAccepted  Code = "INVITATION_ALREADY_ACCEPTED"
+	InvitationExpired   Code = "INVITATION_EXPIRED"
+	InvitationMismatch  Code = "INVITATION_FOR_ANOTHER_EMAIL"
+	InvalidReferralCode Code = "INVALID_REFERRAL_CODE"
+	// CloneState is an operation the clone's status doesn't allow, such as
+	// synthesizing with a clone that hasn't completed
+	CloneState         Code = "VOICE_CLONE_INVALID_STATE"
+	CloneArchived      Code = "VOICE_CLONE_ARCHIVED"
+	CloneTakenDown     Code = "VOICE_CLONE_TAKEN_DOWN"
+	CloneModified      Code = "VOICE_CLONE_MODIFIED"
+	RetryLimitReached  Code = "RETRY_LIMIT_REACHED"
+	SynthesisFailed    Code = "SYNTHESIS_FAILED"
+	SourceAudioInvalid Code = "SOURCE_AUDIO_INVALID"
+	FileColdStorage    Code = "FILE_IN_COLD_STORAGE"
+	// UploadPolicy is an upload its file policy refuses; the body's
+	// violation field says why
+	UploadPolicy      Code = "UPLOAD_POLICY_VIOLATION"
+	FileQuarantined   Code = "FILE_QUARANTINED"
+	FolderNotEmpty    Code = "FOLDER_NOT_EMPTY"
+	UploadOffset      Code = "UPLOAD_OFFSET_MISMATCH"
+	UploadIncomplete  Code = "UPLOAD_INCOMPLETE"
+	ChecksumMismatch  Code = "CHECKSUM_MISMATCH"
+	ShareLimit        Code = "SHARE_LIMIT_REACHED"
+	WebhookLimit      Code = "WEBHOOK_LIMIT_REACHED"
+	IdempotencyBusy   Code = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	IdempotencyReused Code = "IDEMPOTENCY_KEY_REUSED"
+	// JobRunning is a maintenance job triggered while it runs
+	JobRunning Code = "JOB_ALREADY_RUNNING"
+)
+
+// Limits
+const (
+	// QuotaExceeded is a plan or storage limit; the body's quota field says
+	// which
+	QuotaExceeded    Code = "QUOTA_EXCEEDED"
+	DemoLimitReached Code = "DEMO_LIMIT_REACHED"
+	Overloaded       Code = "OVERLOADED"
+	Maintenance      Code = "MAINTENANCE"
+	FeatureDisabled  Code = "FEATURE_NOT_CONFIGURED"
+)
+
+var byStatus = map[int]Code{
+	http.StatusBadRequest:            BadRequest,
+	http.StatusUnauthorized:          Unauthorized,
+	http.StatusForbidden:             Forbidden,
+	http.StatusNotFound:              NotFound,
+	http.StatusMethodNotAllowed:      MethodNotAllowed,
+	http.StatusConflict:              Conflict,
+	http.StatusGone:                  Gone,
+	http.StatusPreconditionFailed:    PreconditionFailed,
+	http.StatusRequestEntityTooLarge: PayloadTooLarge,
+	http.StatusUnsupportedMediaType:  UnsupportedMediaType,
+	http.StatusUnprocessableEntity:   Unprocessable,
+	http.StatusTooManyRequests:       RateLimited,
+	http.StatusBadGateway:            UpstreamError,
+	http.StatusServiceUnavailable:    Unavailable,
+	http.StatusGatewayTimeout:        Timeout,
+}
+
+// ForStatus returns the generic code of a status: a 4xx without its own
+// code is BAD_REQUEST and a 5xx INTERNAL_ERROR
+func ForStatus(status int) Code {
+	if code, ok := byStatus[status]; ok {
+		return code
+	}
+	if status >= 400 && status < 500 {
+		return BadRequest
+	}
+	return Internal
+}
+
+// Detail is one problem of a request. Field names the part of the request
+// it is about, such as a field or a file, when there is one.
+type Detail struct {
+	Field   string `json:"field,omitempty"`
+	Code    Code   `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error response. Its JSON form is the error of the response
+// envelope.
+type Error struct {
+	Status  int      `json:"-"`
+	Code    Code     `json:"code"`
+	Message string   `json:"message"`
+	Details []Detail `json:"details,omitempty"`
+}
+
+// New returns an error response with no details
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
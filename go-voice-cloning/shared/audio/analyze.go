@@ -0,0 +1,189 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// ErrUnsupportedEncoding means Analyze can't decode a file's samples; only
+// integer PCM WAV files are analyzed
+var ErrUnsupportedEncoding = errors.New("unsupported audio encoding")
+
+const (
+	// frameDuration is the window loudness is measured over
+	frameDuration = 20 * time.Millisecond
+	// speechMargin is how much louder than the noise floor a frame must
+	// be to count as speech, as a power ratio (10 dB)
+	speechMargin = 10
+	// silencePower is the power below which a frame is silent however quiet
+	// the recording is (-50 dBFS)
+	silencePower = 1e-5
+
+	energyBins = 8
+	zcrBins    = 8
+)
+
+// Analysis describes the speech in a recording
+type Analysis struct {
+	Duration time.Duration
+	// SpeechDuration is the audio louder than the noise floor, what a
+	// model can learn the voice from
+	SpeechDuration time.Duration
+	// SNR is the power of the speech over the power of the rest, in dB.
+	// It is 0 when the recording has no speech.
+	SNR float64
+
+	// profile is a normalized histogram of the loudness and zero-crossing
+	// rate of speech frames, a coarse signature of the voice
+	profile []float64
+}
+
+// Analyze measures the speech in a PCM WAV file
+func Analyze(path string) (Analysis, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Analysis{}, err
+	}
+	defer f.Close()
+
+	info, err := ProbeWAV(f)
+	if err != nil {
+		return Analysis{}, err
+	}
+	width := int(info.BitsPerSample / 8)
+	if info.AudioFormat != 1 || info.BitsPerSample%8 != 0 || width < 1 || width > 4 ||
+		info.Channels == 0 || info.SampleRate == 0 {
+		return Analysis{}, ErrUnsupportedEncoding
+	}
+	if _, err := f.Seek(info.DataOffset, io.SeekStart); err != nil {
+		return Analysis{}, err
+	}
+
+	frameLength := int(info.SampleRate) * int(frameDuration/time.Millisecond) / 1000
+	if frameLength == 0 {
+		frameLength = 1
+	}
+	var powers, zcrs []float64
+	r := bufio.NewReader(io.LimitReader(f, info.DataBytes))
+	sample := make([]byte, width*int(info.Channels))
+	var sum float64
+	var crossings, n int
+	previous := 0.0
+	for {
+		if _, err := io.ReadFull(r, sample); err != nil {
+			break
+		}
+		// Channels are mixed down to mono
+		var v float64
+		for c := 0; c < int(info.Channels); c++ {
+			v += decodeSample(sample[c*width : (c+1)*width])
+		}
+		v /= float64(info.Channels)
+
+		sum += v * v
+		if (v >= 0) != (previous >= 0) {
+			crossings++
+		}
+		previous = v
+		n++
+		if n == frameLength {
+			powers = append(powers, sum/float64(n))
+			zcrs = append(zcrs, float64(crossings)/float64(n))
+			sum, crossings, n = 0, 0, 0
+		}
+	}
+
+	a := Analysis{Duration: info.Duration()}
+	if len(powers) == 0 {
+		return a, nil
+	}
+
+	// The noise floor is the loudness of the quietest tenth of the frames
+	sorted := append([]float64(nil), powers...)
+	sort.Float64s(sorted)
+	floor := math.Max(sorted[len(sorted)/10], 1e-12)
+	threshold := math.Max(floor*speechMargin, silencePower)
+
+	var speechPower, noisePower float64
+	var speechFrames, noiseFrames int
+	a.profile = make([]float64, energyBins*zcrBins)
+	for i, p := range powers {
+		if p <= threshold {
+			noisePower += p
+			noiseFrames++
+			continue
+		}
+		speechPower += p
+		speechFrames++
+		// Speech frames range from -50 dBFS to full scale
+		e := int((10*math.Log10(p) + 50) / 50 * energyBins)
+		z := int(zcrs[i] * 2 * zcrBins)
+		a.profile[clampBin(e, energyBins)*zcrBins+clampBin(z, zcrBins)]++
+	}
+	a.SpeechDuration = time.Duration(speechFrames) * frameDuration
+	if speechFrames == 0 {
+		a.profile = nil
+		return a, nil
+	}
+	speechPower /= float64(speechFrames)
+	if noiseFrames > 0 {
+		noisePower /= float64(noiseFrames)
+	}
+	a.SNR = 10 * math.Log10(speechPower/math.Max(noisePower, floor))
+	for i := range a.profile {
+		a.profile[i] /= float64(speechFrames)
+	}
+	return a, nil
+}
+
+// Similarity compares the speech profiles of two recordings, from 0 for
+// nothing in common to 1 for the same loudness and pitch distribution. It
+// is a rough proxy for how alike two voices sound, not speaker
+// verification; recordings without speech score 0.
+func Similarity(a, b Analysis) float64 {
+	if len(a.profile) == 0 || len(a.profile) != len(b.profile) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a.profile {
+		dot += a.profile[i] * b.profile[i]
+		na += a.profile[i] * a.profile[i]
+		nb += b.profile[i] * b.profile[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return math.Min(dot/math.Sqrt(na*nb), 1)
+}
+
+// decodeSample reads a little-endian PCM sample as a value from -1 to 1.
+// 8-bit samples are unsigned, wider ones signed.
+func decodeSample(b []byte) float64 {
+	switch len(b) {
+	case 1:
+		return (float64(b[0]) - 128) / 128
+	case 2:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case 3:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+func clampBin(i, bins int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= bins {
+		return bins - 1
+	}
+	return i
+}
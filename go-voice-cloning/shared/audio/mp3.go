@@ -0,0 +1,186 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// mp3SearchLimit bounds how far past any ID3 tag the first frame is looked for
+const mp3SearchLimit = 64 << 10
+
+// Bitrates in kbps by bitrate index, for MPEG-1 layers I-III and MPEG-2 and
+// 2.5 layer I and layers II-III
+var mp3Bitrates = [5][16]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mp3Frame is a decoded MPEG audio frame header
+type mp3Frame struct {
+	mpeg1      bool
+	layer      int
+	bitrate    int // bits per second
+	sampleRate int
+	channels   int
+	samples    int // per frame
+	length     int // bytes, including the header
+}
+
+// parseMP3Frame decodes a frame header, reporting false if b doesn't start
+// with a valid one
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	var f mp3Frame
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return f, false
+	}
+	version := (b[1] >> 3) & 3 // 0 MPEG-2.5, 2 MPEG-2, 3 MPEG-1
+	layerBits := (b[1] >> 1) & 3
+	bitrateIndex := b[2] >> 4
+	rateIndex := (b[2] >> 2) & 3
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return f, false
+	}
+
+	f.mpeg1 = version == 3
+	f.layer = 4 - int(layerBits)
+	table := f.layer - 1
+	if !f.mpeg1 {
+		table = 3
+		if f.layer > 1 {
+			table = 4
+		}
+	}
+	f.bitrate = mp3Bitrates[table][bitrateIndex] * 1000
+	f.sampleRate = [3]int{44100, 48000, 32000}[rateIndex]
+	switch version {
+	case 2:
+		f.sampleRate /= 2
+	case 0:
+		f.sampleRate /= 4
+	}
+	f.channels = 2
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+
+	padding := int((b[2] >> 1) & 1)
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+		f.length = (12*f.bitrate/f.sampleRate + padding) * 4
+	case f.layer == 3 && !f.mpeg1:
+		f.samples = 576
+		f.length = 72*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.length = 144*f.bitrate/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// ProbeMP3 reads the format and length of an MPEG audio file. The length
+// comes from a Xing, Info or VBRI header when the encoder wrote one, and is
+// estimated from the bitrate otherwise.
+func ProbeMP3(r io.ReadSeeker) (Info, error) {
+	info := Info{Format: FormatMP3}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return info, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info, err
+	}
+
+	// Skip an ID3v2 tag; its size is syncsafe, 7 bits per byte
+	start := int64(0)
+	var tag [10]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return info, fmt.Errorf("%w: not an MP3 file", ErrUnsupportedFormat)
+	}
+	if string(tag[0:3]) == "ID3" {
+		start = 10 + (int64(tag[6])<<21 | int64(tag[7])<<14 | int64(tag[8])<<7 | int64(tag[9]))
+		if tag[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return info, err
+	}
+	buf := make([]byte, mp3SearchLimit)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return info, fmt.Errorf("%w: not an MP3 file", ErrUnsupportedFormat)
+	}
+	buf = buf[:n]
+
+	// The first frame is the first header followed by another, or by the
+	// end of the file, so stray sync bytes in a tag aren't taken for one
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + frame.length
+		if next+4 <= len(buf) {
+			if _, ok := parseMP3Frame(buf[next:]); !ok {
+				continue
+			}
+		} else if start+int64(next) < size {
+			continue
+		}
+
+		info.SampleRate = uint32(frame.sampleRate)
+		info.Channels = uint16(frame.channels)
+		info.Samples = mp3Frames(buf[i:], frame) * int64(frame.samples)
+		if info.Samples == 0 {
+			// Constant bitrate: the audio is the rest of the file, less
+			// any ID3v1 tag at its end
+			dataBytes := size - start - int64(i)
+			if size >= 128 {
+				var trailer [3]byte
+				if _, err := r.Seek(size-128, io.SeekStart); err == nil {
+					if _, err := io.ReadFull(r, trailer[:]); err == nil && string(trailer[:]) == "TAG" {
+						dataBytes -= 128
+					}
+				}
+			}
+			info.Samples = dataBytes * 8 * int64(frame.sampleRate) / int64(frame.bitrate)
+		}
+		return info, nil
+	}
+	return info, fmt.Errorf("%w: no MPEG audio frame found", ErrUnsupportedFormat)
+}
+
+// mp3Frames returns the frame count of a Xing, Info or VBRI header in the
+// first frame, or 0 without one
+func mp3Frames(b []byte, frame mp3Frame) int64 {
+	if len(b) > frame.length {
+		b = b[:frame.length]
+	}
+	// The Xing header follows the side information, whose size depends on
+	// the version and channels
+	side := 32
+	switch {
+	case frame.mpeg1 && frame.channels == 1:
+		side = 17
+	case !frame.mpeg1 && frame.channels == 2:
+		side = 17
+	case !frame.mpeg1:
+		side = 9
+	}
+	if x := 4 + side; len(b) >= x+12 {
+		if id := string(b[x : x+4]); id == "Xing" || id == "Info" {
+			if binary.BigEndian.Uint32(b[x+4:x+8])&1 != 0 {
+				return int64(binary.BigEndian.Uint32(b[x+8 : x+12]))
+			}
+		}
+	}
+	if len(b) >= 36+18 && string(b[36:40]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(b[50:54]))
+	}
+	return 0
+}
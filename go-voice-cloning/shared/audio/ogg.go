@@ -0,0 +1,101 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// oggTailBytes is how much of the end of an Ogg file is searched for its
+// last page, whose granule position gives the length
+const oggTailBytes = 64 << 10
+
+// opusGranuleRate is the rate Opus granule positions count samples at,
+// whatever the input's sample rate was
+const opusGranuleRate = 48000
+
+// ProbeOgg reads the format and length of an Ogg Vorbis or Opus file
+func ProbeOgg(r io.ReadSeeker) (Info, error) {
+	info := Info{Format: FormatOgg}
+	var page [27]byte
+	if _, err := io.ReadFull(r, page[:]); err != nil || string(page[0:4]) != "OggS" {
+		return info, fmt.Errorf("%w: not an Ogg file", ErrUnsupportedFormat)
+	}
+	serial := binary.LittleEndian.Uint32(page[14:18])
+	segments := make([]byte, page[26])
+	if _, err := io.ReadFull(r, segments); err != nil {
+		return info, fmt.Errorf("%w: short Ogg page", ErrUnsupportedFormat)
+	}
+	length := 0
+	for _, s := range segments {
+		length += int(s)
+	}
+	// The first page holds only the codec's identification header
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return info, fmt.Errorf("%w: short Ogg page", ErrUnsupportedFormat)
+	}
+
+	var granuleRate, preSkip int64
+	switch {
+	case len(packet) >= 16 && string(packet[0:7]) == "\x01vorbis":
+		info.Codec = CodecVorbis
+		info.Channels = uint16(packet[11])
+		info.SampleRate = binary.LittleEndian.Uint32(packet[12:16])
+		granuleRate = int64(info.SampleRate)
+	case len(packet) >= 16 && string(packet[0:8]) == "OpusHead":
+		info.Codec = CodecOpus
+		info.Channels = uint16(packet[9])
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		info.SampleRate = binary.LittleEndian.Uint32(packet[12:16])
+		if info.SampleRate == 0 {
+			info.SampleRate = opusGranuleRate
+		}
+		granuleRate = opusGranuleRate
+	default:
+		return info, fmt.Errorf("%w: Ogg file isn't Vorbis or Opus", ErrUnsupportedFormat)
+	}
+	if info.Channels == 0 || info.SampleRate == 0 {
+		return info, fmt.Errorf("%w: invalid %s header", ErrUnsupportedFormat, info.Codec)
+	}
+
+	granule, err := lastOggGranule(r, serial)
+	if err != nil {
+		return info, err
+	}
+	if granule -= preSkip; granule > 0 {
+		info.Samples = granule * int64(info.SampleRate) / granuleRate
+	}
+	return info, nil
+}
+
+// lastOggGranule returns the granule position of the stream's last page, the
+// number of samples in it
+func lastOggGranule(r io.ReadSeeker, serial uint32) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	offset := size - oggTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+			continue
+		}
+		// -1 marks a page on which no packet ends
+		if granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14])); granule >= 0 {
+			return granule, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,87 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrUnsupportedFormat means Probe doesn't recognise a file's format
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Probe reads the format of a WAV, FLAC, MP3 or Ogg file, recognised by
+// its magic bytes
+func Probe(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return Info{}, ErrUnsupportedFormat
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+	switch string(magic[:]) {
+	case "RIFF":
+		return ProbeWAV(f)
+	case "fLaC":
+		return ProbeFLAC(f)
+	case "OggS":
+		return ProbeOgg(f)
+	}
+	if string(magic[0:3]) == "ID3" || (magic[0] == 0xFF && magic[1]&0xE0 == 0xE0) {
+		return ProbeMP3(f)
+	}
+	return Info{}, ErrUnsupportedFormat
+}
+
+// ContentType is the MIME type of audio in a format Probe recognises, given
+// the codec of Ogg files, or "" for other formats
+func ContentType(format, codec string) string {
+	switch format {
+	case FormatWAV:
+		return "audio/wav"
+	case FormatFLAC:
+		return "audio/flac"
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatOgg:
+		if codec == CodecOpus {
+			return "audio/ogg; codecs=opus"
+		}
+		return "audio/ogg"
+	}
+	return ""
+}
+
+// ProbeFLAC reads the STREAMINFO block of a FLAC file
+func ProbeFLAC(r io.Reader) (Info, error) {
+	info := Info{Format: FormatFLAC}
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[0:4]) != "fLaC" {
+		return info, fmt.Errorf("%w: not a FLAC file", ErrUnsupportedFormat)
+	}
+	// STREAMINFO is always the first metadata block
+	if header[4]&0x7F != 0 {
+		return info, fmt.Errorf("%w: FLAC file lacks STREAMINFO", ErrUnsupportedFormat)
+	}
+	var block [34]byte
+	if _, err := io.ReadFull(r, block[:]); err != nil {
+		return info, fmt.Errorf("%w: short STREAMINFO block", ErrUnsupportedFormat)
+	}
+
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bits per sample-1,
+	// 36 bits total samples
+	v := binary.BigEndian.Uint64(block[10:18])
+	info.SampleRate = uint32(v >> 44)
+	info.Channels = uint16((v>>41)&0x7) + 1
+	info.BitsPerSample = uint16((v>>36)&0x1F) + 1
+	info.Samples = int64(v & (1<<36 - 1))
+	return info, nil
+}
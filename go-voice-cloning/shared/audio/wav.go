@@ -0,0 +1,206 @@
+// Package audio reads the format of audio samples, combines WAV files and
+// measures the speech in them.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotWAV means a file isn't a RIFF/WAVE file
+var ErrNotWAV = errors.New("not a WAV file")
+
+// Formats Probe recognises
+const (
+	FormatWAV  = "wav"
+	FormatFLAC = "flac"
+	FormatMP3  = "mp3"
+	FormatOgg  = "ogg"
+)
+
+// Codecs of Ogg files
+const (
+	CodecVorbis = "vorbis"
+	CodecOpus   = "opus"
+)
+
+// Info is the format of an audio file. The data chunk fields and
+// BitsPerSample are only set for WAV and FLAC files, Samples for the others,
+// and Codec for Ogg files.
+type Info struct {
+	Format        string
+	Codec         string
+	AudioFormat   uint16 // WAV encoding; 1 is PCM
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+	ByteRate      uint32
+	DataOffset    int64
+	DataBytes     int64
+	Samples       int64
+}
+
+// Duration is the playing time of the audio data
+func (i Info) Duration() time.Duration {
+	if i.Format != FormatWAV && i.SampleRate > 0 {
+		return time.Duration(float64(i.Samples) / float64(i.SampleRate) * float64(time.Second))
+	}
+	if i.ByteRate == 0 {
+		return 0
+	}
+	return time.Duration(float64(i.DataBytes) / float64(i.ByteRate) * float64(time.Second))
+}
+
+// SameFormat reports whether two files' samples can be concatenated
+func (i Info) SameFormat(o Info) bool {
+	return i.AudioFormat == o.AudioFormat && i.Channels == o.Channels &&
+		i.SampleRate == o.SampleRate && i.BitsPerSample == o.BitsPerSample
+}
+
+// ProbeWAV reads the format and data chunk location of a WAV file
+func ProbeWAV(r io.ReadSeeker) (Info, error) {
+	info := Info{Format: FormatWAV}
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return info, ErrNotWAV
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return info, ErrNotWAV
+	}
+
+	offset := int64(12)
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return info, fmt.Errorf("%w: no data chunk", ErrNotWAV)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		offset += 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return info, fmt.Errorf("%w: short fmt chunk", ErrNotWAV)
+			}
+			var format [16]byte
+			if _, err := io.ReadFull(r, format[:]); err != nil {
+				return info, fmt.Errorf("%w: short fmt chunk", ErrNotWAV)
+			}
+			info.AudioFormat = binary.LittleEndian.Uint16(format[0:2])
+			info.Channels = binary.LittleEndian.Uint16(format[2:4])
+			info.SampleRate = binary.LittleEndian.Uint32(format[4:8])
+			info.ByteRate = binary.LittleEndian.Uint32(format[8:12])
+			info.BitsPerSample = binary.LittleEndian.Uint16(format[14:16])
+			haveFormat = true
+			if _, err := r.Seek(size-16+size%2, io.SeekCurrent); err != nil {
+				return info, err
+			}
+		case "data":
+			if !haveFormat {
+				return info, fmt.Errorf("%w: data before fmt chunk", ErrNotWAV)
+			}
+			info.DataOffset = offset
+			info.DataBytes = size
+			return info, nil
+		default:
+			// Chunks are padded to an even size
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return info, err
+			}
+		}
+		offset += size + size%2
+	}
+}
+
+// ProbeWAVFile is ProbeWAV of the file at path
+func ProbeWAVFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	return ProbeWAV(f)
+}
+
+// ConcatWAV writes the audio of srcs, in order, as a single WAV file at dst.
+// The sources must share one format.
+func ConcatWAV(dst string, srcs []string) error {
+	infos := make([]Info, len(srcs))
+	var total int64
+	for i, src := range srcs {
+		info, err := ProbeWAVFile(src)
+		if err != nil {
+			return fmt.Errorf("source %d: %w", i+1, err)
+		}
+		if i > 0 && !info.SameFormat(infos[0]) {
+			return fmt.Errorf("source %d: format differs from source 1 (%d Hz, %d channels, %d bits)",
+				i+1, infos[0].SampleRate, infos[0].Channels, infos[0].BitsPerSample)
+		}
+		infos[i] = info
+		total += info.DataBytes
+	}
+	if len(infos) == 0 {
+		return errors.New("no sources")
+	}
+	if total > 0xFFFFFFFF-36 {
+		return errors.New("combined audio is too long for a WAV file")
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := writeWAV(out, infos, srcs, total); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func writeWAV(out io.Writer, infos []Info, srcs []string, total int64) error {
+	f := infos[0]
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+total+total%2))
+	copy(header[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], f.AudioFormat)
+	binary.LittleEndian.PutUint16(header[22:24], f.Channels)
+	binary.LittleEndian.PutUint32(header[24:28], f.SampleRate)
+	binary.LittleEndian.PutUint32(header[28:32], f.ByteRate)
+	binary.LittleEndian.PutUint16(header[32:34], f.Channels*f.BitsPerSample/8)
+	binary.LittleEndian.PutUint16(header[34:36], f.BitsPerSample)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(total))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	for i, src := range srcs {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		_, err = in.Seek(infos[i].DataOffset, io.SeekStart)
+		if err == nil {
+			_, err = io.CopyN(out, in, infos[i].DataBytes)
+		}
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("source %d: %w", i+1, err)
+		}
+	}
+	// Keep the data chunk padded to an even size
+	if total%2 == 1 {
+		_, err := out.Write([]byte{0})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package audio
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+)
+
+// Waveform is the outline of a recording for display: the lowest and
+// highest sample of each run of SamplesPerPeak frames, mixed down to mono and
+// scaled to 8 bits. It's laid out like audiowaveform's data, so players
+// that read that format can draw it.
+type Waveform struct {
+	SampleRate     uint32
+	SamplesPerPeak int
+	// Peaks holds a min, max pair per run
+	Peaks []int8
+}
+
+// Length is the number of min, max pairs
+func (w Waveform) Length() int {
+	return len(w.Peaks) / 2
+}
+
+// ComputeWaveform reads the peaks of a PCM WAV file, about peaksPerSecond
+// of them per second of audio. Longer recordings get fewer, so there are at
+// most maxPeaks.
+func ComputeWaveform(path string, peaksPerSecond, maxPeaks int) (Waveform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Waveform{}, err
+	}
+	defer f.Close()
+
+	info, err := ProbeWAV(f)
+	if err != nil {
+		return Waveform{}, err
+	}
+	width := int(info.BitsPerSample / 8)
+	if info.AudioFormat != 1 || info.BitsPerSample%8 != 0 || width < 1 || width > 4 ||
+		info.Channels == 0 || info.SampleRate == 0 {
+		return Waveform{}, ErrUnsupportedEncoding
+	}
+	if _, err := f.Seek(info.DataOffset, io.SeekStart); err != nil {
+		return Waveform{}, err
+	}
+
+	frameSize := width * int(info.Channels)
+	frames := info.DataBytes / int64(frameSize)
+	w := Waveform{SampleRate: info.SampleRate, SamplesPerPeak: int(info.SampleRate) / peaksPerSecond}
+	if w.SamplesPerPeak < 1 {
+		w.SamplesPerPeak = 1
+	}
+	if maxPeaks > 0 && frames > int64(w.SamplesPerPeak)*int64(maxPeaks) {
+		w.SamplesPerPeak = int((frames + int64(maxPeaks) - 1) / int64(maxPeaks))
+	}
+
+	r := bufio.NewReader(io.LimitReader(f, info.DataBytes))
+	frame := make([]byte, frameSize)
+	low, high := math.Inf(1), math.Inf(-1)
+	n := 0
+	for {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			break
+		}
+		var v float64
+		for c := 0; c < int(info.Channels); c++ {
+			v += decodeSample(frame[c*width : (c+1)*width])
+		}
+		v /= float64(info.Channels)
+
+		low, high = math.Min(low, v), math.Max(high, v)
+		n++
+		if n == w.SamplesPerPeak {
+			w.Peaks = append(w.Peaks, peakValue(low), peakValue(high))
+			low, high, n = math.Inf(1), math.Inf(-1), 0
+		}
+	}
+	if n > 0 {
+		w.Peaks = append(w.Peaks, peakValue(low), peakValue(high))
+	}
+	return w, nil
+}
+
+// Resample merges runs of peaks so there are at most length pairs
+func (w Waveform) Resample(length int) Waveform {
+	if length <= 0 || w.Length() <= length {
+		return w
+	}
+	factor := (w.Length() + length - 1) / length
+	merged := Waveform{SampleRate: w.SampleRate, SamplesPerPeak: w.SamplesPerPeak * factor}
+	for i := 0; i < len(w.Peaks); i += 2 * factor {
+		end := i + 2*factor
+		if end > len(w.Peaks) {
+			end = len(w.Peaks)
+		}
+		low, high := w.Peaks[i], w.Peaks[i+1]
+		for j := i + 2; j < end; j += 2 {
+			if w.Peaks[j] < low {
+				low = w.Peaks[j]
+			}
+			if w.Peaks[j+1] > high {
+				high = w.Peaks[j+1]
+			}
+		}
+		merged.Peaks = append(merged.Peaks, low, high)
+	}
+	return merged
+}
+
+// peakValue scales a sample from -1 to 1 to 8 bits
+func peakValue(v float64) int8 {
+	return int8(math.Max(-128, math.Min(127, math.Round(v*128))))
+}
@@ -0,0 +1,235 @@
+// Package audit records security-relevant operations, such as sign-ins,
+// role changes and access to other users' data, to a central audit store.
+// Services describe each operation as a Record of who did what to which
+// resource and how it turned out. Records are buffered and shipped in
+// batches by a background writer, so recording doesn't slow requests down,
+// to the audit_log table or to the audit.recorded topic of the event bus.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Outcome is how an operation turned out
+type Outcome string
+
+const (
+	// Success is an operation that was carried out
+	Success Outcome = "success"
+	// Failure is an operation that was attempted and failed, such as a
+	// sign-in with a wrong password
+	Failure Outcome = "failure"
+	// Denied is an operation refused for lack of permission
+	Denied Outcome = "denied"
+)
+
+// Record is one audited operation. ActorID is 0 for anonymous callers,
+// such as someone signing in; Details hold the operation's specifics.
+type Record struct {
+	Service      string                 `json:"service"`
+	ActorID      int                    `json:"actor_id"`
+	ActorRole    string                 `json:"actor_role,omitempty"`
+	Action       string                 `json:"action"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id,omitempty"`
+	Outcome      Outcome                `json:"outcome"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	RequestID    string                 `json:"request_id,omitempty"`
+	IPAddress    string                 `json:"ip_address,omitempty"`
+	OccurredAt   time.Time              `json:"occurred_at"`
+}
+
+// EventType makes records publishable on the event bus
+func (Record) EventType() string { return "audit.recorded" }
+
+// Sink stores batches of records
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+// Options tune a Log. Zero values take the defaults.
+type Options struct {
+	// BufferSize records are held while the sink catches up (default
+	// 1024). Recording blocks once it is full.
+	BufferSize int
+	// BatchSize records at most are written at once (default 100)
+	BatchSize int
+	// FlushInterval is the longest a record waits to be written (default
+	// one second)
+	FlushInterval time.Duration
+	// MaxAttempts to write a batch before its records are logged instead
+	// (default 5)
+	MaxAttempts int
+}
+
+func (o *Options) applyDefaults() {
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1024
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+}
+
+// Log records a service's operations to a sink
+type Log struct {
+	service string
+	sink    Sink
+	opts    Options
+
+	records   chan Record
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New starts a log of service's operations writing to sink. Close it to
+// write what is buffered.
+func New(service string, sink Sink, opts Options) *Log {
+	opts.applyDefaults()
+	l := &Log{
+		service: service,
+		sink:    sink,
+		opts:    opts,
+		records: make(chan Record, opts.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Record queues a record for writing, filling in the service and time. It
+// waits for room in the buffer unless ctx ends first, in which case the
+// record is logged instead.
+func (l *Log) Record(ctx context.Context, rec Record) {
+	rec.Service = l.service
+	if rec.OccurredAt.IsZero() {
+		rec.OccurredAt = time.Now().UTC()
+	}
+	if rec.Outcome == "" {
+		rec.Outcome = Success
+	}
+	select {
+	case l.records <- rec:
+	case <-ctx.Done():
+		logUnwritten([]Record{rec}, ctx.Err())
+	}
+}
+
+// Request records an operation of a request's caller, with its request ID
+// and client address
+func (l *Log) Request(r *http.Request, action, resourceType, resourceID string, outcome Outcome, details map[string]interface{}) {
+	rec := FromRequest(r)
+	rec.Action = action
+	rec.ResourceType = resourceType
+	rec.ResourceID = resourceID
+	rec.Outcome = outcome
+	rec.Details = details
+	l.Record(r.Context(), rec)
+}
+
+// FromRequest starts a record of an operation of a request's caller, for
+// operations whose actor isn't the authenticated caller, such as signing in
+func FromRequest(r *http.Request) Record {
+	return Record{
+		ActorID:   middleware.UserID(r),
+		ActorRole: r.Header.Get(middleware.UserRoleHeader),
+		RequestID: r.Header.Get(utils.RequestIDHeader),
+		IPAddress: clientIP(r),
+	}
+}
+
+// clientIP is the address the gateway received the request from, which it
+// appends to X-Forwarded-For, or the direct caller's. Earlier entries are
+// the client's to set, so they aren't trusted.
+func clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		addr = strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return addr
+}
+
+// Close writes the buffered records and stops the writer
+func (l *Log) Close() {
+	l.closeOnce.Do(func() {
+		close(l.records)
+		<-l.done
+	})
+}
+
+// run writes records in batches, once a batch is full or the oldest record
+// has waited the flush interval
+func (l *Log) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, l.opts.BatchSize)
+	for {
+		select {
+		case rec, ok := <-l.records:
+			if !ok {
+				l.write(batch)
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) < l.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		l.write(batch)
+		batch = batch[:0]
+	}
+}
+
+// write sends a batch to the sink, retrying with backoff. Records the sink
+// keeps refusing are logged, so they reach the service's logs at least.
+func (l *Log) write(batch []Record) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= l.opts.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = l.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		slog.Warn("Failed to write audit records", "records", len(batch), "attempt", attempt, "error", err)
+		if attempt < l.opts.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logUnwritten(batch, err)
+}
+
+func logUnwritten(records []Record, err error) {
+	for _, rec := range records {
+		doc, _ := json.Marshal(rec)
+		slog.Error("Audit record not written", "record", string(doc), "error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/events"
+)
+
+// Sinks selected with AUDIT_SINK
+const (
+	SinkDB  = "db"
+	SinkBus = "bus"
+)
+
+// Config selects where records go. Services hold it in an Audit field of
+// their own Config.
+type Config struct {
+	// Sink is db for the audit_log table, or bus for the audit.recorded
+	// topic of the event bus (EVENT_BUS)
+	Sink string `env:"AUDIT_SINK" default:"db"`
+}
+
+// NewSink returns the configured sink. The bus sink needs a bus.
+func NewSink(cfg Config, db *sqlx.DB, bus events.Publisher) (Sink, error) {
+	switch cfg.Sink {
+	case SinkDB:
+		return DBSink{DB: db}, nil
+	case SinkBus:
+		if bus == nil {
+			return nil, fmt.Errorf("AUDIT_SINK=%s requires EVENT_BUS", SinkBus)
+		}
+		return BusSink{Bus: bus}, nil
+	default:
+		return nil, fmt.Errorf("unknown AUDIT_SINK %q", cfg.Sink)
+	}
+}
+
+// Open starts a log of service's operations to the configured sink
+func Open(service string, cfg Config, db *sqlx.DB, bus events.Publisher) (*Log, error) {
+	sink, err := NewSink(cfg, db, bus)
+	if err != nil {
+		return nil, err
+	}
+	return New(service, sink, Options{}), nil
+}
+
+// DBSink writes records to the audit_log table
+type DBSink struct {
+	DB *sqlx.DB
+}
+
+func (s DBSink) Write(ctx context.Context, records []Record) error {
+	const columns = 11
+	values := make([]string, 0, len(records))
+	args := make([]interface{}, 0, len(records)*columns)
+	for i, rec := range records {
+		var details string
+		if len(rec.Details) > 0 {
+			doc, err := json.Marshal(rec.Details)
+			if err != nil {
+				return err
+			}
+			details = string(doc)
+		}
+		placeholders := make([]string, columns)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*columns+j+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, rec.Service, rec.ActorID, nullIfEmpty(rec.ActorRole), rec.Action, rec.ResourceType,
+			nullIfEmpty(rec.ResourceID), rec.Outcome, nullIfEmpty(details), nullIfEmpty(rec.RequestID), nullIfEmpty(rec.IPAddress), rec.OccurredAt)
+	}
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO audit_log (service, actor_id, actor_role, action, resource_type, resource_id, outcome, details,
+			request_id, ip_address, occurred_at) VALUES `+strings.Join(values, ", "),
+		args...)
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// BusSink publishes each record as an audit.recorded event, for a central
+// audit store consuming the topic
+type BusSink struct {
+	Bus events.Publisher
+}
+
+func (s BusSink) Write(ctx context.Context, records []Record) error {
+	for _, rec := range records {
+		if err := events.Publish(ctx, s.Bus, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package cache keeps values the services look up often, such as validated
+// tokens, users' entitlements and gallery listings, for a while instead of
+// asking for them every time. Values are JSON-encoded under namespaced keys,
+// <prefix>:<namespace>:<key>, in Redis when CACHE_REDIS_URL is set, so the
+// instances of a service share them and can invalidate them for each other,
+// or otherwise in the memory of each process, for local development.
+//
+// A cache is an optimization: callers treat errors as misses and carry on
+// with the source of truth.
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Cache stores values for a time
+type Cache interface {
+	// Get decodes the value of key into value, reporting false when there
+	// is none or it has expired
+	Get(ctx context.Context, key string, value interface{}) (bool, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	// Delete removes keys, whether or not they are set
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Config selects the cache. Services hold it in a Cache field of their own
+// Config.
+type Config struct {
+	// RedisURL is the Redis server shared by the instances; the memory of
+	// each process is used without it
+	RedisURL string `env:"CACHE_REDIS_URL" secret:"true"`
+	// Prefix starts every key, keeping them apart from other users of the
+	// server
+	Prefix string `env:"CACHE_PREFIX" default:"voice-cloning"`
+	// MaxEntries bounds the in-memory cache
+	MaxEntries int `env:"CACHE_MEMORY_MAX_ENTRIES" default:"10000" min:"1"`
+}
+
+// New returns the configured cache, with keys under cfg.Prefix
+func New(cfg Config) (Cache, error) {
+	if cfg.RedisURL == "" {
+		slog.Info("Caching in memory; set CACHE_REDIS_URL to share the cache between instances")
+		return Namespace(NewMemory(cfg.MaxEntries), cfg.Prefix), nil
+	}
+	c, err := NewRedis(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	return Namespace(c, cfg.Prefix), nil
+}
+
+// Namespace returns a view of c keeping its keys under name, so the uses of
+// a cache don't collide
+func Namespace(c Cache, name string) Cache {
+	if name == "" {
+		return c
+	}
+	return namespaced{cache: c, prefix: name + ":"}
+}
+
+type namespaced struct {
+	cache  Cache
+	prefix string
+}
+
+func (n namespaced) Get(ctx context.Context, key string, value interface{}) (bool, error) {
+	return n.cache.Get(ctx, n.prefix+key, value)
+}
+
+func (n namespaced) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return n.cache.Set(ctx, n.prefix+key, value, ttl)
+}
+
+func (n namespaced) Delete(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = n.prefix + key
+	}
+	return n.cache.Delete(ctx, prefixed...)
+}
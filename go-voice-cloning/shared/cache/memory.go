@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Memory is a cache in the memory of the process. Values are encoded as in
+// Redis, so callers get copies and see the same encoding errors.
+type Memory struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty cache of at most maxEntries values. Expired
+// values are dropped once it is full, then arbitrary ones.
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{maxEntries: maxEntries, entries: map[string]memoryEntry{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key string, value interface{}) (bool, error) {
+	m.mu.Lock()
+	entry, ok := m.entries[key]
+	if ok && !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		ok = false
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(entry.data, value)
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		m.evict()
+	}
+	m.entries[key] = memoryEntry{data: data, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// evict makes room for an entry: expired entries go first, or an arbitrary
+// one when none has expired
+func (m *Memory) evict() {
+	now := time.Now()
+	for key, entry := range m.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+	if len(m.entries) < m.maxEntries {
+		return
+	}
+	for key := range m.entries {
+		delete(m.entries, key)
+		return
+	}
+}
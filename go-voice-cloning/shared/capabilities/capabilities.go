@@ -0,0 +1,154 @@
+// Package capabilities is the catalog of models, languages and accents the
+// cloning engines support. The voice service validates clone settings
+// against it and lists it for clients; the voice worker uses it to route
+// jobs to the engine variant serving a model in a language.
+package capabilities
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Errors returned by Route
+var (
+	ErrUnsupportedModel    = errors.New("unsupported model")
+	ErrUnsupportedLanguage = errors.New("unsupported language")
+	ErrUnsupportedLocale   = errors.New("unsupported locale")
+)
+
+// Language is a language a model speaks. Tag is its primary subtag, such as
+// "en"; Locales are the regional accents available, such as "en-GB".
+// Variant overrides the model's engine variant for this language.
+type Language struct {
+	Tag     string   `json:"tag"`
+	Name    string   `json:"name"`
+	Locales []string `json:"locales,omitempty"`
+	Variant string   `json:"variant,omitempty"`
+}
+
+// Model is a model clients can pick in a clone's settings. Variant is what
+// the engine is asked to run, the model's name when empty.
+type Model struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Variant     string     `json:"variant,omitempty"`
+	Languages   []Language `json:"languages"`
+}
+
+// Catalog lists the models the engines support
+type Catalog struct {
+	Models []Model `json:"models"`
+}
+
+// Default is the catalog used without ENGINE_CAPABILITIES_PATH. Japanese,
+// Chinese and Korean run on a separately trained variant.
+var Default = Catalog{Models: []Model{
+	{
+		Name:        "standard",
+		Description: "Balanced quality and training time",
+		Languages: []Language{
+			{Tag: "en", Name: "English", Locales: []string{"en-US", "en-GB", "en-AU", "en-IN"}},
+			{Tag: "es", Name: "Spanish", Locales: []string{"es-ES", "es-MX", "es-US"}},
+			{Tag: "fr", Name: "French", Locales: []string{"fr-FR", "fr-CA"}},
+			{Tag: "de", Name: "German", Locales: []string{"de-DE", "de-AT", "de-CH"}},
+			{Tag: "it", Name: "Italian", Locales: []string{"it-IT"}},
+			{Tag: "pt", Name: "Portuguese", Locales: []string{"pt-BR", "pt-PT"}},
+			{Tag: "nl", Name: "Dutch", Locales: []string{"nl-NL", "nl-BE"}},
+			{Tag: "ja", Name: "Japanese", Locales: []string{"ja-JP"}, Variant: "standard-cjk"},
+			{Tag: "zh", Name: "Chinese", Locales: []string{"zh-CN", "zh-TW"}, Variant: "standard-cjk"},
+			{Tag: "ko", Name: "Korean", Locales: []string{"ko-KR"}, Variant: "standard-cjk"},
+		},
+	},
+	{
+		Name:        "expressive",
+		Description: "Higher fidelity and emotional range, slower to train",
+		Languages: []Language{
+			{Tag: "en", Name: "English", Locales: []string{"en-US", "en-GB"}},
+			{Tag: "es", Name: "Spanish", Locales: []string{"es-ES", "es-MX"}},
+			{Tag: "pt", Name: "Portuguese", Locales: []string{"pt-BR"}},
+		},
+	},
+}}
+
+// FromEnv loads the catalog from the JSON file at ENGINE_CAPABILITIES_PATH,
+// or returns Default when it isn't set
+func FromEnv() (Catalog, error) {
+	path := os.Getenv("ENGINE_CAPABILITIES_PATH")
+	if path == "" {
+		return Default, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Catalog{}, err
+	}
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return Catalog{}, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if len(catalog.Models) == 0 {
+		return Catalog{}, fmt.Errorf("%s lists no models", path)
+	}
+	return catalog, nil
+}
+
+// Model finds a model by name
+func (c Catalog) Model(name string) (Model, bool) {
+	for _, m := range c.Models {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// Route resolves the engine variant that trains and speaks model in a
+// language, a BCP 47 tag, and an optional locale. Without a locale, a
+// language tag with a region, such as "pt-BR", is checked as the locale.
+func (c Catalog) Route(model, language, locale string) (string, error) {
+	m, ok := c.Model(model)
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedModel, model)
+	}
+	primary := PrimaryTag(language)
+	if locale == "" && strings.Contains(language, "-") {
+		locale = language
+	}
+	if locale != "" && PrimaryTag(locale) != primary {
+		return "", fmt.Errorf("%w %q for language %q", ErrUnsupportedLocale, locale, language)
+	}
+
+	for _, l := range m.Languages {
+		if l.Tag != primary {
+			continue
+		}
+		if locale != "" && !containsFold(l.Locales, locale) {
+			return "", fmt.Errorf("%w %q for model %q", ErrUnsupportedLocale, locale, model)
+		}
+		switch {
+		case l.Variant != "":
+			return l.Variant, nil
+		case m.Variant != "":
+			return m.Variant, nil
+		}
+		return m.Name, nil
+	}
+	return "", fmt.Errorf("%w %q for model %q", ErrUnsupportedLanguage, language, model)
+}
+
+// PrimaryTag is the lower-cased primary language subtag of a BCP 47 tag
+func PrimaryTag(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return strings.ToLower(primary)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
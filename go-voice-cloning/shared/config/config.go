@@ -0,0 +1,343 @@
+// Package config loads a service's settings into a struct from the
+// environment and an optional file. Each field names its variable and
+// default in tags:
+//
+//	type Config struct {
+//		Port        string        `env:"PORT" default:"8080"`
+//		DatabaseURL string        `env:"DATABASE_URL" required:"true" secret:"true"`
+//		Interval    time.Duration `env:"JANITOR_INTERVAL_SECONDS" default:"3600" unit:"s" min:"0"`
+//	}
+//
+// Supported field types are string, bool, int, int64, float64, []string
+// (comma-separated) and time.Duration, whose value counts the tag's unit
+// (ms, s, m, h or d; seconds without one). The fields of a struct field
+// without an env tag are loaded as the struct's own, so settings shared by
+// the services can be declared once. A value that doesn't parse, a
+// number under its min or over its max, or an empty required field fails
+// the load, so a mistyped setting stops the service at startup instead of
+// being ignored.
+//
+// CONFIG_FILE may name a JSON object or a YAML file of top-level
+// "KEY: value" pairs, keyed by the variable names. The environment wins
+// over the file. Values from the file are also set in the environment
+// where it has none, so settings read elsewhere with os.Getenv see them.
+//
+// Any variable, from the environment or the file, may hold a reference to
+// a secret instead of the secret itself: vault://<path>#<field> reads a
+// field of a HashiCorp Vault secret, at VAULT_ADDR with VAULT_TOKEN, and
+// aws-sm://<secret id>[#<field>] an AWS Secrets Manager secret, or a field
+// of it when it is a JSON object. References are resolved before the
+// fields are loaded, and the secrets set in the environment in their
+// place, so database passwords, signing keys and storage credentials need
+// not be kept in plain text. A reference that can't be resolved fails the
+// load. With SECRETS_REFRESH_SECONDS they are resolved again periodically;
+// see Current and OnRotate.
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FileEnv names the optional configuration file
+	FileEnv = "CONFIG_FILE"
+	// fileKeysEnv lists the variables set from the file, so a process
+	// started with this environment, such as a reloaded gateway, reads the
+	// file again instead of taking them as set in the environment
+	fileKeysEnv = "CONFIG_FILE_KEYS"
+)
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+}
+
+// MustLoad loads cfg and exits when it is invalid
+func MustLoad(service string, cfg interface{}) {
+	if err := Load(cfg); err != nil {
+		log.Fatalf("Invalid %s configuration:\n%v", service, err)
+	}
+}
+
+// LogSummary logs the effective settings in one record, once logging is
+// set up
+func LogSummary(cfg interface{}) {
+	var args []any
+	for _, line := range Summary(cfg) {
+		key, value, _ := strings.Cut(line, "=")
+		args = append(args, key, value)
+	}
+	slog.Info("Configuration loaded", args...)
+}
+
+// Load fills cfg, a pointer to a struct, from CONFIG_FILE and the
+// environment. Every invalid field is reported in the error.
+func Load(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+
+	restoreReferences()
+	for _, key := range strings.Split(os.Getenv(fileKeysEnv), ",") {
+		if key != "" {
+			os.Unsetenv(key)
+		}
+	}
+	file, err := readFile(os.Getenv(FileEnv))
+	if err != nil {
+		return err
+	}
+	var fromFile []string
+	for key, value := range file {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+			fromFile = append(fromFile, key)
+		}
+	}
+	os.Setenv(fileKeysEnv, strings.Join(fromFile, ","))
+	if errs := resolveReferences(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return errors.Join(loadFields(v.Elem())...)
+}
+
+// loadFields fills the fields of a struct, and those of its untagged struct
+// fields, from the environment
+func loadFields(v reflect.Value) []error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if nested(field) {
+			errs = append(errs, loadFields(v.Field(i))...)
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" || !field.IsExported() {
+			continue
+		}
+		value := os.Getenv(key)
+		if value == "" {
+			value = field.Tag.Get("default")
+		}
+		if value == "" {
+			if field.Tag.Get("required") == "true" {
+				errs = append(errs, fmt.Errorf("%s is required", key))
+			}
+			continue
+		}
+		if err := set(v.Field(i), field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// nested reports whether a field holds settings of its own
+func nested(field reflect.StructField) bool {
+	return field.IsExported() && field.Type.Kind() == reflect.Struct && field.Tag.Get("env") == ""
+}
+
+// set parses value into a field and checks it against the field's min and
+// max
+func set(dst reflect.Value, field reflect.StructField, value string) error {
+	var number float64
+	switch dst.Interface().(type) {
+	case string:
+		dst.SetString(value)
+		return nil
+	case []string:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		dst.Set(reflect.ValueOf(items))
+		return nil
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		dst.SetBool(b)
+		return nil
+	case time.Duration:
+		unit := time.Second
+		if name := field.Tag.Get("unit"); name != "" {
+			var ok bool
+			if unit, ok = durationUnits[name]; !ok {
+				return fmt.Errorf("unknown unit %q", name)
+			}
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		dst.SetInt(int64(time.Duration(n) * unit))
+		number = float64(n)
+	case int, int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		dst.SetInt(n)
+		number = float64(n)
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		dst.SetFloat(f)
+		number = f
+	default:
+		return fmt.Errorf("unsupported type %s", dst.Type())
+	}
+
+	if min := field.Tag.Get("min"); min != "" {
+		m, err := strconv.ParseFloat(min, 64)
+		if err != nil {
+			return fmt.Errorf("invalid min %q", min)
+		}
+		if number < m {
+			return fmt.Errorf("must be at least %s, got %s", min, value)
+		}
+	}
+	if max := field.Tag.Get("max"); max != "" {
+		m, err := strconv.ParseFloat(max, 64)
+		if err != nil {
+			return fmt.Errorf("invalid max %q", max)
+		}
+		if number > m {
+			return fmt.Errorf("must be at most %s, got %s", max, value)
+		}
+	}
+	return nil
+}
+
+// Summary describes the effective value of each field, one per line in
+// field order. Secret fields, and those resolved from a secret reference,
+// only show whether they are set.
+func Summary(cfg interface{}) []string {
+	return summarize(reflect.Indirect(reflect.ValueOf(cfg)))
+}
+
+func summarize(v reflect.Value) []string {
+	var lines []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if nested(field) {
+			lines = append(lines, summarize(v.Field(i))...)
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" || !field.IsExported() {
+			continue
+		}
+		value := fmt.Sprint(v.Field(i).Interface())
+		if items, ok := v.Field(i).Interface().([]string); ok {
+			value = strings.Join(items, ",")
+		}
+		if field.Tag.Get("secret") == "true" || fromReference(key) {
+			value = "(not set)"
+			if !v.Field(i).IsZero() {
+				value = "(set)"
+			}
+		}
+		lines = append(lines, key+"="+value)
+	}
+	return lines
+}
+
+// readFile reads a configuration file into variable names and values. An
+// empty path reads nothing.
+func readFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSON(data)
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	}
+	return nil, fmt.Errorf("config: %s: unsupported file type, use .json, .yaml or .yml", path)
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value := value.(type) {
+		case string:
+			values[key] = value
+		case json.Number, bool:
+			values[key] = fmt.Sprint(value)
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("config: %s: nested values aren't supported", key)
+		}
+	}
+	return values, nil
+}
+
+// parseYAML reads top-level "KEY: value" pairs. Values may be quoted;
+// comments and blank lines are skipped.
+func parseYAML(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "-") {
+			return nil, fmt.Errorf("config: line %d: expected a top-level \"KEY: value\"", n)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,397 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RefreshEnv sets how often, in seconds, secret references are resolved
+	// again; 0, the default, resolves them once at startup
+	RefreshEnv = "SECRETS_REFRESH_SECONDS"
+	// secretRefsEnv keeps the references resolved at startup, so a process
+	// started with this environment, such as a reloaded gateway, resolves
+	// them again instead of taking their values as set
+	secretRefsEnv = "CONFIG_SECRET_REFS"
+)
+
+// resolveTimeout bounds each lookup of a secret
+const resolveTimeout = 10 * time.Second
+
+// A resolver looks up the secret at path in a secrets manager, or the field
+// of it when one is named
+type resolver func(ctx context.Context, path, field string) (string, error)
+
+var resolvers = map[string]resolver{
+	"vault":  resolveVault,
+	"aws-sm": resolveAWSSecret,
+}
+
+var secretClient = &http.Client{Timeout: resolveTimeout}
+
+// secrets tracks the variables resolved from references
+var secrets struct {
+	sync.Mutex
+	refs     map[string]string // variable → reference
+	values   map[string]string // variable → value resolved at startup
+	latest   map[string]string // value resolved at startup → current value
+	onRotate []func(key string)
+	rotating bool
+}
+
+// isReference reports whether value names a secret rather than holding it
+func isReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	_, known := resolvers[scheme]
+	return ok && known
+}
+
+// resolve looks up a reference, scheme://path#field
+func resolve(ctx context.Context, ref string) (string, error) {
+	scheme, rest, _ := strings.Cut(ref, "://")
+	path, field, _ := strings.Cut(rest, "#")
+	if path == "" {
+		return "", fmt.Errorf("%s reference names no secret", scheme)
+	}
+	return resolvers[scheme](ctx, path, field)
+}
+
+// restoreReferences puts back the references a parent process resolved, so
+// they are resolved again rather than read as values
+func restoreReferences() {
+	var refs map[string]string
+	if err := json.Unmarshal([]byte(os.Getenv(secretRefsEnv)), &refs); err != nil {
+		return
+	}
+	for key, ref := range refs {
+		os.Setenv(key, ref)
+	}
+}
+
+// resolveReferences replaces every variable holding a secret reference with
+// the secret, and starts refreshing them when RefreshEnv asks to
+func resolveReferences() []error {
+	refs := map[string]string{}
+	for _, entry := range os.Environ() {
+		if key, value, _ := strings.Cut(entry, "="); isReference(value) {
+			refs[key] = value
+		}
+	}
+	var refresh time.Duration
+	if v := os.Getenv(RefreshEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return []error{fmt.Errorf("%s: invalid number %q", RefreshEnv, v)}
+		}
+		refresh = time.Duration(n) * time.Second
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(refs))
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	values := make(map[string]string, len(refs))
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		value, err := resolve(ctx, refs[key])
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		values[key] = value
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	saved, _ := json.Marshal(refs)
+	os.Setenv(secretRefsEnv, string(saved))
+
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.refs, secrets.values, secrets.latest = refs, values, map[string]string{}
+	for key, value := range values {
+		os.Setenv(key, value)
+		secrets.latest[value] = value
+	}
+	if refresh > 0 && !secrets.rotating {
+		secrets.rotating = true
+		go rotate(refresh)
+	}
+	return nil
+}
+
+// rotate resolves the references again every interval, setting the secrets
+// that changed in the environment and telling the OnRotate callbacks. A
+// secret that can't be looked up keeps its value until the next round.
+func rotate(interval time.Duration) {
+	for range time.Tick(interval) {
+		secrets.Lock()
+		refs := make(map[string]string, len(secrets.refs))
+		for key, ref := range secrets.refs {
+			refs[key] = ref
+		}
+		secrets.Unlock()
+
+		var rotated []string
+		for key, ref := range refs {
+			ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			value, err := resolve(ctx, ref)
+			cancel()
+			if err != nil {
+				slog.Warn("Failed to refresh secret", "key", key, "error", err)
+				continue
+			}
+			secrets.Lock()
+			startup := secrets.values[key]
+			if secrets.latest[startup] != value {
+				secrets.latest[startup] = value
+				os.Setenv(key, value)
+				rotated = append(rotated, key)
+			}
+			secrets.Unlock()
+		}
+
+		secrets.Lock()
+		callbacks := append([]func(string){}, secrets.onRotate...)
+		secrets.Unlock()
+		for _, key := range rotated {
+			slog.Info("Secret rotated", "key", key)
+			for _, fn := range callbacks {
+				fn(key)
+			}
+		}
+	}
+}
+
+// Current returns the latest value of a secret that was resolved to value
+// at startup, or value itself when it wasn't resolved or hasn't changed.
+// Settings loaded once, such as a DSN, pass through it wherever they are
+// used so a rotated secret takes effect without a restart.
+func Current(value string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	if latest, ok := secrets.latest[value]; ok {
+		return latest
+	}
+	return value
+}
+
+// OnRotate calls fn with the variable's name whenever a secret it was
+// resolved from changes
+func OnRotate(fn func(key string)) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.onRotate = append(secrets.onRotate, fn)
+}
+
+// fromReference reports whether a variable's value was resolved from a
+// reference, so the summary masks it whatever its field's tags
+func fromReference(key string) bool {
+	secrets.Lock()
+	defer secrets.Unlock()
+	_, ok := secrets.refs[key]
+	return ok
+}
+
+// field picks a field out of a secret holding a JSON object
+func field(fields map[string]interface{}, name string) (string, error) {
+	value, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", name)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// resolveVault reads vault://<path>#<field> from HashiCorp Vault at
+// VAULT_ADDR with VAULT_TOKEN, in VAULT_NAMESPACE when set. The path is
+// the API's, such as secret/data/voice-cloning for version 2 of the KV
+// engine.
+func resolveVault(ctx context.Context, path, name string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is required for vault:// references")
+	}
+	if name == "" {
+		return "", errors.New("vault reference names no field; add #<field>")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := secretClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault: %s returned %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	fields := body.Data
+	// Version 2 of the KV engine nests the secret beside its metadata
+	if inner, ok := fields["data"].(map[string]interface{}); ok && fields["metadata"] != nil {
+		fields = inner
+	}
+	return field(fields, name)
+}
+
+// resolveAWSSecret reads aws-sm://<secret id>[#<field>] from AWS Secrets
+// Manager in AWS_REGION, or the region of an ARN, with the AWS_* credentials.
+// The field picks a key of a JSON secret; without one the whole secret is
+// used. AWS_ENDPOINT_URL_SECRETS_MANAGER overrides the endpoint.
+func resolveAWSSecret(ctx context.Context, id, name string) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	if endpoint == "" {
+		if region == "" {
+			return "", errors.New("AWS_REGION is required for aws-sm:// references")
+		}
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	payload, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if err := signAWS(req, payload, region, "secretsmanager", time.Now().UTC()); err != nil {
+		return "", err
+	}
+	resp, err := secretClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("aws secrets manager: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		json.Unmarshal(data, &failure)
+		return "", fmt.Errorf("aws secrets manager: %s: %s %s", id, resp.Status, strings.TrimSpace(failure.Type+" "+failure.Message))
+	}
+
+	var body struct {
+		SecretString string
+		SecretBinary string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("aws secrets manager: %w", err)
+	}
+	secret := body.SecretString
+	if secret == "" && body.SecretBinary != "" {
+		decoded, err := base64.StdEncoding.DecodeString(body.SecretBinary)
+		if err != nil {
+			return "", fmt.Errorf("aws secrets manager: %w", err)
+		}
+		secret = string(decoded)
+	}
+	if name == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("aws secrets manager: %s isn't a JSON object, so has no field %q", id, name)
+	}
+	return field(fields, name)
+}
+
+// signAWS signs a request with AWS Signature Version 4, using
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func signAWS(req *http.Request, payload []byte, region, service string, now time.Time) error {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for aws-sm:// references")
+	}
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	names := []string{"content-type", "host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,463 @@
+// Package cron runs a service's recurring maintenance jobs, such as purging
+// expired sessions or removing files past their retention, on cron
+// schedules from its configuration. Every replica of a service runs the
+// scheduler, and a lock per job, a Postgres advisory lock or a Redis key,
+// keeps them from running a job at the same time; a scheduled run is
+// recorded once per slot, so a replica whose clock lags doesn't run it
+// again. Each run is kept in the cron_runs table with how it ended, and
+// admins list the jobs and their runs, and trigger a job outside its
+// schedule, through the service's /admin/jobs endpoints.
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/dialect"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Locks a scheduler takes
+const (
+	LockPostgres = "postgres"
+	LockRedis    = "redis"
+)
+
+// Config chooses the lock and how long runs are kept. Services hold it in
+// a Cron field of their own Config.
+type Config struct {
+	// Lock is postgres, for advisory locks in the service's database, or
+	// redis, for keys at LockRedisURL
+	Lock         string `env:"CRON_LOCK" default:"postgres"`
+	LockRedisURL string `env:"CRON_LOCK_REDIS_URL" secret:"true"`
+	// HistoryRetention is how long finished runs are kept
+	HistoryRetention time.Duration `env:"CRON_HISTORY_RETENTION_DAYS" default:"30" unit:"d" min:"1"`
+}
+
+// Kinds of runs
+const (
+	KindScheduled = "scheduled"
+	KindManual    = "manual"
+)
+
+// Statuses of runs
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrUnknownJob is a job the scheduler doesn't have
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrRunning is a job already running, here or on another replica
+	ErrRunning = errors.New("job is already running")
+)
+
+// Func runs a job once. It should return once ctx is cancelled, as the
+// service stops. An error fails the run.
+type Func func(ctx context.Context) error
+
+// Run is one run of a job. ScheduledFor is the slot of a scheduled run;
+// TriggeredBy the admin who started a manual one.
+type Run struct {
+	ID           int64      `json:"id" db:"id"`
+	Service      string     `json:"service" db:"service"`
+	Job          string     `json:"job" db:"job"`
+	Kind         string     `json:"kind" db:"kind"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty" db:"scheduled_for"`
+	TriggeredBy  *int       `json:"triggered_by,omitempty" db:"triggered_by"`
+	Status       string     `json:"status" db:"status"`
+	Error        *string    `json:"error,omitempty" db:"error"`
+	StartedAt    time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+const runColumns = "id, service, job, kind, scheduled_for, triggered_by, status, error, started_at, finished_at"
+
+// Job describes a job of the service. A job without a schedule only runs
+// when triggered.
+type Job struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule,omitempty"`
+	NextRun  *time.Time `json:"next_run_at,omitempty"`
+	LastRun  *Run       `json:"last_run"`
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule // nil when only triggered
+	run      Func
+}
+
+// Scheduler runs the jobs of a service
+type Scheduler struct {
+	service string
+	db      *sqlx.DB
+	dialect dialect.Dialect
+	locker  Locker
+	redis   *redis.Client // of the Redis lock, if used
+	audit   *audit.Log    // nil to leave triggers unaudited
+	history time.Duration
+
+	jobs  []*job
+	ctx   context.Context
+	stop  context.CancelFunc
+	wg    sync.WaitGroup
+	start sync.Once
+}
+
+// New returns the scheduler of a service, with its configured lock. Manual
+// triggers are recorded to auditLog, which may be nil.
+func New(service string, cfg Config, db *sqlx.DB, auditLog *audit.Log) (*Scheduler, error) {
+	s := &Scheduler{service: service, db: db, dialect: dialect.Of(db), audit: auditLog, history: cfg.HistoryRetention}
+	switch cfg.Lock {
+	case LockPostgres:
+		s.locker = PostgresLocker{DB: db}
+	case LockRedis:
+		if cfg.LockRedisURL == "" {
+			return nil, fmt.Errorf("CRON_LOCK=%s requires CRON_LOCK_REDIS_URL", LockRedis)
+		}
+		opts, err := redis.ParseURL(cfg.LockRedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRON_LOCK_REDIS_URL: %w", err)
+		}
+		s.redis = redis.NewClient(opts)
+		s.locker = RedisLocker{Client: s.redis}
+	default:
+		return nil, fmt.Errorf("unknown CRON_LOCK %q", cfg.Lock)
+	}
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// Spec is a job's schedule from its configuration: the cron expression
+// if one is set, or else every interval, as jobs were configured before
+// they had schedules. Both empty leave the job to be triggered.
+func Spec(schedule string, interval time.Duration) string {
+	if schedule != "" || interval <= 0 {
+		return schedule
+	}
+	return "@every " + interval.String()
+}
+
+// Add registers a job run on spec (see Parse), or only when triggered if
+// spec is empty. Jobs are added before Start.
+func (s *Scheduler) Add(name, spec string, run Func) error {
+	j := &job{name: name, spec: spec, run: run}
+	if spec != "" {
+		schedule, err := Parse(spec)
+		if err != nil {
+			return err
+		}
+		j.schedule = schedule
+	}
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+// Start runs the scheduled jobs in the background until Close
+func (s *Scheduler) Start() {
+	s.start.Do(func() {
+		for _, j := range s.jobs {
+			if j.schedule == nil {
+				continue
+			}
+			slog.Info("Job scheduled", "job", j.name, "schedule", j.spec)
+			s.wg.Add(1)
+			go func(j *job) {
+				defer s.wg.Done()
+				s.loop(j)
+			}(j)
+		}
+	})
+}
+
+// Close stops scheduling, cancels the running jobs and waits for them to
+// return
+func (s *Scheduler) Close() {
+	s.stop()
+	s.wg.Wait()
+	if s.redis != nil {
+		s.redis.Close()
+	}
+}
+
+// loop runs a job at each of its slots
+func (s *Scheduler) loop(j *job) {
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			slog.Warn("Job's schedule never comes round", "job", j.name, "schedule", j.spec)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		run, unlock, err := s.begin(s.ctx, j, KindScheduled, &next, 0)
+		switch {
+		case errors.Is(err, ErrRunning):
+			slog.Debug("Job is running elsewhere, skipping its slot", "job", j.name, "slot", next)
+		case err != nil:
+			slog.Error("Failed to start job", "job", j.name, "error", err)
+		case run != nil:
+			s.execute(j, run, unlock)
+		}
+	}
+}
+
+// begin takes a job's lock and records its run. A scheduled run whose slot
+// was already run returns no run.
+func (s *Scheduler) begin(ctx context.Context, j *job, kind string, slot *time.Time, by int) (*Run, func(), error) {
+	unlock, locked, err := s.locker.Lock(ctx, j.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !locked {
+		return nil, nil, ErrRunning
+	}
+
+	var triggeredBy *int
+	if by != 0 {
+		triggeredBy = &by
+	}
+	run := &Run{}
+	err = s.db.GetContext(ctx, run,
+		`INSERT INTO cron_runs (service, job, kind, scheduled_for, triggered_by, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING RETURNING `+runColumns,
+		s.service, j.name, kind, slot, triggeredBy, StatusRunning)
+	if err != nil {
+		unlock()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return run, unlock, nil
+}
+
+// execute runs a job and records how it ended, releasing its lock
+func (s *Scheduler) execute(j *job, run *Run, unlock func()) {
+	defer unlock()
+
+	started := time.Now()
+	err := call(s.ctx, j.run)
+	status := StatusSucceeded
+	var message *string
+	if err != nil {
+		status = StatusFailed
+		text := err.Error()
+		message = &text
+		slog.Error("Job failed", "job", j.name, "run_id", run.ID, "duration", time.Since(started), "error", err)
+	} else {
+		slog.Info("Job finished", "job", j.name, "run_id", run.ID, "duration", time.Since(started))
+	}
+
+	// Recorded even as the service stops
+	ctx := context.Background()
+	_, err = s.db.ExecContext(ctx,
+		"UPDATE cron_runs SET status = $1, error = $2, finished_at = "+s.dialect.Now()+" WHERE id = $3",
+		status, message, run.ID)
+	if err != nil {
+		slog.Error("Failed to record job run", "job", j.name, "run_id", run.ID, "error", err)
+	}
+	_, err = s.db.ExecContext(ctx,
+		"DELETE FROM cron_runs WHERE service = $1 AND job = $2 AND status <> $3 AND started_at < "+s.dialect.Ago(s.history),
+		s.service, j.name, StatusRunning)
+	if err != nil {
+		slog.Error("Failed to prune job runs", "job", j.name, "error", err)
+	}
+}
+
+// call runs a job, failing the run if it panics
+func call(ctx context.Context, run Func) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return run(ctx)
+}
+
+func (s *Scheduler) job(name string) *job {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// Trigger starts a run of a job now, on behalf of an admin, and returns it
+// as recorded while it runs in the background
+func (s *Scheduler) Trigger(ctx context.Context, name string, by int) (*Run, error) {
+	j := s.job(name)
+	if j == nil {
+		return nil, ErrUnknownJob
+	}
+	run, unlock, err := s.begin(ctx, j, KindManual, nil, by)
+	if err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(j, run, unlock)
+	}()
+	return run, nil
+}
+
+// Jobs describes the service's jobs, with their next slots and last runs
+func (s *Scheduler) Jobs(ctx context.Context) ([]Job, error) {
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		job := Job{Name: j.name, Schedule: j.spec}
+		if j.schedule != nil {
+			if next := j.schedule.Next(time.Now()); !next.IsZero() {
+				job.NextRun = &next
+			}
+		}
+		var last Run
+		err := s.db.GetContext(ctx, &last,
+			"SELECT "+runColumns+" FROM cron_runs WHERE service = $1 AND job = $2 ORDER BY id DESC LIMIT 1",
+			s.service, j.name)
+		switch {
+		case err == nil:
+			job.LastRun = &last
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// ServeHTTP serves the admin endpoints under /admin/jobs: the jobs at
+// GET /admin/jobs, a job's runs, newest first, at GET
+// /admin/jobs/{job}/runs, and a run started now with POST
+// /admin/jobs/{job}/run
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/jobs"), "/")
+	name, action, _ := strings.Cut(path, "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		s.listJobs(w, r)
+	case action == "runs" && r.Method == http.MethodGet:
+		s.listRuns(w, r, name)
+	case action == "run" && r.Method == http.MethodPost:
+		s.triggerJob(w, r, name)
+	case path == "" || action == "runs" || action == "run":
+		utils.ErrorResponse(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+	default:
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Not found")
+	}
+}
+
+func (s *Scheduler) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.Jobs(r.Context())
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch jobs")
+		return
+	}
+	utils.SuccessResponse(w, jobs)
+}
+
+// runCursor is the position of a page of runs
+type runCursor struct {
+	ID int64 `json:"id"`
+}
+
+func (s *Scheduler) listRuns(w http.ResponseWriter, r *http.Request, name string) {
+	if s.job(name) == nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.JobNotFound, "Job not found")
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), 50, 200)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"service = $1", "job = $2"}
+	args := []interface{}{s.service, name}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where = append(where, "status = "+arg(status))
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM cron_runs WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch job runs")
+		return
+	}
+
+	var cursor runCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, "id < "+arg(cursor.ID))
+	}
+
+	runs := []Run{}
+	err = s.db.SelectContext(r.Context(), &runs,
+		"SELECT "+runColumns+" FROM cron_runs WHERE "+strings.Join(where, " AND ")+" ORDER BY id DESC "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch job runs")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, runs, total, func(last Run) interface{} {
+		return runCursor{ID: last.ID}
+	}))
+}
+
+func (s *Scheduler) triggerJob(w http.ResponseWriter, r *http.Request, name string) {
+	run, err := s.Trigger(r.Context(), name, middleware.UserID(r))
+	switch {
+	case errors.Is(err, ErrUnknownJob):
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.JobNotFound, "Job not found")
+		return
+	case errors.Is(err, ErrRunning):
+		utils.ErrorResponse(w, http.StatusConflict, apierror.JobRunning, "Job is already running")
+		return
+	case err != nil:
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to start job")
+		return
+	}
+	if s.audit != nil {
+		s.audit.Request(r, "job.trigger", "job", name, audit.Success, map[string]interface{}{"run_id": run.ID})
+	}
+	utils.JSONResponse(w, http.StatusAccepted, run)
+}
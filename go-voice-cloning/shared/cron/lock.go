@@ -0,0 +1,113 @@
+package cron
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/dialect"
+)
+
+// Locker keeps the replicas of a service from running a job at the same
+// time. Lock reports false when another holds the job's lock; otherwise
+// the lock is held until unlock is called.
+type Locker interface {
+	Lock(ctx context.Context, job string) (unlock func(), locked bool, err error)
+}
+
+// PostgresLocker takes an advisory lock per job, held by a connection of
+// the pool for the length of the run. On SQLite, which a single box uses,
+// every lock is taken at once.
+type PostgresLocker struct {
+	DB *sqlx.DB
+}
+
+func (l PostgresLocker) Lock(ctx context.Context, job string) (func(), bool, error) {
+	conn, err := l.DB.Connx(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	d := dialect.Of(l.DB)
+	id := lockID(job)
+	locked, err := d.TryLock(ctx, conn, id)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		d.Unlock(conn, id)
+		conn.Close()
+	}, true, nil
+}
+
+// lockID is the advisory lock of a job, apart from the locks the services
+// take by fixed ids
+func lockID(job string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("cron:" + job))
+	return int64(h.Sum64() >> 1)
+}
+
+// redisLockTTL is how long a Redis lock outlives a replica that died
+// holding it; a running job refreshes it well before
+const redisLockTTL = time.Minute
+
+// unlockScript deletes a lock only if it still holds this holder's token
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// refreshScript extends a lock only if it still holds this holder's token
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RedisLocker takes a key per job holding a random token, which expires
+// unless the run refreshes it, so a replica dying mid-run doesn't keep the
+// job from running
+type RedisLocker struct {
+	Client *redis.Client
+}
+
+func (l RedisLocker) Lock(ctx context.Context, job string) (func(), bool, error) {
+	key := "cron:lock:" + job
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	locked, err := l.Client.SetNX(ctx, key, token, redisLockTTL).Result()
+	if err != nil || !locked {
+		return nil, false, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(redisLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := refreshScript.Run(context.Background(), l.Client, []string{key}, token, redisLockTTL.Milliseconds()).Err()
+				if err != nil {
+					slog.Warn("Failed to refresh job lock", "job", job, "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		unlockScript.Run(context.Background(), l.Client, []string{key}, token)
+	}, true, nil
+}
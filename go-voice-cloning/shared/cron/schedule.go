@@ -0,0 +1,177 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job runs next
+type Schedule interface {
+	// Next returns the first time strictly after t the job runs at, or the
+	// zero time if it never does
+	Next(t time.Time) time.Time
+}
+
+// descriptors are the shorthands of common schedules
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a schedule: a cron expression of five fields (minute, hour,
+// day of month, month and day of week, Sunday being 0 or 7) in UTC, one of
+// the descriptors @yearly, @monthly, @weekly, @daily and @hourly, or
+// "@every <duration>", such as @every 15m. A field is *, a value, a range
+// a-b, or a list of them separated by commas, each optionally stepped with
+// /n. As in cron, a job restricted by both day fields runs on the days
+// either matches.
+//
+// @every schedules run on the multiples of their duration since the Unix
+// epoch, so the replicas of a service agree on when a run is due.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		return Every(d), nil
+	}
+	if expr, ok := descriptors[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields, got %d", spec, len(fields))
+	}
+	var s expression
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minutes, 0, 59},
+		{&s.hours, 0, 23},
+		{&s.days, 1, 31},
+		{&s.months, 1, 12},
+		{&s.weekdays, 0, 7},
+	} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*f.bits = bits
+	}
+	// Sunday is 0 or 7
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+	return s, nil
+}
+
+// parseField reads one field into a bit set of the values it matches
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if stepped {
+				// a/n steps from a to the end of the range
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// expression is a parsed cron expression, one bit per matching value
+type expression struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+// Next finds the next matching minute by skipping whole months, days and
+// hours that don't match. Schedules matching no date, such as February 30,
+// give up after five years.
+func (s expression) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s expression) matchDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Every is a schedule running every d, on the multiples of d since the
+// Unix epoch
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	d := time.Duration(e)
+	return time.Unix(0, 0).UTC().Add(t.Sub(time.Unix(0, 0)).Truncate(d) + d)
+}
@@ -0,0 +1,182 @@
+// Package dbpool opens the services' Postgres pools. Each pool is sized and
+// recycled as configured, startup waits a bounded time for the database to
+// come up, and the pools' usage is reported in Prometheus text format.
+// Services whose queries are written through package dialect can open a
+// SQLite database instead.
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dialect"
+)
+
+// Config sizes a service's pools. Services hold it in a DB field of their
+// own Config.
+type Config struct {
+	// MaxOpenConns bounds the connections a pool opens; queries beyond it
+	// wait for one to be released
+	MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" default:"25" min:"1"`
+	MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" default:"10" min:"0"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME_SECONDS" default:"1800" min:"0"`
+	// ConnectTimeout bounds how long startup waits for the database
+	ConnectTimeout time.Duration `env:"DB_CONNECT_TIMEOUT_SECONDS" default:"30" min:"1"`
+	// SQLite is set by the services that run on SQLite; the others refuse
+	// a sqlite: DATABASE_URL
+	SQLite bool
+}
+
+// Apply sizes a pool
+func (c Config) Apply(db *sqlx.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+}
+
+// Open returns a pool for dsn without connecting. Its connections are
+// opened with the current value of dsn, so a database password resolved
+// from a secret reference and rotated since startup is used by the
+// connections opened after the rotation; see config.Current. A sqlite:
+// DSN opens a SQLite database.
+func Open(dsn string) (*sqlx.DB, error) {
+	if dialect.IsSQLite(dsn) {
+		return sqlx.Open(dialect.DriverName, dialect.SQLiteDSN(dsn))
+	}
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(sql.OpenDB(connector{dsn: dsn}), "postgres"), nil
+}
+
+type connector struct {
+	dsn string
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	current, err := pq.NewConnector(config.Current(c.dsn))
+	if err != nil {
+		return nil, err
+	}
+	return current.Connect(ctx)
+}
+
+func (c connector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// Connect opens a pool, retrying with backoff while the database can't be
+// reached, such as while it is still starting. It gives up after the
+// config's ConnectTimeout, naming the host it couldn't reach.
+func Connect(dsn string, cfg Config) (*sqlx.DB, error) {
+	if dialect.IsSQLite(dsn) && !cfg.SQLite {
+		return nil, errors.New("DATABASE_URL names a SQLite database, but this service only runs on Postgres")
+	}
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Apply(db)
+
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	backoff := 500 * time.Millisecond
+	for {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			db.Close()
+			return nil, fmt.Errorf("database at %s unreachable after %s: %w", host(dsn), cfg.ConnectTimeout, err)
+		}
+		slog.Warn("Database unreachable, retrying", "host", host(dsn), "retry_in", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// host names the database of a DSN without its credentials
+func host(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "DATABASE_URL"
+}
+
+// Pool is a pool reported under a name, such as primary or replica
+type Pool struct {
+	Name string
+	DB   *sqlx.DB
+}
+
+// WriteMetrics writes the pools' connection counts and waits in Prometheus
+// text format
+func WriteMetrics(w io.Writer, pools ...Pool) {
+	metrics := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) float64
+	}{
+		{"db_pool_max_open_connections", "gauge", "Connections the pool may open.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_pool_open_connections", "gauge", "Connections open, in use or idle.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_pool_in_use_connections", "gauge", "Connections running a query or transaction.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_pool_idle_connections", "gauge", "Connections open and idle.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"db_pool_waits_total", "counter", "Queries that waited for a connection.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_pool_wait_seconds_total", "counter", "Time queries spent waiting for a connection.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"db_pool_max_idle_closed_total", "counter", "Connections closed as more were idle than allowed.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"db_pool_max_lifetime_closed_total", "counter", "Connections closed for reaching their maximum lifetime.",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	stats := make([]sql.DBStats, len(pools))
+	for i, pool := range pools {
+		stats[i] = pool.DB.Stats()
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for i, pool := range pools {
+			fmt.Fprintf(w, "%s{pool=%q} %g\n", m.name, pool.Name, m.value(stats[i]))
+		}
+	}
+}
+
+// Metrics serves the pools' metrics
+func Metrics(pools ...Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, pools...)
+	}
+}
+
+// Pools names a service's primary pool and its replica, when it has one
+// apart from the primary
+func Pools(primary, replica *sqlx.DB) []Pool {
+	pools := []Pool{{Name: "primary", DB: primary}}
+	if replica != nil && replica != primary {
+		pools = append(pools, Pool{Name: "replica", DB: replica})
+	}
+	return pools
+}
@@ -0,0 +1,58 @@
+// Package dbroute sends read-only requests to a Postgres read replica. The
+// gateway tags each request with an intent; services pick the pool for
+// queries that tolerate replication lag.
+package dbroute
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/dbpool"
+)
+
+// IntentHeader carries the gateway's read/write hint for a request
+const IntentHeader = "X-DB-Intent"
+
+const (
+	IntentRead  = "read"
+	IntentWrite = "write"
+)
+
+// ConnectReplica opens the pool at DATABASE_REPLICA_URL, sized like the
+// primary. Without one, or if the replica is unreachable, reads stay on the
+// primary.
+func ConnectReplica(primary *sqlx.DB, pool dbpool.Config) *sqlx.DB {
+	url := os.Getenv("DATABASE_REPLICA_URL")
+	if url == "" {
+		return primary
+	}
+	replica, err := dbpool.Open(url)
+	if err == nil {
+		if err = replica.Ping(); err != nil {
+			replica.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Read replica unavailable, reading from primary: %v", err)
+		return primary
+	}
+	pool.Apply(replica)
+	return replica
+}
+
+// ReadOnly reports whether the gateway marked the request as read-only
+func ReadOnly(r *http.Request) bool {
+	return r.Header.Get(IntentHeader) == IntentRead
+}
+
+// Reader returns the replica for read-only requests and the primary
+// otherwise
+func Reader(r *http.Request, primary, replica *sqlx.DB) *sqlx.DB {
+	if replica != nil && ReadOnly(r) {
+		return replica
+	}
+	return primary
+}
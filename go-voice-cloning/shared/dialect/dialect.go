@@ -0,0 +1,126 @@
+// Package dialect is the small query layer that lets a service run on SQLite
+// as well as on Postgres, for handler tests against a real database and
+// single-box deployments. Queries stay written for Postgres, with $N
+// placeholders; the SQLite driver registered here renumbers them. The few
+// constructs the two databases spell differently, such as the current time,
+// interval arithmetic, ILIKE, row locks and advisory locks, are written
+// through a Dialect instead of inline.
+//
+// FILTER clauses, ON CONFLICT upserts with EXCLUDED and RETURNING are
+// understood by both, so queries using them need nothing from this package.
+// SERIAL columns, JSONB and arrays are a matter of the schema, which package
+// schema keeps apart for each database.
+package dialect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Dialect is the SQL spoken by a database
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// IsSQLite reports whether a DATABASE_URL names a SQLite database, as
+// sqlite:///path/to/file.db, sqlite://relative/file.db or sqlite::memory:
+func IsSQLite(dsn string) bool {
+	return strings.HasPrefix(dsn, "sqlite:")
+}
+
+// Of returns the dialect of a pool or transaction, or of anything else
+// naming its driver. It is Postgres for whatever doesn't.
+func Of(db interface{}) Dialect {
+	if named, ok := db.(interface{ DriverName() string }); ok && named.DriverName() == DriverName {
+		return SQLite
+	}
+	return Postgres
+}
+
+// sqliteTime formats a time as the SQLite driver stores times, so stored
+// times and computed ones compare as text
+const sqliteTime = "'%Y-%m-%d %H:%M:%f'"
+
+// Now is the current time
+func (d Dialect) Now() string {
+	if d == SQLite {
+		return "strftime(" + sqliteTime + ", 'now')"
+	}
+	return "NOW()"
+}
+
+// Ago is the time age before now, to compare timestamps against
+func (d Dialect) Ago(age time.Duration) string {
+	seconds := int64(age / time.Second)
+	if d == SQLite {
+		return fmt.Sprintf("strftime(%s, 'now', '-%d seconds')", sqliteTime, seconds)
+	}
+	return fmt.Sprintf("NOW() - INTERVAL '%d seconds'", seconds)
+}
+
+// ILike matches column against a pattern case-insensitively. SQLite's LIKE
+// already ignores the case of ASCII letters.
+func (d Dialect) ILike(column, pattern string) string {
+	if d == SQLite {
+		return column + " LIKE " + pattern
+	}
+	return column + " ILIKE " + pattern
+}
+
+// SkipLocked ends a SELECT claiming rows other workers aren't processing.
+// SQLite has a single writer, which claims every row it updates, so it
+// needs no row locks.
+func (d Dialect) SkipLocked() string {
+	if d == SQLite {
+		return ""
+	}
+	return " FOR UPDATE SKIP LOCKED"
+}
+
+// TableExists reports whether a table has been created
+func (d Dialect) TableExists(ctx context.Context, db sqlx.QueryerContext, table string) (bool, error) {
+	query := "SELECT to_regclass($1) IS NOT NULL"
+	if d == SQLite {
+		query = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1)"
+	}
+	var exists bool
+	err := sqlx.GetContext(ctx, db, &exists, query, table)
+	return exists, err
+}
+
+// TryLock takes the advisory lock id on the connection without waiting,
+// reporting whether it did. The lock is held until Unlock or the connection
+// closes. A SQLite database is used by a single box whose writers queue for
+// it, so every lock is taken at once.
+func (d Dialect) TryLock(ctx context.Context, conn *sqlx.Conn, id int64) (bool, error) {
+	if d == SQLite {
+		return true, nil
+	}
+	var locked bool
+	err := conn.GetContext(ctx, &locked, "SELECT pg_try_advisory_lock($1)", id)
+	return locked, err
+}
+
+// Lock takes the advisory lock id on the connection, waiting for it
+func (d Dialect) Lock(ctx context.Context, conn *sqlx.Conn, id int64) error {
+	if d == SQLite {
+		return nil
+	}
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", id)
+	return err
+}
+
+// Unlock releases an advisory lock taken by Lock or TryLock
+func (d Dialect) Unlock(conn *sqlx.Conn, id int64) {
+	if d == SQLite {
+		return
+	}
+	conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", id)
+}
@@ -0,0 +1,145 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the database/sql driver of SQLite databases. It needs a
+// build with cgo; without it, opening a database fails.
+const DriverName = "sqlite"
+
+func init() {
+	sql.Register(DriverName, sqliteDriver{})
+	// Queries are written with Postgres placeholders, which the driver
+	// renumbers, so sqlx rebinds to those too
+	sqlx.BindDriver(DriverName, sqlx.DOLLAR)
+}
+
+// sqliteOptions are the defaults of every connection: foreign keys
+// enforced as in Postgres, readers not blocking the writer, writers
+// waiting for each other instead of failing, and transactions taking the
+// write lock up front so two of them can't deadlock upgrading to it
+var sqliteOptions = map[string]string{
+	"_foreign_keys": "1",
+	"_journal_mode": "WAL",
+	"_busy_timeout": "10000",
+	"_txlock":       "immediate",
+}
+
+// SQLiteDSN turns a sqlite: DATABASE_URL into the driver's data source
+// name. Options in its query override the defaults. An in-memory database
+// is shared by the connections of the process, and gone when it exits.
+func SQLiteDSN(dsn string) string {
+	path := strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite:"), "//")
+	path, query, _ := strings.Cut(path, "?")
+	options, _ := url.ParseQuery(query)
+	for name, value := range sqliteOptions {
+		if !options.Has(name) {
+			options.Set(name, value)
+		}
+	}
+	if path == ":memory:" {
+		path = "/voice-cloning"
+		options.Set("vfs", "memdb")
+		options.Del("_journal_mode")
+	}
+	return "file:" + path + "?" + options.Encode()
+}
+
+type sqliteDriver struct{}
+
+func (sqliteDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{conn}, nil
+}
+
+// sqliteConn renumbers the placeholders of each query and stores times in
+// UTC, in the format SQLite's date functions produce
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, rebind(query))
+	}
+	return c.Prepare(query)
+}
+
+func (c sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, rebind(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c sqliteConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c sqliteConn) CheckNamedValue(v *driver.NamedValue) error {
+	if t, ok := v.Value.(time.Time); ok {
+		v.Value = t.UTC().Format("2006-01-02 15:04:05.999999999")
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// rebind turns the $N placeholders of a query outside its quoted strings
+// and identifiers into SQLite's ?N, which, unlike $N, SQLite numbers by the
+// digits rather than by the order they appear in
+func rebind(query string) string {
+	if !strings.Contains(query, "$") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			ch = '?'
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+// Package entitlements fetches what users' plans include from the user
+// service. The gateway, voice service and storage service enforce them on
+// most requests, so each keeps them in its cache for a while instead of
+// asking every time; a plan change takes effect once the cached
+// entitlements expire.
+package entitlements
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/voice-cloning/shared/cache"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	// DefaultTTL is how long entitlements are cached without
+	// ENTITLEMENTS_CACHE_SECONDS
+	DefaultTTL = time.Minute
+	// staleTTL is how long cached entitlements are kept past their TTL, to
+	// fall back on while the user service can't answer
+	staleTTL = 24 * time.Hour
+)
+
+// Client fetches and caches entitlements
+type Client struct {
+	baseURL string
+	ttl     time.Duration
+	http    *http.Client
+	cache   cache.Cache
+}
+
+type cached struct {
+	Entitlements types.Entitlements `json:"entitlements"`
+	FetchedAt    time.Time          `json:"fetched_at"`
+}
+
+// NewClient returns a client of the user service at baseURL caching
+// entitlements in c for ttl
+func NewClient(baseURL string, ttl time.Duration, c cache.Cache) *Client {
+	return &Client{
+		baseURL: baseURL,
+		ttl:     ttl,
+		http:    &http.Client{Timeout: 5 * time.Second},
+		cache:   cache.Namespace(c, "entitlements"),
+	}
+}
+
+// FromEnv returns a client of the user service at USER_SERVICE_URL caching
+// entitlements in c for ENTITLEMENTS_CACHE_SECONDS
+func FromEnv(c cache.Cache) *Client {
+	baseURL := os.Getenv("USER_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8084"
+	}
+	ttl := DefaultTTL
+	if seconds, err := strconv.Atoi(os.Getenv("ENTITLEMENTS_CACHE_SECONDS")); err == nil && seconds >= 0 {
+		ttl = time.Duration(seconds) * time.Second
+	}
+	return NewClient(baseURL, ttl, c)
+}
+
+// Get returns a user's entitlements, from the cache while they are fresh.
+// When the user service can't answer, entitlements cached earlier are
+// returned however old they are, and an error only without any.
+func (c *Client) Get(ctx context.Context, userID int) (types.Entitlements, error) {
+	key := strconv.Itoa(userID)
+	var entry cached
+	ok, err := c.cache.Get(ctx, key, &entry)
+	if err != nil {
+		slog.Warn("Failed to read cached entitlements", "user_id", userID, "error", err)
+	}
+	if ok && time.Since(entry.FetchedAt) < c.ttl {
+		return entry.Entitlements, nil
+	}
+
+	entitlements, err := c.fetch(ctx, userID)
+	if err != nil {
+		if ok {
+			return entry.Entitlements, nil
+		}
+		return entitlements, err
+	}
+
+	entry = cached{Entitlements: entitlements, FetchedAt: time.Now()}
+	if err := c.cache.Set(ctx, key, entry, c.ttl+staleTTL); err != nil {
+		slog.Warn("Failed to cache entitlements", "user_id", userID, "error", err)
+	}
+	return entitlements, nil
+}
+
+func (c *Client) fetch(ctx context.Context, userID int) (types.Entitlements, error) {
+	var entitlements types.Entitlements
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/users/%d/entitlements", c.baseURL, userID), nil)
+	if err != nil {
+		return entitlements, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return entitlements, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return entitlements, fmt.Errorf("entitlements of user %d: status %d", userID, resp.StatusCode)
+	}
+	err = utils.DecodeData(resp, &entitlements)
+	return entitlements, err
+}
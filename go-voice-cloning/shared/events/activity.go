@@ -0,0 +1,39 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// RecordActivity adds an entry to a user's activity feed, in the caller's
+// transaction if db is one
+func RecordActivity(ctx context.Context, db sqlx.ExtContext, userID int, kind, subject string, details interface{}) error {
+	doc, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO user_activity (user_id, kind, subject, details) VALUES ($1, $2, NULLIF($3, ''), $4)",
+		userID, kind, subject, doc)
+	return err
+}
+
+// cloneActivity lists the lifecycle events shown in the owner's feed
+var cloneActivity = map[string]bool{
+	types.ActivityCloneCreated:   true,
+	types.ActivityCloneCompleted: true,
+	types.ActivityCloneFailed:    true,
+}
+
+// recordCloneActivity adds a lifecycle event of a clone to its owner's feed
+func recordCloneActivity(ctx context.Context, db sqlx.ExtContext, cloneID int, event string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO user_activity (user_id, kind, subject, details)
+		SELECT user_id, $1, id::text, json_build_object('name', name) FROM voice_clones WHERE id = $2`,
+		event, cloneID)
+	return err
+}
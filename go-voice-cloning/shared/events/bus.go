@@ -0,0 +1,118 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Event bus drivers selected with EVENT_BUS
+const (
+	BusNATS  = "nats"
+	BusKafka = "kafka"
+)
+
+// Event is a message on the event bus. Type is also the NATS subject or
+// Kafka topic it is published to; Data is its payload, such as a
+// CloneCreated.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Payload is the typed content of an event
+type Payload interface {
+	EventType() string
+}
+
+// NewEvent wraps a payload in an event, with a new ID
+func NewEvent(payload Payload) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: utils.NewRequestID(), Type: payload.EventType(), OccurredAt: time.Now().UTC(), Data: data}, nil
+}
+
+// Decode reads the event's payload into v
+func (e Event) Decode(v Payload) error {
+	if e.Type != v.EventType() {
+		return fmt.Errorf("event %s is a %s, not a %s", e.ID, e.Type, v.EventType())
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// Handler processes an event delivered to a subscriber
+type Handler func(ctx context.Context, event Event) error
+
+// Publisher sends events to the bus
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Subscriber receives the events of a type. Subscribers in the same group
+// share the events between them, each event going to one of them, so every
+// instance of a service subscribes under the service's name. Subscribe
+// blocks until ctx is cancelled. Events a handler fails are logged and
+// skipped: delivery is at most once, and consumers that can't miss an event
+// also read the outbox it was recorded in.
+type Subscriber interface {
+	Subscribe(ctx context.Context, eventType, group string, handler Handler) error
+}
+
+// Bus is a connection to the event bus
+type Bus interface {
+	Publisher
+	Subscriber
+	Close() error
+}
+
+// Publish sends a payload to the bus. Without a bus it does nothing, so
+// services publish the same way whether or not one is configured.
+func Publish(ctx context.Context, p Publisher, payload Payload) error {
+	if p == nil {
+		return nil
+	}
+	event, err := NewEvent(payload)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, event)
+}
+
+// BusFromEnv connects to the event bus selected with EVENT_BUS, at
+// EVENT_BUS_URL: a NATS server URL, or comma-separated Kafka brokers. It
+// returns nil without EVENT_BUS.
+func BusFromEnv() (Bus, error) {
+	driver := os.Getenv("EVENT_BUS")
+	url := os.Getenv("EVENT_BUS_URL")
+	if driver == "" {
+		return nil, nil
+	}
+	if url == "" {
+		return nil, fmt.Errorf("EVENT_BUS_URL is required with EVENT_BUS=%s", driver)
+	}
+	switch driver {
+	case BusNATS:
+		bus, err := NewNATSBus(url)
+		if err != nil {
+			return nil, err
+		}
+		return bus, nil
+	case BusKafka:
+		bus, err := NewKafkaBus(strings.Split(url, ","))
+		if err != nil {
+			return nil, err
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BUS %q", driver)
+	}
+}
@@ -0,0 +1,54 @@
+// Package events publishes clone status transitions: webhook deliveries are
+// recorded, lifecycle events are added to an outbox for the message bus,
+// and live streams are notified over Postgres NOTIFY. All of it happens in
+// the caller's transaction, so nothing is published for a rolled back
+// transition.
+//
+// It also connects the services to the event bus, NATS or Kafka, on which
+// they announce typed events such as clone.created and file.uploaded to
+// each other instead of calling each other's APIs.
+package events
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/webhooks"
+)
+
+// PublishCloneStatus announces a clone's current status. Call it after the
+// status column has been updated.
+func PublishCloneStatus(ctx context.Context, db sqlx.ExtContext, cloneID int, status string) error {
+	if err := webhooks.EnqueueCloneStatus(ctx, db, cloneID, status); err != nil {
+		return err
+	}
+	if event, ok := lifecycleEvents[status]; ok {
+		if err := recordLifecycle(ctx, db, cloneID, event); err != nil {
+			return err
+		}
+	}
+	return notify(ctx, db, cloneID, types.EventKindStatus)
+}
+
+// PublishCloneProgress notifies live streams of a clone's progress and
+// stage. Progress is too frequent for webhooks, so none are sent.
+func PublishCloneProgress(ctx context.Context, db sqlx.ExtContext, cloneID int) error {
+	return notify(ctx, db, cloneID, types.EventKindProgress)
+}
+
+// notify sends a CloneEvent of the clone's current state. Timestamps carry an
+// explicit offset so they decode as RFC 3339. Progress doesn't touch
+// updated_at, so progress events are stamped with the current time.
+func notify(ctx context.Context, db sqlx.ExtContext, cloneID int, kind string) error {
+	// Postgres delivers the notification on commit
+	_, err := db.ExecContext(ctx,
+		`SELECT pg_notify($1, json_build_object(
+			'kind', $2::text, 'clone_id', id, 'user_id', user_id, 'status', status,
+			'progress', progress, 'stage', COALESCE(stage, ''),
+			'occurred_at', CASE WHEN $2::text = 'progress' THEN NOW() ELSE updated_at AT TIME ZONE 'UTC' END)::text)
+		FROM voice_clones WHERE id = $3`,
+		types.CloneEventsChannel, kind, cloneID)
+	return err
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaBus publishes events to Kafka topics named after their type. The
+// subscribers of a group form a consumer group, which shares the topic's
+// partitions between them.
+type KafkaBus struct {
+	brokers []string
+	writer  *kafka.Writer
+}
+
+// NewKafkaBus returns a bus on the Kafka cluster of brokers. Topics are
+// created on first publish when the cluster allows it.
+func NewKafkaBus(brokers []string) (*KafkaBus, error) {
+	if len(brokers) == 0 || brokers[0] == "" {
+		return nil, errors.New("no Kafka brokers")
+	}
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+	}
+	return &KafkaBus{brokers: brokers, writer: writer}, nil
+}
+
+// Publish sends an event to the topic of its type, keyed by its ID
+func (b *KafkaBus) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.writer.WriteMessages(ctx, kafka.Message{Topic: event.Type, Key: []byte(event.ID), Value: data})
+}
+
+// Subscribe handles the events of a type, resuming after the last one the
+// group committed. Each event is committed once handled.
+func (b *KafkaBus) Subscribe(ctx context.Context, eventType, group string, handler Handler) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.brokers,
+		GroupID: group,
+		Topic:   eventType,
+	})
+	defer reader.Close()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		handle(ctx, msg.Value, handler)
+		if err := reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+// Close flushes pending publishes
+func (b *KafkaBus) Close() error {
+	return b.writer.Close()
+}
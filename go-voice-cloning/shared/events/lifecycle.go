@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// PublishCloneCreated announces a new clone job: its pending status, and a
+// clone.created lifecycle event. Call it after the clone has been inserted.
+func PublishCloneCreated(ctx context.Context, db sqlx.ExtContext, cloneID int) error {
+	if err := PublishCloneStatus(ctx, db, cloneID, types.StatusPending); err != nil {
+		return err
+	}
+	return recordLifecycle(ctx, db, cloneID, types.LifecycleCloneCreated)
+}
+
+// PublishCloneDeleted announces that a clone is being purged, with the
+// stored files that belonged only to it, for the storage service to remove.
+// Call it before the clone's row is deleted.
+func PublishCloneDeleted(ctx context.Context, db sqlx.ExtContext, cloneID int, files []string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO clone_lifecycle_events (event, clone_id, user_id, status, files)
+		SELECT $1, id, user_id, status, $2 FROM voice_clones WHERE id = $3`,
+		types.LifecycleCloneDeleted, pq.StringArray(files), cloneID)
+	return err
+}
+
+// lifecycleEvents maps the statuses that end a clone job to the lifecycle
+// event announcing them
+var lifecycleEvents = map[string]string{
+	types.StatusCompleted: types.LifecycleCloneCompleted,
+	types.StatusFailed:    types.LifecycleCloneFailed,
+}
+
+// recordLifecycle adds a lifecycle event of the clone's current state to the
+// outbox, and to the owner's activity feed
+func recordLifecycle(ctx context.Context, db sqlx.ExtContext, cloneID int, event string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO clone_lifecycle_events (event, clone_id, user_id, status)
+		SELECT $1, id, user_id, status FROM voice_clones WHERE id = $2`,
+		event, cloneID)
+	if err != nil || !cloneActivity[event] {
+		return err
+	}
+	return recordCloneActivity(ctx, db, cloneID, event)
+}
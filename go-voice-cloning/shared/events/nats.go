@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBuffer is how many received events a subscription holds while its
+// handler is busy
+const natsBuffer = 256
+
+// NATSBus publishes events to NATS subjects named after their type, and
+// shares each subscription's events within its group with queue
+// subscriptions
+type NATSBus struct {
+	conn *nats.Conn
+}
+
+// NewNATSBus connects to the NATS server at url. The connection reconnects
+// on its own for as long as the bus is open.
+func NewNATSBus(url string) (*NATSBus, error) {
+	conn, err := nats.Connect(url, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSBus{conn: conn}, nil
+}
+
+// Publish sends an event to the subject of its type
+func (b *NATSBus) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(event.Type, data)
+}
+
+// Subscribe handles the events of a type published from now on
+func (b *NATSBus) Subscribe(ctx context.Context, eventType, group string, handler Handler) error {
+	msgs := make(chan *nats.Msg, natsBuffer)
+	sub, err := b.conn.ChanQueueSubscribe(eventType, group, msgs)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-msgs:
+			handle(ctx, msg.Data, handler)
+		}
+	}
+}
+
+// Close drains pending publishes and closes the connection
+func (b *NATSBus) Close() error {
+	return b.conn.Drain()
+}
+
+// handle decodes a received event and passes it to the handler, logging
+// events that are malformed or fail
+func handle(ctx context.Context, data []byte, handler Handler) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		slog.Warn("Dropping malformed event", "error", err)
+		return
+	}
+	if err := handler(ctx, event); err != nil {
+		slog.Error("Failed to handle event", "event_id", event.ID, "event_type", event.Type, "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package events
+
+import "time"
+
+// Types of events on the event bus
+const (
+	TypeCloneCreated        = "clone.created"
+	TypeCloneCompleted      = "clone.completed"
+	TypeCloneFailed         = "clone.failed"
+	TypeFileUploaded        = "file.uploaded"
+	TypeUserDeleted         = "user.deleted"
+	TypeSubscriptionChanged = "subscription.changed"
+)
+
+// CloneCreated is published by the voice service once a new clone job has
+// been queued
+type CloneCreated struct {
+	CloneID int    `json:"clone_id"`
+	UserID  int    `json:"user_id"`
+	Status  string `json:"status"`
+}
+
+// EventType implements Payload
+func (CloneCreated) EventType() string { return TypeCloneCreated }
+
+// CloneCompleted is published by the voice service once a clone has been
+// trained and evaluated
+type CloneCompleted struct {
+	CloneID int `json:"clone_id"`
+	UserID  int `json:"user_id"`
+}
+
+// EventType implements Payload
+func (CloneCompleted) EventType() string { return TypeCloneCompleted }
+
+// CloneFailed is published by the voice service once a clone's job has
+// failed for good
+type CloneFailed struct {
+	CloneID int `json:"clone_id"`
+	UserID  int `json:"user_id"`
+}
+
+// EventType implements Payload
+func (CloneFailed) EventType() string { return TypeCloneFailed }
+
+// FileUploaded is published by the storage service once an upload has been
+// stored and recorded. UserID is zero for files no user owns, such as
+// clone outputs.
+type FileUploaded struct {
+	FileID      string `json:"file_id"`
+	UserID      int    `json:"user_id,omitempty"`
+	Filename    string `json:"filename"`
+	Class       string `json:"class"`
+	Type        string `json:"type"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Version     int    `json:"version"`
+}
+
+// EventType implements Payload
+func (FileUploaded) EventType() string { return TypeFileUploaded }
+
+// UserDeleted is published by the user service once an account has been
+// closed. EventID is the account's user_events row, which the services
+// removing the user's data report their progress against.
+type UserDeleted struct {
+	EventID int  `json:"event_id"`
+	UserID  int  `json:"user_id"`
+	ActorID *int `json:"actor_id,omitempty"`
+}
+
+// EventType implements Payload
+func (UserDeleted) EventType() string { return TypeUserDeleted }
+
+// SubscriptionChanged is published by the user service when billing
+// changes a user's subscription: its Stripe status and the plan it gives,
+// which is the free plan once it has ended
+type SubscriptionChanged struct {
+	UserID            int        `json:"user_id"`
+	Plan              string     `json:"plan"`
+	Status            string     `json:"status"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty"`
+}
+
+// EventType implements Payload
+func (SubscriptionChanged) EventType() string { return TypeSubscriptionChanged }
@@ -0,0 +1,18 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RecordUsage adds a quantity of a types.Usage* metric consumed by a user,
+// in the caller's transaction if db is one
+func RecordUsage(ctx context.Context, db sqlx.ExecerContext, userID int, metric, source string, quantity int64, at time.Time) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO usage_events (user_id, metric, quantity, source, occurred_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, metric, source) DO NOTHING`,
+		userID, metric, quantity, source, at.UTC())
+	return err
+}
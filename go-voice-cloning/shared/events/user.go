@@ -0,0 +1,24 @@
+package events
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// PublishUserDeleted records that an account was deleted. The event is
+// kept in the outbox, so consumers that were down when it was published
+// still see it; live consumers are notified on commit.
+func PublishUserDeleted(ctx context.Context, db sqlx.ExtContext, userID int, actorID *int) (int, error) {
+	var id int
+	err := sqlx.GetContext(ctx, db, &id,
+		"INSERT INTO user_events (kind, user_id, actor_id) VALUES ($1, $2, $3) RETURNING id",
+		types.UserEventDeleted, userID, actorID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, "SELECT pg_notify($1, $2::text)", types.UserEventsChannel, id)
+	return id, err
+}
@@ -0,0 +1,167 @@
+// Package featureflags turns features on for everyone, some users or no one
+// without redeploying. Flags come from providers: the environment, a JSON
+// file and a Redis hash, which every instance of every service reads, so a
+// flag set there rolls out everywhere within the refresh interval.
+//
+// A flag is on for a user when it is enabled, when it lists the user, or
+// when the user falls in its percentage. Users are placed by a hash of the
+// flag's name and their ID, so raising the percentage keeps the users it
+// already included.
+package featureflags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Flag is a feature's rollout
+type Flag struct {
+	// Enabled turns the feature on for everyone
+	Enabled bool `json:"enabled"`
+	// Percentage of users the feature is on for, 0 to 100
+	Percentage int `json:"percentage,omitempty"`
+	// Users the feature is on for whatever the percentage
+	Users []int `json:"users,omitempty"`
+}
+
+// On reports whether the flag is on for a user. Anonymous callers (user 0)
+// only see flags enabled for everyone.
+func (f Flag) On(name string, userID int) bool {
+	if f.Enabled {
+		return true
+	}
+	if userID == 0 {
+		return false
+	}
+	for _, id := range f.Users {
+		if id == userID {
+			return true
+		}
+	}
+	return f.Percentage > 0 && bucket(name, userID) < f.Percentage
+}
+
+// bucket places a user in 0-99 for a flag
+func bucket(name string, userID int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + strconv.Itoa(userID)))
+	return int(h.Sum32() % 100)
+}
+
+// Provider loads flags by name. A provider without a source returns none.
+type Provider interface {
+	Load(ctx context.Context) (map[string]Flag, error)
+}
+
+// Config names the flags' sources. Services hold it in a Features field of
+// their own Config.
+type Config struct {
+	// Path of a JSON file of flags by name
+	Path string `env:"FEATURE_FLAGS_PATH"`
+	// RedisURL of the Redis holding the feature_flags hash
+	RedisURL string `env:"FEATURE_FLAGS_REDIS_URL" secret:"true"`
+	// Refresh is how often the flags are reloaded
+	Refresh time.Duration `env:"FEATURE_FLAGS_REFRESH_SECONDS" default:"10" min:"1"`
+}
+
+// Flags answers whether features are on, reloading them from its
+// providers once they are older than the refresh interval
+type Flags struct {
+	defaults  map[string]Flag
+	providers []Provider
+	refresh   time.Duration
+
+	mu       sync.Mutex
+	flags    map[string]Flag
+	loadedAt time.Time
+}
+
+// New returns the flags of the configured sources. Providers override the
+// defaults and each other in order: the Redis hash, then the file, then
+// the environment, so a flag pinned in a deployment's environment can't be
+// changed under it.
+func New(cfg Config, defaults map[string]Flag) (*Flags, error) {
+	var providers []Provider
+	if cfg.RedisURL != "" {
+		redis, err := NewRedisProvider(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, redis)
+	}
+	if cfg.Path != "" {
+		providers = append(providers, FileProvider{Path: cfg.Path})
+	}
+	providers = append(providers, EnvProvider{})
+	return NewFlags(cfg.Refresh, defaults, providers...), nil
+}
+
+// NewFlags returns flags loaded from providers, later ones overriding
+// earlier ones and all of them the defaults
+func NewFlags(refresh time.Duration, defaults map[string]Flag, providers ...Provider) *Flags {
+	return &Flags{defaults: defaults, providers: providers, refresh: refresh}
+}
+
+// Enabled reports whether a feature is on for a user. Unknown flags are
+// off.
+func (f *Flags) Enabled(ctx context.Context, name string, userID int) bool {
+	flag, ok := f.current(ctx)[name]
+	return ok && flag.On(name, userID)
+}
+
+// current returns the flags, reloading them when they are stale. When a
+// source fails the flags loaded last are kept until the next refresh, so
+// an outage doesn't flip features.
+func (f *Flags) current(ctx context.Context) map[string]Flag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flags != nil && time.Since(f.loadedAt) < f.refresh {
+		return f.flags
+	}
+
+	flags := make(map[string]Flag, len(f.defaults))
+	for name, flag := range f.defaults {
+		flags[name] = flag
+	}
+	for _, p := range f.providers {
+		loaded, err := p.Load(ctx)
+		if err != nil {
+			slog.Warn("Failed to load feature flags", "provider", fmt.Sprintf("%T", p), "error", err)
+			if f.flags != nil {
+				f.loadedAt = time.Now()
+				return f.flags
+			}
+			continue
+		}
+		for name, flag := range loaded {
+			flags[name] = flag
+		}
+	}
+	f.flags = flags
+	f.loadedAt = time.Now()
+	return flags
+}
+
+// Require gates routes behind a flag. Callers it is off for get a 404, as
+// if the route didn't exist.
+func (f *Flags) Require(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !f.Enabled(r.Context(), name, middleware.UserID(r)) {
+				utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Not found")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
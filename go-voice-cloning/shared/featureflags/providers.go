@@ -0,0 +1,122 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// envPrefix starts the variables EnvProvider reads
+const envPrefix = "FEATURE_"
+
+// EnvProvider reads flags from FEATURE_<NAME> variables, e.g.
+// FEATURE_SYNTHESIS_STREAMING for "synthesis_streaming". The value is a
+// comma-separated list of "on", "off", a percentage such as "25%" and
+// users such as "user:42". The FEATURE_FLAGS_ settings aren't flags.
+type EnvProvider struct{}
+
+func (EnvProvider) Load(ctx context.Context) (map[string]Flag, error) {
+	flags := map[string]Flag{}
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, envPrefix) || strings.HasPrefix(key, "FEATURE_FLAGS_") {
+			continue
+		}
+		flag, err := ParseFlag(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		flags[strings.ToLower(strings.TrimPrefix(key, envPrefix))] = flag
+	}
+	return flags, nil
+}
+
+// ParseFlag reads a flag in the environment's format
+func ParseFlag(s string) (Flag, error) {
+	var flag Flag
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		switch {
+		case term == "":
+		case term == "on" || term == "true":
+			flag.Enabled = true
+		case term == "off" || term == "false":
+			flag.Enabled = false
+		case strings.HasSuffix(term, "%"):
+			pct, err := strconv.Atoi(strings.TrimSuffix(term, "%"))
+			if err != nil || pct < 0 || pct > 100 {
+				return flag, fmt.Errorf("invalid percentage %q", term)
+			}
+			flag.Percentage = pct
+		case strings.HasPrefix(term, "user:"):
+			id, err := strconv.Atoi(strings.TrimPrefix(term, "user:"))
+			if err != nil || id <= 0 {
+				return flag, fmt.Errorf("invalid user %q", term)
+			}
+			flag.Users = append(flag.Users, id)
+		default:
+			return flag, fmt.Errorf("invalid term %q", term)
+		}
+	}
+	return flag, nil
+}
+
+// FileProvider reads flags from a JSON object of flags by name:
+//
+//	{"synthesis_streaming": {"percentage": 10, "users": [42]}}
+type FileProvider struct {
+	Path string
+}
+
+func (p FileProvider) Load(ctx context.Context) (map[string]Flag, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]Flag{}
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Path, err)
+	}
+	return flags, nil
+}
+
+// RedisKey is the hash RedisProvider reads, of JSON flags by name. Flags
+// are set at runtime with, e.g.:
+//
+//	HSET feature_flags synthesis_streaming '{"percentage": 10}'
+const RedisKey = "feature_flags"
+
+// RedisProvider reads flags from the RedisKey hash
+type RedisProvider struct {
+	client *redis.Client
+}
+
+// NewRedisProvider returns a provider of the Redis at url
+func NewRedisProvider(url string) (*RedisProvider, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FEATURE_FLAGS_REDIS_URL: %w", err)
+	}
+	return &RedisProvider{client: redis.NewClient(opts)}, nil
+}
+
+func (p *RedisProvider) Load(ctx context.Context) (map[string]Flag, error) {
+	fields, err := p.client.HGetAll(ctx, RedisKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]Flag, len(fields))
+	for name, value := range fields {
+		var flag Flag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", RedisKey, name, err)
+		}
+		flags[name] = flag
+	}
+	return flags, nil
+}
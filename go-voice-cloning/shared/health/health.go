@@ -0,0 +1,151 @@
+// Package health serves the liveness and readiness probes of a service.
+// Each service registers a check per dependency it cannot serve without,
+// such as its database or job queue. /health/live answers as long as the
+// process does; /health/ready runs the checks and answers 503 when any of
+// them fails, so orchestrators stop routing traffic to an instance that
+// lost a dependency without restarting it.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Statuses of a service and of its dependencies
+const (
+	StatusHealthy   = "healthy"
+	StatusUnhealthy = "unhealthy"
+	StatusUp        = "up"
+	StatusDown      = "down"
+)
+
+// DefaultTimeout bounds each readiness check
+const DefaultTimeout = 2 * time.Second
+
+// Check probes a dependency, returning an error when it is unreachable
+type Check func(ctx context.Context) error
+
+// Checker holds the dependency checks of a service
+type Checker struct {
+	service string
+	// Timeout bounds each check; DefaultTimeout when zero
+	Timeout time.Duration
+
+	names  []string
+	checks map[string]Check
+}
+
+// Result is the outcome of a dependency's check
+type Result struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the body of the health endpoints
+type Report struct {
+	Status  string            `json:"status"`
+	Service string            `json:"service"`
+	Checks  map[string]Result `json:"checks,omitempty"`
+}
+
+// New returns a checker for service with no dependencies
+func New(service string) *Checker {
+	return &Checker{service: service, checks: map[string]Check{}}
+}
+
+// Add registers the check of a dependency under name
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Run checks every dependency concurrently. The service is healthy when
+// all of them are up.
+func (c *Checker) Run(ctx context.Context) Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	report := Report{Status: StatusHealthy, Service: c.service}
+	if len(c.names) == 0 {
+		return report
+	}
+	report.Checks = make(map[string]Result, len(c.names))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range c.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			result := Result{Status: StatusUp, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusUnhealthy
+			}
+		}(name, c.checks[name])
+	}
+	wg.Wait()
+	return report
+}
+
+// Live answers the liveness probe. It checks no dependency: a service that
+// can answer is alive.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	utils.JSONResponse(w, http.StatusOK, Report{Status: StatusHealthy, Service: c.service})
+}
+
+// Ready answers the readiness probe with the status of each dependency,
+// and 503 when any of them is down
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	utils.JSONResponse(w, report.HTTPStatus(), report)
+}
+
+// HTTPStatus is the status code a report is served with
+func (r Report) HTTPStatus() int {
+	if r.Status != StatusHealthy {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// Pinger is a connection that can be checked with a round trip, such as a
+// *sql.DB or *sqlx.DB
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// DB checks a database connection with a ping
+func DB(db Pinger) Check {
+	return db.PingContext
+}
+
+// Redis checks a Redis connection with a PING
+func Redis(client *redis.Client) Check {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
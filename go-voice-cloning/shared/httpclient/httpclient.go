@@ -0,0 +1,280 @@
+// Package httpclient is the HTTP client services call each other with. Each
+// attempt is bounded by a timeout and connections to a service are pooled.
+// Idempotent calls that fail on the network, time out or are shed with a
+// 502, 503 or 504 (or a 429) are retried with jittered exponential backoff.
+// A service that keeps failing trips a per-host circuit breaker, and calls
+// to it then fail fast with ErrBreakerOpen until it cools down.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/inproc"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// ErrBreakerOpen is returned, wrapped, for calls to a service whose circuit
+// breaker is open
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// IdempotencyKeyHeader marks a request as safe to repeat whatever its method
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Options tune a client. Zero values take the defaults.
+type Options struct {
+	// Timeout bounds each attempt, from dialing to reading the end of the
+	// body (default 10 seconds). The request's context bounds all of them.
+	Timeout time.Duration
+	// MaxAttempts of an idempotent call (default 3); other calls are tried
+	// once
+	MaxAttempts int
+	// Backoff before the second attempt, doubled for each further one up to
+	// MaxBackoff (defaults 100ms and 2 seconds). Each wait is jittered
+	// between half and all of it. A response asking to retry later than
+	// MaxBackoff is returned instead of waited on.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxIdleConnsPerHost pooled per service (default 64)
+	MaxIdleConnsPerHost int
+	// BreakerThreshold consecutive failures to a host open its breaker for
+	// BreakerCooldown (defaults 5 and 10 seconds). Once it cools down, a
+	// call that succeeds closes it and one that fails opens it again.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func (o *Options) applyDefaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 2 * time.Second
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 64
+	}
+	if o.BreakerThreshold <= 0 {
+		o.BreakerThreshold = 5
+	}
+	if o.BreakerCooldown <= 0 {
+		o.BreakerCooldown = 10 * time.Second
+	}
+}
+
+// New returns a client for calls to other services
+func New(opts Options) *http.Client {
+	opts.applyDefaults()
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.DialContext = inproc.Dial((&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext)
+	base.MaxIdleConns = 0
+	base.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	base.IdleConnTimeout = 90 * time.Second
+
+	return &http.Client{Transport: &transport{
+		base:     base,
+		opts:     opts,
+		breakers: map[string]*breaker{},
+	}}
+}
+
+type idempotentKey struct{}
+
+// Idempotent marks a request as safe to retry whatever its method, such as
+// a POST that only reads
+func Idempotent(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), idempotentKey{}, true))
+}
+
+// idempotent reports whether a request may be sent more than once. Its
+// body must be replayable too.
+func idempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked || req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+type transport struct {
+	base *http.Transport
+	opts Options
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func (t *transport) breaker(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.breaker(req.URL.Host)
+	attempts := 1
+	if idempotent(req) {
+		attempts = t.opts.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !b.allow() {
+			if attempt == 1 && req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", req.URL.Host, ErrBreakerOpen)
+		}
+
+		resp, err := t.attempt(req, attempt)
+		if req.Context().Err() != nil {
+			// The caller gave up; that says nothing of the service
+			return resp, err
+		}
+		failed := err != nil || unavailable(resp.StatusCode)
+		b.record(!failed, t.opts.BreakerThreshold, t.opts.BreakerCooldown)
+		if attempt >= attempts || !(failed || resp.StatusCode == http.StatusTooManyRequests) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header); ok {
+				if after > t.opts.MaxBackoff {
+					return resp, nil
+				}
+				if after > wait {
+					wait = after
+				}
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		logging.FromContext(req.Context()).Warn("Retrying internal call",
+			"method", req.Method, "host", req.URL.Host, "path", req.URL.Path, "attempt", attempt, "error", errorText(resp, err))
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt sends the request once, bounded by the timeout. The timeout's
+// context is released when the response body is closed.
+func (t *transport) attempt(req *http.Request, attempt int) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.Timeout)
+	r := req.Clone(ctx)
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff is the jittered wait after a failed attempt
+func (t *transport) backoff(attempt int) time.Duration {
+	d := t.opts.Backoff << uint(attempt-1)
+	if d > t.opts.MaxBackoff || d <= 0 {
+		d = t.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// unavailable reports whether a status means the service couldn't answer
+func unavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// retryAfter reads the retry guidance of a shed response: the services'
+// millisecond header, or the standard Retry-After in seconds
+func retryAfter(h http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseInt(h.Get(utils.RetryAfterMSHeader), 10, 64); err == nil && ms >= 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	if seconds, err := strconv.Atoi(h.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+func errorText(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// cancelBody releases an attempt's context once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// breaker counts a host's consecutive failures
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+func (b *breaker) record(ok bool, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
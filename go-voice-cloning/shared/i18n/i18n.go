@@ -0,0 +1,103 @@
+// Package i18n translates the messages people read: error responses, keyed
+// by their code, and notifications. A request's language is negotiated from
+// its Accept-Language header, falling back to the user's locale preference
+// and then English.
+//
+// Error messages are written in English where they are raised; other
+// languages replace them with the translation of their code. Codes without
+// a translation of their own get the one of their status's generic code,
+// so a client always shows a message in its user's language while the code
+// stays what it branches on.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voice-cloning/shared/apierror"
+)
+
+// Default is the language messages are written in
+const Default = "en"
+
+// Supported lists the languages with translations
+var Supported = []string{"en", "es", "fr", "de", "pt"}
+
+// Match returns the supported language of a BCP 47 tag such as pt-BR
+func Match(tag string) (string, bool) {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	for _, lang := range Supported {
+		if lang == primary {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// Negotiate picks the supported language an Accept-Language header prefers
+// most, ok false when it names none
+func Negotiate(acceptLanguage string) (string, bool) {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag != "" && tag != "*" && q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if lang, ok := Match(t.tag); ok {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// T translates a message, formatting args into it as fmt does.
+// Translations missing from a language fall back to English.
+func T(lang, key string, args ...interface{}) string {
+	format, ok := messages[lang][key]
+	if !ok {
+		format, ok = messages[Default][key]
+	}
+	if !ok {
+		format = key
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Error returns the message of an error code in lang, or that of the
+// generic code of status when the code has none. ok is false in English,
+// whose messages are the ones written where errors are raised, and for
+// unsupported languages.
+func Error(lang string, code apierror.Code, status int) (string, bool) {
+	if lang == Default {
+		return "", false
+	}
+	catalog, ok := errorMessages[lang]
+	if !ok {
+		return "", false
+	}
+	if message, ok := catalog[code]; ok {
+		return message, true
+	}
+	if status == 0 {
+		return "", false
+	}
+	message, ok := catalog[apierror.ForStatus(status)]
+	return message, ok
+}
@@ -0,0 +1,260 @@
+package i18n
+
+import "github.com/voice-cloning/shared/apierror"
+
+// Keys of the notification messages
+const (
+	CloneCompletedSubject    = "notification.clone_completed.subject"
+	CloneCompletedBody       = "notification.clone_completed.body"
+	SecurityAlertSubject     = "notification.security_alert.subject"
+	SecurityAlertBody        = "notification.security_alert.body"
+	DigestSubject            = "notification.digest.subject"
+	DigestPeriod             = "notification.digest.period"
+	DigestClonesCreated      = "notification.digest.clones_created"
+	DigestClonesCompleted    = "notification.digest.clones_completed"
+	DigestClonesFailed       = "notification.digest.clones_failed"
+	DigestFilesUploaded      = "notification.digest.files_uploaded"
+	DigestMinutesSynthesized = "notification.digest.minutes_synthesized"
+)
+
+// messages are the notification messages by language, as fmt formats
+var messages = map[string]map[string]string{
+	"en": {
+		CloneCompletedSubject:    "Your voice clone %q is ready",
+		CloneCompletedBody:       "Your voice clone %q (#%d) finished training and is ready to use.\n",
+		SecurityAlertSubject:     "New sign-in to your account",
+		SecurityAlertBody:        "Your account was signed in to from a new device on %s.\n\nDevice: %s\nIP address: %s\n\nIf this wasn't you, change your password now.\n",
+		DigestSubject:            "Your week in Voice Cloning",
+		DigestPeriod:             "Your week from %s to %s:\n\n",
+		DigestClonesCreated:      "Voice clones created: %d\n",
+		DigestClonesCompleted:    "Voice clones completed: %d\n",
+		DigestClonesFailed:       "Voice clones failed: %d\n",
+		DigestFilesUploaded:      "Files uploaded: %d\n",
+		DigestMinutesSynthesized: "Minutes synthesized: %.1f\n",
+	},
+	"es": {
+		CloneCompletedSubject:    "Tu clon de voz %q está listo",
+		CloneCompletedBody:       "Tu clon de voz %q (#%d) terminó su entrenamiento y ya se puede usar.\n",
+		SecurityAlertSubject:     "Nuevo inicio de sesión en tu cuenta",
+		SecurityAlertBody:        "Se inició sesión en tu cuenta desde un dispositivo nuevo el %s.\n\nDispositivo: %s\nDirección IP: %s\n\nSi no fuiste tú, cambia tu contraseña ahora.\n",
+		DigestSubject:            "Tu semana en Voice Cloning",
+		DigestPeriod:             "Tu semana del %s al %s:\n\n",
+		DigestClonesCreated:      "Clones de voz creados: %d\n",
+		DigestClonesCompleted:    "Clones de voz completados: %d\n",
+		DigestClonesFailed:       "Clones de voz fallidos: %d\n",
+		DigestFilesUploaded:      "Archivos subidos: %d\n",
+		DigestMinutesSynthesized: "Minutos sintetizados: %.1f\n",
+	},
+	"fr": {
+		CloneCompletedSubject:    "Votre clone vocal « %s » est prêt",
+		CloneCompletedBody:       "L'entraînement de votre clone vocal « %s » (n° %d) est terminé, il est prêt à l'emploi.\n",
+		SecurityAlertSubject:     "Nouvelle connexion à votre compte",
+		SecurityAlertBody:        "Une connexion à votre compte a eu lieu depuis un nouvel appareil le %s.\n\nAppareil : %s\nAdresse IP : %s\n\nSi ce n'était pas vous, changez votre mot de passe dès maintenant.\n",
+		DigestSubject:            "Votre semaine sur Voice Cloning",
+		DigestPeriod:             "Votre semaine du %s au %s :\n\n",
+		DigestClonesCreated:      "Clones vocaux créés : %d\n",
+		DigestClonesCompleted:    "Clones vocaux terminés : %d\n",
+		DigestClonesFailed:       "Clones vocaux en échec : %d\n",
+		DigestFilesUploaded:      "Fichiers envoyés : %d\n",
+		DigestMinutesSynthesized: "Minutes synthétisées : %.1f\n",
+	},
+	"de": {
+		CloneCompletedSubject:    "Dein Stimmklon %q ist bereit",
+		CloneCompletedBody:       "Das Training deines Stimmklons %q (#%d) ist abgeschlossen, er kann jetzt verwendet werden.\n",
+		SecurityAlertSubject:     "Neue Anmeldung bei deinem Konto",
+		SecurityAlertBody:        "Am %s hat sich ein neues Gerät bei deinem Konto angemeldet.\n\nGerät: %s\nIP-Adresse: %s\n\nWarst du das nicht, ändere jetzt dein Passwort.\n",
+		DigestSubject:            "Deine Woche bei Voice Cloning",
+		DigestPeriod:             "Deine Woche vom %s bis %s:\n\n",
+		DigestClonesCreated:      "Erstellte Stimmklone: %d\n",
+		DigestClonesCompleted:    "Fertige Stimmklone: %d\n",
+		DigestClonesFailed:       "Fehlgeschlagene Stimmklone: %d\n",
+		DigestFilesUploaded:      "Hochgeladene Dateien: %d\n",
+		DigestMinutesSynthesized: "Synthetisierte Minuten: %.1f\n",
+	},
+	"pt": {
+		CloneCompletedSubject:    "Seu clone de voz %q está pronto",
+		CloneCompletedBody:       "Seu clone de voz %q (#%d) terminou o treinamento e já pode ser usado.\n",
+		SecurityAlertSubject:     "Novo acesso à sua conta",
+		SecurityAlertBody:        "Sua conta foi acessada de um novo dispositivo em %s.\n\nDispositivo: %s\nEndereço IP: %s\n\nSe não foi você, altere sua senha agora.\n",
+		DigestSubject:            "Sua semana no Voice Cloning",
+		DigestPeriod:             "Sua semana de %s a %s:\n\n",
+		DigestClonesCreated:      "Clones de voz criados: %d\n",
+		DigestClonesCompleted:    "Clones de voz concluídos: %d\n",
+		DigestClonesFailed:       "Clones de voz com falha: %d\n",
+		DigestFilesUploaded:      "Arquivos enviados: %d\n",
+		DigestMinutesSynthesized: "Minutos sintetizados: %.1f\n",
+	},
+}
+
+// errorMessages are the messages of error codes by language. Every
+// language translates the generic codes.
+var errorMessages = map[string]map[apierror.Code]string{
+	"es": {
+		apierror.BadRequest:           "Solicitud no válida",
+		apierror.Unauthorized:         "No autorizado",
+		apierror.Forbidden:            "No tienes permiso para hacer esto",
+		apierror.NotFound:             "No encontrado",
+		apierror.MethodNotAllowed:     "Método no permitido",
+		apierror.Conflict:             "La solicitud entra en conflicto con el estado actual",
+		apierror.Gone:                 "Ya no está disponible",
+		apierror.PreconditionFailed:   "El recurso cambió desde que lo leíste",
+		apierror.PayloadTooLarge:      "La solicitud es demasiado grande",
+		apierror.UnsupportedMediaType: "Tipo de contenido no admitido",
+		apierror.Unprocessable:        "No se puede procesar la solicitud",
+		apierror.RateLimited:          "Demasiadas solicitudes, inténtalo más tarde",
+		apierror.Internal:             "Error interno del servidor",
+		apierror.UpstreamError:        "Un servicio interno respondió con un error",
+		apierror.Unavailable:          "Servicio no disponible, inténtalo más tarde",
+		apierror.Timeout:              "El servicio tardó demasiado en responder",
+
+		apierror.InvalidRequestBody:     "El cuerpo de la solicitud no es válido",
+		apierror.ValidationFailed:       "Algunos campos no son válidos",
+		apierror.InvalidParameter:       "Un parámetro no es válido",
+		apierror.AuthenticationRequired: "Inicia sesión para continuar",
+		apierror.InvalidToken:           "La sesión no es válida o ha caducado",
+		apierror.InvalidCredentials:     "Correo o contraseña incorrectos",
+		apierror.AdminRequired:          "Se requiere el rol de administrador",
+		apierror.VoiceCloneNotFound:     "Clon de voz no encontrado",
+		apierror.FileNotFound:           "Archivo no encontrado",
+		apierror.UserNotFound:           "Usuario no encontrado",
+		apierror.UserExists:             "Ya existe un usuario con ese correo o nombre",
+		apierror.CloneState:             "El clon de voz no admite esta acción en su estado actual",
+		apierror.CloneArchived:          "El clon de voz está archivado",
+		apierror.RetryLimitReached:      "Se alcanzó el límite de reintentos",
+		apierror.SynthesisFailed:        "La síntesis falló",
+		apierror.SourceAudioInvalid:     "El audio de origen no es válido",
+		apierror.UploadPolicy:           "El archivo no cumple la política de subida",
+		apierror.FileQuarantined:        "El archivo está en cuarentena",
+		apierror.IdempotencyBusy:        "Una solicitud con esta Idempotency-Key está en curso, inténtalo de nuevo",
+		apierror.IdempotencyReused:      "La Idempotency-Key ya se usó para otra solicitud",
+		apierror.QuotaExceeded:          "Se alcanzó un límite de tu plan",
+		apierror.Overloaded:             "El servicio está sobrecargado, inténtalo más tarde",
+		apierror.Maintenance:            "El servicio está en mantenimiento, inténtalo más tarde",
+	},
+	"fr": {
+		apierror.BadRequest:           "Requête invalide",
+		apierror.Unauthorized:         "Non autorisé",
+		apierror.Forbidden:            "Vous n'avez pas l'autorisation de faire cela",
+		apierror.NotFound:             "Introuvable",
+		apierror.MethodNotAllowed:     "Méthode non autorisée",
+		apierror.Conflict:             "La requête est en conflit avec l'état actuel",
+		apierror.Gone:                 "N'est plus disponible",
+		apierror.PreconditionFailed:   "La ressource a changé depuis votre lecture",
+		apierror.PayloadTooLarge:      "La requête est trop volumineuse",
+		apierror.UnsupportedMediaType: "Type de contenu non pris en charge",
+		apierror.Unprocessable:        "Impossible de traiter la requête",
+		apierror.RateLimited:          "Trop de requêtes, réessayez plus tard",
+		apierror.Internal:             "Erreur interne du serveur",
+		apierror.UpstreamError:        "Un service interne a répondu par une erreur",
+		apierror.Unavailable:          "Service indisponible, réessayez plus tard",
+		apierror.Timeout:              "Le service a mis trop de temps à répondre",
+
+		apierror.InvalidRequestBody:     "Le corps de la requête est invalide",
+		apierror.ValidationFailed:       "Certains champs sont invalides",
+		apierror.InvalidParameter:       "Un paramètre est invalide",
+		apierror.AuthenticationRequired: "Connectez-vous pour continuer",
+		apierror.InvalidToken:           "La session est invalide ou a expiré",
+		apierror.InvalidCredentials:     "E-mail ou mot de passe incorrect",
+		apierror.AdminRequired:          "Le rôle administrateur est requis",
+		apierror.VoiceCloneNotFound:     "Clone vocal introuvable",
+		apierror.FileNotFound:           "Fichier introuvable",
+		apierror.UserNotFound:           "Utilisateur introuvable",
+		apierror.UserExists:             "Un utilisateur avec cet e-mail ou ce nom existe déjà",
+		apierror.CloneState:             "Le clone vocal ne permet pas cette action dans son état actuel",
+		apierror.CloneArchived:          "Le clone vocal est archivé",
+		apierror.RetryLimitReached:      "La limite de nouvelles tentatives est atteinte",
+		apierror.SynthesisFailed:        "La synthèse a échoué",
+		apierror.SourceAudioInvalid:     "L'audio source est invalide",
+		apierror.UploadPolicy:           "Le fichier ne respecte pas la politique d'envoi",
+		apierror.FileQuarantined:        "Le fichier est en quarantaine",
+		apierror.IdempotencyBusy:        "Une requête avec cette Idempotency-Key est en cours, réessayez",
+		apierror.IdempotencyReused:      "Cette Idempotency-Key a déjà servi pour une autre requête",
+		apierror.QuotaExceeded:          "Une limite de votre forfait est atteinte",
+		apierror.Overloaded:             "Le service est surchargé, réessayez plus tard",
+		apierror.Maintenance:            "Le service est en maintenance, réessayez plus tard",
+	},
+	"de": {
+		apierror.BadRequest:           "Ungültige Anfrage",
+		apierror.Unauthorized:         "Nicht autorisiert",
+		apierror.Forbidden:            "Dazu fehlt dir die Berechtigung",
+		apierror.NotFound:             "Nicht gefunden",
+		apierror.MethodNotAllowed:     "Methode nicht erlaubt",
+		apierror.Conflict:             "Die Anfrage steht im Konflikt mit dem aktuellen Zustand",
+		apierror.Gone:                 "Nicht mehr verfügbar",
+		apierror.PreconditionFailed:   "Die Ressource hat sich seit dem Lesen geändert",
+		apierror.PayloadTooLarge:      "Die Anfrage ist zu groß",
+		apierror.UnsupportedMediaType: "Nicht unterstützter Inhaltstyp",
+		apierror.Unprocessable:        "Die Anfrage kann nicht verarbeitet werden",
+		apierror.RateLimited:          "Zu viele Anfragen, versuche es später erneut",
+		apierror.Internal:             "Interner Serverfehler",
+		apierror.UpstreamError:        "Ein interner Dienst hat mit einem Fehler geantwortet",
+		apierror.Unavailable:          "Dienst nicht verfügbar, versuche es später erneut",
+		apierror.Timeout:              "Der Dienst hat zu lange nicht geantwortet",
+
+		apierror.InvalidRequestBody:     "Der Inhalt der Anfrage ist ungültig",
+		apierror.ValidationFailed:       "Einige Felder sind ungültig",
+		apierror.InvalidParameter:       "Ein Parameter ist ungültig",
+		apierror.AuthenticationRequired: "Melde dich an, um fortzufahren",
+		apierror.InvalidToken:           "Die Sitzung ist ungültig oder abgelaufen",
+		apierror.InvalidCredentials:     "E-Mail oder Passwort ist falsch",
+		apierror.AdminRequired:          "Die Administratorrolle ist erforderlich",
+		apierror.VoiceCloneNotFound:     "Stimmklon nicht gefunden",
+		apierror.FileNotFound:           "Datei nicht gefunden",
+		apierror.UserNotFound:           "Benutzer nicht gefunden",
+		apierror.UserExists:             "Ein Benutzer mit dieser E-Mail oder diesem Namen existiert bereits",
+		apierror.CloneState:             "Der Stimmklon erlaubt diese Aktion in seinem aktuellen Zustand nicht",
+		apierror.CloneArchived:          "Der Stimmklon ist archiviert",
+		apierror.RetryLimitReached:      "Das Limit für Wiederholungen ist erreicht",
+		apierror.SynthesisFailed:        "Die Synthese ist fehlgeschlagen",
+		apierror.SourceAudioInvalid:     "Das Quellaudio ist ungültig",
+		apierror.UploadPolicy:           "Die Datei entspricht nicht der Upload-Richtlinie",
+		apierror.FileQuarantined:        "Die Datei ist in Quarantäne",
+		apierror.IdempotencyBusy:        "Eine Anfrage mit diesem Idempotency-Key läuft noch, versuche es erneut",
+		apierror.IdempotencyReused:      "Dieser Idempotency-Key wurde bereits für eine andere Anfrage verwendet",
+		apierror.QuotaExceeded:          "Ein Limit deines Tarifs ist erreicht",
+		apierror.Overloaded:             "Der Dienst ist überlastet, versuche es später erneut",
+		apierror.Maintenance:            "Der Dienst wird gewartet, versuche es später erneut",
+	},
+	"pt": {
+		apierror.BadRequest:           "Solicitação inválida",
+		apierror.Unauthorized:         "Não autorizado",
+		apierror.Forbidden:            "Você não tem permissão para fazer isso",
+		apierror.NotFound:             "Não encontrado",
+		apierror.MethodNotAllowed:     "Método não permitido",
+		apierror.Conflict:             "A solicitação conflita com o estado atual",
+		apierror.Gone:                 "Não está mais disponível",
+		apierror.PreconditionFailed:   "O recurso mudou desde a sua leitura",
+		apierror.PayloadTooLarge:      "A solicitação é grande demais",
+		apierror.UnsupportedMediaType: "Tipo de conteúdo não suportado",
+		apierror.Unprocessable:        "Não foi possível processar a solicitação",
+		apierror.RateLimited:          "Solicitações demais, tente novamente mais tarde",
+		apierror.Internal:             "Erro interno do servidor",
+		apierror.UpstreamError:        "Um serviço interno respondeu com um erro",
+		apierror.Unavailable:          "Serviço indisponível, tente novamente mais tarde",
+		apierror.Timeout:              "O serviço demorou demais para responder",
+
+		apierror.InvalidRequestBody:     "O corpo da solicitação é inválido",
+		apierror.ValidationFailed:       "Alguns campos são inválidos",
+		apierror.InvalidParameter:       "Um parâmetro é inválido",
+		apierror.AuthenticationRequired: "Entre na sua conta para continuar",
+		apierror.InvalidToken:           "A sessão é inválida ou expirou",
+		apierror.InvalidCredentials:     "E-mail ou senha incorretos",
+		apierror.AdminRequired:          "É necessário o papel de administrador",
+		apierror.VoiceCloneNotFound:     "Clone de voz não encontrado",
+		apierror.FileNotFound:           "Arquivo não encontrado",
+		apierror.UserNotFound:           "Usuário não encontrado",
+		apierror.UserExists:             "Já existe um usuário com esse e-mail ou nome",
+		apierror.CloneState:             "O clone de voz não permite esta ação no estado atual",
+		apierror.CloneArchived:          "O clone de voz está arquivado",
+		apierror.RetryLimitReached:      "O limite de novas tentativas foi atingido",
+		apierror.SynthesisFailed:        "A síntese falhou",
+		apierror.SourceAudioInvalid:     "O áudio de origem é inválido",
+		apierror.UploadPolicy:           "O arquivo não atende à política de envio",
+		apierror.FileQuarantined:        "O arquivo está em quarentena",
+		apierror.IdempotencyBusy:        "Uma solicitação com esta Idempotency-Key está em andamento, tente novamente",
+		apierror.IdempotencyReused:      "Esta Idempotency-Key já foi usada para outra solicitação",
+		apierror.QuotaExceeded:          "Um limite do seu plano foi atingido",
+		apierror.Overloaded:             "O serviço está sobrecarregado, tente novamente mais tarde",
+		apierror.Maintenance:            "O serviço está em manutenção, tente novamente mais tarde",
+	},
+}
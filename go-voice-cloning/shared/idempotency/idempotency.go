@@ -0,0 +1,244 @@
+// Package idempotency lets clients retry mutating requests safely. A request
+// carrying an Idempotency-Key runs once; retries of it within the key's TTL
+// get the stored response back instead of running again. Keys are per user
+// and kept in the shared idempotency_keys table, so any instance of any
+// service can answer a retry.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// KeyHeader lets clients retry a request without repeating its effect;
+// ReplayedHeader marks a response replayed for a retry
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+const (
+	maxKeyLength = 255
+	// maxHashedBytes of a body are held to fingerprint it. Larger bodies,
+	// such as uploads, are fingerprinted by their size and media type and
+	// streamed to the handler.
+	maxHashedBytes = 1 << 20
+	// unavailableRetryAfter is how long clients are told to wait when the
+	// database is unavailable
+	unavailableRetryAfter = 5 * time.Second
+)
+
+// Config is how long keys are kept. Services hold it in an Idempotency
+// field of their own Config.
+type Config struct {
+	TTL time.Duration `env:"IDEMPOTENCY_KEY_TTL_HOURS" default:"24" unit:"h" min:"1"`
+}
+
+// Store keeps keys and their responses
+type Store struct {
+	db  *sqlx.DB
+	ttl time.Duration
+}
+
+// New returns a store of keys in db
+func New(db *sqlx.DB, cfg Config) *Store {
+	return &Store{db: db, ttl: cfg.TTL}
+}
+
+// recorder keeps a copy of the response it writes
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Wrap deduplicates requests carrying an Idempotency-Key. The first request
+// with a key runs; retries of it within the TTL get its response back
+// instead of running again. Reusing a key for a different request is
+// rejected, and so are retries while the first request is still running.
+// Responses that are worth retrying, server errors and 429s, release the
+// key. Requests without a key, or without an authenticated caller, run as
+// usual.
+func (s *Store) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(KeyHeader)
+		userID := middleware.UserID(r)
+		if key == "" || userID == 0 {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidHeader, "Idempotency-Key is limited to 255 characters")
+			return
+		}
+
+		hash, err := fingerprint(r)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Failed to read request body")
+			return
+		}
+
+		// An expired key is claimed again as if it were new
+		now := time.Now()
+		var claimed bool
+		err = s.db.GetContext(r.Context(), &claimed,
+			`INSERT INTO idempotency_keys (user_id, key, request_hash, created_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL,
+				response = NULL, location = NULL, created_at = EXCLUDED.created_at
+			WHERE idempotency_keys.created_at < $5
+			RETURNING true`,
+			userID, key, hash, now, now.Add(-s.ttl))
+		if errors.Is(err, sql.ErrNoRows) {
+			s.replay(r.Context(), w, userID, key, hash)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to claim idempotency key", "error", err)
+			dbError(w, err)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		stored := false
+		defer func() {
+			if !stored {
+				s.db.ExecContext(context.WithoutCancel(r.Context()), "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2", userID, key)
+			}
+		}()
+		next(rec, r)
+
+		if rec.status == 0 || rec.status >= 500 || rec.status == http.StatusTooManyRequests {
+			return
+		}
+		_, err = s.db.ExecContext(context.WithoutCancel(r.Context()),
+			`UPDATE idempotency_keys SET status_code = $1, response = $2, location = NULLIF($3, '')
+			WHERE user_id = $4 AND key = $5`,
+			rec.status, rec.body.String(), w.Header().Get("Location"), userID, key)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to store the response of an idempotency key", "error", err)
+			return
+		}
+		stored = true
+	}
+}
+
+// fingerprint hashes what makes a request the same request: its method,
+// path and body. Bodies too large to hold are hashed by their size and
+// media type, and passed on unread.
+func fingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+
+	head, err := io.ReadAll(io.LimitReader(r.Body, maxHashedBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(head) <= maxHashedBytes {
+		h.Write(head)
+		r.Body = io.NopCloser(bytes.NewReader(head))
+	} else {
+		// Multipart boundaries differ between retries, so only the media
+		// type counts
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		fmt.Fprintf(h, "%d %s", r.ContentLength, mediaType)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replay answers a request whose key was already used
+func (s *Store) replay(ctx context.Context, w http.ResponseWriter, userID int, key, hash string) {
+	var stored struct {
+		RequestHash string         `db:"request_hash"`
+		StatusCode  sql.NullInt64  `db:"status_code"`
+		Response    sql.NullString `db:"response"`
+		Location    sql.NullString `db:"location"`
+	}
+	err := s.db.GetContext(ctx, &stored,
+		"SELECT request_hash, status_code, response, location FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+		userID, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released since the claim failed; the client can retry right away
+		w.Header().Set("Retry-After", "1")
+		utils.ErrorResponse(w, http.StatusConflict, apierror.IdempotencyBusy, "A request with this Idempotency-Key is in progress, try again")
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if stored.RequestHash != hash {
+		utils.ErrorResponse(w, http.StatusUnprocessableEntity, apierror.IdempotencyReused, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if !stored.StatusCode.Valid {
+		w.Header().Set("Retry-After", "1")
+		utils.ErrorResponse(w, http.StatusConflict, apierror.IdempotencyBusy, "A request with this Idempotency-Key is in progress, try again")
+		return
+	}
+
+	if stored.Location.Valid {
+		w.Header().Set("Location", stored.Location.String)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(int(stored.StatusCode.Int64))
+	io.WriteString(w, stored.Response.String)
+}
+
+// dbError answers a failed query: 503 with retry guidance when the
+// database couldn't be reached or didn't answer in time, otherwise 500
+func dbError(w http.ResponseWriter, err error) {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		utils.RetryResponse(w, http.StatusServiceUnavailable, "Database unavailable, try again later",
+			utils.NewRetryGuidance(utils.RetryReasonUpstreamUnavailable, unavailableRetryAfter))
+		return
+	}
+	utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to process request")
+}
+
+// Purge deletes keys past the TTL
+func (s *Store) Purge(ctx context.Context) {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", time.Now().Add(-s.ttl))
+	if err != nil {
+		slog.Error("Failed to purge idempotency keys", "error", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package inproc connects services composed into one process over in-memory
+// connections. A composed service serves on the Listener of a host name,
+// such as voice-service.inproc, and calls to that host from
+// http.DefaultTransport and the httpclient clients reach it without going
+// through the network. Calls to other hosts are dialed as usual.
+package inproc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Suffix ends the host names of composed services, which are never looked
+// up
+const Suffix = ".inproc"
+
+var (
+	mu        sync.RWMutex
+	listeners = map[string]*Listener{}
+)
+
+// Listener accepts the in-memory connections dialed to a host
+type Listener struct {
+	host   string
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Listen returns the listener of a service's host name, such as
+// voice-service.inproc, and its URL to reach it at
+func Listen(name string) (*Listener, string) {
+	host := name + Suffix
+	l := &Listener{host: host, conns: make(chan net.Conn), closed: make(chan struct{})}
+	mu.Lock()
+	listeners[host] = l
+	mu.Unlock()
+	return l, "http://" + host
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		mu.Lock()
+		delete(listeners, l.host)
+		mu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return addr(l.host)
+}
+
+type addr string
+
+func (a addr) Network() string { return "inproc" }
+func (a addr) String() string  { return string(a) }
+
+// DialFunc dials a network address, as http.Transport.DialContext
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dial connects to the hosts listened on in this process in memory, and to
+// the others with next
+func Dial(next DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil || !strings.HasSuffix(host, Suffix) {
+			return next(ctx, network, address)
+		}
+		mu.RLock()
+		l := listeners[host]
+		mu.RUnlock()
+		if l == nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: addr(host), Err: errors.New("no service listening in this process")}
+		}
+		client, server := net.Pipe()
+		select {
+		case l.conns <- server:
+			return client, nil
+		case <-l.closed:
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: addr(host), Err: net.ErrClosed}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Install has http.DefaultTransport, and so http.DefaultClient and the
+// clients built from it afterwards, reach the services listening in this
+// process. Call it before building the services' clients.
+func Install() {
+	t := http.DefaultTransport.(*http.Transport)
+	next := t.DialContext
+	if next == nil {
+		next = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = Dial(next)
+}
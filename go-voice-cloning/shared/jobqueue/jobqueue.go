@@ -0,0 +1,623 @@
+// Package jobqueue is the durable voice clone job queue shared by the voice
+// service, which enqueues jobs, and the voice worker, which consumes them.
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/workerpool"
+)
+
+// Job is a queued voice clone processing request
+type Job struct {
+	CloneID  int
+	Attempt  int
+	Priority string // a types.Priority* tier; empty means normal
+	// SynthesisID is set for speech synthesis jobs on a completed clone
+	SynthesisID int
+	// ModelVersion is set for jobs retraining a completed clone, naming the
+	// model version to produce
+	ModelVersion int
+}
+
+// Handler processes a job. Returning an error schedules a retry until the
+// attempt limit is reached, after which the job is dead-lettered.
+type Handler func(ctx context.Context, job Job) error
+
+// deferError puts a job back in the queue without counting an attempt
+type deferError struct {
+	after time.Duration
+}
+
+func (e *deferError) Error() string {
+	return fmt.Sprintf("job deferred for %s", e.after)
+}
+
+// Defer returns an error that, returned by a Handler, queues the job again
+// after the given delay instead of retrying it. The job keeps its attempt
+// count and priority.
+func Defer(after time.Duration) error {
+	return &deferError{after: after}
+}
+
+// Options tune consumer behaviour
+type Options struct {
+	// MaxAttempts before a job is dead-lettered (default 3)
+	MaxAttempts int
+	// ClaimIdle is how long a job may go without its consumer's heartbeat
+	// before another consumer reclaims it (default 5 minutes). Consumers
+	// renew the jobs they hold every ClaimIdle/3, however long they run.
+	ClaimIdle time.Duration
+	// Weights is the share of reads each priority tier gets while several
+	// tiers have jobs waiting (default high 6, normal 3, low 1). Every tier
+	// with a positive weight is served eventually, so none starves.
+	Weights map[string]int
+}
+
+// Queue is a durable job queue backed by one Redis Stream per priority tier,
+// sharing a consumer group name. Jobs are acknowledged only after they
+// finish, so jobs held by a worker that crashes or restarts are reclaimed by
+// another consumer.
+type Queue struct {
+	client      *redis.Client
+	streams     map[string]string // priority tier -> stream
+	schedule    []string          // weighted order in which tiers are read
+	turn        int
+	deadLetter  string
+	deferred    string
+	group       string
+	consumer    string
+	cancels     string
+	maxAttempts int
+	claimIdle   time.Duration
+
+	// Jobs running in this consumer, cancellable by clone ID, and the
+	// messages it holds, queued in the pool or running, by stream
+	mu      sync.Mutex
+	running map[int]context.CancelFunc
+	held    map[string]map[string]bool
+
+	// OnDeadLetter is called when a job exhausts its attempts
+	OnDeadLetter func(job Job, err error)
+}
+
+// New connects to Redis and ensures the stream and consumer group exist
+func New(ctx context.Context, redisURL string, opts Options) (*Queue, error) {
+	redisOpts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.ClaimIdle <= 0 {
+		opts.ClaimIdle = 5 * time.Minute
+	}
+	if opts.Weights == nil {
+		opts.Weights = map[string]int{types.PriorityHigh: 6, types.PriorityNormal: 3, types.PriorityLow: 1}
+	}
+
+	consumer, _ := os.Hostname()
+	if consumer == "" {
+		consumer = "voice-worker"
+	}
+
+	q := &Queue{
+		client: redis.NewClient(redisOpts),
+		// Normal jobs keep the original stream so queued jobs survive upgrades
+		streams: map[string]string{
+			types.PriorityHigh:   "voice:jobs:high",
+			types.PriorityNormal: "voice:jobs",
+			types.PriorityLow:    "voice:jobs:low",
+		},
+		schedule:    weightedSchedule(opts.Weights),
+		deadLetter:  "voice:jobs:dead",
+		deferred:    "voice:jobs:deferred",
+		group:       "voice-workers",
+		cancels:     "voice:jobs:cancel",
+		consumer:    fmt.Sprintf("%s-%d", consumer, os.Getpid()),
+		maxAttempts: opts.MaxAttempts,
+		claimIdle:   opts.ClaimIdle,
+		running:     map[int]context.CancelFunc{},
+		held:        map[string]map[string]bool{},
+	}
+
+	if err := q.client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	for _, stream := range q.streams {
+		err = q.client.XGroupCreateMkStream(ctx, stream, q.group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("failed to create consumer group: %w", err)
+		}
+	}
+
+	return q, nil
+}
+
+// weightedSchedule interleaves tiers in proportion to their weights (smooth
+// weighted round-robin), so a run of high priority reads is broken up by
+// lower tiers rather than followed by them
+func weightedSchedule(weights map[string]int) []string {
+	total := 0
+	for _, p := range types.Priorities {
+		total += weights[p]
+	}
+	if total == 0 {
+		return append([]string{}, types.Priorities...)
+	}
+
+	schedule := make([]string, 0, total)
+	current := map[string]int{}
+	for len(schedule) < total {
+		best := ""
+		for _, p := range types.Priorities {
+			current[p] += weights[p]
+			if best == "" || current[p] > current[best] {
+				best = p
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+	return schedule
+}
+
+// streamFor returns the stream of a priority tier
+func (q *Queue) streamFor(priority string) string {
+	if stream, ok := q.streams[priority]; ok {
+		return stream
+	}
+	return q.streams[types.PriorityNormal]
+}
+
+// Enqueue adds a job to the stream of its priority tier
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	if q.streams[job.Priority] == "" {
+		job.Priority = types.PriorityNormal
+	}
+	values := map[string]interface{}{
+		"clone_id": job.CloneID,
+		"attempt":  job.Attempt,
+		"priority": job.Priority,
+	}
+	if job.SynthesisID != 0 {
+		values["synthesis_id"] = job.SynthesisID
+	}
+	if job.ModelVersion != 0 {
+		values["model_version"] = job.ModelVersion
+	}
+	return q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.streamFor(job.Priority),
+		Values: values,
+	}).Err()
+}
+
+// Cancel interrupts a running job for a clone in whichever consumer holds
+// it. Queued jobs are not removed; handlers are expected to skip jobs whose
+// clone was cancelled.
+func (q *Queue) Cancel(ctx context.Context, cloneID int) error {
+	return q.client.Publish(ctx, q.cancels, cloneID).Err()
+}
+
+// listenForCancels cancels the context of local jobs named on the cancel
+// channel
+func (q *Queue) listenForCancels(ctx context.Context) {
+	sub := q.client.Subscribe(ctx, q.cancels)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			cloneID, err := strconv.Atoi(msg.Payload)
+			if err != nil {
+				continue
+			}
+			q.mu.Lock()
+			if cancel, ok := q.running[cloneID]; ok {
+				log.Printf("Cancelling voice clone %d", cloneID)
+				cancel()
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// Run consumes jobs until ctx is cancelled, handing each one to the pool.
+// Submission blocks while the pool is saturated, which keeps unclaimed jobs
+// in Redis where other consumers can pick them up.
+func (q *Queue) Run(ctx context.Context, pool *workerpool.Pool, handler Handler) {
+	go q.listenForCancels(ctx)
+	go q.heartbeat(ctx)
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) > q.claimIdle/2 {
+			for _, stream := range q.streams {
+				q.reclaim(ctx, pool, handler, stream)
+			}
+			lastClaim = time.Now()
+		}
+		q.releaseDeferred(ctx)
+
+		streams, err := q.read(ctx)
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			log.Printf("Failed to read job queue: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				q.dispatch(ctx, pool, handler, stream.Stream, msg)
+			}
+		}
+	}
+}
+
+// read takes the next job, trying the tier whose turn it is first and then
+// the others from highest priority down. When every tier is empty it blocks
+// on all of them for the next job to arrive.
+func (q *Queue) read(ctx context.Context) ([]redis.XStream, error) {
+	first := q.schedule[q.turn%len(q.schedule)]
+	q.turn++
+
+	order := []string{first}
+	for _, p := range types.Priorities {
+		if p != first {
+			order = append(order, p)
+		}
+	}
+	for _, p := range order {
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    q.group,
+			Consumer: q.consumer,
+			Streams:  []string{q.streams[p], ">"},
+			Count:    1,
+			Block:    -1,
+		}).Result()
+		if err == nil {
+			return streams, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, 2*len(order))
+	for _, p := range order {
+		keys = append(keys, q.streams[p])
+	}
+	for range order {
+		keys = append(keys, ">")
+	}
+	return q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: q.consumer,
+		Streams:  keys,
+		Count:    1,
+		Block:    5 * time.Second,
+	}).Result()
+}
+
+// deferJob holds a job back until after has passed
+func (q *Queue) deferJob(ctx context.Context, job Job, after time.Duration) error {
+	member, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.ZAdd(ctx, q.deferred, redis.Z{
+		Score:  float64(time.Now().Add(after).UnixMilli()),
+		Member: member,
+	}).Err()
+}
+
+// releaseDeferred queues the deferred jobs that are due. Consumers releasing
+// side by side each get a job only once, as only one removes it.
+func (q *Queue) releaseDeferred(ctx context.Context) {
+	due, err := q.client.ZRangeByScore(ctx, q.deferred, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to read deferred jobs: %v", err)
+		}
+		return
+	}
+	for _, member := range due {
+		var job Job
+		if err := json.Unmarshal([]byte(member), &job); err != nil {
+			log.Printf("Discarding malformed deferred job %q: %v", member, err)
+			q.client.ZRem(ctx, q.deferred, member)
+			continue
+		}
+		if n, err := q.client.ZRem(ctx, q.deferred, member).Result(); err != nil || n == 0 {
+			continue
+		}
+		if err := q.Enqueue(ctx, job); err != nil {
+			log.Printf("Failed to queue deferred voice clone %d, deferring again: %v", job.CloneID, err)
+			q.deferJob(ctx, job, time.Second)
+		}
+	}
+}
+
+// heartbeat resets the idle time of the messages this consumer holds every
+// claimIdle/3 until ctx is cancelled, so jobs that run or wait in the pool
+// longer than claimIdle aren't reclaimed and run a second time
+func (q *Queue) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(q.claimIdle / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for stream, ids := range q.heldMessages() {
+				err := q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+					Stream:   stream,
+					Group:    q.group,
+					Consumer: q.consumer,
+					Messages: ids,
+				}).Err()
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Failed to renew held jobs: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// hold records that this consumer has taken a message, until release
+func (q *Queue) hold(stream, id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.held[stream] == nil {
+		q.held[stream] = map[string]bool{}
+	}
+	q.held[stream][id] = true
+}
+
+func (q *Queue) release(stream, id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.held[stream], id)
+}
+
+func (q *Queue) holds(stream, id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held[stream][id]
+}
+
+func (q *Queue) heldMessages() map[string][]string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := map[string][]string{}
+	for stream, ids := range q.held {
+		for id := range ids {
+			messages[stream] = append(messages[stream], id)
+		}
+	}
+	return messages
+}
+
+// reclaim takes over jobs left unacknowledged by consumers that died. Jobs
+// this consumer still holds are left to it, even when a missed heartbeat let
+// them go idle.
+func (q *Queue) reclaim(ctx context.Context, pool *workerpool.Pool, handler Handler, stream string) {
+	msgs, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    q.group,
+		Consumer: q.consumer,
+		MinIdle:  q.claimIdle,
+		Start:    "0",
+		Count:    100,
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to reclaim stale jobs: %v", err)
+		}
+		return
+	}
+	for _, msg := range msgs {
+		if q.holds(stream, msg.ID) {
+			continue
+		}
+		log.Printf("Reclaimed stale job %s", msg.ID)
+		q.dispatch(ctx, pool, handler, stream, msg)
+	}
+}
+
+func (q *Queue) dispatch(ctx context.Context, pool *workerpool.Pool, handler Handler, stream string, msg redis.XMessage) {
+	job, err := parseJob(msg)
+	if err != nil {
+		log.Printf("Discarding malformed job %s: %v", msg.ID, err)
+		q.deadLetterMessage(context.Background(), stream, msg, err)
+		return
+	}
+
+	q.hold(stream, msg.ID)
+	err = pool.Submit(ctx, func(taskCtx context.Context) {
+		defer q.release(stream, msg.ID)
+
+		// Only clone jobs can be cancelled; a clone may have several
+		// synthesis jobs running at once
+		jobCtx, cancel := context.WithCancel(taskCtx)
+		if job.SynthesisID == 0 {
+			q.mu.Lock()
+			q.running[job.CloneID] = cancel
+			q.mu.Unlock()
+		}
+
+		err := handler(jobCtx, job)
+
+		if job.SynthesisID == 0 {
+			q.mu.Lock()
+			delete(q.running, job.CloneID)
+			q.mu.Unlock()
+		}
+		cancel()
+
+		q.finish(stream, msg, job, err)
+	})
+	if err != nil {
+		// Left pending; it will be reclaimed after claimIdle
+		q.release(stream, msg.ID)
+		log.Printf("Could not schedule job %s: %v", msg.ID, err)
+	}
+}
+
+// finish acknowledges a processed job, re-enqueueing or dead-lettering it on
+// failure. Jobs interrupted by shutdown are left pending for reclaiming.
+func (q *Queue) finish(stream string, msg redis.XMessage, job Job, err error) {
+	ctx := context.Background()
+
+	if err != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+
+	var deferred *deferError
+	if errors.As(err, &deferred) {
+		if deferErr := q.deferJob(ctx, job, deferred.after); deferErr != nil {
+			// Left pending; it will be reclaimed after claimIdle
+			log.Printf("Failed to defer voice clone %d: %v", job.CloneID, deferErr)
+			return
+		}
+		err = nil
+	}
+
+	if err != nil {
+		if job.Attempt+1 < q.maxAttempts {
+			log.Printf("Voice clone %d failed (attempt %d), retrying: %v", job.CloneID, job.Attempt+1, err)
+			retry := job
+			retry.Attempt++
+			if enqueueErr := q.Enqueue(ctx, retry); enqueueErr != nil {
+				log.Printf("Failed to re-enqueue voice clone %d: %v", job.CloneID, enqueueErr)
+				return
+			}
+		} else {
+			log.Printf("Voice clone %d failed after %d attempts: %v", job.CloneID, job.Attempt+1, err)
+			q.deadLetterMessage(ctx, stream, msg, err)
+			if q.OnDeadLetter != nil {
+				q.OnDeadLetter(job, err)
+			}
+			return
+		}
+	}
+
+	if ackErr := q.client.XAck(ctx, stream, q.group, msg.ID).Err(); ackErr != nil {
+		log.Printf("Failed to acknowledge job %s: %v", msg.ID, ackErr)
+	}
+}
+
+func (q *Queue) deadLetterMessage(ctx context.Context, stream string, msg redis.XMessage, cause error) {
+	values := map[string]interface{}{
+		"original_id": msg.ID,
+		"error":       cause.Error(),
+		"failed_at":   time.Now().UTC().Format(time.RFC3339),
+	}
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	if err := q.client.XAdd(ctx, &redis.XAddArgs{Stream: q.deadLetter, Values: values}).Err(); err != nil {
+		log.Printf("Failed to dead-letter job %s: %v", msg.ID, err)
+	}
+	q.client.XAck(ctx, stream, q.group, msg.ID)
+}
+
+// TierDepth is the backlog of one priority tier: jobs no consumer has read
+// yet, and jobs read but not yet acknowledged
+type TierDepth struct {
+	Waiting int64 `json:"waiting"`
+	Pending int64 `json:"pending"`
+}
+
+// Depth is a snapshot of the queue's backlog
+type Depth struct {
+	Tiers        map[string]TierDepth `json:"tiers"`
+	Deferred     int64                `json:"deferred"`
+	DeadLettered int64                `json:"dead_lettered"`
+}
+
+// Depth reports how many jobs wait in each tier, how many are held by
+// consumers, and the deferred and dead-lettered counts
+func (q *Queue) Depth(ctx context.Context) (Depth, error) {
+	depth := Depth{Tiers: map[string]TierDepth{}}
+	for tier, stream := range q.streams {
+		groups, err := q.client.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			return depth, fmt.Errorf("%s: %w", stream, err)
+		}
+		for _, group := range groups {
+			if group.Name == q.group {
+				depth.Tiers[tier] = TierDepth{Waiting: group.Lag, Pending: group.Pending}
+			}
+		}
+	}
+
+	var err error
+	if depth.Deferred, err = q.client.ZCard(ctx, q.deferred).Result(); err != nil {
+		return depth, fmt.Errorf("%s: %w", q.deferred, err)
+	}
+	if depth.DeadLettered, err = q.client.XLen(ctx, q.deadLetter).Result(); err != nil {
+		return depth, fmt.Errorf("%s: %w", q.deadLetter, err)
+	}
+	return depth, nil
+}
+
+// Ping checks the queue's Redis connection
+func (q *Queue) Ping(ctx context.Context) error {
+	return q.client.Ping(ctx).Err()
+}
+
+// Close releases the Redis connection
+func (q *Queue) Close() error {
+	return q.client.Close()
+}
+
+func parseJob(msg redis.XMessage) (Job, error) {
+	var job Job
+	cloneID, err := strconv.Atoi(fmt.Sprint(msg.Values["clone_id"]))
+	if err != nil {
+		return job, fmt.Errorf("invalid clone_id")
+	}
+	job.CloneID = cloneID
+	job.Attempt, _ = strconv.Atoi(fmt.Sprint(msg.Values["attempt"]))
+	if priority, ok := msg.Values["priority"].(string); ok {
+		job.Priority = priority
+	}
+	if id, ok := msg.Values["synthesis_id"]; ok {
+		if job.SynthesisID, err = strconv.Atoi(fmt.Sprint(id)); err != nil {
+			return job, fmt.Errorf("invalid synthesis_id")
+		}
+	}
+	if v, ok := msg.Values["model_version"]; ok {
+		if job.ModelVersion, err = strconv.Atoi(fmt.Sprint(v)); err != nil {
+			return job, fmt.Errorf("invalid model_version")
+		}
+	}
+	return job, nil
+}
@@ -0,0 +1,119 @@
+// Package locale holds users' timezone and locale, kept on their profile,
+// and formats times for them. The user service manages profiles; services
+// writing times for users, such as in emails and notices, read them too.
+package locale
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Defaults of users who haven't set theirs
+const (
+	DefaultTimezone = "UTC"
+	DefaultLocale   = "en"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// ValidTimezone reports whether name is an IANA time zone such as
+// Europe/Paris
+func ValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// ValidLocale reports whether tag is a BCP 47 tag such as en or pt-BR
+func ValidLocale(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// Settings are a user's timezone and locale
+type Settings struct {
+	Timezone string `json:"timezone" db:"timezone"`
+	Locale   string `json:"locale" db:"locale"`
+}
+
+// ForUser reads a user's settings, the defaults if they have no profile
+func ForUser(ctx context.Context, db sqlx.QueryerContext, userID int) (Settings, error) {
+	settings := Settings{Timezone: DefaultTimezone, Locale: DefaultLocale}
+	err := sqlx.GetContext(ctx, db, &settings,
+		"SELECT COALESCE(timezone, $2) AS timezone, COALESCE(locale, $3) AS locale FROM user_profiles WHERE user_id = $1",
+		userID, DefaultTimezone, DefaultLocale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	return settings, err
+}
+
+// Location is the user's time zone, UTC if it can't be loaded
+func (s Settings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil || s.Timezone == "Local" {
+		return time.UTC
+	}
+	return loc
+}
+
+// Layouts by locale. Month names are English, so other languages get
+// numeric dates.
+var (
+	usLayouts      = [2]string{"January 2, 2006", "January 2, 2006 at 3:04 PM MST"}
+	englishLayouts = [2]string{"2 January 2006", "2 January 2006 at 15:04 MST"}
+	numericLayouts = [2]string{"2006-01-02", "2006-01-02 15:04 MST"}
+)
+
+func (s Settings) layouts() [2]string {
+	tag := strings.ToLower(s.Locale)
+	switch {
+	case tag == "en" || tag == "en-us":
+		return usLayouts
+	case strings.HasPrefix(tag, "en-"):
+		return englishLayouts
+	}
+	return numericLayouts
+}
+
+// FormatDate writes the day of t in the user's time zone
+func (s Settings) FormatDate(t time.Time) string {
+	return t.In(s.Location()).Format(s.layouts()[0])
+}
+
+// FormatTime writes t in the user's time zone, with the zone's abbreviation
+func (s Settings) FormatTime(t time.Time) string {
+	return t.In(s.Location()).Format(s.layouts()[1])
+}
+
+// QuietUntil reports whether now falls in quiet hours from start to end,
+// times of day as HH:MM in loc, and when those end. Quiet hours may span
+// midnight; they are off unless both ends are set and differ.
+func QuietUntil(start, end string, loc *time.Location, now time.Time) (time.Time, bool) {
+	if start == "" || end == "" || start == end {
+		return time.Time{}, false
+	}
+	local := now.In(loc)
+	clock := local.Format("15:04")
+	quiet := (start < end && clock >= start && clock < end) || (start > end && (clock >= start || clock < end))
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	var hour, minute int
+	fmt.Sscanf(end, "%d:%d", &hour, &minute)
+	until := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !until.After(local) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, true
+}
@@ -0,0 +1,73 @@
+// Package logging sets up the services' structured logs: JSON records on
+// stderr, each carrying the service name, at the level configured by
+// LOG_LEVEL. Handlers log through the request's logger, which adds the
+// request ID and, once known, the calling user's ID.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/voice-cloning/shared/utils"
+)
+
+type contextKey struct{}
+
+// Setup makes a JSON logger the default at the given level (debug, info,
+// warn or error). Records of the standard log package go through it too,
+// at info level.
+func Setup(service, level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})
+	slog.SetDefault(slog.New(handler).With("service", service))
+	return nil
+}
+
+// ParseLevel parses a level name; an empty one is info
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+// FromContext returns the logger of a request, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns a context whose logger adds the given fields
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).With(args...))
+}
+
+// Middleware gives each request a logger with its request ID and the user
+// ID the gateway forwarded, if any. It goes inside middleware.RequestID so
+// every request has an ID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := []any{"request_id", r.Header.Get(utils.RequestIDHeader)}
+		if userID, err := strconv.Atoi(r.Header.Get("X-User-ID")); err == nil && userID > 0 {
+			args = append(args, "user_id", userID)
+		}
+		next.ServeHTTP(w, r.WithContext(With(r.Context(), args...)))
+	})
+}
@@ -0,0 +1,83 @@
+package mail
+
+var builtinBranding = Branding{
+	Name:         "Voice Cloning",
+	PrimaryColor: "#4f46e5",
+	SupportEmail: "support@voice-cloning.local",
+}
+
+const htmlLayoutStart = `<div style="font-family:sans-serif;max-width:560px;margin:0 auto">
+{{if .Brand.LogoURL}}<img src="{{.Brand.LogoURL}}" alt="{{.Brand.Name}}" height="32">{{else}}<h2>{{.Brand.Name}}</h2>{{end}}
+`
+
+const htmlLayoutEnd = `<p style="color:#6b7280;font-size:12px">Questions? Contact {{.Brand.SupportEmail}}.</p>
+</div>`
+
+func actionButton(label string) string {
+	return `<p><a href="{{.ActionURL}}" style="background:{{.Brand.PrimaryColor}};color:#fff;padding:10px 16px;border-radius:4px;text-decoration:none">` + label + `</a></p>
+`
+}
+
+// builtinTemplates are used for any part not overridden
+var builtinTemplates = map[string]Template{
+	KindVerification: {
+		Subject: `Verify your email for {{.Brand.Name}}`,
+		Text: `Hi {{.Recipient.Name}},
+
+Confirm your email address by opening the link below:
+
+{{.ActionURL}}
+
+The link expires at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+<p>Confirm your email address to finish setting up your account.</p>
+` + actionButton("Verify email") + `<p>The link expires at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}.</p>
+` + htmlLayoutEnd,
+	},
+	KindPasswordReset: {
+		Subject: `Reset your {{.Brand.Name}} password`,
+		Text: `Hi {{.Recipient.Name}},
+
+Someone asked to reset your password. If it was you, open the link below:
+
+{{.ActionURL}}
+
+The link expires at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}. If you did not ask for a reset, ignore this email.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+<p>Someone asked to reset your password. If it was you, use the button below.</p>
+` + actionButton("Reset password") + `<p>The link expires at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}. If you did not ask for a reset, ignore this email.</p>
+` + htmlLayoutEnd,
+	},
+	KindMagicLink: {
+		Subject: `Your {{.Brand.Name}} sign-in link`,
+		Text: `Hi {{.Recipient.Name}},
+
+Sign in with the link below:
+
+{{.ActionURL}}
+
+Or enter the code {{.Code}}. Both expire at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+` + actionButton("Sign in") + `<p>Or enter the code <strong>{{.Code}}</strong>. Both expire at {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}.</p>
+` + htmlLayoutEnd,
+	},
+	KindSecurityAlert: {
+		Subject: `Security alert for your {{.Brand.Name}} account`,
+		Text: `Hi {{.Recipient.Name}},
+
+{{.Event.Description}} on {{.Event.OccurredAt.Format "2006-01-02 15:04 MST"}}
+IP address: {{.Event.IPAddress}}
+Device: {{.Event.UserAgent}}
+
+If this was not you, reset your password and contact {{.Brand.SupportEmail}}.
+`,
+		HTML: htmlLayoutStart + `<p>Hi {{.Recipient.Name}},</p>
+<p><strong>{{.Event.Description}}</strong> on {{.Event.OccurredAt.Format "2006-01-02 15:04 MST"}}</p>
+<p>IP address: {{.Event.IPAddress}}<br>Device: {{.Event.UserAgent}}</p>
+<p>If this was not you, reset your password right away.</p>
+` + htmlLayoutEnd,
+	},
+}
@@ -0,0 +1,108 @@
+// Package mail sends transactional emails and renders them from templates
+// that deployments and orgs can override.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+)
+
+// Message is a rendered email. HTML is optional.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Config selects the mail provider and the templates' overrides. Services
+// hold it in a Mail field of their own Config.
+type Config struct {
+	// Provider is smtp, the default, or sendgrid
+	Provider string `env:"MAIL_PROVIDER" default:"smtp"`
+	// From is the sender address of both providers
+	From         string `env:"SMTP_FROM" default:"no-reply@voice-cloning.local"`
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     string `env:"SMTP_PORT" default:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true"`
+	SendGridKey  string `env:"SENDGRID_API_KEY" secret:"true"`
+	// TemplateDir holds deployment overrides of the built-in templates
+	TemplateDir       string `env:"EMAIL_TEMPLATE_DIR"`
+	BrandName         string `env:"BRAND_NAME"`
+	BrandLogoURL      string `env:"BRAND_LOGO_URL"`
+	BrandPrimaryColor string `env:"BRAND_PRIMARY_COLOR"`
+	BrandSupportEmail string `env:"BRAND_SUPPORT_EMAIL"`
+}
+
+// Mailer sends transactional emails over SMTP. When no SMTP host is
+// configured messages are logged instead, which keeps local setups working
+// without a mail server.
+type Mailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewMailer(cfg Config) *Mailer {
+	return &Mailer{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.From,
+	}
+}
+
+// Send delivers a plain-text email to a single recipient
+func (m *Mailer) Send(to, subject, body string) error {
+	return m.SendMessage(Message{To: to, Subject: subject, Text: body})
+}
+
+// SendMessage delivers a rendered message, as multipart/alternative when it
+// has an HTML part
+func (m *Mailer) SendMessage(msg Message) error {
+	if m.host == "" {
+		log.Printf("SMTP not configured, skipping email to %s: %s", msg.To, msg.Subject)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", m.from, msg.To, msg.Subject)
+	if msg.HTML == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n\r\n%s", msg.Text)
+	} else {
+		writer := multipart.NewWriter(&buf)
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", msg.Text},
+			{"text/html; charset=UTF-8", msg.HTML},
+		} {
+			w, err := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return err
+			}
+			qp := quotedprintable.NewWriter(w)
+			qp.Write([]byte(part.body))
+			qp.Close()
+		}
+		writer.Close()
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	return smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{msg.To}, buf.Bytes())
+}
@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Mail providers selected with MAIL_PROVIDER
+const (
+	ProviderSMTP     = "smtp"
+	ProviderSendGrid = "sendgrid"
+)
+
+// sendGridURL is SendGrid's v3 send endpoint
+const sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// Sender delivers rendered messages
+type Sender interface {
+	SendMessage(msg Message) error
+}
+
+// NewSender returns the configured sender: the SMTP Mailer by default, or
+// SendGrid's API with SENDGRID_API_KEY
+func NewSender(cfg Config) (Sender, error) {
+	switch provider := cfg.Provider; provider {
+	case "", ProviderSMTP:
+		return NewMailer(cfg), nil
+	case ProviderSendGrid:
+		return NewSendGrid(cfg)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q", provider)
+	}
+}
+
+// SendGrid sends transactional emails through SendGrid's v3 API
+type SendGrid struct {
+	apiKey string
+	from   string
+	client *http.Client
+}
+
+// NewSendGrid sends with the API key in SENDGRID_API_KEY, from the
+// Mailer's sender address
+func NewSendGrid(cfg Config) (*SendGrid, error) {
+	if cfg.SendGridKey == "" {
+		return nil, fmt.Errorf("SENDGRID_API_KEY is required with MAIL_PROVIDER=%s", ProviderSendGrid)
+	}
+	return &SendGrid{
+		apiKey: cfg.SendGridKey,
+		from:   cfg.From,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// SendMessage delivers a rendered message, with its HTML part when it has
+// one
+func (s *SendGrid) SendMessage(msg Message) error {
+	content := []sendGridContent{{Type: "text/plain", Value: msg.Text}}
+	if msg.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": []sendGridAddress{{Email: msg.To}}}},
+		"from":             sendGridAddress{Email: s.from},
+		"subject":          msg.Subject,
+		"content":          content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sendGridURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("sendgrid returned %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Kinds of templated emails
+const (
+	KindVerification  = "verification"
+	KindPasswordReset = "password_reset"
+	KindMagicLink     = "magic_link"
+	KindSecurityAlert = "security_alert"
+)
+
+// Kinds lists every templated email
+var Kinds = []string{KindVerification, KindPasswordReset, KindMagicLink, KindSecurityAlert}
+
+// MaxTemplateSize bounds each part of an override
+const MaxTemplateSize = 64 << 10
+
+var ErrUnknownKind = errors.New("mail: unknown template kind")
+
+// Template is the source of an email. Empty parts of an override inherit
+// from the layer below.
+type Template struct {
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Over returns t with the non-empty parts of override applied
+func (t Template) Over(override Template) Template {
+	if override.Subject != "" {
+		t.Subject = override.Subject
+	}
+	if override.Text != "" {
+		t.Text = override.Text
+	}
+	if override.HTML != "" {
+		t.HTML = override.HTML
+	}
+	return t
+}
+
+// Branding is interpolated into every template as .Brand
+type Branding struct {
+	Name         string `json:"name,omitempty" db:"name"`
+	LogoURL      string `json:"logo_url,omitempty" db:"logo_url"`
+	PrimaryColor string `json:"primary_color,omitempty" db:"primary_color"`
+	SupportEmail string `json:"support_email,omitempty" db:"support_email"`
+}
+
+func (b Branding) over(override Branding) Branding {
+	if override.Name != "" {
+		b.Name = override.Name
+	}
+	if override.LogoURL != "" {
+		b.LogoURL = override.LogoURL
+	}
+	if override.PrimaryColor != "" {
+		b.PrimaryColor = override.PrimaryColor
+	}
+	if override.SupportEmail != "" {
+		b.SupportEmail = override.SupportEmail
+	}
+	return b
+}
+
+// Data is everything a template can reference. Templates only see these
+// fields, and HTML output is escaped contextually.
+type Data struct {
+	Brand     Branding
+	Recipient Recipient
+	ActionURL string
+	Code      string
+	ExpiresAt time.Time
+	Event     SecurityEvent
+	// Details are the specifics of a notification, such as the name of
+	// the clone it is about
+	Details map[string]interface{}
+}
+
+// Recipient identifies who an email is addressed to
+type Recipient struct {
+	Email string
+	Name  string
+}
+
+// SecurityEvent describes what triggered a security alert
+type SecurityEvent struct {
+	Description string
+	IPAddress   string
+	UserAgent   string
+	OccurredAt  time.Time
+}
+
+// SampleData is used for previews and to validate overrides before they are
+// saved
+func SampleData() Data {
+	now := time.Now().UTC()
+	return Data{
+		Recipient: Recipient{Email: "jane@example.com", Name: "Jane"},
+		ActionURL: "https://example.com/action?token=sample",
+		Code:      "123456",
+		ExpiresAt: now.Add(time.Hour),
+		Event: SecurityEvent{
+			Description: "New sign-in",
+			IPAddress:   "203.0.113.7",
+			UserAgent:   "Mozilla/5.0",
+			OccurredAt:  now,
+		},
+	}
+}
+
+// Renderer resolves templates through the built-in, deployment and org
+// layers and renders them
+type Renderer struct {
+	templates map[string]Template
+	brand     Branding
+}
+
+// NewRenderer loads deployment overrides from EMAIL_TEMPLATE_DIR, where
+// <kind>.subject.tmpl, <kind>.txt.tmpl and <kind>.html.tmpl replace the
+// corresponding built-in part, and branding from BRAND_* variables.
+func NewRenderer(cfg Config) (*Renderer, error) {
+	r := &Renderer{
+		templates: map[string]Template{},
+		brand: builtinBranding.over(Branding{
+			Name:         cfg.BrandName,
+			LogoURL:      cfg.BrandLogoURL,
+			PrimaryColor: cfg.BrandPrimaryColor,
+			SupportEmail: cfg.BrandSupportEmail,
+		}),
+	}
+
+	dir := cfg.TemplateDir
+	for _, kind := range Kinds {
+		t := builtinTemplates[kind]
+		if dir != "" {
+			override, err := readTemplateDir(dir, kind)
+			if err != nil {
+				return nil, err
+			}
+			if err := Validate(override); err != nil {
+				return nil, fmt.Errorf("mail: %s template in %s: %w", kind, dir, err)
+			}
+			t = t.Over(override)
+		}
+		r.templates[kind] = t
+	}
+	return r, nil
+}
+
+func readTemplateDir(dir, kind string) (Template, error) {
+	var t Template
+	for suffix, part := range map[string]*string{"subject": &t.Subject, "txt": &t.Text, "html": &t.HTML} {
+		data, err := os.ReadFile(filepath.Join(dir, kind+"."+suffix+".tmpl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return t, err
+		}
+		*part = string(data)
+	}
+	return t, nil
+}
+
+// Resolve returns the deployment template of a kind with an org override
+// applied
+func (r *Renderer) Resolve(kind string, org Template) (Template, error) {
+	t, ok := r.templates[kind]
+	if !ok {
+		return Template{}, ErrUnknownKind
+	}
+	return t.Over(org), nil
+}
+
+// Brand returns the deployment branding with an org override applied
+func (r *Renderer) Brand(org Branding) Branding {
+	return r.brand.over(org)
+}
+
+// Render executes a template. The subject is collapsed to one line so
+// interpolated values cannot inject headers.
+func Render(t Template, data Data) (Message, error) {
+	var msg Message
+
+	subject, err := executeText(t.Subject, data)
+	if err != nil {
+		return msg, fmt.Errorf("subject: %w", err)
+	}
+	msg.Subject = strings.Join(strings.Fields(subject), " ")
+
+	if msg.Text, err = executeText(t.Text, data); err != nil {
+		return msg, fmt.Errorf("text: %w", err)
+	}
+
+	if t.HTML != "" {
+		tmpl, err := htmltemplate.New("html").Parse(t.HTML)
+		if err != nil {
+			return msg, fmt.Errorf("html: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return msg, fmt.Errorf("html: %w", err)
+		}
+		msg.HTML = buf.String()
+	}
+
+	msg.To = data.Recipient.Email
+	return msg, nil
+}
+
+func executeText(source string, data Data) (string, error) {
+	tmpl, err := texttemplate.New("text").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Validate checks that an override is within size limits and renders
+// against sample data, which rejects references to unknown fields
+func Validate(t Template) error {
+	for _, part := range []string{t.Subject, t.Text, t.HTML} {
+		if len(part) > MaxTemplateSize {
+			return fmt.Errorf("template parts must be at most %d bytes", MaxTemplateSize)
+		}
+	}
+	_, err := Render(t, SampleData())
+	return err
+}
@@ -0,0 +1,143 @@
+// Package manifest builds and signs reproducibility manifests for completed
+// clone jobs. A manifest records everything needed to audit how a model was
+// produced; the Ed25519 signature lets anyone holding the public key confirm
+// it was issued by a worker and not edited afterwards.
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Algorithm is the signature algorithm recorded alongside each manifest
+const Algorithm = "ed25519"
+
+// SchemaVersion is bumped whenever the manifest layout changes
+const SchemaVersion = 1
+
+var ErrInvalidSignature = errors.New("manifest: invalid signature")
+
+// File identifies an input or output of a job by content
+type File struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Manifest describes how a clone's model was produced
+type Manifest struct {
+	SchemaVersion      int                    `json:"schema_version"`
+	CloneID            int                    `json:"clone_id"`
+	ModelVersion       string                 `json:"model_version"`
+	Parameters         map[string]interface{} `json:"parameters"`
+	Samples            []File                 `json:"samples"`
+	PreprocessingChain []string               `json:"preprocessing_chain"`
+	Outputs            []File                 `json:"outputs"`
+	WorkerImage        string                 `json:"worker_image"`
+	GeneratedAt        time.Time              `json:"generated_at"`
+}
+
+// Signed is a manifest together with its detached signature. Payload holds
+// the exact bytes that were signed and must be stored verbatim.
+type Signed struct {
+	Payload   json.RawMessage `json:"manifest"`
+	Algorithm string          `json:"algorithm"`
+	KeyID     string          `json:"key_id"`
+	PublicKey string          `json:"public_key"`
+	Signature string          `json:"signature"`
+}
+
+// Signer signs manifests with an Ed25519 key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner creates a signer from a 32 byte Ed25519 seed
+func NewSigner(seed []byte) (*Signer, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("manifest: signing key must be a %d byte seed", ed25519.SeedSize)
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// SignerFromEnv reads a base64 seed from MANIFEST_SIGNING_KEY. Without one an
+// ephemeral key is generated, which signs correctly but changes on restart.
+func SignerFromEnv() (*Signer, error) {
+	if encoded := os.Getenv("MANIFEST_SIGNING_KEY"); encoded != "" {
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: invalid MANIFEST_SIGNING_KEY: %w", err)
+		}
+		return NewSigner(seed)
+	}
+
+	log.Println("MANIFEST_SIGNING_KEY not set, signing manifests with an ephemeral key")
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return NewSigner(seed)
+}
+
+// KeyID derives a short stable identifier for a public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign serializes and signs a manifest
+func (s *Signer) Sign(m Manifest) (*Signed, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &Signed{
+		Payload:   payload,
+		Algorithm: Algorithm,
+		KeyID:     s.keyID,
+		PublicKey: base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload)),
+	}, nil
+}
+
+// Verify checks a signed manifest against the public key it carries. Callers
+// auditing a manifest should also check KeyID against a key they trust.
+func Verify(signed *Signed) error {
+	pub, err := base64.StdEncoding.DecodeString(signed.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize || signed.Algorithm != Algorithm {
+		return ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), signed.Payload, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Digest hashes a local file for inclusion in a manifest
+func Digest(name, path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, SHA256: hex.EncodeToString(h.Sum(nil)), Bytes: n}, nil
+}
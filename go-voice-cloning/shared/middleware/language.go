@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/i18n"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	// userLocaleTTL is how long a user's locale preference is cached
+	userLocaleTTL = time.Minute
+	// maxCachedLocales bounds the cache; expired entries are dropped past it
+	maxCachedLocales = 10000
+)
+
+// Language sets Content-Language to the supported language the request's
+// Accept-Language prefers most. Error responses are written in it.
+func Language(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lang, ok := i18n.Negotiate(r.Header.Get("Accept-Language")); ok {
+			w.Header().Set(utils.ContentLanguageHeader, lang)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UserLanguage falls back to the caller's locale preference, kept on their
+// profile, for requests whose Accept-Language names no supported language.
+// Preferences are cached for a minute.
+func UserLanguage(db *sqlx.DB) func(http.Handler) http.Handler {
+	locales := &userLocales{db: db, cache: map[int]cachedLocale{}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if userID := UserID(r); userID != 0 && w.Header().Get(utils.ContentLanguageHeader) == "" {
+				if lang, ok := i18n.Match(locales.get(r.Context(), userID)); ok {
+					w.Header().Set(utils.ContentLanguageHeader, lang)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type userLocales struct {
+	db *sqlx.DB
+
+	mu    sync.Mutex
+	cache map[int]cachedLocale
+}
+
+type cachedLocale struct {
+	locale    string
+	fetchedAt time.Time
+}
+
+// get returns a user's locale, or "" when it can't be read
+func (l *userLocales) get(ctx context.Context, userID int) string {
+	l.mu.Lock()
+	entry, ok := l.cache[userID]
+	l.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < userLocaleTTL {
+		return entry.locale
+	}
+
+	settings, err := locale.ForUser(ctx, l.db, userID)
+	if err != nil {
+		return entry.locale
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.cache) >= maxCachedLocales {
+		for id, e := range l.cache {
+			if time.Since(e.fetchedAt) >= userLocaleTTL {
+				delete(l.cache, id)
+			}
+		}
+	}
+	l.cache[userID] = cachedLocale{locale: settings.Locale, fetchedAt: time.Now()}
+	return settings.Locale
+}
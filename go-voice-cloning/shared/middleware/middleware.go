@@ -0,0 +1,173 @@
+// Package middleware holds the HTTP middleware and request helpers the
+// services share. Services behind the gateway serve their router through
+// Chain; the gateway, which records its own access log with each request's
+// trace, uses RequestID and Recover directly.
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Headers the gateway sets on proxied requests once it has authenticated
+// the caller. It strips them from incoming requests, so services can trust
+// them.
+const (
+	UserIDHeader   = "X-User-ID"
+	UserRoleHeader = "X-User-Role"
+)
+
+// UserID returns the ID of the authenticated caller, or 0
+func UserID(r *http.Request) int {
+	id, err := strconv.Atoi(r.Header.Get(UserIDHeader))
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// IsAdmin reports whether the authenticated caller is an admin
+func IsAdmin(r *http.Request) bool {
+	return r.Header.Get(UserRoleHeader) == types.RoleAdmin
+}
+
+// Chain wraps a service's router in the shared middleware: request ID,
+// request-scoped logger, access log, panic recovery and language,
+// outermost first
+func Chain(next http.Handler) http.Handler {
+	return RequestID(logging.Middleware(AccessLog(Recover(Language(next)))))
+}
+
+// RequestID reuses a well-formed incoming request ID or generates a new one,
+// and echoes it on the response so errors can be correlated
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(utils.RequestIDHeader)
+		if !utils.ValidRequestID(requestID) {
+			requestID = utils.NewRequestID()
+			r.Header.Set(utils.RequestIDHeader, requestID)
+		}
+		w.Header().Set(utils.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Recover turns a panicking handler into a 500 response, logging the panic
+// with its stack, so one bad request doesn't take the connection down with
+// no answer. A handler that panics after starting its response is aborted
+// instead, so the client sees a broken response rather than a truncated one
+// that looks complete. Aborted handlers (http.ErrAbortHandler) still abort.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := wrap(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			logging.FromContext(r.Context()).Error("Panic serving request", "method", r.Method, "path", r.URL.Path,
+				"panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+			if rec.hijacked {
+				return
+			}
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			utils.ErrorResponse(rec, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// AccessLog logs each request once it completes, with its status, size and
+// duration. Health checks are logged at debug level.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := wrap(w)
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if strings.HasPrefix(r.URL.Path, "/health") {
+			level = slog.LevelDebug
+		}
+		logging.FromContext(r.Context()).Log(r.Context(), level, "Request completed", "method", r.Method,
+			"path", r.URL.Path, "status", rec.status, "bytes", rec.bytes, "duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+// recorder captures the status and size of a response
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+// wrap returns w as a recorder, reusing it if it already is one
+func wrap(w http.ResponseWriter) *recorder {
+	if rec, ok := w.(*recorder); ok {
+		return rec
+	}
+	return &recorder{ResponseWriter: w}
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses, such as server-sent events, reach the
+// client as they are written
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.hijacked = true
+	if rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
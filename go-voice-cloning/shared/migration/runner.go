@@ -0,0 +1,291 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Migration describes how to copy and check rows between the old and new
+// shapes. Rows are visited in key order; a cursor is the last key handled,
+// encoded as a string ("" before the first row).
+type Migration struct {
+	Name string
+	// BatchSize is the number of rows per backfill or verify batch
+	// (default 500)
+	BatchSize int
+	// Pause between batches keeps the backfill from starving live traffic
+	Pause time.Duration
+	// Count returns the number of rows to migrate, for progress reporting
+	Count func(ctx context.Context, db *sqlx.DB) (int64, error)
+	// Backfill copies up to limit rows after cursor into the new shape. It
+	// must be idempotent, as rows written by dual writes are copied again.
+	// It returns the cursor of the last row and the number of rows copied;
+	// zero rows ends the backfill.
+	Backfill func(ctx context.Context, tx *sqlx.Tx, cursor string, limit int) (next string, n int, err error)
+	// Verify compares up to limit rows after cursor between the shapes and
+	// returns the cursor of the last row, the number checked and the keys of
+	// rows that differ
+	Verify func(ctx context.Context, db *sqlx.DB, cursor string, limit int) (next string, n int, mismatches []string, err error)
+}
+
+// maxMismatchKeys bounds the mismatching keys kept for inspection
+const maxMismatchKeys = 100
+
+// Progress is the recorded state of a migration
+type Progress struct {
+	Name         string         `json:"name" db:"name"`
+	Phase        Phase          `json:"phase" db:"phase"`
+	Cursor       string         `json:"-" db:"backfill_cursor"`
+	Backfilled   int64          `json:"backfilled" db:"backfilled"`
+	Total        int64          `json:"total" db:"total"`
+	BackfilledAt *time.Time     `json:"backfilled_at,omitempty" db:"backfilled_at"`
+	Verified     int64          `json:"verified" db:"verified"`
+	Mismatches   int64          `json:"mismatches" db:"mismatches"`
+	MismatchKeys pq.StringArray `json:"mismatch_keys,omitempty" db:"mismatch_keys"`
+	VerifiedAt   *time.Time     `json:"verified_at,omitempty" db:"verified_at"`
+	LastError    string         `json:"last_error,omitempty" db:"last_error"`
+	StartedAt    time.Time      `json:"started_at" db:"started_at"`
+	UpdatedAt    time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// Percent is the share of rows backfilled
+func (p Progress) Percent() float64 {
+	if p.BackfilledAt != nil {
+		return 100
+	}
+	if p.Total <= 0 {
+		return 0
+	}
+	pct := float64(p.Backfilled) * 100 / float64(p.Total)
+	if pct > 99.9 {
+		pct = 99.9
+	}
+	return pct
+}
+
+// Clean reports whether the backfill finished and the last verification
+// found no differences
+func (p Progress) Clean() bool {
+	return p.BackfilledAt != nil && p.VerifiedAt != nil && p.Mismatches == 0
+}
+
+// Runner backfills and verifies migrations, recording progress in the
+// schema_rollouts table so an interrupted backfill resumes where it stopped
+type Runner struct {
+	db *sqlx.DB
+}
+
+// NewRunner creates the progress table if needed
+func NewRunner(db *sqlx.DB) (*Runner, error) {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_rollouts (
+		name VARCHAR(100) PRIMARY KEY,
+		phase VARCHAR(20) NOT NULL,
+		backfill_cursor TEXT NOT NULL DEFAULT '',
+		backfilled BIGINT NOT NULL DEFAULT 0,
+		total BIGINT NOT NULL DEFAULT 0,
+		backfilled_at TIMESTAMP,
+		verified BIGINT NOT NULL DEFAULT 0,
+		mismatches BIGINT NOT NULL DEFAULT 0,
+		mismatch_keys TEXT[],
+		verified_at TIMESTAMP,
+		last_error TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_rollouts: %w", err)
+	}
+	return &Runner{db: db}, nil
+}
+
+// Progress returns the recorded state of a migration
+func (r *Runner) Progress(ctx context.Context, name string) (*Progress, error) {
+	var p Progress
+	err := r.db.GetContext(ctx, &p, "SELECT * FROM schema_rollouts WHERE name = $1", name)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// List returns the state of every migration
+func (r *Runner) List(ctx context.Context) ([]Progress, error) {
+	progress := []Progress{}
+	err := r.db.SelectContext(ctx, &progress, "SELECT * FROM schema_rollouts ORDER BY started_at")
+	return progress, err
+}
+
+// Phase returns the phase to run the migration in. Reads only move to the
+// new shape once a backfill has been verified clean; until then a requested
+// read phase is held back to PhaseDualWrite.
+func (r *Runner) Phase(ctx context.Context, m *Migration, requested Phase) Phase {
+	effective := requested
+	if requested.ReadsNew() {
+		p, err := r.Progress(ctx, m.Name)
+		if err != nil || !p.Clean() {
+			log.Printf("Migration %s: holding %s until the backfill is verified clean", m.Name, requested)
+			effective = PhaseDualWrite
+		}
+	}
+	r.db.ExecContext(ctx,
+		`INSERT INTO schema_rollouts (name, phase) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET phase = EXCLUDED.phase, updated_at = NOW()`,
+		m.Name, effective)
+	return effective
+}
+
+// Backfill copies rows into the new shape until none are left, resuming
+// from the recorded cursor. Run it once the phase writes the new shape, so
+// rows changed during the backfill are kept in step by dual writes.
+func (r *Runner) Backfill(ctx context.Context, m *Migration) error {
+	if _, err := r.db.ExecContext(ctx,
+		"INSERT INTO schema_rollouts (name, phase) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING",
+		m.Name, PhaseDualWrite); err != nil {
+		return err
+	}
+	if m.Count != nil {
+		total, err := m.Count(ctx, r.db)
+		if err != nil {
+			return r.fail(m, fmt.Errorf("count failed: %w", err))
+		}
+		r.db.ExecContext(ctx, "UPDATE schema_rollouts SET total = $1 WHERE name = $2", total, m.Name)
+	}
+
+	for {
+		done, err := r.backfillBatch(ctx, m)
+		if err != nil {
+			return r.fail(m, err)
+		}
+		if done {
+			log.Printf("Migration %s: backfill complete", m.Name)
+			return nil
+		}
+		if err := sleep(ctx, m.Pause); err != nil {
+			return err
+		}
+	}
+}
+
+// backfillBatch copies one batch. The progress row is locked for the batch
+// so concurrent runners never copy the same rows.
+func (r *Runner) backfillBatch(ctx context.Context, m *Migration) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var p Progress
+	if err := tx.GetContext(ctx, &p, "SELECT * FROM schema_rollouts WHERE name = $1 FOR UPDATE", m.Name); err != nil {
+		return false, err
+	}
+	if p.BackfilledAt != nil {
+		return true, nil
+	}
+
+	next, n, err := m.Backfill(ctx, tx, p.Cursor, batchSize(m))
+	if err != nil {
+		return false, fmt.Errorf("backfill after %q failed: %w", p.Cursor, err)
+	}
+	if n == 0 {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE schema_rollouts SET backfilled_at = NOW(), last_error = '', updated_at = NOW() WHERE name = $1", m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE schema_rollouts SET backfill_cursor = $1, backfilled = backfilled + $2, last_error = '', updated_at = NOW() WHERE name = $3",
+			next, n, m.Name)
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == 0, tx.Commit()
+}
+
+// Verify compares every row between the shapes and records the number of
+// differences. It returns the final progress; check Clean before switching
+// reads.
+func (r *Runner) Verify(ctx context.Context, m *Migration) (*Progress, error) {
+	var (
+		cursor     string
+		checked    int64
+		mismatches int64
+		keys       []string
+	)
+	for {
+		next, n, diff, err := m.Verify(ctx, r.db, cursor, batchSize(m))
+		if err != nil {
+			return nil, r.fail(m, fmt.Errorf("verify after %q failed: %w", cursor, err))
+		}
+		if n == 0 {
+			break
+		}
+		cursor = next
+		checked += int64(n)
+		mismatches += int64(len(diff))
+		for _, key := range diff {
+			if len(keys) < maxMismatchKeys {
+				keys = append(keys, key)
+			}
+		}
+		r.db.ExecContext(ctx, "UPDATE schema_rollouts SET verified = $1, updated_at = NOW() WHERE name = $2", checked, m.Name)
+		if err := sleep(ctx, m.Pause); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE schema_rollouts SET verified = $1, mismatches = $2, mismatch_keys = $3, verified_at = NOW(),
+			last_error = '', updated_at = NOW()
+		WHERE name = $4`,
+		checked, mismatches, pq.StringArray(keys), m.Name)
+	if err != nil {
+		return nil, err
+	}
+	if mismatches > 0 {
+		log.Printf("Migration %s: %d of %d rows differ", m.Name, mismatches, checked)
+	}
+	return r.Progress(ctx, m.Name)
+}
+
+// Reset discards recorded progress so the next backfill starts over
+func (r *Runner) Reset(ctx context.Context, name string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE schema_rollouts SET backfill_cursor = '', backfilled = 0, backfilled_at = NULL, verified = 0, mismatches = 0,
+			mismatch_keys = NULL, verified_at = NULL, last_error = '', started_at = NOW(), updated_at = NOW()
+		WHERE name = $1`,
+		name)
+	return err
+}
+
+func (r *Runner) fail(m *Migration, err error) error {
+	r.db.Exec("UPDATE schema_rollouts SET last_error = $1, updated_at = NOW() WHERE name = $2", err.Error(), m.Name)
+	log.Printf("Migration %s: %v", m.Name, err)
+	return err
+}
+
+func batchSize(m *Migration) int {
+	if m.BatchSize > 0 {
+		return m.BatchSize
+	}
+	return 500
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
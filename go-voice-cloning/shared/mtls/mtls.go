@@ -0,0 +1,281 @@
+// Package mtls secures the calls between the services with mutual TLS. A
+// service serves HTTPS with its certificate and, with MTLS_REQUIRE, only
+// answers callers presenting a certificate issued by the internal CA, and
+// only those named in MTLS_ALLOWED_CLIENTS when it is set, so endpoints
+// that trust the gateway's X-User-ID header can't be called by anything
+// else on the network. Outgoing calls present the same certificate when a
+// server asks for one.
+//
+// Certificates are PEM files: MTLS_CERT_FILE, MTLS_KEY_FILE and the CA
+// bundle MTLS_CA_FILE, or the X.509 SVID a SPIFFE helper keeps in
+// MTLS_SPIFFE_DIR as svid.pem, svid_key.pem and svid_bundle.pem, in which
+// case clients may be named by their SPIFFE IDs. The files are read again
+// every MTLS_RELOAD_SECONDS, so rotated certificates are used by new
+// connections without a restart.
+package mtls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Config locates a service's certificates. Services hold it in a TLS field
+// of their own Config.
+type Config struct {
+	CertFile string `env:"MTLS_CERT_FILE"`
+	KeyFile  string `env:"MTLS_KEY_FILE"`
+	CAFile   string `env:"MTLS_CA_FILE"`
+	// SPIFFEDir holds an SVID kept up to date by a SPIFFE helper, in place
+	// of the three files
+	SPIFFEDir string `env:"MTLS_SPIFFE_DIR"`
+	// Require refuses requests without a client certificate, but for health
+	// checks
+	Require bool `env:"MTLS_REQUIRE" default:"false"`
+	// AllowedClients are the SPIFFE IDs, DNS names or common names one of
+	// which a client certificate must carry; any certificate of the CA is
+	// accepted without them
+	AllowedClients []string `env:"MTLS_ALLOWED_CLIENTS"`
+	// ReloadInterval is how often the files are checked for new
+	// certificates; 0 reads them once
+	ReloadInterval time.Duration `env:"MTLS_RELOAD_SECONDS" default:"60" min:"0"`
+}
+
+// files returns the paths of the certificate, its key and the CA bundle
+func (c Config) files() (cert, key, ca string) {
+	if c.SPIFFEDir != "" {
+		return filepath.Join(c.SPIFFEDir, "svid.pem"), filepath.Join(c.SPIFFEDir, "svid_key.pem"), filepath.Join(c.SPIFFEDir, "svid_bundle.pem")
+	}
+	return c.CertFile, c.KeyFile, c.CAFile
+}
+
+// healthPaths answer without a client certificate, for probes
+var healthPaths = map[string]bool{"/health": true, "/health/live": true, "/health/ready": true}
+
+// Source holds a service's certificate and the CAs it trusts, as last read
+// from the files
+type Source struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool // the internal CA, for clients
+	roots     *x509.CertPool // the internal CA and the system's, for servers
+	sum       [sha256.Size]byte
+}
+
+// Setup reads the configured certificates and has http.DefaultTransport,
+// so http.DefaultClient and the clients built from it afterwards, present
+// them and trust the internal CA besides the system's. It returns nil when
+// mTLS isn't configured. Call it before building the service's clients.
+func Setup(cfg Config) (*Source, error) {
+	certFile, keyFile, caFile := cfg.files()
+	if certFile == "" && keyFile == "" && caFile == "" {
+		if cfg.Require {
+			return nil, errors.New("MTLS_REQUIRE needs MTLS_CERT_FILE, MTLS_KEY_FILE and MTLS_CA_FILE, or MTLS_SPIFFE_DIR")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, errors.New("MTLS_CERT_FILE, MTLS_KEY_FILE and MTLS_CA_FILE must be set together")
+	}
+
+	s := &Source{cfg: cfg}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval > 0 {
+		go s.watch()
+	}
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = s.ClientConfig()
+	return s, nil
+}
+
+// reload reads the files, reporting whether they changed. Files that don't
+// make a valid certificate, such as while they are being replaced, leave
+// the current one in use.
+func (s *Source) reload() (bool, error) {
+	certFile, keyFile, caFile := s.cfg.files()
+	var contents [][]byte
+	for _, path := range []string{certFile, keyFile, caFile} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("mtls: %w", err)
+		}
+		contents = append(contents, data)
+	}
+	sum := sha256.Sum256(bytes.Join(contents, nil))
+	s.mu.RLock()
+	unchanged := sum == s.sum
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("mtls: %s: %w", certFile, err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(contents[2]) {
+		return false, fmt.Errorf("mtls: %s holds no certificates", caFile)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	roots.AppendCertsFromPEM(contents[2])
+
+	s.mu.Lock()
+	s.cert, s.clientCAs, s.roots, s.sum = &cert, clientCAs, roots, sum
+	s.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the files every ReloadInterval
+func (s *Source) watch() {
+	for range time.Tick(s.cfg.ReloadInterval) {
+		changed, err := s.reload()
+		if err != nil {
+			slog.Warn("Failed to reload TLS certificates, keeping the current ones", "error", err)
+			continue
+		}
+		if changed {
+			slog.Info("TLS certificates reloaded")
+		}
+	}
+}
+
+func (s *Source) certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert
+}
+
+// ServerConfig serves the current certificate and verifies the client
+// certificates given against the current CA. Whether one is required is up
+// to the handler, so health checks can do without.
+func (s *Source) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+				ClientCAs:    s.clientCAs,
+				ClientAuth:   tls.VerifyClientCertIfGiven,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// ClientConfig presents the current certificate to servers asking for one
+// and verifies servers against the current CA and the system's. The chain
+// is checked in VerifyConnection rather than through RootCAs so a rotated
+// CA applies to new connections.
+func (s *Source) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection:   s.verifyServer,
+	}
+}
+
+func (s *Source) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("mtls: server presented no certificate")
+	}
+	s.mu.RLock()
+	roots := s.roots
+	s.mu.RUnlock()
+	opts := x509.VerifyOptions{Roots: roots, DNSName: cs.ServerName, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// ListenAndServe serves server over HTTPS, refusing callers without an
+// allowed client certificate as configured. A nil source serves plain HTTP,
+// as without mTLS.
+func (s *Source) ListenAndServe(server *http.Server) error {
+	if s == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = s.ServerConfig()
+	server.Handler = s.authenticate(server.Handler)
+	return server.ListenAndServeTLS("", "")
+}
+
+// authenticate refuses requests without a client certificate when one is
+// required, and those whose certificate names no allowed client
+func (s *Source) authenticate(next http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, id := range s.cfg.AllowedClients {
+		allowed[id] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			if s.cfg.Require {
+				slog.Warn("Refused request without a client certificate", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				utils.ErrorResponse(w, http.StatusUnauthorized, apierror.Unauthorized, "Client certificate required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(allowed) > 0 {
+			ids := identities(r.TLS.VerifiedChains[0][0])
+			ok := false
+			for _, id := range ids {
+				ok = ok || allowed[id]
+			}
+			if !ok {
+				slog.Warn("Refused request from a client not allowed", "path", r.URL.Path, "client", ids)
+				utils.ErrorResponse(w, http.StatusForbidden, apierror.Forbidden, "Client not allowed")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identities are the names a certificate may be allowed by: its SPIFFE ID
+// and other URIs, its DNS names and its common name
+func identities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+	ids = append(ids, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return ids
+}
@@ -0,0 +1,44 @@
+// Package orgs holds the organization membership tables and the checks
+// services make against them. The user service manages orgs; the gateway
+// verifies the X-Org-ID header of a request against the caller's
+// memberships before forwarding it, with the caller's role in X-Org-Role.
+package orgs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// FromRequest returns the org a request was made on behalf of, or 0. Only
+// trust it behind the gateway, which checks the caller is a member.
+func FromRequest(r *http.Request) int {
+	id, err := strconv.Atoi(r.Header.Get(types.OrgIDHeader))
+	if err != nil || id <= 0 {
+		return 0
+	}
+	return id
+}
+
+// Role returns the user's role in an org, or "" if they aren't a member
+func Role(ctx context.Context, db sqlx.QueryerContext, orgID, userID int) (string, error) {
+	var role string
+	err := sqlx.GetContext(ctx, db, &role,
+		"SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// MemberWhere is a condition matching rows whose org column has the user,
+// given by a placeholder, as a member
+func MemberWhere(org, user string) string {
+	return "(" + org + " IS NOT NULL AND EXISTS (SELECT 1 FROM org_members om WHERE om.org_id = " + org + " AND om.user_id = " + user + "))"
+}
@@ -0,0 +1,45 @@
+// Package referrals resolves referral codes and records referrals. The user
+// service gives each user a referral code and credits them for the sign-ups
+// it brings; the auth service records which code a new user registered with.
+package referrals
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/dialect"
+)
+
+// ErrUnknownCode is returned for codes no user has
+var ErrUnknownCode = errors.New("unknown referral code")
+
+// Normalize returns a code as it is stored; codes are matched case-insensitively
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Referrer returns the user a code belongs to
+func Referrer(ctx context.Context, db sqlx.QueryerContext, code string) (int, error) {
+	var userID int
+	err := sqlx.GetContext(ctx, db, &userID,
+		`SELECT c.user_id FROM referral_codes c JOIN users u ON u.id = c.user_id
+		WHERE c.code = $1 AND u.deleted_at IS NULL`, Normalize(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUnknownCode
+	}
+	return userID, err
+}
+
+// Record credits the referrer with a new user. A user is only ever
+// referred once; recording them again changes nothing.
+func Record(ctx context.Context, db sqlx.ExecerContext, referrerID, referredID int, code string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO referrals (referred_id, referrer_id, code, created_at) VALUES ($1, $2, $3, `+dialect.Of(db).Now()+`)
+		ON CONFLICT (referred_id) DO NOTHING`,
+		referredID, referrerID, Normalize(code))
+	return err
+}
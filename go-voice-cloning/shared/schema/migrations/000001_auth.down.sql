@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS
+	login_devices,
+	revoked_tokens,
+	email_branding,
+	email_templates,
+	users;
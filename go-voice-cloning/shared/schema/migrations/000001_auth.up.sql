@@ -0,0 +1,54 @@
+-- Baseline of the auth service's tables. The baseline versions are the
+-- schema the services created on start before migrations; every statement
+-- is idempotent, so they also apply over a database created that way.
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	username VARCHAR(100) UNIQUE NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_algo VARCHAR(20) NOT NULL DEFAULT 'bcrypt';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(20) NOT NULL DEFAULT 'free';
+
+CREATE TABLE IF NOT EXISTS email_templates (
+	org VARCHAR(255) NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	text_body TEXT NOT NULL DEFAULT '',
+	html_body TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (org, kind)
+);
+
+CREATE TABLE IF NOT EXISTS email_branding (
+	org VARCHAR(255) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	logo_url VARCHAR(2048) NOT NULL DEFAULT '',
+	primary_color VARCHAR(20) NOT NULL DEFAULT '',
+	support_email VARCHAR(255) NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	token_hash CHAR(64) PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Devices a user has logged in from, told apart by their user agent, so a
+-- login from a new one shows up in the user's activity feed
+CREATE TABLE IF NOT EXISTS login_devices (
+	user_id INTEGER NOT NULL,
+	fingerprint VARCHAR(64) NOT NULL,
+	user_agent TEXT NOT NULL,
+	last_ip VARCHAR(45),
+	first_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, fingerprint),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
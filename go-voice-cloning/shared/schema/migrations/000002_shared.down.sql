@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS
+	referrals,
+	referral_codes,
+	user_profiles,
+	org_members,
+	organizations,
+	usage_events,
+	clone_lifecycle_events,
+	user_events,
+	user_activity;
@@ -0,0 +1,98 @@
+-- Baseline of the tables several services write, which each of them used
+-- to create on start
+
+-- The user_activity feed, which every service records activity in
+CREATE TABLE IF NOT EXISTS user_activity (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	subject VARCHAR(255),
+	details JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_user_activity_user ON user_activity(user_id, id);
+
+-- The user_events outbox
+CREATE TABLE IF NOT EXISTS user_events (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(50) NOT NULL,
+	user_id INTEGER NOT NULL,
+	actor_id INTEGER,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_user_events_kind ON user_events(kind, id);
+
+-- The clone_lifecycle_events outbox. Events are written in the transaction
+-- that changes the clone and relayed to the message bus afterwards, so none
+-- is lost while the bus is down.
+CREATE TABLE IF NOT EXISTS clone_lifecycle_events (
+	id BIGSERIAL PRIMARY KEY,
+	event VARCHAR(50) NOT NULL,
+	clone_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	published_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_clone_lifecycle_events_unpublished ON clone_lifecycle_events(id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_clone_lifecycle_events_published_at ON clone_lifecycle_events(published_at);
+ALTER TABLE clone_lifecycle_events ADD COLUMN IF NOT EXISTS files TEXT[];
+CREATE INDEX IF NOT EXISTS idx_clone_lifecycle_events_event ON clone_lifecycle_events(event, id);
+
+-- The usage_events ledger of metered consumption. An event's source
+-- identifies what was consumed, such as a synthesis job, so recording it
+-- again is a no-op.
+CREATE TABLE IF NOT EXISTS usage_events (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	metric VARCHAR(50) NOT NULL,
+	quantity BIGINT NOT NULL,
+	source VARCHAR(255) NOT NULL,
+	occurred_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, metric, source)
+);
+CREATE INDEX IF NOT EXISTS idx_usage_events_user ON usage_events(user_id, occurred_at);
+
+-- Orgs and their members
+CREATE TABLE IF NOT EXISTS organizations (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	created_by INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+	org_id INTEGER NOT NULL REFERENCES organizations(id),
+	user_id INTEGER NOT NULL,
+	role VARCHAR(20) NOT NULL,
+	joined_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members(user_id);
+
+-- Profiles, with the timezone and locale services localize for
+CREATE TABLE IF NOT EXISTS user_profiles (
+	user_id INTEGER PRIMARY KEY,
+	first_name VARCHAR(100),
+	last_name VARCHAR(100),
+	bio TEXT,
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
+
+-- Users' referral codes and who registered with them
+CREATE TABLE IF NOT EXISTS referral_codes (
+	user_id INTEGER PRIMARY KEY,
+	code VARCHAR(16) UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS referrals (
+	referred_id INTEGER PRIMARY KEY,
+	referrer_id INTEGER NOT NULL,
+	code VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer_id, created_at);
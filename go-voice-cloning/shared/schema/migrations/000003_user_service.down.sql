@@ -0,0 +1,12 @@
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+DROP TABLE IF EXISTS
+	notifications,
+	stripe_events,
+	billing_customers,
+	plans,
+	account_deletions,
+	org_invitations,
+	user_preferences,
+	user_avatars,
+	member_activity_daily,
+	user_invitations;
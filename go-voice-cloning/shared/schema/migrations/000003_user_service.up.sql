@@ -0,0 +1,128 @@
+-- Baseline of the user service's tables
+
+CREATE TABLE IF NOT EXISTS user_invitations (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER UNIQUE NOT NULL,
+	token VARCHAR(64) UNIQUE NOT NULL,
+	org VARCHAR(255),
+	invited_by INTEGER,
+	created_at TIMESTAMP NOT NULL,
+	accepted_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+ALTER TABLE user_invitations ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_user_invitations_org ON user_invitations(org);
+
+CREATE TABLE IF NOT EXISTS member_activity_daily (
+	user_id INTEGER NOT NULL,
+	day DATE NOT NULL,
+	clones_created INTEGER NOT NULL DEFAULT 0,
+	storage_bytes BIGINT,
+	last_active_at TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, day)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS user_avatars (
+	user_id INTEGER PRIMARY KEY,
+	original_file VARCHAR(255) NOT NULL,
+	variants JSONB NOT NULL,
+	content_type VARCHAR(50) NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+	user_id INTEGER PRIMARY KEY,
+	version INTEGER NOT NULL,
+	preferences JSONB NOT NULL DEFAULT '{}',
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+	id SERIAL PRIMARY KEY,
+	org_id INTEGER NOT NULL REFERENCES organizations(id),
+	email VARCHAR(255) NOT NULL,
+	role VARCHAR(20) NOT NULL,
+	token VARCHAR(64) UNIQUE NOT NULL,
+	invited_by INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	accepted_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org ON org_invitations(org_id);
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+	event_id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	requested_by INTEGER,
+	source VARCHAR(20) NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	step VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	report JSONB NOT NULL DEFAULT '{}',
+	error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_account_deletions_status ON account_deletions(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_account_deletions_user ON account_deletions(user_id, event_id);
+
+CREATE TABLE IF NOT EXISTS plans (
+	name VARCHAR(20) PRIMARY KEY,
+	max_clones INTEGER NOT NULL,
+	max_concurrent_jobs INTEGER NOT NULL,
+	max_processing_jobs INTEGER NOT NULL,
+	synthesis_minutes_per_month INTEGER NOT NULL,
+	inactive_clone_days INTEGER NOT NULL,
+	sample_storage_bytes BIGINT NOT NULL,
+	output_storage_bytes BIGINT NOT NULL,
+	max_priority VARCHAR(10) NOT NULL,
+	updated_by INTEGER,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(20) NOT NULL DEFAULT 'free';
+
+CREATE TABLE IF NOT EXISTS billing_customers (
+	user_id INTEGER PRIMARY KEY,
+	stripe_customer_id VARCHAR(255) UNIQUE NOT NULL,
+	subscription_id VARCHAR(255),
+	subscription_status VARCHAR(30),
+	plan VARCHAR(20) NOT NULL DEFAULT 'free',
+	current_period_end TIMESTAMP,
+	cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+	last_event_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS stripe_events (
+	id VARCHAR(255) PRIMARY KEY,
+	type VARCHAR(100) NOT NULL,
+	received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	source_id BIGINT NOT NULL,
+	details JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL,
+	send_after TIMESTAMP NOT NULL DEFAULT NOW(),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	sent_at TIMESTAMP,
+	UNIQUE (kind, user_id, source_id)
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_due ON notifications(send_after) WHERE status IN ('pending', 'sending');
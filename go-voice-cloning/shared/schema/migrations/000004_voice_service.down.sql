@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS
+	idempotency_keys,
+	user_cleanups,
+	gallery_demo_usage,
+	gallery_reports,
+	gallery_listings,
+	clone_models,
+	synthesis_usage,
+	synthesis_jobs,
+	clone_manifests,
+	webhook_deliveries,
+	voice_callback_settings,
+	clone_defaults,
+	clone_sources,
+	clone_shares,
+	clone_artifacts,
+	voice_clones;
@@ -0,0 +1,239 @@
+-- Baseline of the voice service's tables
+
+CREATE TABLE IF NOT EXISTS voice_clones (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	source_file VARCHAR(500) NOT NULL,
+	output_file VARCHAR(500),
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS clone_artifacts (
+	id SERIAL PRIMARY KEY,
+	clone_id INTEGER NOT NULL,
+	stage VARCHAR(50) NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	file VARCHAR(500) NOT NULL,
+	content_type VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (clone_id) REFERENCES voice_clones(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_clone_artifacts_clone_id ON clone_artifacts(clone_id);
+
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS callback_url VARCHAR(2048);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS tags TEXT[];
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS metadata JSONB;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS error_code VARCHAR(50);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS error_message TEXT;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS model_offloaded_at TIMESTAMP;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_archived_at ON voice_clones(archived_at) WHERE archived_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_deleted_at ON voice_clones(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_status ON voice_clones(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_tags ON voice_clones USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_metadata ON voice_clones USING GIN (metadata jsonb_path_ops);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS settings JSONB;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS retry_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS progress SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS stage VARCHAR(50);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS priority VARCHAR(20) NOT NULL DEFAULT 'normal';
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS visibility VARCHAR(20) NOT NULL DEFAULT 'private';
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS preview_file VARCHAR(500);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_created ON voice_clones(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_public ON voice_clones(completed_at DESC, id DESC) WHERE visibility = 'public';
+
+CREATE TABLE IF NOT EXISTS clone_shares (
+	clone_id INTEGER NOT NULL REFERENCES voice_clones(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	granted_by INTEGER NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (clone_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_clone_shares_user ON clone_shares(user_id);
+
+CREATE TABLE IF NOT EXISTS clone_sources (
+	clone_id INTEGER NOT NULL REFERENCES voice_clones(id) ON DELETE CASCADE,
+	position SMALLINT NOT NULL,
+	filename VARCHAR(500) NOT NULL,
+	PRIMARY KEY (clone_id, position)
+);
+CREATE INDEX IF NOT EXISTS idx_clone_sources_filename ON clone_sources(filename);
+ALTER TABLE clone_sources ADD COLUMN IF NOT EXISTS file_version INTEGER;
+
+CREATE TABLE IF NOT EXISTS clone_defaults (
+	scope VARCHAR(10) NOT NULL,
+	scope_id VARCHAR(255) NOT NULL,
+	settings JSONB NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (scope, scope_id)
+);
+
+CREATE TABLE IF NOT EXISTS voice_callback_settings (
+	user_id INTEGER PRIMARY KEY,
+	url VARCHAR(2048),
+	secret VARCHAR(100) NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id SERIAL PRIMARY KEY,
+	clone_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	event VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_status_code INTEGER,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP,
+	FOREIGN KEY (clone_id) REFERENCES voice_clones(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_clone_id ON webhook_deliveries(clone_id);
+
+CREATE TABLE IF NOT EXISTS clone_manifests (
+	clone_id INTEGER PRIMARY KEY REFERENCES voice_clones(id) ON DELETE CASCADE,
+	manifest TEXT NOT NULL,
+	algorithm VARCHAR(20) NOT NULL,
+	key_id VARCHAR(32) NOT NULL,
+	public_key TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS synthesis_jobs (
+	id SERIAL PRIMARY KEY,
+	clone_id INTEGER NOT NULL REFERENCES voice_clones(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL,
+	text TEXT,
+	ssml TEXT,
+	status VARCHAR(50) NOT NULL,
+	output_file VARCHAR(500),
+	error TEXT,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_synthesis_jobs_clone_id ON synthesis_jobs(clone_id, created_at DESC);
+ALTER TABLE synthesis_jobs ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+CREATE INDEX IF NOT EXISTS idx_synthesis_jobs_user_completed ON synthesis_jobs(user_id, completed_at);
+
+CREATE TABLE IF NOT EXISTS synthesis_usage (
+	user_id INTEGER NOT NULL,
+	period DATE NOT NULL,
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, period)
+);
+
+CREATE TABLE IF NOT EXISTS clone_models (
+	clone_id INTEGER NOT NULL REFERENCES voice_clones(id) ON DELETE CASCADE,
+	version INTEGER NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	file VARCHAR(500),
+	source_files TEXT[] NOT NULL DEFAULT '{}',
+	progress SMALLINT NOT NULL DEFAULT 0,
+	error_code VARCHAR(50),
+	error_message TEXT,
+	created_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	PRIMARY KEY (clone_id, version)
+);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS model_version INTEGER;
+ALTER TABLE synthesis_jobs ADD COLUMN IF NOT EXISTS model_version INTEGER;
+-- Models trained before versioning become version 1
+INSERT INTO clone_models (clone_id, version, status, file, created_at, completed_at)
+SELECT DISTINCT ON (clone_id) clone_id, 1, 'completed', file, created_at, created_at
+FROM clone_artifacts WHERE kind = 'voice_model' ORDER BY clone_id, id DESC
+ON CONFLICT DO NOTHING;
+UPDATE voice_clones c SET model_version = 1
+WHERE model_version IS NULL AND EXISTS (SELECT 1 FROM clone_models m WHERE m.clone_id = c.id);
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS quality JSONB;
+ALTER TABLE clone_models ADD COLUMN IF NOT EXISTS quality JSONB;
+ALTER TABLE clone_models ADD COLUMN IF NOT EXISTS preview_file VARCHAR(500);
+ALTER TABLE clone_models ADD COLUMN IF NOT EXISTS source_versions JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS process_after TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_process_after ON voice_clones(process_after) WHERE process_after IS NOT NULL;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS retention_exempt BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_expires_at ON voice_clones(expires_at) WHERE expires_at IS NOT NULL;
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS org_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_org ON voice_clones(org_id) WHERE org_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS gallery_listings (
+	clone_id INTEGER PRIMARY KEY REFERENCES voice_clones(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	category VARCHAR(50) NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	review_note TEXT,
+	demo_limit INTEGER NOT NULL,
+	submitted_at TIMESTAMP NOT NULL,
+	reviewed_at TIMESTAMP,
+	published_at TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_gallery_listings_public ON gallery_listings(status, published_at DESC, clone_id DESC);
+
+CREATE TABLE IF NOT EXISTS gallery_reports (
+	id SERIAL PRIMARY KEY,
+	clone_id INTEGER NOT NULL REFERENCES gallery_listings(clone_id) ON DELETE CASCADE,
+	reason VARCHAR(50) NOT NULL,
+	details TEXT,
+	reporter VARCHAR(64) NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	resolved_at TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gallery_reports_open ON gallery_reports(clone_id, reporter) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS gallery_demo_usage (
+	clone_id INTEGER NOT NULL REFERENCES gallery_listings(clone_id) ON DELETE CASCADE,
+	visitor VARCHAR(64) NOT NULL,
+	day DATE NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (clone_id, visitor, day)
+);
+
+CREATE TABLE IF NOT EXISTS user_cleanups (
+	id SERIAL PRIMARY KEY,
+	event_id INTEGER UNIQUE NOT NULL,
+	user_id INTEGER NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	clones_total INTEGER NOT NULL DEFAULT 0,
+	clones_deleted INTEGER NOT NULL DEFAULT 0,
+	syntheses_deleted INTEGER NOT NULL DEFAULT 0,
+	files_deleted INTEGER NOT NULL DEFAULT 0,
+	files_failed TEXT[] NOT NULL DEFAULT '{}',
+	error TEXT,
+	created_at TIMESTAMP NOT NULL,
+	started_at TIMESTAMP,
+	completed_at TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_cleanups_user_id ON user_cleanups(user_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id INTEGER NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	request_hash CHAR(64) NOT NULL,
+	status_code INTEGER,
+	response TEXT,
+	location VARCHAR(500),
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS
+	unattributed_objects,
+	clone_collections,
+	file_shares,
+	file_access_events,
+	object_replicas,
+	direct_uploads,
+	folders,
+	file_waveforms,
+	file_versions,
+	storage_quotas,
+	stored_blobs,
+	upload_sessions,
+	file_access_audit,
+	stored_files;
@@ -0,0 +1,235 @@
+-- Baseline of the storage service's tables
+
+CREATE TABLE IF NOT EXISTS stored_files (
+	filename VARCHAR(255) PRIMARY KEY,
+	user_id INTEGER,
+	class VARCHAR(20) NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP
+);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS file_type VARCHAR(50);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS org_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_stored_files_org ON stored_files(org_id) WHERE org_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_stored_files_user_class ON stored_files(user_id, class);
+CREATE INDEX IF NOT EXISTS idx_stored_files_expires_at ON stored_files(expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS file_access_audit (
+	id SERIAL PRIMARY KEY,
+	admin_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	filename VARCHAR(255) NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	justification TEXT NOT NULL,
+	request_id VARCHAR(100),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_user ON file_access_audit(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_admin ON file_access_audit(admin_id, created_at DESC);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS tier VARCHAR(20) NOT NULL DEFAULT 'standard';
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS object_key VARCHAR(500);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS id VARCHAR(64);
+UPDATE stored_files SET id = filename WHERE id IS NULL;
+ALTER TABLE stored_files ALTER COLUMN id SET NOT NULL;
+ALTER TABLE stored_files DROP CONSTRAINT IF EXISTS stored_files_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stored_files_id ON stored_files(id);
+ALTER TABLE file_access_audit ADD COLUMN IF NOT EXISTS file_id VARCHAR(64);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id INTEGER,
+	filename VARCHAR(255) NOT NULL,
+	file_type VARCHAR(50),
+	content_type VARCHAR(255),
+	class VARCHAR(20) NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions(expires_at);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS audio_format VARCHAR(10);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS audio_codec VARCHAR(20);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS channels INTEGER;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS sample_rate INTEGER;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS bits_per_sample INTEGER;
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS normalized_key VARCHAR(500);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS normalized_size BIGINT;
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS content_type VARCHAR(255);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS checksum_sha256 VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_stored_files_user_created ON stored_files(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_stored_files_user_filename ON stored_files(user_id, filename, id);
+CREATE INDEX IF NOT EXISTS idx_stored_files_user_size ON stored_files(user_id, size_bytes, id);
+
+CREATE TABLE IF NOT EXISTS stored_blobs (
+	object_key VARCHAR(500) PRIMARY KEY,
+	checksum_sha256 VARCHAR(64) NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	ref_count INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Admins can give a user a limit other than the class default
+CREATE TABLE IF NOT EXISTS storage_quotas (
+	user_id INTEGER NOT NULL,
+	class VARCHAR(20) NOT NULL,
+	limit_bytes BIGINT NOT NULL,
+	updated_by INTEGER,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, class)
+);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS scan_status VARCHAR(20);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS scan_signature VARCHAR(255);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_stored_files_scan_queue ON stored_files(created_at) WHERE scan_status IN ('pending', 'scanning');
+
+-- Which files have been encrypted, so files stored before encryption was
+-- enabled can be found and encrypted
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_stored_files_unencrypted ON stored_files(object_key) WHERE NOT encrypted;
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+CREATE TABLE IF NOT EXISTS file_versions (
+	file_id VARCHAR(64) NOT NULL,
+	version INTEGER NOT NULL,
+	filename VARCHAR(255) NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	duration_ms BIGINT,
+	object_key VARCHAR(500) NOT NULL,
+	audio_format VARCHAR(10),
+	audio_codec VARCHAR(20),
+	channels INTEGER,
+	sample_rate INTEGER,
+	bits_per_sample INTEGER,
+	normalized_key VARCHAR(500),
+	normalized_size BIGINT,
+	content_type VARCHAR(255),
+	checksum_sha256 VARCHAR(64),
+	scan_status VARCHAR(20),
+	quarantined BOOLEAN NOT NULL DEFAULT FALSE,
+	encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (file_id, version)
+);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS temporary BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS reconciled_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_stored_files_reconciled_at ON stored_files(reconciled_at NULLS FIRST, created_at)
+	WHERE expires_at IS NULL AND user_id IS NOT NULL;
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS ttl_seconds BIGINT;
+
+CREATE TABLE IF NOT EXISTS file_waveforms (
+	object_key VARCHAR(500) PRIMARY KEY,
+	sample_rate INTEGER NOT NULL,
+	samples_per_peak INTEGER NOT NULL,
+	peaks BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS checksum_sha256 VARCHAR(64);
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS content_md5 VARCHAR(24);
+
+CREATE TABLE IF NOT EXISTS folders (
+	user_id INTEGER NOT NULL,
+	path VARCHAR(500) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, path)
+);
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS folder VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS folder VARCHAR(500) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_stored_files_user_folder ON stored_files(user_id, folder);
+
+CREATE TABLE IF NOT EXISTS direct_uploads (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id INTEGER,
+	filename VARCHAR(255) NOT NULL,
+	file_type VARCHAR(50),
+	content_type VARCHAR(255),
+	class VARCHAR(20) NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	ttl_seconds BIGINT,
+	checksum_sha256 VARCHAR(64),
+	content_md5 VARCHAR(24),
+	folder VARCHAR(500) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_direct_uploads_expires_at ON direct_uploads(expires_at);
+
+CREATE TABLE IF NOT EXISTS object_replicas (
+	object_key VARCHAR(500) PRIMARY KEY,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	size_bytes BIGINT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	replicated_at TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_object_replicas_status ON object_replicas(status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS file_access_events (
+	id BIGSERIAL PRIMARY KEY,
+	file_id VARCHAR(64) NOT NULL,
+	owner_id INTEGER,
+	user_id INTEGER,
+	role VARCHAR(20),
+	signed BOOLEAN NOT NULL DEFAULT FALSE,
+	action VARCHAR(20) NOT NULL,
+	result VARCHAR(20) NOT NULL,
+	status INTEGER NOT NULL,
+	ip VARCHAR(64),
+	request_id VARCHAR(100),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_file_access_events_file ON file_access_events(file_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_events_user ON file_access_events(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_events_owner ON file_access_events(owner_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_events_created_at ON file_access_events(created_at DESC);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS acl VARCHAR(20) NOT NULL DEFAULT 'private';
+CREATE TABLE IF NOT EXISTS file_shares (
+	file_id VARCHAR(64) NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (file_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_file_shares_user ON file_shares(user_id);
+
+CREATE TABLE IF NOT EXISTS clone_collections (
+	event_id BIGINT PRIMARY KEY,
+	clone_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	files TEXT[] NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	files_deleted INTEGER NOT NULL DEFAULT 0,
+	files_missing INTEGER NOT NULL DEFAULT 0,
+	files_kept INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	completed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_clone_collections_status ON clone_collections(status, event_id);
+CREATE TABLE IF NOT EXISTS unattributed_objects (
+	event_id BIGINT NOT NULL,
+	file_id VARCHAR(255) NOT NULL,
+	clone_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	owner_id INTEGER,
+	reason VARCHAR(30) NOT NULL,
+	detected_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (event_id, file_id)
+);
+CREATE INDEX IF NOT EXISTS idx_unattributed_objects_detected_at ON unattributed_objects(detected_at DESC);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS
+	notification_deliveries,
+	notification_webhooks;
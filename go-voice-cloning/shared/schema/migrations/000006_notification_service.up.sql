@@ -0,0 +1,33 @@
+-- Tables of the notification service: the webhook endpoints users register
+-- and the emails and webhooks sent for platform events
+
+CREATE TABLE IF NOT EXISTS notification_webhooks (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	events TEXT[] NOT NULL,
+	secret VARCHAR(100) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_notification_webhooks_user ON notification_webhooks(user_id);
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	event_id VARCHAR(64) NOT NULL,
+	event_type VARCHAR(100) NOT NULL,
+	channel VARCHAR(20) NOT NULL,
+	webhook_id INTEGER REFERENCES notification_webhooks(id) ON DELETE CASCADE,
+	target VARCHAR(2048) NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_status_code INTEGER,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	delivered_at TIMESTAMP,
+	UNIQUE (event_id, channel, target)
+);
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_due ON notification_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user ON notification_deliveries(user_id, id DESC);
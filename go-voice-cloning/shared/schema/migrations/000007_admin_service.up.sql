@@ -0,0 +1,15 @@
+-- The admin service's audit log: every operation an admin ran through it,
+-- who ran it, on what, and what it did
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	admin_id INTEGER NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	target_type VARCHAR(50) NOT NULL,
+	target_id VARCHAR(100),
+	details JSONB,
+	request_id VARCHAR(64),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_admin ON admin_audit_log(admin_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_action ON admin_audit_log(action, id DESC);
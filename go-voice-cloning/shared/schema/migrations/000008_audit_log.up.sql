@@ -0,0 +1,22 @@
+-- The central audit log: security-relevant operations of every service,
+-- who ran them, on what, and how they turned out. Written by shared/audit
+-- when AUDIT_SINK is db.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	service VARCHAR(50) NOT NULL,
+	actor_id INTEGER NOT NULL DEFAULT 0,
+	actor_role VARCHAR(20),
+	action VARCHAR(50) NOT NULL,
+	resource_type VARCHAR(50) NOT NULL,
+	resource_id VARCHAR(100),
+	outcome VARCHAR(10) NOT NULL,
+	details JSONB,
+	request_id VARCHAR(64),
+	ip_address VARCHAR(45),
+	occurred_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, id DESC);
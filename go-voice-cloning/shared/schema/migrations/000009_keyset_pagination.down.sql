@@ -0,0 +1,21 @@
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_created ON voice_clones(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_synthesis_jobs_clone_id ON synthesis_jobs(clone_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_clone_id ON webhook_deliveries(clone_id);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_user ON file_access_audit(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_admin ON file_access_audit(admin_id, created_at DESC);
+
+DROP INDEX IF EXISTS
+	idx_voice_clones_user_created_id,
+	idx_voice_clones_user_updated_id,
+	idx_voice_clones_user_name_id,
+	idx_synthesis_jobs_clone_user_created,
+	idx_webhook_deliveries_clone_user,
+	idx_gallery_listings_queue,
+	idx_gallery_reports_status,
+	idx_user_cleanups_status,
+	idx_file_access_audit_user_page,
+	idx_file_access_audit_admin_page,
+	idx_file_access_audit_created,
+	idx_account_deletions_status_event,
+	idx_org_members_org_joined,
+	idx_referrals_referrer_referred;
@@ -0,0 +1,28 @@
+-- Indexes matching the order and filters of each paginated listing, so a
+-- page after a cursor is read from the index instead of sorting every row
+-- of a large account. Each ends with the listing's tiebreak column.
+
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_created_id ON voice_clones(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_updated_id ON voice_clones(user_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_voice_clones_user_name_id ON voice_clones(user_id, name, id);
+DROP INDEX IF EXISTS idx_voice_clones_user_created;
+
+CREATE INDEX IF NOT EXISTS idx_synthesis_jobs_clone_user_created ON synthesis_jobs(clone_id, user_id, created_at DESC, id DESC);
+DROP INDEX IF EXISTS idx_synthesis_jobs_clone_id;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_clone_user ON webhook_deliveries(clone_id, user_id, id DESC);
+DROP INDEX IF EXISTS idx_webhook_deliveries_clone_id;
+
+CREATE INDEX IF NOT EXISTS idx_gallery_listings_queue ON gallery_listings(status, submitted_at, clone_id);
+CREATE INDEX IF NOT EXISTS idx_gallery_reports_status ON gallery_reports(status, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_user_cleanups_status ON user_cleanups(status, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_user_page ON file_access_audit(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_admin_page ON file_access_audit(admin_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_file_access_audit_created ON file_access_audit(created_at DESC, id DESC);
+DROP INDEX IF EXISTS idx_file_access_audit_user;
+DROP INDEX IF EXISTS idx_file_access_audit_admin;
+
+CREATE INDEX IF NOT EXISTS idx_account_deletions_status_event ON account_deletions(status, event_id DESC);
+CREATE INDEX IF NOT EXISTS idx_org_members_org_joined ON org_members(org_id, joined_at, user_id);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer_referred ON referrals(referrer_id, referred_id DESC);
@@ -0,0 +1,15 @@
+-- Product events of the services and clients, aggregated by the analytics
+-- service for the admin dashboard and users' stats pages, when
+-- ANALYTICS_STORE is postgres. user_id is NULL for events of no user.
+
+CREATE TABLE IF NOT EXISTS analytics_events (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(64) NOT NULL,
+	user_id INTEGER,
+	source VARCHAR(50) NOT NULL,
+	properties JSONB,
+	occurred_at TIMESTAMP NOT NULL,
+	received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_analytics_events_name ON analytics_events(name, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_analytics_events_user ON analytics_events(user_id, occurred_at) WHERE user_id IS NOT NULL;
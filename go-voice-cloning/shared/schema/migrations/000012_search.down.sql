@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_voice_clones_search, idx_stored_files_search;
+ALTER TABLE voice_clones DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE stored_files DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,21 @@
+-- Full-text search over clones and files. The vectors are generated
+-- columns, kept current by Postgres on every write. Words are weighted by
+-- where they were found, names first. Filenames have their punctuation
+-- turned into spaces first, as the parser would otherwise read a name such
+-- as take_3.wav as one host name token. Tags are lexemes as they are,
+-- already lowercased, leaving out empty ones, which array_to_tsvector
+-- refuses.
+
+ALTER TABLE voice_clones ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', name), 'A') ||
+	setweight(array_to_tsvector(array_remove(array_remove(COALESCE(tags, '{}'), NULL), '')), 'B') ||
+	setweight(jsonb_to_tsvector('simple', COALESCE(metadata, '{}'), '["string"]'), 'C') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'D')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_voice_clones_search ON voice_clones USING GIN (search_vector);
+
+ALTER TABLE stored_files ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', regexp_replace(filename, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+	setweight(to_tsvector('simple', regexp_replace(folder, '[^[:alnum:]]+', ' ', 'g')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_stored_files_search ON stored_files USING GIN (search_vector);
@@ -0,0 +1,239 @@
+// Package schema migrates the database the services share. Its schema is
+// one ordered history of SQL migrations embedded from migrations/: version
+// N is applied by NNNNNN_name.up.sql and reverted by NNNNNN_name.down.sql.
+// Applied versions are recorded in schema_migrations, each in the
+// transaction that applied it, so a failed migration leaves nothing
+// half-done and is retried whole.
+//
+// Services apply pending migrations on start unless MIGRATE_ON_START is
+// false; the migrate command applies, reverts and lists them.
+//
+// A SQLite database has its own history, embedded from sqlite/, of the
+// tables of the services that run on SQLite.
+package schema
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/dialect"
+)
+
+//go:embed migrations/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockID is the Postgres advisory lock that keeps services starting at the
+// same time from migrating together
+const lockID = 738202
+
+const migrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+
+// Migration is one version of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it was
+type Status struct {
+	Version   int        `json:"version" db:"version"`
+	Name      string     `json:"name" db:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty" db:"applied_at"`
+}
+
+// Migrations returns the embedded migrations of a dialect in version order
+func Migrations(d dialect.Dialect) ([]Migration, error) {
+	dir := "migrations"
+	if d == dialect.SQLite {
+		dir = "sqlite"
+	}
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		number, name, named := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || !named || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("schema: malformed migration file name %s", entry.Name())
+		}
+		sql, err := files.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("schema: version %d is named both %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("schema: version %d has no up migration", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies the pending migrations in order and returns how many it
+// applied
+func Up(ctx context.Context, db *sqlx.DB) (int, error) {
+	migrations, err := Migrations(dialect.Of(db))
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	err = locked(ctx, db, func(conn *sqlx.Conn, done map[int]bool) error {
+		for _, m := range migrations {
+			if done[m.Version] {
+				continue
+			}
+			if err := run(ctx, conn, m.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+				return fmt.Errorf("schema: migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// how many it reverted
+func Down(ctx context.Context, db *sqlx.DB, steps int) (int, error) {
+	migrations, err := Migrations(dialect.Of(db))
+	if err != nil {
+		return 0, err
+	}
+	reverted := 0
+	err = locked(ctx, db, func(conn *sqlx.Conn, done map[int]bool) error {
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if !done[m.Version] {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("schema: migration %d_%s can't be reverted", m.Version, m.Name)
+			}
+			if err := run(ctx, conn, m.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+				return fmt.Errorf("schema: reverting migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			slog.Info("Reverted migration", "version", m.Version, "name", m.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// List returns every migration with when it was applied. Versions recorded
+// in the database but not embedded, applied by a newer build, are listed
+// too.
+func List(ctx context.Context, db *sqlx.DB) ([]Status, error) {
+	migrations, err := Migrations(dialect.Of(db))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, migrationsTable); err != nil {
+		return nil, fmt.Errorf("schema: failed to create schema_migrations: %w", err)
+	}
+	var applied []Status
+	if err := db.SelectContext(ctx, &applied, "SELECT version, name, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]Status{}
+	for _, s := range applied {
+		byVersion[s.Version] = s
+	}
+	for _, m := range migrations {
+		if _, ok := byVersion[m.Version]; !ok {
+			byVersion[m.Version] = Status{Version: m.Version, Name: m.Name}
+		}
+	}
+	statuses := make([]Status, 0, len(byVersion))
+	for _, s := range byVersion {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// locked runs fn holding the migration lock, with the versions applied so
+// far
+func locked(ctx context.Context, db *sqlx.DB, fn func(conn *sqlx.Conn, done map[int]bool) error) error {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	d := dialect.Of(db)
+	if err := d.Lock(ctx, conn, lockID); err != nil {
+		return fmt.Errorf("schema: failed to take the migration lock: %w", err)
+	}
+	defer d.Unlock(conn, lockID)
+
+	if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("schema: failed to create schema_migrations: %w", err)
+	}
+	var versions []int
+	if err := conn.SelectContext(ctx, &versions, "SELECT version FROM schema_migrations"); err != nil {
+		return err
+	}
+	done := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		done[v] = true
+	}
+	return fn(conn, done)
+}
+
+// run executes a migration's SQL and records it in one transaction
+func run(ctx context.Context, conn *sqlx.Conn, sql, record string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
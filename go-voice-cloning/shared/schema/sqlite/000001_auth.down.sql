@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS referrals;
+DROP TABLE IF EXISTS referral_codes;
+DROP TABLE IF EXISTS user_activity;
+DROP TABLE IF EXISTS user_profiles;
+DROP TABLE IF EXISTS user_invitations;
+DROP TABLE IF EXISTS login_devices;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS email_branding;
+DROP TABLE IF EXISTS email_templates;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,120 @@
+-- The tables of the auth service on SQLite, and those of other services it
+-- reads or writes, as the Postgres history leaves them. SERIAL columns are
+-- INTEGER PRIMARY KEY, which SQLite numbers itself, and JSONB is TEXT.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	username VARCHAR(100) UNIQUE NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	role VARCHAR(50) NOT NULL DEFAULT 'user',
+	password_algo VARCHAR(20) NOT NULL DEFAULT 'bcrypt',
+	plan VARCHAR(20) NOT NULL DEFAULT 'free',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS email_templates (
+	org VARCHAR(255) NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	subject TEXT NOT NULL DEFAULT '',
+	text_body TEXT NOT NULL DEFAULT '',
+	html_body TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (org, kind)
+);
+
+CREATE TABLE IF NOT EXISTS email_branding (
+	org VARCHAR(255) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	logo_url VARCHAR(2048) NOT NULL DEFAULT '',
+	primary_color VARCHAR(20) NOT NULL DEFAULT '',
+	support_email VARCHAR(255) NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	token_hash CHAR(64) PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS login_devices (
+	user_id INTEGER NOT NULL,
+	fingerprint VARCHAR(64) NOT NULL,
+	user_agent TEXT NOT NULL,
+	last_ip VARCHAR(45),
+	first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, fingerprint),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_invitations (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER UNIQUE NOT NULL,
+	token VARCHAR(64) UNIQUE NOT NULL,
+	org VARCHAR(255),
+	invited_by INTEGER,
+	created_at TIMESTAMP NOT NULL,
+	accepted_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_invitations_org ON user_invitations(org);
+
+CREATE TABLE IF NOT EXISTS user_profiles (
+	user_id INTEGER PRIMARY KEY,
+	first_name VARCHAR(100),
+	last_name VARCHAR(100),
+	bio TEXT,
+	timezone VARCHAR(64),
+	locale VARCHAR(35),
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_activity (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	kind VARCHAR(50) NOT NULL,
+	subject VARCHAR(255),
+	details TEXT NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_user_activity_user ON user_activity(user_id, id);
+
+CREATE TABLE IF NOT EXISTS referral_codes (
+	user_id INTEGER PRIMARY KEY,
+	code VARCHAR(16) UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS referrals (
+	referred_id INTEGER PRIMARY KEY,
+	referrer_id INTEGER NOT NULL,
+	code VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer_referred ON referrals(referrer_id, referred_id DESC);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY,
+	service VARCHAR(50) NOT NULL,
+	actor_id INTEGER NOT NULL DEFAULT 0,
+	actor_role VARCHAR(20),
+	action VARCHAR(50) NOT NULL,
+	resource_type VARCHAR(50) NOT NULL,
+	resource_id VARCHAR(100),
+	outcome VARCHAR(10) NOT NULL,
+	details TEXT,
+	request_id VARCHAR(64),
+	ip_address VARCHAR(45),
+	occurred_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, id DESC);
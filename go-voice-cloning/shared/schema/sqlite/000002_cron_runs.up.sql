@@ -0,0 +1,16 @@
+-- Runs of the auth service's maintenance jobs, as in the Postgres history
+
+CREATE TABLE IF NOT EXISTS cron_runs (
+	id INTEGER PRIMARY KEY,
+	service VARCHAR(50) NOT NULL,
+	job VARCHAR(50) NOT NULL,
+	kind VARCHAR(10) NOT NULL,
+	scheduled_for TIMESTAMP,
+	triggered_by INTEGER,
+	status VARCHAR(10) NOT NULL,
+	error TEXT,
+	started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cron_runs_slot ON cron_runs(service, job, scheduled_for) WHERE scheduled_for IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_cron_runs_job ON cron_runs(service, job, id DESC);
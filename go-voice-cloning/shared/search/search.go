@@ -0,0 +1,75 @@
+// Package search holds what the services' full-text search endpoints share:
+// parsing what a user typed into a Postgres text search query, and the
+// results they answer with. Each searched table keeps a search_vector
+// column, built with the 'simple' configuration so names, tags and
+// filenames in any language match as typed, without stemming.
+package search
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// Types of results
+const (
+	TypeClone = "clone"
+	TypeFile  = "file"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 50
+	// MinQueryLength and MaxQueryLength bound ?q= in characters
+	MinQueryLength = 2
+	MaxQueryLength = 200
+	// maxTerms bounds the words of a query; later ones are ignored
+	maxTerms = 8
+)
+
+// termPattern finds the words of a query. Anything else, including the
+// operators of to_tsquery, separates them.
+var termPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Result is a match of a search, most relevant first by Rank. Item is the
+// clone or file, as its service lists it.
+type Result struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Name string      `json:"name"`
+	Rank float64     `json:"rank"`
+	Item interface{} `json:"item"`
+}
+
+// Request is a parsed search: TSQuery is the text of a
+// to_tsquery('simple', ...) matching documents with every word of the
+// query, the words taken as prefixes so results narrow as the user types
+type Request struct {
+	Query   string
+	TSQuery string
+	Limit   int
+}
+
+// ParseRequest reads ?q= and ?limit= of a search
+func ParseRequest(q url.Values) (Request, error) {
+	query := strings.TrimSpace(q.Get("q"))
+	if n := len([]rune(query)); n < MinQueryLength || n > MaxQueryLength {
+		return Request{}, fmt.Errorf("q must be between %d and %d characters", MinQueryLength, MaxQueryLength)
+	}
+	limit, err := types.ParseLimit(q, DefaultLimit, MaxLimit)
+	if err != nil {
+		return Request{}, err
+	}
+
+	terms := termPattern.FindAllString(strings.ToLower(query), maxTerms)
+	if len(terms) == 0 {
+		return Request{}, fmt.Errorf("q must contain a letter or digit")
+	}
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return Request{Query: query, TSQuery: strings.Join(terms, " & "), Limit: limit}, nil
+}
@@ -0,0 +1,134 @@
+// Package speechstream carries synthesized speech from the voice worker to
+// the voice service while it is being produced. The worker appends the audio
+// in chunks to a Redis stream per synthesis job; the service reads them back
+// for clients listening to the job. Streaming is best effort: the stored
+// output file stays the complete copy.
+package speechstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChunkSize is how much audio the worker sends at a time
+const ChunkSize = 32 << 10
+
+// TTL is how long a job's stream can still be read after its last entry
+const TTL = 10 * time.Minute
+
+// How a stream ended
+const (
+	EndCompleted = "completed"
+	// EndInterrupted means the attempt stopped before all audio was sent,
+	// because synthesis failed or chunks couldn't be sent. A failed job may
+	// be retried, starting a new stream.
+	EndInterrupted = "interrupted"
+)
+
+// Entry is a chunk of audio, or the end of the stream
+type Entry struct {
+	Chunk []byte
+	End   string
+	Error string
+}
+
+func key(synthesisID int) string {
+	return fmt.Sprintf("synthesis:%d:audio", synthesisID)
+}
+
+// Writer sends audio written to it to a job's stream. Failing to send a
+// chunk stops the stream but not the writes, so synthesis carries on to the
+// output file. Close it with the outcome of synthesis.
+type Writer struct {
+	ctx    context.Context
+	client *redis.Client
+	key    string
+	buf    []byte
+	err    error
+}
+
+// NewWriter starts a job's stream, replacing what an earlier attempt sent
+func NewWriter(ctx context.Context, client *redis.Client, synthesisID int) *Writer {
+	w := &Writer{ctx: ctx, client: client, key: key(synthesisID)}
+	w.err = client.Del(ctx, w.key).Err()
+	return w
+}
+
+// Write buffers p and sends every full chunk
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= ChunkSize && w.err == nil {
+		w.err = w.add(map[string]interface{}{"chunk": w.buf[:ChunkSize]})
+		w.buf = w.buf[ChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close sends the rest of the audio and ends the stream: completed, or
+// interrupted when cause is set or a chunk couldn't be sent
+func (w *Writer) Close(cause error) error {
+	if w.err == nil && cause == nil && len(w.buf) > 0 {
+		w.err = w.add(map[string]interface{}{"chunk": w.buf})
+	}
+	end := map[string]interface{}{"end": EndCompleted}
+	switch {
+	case cause != nil:
+		end = map[string]interface{}{"end": EndInterrupted, "error": cause.Error()}
+	case w.err != nil:
+		end = map[string]interface{}{"end": EndInterrupted, "error": w.err.Error()}
+	}
+	return w.add(end)
+}
+
+func (w *Writer) add(values map[string]interface{}) error {
+	_, err := w.client.TxPipelined(w.ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(w.ctx, &redis.XAddArgs{Stream: w.key, Values: values})
+		pipe.Expire(w.ctx, w.key, TTL)
+		return nil
+	})
+	return err
+}
+
+// Read returns the entries of a job's stream after the entry ID after ("0"
+// for the start), waiting up to wait for one to arrive, and the ID of the
+// last entry returned. No entries means none arrived in time.
+func Read(ctx context.Context, client *redis.Client, synthesisID int, after string, wait time.Duration) ([]Entry, string, error) {
+	streams, err := client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{key(synthesisID), after},
+		Block:   wait,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, after, nil
+	}
+	if err != nil {
+		return nil, after, err
+	}
+
+	entries := []Entry{}
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			after = msg.ID
+			entry := Entry{}
+			if v, ok := msg.Values["chunk"].(string); ok {
+				entry.Chunk = []byte(v)
+			}
+			entry.End, _ = msg.Values["end"].(string)
+			entry.Error, _ = msg.Values["error"].(string)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, after, nil
+}
+
+// Exists reports whether a job's stream can still be read
+func Exists(ctx context.Context, client *redis.Client, synthesisID int) (bool, error) {
+	n, err := client.Exists(ctx, key(synthesisID)).Result()
+	return n > 0, err
+}
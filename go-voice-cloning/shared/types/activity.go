@@ -0,0 +1,28 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of Activity. Clone activity uses the names of the lifecycle events
+// it is recorded with.
+const (
+	ActivityCloneCreated   = LifecycleCloneCreated
+	ActivityCloneCompleted = LifecycleCloneCompleted
+	ActivityCloneFailed    = LifecycleCloneFailed
+	ActivityFileUploaded   = "file.uploaded"
+	ActivityProfileUpdated = "profile.updated"
+	ActivityNewDeviceLogin = "login.new_device"
+)
+
+// Activity is an entry of a user's activity feed, recorded by the service
+// where it happened. Subject is the ID of the clone or file it is about, if
+// any; Details depend on the kind.
+type Activity struct {
+	ID         int64           `json:"id" db:"id"`
+	Kind       string          `json:"kind" db:"kind"`
+	Subject    string          `json:"subject,omitempty" db:"subject"`
+	Details    json.RawMessage `json:"details" db:"details"`
+	OccurredAt time.Time       `json:"occurred_at" db:"created_at"`
+}
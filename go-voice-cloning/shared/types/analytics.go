@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+// Analytics windows, in days
+const (
+	DefaultAnalyticsDays = 30
+	MaxAnalyticsDays     = 365
+)
+
+// CloneAnalytics summarizes a user's jobs over a window of days ending
+// today (UTC): a daily series, the window's totals, and how many of the
+// user's clones are in each status right now. Clone jobs are counted on the
+// day they were created, synthesis on the day it completed.
+type CloneAnalytics struct {
+	From   time.Time        `json:"from"`
+	To     time.Time        `json:"to"`
+	Days   []AnalyticsDay   `json:"days"`
+	Totals AnalyticsFigures `json:"totals"`
+	Clones map[string]int   `json:"clones"`
+}
+
+// AnalyticsDay is one day of CloneAnalytics, dated YYYY-MM-DD
+type AnalyticsDay struct {
+	Date string `json:"date"`
+	AnalyticsFigures
+}
+
+// AnalyticsFigures are the job figures of a day or a window. FailureRate
+// is the share of finished clone jobs that failed, and AvgProcessingSeconds
+// the mean time completed jobs spent processing; both are null without jobs
+// to measure. Syntheses counts the synthesis jobs completed.
+type AnalyticsFigures struct {
+	Jobs                 int      `json:"jobs"`
+	Completed            int      `json:"completed"`
+	Failed               int      `json:"failed"`
+	FailureRate          *float64 `json:"failure_rate"`
+	AvgProcessingSeconds *float64 `json:"avg_processing_seconds"`
+	SynthesisSeconds     float64  `json:"synthesis_seconds"`
+	Syntheses            int      `json:"syntheses"`
+}
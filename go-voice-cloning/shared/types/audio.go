@@ -0,0 +1,25 @@
+package types
+
+// AudioInfo is the format of a stored audio file as probed by the storage
+// service. Format is empty, and the other format fields zero, for files that
+// aren't WAV, FLAC, MP3 or Ogg audio. Codec is only set for Ogg files.
+// Variant is the version of the file described, its original or the
+// version normalized for the cloning engine, and Normalized whether it has
+// the latter. Quarantined files failed the malware scan and can't be used.
+// Version is the version of the file's content described.
+type AudioInfo struct {
+	ID            string `json:"id"`
+	Filename      string `json:"filename"`
+	UserID        *int   `json:"user_id,omitempty"`
+	SizeBytes     int64  `json:"size_bytes"`
+	Format        string `json:"format"`
+	Codec         string `json:"codec,omitempty"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	BitsPerSample int    `json:"bits_per_sample,omitempty"`
+	DurationMS    int64  `json:"duration_ms,omitempty"`
+	Variant       string `json:"variant,omitempty"`
+	Normalized    bool   `json:"normalized"`
+	Quarantined   bool   `json:"quarantined,omitempty"`
+	Version       int    `json:"version,omitempty"`
+}
@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+// Subscription is a user's paid plan as billed through Stripe. Status is
+// Stripe's subscription status; a plan is included while it is active,
+// trialing or past_due.
+type Subscription struct {
+	Plan              string     `json:"plan" db:"plan"`
+	Status            string     `json:"status,omitempty" db:"subscription_status"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty" db:"current_period_end"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end" db:"cancel_at_period_end"`
+}
+
+// CheckoutRequest starts the purchase of a paid plan
+type CheckoutRequest struct {
+	Plan string `json:"plan"`
+}
+
+// BillingSession is a Stripe-hosted page the client sends the user to:
+// Checkout to subscribe, or the billing portal to change or cancel
+type BillingSession struct {
+	ID  string `json:"id,omitempty"`
+	URL string `json:"url"`
+}
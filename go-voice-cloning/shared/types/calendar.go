@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// Calendar event types published by services
+const (
+	EventScheduledSynthesis = "synthesis.scheduled"
+	EventFileExpiration     = "file.expires"
+	EventQuotaReset         = "quota.reset"
+	EventPlanRenewal        = "plan.renews"
+)
+
+// CalendarEvent is an upcoming user-relevant event. Services expose their
+// events at GET /calendar?from=&to= (RFC 3339) for the user service to
+// aggregate.
+type CalendarEvent struct {
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	At       time.Time `json:"at"`
+	Source   string    `json:"source"`
+	Resource string    `json:"resource,omitempty"`
+}
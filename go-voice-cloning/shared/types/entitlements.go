@@ -0,0 +1,46 @@
+package types
+
+// Headers the gateway sets from the caller's entitlements. Values sent by
+// clients are dropped.
+const (
+	PlanHeader         = "X-User-Plan"
+	PriorityTierHeader = "X-Priority-Tier"
+)
+
+// Entitlements are what a plan includes: the voice service's limits, the
+// storage quota of each class and the highest priority tier its jobs may
+// request. A limit of 0 is unlimited.
+type Entitlements struct {
+	Plan string `json:"plan" db:"name"`
+	PlanLimits
+	SampleStorageBytes int64  `json:"sample_storage_bytes" db:"sample_storage_bytes"`
+	OutputStorageBytes int64  `json:"output_storage_bytes" db:"output_storage_bytes"`
+	MaxPriority        string `json:"max_priority" db:"max_priority"`
+	// BonusSynthesisMinutes are the minutes earned through referrals, already
+	// included in SynthesisMinutesPerMonth
+	BonusSynthesisMinutes int `json:"bonus_synthesis_minutes,omitempty" db:"-"`
+}
+
+// defaultPlanStorage is the sample and output storage of each plan, in bytes
+var defaultPlanStorage = map[string][2]int64{
+	PlanFree:       {1 << 30, 5 << 30},
+	PlanPro:        {20 << 30, 100 << 30},
+	PlanEnterprise: {0, 0},
+}
+
+// DefaultEntitlements are what a plan includes unless configured otherwise.
+// Unknown plans get the free plan's.
+func DefaultEntitlements(plan string) Entitlements {
+	limits, ok := DefaultPlanLimits[plan]
+	if !ok {
+		plan, limits = PlanFree, DefaultPlanLimits[PlanFree]
+	}
+	storage := defaultPlanStorage[plan]
+	return Entitlements{
+		Plan:               plan,
+		PlanLimits:         limits,
+		SampleStorageBytes: storage[0],
+		OutputStorageBytes: storage[1],
+		MaxPriority:        MaxPriority(plan),
+	}
+}
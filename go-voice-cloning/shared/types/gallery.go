@@ -0,0 +1,52 @@
+package types
+
+import "time"
+
+// Gallery listing moderation states
+const (
+	GalleryPendingReview = "pending_review"
+	GalleryApproved      = "approved"
+	GalleryRejected      = "rejected"
+	GalleryTakenDown     = "taken_down"
+)
+
+// GalleryCategories are the categories a published clone can be listed under
+var GalleryCategories = []string{"narration", "character", "assistant", "education", "entertainment", "other"}
+
+// AbuseReasons are the reasons a gallery listing can be reported for
+var AbuseReasons = []string{"impersonation", "copyright", "offensive", "spam", "other"}
+
+// GalleryListing is a clone published to the public gallery. Only approved
+// listings are visible to the public.
+type GalleryListing struct {
+	CloneID     int        `json:"clone_id" db:"clone_id"`
+	Title       string     `json:"title" db:"title"`
+	Description string     `json:"description" db:"description"`
+	Category    string     `json:"category" db:"category"`
+	Creator     string     `json:"creator" db:"creator"`
+	Status      string     `json:"status,omitempty" db:"status"`
+	ReviewNote  string     `json:"review_note,omitempty" db:"review_note"`
+	DemoLimit   int        `json:"demo_limit" db:"demo_limit"` // demos per visitor per day
+	SubmittedAt time.Time  `json:"submitted_at" db:"submitted_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty" db:"published_at"`
+}
+
+// GalleryPublishRequest publishes a clone or updates its listing, which
+// sends it back to review
+type GalleryPublishRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	DemoLimit   *int   `json:"demo_limit,omitempty"`
+}
+
+// AbuseReport is a visitor's report against a gallery listing
+type AbuseReport struct {
+	ID         int        `json:"id" db:"id"`
+	CloneID    int        `json:"clone_id" db:"clone_id"`
+	Reason     string     `json:"reason" db:"reason"`
+	Details    string     `json:"details" db:"details"`
+	Status     string     `json:"status" db:"status"` // open, resolved, dismissed
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+}
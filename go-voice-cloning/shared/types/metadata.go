@@ -0,0 +1,34 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// CloneMetadata is free-form JSON a user attaches to a clone, such as the
+// project or character it belongs to
+type CloneMetadata map[string]interface{}
+
+// Value stores metadata as JSON
+func (m CloneMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan reads metadata stored as JSON
+func (m *CloneMetadata) Scan(src interface{}) error {
+	*m = CloneMetadata{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("cannot scan %T into CloneMetadata", src)
+	}
+}
@@ -0,0 +1,62 @@
+package types
+
+import "time"
+
+// Headers of a request made on behalf of an org. Clients set OrgIDHeader;
+// the gateway checks the caller is a member and sets OrgRoleHeader to their
+// role.
+const (
+	OrgIDHeader   = "X-Org-ID"
+	OrgRoleHeader = "X-Org-Role"
+)
+
+// Roles of an org member. Owners manage the org and its admins; admins
+// invite and remove members; members use what the org owns.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrgRoles lists the member roles, most privileged first
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+// Organization is a team whose members share voice clones and files
+type Organization struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedBy int       `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Role is the caller's role in the org, when listing their orgs
+	Role string `json:"role,omitempty" db:"role"`
+}
+
+// OrgMember is a user's membership of an org
+type OrgMember struct {
+	OrgID    int       `json:"org_id" db:"org_id"`
+	UserID   int       `json:"user_id" db:"user_id"`
+	Email    string    `json:"email,omitempty" db:"email"`
+	Username string    `json:"username,omitempty" db:"username"`
+	Role     string    `json:"role" db:"role"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// OrgInvitation invites an email address to join an org with a role. The
+// token is only returned when the invitation is created.
+type OrgInvitation struct {
+	ID         int        `json:"id" db:"id"`
+	OrgID      int        `json:"org_id" db:"org_id"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	Token      string     `json:"token,omitempty" db:"-"`
+	InvitedBy  int        `json:"invited_by" db:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+}
+
+// OrgInvitationRequest invites a user to an org
+type OrgInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
@@ -0,0 +1,156 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that were not issued by
+// EncodeCursor, or for another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest is the page of a listing a client asked for, read from
+// ?limit= and ?cursor=. Cursor is empty for the first page.
+type PageRequest struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Pagination describes the position of a page within a listing. NextCursor
+// is empty on the last page.
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PageResponse is the envelope shared by all paginated listings
+type PageResponse struct {
+	Data       interface{} `json:"data"`
+	Pagination Pagination  `json:"pagination"`
+}
+
+// ParseLimit reads the limit query parameter, applying a default and a cap
+func ParseLimit(q url.Values, defaultLimit, maxLimit int) (int, error) {
+	v := q.Get("limit")
+	if v == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return limit, nil
+}
+
+// ParsePageRequest reads ?limit= and ?cursor=, applying a default limit and
+// a cap
+func ParsePageRequest(q url.Values, defaultLimit, maxLimit int) (PageRequest, error) {
+	limit, err := ParseLimit(q, defaultLimit, maxLimit)
+	if err != nil {
+		return PageRequest{}, err
+	}
+	return PageRequest{Limit: limit, Cursor: q.Get("cursor")}, nil
+}
+
+// Position decodes the cursor into position and reports whether there was
+// one
+func (p PageRequest) Position(position interface{}) (bool, error) {
+	if p.Cursor == "" {
+		return false, nil
+	}
+	return true, DecodeCursor(p.Cursor, position)
+}
+
+// LimitClause is the LIMIT of the page's query. It fetches one row more
+// than the page holds, which tells NewPage whether another page follows.
+func (p PageRequest) LimitClause(arg func(interface{}) string) string {
+	return "LIMIT " + arg(p.Limit+1)
+}
+
+// NewPage builds the response for the rows of a query limited by
+// LimitClause: the extra row is dropped, and position returns the cursor
+// position after the last item when another page follows
+func NewPage[T any](p PageRequest, items []T, total int, position func(last T) interface{}) PageResponse {
+	page := Pagination{Limit: p.Limit, Total: total}
+	if len(items) > p.Limit {
+		items = items[:p.Limit]
+		page.NextCursor = EncodeCursor(position(items[len(items)-1]))
+	}
+	return PageResponse{Data: items, Pagination: page}
+}
+
+// PageSort is the order of a listing, read from ?sort=: a sort name,
+// prefixed with - for descending. Rows are ordered by the sort's column,
+// then by id so keyset cursors are stable.
+type PageSort struct {
+	Name       string
+	Column     string
+	Descending bool
+}
+
+// ParseSort reads ?sort=, which must be one of the names columns maps to
+// their columns, and uses defaultSort without it
+func ParseSort(q url.Values, columns map[string]string, defaultSort string) (PageSort, error) {
+	name := q.Get("sort")
+	if name == "" {
+		name = defaultSort
+	}
+	column, ok := columns[strings.TrimPrefix(name, "-")]
+	if !ok {
+		names := make([]string, 0, len(columns))
+		for n := range columns {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return PageSort{}, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+	}
+	return PageSort{Name: name, Column: column, Descending: strings.HasPrefix(name, "-")}, nil
+}
+
+// OrderBy is the ORDER BY of the listing's query
+func (s PageSort) OrderBy() string {
+	direction := "ASC"
+	if s.Descending {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, id %s", s.Column, direction, direction)
+}
+
+// After is the WHERE condition selecting the rows after the cursor
+// position: a row whose sort column is value and whose id is id
+func (s PageSort) After(value, id interface{}, arg func(interface{}) string) string {
+	op := ">"
+	if s.Descending {
+		op = "<"
+	}
+	if s.Column == "id" {
+		return fmt.Sprintf("id %s %s", op, arg(id))
+	}
+	return fmt.Sprintf("(%s, id) %s (%s, %s)", s.Column, op, arg(value), arg(id))
+}
+
+// EncodeCursor serializes the position after the last item of a page into
+// an opaque token
+func EncodeCursor(position interface{}) string {
+	data, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor restores a position produced by EncodeCursor
+func DecodeCursor(cursor string, position interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
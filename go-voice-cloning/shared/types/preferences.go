@@ -0,0 +1,36 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Preference keys. Preferences are a flat set of dotted keys, so a client
+// can change one without sending the others.
+const (
+	PrefSynthesisLanguage     = "synthesis.language"
+	PrefSynthesisOutputFormat = "synthesis.output_format"
+	PrefNotificationChannels  = "notifications.channels"
+	PrefNotifyCloneCreated    = "notifications.clone_created"
+	PrefNotifyCloneCompleted  = "notifications.clone_completed"
+	PrefNotifyFileUploaded    = "notifications.file_uploaded"
+	PrefNotifyWeeklyDigest    = "notifications.weekly_digest"
+	PrefNotifySecurityAlerts  = "notifications.security_alerts"
+	PrefQuietHoursStart       = "notifications.quiet_hours.start"
+	PrefQuietHoursEnd         = "notifications.quiet_hours.end"
+	PrefProfilePublic         = "profile.public"
+	PrefProfileSearchable     = "profile.searchable"
+	PrefUITheme               = "ui.theme"
+	PrefUITimezone            = "ui.timezone"
+	PrefUICompact             = "ui.compact"
+)
+
+// Preferences are a user's settings. Values holds every known key, with
+// the default for those the user hasn't set, which are listed in Defaulted.
+// Version is the schema version the stored preferences were migrated to.
+type Preferences struct {
+	Version   int                        `json:"version"`
+	Values    map[string]json.RawMessage `json:"preferences"`
+	Defaulted []string                   `json:"defaulted"`
+	UpdatedAt *time.Time                 `json:"updated_at,omitempty"`
+}
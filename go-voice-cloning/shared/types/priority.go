@@ -0,0 +1,41 @@
+package types
+
+// Clone job priority tiers
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Priorities lists the tiers from highest to lowest
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// Subscription plans, stored in users.plan
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+// Plans lists the subscription plans, from free to enterprise
+var Plans = []string{PlanFree, PlanPro, PlanEnterprise}
+
+// PriorityRank orders tiers, higher ranks first. Unknown tiers rank -1.
+func PriorityRank(priority string) int {
+	for i, p := range Priorities {
+		if p == priority {
+			return len(Priorities) - i
+		}
+	}
+	return -1
+}
+
+// MaxPriority is the highest tier a plan may request, and the tier its jobs
+// get by default. Paid plans jump ahead of free ones.
+func MaxPriority(plan string) string {
+	switch plan {
+	case PlanPro, PlanEnterprise:
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// QualityMetrics rate the audio a model was trained on, and how close the
+// clone's preview sounds to it. The worker computes them once training
+// completes; a metric it can't measure, such as for FLAC sources, is left
+// out. Warnings name the thresholds below that a metric fell short of.
+type QualityMetrics struct {
+	SimilarityScore    *float64 `json:"similarity_score,omitempty"` // 0-1
+	SNRDB              *float64 `json:"snr_db,omitempty"`
+	UsableAudioSeconds *float64 `json:"usable_audio_seconds,omitempty"`
+	TotalAudioSeconds  *float64 `json:"total_audio_seconds,omitempty"`
+	Warnings           []string `json:"warnings,omitempty"`
+}
+
+// Quality thresholds below which a clone is likely to sound poor
+const (
+	MinSimilarityScore    = 0.7
+	MinSNRDB              = 15
+	MinUsableAudioSeconds = 30
+)
+
+// Quality warnings
+const (
+	QualityWarningLowSimilarity = "low_similarity"
+	QualityWarningNoisy         = "noisy_audio"
+	QualityWarningShort         = "insufficient_speech"
+)
+
+// Value stores metrics as JSON
+func (q QualityMetrics) Value() (driver.Value, error) {
+	return json.Marshal(q)
+}
+
+// Scan reads metrics stored as JSON
+func (q *QualityMetrics) Scan(src interface{}) error {
+	*q = QualityMetrics{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, q)
+	case string:
+		return json.Unmarshal([]byte(v), q)
+	default:
+		return fmt.Errorf("cannot scan %T into QualityMetrics", src)
+	}
+}
@@ -0,0 +1,74 @@
+package types
+
+import "time"
+
+// PlanLimits caps what a plan's users can do in the voice service. A limit
+// of 0 is unlimited. Completed clones unused for InactiveCloneDays expire.
+// MaxProcessingJobs caps how many of a user's jobs, synthesis included, the
+// worker fleet runs at once; the rest wait in the queue.
+type PlanLimits struct {
+	MaxClones                int `json:"max_clones" db:"max_clones"`
+	MaxConcurrentJobs        int `json:"max_concurrent_jobs" db:"max_concurrent_jobs"`
+	MaxProcessingJobs        int `json:"max_processing_jobs" db:"max_processing_jobs"`
+	SynthesisMinutesPerMonth int `json:"synthesis_minutes_per_month" db:"synthesis_minutes_per_month"`
+	InactiveCloneDays        int `json:"inactive_clone_days" db:"inactive_clone_days"`
+}
+
+// DefaultPlanLimits are the limits of each plan unless configured otherwise
+var DefaultPlanLimits = map[string]PlanLimits{
+	PlanFree:       {MaxClones: 5, MaxConcurrentJobs: 1, MaxProcessingJobs: 1, SynthesisMinutesPerMonth: 30, InactiveCloneDays: 90},
+	PlanPro:        {MaxClones: 50, MaxConcurrentJobs: 3, MaxProcessingJobs: 2, SynthesisMinutesPerMonth: 600, InactiveCloneDays: 365},
+	PlanEnterprise: {MaxClones: 0, MaxConcurrentJobs: 10, MaxProcessingJobs: 4, SynthesisMinutesPerMonth: 0, InactiveCloneDays: 0},
+}
+
+// Quotas reported by the quota endpoint and named in quota errors
+const (
+	QuotaClones           = "clones"
+	QuotaConcurrentJobs   = "concurrent_jobs"
+	QuotaProcessingJobs   = "processing_jobs"
+	QuotaSynthesisMinutes = "synthesis_minutes"
+)
+
+// QuotaAllowance is the use of one quota. Limit and Remaining are omitted
+// when the plan has no limit.
+type QuotaAllowance struct {
+	Used      float64  `json:"used"`
+	Limit     *float64 `json:"limit,omitempty"`
+	Remaining *float64 `json:"remaining,omitempty"`
+}
+
+// QuotaReport is a user's remaining allowance under their plan. Synthesis
+// minutes are counted per calendar month (UTC). InactiveCloneDays is
+// omitted when the plan's clones don't expire. ProcessingJobs counts the
+// user's jobs the workers are running; jobs past its limit wait their turn.
+type QuotaReport struct {
+	Plan              string         `json:"plan"`
+	InactiveCloneDays int            `json:"inactive_clone_days,omitempty"`
+	Clones            QuotaAllowance `json:"clones"`
+	ConcurrentJobs    QuotaAllowance `json:"concurrent_jobs"`
+	ProcessingJobs    QuotaAllowance `json:"processing_jobs"`
+	SynthesisMinutes  QuotaAllowance `json:"synthesis_minutes"`
+	PeriodStart       time.Time      `json:"period_start"`
+	PeriodEnd         time.Time      `json:"period_end"`
+}
+
+// QuotaStorage names storage quota errors, which also carry the class
+const QuotaStorage = "storage"
+
+// StorageClassUsage is a user's consumption of one storage class. A
+// LimitBytes or RetentionDays of 0 is unlimited.
+type StorageClassUsage struct {
+	Files         int   `json:"files" db:"files"`
+	Bytes         int64 `json:"bytes" db:"bytes"`
+	LimitBytes    int64 `json:"limit_bytes" db:"limit_bytes"`
+	RetentionDays int   `json:"retention_days"`
+}
+
+// StorageUsage is a user's stored bytes as reported by the storage service,
+// for the user service and billing
+type StorageUsage struct {
+	UserID     int               `json:"user_id"`
+	Samples    StorageClassUsage `json:"samples"`
+	Outputs    StorageClassUsage `json:"outputs"`
+	TotalBytes int64             `json:"total_bytes"`
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CloneSettings are the processing options of a clone. The same shape is
+// used for org and user defaults, where empty fields inherit from the
+// layer below. Language is a BCP 47 tag; Locale optionally picks an accent
+// of it, such as en-GB for en.
+type CloneSettings struct {
+	Model         string                `json:"model,omitempty"`
+	OutputFormats []string              `json:"output_formats,omitempty"`
+	Preprocessing *PreprocessingOptions `json:"preprocessing,omitempty"`
+	Language      string                `json:"language,omitempty"`
+	Locale        string                `json:"locale,omitempty"`
+}
+
+// PreprocessingOptions toggles the steps applied to source audio
+type PreprocessingOptions struct {
+	Denoise     *bool `json:"denoise,omitempty"`
+	Normalize   *bool `json:"normalize,omitempty"`
+	TrimSilence *bool `json:"trim_silence,omitempty"`
+}
+
+// Merge returns s with every field set in override replaced
+func (s CloneSettings) Merge(override CloneSettings) CloneSettings {
+	if override.Model != "" {
+		s.Model = override.Model
+	}
+	if len(override.OutputFormats) > 0 {
+		s.OutputFormats = override.OutputFormats
+	}
+	if override.Language != "" {
+		// An inherited accent of another language no longer applies
+		if override.Locale == "" && !samePrimaryTag(s.Locale, override.Language) {
+			s.Locale = ""
+		}
+		s.Language = override.Language
+	}
+	if override.Locale != "" {
+		s.Locale = override.Locale
+	}
+	if override.Preprocessing != nil {
+		merged := PreprocessingOptions{}
+		if s.Preprocessing != nil {
+			merged = *s.Preprocessing
+		}
+		if override.Preprocessing.Denoise != nil {
+			merged.Denoise = override.Preprocessing.Denoise
+		}
+		if override.Preprocessing.Normalize != nil {
+			merged.Normalize = override.Preprocessing.Normalize
+		}
+		if override.Preprocessing.TrimSilence != nil {
+			merged.TrimSilence = override.Preprocessing.TrimSilence
+		}
+		s.Preprocessing = &merged
+	}
+	return s
+}
+
+func samePrimaryTag(a, b string) bool {
+	a, _, _ = strings.Cut(a, "-")
+	b, _, _ = strings.Cut(b, "-")
+	return strings.EqualFold(a, b)
+}
+
+// Value stores settings as JSON
+func (s CloneSettings) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan reads settings stored as JSON
+func (s *CloneSettings) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = CloneSettings{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("cannot scan %T into CloneSettings", src)
+	}
+}
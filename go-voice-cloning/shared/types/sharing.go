@@ -0,0 +1,83 @@
+package types
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Clone visibilities. A private clone is usable only by its owner, a shared
+// clone also by the users it was shared with, and a public clone by every
+// user through the voice library.
+const (
+	VisibilityPrivate = "private"
+	VisibilityShared  = "shared"
+	VisibilityPublic  = "public"
+)
+
+// Visibilities lists the valid clone visibilities
+var Visibilities = []string{VisibilityPrivate, VisibilityShared, VisibilityPublic}
+
+// File ACLs. A private file can be read only by its owner, a shared file
+// also by the users it was shared with, and a public-read file by anyone,
+// such as a preview sample.
+const (
+	FileACLPrivate    = "private"
+	FileACLShared     = "shared"
+	FileACLPublicRead = "public-read"
+)
+
+// FileACLs lists the valid file ACLs
+var FileACLs = []string{FileACLPrivate, FileACLShared, FileACLPublicRead}
+
+// FileACLRequest sets who can read a file. Users, when given, replace the
+// users it's shared with.
+type FileACLRequest struct {
+	ACL   string `json:"acl"`
+	Users []int  `json:"users"`
+}
+
+// PreviewACL is the ACL of a clone's previews, so the users who can use a
+// clone can listen to it
+func PreviewACL(visibility string) string {
+	switch visibility {
+	case VisibilityPublic:
+		return FileACLPublicRead
+	case VisibilityShared:
+		return FileACLShared
+	default:
+		return FileACLPrivate
+	}
+}
+
+// CloneVisibilityRequest changes who can use a clone
+type CloneVisibilityRequest struct {
+	Visibility string `json:"visibility"`
+}
+
+// CloneShare grants a user synthesis access to another user's clone
+type CloneShare struct {
+	CloneID   int       `json:"clone_id" db:"clone_id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	GrantedBy int       `json:"granted_by" db:"granted_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CloneShareRequest shares a clone with a user, identified by ID or email
+type CloneShareRequest struct {
+	UserID int    `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+}
+
+// LibraryClone is a clone listed in the voice library: a public clone, or
+// one shared with the caller
+type LibraryClone struct {
+	ID          int            `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	Tags        pq.StringArray `json:"tags" db:"tags"`
+	Owner       string         `json:"owner" db:"owner"`
+	Visibility  string         `json:"visibility" db:"visibility"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+}
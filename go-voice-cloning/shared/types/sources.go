@@ -0,0 +1,35 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SourceVersions pins source files, by ID, to a version of their content in
+// the storage service, so retraining on them is reproducible after a file
+// is replaced. Sources missing from it use their latest version.
+type SourceVersions map[string]int
+
+// Value stores the versions as JSON
+func (v SourceVersions) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+// Scan reads versions stored as JSON
+func (v *SourceVersions) Scan(src interface{}) error {
+	*v = SourceVersions{}
+	switch b := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(b, v)
+	case string:
+		return json.Unmarshal([]byte(b), v)
+	default:
+		return fmt.Errorf("cannot scan %T into SourceVersions", src)
+	}
+}
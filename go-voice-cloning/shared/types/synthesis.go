@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+// MaxSynthesisText is the longest text or SSML document accepted for one
+// synthesis job, in characters
+const MaxSynthesisText = 5000
+
+// SynthesisRequest asks a completed clone to speak text. Exactly one of Text
+// and SSML is set; SSML must be a <speak> document. ModelVersion pins a
+// completed model version of the clone; by default its current one speaks.
+type SynthesisRequest struct {
+	Text         string `json:"text,omitempty"`
+	SSML         string `json:"ssml,omitempty"`
+	ModelVersion int    `json:"model_version,omitempty"`
+}
+
+// SynthesisJob is speech generated with a clone's voice. Its status follows
+// the clone statuses pending, processing, completed and failed. ModelVersion
+// is set when the request pinned a model version.
+type SynthesisJob struct {
+	ID           int        `json:"id" db:"id"`
+	CloneID      int        `json:"clone_id" db:"clone_id"`
+	UserID       int        `json:"user_id" db:"user_id"`
+	Text         string     `json:"text,omitempty" db:"text"`
+	SSML         string     `json:"ssml,omitempty" db:"ssml"`
+	ModelVersion int        `json:"model_version,omitempty" db:"model_version"`
+	Status       string     `json:"status" db:"status"`
+	OutputFile   string     `json:"output_file,omitempty" db:"output_file"`
+	Error        string     `json:"error,omitempty" db:"error"`
+	DurationMS   int64      `json:"duration_ms,omitempty" db:"duration_ms"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	DownloadURL  string     `json:"download_url,omitempty" db:"-"`
+}
@@ -0,0 +1,34 @@
+package types
+
+import "time"
+
+// UploadEventsChannel is the Postgres NOTIFY channel carrying UploadEvent
+// payloads
+const UploadEventsChannel = "upload_events"
+
+// Kinds of UploadEvent
+const (
+	UploadEventProgress  = "progress"
+	UploadEventCompleted = "completed"
+)
+
+// UploadEvent reports how much of a resumable upload the storage service
+// has received, so clients can show its progress
+type UploadEvent struct {
+	Kind          string    `json:"kind"`
+	UploadID      string    `json:"upload_id"`
+	UserID        int       `json:"user_id"`
+	Filename      string    `json:"filename"`
+	ReceivedBytes int64     `json:"received_bytes"`
+	ExpectedBytes int64     `json:"expected_bytes"`
+	Percent       int       `json:"percent"`
+	OccurredAt    time.Time `json:"occurred_at"`
+}
+
+// UploadPercent is the whole percentage of expected bytes received
+func UploadPercent(received, expected int64) int {
+	if expected <= 0 || received >= expected {
+		return 100
+	}
+	return int(received * 100 / expected)
+}
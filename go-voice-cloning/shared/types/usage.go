@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// Metered quantities. The services record each as it is consumed, keyed by
+// its source so recording it again doesn't count it twice. Storage is a
+// gauge: the storage service records each user's stored bytes periodically.
+const (
+	UsageAudioProcessedMS    = "audio_processed_ms"
+	UsageSynthesisMS         = "synthesis_ms"
+	UsageSynthesisCharacters = "synthesis_characters"
+	UsageStorageBytes        = "storage_bytes"
+)
+
+// UsageMonth is a user's metered consumption in a calendar month (UTC).
+// StorageBytes is the latest measurement of the month, StorageBytesPeak the
+// highest.
+type UsageMonth struct {
+	Period                time.Time `json:"period" db:"period"`
+	AudioProcessedMinutes float64   `json:"audio_processed_minutes" db:"-"`
+	SynthesisMinutes      float64   `json:"synthesis_minutes" db:"-"`
+	SynthesisCharacters   int64     `json:"synthesis_characters" db:"synthesis_characters"`
+	StorageBytes          int64     `json:"storage_bytes" db:"storage_bytes"`
+	StorageBytesPeak      int64     `json:"storage_bytes_peak" db:"storage_bytes_peak"`
+}
+
+// UsageReport is a user's monthly consumption, most recent month first
+type UsageReport struct {
+	UserID int          `json:"user_id"`
+	Months []UsageMonth `json:"months"`
+}
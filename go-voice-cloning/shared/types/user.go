@@ -2,22 +2,112 @@ package types
 
 import "time"
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	Username  string    `json:"username" db:"username"`
-	Password  string    `json:"-" db:"password"` // Never return password in JSON
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID           int       `json:"id" db:"id"`
+	Email        string    `json:"email" db:"email"`
+	Username     string    `json:"username" db:"username"`
+	Password     string    `json:"-" db:"password"` // Never return password in JSON
+	PasswordAlgo string    `json:"-" db:"password_algo"`
+	Role         string    `json:"role" db:"role"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
-// UserProfile extends user with additional profile information
+// UserProfile extends user with additional profile information. Timezone
+// (IANA) and Locale (BCP 47) localize the times shown and sent to the user.
+// AvatarURL is the user's avatar at its largest size, if they uploaded one.
 type UserProfile struct {
 	User
 	FirstName string `json:"first_name" db:"first_name"`
 	LastName  string `json:"last_name" db:"last_name"`
 	Bio       string `json:"bio" db:"bio"`
+	Timezone  string `json:"timezone" db:"timezone"`
+	Locale    string `json:"locale" db:"locale"`
+	AvatarURL string `json:"avatar_url,omitempty" db:"avatar_url"`
+}
+
+// UserSummary is the least needed to pick a user, such as the recipient of
+// a share or an org invitation
+type UserSummary struct {
+	ID        int    `json:"id" db:"id"`
+	Username  string `json:"username" db:"username"`
+	AvatarURL string `json:"avatar_url,omitempty" db:"avatar_url"`
+}
+
+// PublicProfile is what a user who opted in shows to anyone: their display
+// name (first and last name, or their username without one), bio, avatar
+// and completed public clones. No other field is read for it.
+type PublicProfile struct {
+	Username    string         `json:"username" db:"username"`
+	DisplayName string         `json:"display_name" db:"display_name"`
+	Bio         string         `json:"bio" db:"bio"`
+	AvatarURL   string         `json:"avatar_url,omitempty" db:"avatar_url"`
+	Clones      []LibraryClone `json:"clones" db:"-"`
+}
+
+// ReferralSummary is a user's referral code, the link to share it with and
+// what it has brought them
+type ReferralSummary struct {
+	Code                  string `json:"code"`
+	Link                  string `json:"link"`
+	Conversions           int    `json:"conversions"`
+	BonusSynthesisMinutes int    `json:"bonus_synthesis_minutes"`
+	// MaxBonusMinutes caps the bonus however many users sign up; 0 is no cap
+	MaxBonusMinutes int `json:"max_bonus_minutes"`
+}
+
+// ReferralConversion is a user who registered with a referral code
+type ReferralConversion struct {
+	UserID   int       `json:"user_id" db:"user_id"`
+	Username string    `json:"username" db:"username"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// DirectoryUser is a user as the admin directory lists them
+type DirectoryUser struct {
+	User
+	Plan      string     `json:"plan" db:"plan"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// DirectoryUserDetail is a user's profile in the admin directory, with the
+// number of their clones in each status and their storage usage. Either
+// summary is left out when its service is unavailable.
+type DirectoryUserDetail struct {
+	UserProfile
+	Plan      string         `json:"plan" db:"plan"`
+	DeletedAt *time.Time     `json:"deleted_at,omitempty" db:"deleted_at"`
+	Clones    map[string]int `json:"clones,omitempty" db:"-"`
+	Storage   *StorageUsage  `json:"storage,omitempty" db:"-"`
+}
+
+// Avatar is a user's profile picture: the uploaded image, kept private, and
+// square variants anyone can load, keyed by their size in pixels
+type Avatar struct {
+	URL         string            `json:"url"`
+	OriginalURL string            `json:"original_url"`
+	Variants    map[string]string `json:"variants"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	ContentType string            `json:"content_type"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// UserFilesPurge reports the storage service's removal of a deleted user's
+// files, unfinished uploads and folders. FilesFailed lists the files that
+// couldn't be removed and are kept for a retry.
+type UserFilesPurge struct {
+	UserID           int      `json:"user_id"`
+	FilesDeleted     int      `json:"files_deleted"`
+	FilesFailed      []string `json:"files_failed"`
+	UploadsCancelled int      `json:"uploads_cancelled"`
 }
 
 // RegisterRequest represents a user registration request
@@ -25,6 +115,11 @@ type RegisterRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Username string `json:"username" validate:"required,min=3,max=20"`
 	Password string `json:"password" validate:"required,min=8"`
+	// CaptchaToken is the client-side challenge response, required when the
+	// auth service has a CAPTCHA provider configured
+	CaptchaToken string `json:"captcha_token,omitempty"`
+	// ReferralCode is the code of the user who referred them, if any
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 // LoginRequest represents a user login request
@@ -40,4 +135,21 @@ type AuthResponse struct {
 	User      User      `json:"user"`
 }
 
+// UserEventsChannel is the Postgres NOTIFY channel announcing new user_events
+// rows; the payload is the event ID
+const UserEventsChannel = "user_events"
+
+// Kinds of UserEvent
+const (
+	UserEventDeleted = "user_deleted"
+)
 
+// UserEvent is an account lifecycle event recorded in the user_events
+// outbox. Consumers track which events they have handled themselves.
+type UserEvent struct {
+	ID        int       `json:"id" db:"id"`
+	Kind      string    `json:"kind" db:"kind"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	ActorID   *int      `json:"actor_id,omitempty" db:"actor_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -1,31 +1,215 @@
 package types
 
-import "time"
+import (
+	"time"
 
-// VoiceClone represents a voice cloning job
+	"github.com/lib/pq"
+)
+
+// VoiceClone represents a voice cloning job. ErrorCode and ErrorMessage say
+// why a failed clone failed. ModelVersion is the model synthesis uses unless
+// a request pins another, Quality rates it and PreviewFile is it speaking
+// the standard preview phrase. ProcessAfter is set while a scheduled job
+// waits for its time, and ExpiresAt once an inactive clone is due to
+// expire, which exempt clones never are. Archived clones are kept out of
+// listings; deleted ones are purged once their retention ends.
 type VoiceClone struct {
-	ID          int       `json:"id" db:"id"`
-	UserID      int       `json:"user_id" db:"user_id"`
-	Name        string    `json:"name" db:"name"`
-	Status      string    `json:"status" db:"status"` // pending, processing, completed, failed
-	SourceFile  string    `json:"source_file" db:"source_file"`
-	OutputFile  string    `json:"output_file,omitempty" db:"output_file"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
-	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ID              int             `json:"id" db:"id"`
+	UserID          int             `json:"user_id" db:"user_id"`
+	OrgID           *int            `json:"org_id,omitempty" db:"org_id"` // the org sharing it among its members
+	Name            string          `json:"name" db:"name"`
+	Description     string          `json:"description" db:"description"`
+	Tags            pq.StringArray  `json:"tags" db:"tags"`
+	Metadata        CloneMetadata   `json:"metadata" db:"metadata"`
+	Status          string          `json:"status" db:"status"` // pending, processing, completed, failed, cancelled
+	SourceFile      string          `json:"source_file" db:"source_file"`
+	SourceFiles     []string        `json:"source_files,omitempty" db:"-"`
+	SourceVersions  SourceVersions  `json:"source_versions,omitempty" db:"-"`
+	OutputFile      string          `json:"output_file,omitempty" db:"output_file"`
+	PreviewFile     string          `json:"preview_file,omitempty" db:"preview_file"`
+	CallbackURL     string          `json:"callback_url,omitempty" db:"callback_url"`
+	Settings        CloneSettings   `json:"settings" db:"settings"`
+	RetryCount      int             `json:"retry_count" db:"retry_count"`
+	Priority        string          `json:"priority" db:"priority"`
+	Visibility      string          `json:"visibility" db:"visibility"`
+	Progress        int             `json:"progress" db:"progress"` // 0-100
+	Stage           string          `json:"stage,omitempty" db:"stage"`
+	ErrorCode       string          `json:"error_code,omitempty" db:"error_code"`
+	ErrorMessage    string          `json:"error_message,omitempty" db:"error_message"`
+	ModelVersion    int             `json:"model_version,omitempty" db:"model_version"`
+	Quality         *QualityMetrics `json:"quality,omitempty" db:"quality"`
+	ProcessAfter    *time.Time      `json:"process_after,omitempty" db:"process_after"`
+	ExpiresAt       *time.Time      `json:"expires_at,omitempty" db:"expires_at"`
+	RetentionExempt bool            `json:"retention_exempt,omitempty" db:"retention_exempt"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
+	CompletedAt     *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
+	ArchivedAt      *time.Time      `json:"archived_at,omitempty" db:"archived_at"`
+	DeletedAt       *time.Time      `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
-// VoiceCloneRequest represents a request to create a voice clone
+// MaxCloneSources is the most source audio files a clone can be trained on
+const MaxCloneSources = 20
+
+// VoiceCloneRequest represents a request to create a voice clone. The source
+// audio is SourceFiles, in order; SourceFile is a single source kept for
+// older clients, and counts as the first when both are set. SourceVersions
+// pins sources to earlier versions; the others are pinned to their current
+// version.
 type VoiceCloneRequest struct {
-	Name       string `json:"name" validate:"required"`
-	SourceFile string `json:"source_file" validate:"required"`
+	Name           string         `json:"name" validate:"required"`
+	SourceFile     string         `json:"source_file,omitempty"`
+	SourceFiles    []string       `json:"source_files,omitempty"`
+	SourceVersions SourceVersions `json:"source_versions,omitempty"`
+	Description    string         `json:"description,omitempty"`
+	Tags           []string       `json:"tags,omitempty"`
+	Metadata       CloneMetadata  `json:"metadata,omitempty"`
+	// CallbackURL receives signed status transition webhooks for this clone
+	CallbackURL string `json:"callback_url,omitempty" validate:"omitempty,url"`
+	// Priority defaults to the highest tier the user's plan allows
+	Priority string `json:"priority,omitempty"`
+	// ProcessAfter defers the job until then, such as to train a batch
+	// off-peak; the job runs right away without it
+	ProcessAfter *time.Time `json:"process_after,omitempty"`
+	// Settings omitted here are filled from the user's and org's defaults
+	CloneSettings
+}
+
+// VoiceCloneUpdateRequest is a partial update of a clone's metadata. Omitted
+// fields are left unchanged. UpdatedAt, like an If-Match header, rejects the
+// update if the clone changed since it was read. Metadata replaces the whole
+// object; send {} to clear it.
+type VoiceCloneUpdateRequest struct {
+	Name        *string        `json:"name,omitempty"`
+	Description *string        `json:"description,omitempty"`
+	Tags        *[]string      `json:"tags,omitempty"`
+	Metadata    *CloneMetadata `json:"metadata,omitempty"`
+	UpdatedAt   *time.Time     `json:"updated_at,omitempty"`
 }
 
 // VoiceCloneResponse represents the response after creating a voice clone job
 type VoiceCloneResponse struct {
-	ID     int    `json:"id"`
-	Status string `json:"status"`
-	Message string `json:"message"`
+	ID           int        `json:"id"`
+	Status       string     `json:"status"`
+	Message      string     `json:"message"`
+	ProcessAfter *time.Time `json:"process_after,omitempty"`
+}
+
+// Pipeline stages that produce clone artifacts
+const (
+	StagePreprocessing = "preprocessing"
+	StageTraining      = "training"
+	StageEvaluation    = "evaluation"
+	StageSynthesis     = "synthesis"
+)
+
+// CloneArtifact is an intermediate file produced by a pipeline stage
+type CloneArtifact struct {
+	ID          int       `json:"id" db:"id"`
+	CloneID     int       `json:"clone_id" db:"clone_id"`
+	Stage       string    `json:"stage" db:"stage"`
+	Kind        string    `json:"kind" db:"kind"` // preprocessed_sample, evaluation_report, spectrogram
+	File        string    `json:"file" db:"file"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	DownloadURL string    `json:"download_url" db:"-"`
+}
+
+// CloneModel is a version of a clone's trained model. Version 1 comes from
+// the clone job; retraining adds the next version, and the old ones stay
+// usable. Its status follows the clone statuses; deleting the clone cancels
+// a retraining. PreviewFile is the version speaking the preview phrase.
+type CloneModel struct {
+	CloneID        int             `json:"clone_id" db:"clone_id"`
+	Version        int             `json:"version" db:"version"`
+	Status         string          `json:"status" db:"status"`
+	SourceFiles    pq.StringArray  `json:"source_files" db:"source_files"`
+	SourceVersions SourceVersions  `json:"source_versions,omitempty" db:"source_versions"`
+	Progress       int             `json:"progress" db:"progress"`
+	ErrorCode      string          `json:"error_code,omitempty" db:"error_code"`
+	ErrorMessage   string          `json:"error_message,omitempty" db:"error_message"`
+	Quality        *QualityMetrics `json:"quality,omitempty" db:"quality"`
+	PreviewFile    string          `json:"preview_file,omitempty" db:"preview_file"`
+	Current        bool            `json:"current" db:"current"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty" db:"completed_at"`
 }
 
+// RetrainRequest asks for a new model version of a completed clone. Omitted
+// SourceFiles retrain on the clone's current sources, at the versions the
+// clone was trained on. SourceVersions pins given sources like
+// VoiceCloneRequest's.
+type RetrainRequest struct {
+	SourceFiles    []string       `json:"source_files,omitempty"`
+	SourceVersions SourceVersions `json:"source_versions,omitempty"`
+}
+
+// CloneEventsChannel is the Postgres NOTIFY channel carrying CloneEvent payloads
+const CloneEventsChannel = "clone_events"
+
+// Kinds of CloneEvent
+const (
+	EventKindStatus   = "status"
+	EventKindProgress = "progress"
+)
+
+// CloneEvent is a status or progress change of a clone job
+type CloneEvent struct {
+	Kind       string    `json:"kind"`
+	CloneID    int       `json:"clone_id"`
+	UserID     int       `json:"user_id"`
+	Status     string    `json:"status"`
+	Progress   int       `json:"progress"`
+	Stage      string    `json:"stage,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// CloneLifecycleStream is the message bus (Redis) stream clone lifecycle
+// events are published to
+const CloneLifecycleStream = "clone-lifecycle"
+
+// Clone lifecycle events. clone.deleted is published when a clone is purged
+// for good, not when it's moved to the trash.
+const (
+	LifecycleCloneCreated   = "clone.created"
+	LifecycleCloneCompleted = "clone.completed"
+	LifecycleCloneFailed    = "clone.failed"
+	LifecycleCloneDeleted   = "clone.deleted"
+)
+
+// CloneLifecycleEvent is a clone lifecycle event as relayed from the
+// clone_lifecycle_events outbox to the message bus. Delivery is at least
+// once; IDs increase, so consumers can skip events they have handled.
+// Files, of clone.deleted events, are the stored files that belonged only
+// to the clone.
+type CloneLifecycleEvent struct {
+	ID         int64          `json:"id" db:"id"`
+	Event      string         `json:"event" db:"event"`
+	CloneID    int            `json:"clone_id" db:"clone_id"`
+	UserID     int            `json:"user_id" db:"user_id"`
+	Status     string         `json:"status" db:"status"`
+	Files      pq.StringArray `json:"files,omitempty" db:"files"`
+	OccurredAt time.Time      `json:"occurred_at" db:"created_at"`
+}
+
+// Clone statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// IsTerminalStatus reports whether a clone status is final
+func IsTerminalStatus(status string) bool {
+	return status == StatusCompleted || status == StatusFailed || status == StatusCancelled
+}
 
+// Error codes of failed clones
+const (
+	CloneErrorInvalidAudio  = "invalid_audio"
+	CloneErrorEngineTimeout = "engine_timeout"
+	CloneErrorOutOfMemory   = "out_of_memory"
+	CloneErrorInternal      = "internal_error"
+)
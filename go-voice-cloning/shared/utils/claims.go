@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// Claims schema versions. Version 1 tokens predate the schema_version
+// claim. Bump ClaimsVersion whenever claims are added or change meaning and
+// register an upgrade from the previous version, so sessions issued before
+// the auth service deploys keep working until they expire.
+const (
+	ClaimsVersion1 = 1
+	ClaimsVersion  = 2
+)
+
+// ErrClaimsVersion means a token's claims are older than the oldest
+// version still accepted
+var ErrClaimsVersion = errors.New("unsupported claims schema version")
+
+// claimsUpgrades[v] brings version v claims to version v+1
+var claimsUpgrades = map[int]func(*Claims){
+	ClaimsVersion1: func(c *Claims) {
+		if c.Role == "" {
+			c.Role = types.RoleUser
+		}
+		c.Scope = ScopeForRole(c.Role)
+	},
+}
+
+// minClaimsVersion retires old tokens once they can no longer be in use.
+// Set JWT_MIN_CLAIMS_VERSION after a rollout to reject older versions.
+var minClaimsVersion = func() int {
+	if v, err := strconv.Atoi(os.Getenv("JWT_MIN_CLAIMS_VERSION")); err == nil && v > ClaimsVersion1 {
+		return v
+	}
+	return ClaimsVersion1
+}()
+
+// UpgradeClaims brings claims of an older schema version up to
+// ClaimsVersion in place. Claims of a newer version, issued by an auth
+// service that deployed first, are kept as they are: the claims this
+// version knows are read and the rest ignored.
+func UpgradeClaims(c *Claims) error {
+	v := c.SchemaVersion
+	if v == 0 {
+		v = ClaimsVersion1
+	}
+	if v < minClaimsVersion {
+		return fmt.Errorf("%w: %d (oldest accepted is %d)", ErrClaimsVersion, v, minClaimsVersion)
+	}
+	for ; v < ClaimsVersion; v++ {
+		if upgrade := claimsUpgrades[v]; upgrade != nil {
+			upgrade(c)
+		}
+	}
+	c.SchemaVersion = v
+	return nil
+}
+
+// ScopeForRole is the token scope granted to a role
+func ScopeForRole(role string) string {
+	if role == types.RoleAdmin {
+		return "user admin"
+	}
+	return "user"
+}
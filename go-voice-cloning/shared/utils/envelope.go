@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/types"
+)
+
+// Envelope is the body of every JSON response: the result in Data, or what
+// went wrong in Error, and in Meta what describes the response rather than
+// its result, such as its request ID and a listing's pagination. The member
+// that doesn't apply is null.
+//
+//	{"data": {"id": 42, ...}, "error": null, "meta": {"request_id": "..."}}
+//	{"data": null, "error": {"code": "VOICE_CLONE_NOT_FOUND", "message": "..."}, "meta": {"request_id": "..."}}
+type Envelope struct {
+	Data  interface{} `json:"data"`
+	Error interface{} `json:"error"`
+	Meta  Meta        `json:"meta"`
+}
+
+// Meta describes a response
+type Meta struct {
+	RequestID  string            `json:"request_id,omitempty"`
+	Pagination *types.Pagination `json:"pagination,omitempty"`
+}
+
+// RawEnvelope is an envelope read from a response, its members still
+// encoded
+type RawEnvelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error json.RawMessage `json:"error"`
+	Meta  json.RawMessage `json:"meta"`
+}
+
+var null = json.RawMessage("null")
+
+// present reports whether a member holds something other than null
+func present(member json.RawMessage) bool {
+	return len(member) > 0 && string(member) != "null"
+}
+
+// IsError reports whether the envelope holds an error
+func (e RawEnvelope) IsError() bool {
+	return present(e.Error)
+}
+
+// ReadEnvelope reads the JSON body of a response with the given status.
+// Bodies in the shapes used before the envelope, which services not yet
+// upgraded still send, are converted: an error status's object becomes the
+// error, its "error" message the "message" and its "request_id" the meta's,
+// a listing's {"data", "pagination"} is split between the data and the
+// meta, and any other body is the data.
+func ReadEnvelope(status int, body []byte) (RawEnvelope, error) {
+	if !json.Valid(body) {
+		return RawEnvelope{}, errors.New("response body isn't JSON")
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+	if _, ok := fields["data"]; ok && len(fields) == 3 {
+		if _, ok := fields["error"]; ok {
+			if _, ok := fields["meta"]; ok {
+				return RawEnvelope{Data: fields["data"], Error: fields["error"], Meta: fields["meta"]}, nil
+			}
+		}
+	}
+
+	envelope := RawEnvelope{Data: null, Error: null}
+	meta := map[string]json.RawMessage{}
+	switch {
+	case status >= 400 && fields == nil:
+		envelope.Error, _ = json.Marshal(apierror.New(status, apierror.ForStatus(status), http.StatusText(status)))
+	case status >= 400:
+		if requestID, ok := fields["request_id"]; ok {
+			meta["request_id"] = requestID
+			delete(fields, "request_id")
+		}
+		if message, ok := fields["error"]; ok {
+			fields["message"] = message
+			delete(fields, "error")
+		}
+		envelope.Error, _ = json.Marshal(fields)
+	case len(fields) == 2 && fields["data"] != nil && fields["pagination"] != nil:
+		envelope.Data = fields["data"]
+		meta["pagination"] = fields["pagination"]
+	default:
+		envelope.Data = body
+	}
+	envelope.Meta, _ = json.Marshal(meta)
+	return envelope, nil
+}
+
+// Legacy returns the body in the shape it had before the envelope, for
+// clients that haven't moved to it yet: the error object with its message
+// in "error" and the request ID, a listing's {"data", "pagination"}, or
+// the data alone.
+func (e RawEnvelope) Legacy() ([]byte, error) {
+	var meta struct {
+		RequestID  string          `json:"request_id"`
+		Pagination json.RawMessage `json:"pagination"`
+	}
+	json.Unmarshal(e.Meta, &meta)
+	switch {
+	case e.IsError():
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(e.Error, &fields); err != nil {
+			return nil, err
+		}
+		if message, ok := fields["message"]; ok {
+			fields["error"] = message
+			delete(fields, "message")
+		}
+		if meta.RequestID != "" {
+			fields["request_id"], _ = json.Marshal(meta.RequestID)
+		}
+		return json.Marshal(fields)
+	case present(meta.Pagination):
+		return json.Marshal(map[string]json.RawMessage{"data": e.Data, "pagination": meta.Pagination})
+	}
+	return e.Data, nil
+}
+
+// DecodeData reads the response of another service and decodes its data
+// into v
+func DecodeData(resp *http.Response, v interface{}) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	envelope, err := ReadEnvelope(resp.StatusCode, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Data, v)
+}
+
+// DecodeError reads the error another service answered with. One that
+// can't be read has the generic code of the response's status.
+func DecodeError(resp *http.Response) *apierror.Error {
+	e := apierror.New(resp.StatusCode, apierror.ForStatus(resp.StatusCode), http.StatusText(resp.StatusCode))
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return e
+	}
+	if envelope, err := ReadEnvelope(resp.StatusCode, body); err == nil && envelope.IsError() {
+		json.Unmarshal(envelope.Error, e)
+	}
+	return e
+}
@@ -2,21 +2,69 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/i18n"
+	"github.com/voice-cloning/shared/types"
 )
 
-// JSONResponse sends a JSON response
+// ContentLanguageHeader is the language of a response's messages
+const ContentLanguageHeader = "Content-Language"
+
+// JSONResponse sends data in the response envelope: as its error when it
+// is one, such as an *apierror.Error or the fields of ErrorBody, and as its
+// data otherwise. A listing's PageResponse is split into its items and, in
+// the meta, its pagination. The request ID set by the RequestID middleware
+// goes in the meta too, so clients can quote it in bug reports.
 func JSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	envelope := Envelope{Meta: Meta{RequestID: w.Header().Get(RequestIDHeader)}}
+	switch data := data.(type) {
+	case error:
+		envelope.Error = data
+	case types.PageResponse:
+		envelope.Data, envelope.Meta.Pagination = data.Data, &data.Pagination
+	default:
+		envelope.Data = data
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(data)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+// ErrorResponse sends an error response with its code
+func ErrorResponse(w http.ResponseWriter, statusCode int, code apierror.Code, message string) {
+	WriteError(w, apierror.New(statusCode, code, message))
+}
+
+// WriteError sends an error response, with its details if it has any. The
+// message is translated into the language the Language middleware chose.
+func WriteError(w http.ResponseWriter, e *apierror.Error) {
+	if message, ok := i18n.Error(w.Header().Get(ContentLanguageHeader), e.Code, e.Status); ok {
+		e.Message = message
+	}
+	JSONResponse(w, e.Status, e)
+}
+
+// ErrorFields is the error of a response that carries fields of its own
+// next to its code and message, such as the limit of a quota
+type ErrorFields map[string]interface{}
+
+func (f ErrorFields) Error() string {
+	return fmt.Sprintf("%v: %v", f["code"], f["message"])
 }
 
-// ErrorResponse sends an error response
-func ErrorResponse(w http.ResponseWriter, statusCode int, message string) {
-	JSONResponse(w, statusCode, map[string]string{
-		"error": message,
-	})
+// ErrorBody returns the error of a response that carries fields of its own,
+// to add them to before sending it with JSONResponse
+func ErrorBody(w http.ResponseWriter, code apierror.Code, message string) ErrorFields {
+	if translated, ok := i18n.Error(w.Header().Get(ContentLanguageHeader), code, 0); ok {
+		message = translated
+	}
+	return ErrorFields{
+		"code":    code,
+		"message": message,
+	}
 }
 
 // SuccessResponse sends a success response
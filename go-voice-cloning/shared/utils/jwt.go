@@ -2,29 +2,76 @@ package utils
 
 import (
 	"errors"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production") // TODO: Move to env
+// defaultJWTSecret signs tokens when JWT_SECRET isn't set, for local
+// development
+const defaultJWTSecret = "your-secret-key-change-in-production"
 
-// Claims represents JWT claims
+// jwtKeys remembers the secret JWT_SECRET held before it last changed, such
+// as when it was rotated from a secrets manager, so tokens signed before
+// the rotation stay valid. JWT_PREVIOUS_SECRET does the same across
+// restarts.
+var jwtKeys struct {
+	sync.Mutex
+	current, previous string
+}
+
+// jwtSecrets returns the key tokens are signed with, followed by the older
+// keys they are also validated with
+func jwtSecrets() [][]byte {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = defaultJWTSecret
+	}
+	jwtKeys.Lock()
+	if jwtKeys.current != secret {
+		if jwtKeys.current != "" {
+			jwtKeys.previous = jwtKeys.current
+		}
+		jwtKeys.current = secret
+	}
+	previous := jwtKeys.previous
+	jwtKeys.Unlock()
+
+	keys := [][]byte{[]byte(secret)}
+	for _, key := range []string{previous, os.Getenv("JWT_PREVIOUS_SECRET")} {
+		if key != "" && key != secret {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// Claims represents JWT claims. Tokens of older schema versions are
+// upgraded to the current one when validated; see UpgradeClaims.
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	UserID        int    `json:"user_id"`
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	Role          string `json:"role,omitempty"`
+	// Scope is a space separated list, as in OAuth. Added in version 2.
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // GenerateToken generates a JWT token for a user
-func GenerateToken(userID int, email, username string) (string, error) {
+func GenerateToken(userID int, email, username, role string) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	
 	claims := &Claims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
+		SchemaVersion: ClaimsVersion,
+		UserID:        userID,
+		Email:         email,
+		Username:      username,
+		Role:          role,
+		Scope:         ScopeForRole(role),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -33,7 +80,7 @@ func GenerateToken(userID int, email, username string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(jwtSecrets()[0])
 }
 
 // ValidateToken validates a JWT token and returns the claims
@@ -44,7 +91,11 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("invalid signing method")
 		}
-		return jwtSecret, nil
+		set := jwt.VerificationKeySet{}
+		for _, key := range jwtSecrets() {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
 	})
 
 	if err != nil {
@@ -55,6 +106,9 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if err := UpgradeClaims(claims); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
 
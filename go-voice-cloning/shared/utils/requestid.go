@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+)
+
+// RequestIDHeader carries the correlation ID between clients, the gateway and
+// downstream services
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an incoming request ID is safe to reuse
+func ValidRequestID(requestID string) bool {
+	return validRequestID.MatchString(requestID)
+}
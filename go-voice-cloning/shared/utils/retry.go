@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+)
+
+// Retry guidance headers. Retry-After carries the same delay rounded up to
+// whole seconds for clients that only understand the standard header.
+const (
+	RetryAfterMSHeader  = "X-Retry-After-Ms"
+	RetryJitterMSHeader = "X-Retry-Jitter-Ms"
+	RetryBackoffHeader  = "X-Retry-Backoff-Multiplier"
+)
+
+// Reasons a request was shed
+const (
+	RetryReasonRateLimited         = "rate_limited"
+	RetryReasonBreakerOpen         = "breaker_open"
+	RetryReasonMaintenance         = "maintenance"
+	RetryReasonUpstreamUnavailable = "upstream_unavailable"
+	RetryReasonOverloaded          = "overloaded"
+)
+
+// RetryGuidance tells clients when to retry a shed request. Clients should
+// wait RetryAfterMS plus a random share of JitterMS, and multiply the wait
+// by BackoffMultiplier for each further failed attempt.
+type RetryGuidance struct {
+	RetryAfterMS      int64   `json:"retry_after_ms"`
+	JitterMS          int64   `json:"jitter_ms"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	Reason            string  `json:"reason"`
+}
+
+// NewRetryGuidance builds guidance with a jitter window of a fifth of the
+// delay (at least 100ms) and a backoff multiplier of 2
+func NewRetryGuidance(reason string, retryAfter time.Duration) RetryGuidance {
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+	jitter := retryAfter / 5
+	if jitter < 100*time.Millisecond {
+		jitter = 100 * time.Millisecond
+	}
+	return RetryGuidance{
+		RetryAfterMS:      retryAfter.Milliseconds(),
+		JitterMS:          jitter.Milliseconds(),
+		BackoffMultiplier: 2,
+		Reason:            reason,
+	}
+}
+
+// SetRetryHeaders sets the retry guidance headers
+func SetRetryHeaders(h http.Header, g RetryGuidance) {
+	h.Set("Retry-After", strconv.FormatInt((g.RetryAfterMS+999)/1000, 10))
+	h.Set(RetryAfterMSHeader, strconv.FormatInt(g.RetryAfterMS, 10))
+	h.Set(RetryJitterMSHeader, strconv.FormatInt(g.JitterMS, 10))
+	h.Set(RetryBackoffHeader, strconv.FormatFloat(g.BackoffMultiplier, 'f', -1, 64))
+}
+
+// retryCodes are the error codes of the reasons a request was shed
+var retryCodes = map[string]apierror.Code{
+	RetryReasonRateLimited:         apierror.RateLimited,
+	RetryReasonBreakerOpen:         apierror.Unavailable,
+	RetryReasonMaintenance:         apierror.Maintenance,
+	RetryReasonUpstreamUnavailable: apierror.Unavailable,
+	RetryReasonOverloaded:          apierror.Overloaded,
+}
+
+// RetryResponse sends a shed-load error (usually 429 or 503) with retry
+// guidance in both the headers and the body's "retry" field. Its code
+// follows the guidance's reason.
+func RetryResponse(w http.ResponseWriter, statusCode int, message string, g RetryGuidance) {
+	SetRetryHeaders(w.Header(), g)
+	code, ok := retryCodes[g.Reason]
+	if !ok {
+		code = apierror.ForStatus(statusCode)
+	}
+	body := ErrorBody(w, code, message)
+	body["retry"] = g
+	JSONResponse(w, statusCode, body)
+}
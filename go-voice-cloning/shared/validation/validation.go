@@ -0,0 +1,127 @@
+// Package validation enforces the validate tags of request types, such as
+// `validate:"required,email"`, with go-playground/validator. Handlers decode
+// request bodies through DecodeJSON, or call Check on what they decoded
+// themselves, so an invalid request is refused the same way everywhere: a
+// 400 VALIDATION_FAILED listing each invalid field in its details, named as
+// in the JSON body.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
+
+// Struct checks the validate tags of v, a struct or a pointer to one, and
+// returns a detail for each field that fails them, or nil when v is valid.
+// Other values have no tags to check and are always valid.
+func Struct(v interface{}) []apierror.Detail {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	err := validate.Struct(v)
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return nil
+	}
+	details := make([]apierror.Detail, len(fieldErrs))
+	for i, fe := range fieldErrs {
+		details[i] = apierror.Detail{Field: field(fe), Code: apierror.InvalidParameter, Message: message(fe)}
+	}
+	return details
+}
+
+// Check answers a request whose decoded body v fails its validate tags with
+// 400 and the invalid fields, and reports whether v is valid
+func Check(w http.ResponseWriter, v interface{}) bool {
+	details := Struct(v)
+	if details == nil {
+		return true
+	}
+	e := apierror.New(http.StatusBadRequest, apierror.ValidationFailed, "Request validation failed")
+	e.Details = details
+	utils.WriteError(w, e)
+	return false
+}
+
+// DecodeJSON decodes the request's JSON body into v and checks it. It
+// answers a malformed body or an invalid one with 400, and reports whether
+// the handler can go on.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Invalid request body")
+		return false
+	}
+	return Check(w, v)
+}
+
+// field is the JSON path of an invalid field, without the name of the
+// request type
+func field(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+// message explains a failed tag in the words of the API's other errors,
+// such as "username must be at least 3 characters"
+func message(fe validator.FieldError) string {
+	name := field(fe)
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+	switch fe.Tag() {
+	case "required":
+		return name + " is required"
+	case "email":
+		return name + " must be a valid email address"
+	case "url":
+		return name + " must be a valid URL"
+	case "min":
+		return fmt.Sprintf("%s must be at least %s%s", name, fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("%s must be at most %s%s", name, fe.Param(), unit)
+	case "len":
+		return fmt.Sprintf("%s must be exactly %s%s", name, fe.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", name, strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("%s fails the %s rule", name, fe.Tag())
+	}
+}
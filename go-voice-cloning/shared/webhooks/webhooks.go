@@ -0,0 +1,288 @@
+// Package webhooks records clone status transitions and other clone events
+// as signed webhook deliveries and dispatches them with retries. Deliveries are stored in
+// Postgres so a crash between the transition and the HTTP call loses nothing.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Voice-Signature"
+	EventHeader     = "X-Voice-Event"
+	DeliveryHeader  = "X-Voice-Delivery"
+)
+
+// Events sent to callback URLs: EventCloneStatusChanged on every clone
+// status transition, EventCloneExpiring ahead of an inactive clone's expiry
+const (
+	EventCloneStatusChanged = "clone.status_changed"
+	EventCloneExpiring      = "clone.expiring"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Delivery is a single webhook delivery and its outcome
+type Delivery struct {
+	ID             int             `json:"id" db:"id"`
+	CloneID        int             `json:"clone_id" db:"clone_id"`
+	URL            string          `json:"url" db:"url"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	LastStatusCode *int            `json:"last_status_code,omitempty" db:"last_status_code"`
+	LastError      *string         `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// ValidateURL checks that a callback URL is an absolute http(s) URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// EnsureSecret makes sure the user has a signing secret and returns it
+func EnsureSecret(ctx context.Context, db sqlx.ExtContext, userID int) (string, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	var stored string
+	err = sqlx.GetContext(ctx, db, &stored,
+		`INSERT INTO voice_callback_settings (user_id, secret, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING secret`,
+		userID, secret)
+	return stored, err
+}
+
+// Sign computes the signature header value for a payload. Receivers
+// recompute HMAC-SHA256 over "<timestamp>.<body>" with their secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// EnqueueCloneStatus records deliveries for a clone's status transition to
+// the clone's own callback URL and the owner's account-level URL
+func EnqueueCloneStatus(ctx context.Context, db sqlx.ExtContext, cloneID int, status string) error {
+	return EnqueueCloneEvent(ctx, db, cloneID, EventCloneStatusChanged, map[string]interface{}{"status": status})
+}
+
+// EnqueueCloneEvent records deliveries of an event about a clone to the same
+// URLs as status transitions. fields are added to the payload.
+func EnqueueCloneEvent(ctx context.Context, db sqlx.ExtContext, cloneID int, event string, fields map[string]interface{}) error {
+	var target struct {
+		UserID     int     `db:"user_id"`
+		Name       string  `db:"name"`
+		CloneURL   *string `db:"callback_url"`
+		AccountURL *string `db:"account_url"`
+	}
+	err := sqlx.GetContext(ctx, db, &target,
+		`SELECT c.user_id, c.name, c.callback_url, s.url AS account_url
+		FROM voice_clones c
+		LEFT JOIN voice_callback_settings s ON s.user_id = c.user_id
+		WHERE c.id = $1`,
+		cloneID)
+	if err != nil {
+		return err
+	}
+
+	urls := []string{}
+	if target.CloneURL != nil && *target.CloneURL != "" {
+		urls = append(urls, *target.CloneURL)
+	}
+	if target.AccountURL != nil && *target.AccountURL != "" && (len(urls) == 0 || urls[0] != *target.AccountURL) {
+		urls = append(urls, *target.AccountURL)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"event":       event,
+		"clone_id":    cloneID,
+		"name":        target.Name,
+		"occurred_at": time.Now().UTC(),
+	}
+	for k, v := range fields {
+		body[k] = v
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range urls {
+		_, err := db.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (clone_id, user_id, url, event, payload, status, attempts, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, 0, NOW(), NOW())`,
+			cloneID, target.UserID, u, event, string(payload), StatusPending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dispatcher sends pending deliveries, retrying failures with exponential
+// backoff until MaxAttempts is reached
+type Dispatcher struct {
+	DB          *sqlx.DB
+	Client      *http.Client
+	MaxAttempts int
+	Interval    time.Duration
+	BatchSize   int
+}
+
+func NewDispatcher(db *sqlx.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		Interval:    2 * time.Second,
+		BatchSize:   20,
+	}
+}
+
+// Run dispatches due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.dispatchDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Webhook dispatch failed: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var due []struct {
+		ID       int    `db:"id"`
+		URL      string `db:"url"`
+		Event    string `db:"event"`
+		Payload  string `db:"payload"`
+		Attempts int    `db:"attempts"`
+		Secret   string `db:"secret"`
+	}
+	// SKIP LOCKED lets several workers dispatch concurrently
+	err = tx.SelectContext(ctx, &due,
+		`SELECT d.id, d.url, d.event, d.payload, d.attempts, s.secret
+		FROM webhook_deliveries d
+		JOIN voice_callback_settings s ON s.user_id = d.user_id
+		WHERE d.status = $1 AND d.next_attempt_at <= NOW()
+		ORDER BY d.next_attempt_at
+		LIMIT $2
+		FOR UPDATE OF d SKIP LOCKED`,
+		StatusPending, d.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range due {
+		code, sendErr := d.send(ctx, delivery.ID, delivery.URL, delivery.Event, delivery.Secret, []byte(delivery.Payload))
+		attempts := delivery.Attempts + 1
+
+		var codeArg interface{}
+		if code != 0 {
+			codeArg = code
+		}
+
+		if sendErr == nil {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = NULL, delivered_at = NOW()
+				WHERE id = $4`,
+				StatusDelivered, attempts, codeArg, delivery.ID)
+		} else {
+			status := StatusPending
+			if attempts >= d.MaxAttempts {
+				status = StatusFailed
+			}
+			// 10s, 20s, 40s ... capped at one hour
+			backoff := time.Duration(10<<uint(attempts-1)) * time.Second
+			if backoff > time.Hour {
+				backoff = time.Hour
+			}
+			_, err = tx.ExecContext(ctx,
+				`UPDATE webhook_deliveries SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5
+				WHERE id = $6`,
+				status, attempts, codeArg, sendErr.Error(), time.Now().Add(backoff), delivery.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Dispatcher) send(ctx context.Context, id int, target, event, secret string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(id))
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now().Unix(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
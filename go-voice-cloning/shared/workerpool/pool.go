@@ -0,0 +1,258 @@
+// Package workerpool provides a bounded worker pool with backpressure,
+// graceful draining and panic isolation for background work in services.
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by TrySubmit when the queue has no free slots
+	ErrQueueFull = errors.New("workerpool: queue full")
+	// ErrClosed is returned when submitting to a pool that is shutting down
+	ErrClosed = errors.New("workerpool: pool closed")
+)
+
+// Task is a unit of work. The context is cancelled when the pool is stopped
+// without draining.
+type Task func(ctx context.Context)
+
+// Hooks are optional callbacks for metrics and logging. They are called from
+// worker goroutines and must be safe for concurrent use.
+type Hooks struct {
+	OnStart  func()
+	OnFinish func(duration time.Duration)
+	OnPanic  func(recovered interface{}, stack []byte)
+	OnReject func()
+}
+
+// Config configures a Pool
+type Config struct {
+	Name      string
+	Workers   int
+	QueueSize int
+	Hooks     Hooks
+}
+
+// Stats is a snapshot of pool counters
+type Stats struct {
+	Name      string `json:"name"`
+	Workers   int    `json:"workers"`
+	Queued    int    `json:"queued"`
+	QueueSize int    `json:"queue_size"`
+	Active    int64  `json:"active"`
+	Completed int64  `json:"completed"`
+	Panicked  int64  `json:"panicked"`
+	Rejected  int64  `json:"rejected"`
+}
+
+// Pool runs submitted tasks on a fixed number of workers
+type Pool struct {
+	cfg    Config
+	tasks  chan Task
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// done is closed when the pool starts shutting down, releasing blocked
+	// submitters. tasks is closed once none is left.
+	mu         sync.RWMutex
+	closed     bool
+	done       chan struct{}
+	submitting sync.WaitGroup
+	closeTasks sync.Once
+
+	active    atomic.Int64
+	completed atomic.Int64
+	panicked  atomic.Int64
+	rejected  atomic.Int64
+}
+
+// New starts a pool with the given configuration
+func New(cfg Config) *Pool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize < 0 {
+		cfg.QueueSize = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		cfg:    cfg,
+		tasks:  make(chan Task, cfg.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	p.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Submit queues a task, blocking until a slot is free, ctx is done or the
+// pool shuts down
+func (p *Pool) Submit(ctx context.Context, task Task) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrClosed
+	}
+	p.submitting.Add(1)
+	p.mu.RUnlock()
+	defer p.submitting.Done()
+
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.done:
+		p.reject()
+		return ErrClosed
+	case <-ctx.Done():
+		p.reject()
+		return ctx.Err()
+	}
+}
+
+// TrySubmit queues a task without blocking, returning ErrQueueFull when the
+// queue is at capacity so callers can shed load
+func (p *Pool) TrySubmit(task Task) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+		p.reject()
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting tasks and waits for queued and running tasks to
+// finish. Submitters blocked on a full queue get ErrClosed. If ctx expires first, running tasks are cancelled and ctx's error
+// is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.submitting.Wait()
+		p.closeTasks.Do(func() { close(p.tasks) })
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
+
+// Group submits related tasks to a pool so the caller can wait for all of
+// them, such as the items of one batch
+type Group struct {
+	pool *Pool
+	wg   sync.WaitGroup
+}
+
+// Group starts a group of tasks on the pool
+func (p *Pool) Group() *Group {
+	return &Group{pool: p}
+}
+
+// Submit queues a task of the group, blocking like Pool.Submit
+func (g *Group) Submit(ctx context.Context, task Task) error {
+	g.wg.Add(1)
+	err := g.pool.Submit(ctx, func(ctx context.Context) {
+		defer g.wg.Done()
+		task(ctx)
+	})
+	if err != nil {
+		g.wg.Done()
+	}
+	return err
+}
+
+// Wait blocks until every task submitted to the group has finished, or
+// panicked
+func (g *Group) Wait() {
+	g.wg.Wait()
+}
+
+// Stats returns a snapshot of the pool's counters
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Name:      p.cfg.Name,
+		Workers:   p.cfg.Workers,
+		Queued:    len(p.tasks),
+		QueueSize: p.cfg.QueueSize,
+		Active:    p.active.Load(),
+		Completed: p.completed.Load(),
+		Panicked:  p.panicked.Load(),
+		Rejected:  p.rejected.Load(),
+	}
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		p.run(task)
+	}
+}
+
+// run executes a single task, isolating panics so one bad task cannot take
+// down the worker or the service
+func (p *Pool) run(task Task) {
+	start := time.Now()
+	p.active.Add(1)
+	if p.cfg.Hooks.OnStart != nil {
+		p.cfg.Hooks.OnStart()
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			p.panicked.Add(1)
+			if p.cfg.Hooks.OnPanic != nil {
+				p.cfg.Hooks.OnPanic(rec, debug.Stack())
+			} else {
+				slog.Error("Worker pool task panicked", "pool", p.cfg.Name, "panic", rec, "stack", string(debug.Stack()))
+			}
+		}
+		p.active.Add(-1)
+		p.completed.Add(1)
+		if p.cfg.Hooks.OnFinish != nil {
+			p.cfg.Hooks.OnFinish(time.Since(start))
+		}
+	}()
+
+	task(p.ctx)
+}
+
+func (p *Pool) reject() {
+	p.rejected.Add(1)
+	if p.cfg.Hooks.OnReject != nil {
+		p.cfg.Hooks.OnReject()
+	}
+}
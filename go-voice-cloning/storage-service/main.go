@@ -1,174 +1,55 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 
-	"github.com/gorilla/mux"
-	
-	"github.com/voice-cloning/shared/utils"
-)
+	_ "github.com/lib/pq"
 
-type StorageService struct {
-	storagePath string
-}
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/schema"
+	"github.com/voice-cloning/storage-service/storage"
+)
 
 func main() {
-	storagePath := os.Getenv("STORAGE_PATH")
-	if storagePath == "" {
-		storagePath = "./storage"
-	}
-
-	// Create storage directory if it doesn't exist
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		log.Fatal("Failed to create storage directory:", err)
-	}
-
-	service := &StorageService{storagePath: storagePath}
-
-	// Setup routes
-	r := mux.NewRouter()
-	r.HandleFunc("/health", healthCheck).Methods("GET")
-	r.HandleFunc("/upload", service.uploadFile).Methods("POST")
-	r.HandleFunc("/download/{filename}", service.downloadFile).Methods("GET")
-	r.HandleFunc("/files/{filename}", service.deleteFile).Methods("DELETE")
-	r.HandleFunc("/files", service.listFiles).Methods("GET")
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8083"
+	var cfg storage.Config
+	config.MustLoad("storage-service", &cfg)
+	if err := logging.Setup("storage-service", cfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
 	}
+	config.LogSummary(&cfg)
 
-	log.Printf("Storage Service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
-}
-
-func healthCheck(w http.ResponseWriter, r *http.Request) {
-	utils.JSONResponse(w, http.StatusOK, map[string]string{
-		"status": "healthy",
-		"service": "storage-service",
-	})
-}
-
-func (s *StorageService) uploadFile(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form
-	err := r.ParseMultipartForm(10 << 20) // 10 MB max
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, "Failed to parse form")
-		return
-	}
-
-	file, handler, err := r.FormFile("file")
+	tlsSource, err := mtls.Setup(cfg.TLS)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, "No file provided")
-		return
+		log.Fatal("Invalid mTLS configuration: ", err)
 	}
-	defer file.Close()
-
-	// Create file path
-	filePath := filepath.Join(s.storagePath, handler.Filename)
 
-	// Create file
-	dst, err := os.Create(filePath)
+	// Database connection for file metadata and quota accounting
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to save file")
-		return
+		log.Fatal("Failed to connect to database:", err)
 	}
-	defer dst.Close()
-
-	// Copy file content
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to save file")
-		return
-	}
-
-	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
-		"filename": handler.Filename,
-		"size":     handler.Size,
-		"path":     filePath,
-		"message":  "File uploaded successfully",
-	})
-}
-
-func (s *StorageService) downloadFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	filename := vars["filename"]
+	defer db.Close()
 
-	filePath := filepath.Join(s.storagePath, filename)
-
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		utils.ErrorResponse(w, http.StatusNotFound, "File not found")
-		return
-	}
-
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to open file")
-		return
-	}
-	defer file.Close()
-
-	// Set headers
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-
-	// Copy file to response
-	io.Copy(w, file)
-}
-
-func (s *StorageService) deleteFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	filename := vars["filename"]
-
-	filePath := filepath.Join(s.storagePath, filename)
-
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		utils.ErrorResponse(w, http.StatusNotFound, "File not found")
-		return
+	if cfg.MigrateOnStart {
+		if _, err := schema.Up(context.Background(), db); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
 	}
 
-	// Delete file
-	err := os.Remove(filePath)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to delete file")
-		return
+	if len(os.Args) > 1 && os.Args[1] == "reconcile-replicas" {
+		os.Exit(storage.ReconcileCommand(cfg, db, os.Args[2:]))
 	}
 
-	utils.JSONResponse(w, http.StatusOK, map[string]string{
-		"message": "File deleted successfully",
-	})
-}
-
-func (s *StorageService) listFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := os.ReadDir(s.storagePath)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "Failed to list files")
-		return
-	}
+	handler, closeService := storage.New(cfg, db)
+	defer closeService()
 
-	var fileList []map[string]interface{}
-	for _, file := range files {
-		if !file.IsDir() {
-			info, err := file.Info()
-			if err != nil {
-				continue
-			}
-			fileList = append(fileList, map[string]interface{}{
-				"name": file.Name(),
-				"size": info.Size(),
-			})
-		}
-	}
-
-	utils.SuccessResponse(w, fileList)
+	slog.Info("Storage Service starting", "port", cfg.Port)
+	log.Fatal(tlsSource.ListenAndServe(&http.Server{Addr: ":" + cfg.Port, Handler: handler}))
 }
-
-
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// justificationHeader carries an admin's reason for accessing another
+// user's file. It is required for every such access and kept in the audit.
+const justificationHeader = "X-Access-Justification"
+
+const maxJustificationLength = 1000
+
+// File access actions
+const (
+	accessDownload = "download"
+	accessDelete   = "delete"
+	accessPresign  = "presign"
+)
+
+// FileAccess is an admin's access to a file stored by another user
+type FileAccess struct {
+	ID            int       `json:"id" db:"id"`
+	AdminID       int       `json:"admin_id" db:"admin_id"`
+	UserID        int       `json:"user_id" db:"user_id"`
+	FileID        string    `json:"file_id" db:"file_id"`
+	Filename      string    `json:"filename" db:"filename"`
+	Action        string    `json:"action" db:"action"`
+	Justification string    `json:"justification" db:"justification"`
+	RequestID     string    `json:"request_id,omitempty" db:"request_id"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+const (
+	defaultAccessPageSize = 50
+	maxAccessPageSize     = 200
+)
+
+// accessCursor is the keyset position after the last access of a page
+type accessCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int       `json:"id"`
+}
+
+// accessSort lists the newest accesses first
+var accessSort = types.PageSort{Name: "-created_at", Column: "created_at", Descending: true}
+
+const fileAccessColumns = `id, admin_id, user_id, COALESCE(file_id, filename) AS file_id, filename, action, justification,
+	COALESCE(request_id, '') AS request_id, created_at`
+
+// auditAdminAccess records an admin's access to another user's file before
+// it happens. The access is refused without a justification, or when the
+// audit can't be written. Users' own files and internal calls, which carry
+// no role, pass through unrecorded.
+func (s *StorageService) auditAdminAccess(w http.ResponseWriter, r *http.Request, file storedFile, action string) bool {
+	adminID := middleware.UserID(r)
+	if !middleware.IsAdmin(r) || adminID == 0 {
+		return true
+	}
+	if !file.UserID.Valid || file.owner() == adminID {
+		return true
+	}
+
+	justification := strings.TrimSpace(r.Header.Get(justificationHeader))
+	if justification == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter,
+			fmt.Sprintf("The %s header is required to access another user's file", justificationHeader))
+		return false
+	}
+	if len(justification) > maxJustificationLength {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter,
+			fmt.Sprintf("%s must be at most %d characters", justificationHeader, maxJustificationLength))
+		return false
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO file_access_audit (admin_id, user_id, file_id, filename, action, justification, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+		adminID, file.owner(), file.ID, file.Filename, action, justification, r.Header.Get(utils.RequestIDHeader))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to audit admin file access", "action", action, "file_id", file.ID, "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to record file access")
+		return false
+	}
+	s.audit.Request(r, "admin_file_access", "file", file.ID, audit.Success,
+		map[string]interface{}{"action": action, "owner_id": file.owner(), "justification": justification})
+	return true
+}
+
+// listAccessLog shows users when admins accessed their files, newest first.
+// Supports ?limit=/?cursor= pagination.
+func (s *StorageService) listAccessLog(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	s.listAccesses(w, r, []string{"user_id = $1"}, []interface{}{userID}, "Failed to fetch access log")
+}
+
+// listFileAccess is the admin view of the audit, optionally filtered by
+// ?user_id= and ?admin_id=, with ?limit=/?cursor= pagination
+func (s *StorageService) listFileAccess(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	for _, filter := range []string{"user_id", "admin_id"} {
+		v := r.URL.Query().Get(filter)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid "+filter)
+			return
+		}
+		args = append(args, id)
+		where = append(where, fmt.Sprintf("%s = $%d", filter, len(args)))
+	}
+	s.listAccesses(w, r, where, args, "Failed to fetch file access audit")
+}
+
+// listAccesses pages through the audited accesses matching where, newest
+// first
+func (s *StorageService) listAccesses(w http.ResponseWriter, r *http.Request, where []string, args []interface{}, failure string) {
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultAccessPageSize, maxAccessPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM file_access_audit WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, failure)
+		return
+	}
+
+	var cursor accessCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, accessSort.After(cursor.CreatedAt, cursor.ID, arg))
+	}
+
+	accesses := []FileAccess{}
+	err = db.SelectContext(r.Context(), &accesses,
+		"SELECT "+fileAccessColumns+" FROM file_access_audit WHERE "+strings.Join(where, " AND ")+" "+accessSort.OrderBy()+" "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, failure)
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, accesses, total, func(last FileAccess) interface{} {
+		return accessCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}))
+}
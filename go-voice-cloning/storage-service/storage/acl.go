@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// A file's ACL decides who else than its owner can read it: nobody
+// (private), the users it's shared with (shared) or anyone (public-read),
+// such as a preview sample. Like a clone's, a file's shares are kept when it
+// is made private and apply again once it's shared. Reading is downloading
+// the file or its audio details and waveform; only its owner changes it.
+
+// maxFileShares caps the users a file can be shared with
+const maxFileShares = 100
+
+// FileACL is who can read a file
+type FileACL struct {
+	ID    string `json:"id"`
+	ACL   string `json:"acl"`
+	Users []int  `json:"users"`
+}
+
+func validFileACL(acl string) bool {
+	for _, a := range types.FileACLs {
+		if a == acl {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead reports whether the caller may read a file: whoever can access it,
+// and other users its ACL allows
+func (s *StorageService) canRead(ctx context.Context, r *http.Request, file storedFile) (bool, error) {
+	if canAccess(r, file) {
+		return true, nil
+	}
+	switch file.ACL {
+	case types.FileACLPublicRead:
+		return true, nil
+	case types.FileACLShared:
+		var shared bool
+		err := s.db.GetContext(ctx, &shared,
+			"SELECT EXISTS (SELECT 1 FROM file_shares WHERE file_id = $1 AND user_id = $2)", file.ID, middleware.UserID(r))
+		return shared, err
+	}
+	return false, nil
+}
+
+// fileACL loads who can read a file
+func (s *StorageService) fileACL(ctx context.Context, file storedFile) (FileACL, error) {
+	acl := FileACL{ID: file.ID, ACL: file.ACL, Users: []int{}}
+	err := s.db.SelectContext(ctx, &acl.Users,
+		"SELECT user_id FROM file_shares WHERE file_id = $1 ORDER BY user_id", file.ID)
+	return acl, err
+}
+
+// sharedFile resolves a file of the request's {id} whose ACL the caller may
+// change: a user's file, by its owner or an internal call. Otherwise the
+// request has been answered and false is returned.
+func (s *StorageService) sharedFile(w http.ResponseWriter, r *http.Request) (storedFile, bool) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return file, false
+	}
+	if !canModify(w, r, file) {
+		return file, false
+	}
+	if file.owner() == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "Internal files can't be shared")
+		return file, false
+	}
+	return file, true
+}
+
+// getFileACL shows who can read a file, to its owner
+func (s *StorageService) getFileACL(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	acl, err := s.fileACL(r.Context(), file)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch file ACL")
+		return
+	}
+	utils.SuccessResponse(w, acl)
+}
+
+// setFileACL changes a file's ACL and, when users are given, replaces the
+// users it's shared with
+func (s *StorageService) setFileACL(w http.ResponseWriter, r *http.Request) {
+	var req types.FileACLRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if !validFileACL(req.ACL) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("acl must be one of %v", types.FileACLs))
+		return
+	}
+	if len(req.Users) > maxFileShares {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("A file can be shared with at most %d users", maxFileShares))
+		return
+	}
+	for _, id := range req.Users {
+		if id <= 0 {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "users must be user IDs")
+			return
+		}
+	}
+	file, ok := s.sharedFile(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update file ACL")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(r.Context(), "UPDATE stored_files SET acl = $1 WHERE id = $2", req.ACL, file.ID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update file ACL")
+		return
+	}
+	if req.Users != nil {
+		if _, err := tx.ExecContext(r.Context(), "DELETE FROM file_shares WHERE file_id = $1", file.ID); err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update file ACL")
+			return
+		}
+		for _, userID := range req.Users {
+			if userID == file.owner() {
+				continue
+			}
+			if _, err := tx.ExecContext(r.Context(),
+				"INSERT INTO file_shares (file_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", file.ID, userID); err != nil {
+				utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update file ACL")
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update file ACL")
+		return
+	}
+
+	s.audit.Request(r, "set_file_acl", "file", file.ID, audit.Success, map[string]interface{}{"acl": req.ACL, "users": req.Users})
+	file.ACL = req.ACL
+	s.respondFileACL(w, r, file)
+}
+
+// shareFile shares a file with a user. Sharing a private file makes it
+// shared.
+func (s *StorageService) shareFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID int `json:"user_id"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if req.UserID <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "user_id is required")
+		return
+	}
+	file, ok := s.sharedFile(w, r)
+	if !ok {
+		return
+	}
+	if req.UserID == file.owner() {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "A file can't be shared with its owner")
+		return
+	}
+
+	var shares int
+	if err := s.db.GetContext(r.Context(), &shares,
+		"SELECT COUNT(*) FROM file_shares WHERE file_id = $1 AND user_id <> $2", file.ID, req.UserID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to share file")
+		return
+	}
+	if shares >= maxFileShares {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.ShareLimit, fmt.Sprintf("A file can be shared with at most %d users", maxFileShares))
+		return
+	}
+	if _, err := s.db.ExecContext(r.Context(),
+		"INSERT INTO file_shares (file_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", file.ID, req.UserID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to share file")
+		return
+	}
+	if file.ACL == types.FileACLPrivate {
+		if _, err := s.db.ExecContext(r.Context(),
+			"UPDATE stored_files SET acl = $1 WHERE id = $2 AND acl = $3", types.FileACLShared, file.ID, types.FileACLPrivate); err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to share file")
+			return
+		}
+		file.ACL = types.FileACLShared
+	}
+	s.audit.Request(r, "share_file", "file", file.ID, audit.Success, map[string]interface{}{"user_id": req.UserID})
+	s.respondFileACL(w, r, file)
+}
+
+// unshareFile stops sharing a file with a user
+func (s *StorageService) unshareFile(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+	file, ok := s.sharedFile(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.db.ExecContext(r.Context(),
+		"DELETE FROM file_shares WHERE file_id = $1 AND user_id = $2", file.ID, userID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove share")
+		return
+	}
+	s.audit.Request(r, "unshare_file", "file", file.ID, audit.Success, map[string]interface{}{"user_id": userID})
+	s.respondFileACL(w, r, file)
+}
+
+// respondFileACL answers with who can now read a file
+func (s *StorageService) respondFileACL(w http.ResponseWriter, r *http.Request, file storedFile) {
+	acl, err := s.fileACL(r.Context(), file)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch file ACL")
+		return
+	}
+	utils.SuccessResponse(w, acl)
+}
+
+// downloadPublic serves the current version of a public-read file to
+// anyone, without a token or signature
+func (s *StorageService) downloadPublic(w http.ResponseWriter, r *http.Request) {
+	var acl string
+	err := s.db.GetContext(r.Context(), &acl, "SELECT acl FROM stored_files WHERE id = $1", mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && acl != types.FileACLPublicRead) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return
+	}
+	q := r.URL.Query()
+	q.Del("version")
+	r.URL.RawQuery = q.Encode()
+	s.downloadFile(w, r)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// The format of audio samples is read when they are uploaded, so the voice
+// service can check clone sources without the file being read again
+
+// audioMetadata is the format of an upload reported to the client, nil for
+// files that aren't audio
+func audioMetadata(info audio.Info) map[string]interface{} {
+	if info.Format == "" {
+		return nil
+	}
+	metadata := map[string]interface{}{
+		"format":      info.Format,
+		"channels":    info.Channels,
+		"sample_rate": info.SampleRate,
+		"duration_ms": info.Duration().Milliseconds(),
+	}
+	if info.Codec != "" {
+		metadata["codec"] = info.Codec
+	}
+	if info.BitsPerSample != 0 {
+		metadata["bits_per_sample"] = info.BitsPerSample
+	}
+	return metadata
+}
+
+// recordedAudio is the format of a file's version recorded on upload
+type recordedAudio struct {
+	Size           int64          `db:"size_bytes"`
+	Format         sql.NullString `db:"audio_format"`
+	Codec          sql.NullString `db:"audio_codec"`
+	Channels       sql.NullInt64  `db:"channels"`
+	SampleRate     sql.NullInt64  `db:"sample_rate"`
+	BitsPerSample  sql.NullInt64  `db:"bits_per_sample"`
+	DurationMS     sql.NullInt64  `db:"duration_ms"`
+	NormalizedSize sql.NullInt64  `db:"normalized_size"`
+}
+
+// recordedAudio reads the format recorded for the file's version
+func (s *StorageService) recordedAudio(ctx context.Context, file storedFile) (recordedAudio, error) {
+	var recorded recordedAudio
+	query := `SELECT size_bytes, audio_format, audio_codec, channels, sample_rate, bits_per_sample, duration_ms, normalized_size
+		FROM stored_files WHERE id = $1 AND version = $2`
+	if file.Archived {
+		query = `SELECT size_bytes, audio_format, audio_codec, channels, sample_rate, bits_per_sample, duration_ms, normalized_size
+		FROM file_versions WHERE file_id = $1 AND version = $2`
+	}
+	err := s.db.GetContext(ctx, &recorded, query, file.ID, file.Version)
+	return recorded, err
+}
+
+// getAudioInfo returns a stored file's audio format for the voice service,
+// which validates clone sources before accepting a job. The format recorded
+// on upload is used when there is one; other files are probed. The variant
+// parameter selects the version described, as for downloads. Quarantined
+// files are described too, flagged so the voice service refuses them.
+func (s *StorageService) getAudioInfo(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	if file, ok = s.fileVersion(w, r, file); !ok {
+		return
+	}
+	variant, _, _, ok := fileVariant(w, r, file)
+	if !ok {
+		return
+	}
+
+	info := types.AudioInfo{ID: file.ID, Filename: file.Filename, Variant: variant, Normalized: file.NormalizedKey.Valid,
+		Quarantined: file.Quarantined, Version: file.Version}
+	if file.UserID.Valid {
+		owner := file.owner()
+		info.UserID = &owner
+	}
+
+	recorded, err := s.recordedAudio(r.Context(), file)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return
+	}
+	if variant == VariantNormalized {
+		info.SizeBytes = recorded.NormalizedSize.Int64
+		info.Format = audio.FormatWAV
+		info.Channels = normalizedChannels
+		info.SampleRate = normalizedSampleRate
+		info.BitsPerSample = normalizedBitsPerSample
+		info.DurationMS = recorded.DurationMS.Int64
+		utils.SuccessResponse(w, info)
+		return
+	}
+	if recorded.Format.Valid {
+		info.SizeBytes = recorded.Size
+		info.Format = recorded.Format.String
+		info.Codec = recorded.Codec.String
+		info.Channels = int(recorded.Channels.Int64)
+		info.SampleRate = int(recorded.SampleRate.Int64)
+		info.BitsPerSample = int(recorded.BitsPerSample.Int64)
+		info.DurationMS = recorded.DurationMS.Int64
+		utils.SuccessResponse(w, info)
+		return
+	}
+
+	stat, err := s.storage.Stat(r.Context(), file.Key)
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to read file")
+		return
+	}
+	info.SizeBytes = stat.Size
+
+	filePath, cleanup, err := localCopy(r.Context(), s.storage, file.Key)
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to read file")
+		return
+	}
+	defer cleanup()
+
+	probed, err := audio.Probe(filePath)
+	if err == nil {
+		info.Format = probed.Format
+		info.Codec = probed.Codec
+		info.SampleRate = int(probed.SampleRate)
+		info.Channels = int(probed.Channels)
+		info.BitsPerSample = int(probed.BitsPerSample)
+		info.DurationMS = probed.Duration().Milliseconds()
+	}
+	utils.SuccessResponse(w, info)
+}
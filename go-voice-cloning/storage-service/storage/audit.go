@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/signedurl"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Every download, deletion and presigned link of a file is logged, whoever
+// asked and however it went, so it can be answered who accessed a
+// recording. Admins' accesses to users' files are also kept, with their
+// justification, in the file access audit. Deletions, denied accesses and
+// admins' accesses go to the central audit log too.
+
+// Results of a file access
+const (
+	AccessSucceeded = "success"
+	AccessDenied    = "denied"
+	AccessNotFound  = "not_found"
+	AccessRejected  = "rejected"
+	AccessFailed    = "error"
+)
+
+// maxAccessEvents bounds the events listed at once
+const maxAccessEvents = 500
+
+// AccessEvent is a logged access to a file. UserID is the caller, absent
+// for internal calls and signed links; OwnerID the file's owner, absent for
+// internal files and files that weren't found.
+type AccessEvent struct {
+	ID        int64     `json:"id" db:"id"`
+	FileID    string    `json:"file_id" db:"file_id"`
+	OwnerID   *int      `json:"owner_id" db:"owner_id"`
+	UserID    *int      `json:"user_id" db:"user_id"`
+	Role      string    `json:"role,omitempty" db:"role"`
+	Signed    bool      `json:"signed" db:"signed"`
+	Action    string    `json:"action" db:"action"`
+	Result    string    `json:"result" db:"result"`
+	Status    int       `json:"status" db:"status"`
+	IP        string    `json:"ip" db:"ip"`
+	RequestID string    `json:"request_id,omitempty" db:"request_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+const accessEventColumns = `id, file_id, owner_id, user_id, COALESCE(role, '') AS role, signed, action, result, status,
+	COALESCE(ip, '') AS ip, COALESCE(request_id, '') AS request_id, created_at`
+
+// accessedFile is a file a request resolved, and whether the caller was
+// allowed to use it
+type accessedFile struct {
+	id      string
+	owner   sql.NullInt64
+	allowed bool
+}
+
+// accessLog gathers what a logged request accessed
+type accessLog struct {
+	files  []accessedFile
+	signed bool
+}
+
+type accessLogKey struct{}
+
+// noteFileAccess adds a file to the request's access log, if it's logged.
+// Requests resolving the file of their {id} note it themselves; others,
+// such as archives, note each file they serve.
+func noteFileAccess(r *http.Request, file storedFile, allowed bool) {
+	entry, ok := r.Context().Value(accessLogKey{}).(*accessLog)
+	if !ok {
+		return
+	}
+	entry.files = append(entry.files, accessedFile{id: file.ID, owner: file.UserID, allowed: allowed})
+	entry.signed = entry.signed || signedurl.IsSigned(r)
+}
+
+// statusRecorder keeps the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// logFileAccess logs the action on the files a request accesses, once it's
+// answered. A request that was refused before resolving a file is logged
+// against the {id} it asked for.
+func (s *StorageService) logFileAccess(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := &accessLog{}
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			files := entry.files
+			if len(files) == 0 {
+				id := mux.Vars(r)["id"]
+				if id == "" {
+					return
+				}
+				files = []accessedFile{{id: id, allowed: true}}
+			}
+			for _, file := range files {
+				result := accessResult(status)
+				if !file.allowed {
+					result = AccessDenied
+				}
+				s.recordAccessEvent(r, file, entry.signed, action, result, status)
+				if action == accessDelete || result == AccessDenied {
+					s.auditFileAccess(r, file, entry.signed, action, result, status)
+				}
+			}
+		})
+	}
+}
+
+// accessResult classifies the status an access was answered with
+func accessResult(status int) string {
+	switch {
+	case status < 400:
+		return AccessSucceeded
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return AccessDenied
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return AccessNotFound
+	case status < 500:
+		return AccessRejected
+	default:
+		return AccessFailed
+	}
+}
+
+// recordAccessEvent writes an access to the log. A failure is logged
+// rather than failing the request, which has been answered.
+func (s *StorageService) recordAccessEvent(r *http.Request, file accessedFile, signed bool, action, result string, status int) {
+	var userID sql.NullInt64
+	if id := middleware.UserID(r); id != 0 {
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO file_access_events (file_id, owner_id, user_id, role, signed, action, result, status, ip, request_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''))`,
+		file.id, file.owner, userID, r.Header.Get("X-User-Role"), signed, action, result, status,
+		clientIP(r), r.Header.Get(utils.RequestIDHeader))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to log file access", "action", action, "file_id", file.id, "error", err)
+	}
+}
+
+// auditFileAccess records deletions, and accesses that were denied, to the
+// central audit log as well
+func (s *StorageService) auditFileAccess(r *http.Request, file accessedFile, signed bool, action, result string, status int) {
+	outcome := audit.Failure
+	switch result {
+	case AccessSucceeded:
+		outcome = audit.Success
+	case AccessDenied:
+		outcome = audit.Denied
+	}
+	details := map[string]interface{}{"result": result, "status": status, "signed": signed}
+	if file.owner.Valid {
+		details["owner_id"] = file.owner.Int64
+	}
+	s.audit.Request(r, action+"_file", "file", file.id, outcome, details)
+}
+
+// clientIP is the address the gateway received the request from, which it
+// appends to X-Forwarded-For, or the direct caller's
+func clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		addr = strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return addr
+}
+
+// listAccessEvents queries the access log (admin), newest first, by
+// ?file_id=, ?user_id= (the caller), ?owner_id=, ?action=, ?result=, and
+// ?since= and ?until= (RFC 3339). ?before= takes the id of the last event
+// of a page to list the next one.
+func (s *StorageService) listAccessEvents(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	q := r.URL.Query()
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	for _, filter := range []string{"user_id", "owner_id", "before"} {
+		v := q.Get(filter)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid "+filter)
+			return
+		}
+		if filter == "before" {
+			where = append(where, "id < "+arg(id))
+		} else {
+			where = append(where, filter+" = "+arg(id))
+		}
+	}
+	if v := q.Get("file_id"); v != "" {
+		where = append(where, "file_id = "+arg(v))
+	}
+	if v := q.Get("action"); v != "" {
+		if v != accessDownload && v != accessDelete && v != accessPresign {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "action must be download, delete or presign")
+			return
+		}
+		where = append(where, "action = "+arg(v))
+	}
+	if v := q.Get("result"); v != "" {
+		switch v {
+		case AccessSucceeded, AccessDenied, AccessNotFound, AccessRejected, AccessFailed:
+		default:
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "result must be success, denied, not_found, rejected or error")
+			return
+		}
+		where = append(where, "result = "+arg(v))
+	}
+	for _, bound := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, bound.param+" must be an RFC 3339 time")
+			return
+		}
+		where = append(where, "created_at "+bound.op+" "+arg(t.UTC()))
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAccessEvents {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("limit must be between 1 and %d", maxAccessEvents))
+			return
+		}
+		limit = n
+	}
+
+	events := []AccessEvent{}
+	err := dbroute.Reader(r, s.db, s.replica).SelectContext(r.Context(), &events,
+		"SELECT "+accessEventColumns+" FROM file_access_events WHERE "+strings.Join(where, " AND ")+
+			" ORDER BY id DESC LIMIT "+arg(limit), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch access events")
+		return
+	}
+	response := map[string]interface{}{"events": events}
+	if len(events) == limit {
+		response["next_before"] = events[len(events)-1].ID
+	}
+	utils.SuccessResponse(w, response)
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureAPIVersion is the Blob service REST API version requests are made
+// with
+const azureAPIVersion = "2021-08-06"
+
+// AzureConfig locates a blob container and the credentials to access it
+type AzureConfig struct {
+	Account string
+	// Key is the account's shared key, base64 encoded as the portal shows
+	// it. SASToken authorizes requests instead when there is no key.
+	Key      string
+	SASToken string
+	// Endpoint is the account's blob service URL, by default
+	// https://<account>.blob.core.windows.net; Azurite's includes the
+	// account in the path
+	Endpoint  string
+	Container string
+	// Prefix is prepended to the names of stored files
+	Prefix string
+}
+
+// AzureSettings locate the Azure container. The account is authorized with
+// its key or a SAS token.
+type AzureSettings struct {
+	Account    string `env:"AZURE_STORAGE_ACCOUNT"`
+	Key        string `env:"AZURE_STORAGE_KEY" secret:"true"`
+	SASToken   string `env:"AZURE_STORAGE_SAS_TOKEN" secret:"true"`
+	Endpoint   string `env:"AZURE_STORAGE_ENDPOINT"`
+	Container  string `env:"AZURE_STORAGE_CONTAINER"`
+	Prefix     string `env:"AZURE_STORAGE_PREFIX"`
+	ColdPrefix string `env:"AZURE_STORAGE_COLD_PREFIX"`
+}
+
+// azureConfig checks the container's settings
+func azureConfig(settings AzureSettings) (AzureConfig, error) {
+	cfg := AzureConfig{
+		Account:   settings.Account,
+		Key:       settings.Key,
+		SASToken:  strings.TrimPrefix(settings.SASToken, "?"),
+		Endpoint:  settings.Endpoint,
+		Container: settings.Container,
+		Prefix:    settings.Prefix,
+	}
+	if cfg.Account == "" || cfg.Container == "" {
+		return cfg, fmt.Errorf("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_CONTAINER are required")
+	}
+	if cfg.Key == "" && cfg.SASToken == "" {
+		return cfg, fmt.Errorf("AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://" + cfg.Account + ".blob.core.windows.net"
+	}
+	return cfg, nil
+}
+
+// AzureStorage keeps files as block blobs in an Azure Storage container,
+// authorizing requests with the account's shared key or a SAS token. Blobs
+// are written in a single request, so files are limited to 5000 MiB.
+type AzureStorage struct {
+	cfg      AzureConfig
+	key      []byte
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewAzureStorage checks the configuration; the container must already
+// exist
+func NewAzureStorage(cfg AzureConfig) (*AzureStorage, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid Azure storage endpoint %q", cfg.Endpoint)
+	}
+	a := &AzureStorage{cfg: cfg, endpoint: endpoint, client: &http.Client{}}
+	if cfg.Key != "" {
+		if a.key, err = base64.StdEncoding.DecodeString(cfg.Key); err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+		}
+	}
+	return a, nil
+}
+
+func (a *AzureStorage) blob(name string) string {
+	return a.cfg.Prefix + name
+}
+
+func (a *AzureStorage) location(name string) string {
+	return a.endpoint.String() + "/" + a.cfg.Container + "/" + a.blob(name)
+}
+
+// Put streams the body as a block blob, which Azure needs the size of up
+// front
+func (a *AzureStorage) Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error) {
+	if size < 0 {
+		return FileInfo{}, fmt.Errorf("azure: size of %s unknown", name)
+	}
+	header := http.Header{}
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	header.Set("Content-Type", "application/octet-stream")
+	res, err := a.do(ctx, http.MethodPut, a.blob(name), nil, header, io.NopCloser(r), size)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res.Body.Close()
+	return FileInfo{Name: name, Size: size, ModTime: time.Now(), Location: a.location(name)}, nil
+}
+
+func (a *AzureStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := a.do(ctx, http.MethodGet, a.blob(name), nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (a *AzureStorage) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	res, err := a.do(ctx, http.MethodGet, a.blob(name), nil, rangeHeader(offset, length), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (a *AzureStorage) Delete(ctx context.Context, name string) error {
+	res, err := a.do(ctx, http.MethodDelete, a.blob(name), nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// List returns the files directly under the prefix, paging through the
+// container listing
+func (a *AzureStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {a.blob(prefix)}, "delimiter": {"/"}}
+	for {
+		res, err := a.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					ContentLength int64  `xml:"Content-Length"`
+					LastModified  string `xml:"Last-Modified"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("azure: invalid listing: %w", err)
+		}
+
+		for _, blob := range page.Blobs {
+			name := strings.TrimPrefix(blob.Name, a.blob(prefix))
+			modTime, _ := http.ParseTime(blob.Properties.LastModified)
+			files = append(files, FileInfo{Name: name, Size: blob.Properties.ContentLength, ModTime: modTime, Location: a.location(prefix + name)})
+		}
+		if page.NextMarker == "" {
+			return files, nil
+		}
+		query.Set("marker", page.NextMarker)
+	}
+}
+
+func (a *AzureStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	res, err := a.do(ctx, http.MethodHead, a.blob(name), nil, nil, nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res.Body.Close()
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: res.ContentLength, ModTime: modTime, Location: a.location(name)}, nil
+}
+
+// do sends an authorized request for a blob, or the container when blob is
+// empty, and turns error responses into BackendErrors
+func (a *AzureStorage) do(ctx context.Context, method, blob string, query url.Values, header http.Header, body io.ReadCloser, size int64) (*http.Response, error) {
+	u := *a.endpoint
+	u.Path += "/" + a.cfg.Container
+	if blob != "" {
+		u.Path += "/" + blob
+	}
+	u.RawQuery = query.Encode()
+	if a.key == nil {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += a.cfg.SASToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	if a.key != nil {
+		a.sign(req)
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	// HEAD responses carry the error code in a header only
+	backendErr := &BackendError{Backend: BackendAzure, StatusCode: res.StatusCode, Code: res.Header.Get("X-Ms-Error-Code")}
+	xml.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(backendErr)
+	backendErr.NotFound = backendErr.Code == "BlobNotFound" ||
+		blob != "" && res.StatusCode == http.StatusNotFound && backendErr.Code == ""
+	return nil, backendErr
+}
+
+// sign adds the Shared Key Authorization header to req
+func (a *AzureStorage) sign(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	headers := []string{}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower)
+		}
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	// The resource is the account followed by the request's path, which
+	// for Azurite starts with the account too
+	var resource strings.Builder
+	resource.WriteString("/" + a.cfg.Account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		resource.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, sent as x-ms-date instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + resource.String(),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+a.cfg.Account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Storage keeps the contents of stored files under slash-separated names,
+// such as a user's prefix followed by the filename. Missing files are
+// reported with errors matching fs.ErrNotExist.
+type Storage interface {
+	// Put stores size bytes read from r under name, replacing any file of
+	// the same name only once all of it was written
+	Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error)
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// GetRange reads length bytes of a file from offset on
+	GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	// List returns the files directly under prefix, which ends with a slash
+	// or is empty, named relative to it
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+	Stat(ctx context.Context, name string) (FileInfo, error)
+}
+
+// FileInfo describes a stored file. Location is where the backend keeps
+// it, for operators.
+type FileInfo struct {
+	Name     string
+	Size     int64
+	ModTime  time.Time
+	Location string
+}
+
+// Storage backends selected with STORAGE_BACKEND
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+	BackendAzure = "azure"
+)
+
+// storageRetryAfter is how long clients are told to wait when the backend is
+// unavailable
+const storageRetryAfter = 5 * time.Second
+
+// BackendError is an error response of a remote storage backend. NotFound
+// is set when the file is missing, rather than its bucket or container.
+type BackendError struct {
+	Backend    string
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	NotFound   bool
+}
+
+func (e *BackendError) Error() string {
+	if e.Code == "" && e.Message == "" {
+		return fmt.Sprintf("%s: %s", e.Backend, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%s: %d %s: %s", e.Backend, e.StatusCode, e.Code, e.Message)
+}
+
+// Is makes missing files match fs.ErrNotExist
+func (e *BackendError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.NotFound
+}
+
+// BackendSettings select where files are kept, with the settings of each
+// backend
+type BackendSettings struct {
+	Backend string `env:"STORAGE_BACKEND" default:"local"`
+	// Path and ColdPath are the local backend's directories
+	Path     string `env:"STORAGE_PATH" default:"./storage"`
+	ColdPath string `env:"COLD_STORAGE_PATH"`
+	S3       S3Settings
+	GCS      GCSSettings
+	Azure    AzureSettings
+}
+
+// openStorage opens the standard and cold tiers of the configured backend:
+// directories on local disk by default, or prefixes of an S3 or GCS bucket
+// or an Azure container
+func openStorage(settings BackendSettings, aws AWSSettings) (standard, cold Storage, err error) {
+	switch settings.Backend {
+	case BackendLocal:
+		if standard, err = NewLocalStorage(settings.Path); err != nil {
+			return nil, nil, err
+		}
+		if cold, err = NewLocalStorage(coldStoragePath(settings)); err != nil {
+			return nil, nil, err
+		}
+		return standard, cold, nil
+	case BackendS3:
+		cfg, err := s3Config("", settings.S3, aws.credentials())
+		if err != nil {
+			return nil, nil, err
+		}
+		if standard, err = NewS3Storage(cfg); err != nil {
+			return nil, nil, err
+		}
+		cfg.Prefix = coldPrefix(settings.S3.ColdPrefix, cfg.Prefix)
+		if cold, err = NewS3Storage(cfg); err != nil {
+			return nil, nil, err
+		}
+		return standard, cold, nil
+	case BackendGCS:
+		cfg, err := gcsConfig(settings.GCS)
+		if err != nil {
+			return nil, nil, err
+		}
+		if standard, err = NewGCSStorage(cfg); err != nil {
+			return nil, nil, err
+		}
+		cfg.Prefix = coldPrefix(settings.GCS.ColdPrefix, cfg.Prefix)
+		if cold, err = NewGCSStorage(cfg); err != nil {
+			return nil, nil, err
+		}
+		return standard, cold, nil
+	case BackendAzure:
+		cfg, err := azureConfig(settings.Azure)
+		if err != nil {
+			return nil, nil, err
+		}
+		if standard, err = NewAzureStorage(cfg); err != nil {
+			return nil, nil, err
+		}
+		cfg.Prefix = coldPrefix(settings.Azure.ColdPrefix, cfg.Prefix)
+		if cold, err = NewAzureStorage(cfg); err != nil {
+			return nil, nil, err
+		}
+		return standard, cold, nil
+	}
+	return nil, nil, fmt.Errorf("unknown STORAGE_BACKEND %q", settings.Backend)
+}
+
+// LocalStorage keeps files in a directory on local disk
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates the directory if it doesn't exist
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+// path is where a file is kept. Names are rooted before being cleaned, so
+// they can't reach outside the directory.
+func (l *LocalStorage) path(name string) string {
+	return filepath.Join(l.dir, filepath.Clean("/"+name))
+}
+
+// Put writes to a temporary file first, so a failed write never replaces
+// an existing file of the same name
+func (l *LocalStorage) Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error) {
+	tmp, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path(name)), 0755); err != nil {
+		return FileInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), l.path(name)); err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: name, Size: n, ModTime: time.Now(), Location: l.path(name)}, nil
+}
+
+func (l *LocalStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(l.path(name))
+}
+
+func (l *LocalStorage) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, name string) error {
+	return os.Remove(l.path(name))
+}
+
+// List skips directories, such as the cold tier's default one, and
+// dotfiles, which are uploads still being written
+func (l *LocalStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	entries, err := os.ReadDir(l.path(prefix))
+	if isNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime(), Location: l.path(prefix + entry.Name())})
+	}
+	return files, nil
+}
+
+func (l *LocalStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	info, err := os.Stat(l.path(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if info.IsDir() {
+		return FileInfo{}, &fs.PathError{Op: "stat", Path: l.path(name), Err: fs.ErrNotExist}
+	}
+	return FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime(), Location: l.path(name)}, nil
+}
+
+// localCopy gives a stored file's path on local disk, downloading it to a
+// temporary file for remote backends. Call cleanup once done with it.
+func localCopy(ctx context.Context, storage Storage, name string) (path string, cleanup func(), err error) {
+	if local, ok := storage.(*LocalStorage); ok {
+		return local.path(name), func() {}, nil
+	}
+
+	src, err := storage.Get(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp("", "storage-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(tmp.Name()) }
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// healthProbeName is a file that is never stored, which the readiness probe
+// stats to reach the backend
+const healthProbeName = ".health-probe"
+
+// probeStorage checks that a backend answers. A missing file is an answer.
+func probeStorage(s Storage) health.Check {
+	return func(ctx context.Context) error {
+		_, err := s.Stat(ctx, healthProbeName)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+		return nil
+	}
+}
+
+// isNotExist reports whether err means a file isn't stored
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// backendUnavailable reports whether err means the backend couldn't be
+// reached, timed out or is throttling, so the call may succeed later
+func backendUnavailable(err error) bool {
+	var backendErr *BackendError
+	if errors.As(err, &backendErr) {
+		return backendErr.StatusCode == http.StatusTooManyRequests || backendErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// storageError answers a failed backend call: 404 for a missing file, 503
+// with retry guidance when the backend is unavailable, otherwise 500 with
+// message. Failures other than missing files are logged.
+func storageError(w http.ResponseWriter, err error, filename, message string) {
+	if isNotExist(err) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return
+	}
+	slog.Error("Storage backend failed", "file", filename, "error", err)
+	if backendUnavailable(err) {
+		utils.RetryResponse(w, http.StatusServiceUnavailable, "Storage backend unavailable, try again later",
+			utils.NewRetryGuidance(utils.RetryReasonUpstreamUnavailable, storageRetryAfter))
+		return
+	}
+	utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, message)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Clients can send the checksums of what they upload, which are verified
+// before the file is stored, so corruption on the way is caught. Resumable
+// uploads keep them with the session until it's completed.
+
+// expectedChecksums are the checksums a client sent with an upload: the
+// SHA-256 in hex and the MD5 in base64, as in a Content-MD5 header. Either
+// may be empty.
+type expectedChecksums struct {
+	sha256 string
+	md5    string
+}
+
+// checksumMismatch is an upload whose content doesn't match a checksum the
+// client sent
+type checksumMismatch struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *checksumMismatch) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// parseChecksums validates checksums sent by a client. Its errors are meant
+// for the client.
+func parseChecksums(sha256Hex, md5Base64 string) (expectedChecksums, error) {
+	expected := expectedChecksums{sha256: strings.ToLower(strings.TrimSpace(sha256Hex)), md5: strings.TrimSpace(md5Base64)}
+	if expected.sha256 != "" {
+		if b, err := hex.DecodeString(expected.sha256); err != nil || len(b) != 32 {
+			return expected, errors.New("checksum_sha256 must be 64 hexadecimal characters")
+		}
+	}
+	if expected.md5 != "" {
+		if b, err := base64.StdEncoding.DecodeString(expected.md5); err != nil || len(b) != 16 {
+			return expected, errors.New("Content-MD5 must be the base64 of a 16-byte MD5")
+		}
+	}
+	return expected, nil
+}
+
+// verify compares the checksums of the staged content with those expected,
+// returning nil if they match
+func (e expectedChecksums) verify(sha256Hex, md5Base64 string) *checksumMismatch {
+	if e.sha256 != "" && e.sha256 != sha256Hex {
+		return &checksumMismatch{Algorithm: "sha256", Expected: e.sha256, Actual: sha256Hex}
+	}
+	if e.md5 != "" && e.md5 != md5Base64 {
+		return &checksumMismatch{Algorithm: "md5", Expected: e.md5, Actual: md5Base64}
+	}
+	return nil
+}
+
+// writeChecksumMismatch answers an upload that failed verification
+func writeChecksumMismatch(w http.ResponseWriter, err *checksumMismatch) {
+	body := utils.ErrorBody(w, apierror.ChecksumMismatch, "Upload doesn't match its "+err.Algorithm+" checksum")
+	body["algorithm"] = err.Algorithm
+	body["expected"] = err.Expected
+	body["actual"] = err.Actual
+	utils.JSONResponse(w, http.StatusBadRequest, body)
+}
+
+// setDigestHeaders identifies content by its SHA-256: the ETag is the
+// checksum in hex and the Digest header (RFC 3230) its base64. Range
+// responses carry the digest of the whole file.
+func setDigestHeaders(w http.ResponseWriter, sha256Hex string) bool {
+	sum, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(sum) != 32 {
+		return false
+	}
+	w.Header().Set("ETag", `"`+sha256Hex+`"`)
+	w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	return true
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// When the voice service purges a clone it adds a clone.deleted event to the
+// clone lifecycle outbox, naming the stored files that belonged only to the
+// clone: its outputs, previews, models and intermediate artifacts. Each event
+// becomes a collection that removes those files. A file is only removed if
+// it belongs to the clone's owner and no other clone uses it; the others are
+// kept and listed as unattributed for an admin to look into.
+
+// Collection statuses
+const (
+	CollectionPending   = "pending"
+	CollectionRunning   = "running"
+	CollectionCompleted = "completed"
+	CollectionFailed    = "failed"
+)
+
+// Reasons a file named by a clone.deleted event is kept
+const (
+	// UnattributedOwner is a file of another user than the clone's
+	UnattributedOwner = "other_owner"
+	// UnattributedInternal is an object without an owner, which can't be
+	// told apart from the services' own
+	UnattributedInternal = "internal"
+	// UnattributedReferenced is a file another clone still uses
+	UnattributedReferenced = "still_referenced"
+)
+
+const (
+	// maxCollectionAttempts before a collection is left failed for an admin
+	maxCollectionAttempts = 5
+	// collectionStaleAfter reclaims collections of an instance that died
+	// mid-run
+	collectionStaleAfter = 10 * time.Minute
+)
+
+// CloneCollection is the progress, and once finished the outcome, of
+// removing a purged clone's files
+type CloneCollection struct {
+	EventID      int64          `json:"event_id" db:"event_id"`
+	CloneID      int            `json:"clone_id" db:"clone_id"`
+	UserID       int            `json:"user_id" db:"user_id"`
+	Files        pq.StringArray `json:"files" db:"files"`
+	Status       string         `json:"status" db:"status"`
+	Attempts     int            `json:"attempts" db:"attempts"`
+	FilesDeleted int            `json:"files_deleted" db:"files_deleted"`
+	FilesMissing int            `json:"files_missing" db:"files_missing"`
+	FilesKept    int            `json:"files_kept" db:"files_kept"`
+	Error        string         `json:"error,omitempty" db:"error"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at" db:"updated_at"`
+	CompletedAt  *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+const collectionColumns = `event_id, clone_id, user_id, files, status, attempts, files_deleted, files_missing, files_kept,
+	COALESCE(error, '') AS error, created_at, updated_at, completed_at`
+
+// UnattributedObject is a file a clone.deleted event named that wasn't
+// removed, because it couldn't be attributed to the clone
+type UnattributedObject struct {
+	FileID     string    `json:"file_id" db:"file_id"`
+	EventID    int64     `json:"event_id" db:"event_id"`
+	CloneID    int       `json:"clone_id" db:"clone_id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	OwnerID    *int      `json:"owner_id" db:"owner_id"`
+	Reason     string    `json:"reason" db:"reason"`
+	DetectedAt time.Time `json:"detected_at" db:"detected_at"`
+}
+
+// runCloneGC removes the files of purged clones, polling the lifecycle
+// outbox every interval until ctx is cancelled
+func (s *StorageService) runCloneGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.claimCloneDeletions(ctx); err != nil {
+			slog.Error("Failed to read clone deletions", "error", err)
+		}
+		for ctx.Err() == nil {
+			collection, err := s.nextCollection(ctx)
+			if err != nil {
+				slog.Error("Failed to start clone collection", "error", err)
+				break
+			}
+			if collection == nil {
+				break
+			}
+			s.runCollection(ctx, collection)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimCloneDeletions records a pending collection for every clone.deleted
+// event not seen yet
+func (s *StorageService) claimCloneDeletions(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO clone_collections (event_id, clone_id, user_id, files, status)
+		SELECT id, clone_id, user_id, COALESCE(files, '{}'), $1 FROM clone_lifecycle_events
+		WHERE event = $2 AND id > (SELECT COALESCE(MAX(event_id), 0) FROM clone_collections)
+		ON CONFLICT (event_id) DO NOTHING`,
+		CollectionPending, types.LifecycleCloneDeleted)
+	return err
+}
+
+// nextCollection claims the oldest pending collection, or one whose
+// instance stopped reporting progress
+func (s *StorageService) nextCollection(ctx context.Context) (*CloneCollection, error) {
+	var collection CloneCollection
+	err := s.db.GetContext(ctx, &collection,
+		`UPDATE clone_collections SET status = $1, attempts = attempts + 1, updated_at = NOW()
+		WHERE event_id = (
+			SELECT event_id FROM clone_collections
+			WHERE status = $2 OR (status = $1 AND updated_at < $3)
+			ORDER BY event_id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING `+collectionColumns,
+		CollectionRunning, CollectionPending, time.Now().Add(-collectionStaleAfter))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// runCollection removes a purged clone's files and records the outcome. A
+// failed attempt goes back to pending until it has been tried
+// maxCollectionAttempts times. Files an earlier attempt removed are found
+// missing by the next, so they are counted as deleted rather than missing.
+func (s *StorageService) runCollection(ctx context.Context, collection *CloneCollection) {
+	err := s.collectClone(ctx, collection)
+	if err == nil {
+		_, err = s.db.ExecContext(ctx,
+			`UPDATE clone_collections SET status = $1, files_deleted = files_deleted + $2,
+				files_missing = GREATEST($3 - files_deleted, 0), files_kept = $4, error = NULL, completed_at = NOW(), updated_at = NOW()
+			WHERE event_id = $5`,
+			CollectionCompleted, collection.FilesDeleted, collection.FilesMissing, collection.FilesKept, collection.EventID)
+		if err != nil {
+			slog.Error("Failed to complete clone collection", "clone_id", collection.CloneID, "error", err)
+		}
+		if collection.FilesDeleted > 0 || collection.FilesKept > 0 {
+			slog.Info("Removed the files of a purged voice clone", "clone_id", collection.CloneID,
+				"files_deleted", collection.FilesDeleted, "files_kept", collection.FilesKept)
+		}
+		return
+	}
+
+	// An attempt cut short by shutdown is resumed by the next instance
+	status := CollectionPending
+	if collection.Attempts >= maxCollectionAttempts && ctx.Err() == nil {
+		status = CollectionFailed
+	}
+	slog.Error("Collection of a purged voice clone failed", "clone_id", collection.CloneID, "attempt", collection.Attempts, "error", err)
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE clone_collections SET status = $1, files_deleted = files_deleted + $2, error = $3, updated_at = NOW()
+		WHERE event_id = $4`,
+		status, collection.FilesDeleted, err.Error(), collection.EventID)
+	if err != nil {
+		slog.Error("Failed to record clone collection failure", "clone_id", collection.CloneID, "error", err)
+	}
+}
+
+// collectClone removes the files of a collection that belong to the clone's
+// owner and no other clone uses, counting them in the collection
+func (s *StorageService) collectClone(ctx context.Context, collection *CloneCollection) error {
+	collection.FilesDeleted, collection.FilesMissing, collection.FilesKept = 0, 0, 0
+	if len(collection.Files) == 0 {
+		return nil
+	}
+	// The voice service can't be asked about more files at once
+	for start := 0; start < len(collection.Files); start += janitorBatchSize {
+		end := start + janitorBatchSize
+		if end > len(collection.Files) {
+			end = len(collection.Files)
+		}
+		if err := s.collectFiles(ctx, collection, collection.Files[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageService) collectFiles(ctx context.Context, collection *CloneCollection, ids []string) error {
+	// A file can have been reused by another clone since the voice service
+	// decided it belonged only to this one
+	referenced, err := s.referencedFiles(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("checking references: %w", err)
+	}
+
+	for _, id := range ids {
+		if !validFileID(id) {
+			collection.FilesMissing++
+			continue
+		}
+		file, err := s.resolveFile(ctx, id)
+		if err != nil {
+			return err
+		}
+		tier, err := s.locateFile(ctx, file.Key)
+		if err != nil && !isNotExist(err) {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		if isNotExist(err) && !file.UserID.Valid {
+			// Already deleted, such as by the user's account cleanup
+			collection.FilesMissing++
+			continue
+		}
+
+		reason := ""
+		switch {
+		case !file.UserID.Valid:
+			reason = UnattributedInternal
+		case file.owner() != collection.UserID:
+			reason = UnattributedOwner
+		case referenced[file.ID]:
+			reason = UnattributedReferenced
+		}
+		if reason != "" {
+			if err := s.recordUnattributed(ctx, collection, file, reason); err != nil {
+				return err
+			}
+			collection.FilesKept++
+			continue
+		}
+
+		if err := s.removeFile(ctx, s.tierStorage(tier), file); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		collection.FilesDeleted++
+	}
+	return nil
+}
+
+// recordUnattributed lists a file a collection kept in the reconciliation
+// report
+func (s *StorageService) recordUnattributed(ctx context.Context, collection *CloneCollection, file storedFile, reason string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO unattributed_objects (event_id, file_id, clone_id, user_id, owner_id, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (event_id, file_id) DO UPDATE SET owner_id = EXCLUDED.owner_id, reason = EXCLUDED.reason`,
+		collection.EventID, file.ID, collection.CloneID, collection.UserID, file.UserID, reason)
+	return err
+}
+
+// getCloneGCReport reports the collections of purged clones, newest first
+// and optionally filtered by ?status=, and the files they kept because they
+// couldn't be attributed to the clone (admin)
+func (s *StorageService) getCloneGCReport(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+	collections := []CloneCollection{}
+	query := "SELECT " + collectionColumns + " FROM clone_collections"
+	args := []interface{}{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += " WHERE status = $1"
+		args = append(args, status)
+	}
+	if err := db.SelectContext(r.Context(), &collections, query+" ORDER BY event_id DESC LIMIT 100", args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch clone collections")
+		return
+	}
+	unattributed := []UnattributedObject{}
+	err := db.SelectContext(r.Context(), &unattributed,
+		`SELECT file_id, event_id, clone_id, user_id, owner_id, reason, detected_at FROM unattributed_objects
+		ORDER BY detected_at DESC, file_id LIMIT 500`)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch unattributed objects")
+		return
+	}
+	utils.SuccessResponse(w, map[string]interface{}{
+		"collections":  collections,
+		"unattributed": unattributed,
+	})
+}
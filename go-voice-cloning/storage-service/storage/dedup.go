@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+
+	"github.com/voice-cloning/shared/logging"
+)
+
+// Uploads are stored by content: a user's files with the same SHA-256 share
+// one blob, kept under the checksum in the user's prefix, so uploading the
+// same sample again takes no more space. Blobs are counted by the files
+// referencing them and deleted with the last one. Files stored before
+// deduplication have no blob row and are deleted directly.
+
+// blobKey is where a user's blob with the checksum is kept
+func blobKey(userID int, checksum string) string {
+	return objectKey(userID, "sha256/"+checksum)
+}
+
+// storeBlob takes a reference to the user's blob with the content of the
+// staged file, writing it only if the user has no identical blob. It
+// returns the blob's key and whether an existing blob was reused.
+func (s *StorageService) storeBlob(ctx context.Context, path string, userID int, checksum string, size int64) (string, FileInfo, bool, error) {
+	key := blobKey(userID, checksum)
+	var refs int
+	err := s.db.GetContext(ctx, &refs,
+		`INSERT INTO stored_blobs (object_key, checksum_sha256, size_bytes, ref_count) VALUES ($1, $2, $3, 1)
+		ON CONFLICT (object_key) DO UPDATE SET ref_count = stored_blobs.ref_count + 1
+		RETURNING ref_count`,
+		key, checksum, size)
+	if err != nil {
+		return "", FileInfo{}, false, err
+	}
+
+	if refs > 1 {
+		info, err := s.reuseBlob(ctx, key)
+		if err == nil {
+			return key, info, true, nil
+		}
+		if !isNotExist(err) {
+			s.releaseBlob(context.Background(), s.storage, key)
+			return "", FileInfo{}, false, err
+		}
+		// The blob is referenced but its content is missing, so it's
+		// written again
+		logging.FromContext(ctx).Warn("Blob was missing and is written again", "key", key)
+	}
+
+	staged, err := os.Open(path)
+	if err != nil {
+		s.releaseBlob(context.Background(), s.storage, key)
+		return "", FileInfo{}, false, err
+	}
+	defer staged.Close()
+	info, err := s.storage.Put(ctx, key, staged, size)
+	if err != nil {
+		s.releaseBlob(context.Background(), s.storage, key)
+		return "", FileInfo{}, false, err
+	}
+	return key, info, false, nil
+}
+
+// reuseBlob returns an existing blob, moving it back to the standard tier
+// if it was moved to cold storage with the files referencing it, as the
+// user is storing it anew
+func (s *StorageService) reuseBlob(ctx context.Context, key string) (FileInfo, error) {
+	tier, err := s.locateFile(ctx, key)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if tier == TierCold {
+		if err := moveBetween(ctx, s.cold, s.storage, key, key); err != nil {
+			return FileInfo{}, err
+		}
+		normalized := key + normalizedSuffix
+		if err := moveBetween(ctx, s.cold, s.storage, normalized, normalized); err != nil && !isNotExist(err) {
+			logging.FromContext(ctx).Error("Failed to move the normalized version to the standard tier", "key", key, "error", err)
+		}
+		s.db.ExecContext(ctx, "UPDATE stored_files SET tier = $1 WHERE object_key = $2", TierStandard, key)
+	}
+	return s.storage.Stat(ctx, key)
+}
+
+// blobNormalized returns the normalized version stored for another file of
+// the blob, if any, so a reused blob isn't transcoded again
+func (s *StorageService) blobNormalized(ctx context.Context, key string) (*string, *int64) {
+	var normalized struct {
+		Key  string `db:"normalized_key"`
+		Size int64  `db:"normalized_size"`
+	}
+	err := s.db.GetContext(ctx, &normalized,
+		`SELECT normalized_key, COALESCE(normalized_size, 0) AS normalized_size FROM stored_files
+		WHERE object_key = $1 AND normalized_key IS NOT NULL LIMIT 1`, key)
+	if err != nil {
+		return nil, nil
+	}
+	return &normalized.Key, &normalized.Size
+}
+
+// releaseBlob drops a reference to the content kept under key in storage,
+// deleting it, any normalized version and its waveform once no file
+// references it
+func (s *StorageService) releaseBlob(ctx context.Context, storage Storage, key string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var refs int
+	err = tx.GetContext(ctx, &refs,
+		"UPDATE stored_blobs SET ref_count = ref_count - 1 WHERE object_key = $1 RETURNING ref_count", key)
+	if err == nil && refs > 0 {
+		return tx.Commit()
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err := storage.Delete(ctx, key); err != nil && !isNotExist(err) {
+		return err
+	}
+	if err := storage.Delete(ctx, key+normalizedSuffix); err != nil && !isNotExist(err) {
+		logging.FromContext(ctx).Warn("Failed to delete the normalized version", "key", key, "error", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM stored_blobs WHERE object_key = $1", key); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM file_waveforms WHERE object_key = $1", key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeFile deletes a file, with its earlier versions, from the storage of
+// its tier and its metadata. Content is only deleted with the last file
+// referencing it.
+func (s *StorageService) removeFile(ctx context.Context, storage Storage, file storedFile) error {
+	if err := s.releaseBlob(ctx, storage, file.Key); err != nil {
+		return err
+	}
+	if err := s.releaseVersions(ctx, file.ID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM file_shares WHERE file_id = $1", file.ID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM stored_files WHERE id = $1", file.ID)
+	return err
+}
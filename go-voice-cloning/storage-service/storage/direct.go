@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Direct uploads send a file straight to the S3 bucket with a presigned PUT,
+// so large files don't pass through the gateway or this service. The upload
+// is checked like a resumable one when the client completes it: the object
+// is read back from its staging key, stored as a regular upload, and the
+// staged object removed.
+
+// presignedPutter is a backend clients can upload to directly
+type presignedPutter interface {
+	Storage
+	PresignPut(name string, expires time.Duration) string
+}
+
+// directBackend is the backend direct uploads are staged in, if the
+// standard tier's allows them. Staged objects are written by clients as
+// they are, so they're read back without decryption.
+func (s *StorageService) directBackend() (presignedPutter, bool) {
+	putter, ok := rawStorage(s.storage).(presignedPutter)
+	return putter, ok
+}
+
+// directUploadKey is where a direct upload is staged
+func directUploadKey(id string) string {
+	return "direct-uploads/" + id
+}
+
+// createDirectUpload starts a direct upload, returning the URL to PUT the
+// file to. The file's type, size and the quota are checked as for a
+// resumable upload.
+func (s *StorageService) createDirectUpload(w http.ResponseWriter, r *http.Request) {
+	backend, ok := s.directBackend()
+	if !ok {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Direct uploads need the S3 storage backend")
+		return
+	}
+	session, ok := s.newUploadSession(w, r)
+	if !ok {
+		return
+	}
+	urlTTL := s.uploads.directURLTTL
+	if expiresAt := session.CreatedAt.Add(urlTTL); session.ExpiresAt.Before(expiresAt) {
+		session.ExpiresAt = expiresAt
+	}
+
+	_, err := s.db.NamedExec(
+		`INSERT INTO direct_uploads (id, user_id, filename, file_type, content_type, class, size_bytes, ttl_seconds,
+			checksum_sha256, content_md5, folder, created_at, expires_at)
+		VALUES (:id, :user_id, :filename, :file_type, :content_type, :class, :size_bytes, :ttl_seconds,
+			:checksum_sha256, :content_md5, :folder, :created_at, :expires_at)`,
+		session)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record direct upload", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create direct upload")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"id":             session.ID,
+		"filename":       session.Filename,
+		"class":          session.Class,
+		"size":           session.Size,
+		"method":         http.MethodPut,
+		"upload_url":     backend.PresignPut(directUploadKey(session.ID), urlTTL),
+		"url_expires_at": session.CreatedAt.Add(urlTTL),
+		"expires_at":     session.ExpiresAt,
+	})
+}
+
+// requestedDirectUpload resolves the direct upload of the request's {id},
+// visible to the user who created it or to internal callers. Otherwise the
+// request has been answered and false is returned.
+func (s *StorageService) requestedDirectUpload(w http.ResponseWriter, r *http.Request) (uploadSession, bool) {
+	var session uploadSession
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Direct upload not found")
+		return session, false
+	}
+	err := s.db.GetContext(r.Context(), &session,
+		`SELECT id, user_id, filename, file_type, content_type, class, size_bytes, ttl_seconds, checksum_sha256, content_md5, folder,
+			created_at, expires_at
+		FROM direct_uploads WHERE id = $1 AND expires_at > NOW()`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Direct upload not found")
+		return session, false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read direct upload")
+		return session, false
+	}
+	if userID := middleware.UserID(r); userID != 0 && int64(userID) != session.UserID.Int64 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Direct upload not found")
+		return session, false
+	}
+	return session, true
+}
+
+// completeDirectUpload stores a file the client has PUT to its upload URL,
+// as an upload in one request would have been, and ends the upload
+func (s *StorageService) completeDirectUpload(w http.ResponseWriter, r *http.Request) {
+	backend, ok := s.directBackend()
+	if !ok {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Direct uploads need the S3 storage backend")
+		return
+	}
+	session, ok := s.requestedDirectUpload(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(w, session.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	key := directUploadKey(session.ID)
+	info, err := backend.Stat(r.Context(), key)
+	if isNotExist(err) {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.UploadIncomplete, "File has not been uploaded")
+		return
+	}
+	if err != nil {
+		storageError(w, err, session.ID, "Failed to check upload")
+		return
+	}
+	if info.Size != session.Size {
+		body := utils.ErrorBody(w, apierror.UploadIncomplete, "Uploaded file doesn't have the declared size")
+		body["size"] = session.Size
+		body["uploaded_size"] = info.Size
+		utils.JSONResponse(w, http.StatusConflict, body)
+		return
+	}
+
+	staged, cleanup, err := localCopy(r.Context(), backend, key)
+	if err != nil {
+		storageError(w, err, session.ID, "Failed to check upload")
+		return
+	}
+	defer cleanup()
+	if !s.storeSession(w, r, session, staged) {
+		return
+	}
+	s.endDirectUpload(r.Context(), backend, session.ID)
+}
+
+// cancelDirectUpload discards a direct upload and anything sent for it
+func (s *StorageService) cancelDirectUpload(w http.ResponseWriter, r *http.Request) {
+	backend, ok := s.directBackend()
+	if !ok {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Direct uploads need the S3 storage backend")
+		return
+	}
+	session, ok := s.requestedDirectUpload(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(w, session.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	s.endDirectUpload(r.Context(), backend, session.ID)
+	utils.JSONResponse(w, http.StatusOK, map[string]string{
+		"message": "Direct upload cancelled",
+	})
+}
+
+// endDirectUpload removes a direct upload and its staged object
+func (s *StorageService) endDirectUpload(ctx context.Context, backend Storage, id string) {
+	if err := backend.Delete(ctx, directUploadKey(id)); err != nil && !isNotExist(err) {
+		logging.FromContext(ctx).Warn("Failed to remove the staged object of a direct upload", "upload_id", id, "error", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM direct_uploads WHERE id = $1", id); err != nil {
+		logging.FromContext(ctx).Error("Failed to remove direct upload", "upload_id", id, "error", err)
+	}
+	uploadLocks.Delete(id)
+}
+
+// sweepDirectUploads removes direct uploads that weren't completed in time
+func (s *StorageService) sweepDirectUploads(ctx context.Context) {
+	backend, ok := s.directBackend()
+	if !ok {
+		return
+	}
+	var expired []string
+	err := s.db.SelectContext(ctx, &expired,
+		"SELECT id FROM direct_uploads WHERE expires_at < NOW() ORDER BY expires_at LIMIT $1",
+		janitorBatchSize)
+	if err != nil {
+		slog.Error("Janitor failed to list expired direct uploads", "error", err)
+		return
+	}
+	group := s.janitor.Group()
+	for _, id := range expired {
+		id := id
+		if group.Submit(ctx, func(context.Context) { s.endDirectUpload(ctx, backend, id) }) != nil {
+			break
+		}
+	}
+	group.Wait()
+	if len(expired) > 0 {
+		slog.Info("Janitor removed expired direct uploads", "count", len(expired))
+	}
+}
@@ -0,0 +1,575 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Stored files are encrypted when a master key is configured. Each file
+// gets its own random data key, wrapped by the master key and kept in a
+// header in front of the content, so the backend never holds plaintext or
+// usable keys. The content is sealed with AES-256-GCM in fixed-size chunks,
+// which lets a range of a file be decrypted without reading all of it.
+// Files stored before encryption was enabled are read as they are until
+// they are encrypted in the background.
+const (
+	// encryptionMagic starts the header of encrypted files, ending with the
+	// format version
+	encryptionMagic = "VCE\x01"
+	// encryptionHeaderSize is the header's fixed size: the magic, the key ID
+	// and the wrapped data key, padded with zeros
+	encryptionHeaderSize = 512
+	// encryptionChunkSize is the plaintext size of each sealed chunk but the
+	// last
+	encryptionChunkSize = 64 << 10
+	// encryptionTagSize is what sealing adds to each chunk
+	encryptionTagSize = 16
+	dataKeySize       = 32
+)
+
+// encryptionMigrationBatch bounds how many files are encrypted per round
+const encryptionMigrationBatch = 100
+
+// errNotEncrypted is a file's content without an encryption header
+var errNotEncrypted = errors.New("file is not encrypted")
+
+// KeyWrapper protects data keys with a master key
+type KeyWrapper interface {
+	// Wrap encrypts a data key, returning the ID of the master key used
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// EncryptionSettings choose the master keys files are encrypted with: a
+// local key set or an AWS KMS key
+type EncryptionSettings struct {
+	Keys        string `env:"ENCRYPTION_KEYS" secret:"true"`
+	KMSKeyID    string `env:"ENCRYPTION_KMS_KEY_ID"`
+	KMSRegion   string `env:"KMS_REGION"`
+	KMSEndpoint string `env:"KMS_ENDPOINT"`
+}
+
+// newKeyWrapper returns the master key set with ENCRYPTION_KEYS, or the AWS
+// KMS key named by ENCRYPTION_KMS_KEY_ID. Files aren't encrypted without
+// either.
+func newKeyWrapper(settings EncryptionSettings, aws AWSSettings) (KeyWrapper, error) {
+	switch {
+	case settings.Keys != "" && settings.KMSKeyID != "":
+		return nil, errors.New("set either ENCRYPTION_KEYS or ENCRYPTION_KMS_KEY_ID")
+	case settings.Keys != "":
+		return NewLocalKeyWrapper(settings.Keys)
+	case settings.KMSKeyID != "":
+		return newKMSKeyWrapper(settings.KMSKeyID, settings, aws)
+	default:
+		return nil, nil
+	}
+}
+
+// LocalKeyWrapper wraps data keys with AES-256-GCM master keys held by the
+// service. The first key wraps new data keys; every key unwraps, so a new
+// master key can be rolled out while files wrapped by older ones are read.
+type LocalKeyWrapper struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeyWrapper parses "kid:key,kid:key", newest key first, each key
+// 32 base64-encoded bytes
+func NewLocalKeyWrapper(spec string) (*LocalKeyWrapper, error) {
+	w := &LocalKeyWrapper{keys: map[string]cipher.AEAD{}}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, encoded, ok := strings.Cut(pair, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || kid == "" || len(kid) > 255 || err != nil || len(key) != dataKeySize {
+			return nil, fmt.Errorf("invalid encryption key %q (want kid:key, key 32 base64-encoded bytes)", kid)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if w.current == "" {
+			w.current = kid
+		}
+		w.keys[kid] = aead
+	}
+	if w.current == "" {
+		return nil, errors.New("no encryption keys configured")
+	}
+	return w, nil
+}
+
+func (w *LocalKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	aead := w.keys[w.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return w.current, aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (w *LocalKeyWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := w.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedStorage encrypts files written to another Storage and decrypts
+// them when read. Sizes are those of the plaintext.
+type EncryptedStorage struct {
+	inner Storage
+	keys  KeyWrapper
+}
+
+func NewEncryptedStorage(inner Storage, keys KeyWrapper) *EncryptedStorage {
+	return &EncryptedStorage{inner: inner, keys: keys}
+}
+
+// rawStorage is the storage under any encryption, where files are kept as
+// they are stored
+func rawStorage(storage Storage) Storage {
+	if encrypted, ok := storage.(*EncryptedStorage); ok {
+		return encrypted.inner
+	}
+	return storage
+}
+
+func (e *EncryptedStorage) Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return FileInfo{}, err
+	}
+	keyID, wrapped, err := e.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("wrapping data key: %w", err)
+	}
+	header, err := encryptionHeader(keyID, wrapped)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	sealed := io.MultiReader(bytes.NewReader(header), &sealingReader{aead: aead, src: r, remaining: size})
+	info, err := e.inner.Put(ctx, name, sealed, encryptedSize(size))
+	info.Size = size
+	return info, err
+}
+
+func (e *EncryptedStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	body, err := e.inner.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		body.Close()
+		return nil, err
+	}
+	if !bytes.HasPrefix(header[:n], []byte(encryptionMagic)) {
+		return readCloser{io.MultiReader(bytes.NewReader(header[:n]), body), body}, nil
+	}
+
+	aead, err := e.dataKey(ctx, header)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{&openingReader{aead: aead, src: bufio.NewReaderSize(body, encryptionChunkSize+encryptionTagSize), final: -1}, body}, nil
+}
+
+func (e *EncryptedStorage) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	info, aead, err := e.open(ctx, name)
+	if errors.Is(err, errNotEncrypted) {
+		return e.inner.GetRange(ctx, name, offset, length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offset+length > info.Size {
+		length = info.Size - offset
+	}
+	if length <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	// Read the chunks holding the range, then skip to its start in the
+	// first one
+	first := offset / encryptionChunkSize
+	last := (offset + length - 1) / encryptionChunkSize
+	sealedChunk := int64(encryptionChunkSize + encryptionTagSize)
+	body, err := e.inner.GetRange(ctx, name, encryptionHeaderSize+first*sealedChunk,
+		min(last+1, chunkCount(info.Size))*sealedChunk-first*sealedChunk)
+	if err != nil {
+		return nil, err
+	}
+	opened := &openingReader{aead: aead, src: bufio.NewReaderSize(body, int(sealedChunk)), index: first, final: chunkCount(info.Size) - 1}
+	if _, err := io.CopyN(io.Discard, opened, offset-first*encryptionChunkSize); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{io.LimitReader(opened, length), body}, nil
+}
+
+func (e *EncryptedStorage) Delete(ctx context.Context, name string) error {
+	return e.inner.Delete(ctx, name)
+}
+
+func (e *EncryptedStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files, err := e.inner.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		info, err := e.Stat(ctx, prefix+file.Name)
+		if err != nil {
+			return nil, err
+		}
+		files[i].Size = info.Size
+	}
+	return files, nil
+}
+
+func (e *EncryptedStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	info, err := e.inner.Stat(ctx, name)
+	if err != nil {
+		return info, err
+	}
+	encrypted, err := e.isEncrypted(ctx, name, info.Size)
+	if err != nil {
+		return info, err
+	}
+	if encrypted {
+		info.Size = plaintextSize(info.Size)
+	}
+	return info, nil
+}
+
+// Encrypted reports whether a file is stored encrypted
+func (e *EncryptedStorage) Encrypted(ctx context.Context, name string) (bool, error) {
+	info, err := e.inner.Stat(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return e.isEncrypted(ctx, name, info.Size)
+}
+
+// isEncrypted reads the start of a file of the stored size to check for an
+// encryption header
+func (e *EncryptedStorage) isEncrypted(ctx context.Context, name string, size int64) (bool, error) {
+	if size < encryptedSize(0) {
+		return false, nil
+	}
+	body, err := e.inner.GetRange(ctx, name, 0, int64(len(encryptionMagic)))
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(body, magic); err != nil {
+		return false, err
+	}
+	return string(magic) == encryptionMagic, nil
+}
+
+// open returns an encrypted file's plaintext size and data key, or
+// errNotEncrypted
+func (e *EncryptedStorage) open(ctx context.Context, name string) (FileInfo, cipher.AEAD, error) {
+	info, err := e.inner.Stat(ctx, name)
+	if err != nil {
+		return info, nil, err
+	}
+	if info.Size < encryptedSize(0) {
+		return info, nil, errNotEncrypted
+	}
+	body, err := e.inner.GetRange(ctx, name, 0, encryptionHeaderSize)
+	if err != nil {
+		return info, nil, err
+	}
+	defer body.Close()
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return info, nil, err
+	}
+	if !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		return info, nil, errNotEncrypted
+	}
+	aead, err := e.dataKey(ctx, header)
+	info.Size = plaintextSize(info.Size)
+	return info, aead, err
+}
+
+// dataKey unwraps the data key in a file's header
+func (e *EncryptedStorage) dataKey(ctx context.Context, header []byte) (cipher.AEAD, error) {
+	fields := header[len(encryptionMagic):]
+	keyIDLen := int(fields[0])
+	fields = fields[1:]
+	if len(fields) < keyIDLen+2 {
+		return nil, errors.New("invalid encryption header")
+	}
+	keyID := string(fields[:keyIDLen])
+	fields = fields[keyIDLen:]
+	wrappedLen := int(binary.BigEndian.Uint16(fields))
+	fields = fields[2:]
+	if len(fields) < wrappedLen {
+		return nil, errors.New("invalid encryption header")
+	}
+
+	dataKey, err := e.keys.Unwrap(ctx, keyID, fields[:wrappedLen])
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	return newAEAD(dataKey)
+}
+
+// encryptionHeader lays out the header of a file encrypted with a data key
+// wrapped by the master key keyID
+func encryptionHeader(keyID string, wrapped []byte) ([]byte, error) {
+	header := make([]byte, 0, encryptionHeaderSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	if len(keyID) > 255 || len(header) > encryptionHeaderSize {
+		return nil, errors.New("wrapped data key doesn't fit the encryption header")
+	}
+	return header[:encryptionHeaderSize], nil
+}
+
+// chunkCount is how many chunks a file of size plaintext bytes is sealed
+// in; an empty file has one empty chunk
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encryptionChunkSize - 1) / encryptionChunkSize
+}
+
+// encryptedSize is the stored size of a file of size plaintext bytes
+func encryptedSize(size int64) int64 {
+	return encryptionHeaderSize + size + chunkCount(size)*encryptionTagSize
+}
+
+// plaintextSize is the size of the plaintext of a file stored in size bytes
+func plaintextSize(size int64) int64 {
+	sealed := size - encryptionHeaderSize
+	chunks := (sealed + encryptionChunkSize + encryptionTagSize - 1) / (encryptionChunkSize + encryptionTagSize)
+	return sealed - chunks*encryptionTagSize
+}
+
+// chunkNonce is the nonce of a chunk. Data keys are only used for one file,
+// so the chunk's index is unique.
+func chunkNonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+// chunkAAD marks the last chunk, so a file cut at a chunk boundary doesn't
+// decrypt
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// sealingReader reads remaining bytes from src as sealed chunks
+type sealingReader struct {
+	aead      cipher.AEAD
+	src       io.Reader
+	remaining int64
+	index     int64
+	done      bool
+	buf       []byte
+}
+
+func (s *sealingReader) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, min(s.remaining, encryptionChunkSize))
+		if _, err := io.ReadFull(s.src, chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		s.remaining -= int64(len(chunk))
+		s.done = s.remaining == 0
+		s.buf = s.aead.Seal(chunk[:0], chunkNonce(s.index), chunk, chunkAAD(s.done))
+		s.index++
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// openingReader decrypts sealed chunks read from src, starting with chunk
+// index. final is the index of the last chunk, or -1 when it's found by
+// reaching the end of src.
+type openingReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	index int64
+	final int64
+	done  bool
+	buf   []byte
+}
+
+func (o *openingReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, encryptionChunkSize+encryptionTagSize)
+		n, err := io.ReadFull(o.src, sealed)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		final := o.index == o.final
+		if o.final < 0 {
+			_, peekErr := o.src.Peek(1)
+			final = errors.Is(peekErr, io.EOF)
+		}
+		plain, err := o.aead.Open(sealed[:0], chunkNonce(o.index), sealed[:n], chunkAAD(final))
+		if err != nil {
+			return 0, fmt.Errorf("decrypting chunk %d: %w", o.index, err)
+		}
+		o.buf = plain
+		o.done = final
+		o.index++
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+// migrateEncryption encrypts the files stored before encryption was
+// enabled, along with their normalized versions and earlier versions. Until a file is encrypted
+// it's still served as it is.
+func (s *StorageService) migrateEncryption(ctx context.Context) {
+	encrypted, after := 0, ""
+	for {
+		var files []struct {
+			Key           string         `db:"object_key"`
+			NormalizedKey sql.NullString `db:"normalized_key"`
+		}
+		err := s.db.SelectContext(ctx, &files,
+			`SELECT object_key, MAX(normalized_key) AS normalized_key FROM (
+				SELECT object_key, normalized_key FROM stored_files WHERE NOT encrypted
+				UNION ALL
+				SELECT object_key, normalized_key FROM file_versions WHERE NOT encrypted) unencrypted
+			WHERE object_key > $1 GROUP BY object_key ORDER BY object_key LIMIT $2`,
+			after, encryptionMigrationBatch)
+		if err != nil {
+			slog.Error("Failed to list files to encrypt", "error", err)
+			return
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, file := range files {
+			after = file.Key
+			tier, err := s.locateFile(ctx, file.Key)
+			if err != nil && !isNotExist(err) {
+				slog.Error("Failed to locate a file to encrypt", "key", file.Key, "error", err)
+				return
+			}
+			storage, ok := s.tierStorage(tier).(*EncryptedStorage)
+			if !ok {
+				return
+			}
+			keys := []string{file.Key}
+			if file.NormalizedKey.Valid {
+				keys = append(keys, file.NormalizedKey.String)
+			}
+			for _, key := range keys {
+				if err := storage.encryptInPlace(ctx, key); err != nil && !isNotExist(err) {
+					// Leave the rest for the next start rather than
+					// retrying the same files forever
+					slog.Error("Failed to encrypt file", "key", key, "error", err)
+					return
+				}
+				s.requeueReplica(ctx, key)
+			}
+			if _, err := s.db.ExecContext(ctx,
+				"UPDATE stored_files SET encrypted = TRUE WHERE object_key = $1", file.Key); err != nil {
+				slog.Error("Failed to record file as encrypted", "key", file.Key, "error", err)
+				return
+			}
+			if _, err := s.db.ExecContext(ctx,
+				"UPDATE file_versions SET encrypted = TRUE WHERE object_key = $1", file.Key); err != nil {
+				slog.Error("Failed to record file as encrypted", "key", file.Key, "error", err)
+				return
+			}
+			encrypted++
+		}
+	}
+
+	if encrypted > 0 {
+		slog.Info("Encrypted stored files", "count", encrypted)
+	}
+}
+
+// encryptInPlace replaces a file stored unencrypted with its encryption
+func (e *EncryptedStorage) encryptInPlace(ctx context.Context, name string) error {
+	info, err := e.inner.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	encrypted, err := e.isEncrypted(ctx, name, info.Size)
+	if err != nil || encrypted {
+		return err
+	}
+	plain, err := e.inner.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	_, err = e.Put(ctx, name, plain, info.Size)
+	return err
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Files are referenced by IDs the service generates, and kept in the
+// backend under their ID. The client's filename is only metadata, so it
+// never decides where a file is written. Files stored before IDs keep their
+// filename as their ID, so existing references to them still work.
+
+// maxFilenameLength is the longest filename recorded, in bytes
+const maxFilenameLength = 255
+
+// recordedName is how a client's filename is kept: without directories and
+// cut to maxFilenameLength
+func recordedName(filename string) string {
+	filename = filepath.Base(filename)
+	if len(filename) > maxFilenameLength {
+		filename = strings.ToValidUTF8(filename[:maxFilenameLength], "")
+	}
+	return filename
+}
+
+// newFileID generates a random (version 4) UUID
+func newFileID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// validFileID rejects IDs that could name a path outside the caller's
+// files. Generated IDs are UUIDs; older ones are plain filenames.
+func validFileID(id string) bool {
+	return id != "" && id == filepath.Base(id) && !strings.HasPrefix(id, ".") && !strings.Contains(id, `\`)
+}
+
+// requestedFile resolves the file of the request's {id} and checks the
+// caller may use it. Otherwise the request has been answered and false is
+// returned.
+func (s *StorageService) requestedFile(w http.ResponseWriter, r *http.Request) (storedFile, bool) {
+	return s.resolveRequested(w, r, false)
+}
+
+// readableFile is requestedFile for reading a file, which its ACL may also
+// allow other users
+func (s *StorageService) readableFile(w http.ResponseWriter, r *http.Request) (storedFile, bool) {
+	return s.resolveRequested(w, r, true)
+}
+
+func (s *StorageService) resolveRequested(w http.ResponseWriter, r *http.Request, read bool) (storedFile, bool) {
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return storedFile{}, false
+	}
+	file, err := s.resolveFile(r.Context(), id)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return storedFile{}, false
+	}
+	// Other users' files are logged as denied, though the caller is told
+	// they don't exist
+	allowed := canAccess(r, file)
+	if !allowed && read {
+		if allowed, err = s.canRead(r.Context(), r, file); err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+			return storedFile{}, false
+		}
+	}
+	noteFileAccess(r, file, allowed || !file.UserID.Valid)
+	if !allowed {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return storedFile{}, false
+	}
+	return file, true
+}
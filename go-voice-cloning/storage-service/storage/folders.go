@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Users organize their files in folders, paths such as projects/acme/takes.
+// Folders are only a listing aid: a file's folder is recorded with it, and
+// where the backend keeps it doesn't change. A folder exists once created,
+// or once a file is put in it, along with its parents.
+
+// maxFolderPath bounds the length of a folder's path
+const maxFolderPath = 500
+
+var errInvalidFolder = errors.New("folder must be a path of names separated by /, without . or .. and at most 500 characters")
+
+// likeEscaper escapes LIKE wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// cleanFolder normalizes a folder path, without leading or trailing
+// slashes. The root folder is "".
+func cleanFolder(path string) (string, error) {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return "", nil
+	}
+	if len(path) > maxFolderPath || strings.ContainsAny(path, "\\\x00") {
+		return "", errInvalidFolder
+	}
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." || name == ".." {
+			return "", errInvalidFolder
+		}
+	}
+	return path, nil
+}
+
+// folderTree matches a folder and the folders under it, in the column
+func folderTree(column, path string, arg func(interface{}) string) string {
+	return "(" + column + " = " + arg(path) + " OR " + column + " LIKE " + arg(likeEscaper.Replace(path)+"/%") + ")"
+}
+
+// ensureFolder records a user's folder and its parents
+func ensureFolder(ctx context.Context, db sqlx.ExecerContext, userID int, path string) error {
+	for path != "" {
+		if _, err := db.ExecContext(ctx,
+			"INSERT INTO folders (user_id, path) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, path); err != nil {
+			return err
+		}
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return nil
+}
+
+// Folder is a folder as listed to its owner, with the files directly in it
+type Folder struct {
+	Path      string    `json:"path" db:"path"`
+	Files     int       `json:"files" db:"files"`
+	Bytes     int64     `json:"bytes" db:"bytes"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// createFolder creates a folder, and its parents, for the caller
+func (s *StorageService) createFolder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var req struct {
+		Path string `json:"path"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	path, err := cleanFolder(req.Path)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	if path == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "path is required")
+		return
+	}
+
+	if err := ensureFolder(r.Context(), s.db, userID, path); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create folder")
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, map[string]string{
+		"path":    path,
+		"message": "Folder created successfully",
+	})
+}
+
+// listFolders lists the caller's folders directly under ?parent=, the root
+// by default, with the number and size of the files directly in each
+func (s *StorageService) listFolders(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	parent, err := cleanFolder(r.URL.Query().Get("parent"))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"f.user_id = $1"}
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if parent == "" {
+		where = append(where, "f.path NOT LIKE '%/%'")
+	} else {
+		prefix := likeEscaper.Replace(parent) + "/"
+		where = append(where, "f.path LIKE "+arg(prefix+"%"), "f.path NOT LIKE "+arg(prefix+"%/%"))
+	}
+
+	folders := []Folder{}
+	err = dbroute.Reader(r, s.db, s.replica).SelectContext(r.Context(), &folders,
+		`SELECT f.path, f.created_at, COUNT(sf.id) AS files, COALESCE(SUM(sf.size_bytes), 0) AS bytes
+		FROM folders f LEFT JOIN stored_files sf ON sf.user_id = f.user_id AND sf.folder = f.path
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY f.path, f.created_at ORDER BY f.path`, args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list folders")
+		return
+	}
+	utils.SuccessResponse(w, map[string]interface{}{"parent": parent, "folders": folders})
+}
+
+// deleteFolder deletes the caller's folder ?path=. A folder that holds
+// files or other folders is only deleted with ?recursive=true, which
+// deletes everything under it.
+func (s *StorageService) deleteFolder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	path, err := cleanFolder(r.URL.Query().Get("path"))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	if path == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "path is required")
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	var exists bool
+	if err := s.db.GetContext(r.Context(), &exists,
+		"SELECT EXISTS (SELECT 1 FROM folders WHERE user_id = $1 AND path = $2)", userID, path); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete folder")
+		return
+	}
+	if !exists {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FolderNotFound, "Folder not found")
+		return
+	}
+
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var files []storedFile
+	if err := s.db.SelectContext(r.Context(), &files,
+		"SELECT "+storedFileColumns+" FROM stored_files WHERE user_id = $1 AND "+folderTree("folder", path, arg), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete folder")
+		return
+	}
+	var folders int
+	if err := s.db.GetContext(r.Context(), &folders,
+		"SELECT COUNT(*) FROM folders WHERE user_id = $1 AND path LIKE $2", userID, likeEscaper.Replace(path)+"/%"); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete folder")
+		return
+	}
+	if !recursive && (len(files) > 0 || folders > 0) {
+		body := utils.ErrorBody(w, apierror.FolderNotEmpty, "Folder is not empty")
+		body["files"] = len(files)
+		body["folders"] = folders
+		utils.JSONResponse(w, http.StatusConflict, body)
+		return
+	}
+
+	// The folder is kept if one of its files can't be deleted, so what's
+	// left can still be found
+	for _, file := range files {
+		tier, err := s.locateFile(r.Context(), file.Key)
+		if err == nil || isNotExist(err) {
+			err = s.removeFile(r.Context(), s.tierStorage(tier), file)
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to delete file from folder", "file_id", file.ID, "folder", path, "error", err)
+			storageError(w, err, file.ID, "Failed to delete folder")
+			return
+		}
+	}
+	args = []interface{}{userID}
+	if _, err := s.db.ExecContext(r.Context(),
+		"DELETE FROM folders WHERE user_id = $1 AND "+folderTree("path", path, arg), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete folder")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"path":          path,
+		"files_deleted": len(files),
+		"message":       "Folder deleted successfully",
+	})
+}
+
+// moveToFolder moves a file to its owner's folder in the body, "" or "/"
+// for the root
+func (s *StorageService) moveToFolder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Folder *string `json:"folder"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if req.Folder == nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "folder is required")
+		return
+	}
+	folder, err := cleanFolder(*req.Folder)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	if !canModify(w, r, file) {
+		return
+	}
+	// Folders are their owner's; internal files have none
+	userID := file.owner()
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "Internal files can't be put in folders")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to move file")
+		return
+	}
+	defer tx.Rollback()
+	if err := ensureFolder(r.Context(), tx, userID, folder); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to move file")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), "UPDATE stored_files SET folder = $1 WHERE id = $2", folder, file.ID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to move file")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to move file")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]string{
+		"id":     file.ID,
+		"folder": folder,
+	})
+}
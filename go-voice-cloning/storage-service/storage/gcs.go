@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// gcsScope is the OAuth scope the storage service needs on its bucket
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsMetadataTokenURL hands out tokens for the service account of the
+// Compute Engine or GKE node the service runs on
+const gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCSConfig locates a bucket and the credentials to access it
+type GCSConfig struct {
+	// Endpoint is the API's URL, https://storage.googleapis.com unless an
+	// emulator stands in for it
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to the names of stored files
+	Prefix string
+	// CredentialsFile is a service account key. Without one, tokens come
+	// from the metadata server, or, with a custom endpoint, requests go
+	// unauthenticated.
+	CredentialsFile string
+}
+
+// GCSSettings locate the GCS bucket. The service account key file is
+// GCS_CREDENTIALS_FILE, or else GOOGLE_APPLICATION_CREDENTIALS.
+type GCSSettings struct {
+	Endpoint          string `env:"GCS_ENDPOINT"`
+	Bucket            string `env:"GCS_BUCKET"`
+	Prefix            string `env:"GCS_PREFIX"`
+	ColdPrefix        string `env:"GCS_COLD_PREFIX"`
+	CredentialsFile   string `env:"GCS_CREDENTIALS_FILE"`
+	GoogleCredentials string `env:"GOOGLE_APPLICATION_CREDENTIALS"`
+}
+
+// gcsConfig checks the bucket's settings
+func gcsConfig(settings GCSSettings) (GCSConfig, error) {
+	cfg := GCSConfig{
+		Endpoint:        settings.Endpoint,
+		Bucket:          settings.Bucket,
+		Prefix:          settings.Prefix,
+		CredentialsFile: settings.CredentialsFile,
+	}
+	if cfg.CredentialsFile == "" {
+		cfg.CredentialsFile = settings.GoogleCredentials
+	}
+	if cfg.Bucket == "" {
+		return cfg, fmt.Errorf("GCS_BUCKET is required")
+	}
+	return cfg, nil
+}
+
+// GCSStorage keeps files as objects in a Google Cloud Storage bucket,
+// through the JSON API
+type GCSStorage struct {
+	cfg      GCSConfig
+	endpoint string
+	client   *http.Client
+	tokens   *gcsTokenSource
+}
+
+// NewGCSStorage loads the credentials; the bucket must already exist
+func NewGCSStorage(cfg GCSConfig) (*GCSStorage, error) {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	custom := endpoint != ""
+	if !custom {
+		endpoint = "https://storage.googleapis.com"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid GCS endpoint %q", cfg.Endpoint)
+	}
+
+	client := &http.Client{}
+	g := &GCSStorage{cfg: cfg, endpoint: endpoint, client: client}
+	switch {
+	case cfg.CredentialsFile != "":
+		tokens, err := gcsServiceAccount(client, cfg.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		g.tokens = tokens
+	case !custom:
+		g.tokens = &gcsTokenSource{client: client, metadata: true}
+	}
+	return g, nil
+}
+
+func (g *GCSStorage) object(name string) string {
+	return g.cfg.Prefix + name
+}
+
+func (g *GCSStorage) location(name string) string {
+	return "gs://" + g.cfg.Bucket + "/" + g.object(name)
+}
+
+// gcsObject is an object's metadata in the JSON API
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    string    `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+func (g *GCSStorage) fileInfo(obj gcsObject) FileInfo {
+	name := strings.TrimPrefix(obj.Name, g.cfg.Prefix)
+	size, _ := strconv.ParseInt(obj.Size, 10, 64)
+	return FileInfo{Name: name, Size: size, ModTime: obj.Updated, Location: g.location(name)}
+}
+
+// Put streams the body in a single media upload
+func (g *GCSStorage) Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error) {
+	u := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(g.cfg.Bucket) + "/o?" +
+		url.Values{"uploadType": {"media"}, "name": {g.object(name)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, io.NopCloser(r))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := g.do(req, false)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer res.Body.Close()
+
+	var obj gcsObject
+	if err := json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return FileInfo{}, fmt.Errorf("gcs: invalid upload response: %w", err)
+	}
+	return g.fileInfo(obj), nil
+}
+
+func (g *GCSStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.do(req, true)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (g *GCSStorage) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = rangeHeader(offset, length)
+	res, err := g.do(req, true)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (g *GCSStorage) Delete(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	res, err := g.do(req, true)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// List returns the files directly under the prefix, paging through the
+// bucket listing
+func (g *GCSStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	query := url.Values{"prefix": {g.object(prefix)}, "delimiter": {"/"}, "fields": {"items(name,size,updated),nextPageToken"}}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			g.endpoint+"/storage/v1/b/"+url.PathEscape(g.cfg.Bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := g.do(req, false)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("gcs: invalid listing: %w", err)
+		}
+
+		for _, obj := range page.Items {
+			info := g.fileInfo(obj)
+			info.Name = strings.TrimPrefix(info.Name, prefix)
+			files = append(files, info)
+		}
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (g *GCSStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(name), nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res, err := g.do(req, true)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer res.Body.Close()
+
+	var obj gcsObject
+	if err := json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return FileInfo{}, fmt.Errorf("gcs: invalid object metadata: %w", err)
+	}
+	return g.fileInfo(obj), nil
+}
+
+func (g *GCSStorage) objectURL(name string) string {
+	return g.endpoint + "/storage/v1/b/" + url.PathEscape(g.cfg.Bucket) + "/o/" + url.PathEscape(g.object(name))
+}
+
+// do sends an authorized request and turns error responses into
+// BackendErrors. A 404 means a missing file when forObject is set, unless
+// it is the bucket that is missing.
+func (g *GCSStorage) do(req *http.Request, forObject bool) (*http.Response, error) {
+	if g.tokens != nil {
+		token, err := g.tokens.token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&body)
+	backendErr := &BackendError{Backend: BackendGCS, StatusCode: res.StatusCode, Message: body.Error.Message}
+	if len(body.Error.Errors) > 0 {
+		backendErr.Code = body.Error.Errors[0].Reason
+	}
+	backendErr.NotFound = forObject && res.StatusCode == http.StatusNotFound &&
+		!strings.HasPrefix(body.Error.Message, "The specified bucket")
+	return nil, backendErr
+}
+
+// gcsTokenSource hands out OAuth access tokens, fetching a new one shortly
+// before the last expires
+type gcsTokenSource struct {
+	client *http.Client
+	// metadata fetches tokens from the metadata server instead of signing
+	// assertions with a service account key
+	metadata bool
+	email    string
+	tokenURI string
+	key      interface{}
+
+	mu      sync.Mutex
+	current string
+	expiry  time.Time
+}
+
+// gcsServiceAccount loads a service account key file
+func gcsServiceAccount(client *http.Client, path string) (*gcsTokenSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCS credentials: %w", err)
+	}
+	var account struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials: %w", err)
+	}
+	if account.Type != "service_account" || account.ClientEmail == "" {
+		return nil, fmt.Errorf("GCS credentials must be a service account key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials private key: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &gcsTokenSource{client: client, email: account.ClientEmail, tokenURI: account.TokenURI, key: key}, nil
+}
+
+func (t *gcsTokenSource) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != "" && time.Until(t.expiry) > time.Minute {
+		return t.current, nil
+	}
+
+	var req *http.Request
+	var err error
+	if t.metadata {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		now := time.Now()
+		assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   t.email,
+			"scope": gcsScope,
+			"aud":   t.tokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}).SignedString(t.key)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, t.tokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcs: failed to fetch access token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", &BackendError{Backend: BackendGCS, StatusCode: res.StatusCode, Code: "token", Message: "failed to fetch access token"}
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.AccessToken == "" {
+		return "", fmt.Errorf("gcs: invalid access token response")
+	}
+	t.current = body.AccessToken
+	t.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return t.current, nil
+}
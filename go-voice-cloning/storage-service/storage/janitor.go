@@ -0,0 +1,18 @@
+package storage
+
+import "context"
+
+// janitorBatchSize bounds how many expired files, and how many files checked
+// for orphans, are removed per sweep
+const janitorBatchSize = 500
+
+// fileJanitor deletes files whose retention period has passed, orphaned
+// files and upload sessions left idle, JanitorConcurrency at a time on the
+// janitor pool. It's the file-janitor job; each sweep logs its own failures
+// and leaves what it couldn't do to the next run.
+func (s *StorageService) fileJanitor(ctx context.Context) error {
+	s.sweepLifecycle(ctx)
+	s.sweepUploadSessions(ctx)
+	s.sweepDirectUploads(ctx)
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// KMSKeyWrapper wraps data keys with an AWS KMS key, which never leaves
+// KMS. Rotating the key in KMS keeps older data keys readable.
+type KMSKeyWrapper struct {
+	KeyID    string
+	Region   string
+	Endpoint string
+	Creds    awsCredentials
+	client   *http.Client
+}
+
+// newKMSKeyWrapper uses the KMS key keyID in the configured region
+// (default AWS_REGION, then us-east-1) and endpoint, with the standard AWS_*
+// credentials
+func newKMSKeyWrapper(keyID string, settings EncryptionSettings, aws AWSSettings) (*KMSKeyWrapper, error) {
+	w := &KMSKeyWrapper{
+		KeyID:    keyID,
+		Region:   settings.KMSRegion,
+		Endpoint: settings.KMSEndpoint,
+		Creds:    aws.credentials(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if w.Region == "" {
+		w.Region = aws.Region
+	}
+	if w.Creds.AccessKey == "" || w.Creds.SecretKey == "" {
+		return nil, fmt.Errorf("AWS credentials are required for KMS")
+	}
+	if w.Region == "" {
+		w.Region = "us-east-1"
+	}
+	if w.Endpoint == "" {
+		w.Endpoint = "https://kms." + w.Region + ".amazonaws.com"
+	}
+	return w, nil
+}
+
+func (w *KMSKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var res struct {
+		CiphertextBlob []byte
+	}
+	if err := w.call(ctx, "Encrypt", map[string]interface{}{"KeyId": w.KeyID, "Plaintext": dataKey}, &res); err != nil {
+		return "", nil, err
+	}
+	// The ciphertext names the key that encrypted it
+	return "kms", res.CiphertextBlob, nil
+}
+
+func (w *KMSKeyWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != "kms" {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	var res struct {
+		Plaintext []byte
+	}
+	if err := w.call(ctx, "Decrypt", map[string]interface{}{"KeyId": w.KeyID, "CiphertextBlob": wrapped}, &res); err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// call makes a KMS API request. Binary fields are base64-encoded in JSON,
+// as KMS expects.
+func (w *KMSKeyWrapper) call(ctx context.Context, action string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	hash := sha256.Sum256(payload)
+	signV4(req, w.Creds.current(), w.Region, "kms", hex.EncodeToString(hash[:]), time.Now().UTC())
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var kmsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&kmsErr)
+		return fmt.Errorf("kms %s: %d %s: %s", action, res.StatusCode, kmsErr.Type, kmsErr.Message)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
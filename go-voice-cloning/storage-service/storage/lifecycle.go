@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Files uploaded as temporary expire after their TTL, whatever their class's
+// retention. Files no clone references are orphans: once they are older than
+// the grace period the janitor asks the voice service whether any clone uses
+// them, and removes those none does. Files found referenced aren't asked
+// about again for another grace period.
+
+// Reasons a file is removed
+const (
+	RemovalExpired   = "expired"
+	RemovalTemporary = "temporary"
+	RemovalOrphaned  = "orphaned"
+)
+
+// lifecycleConfig is how long temporary files are kept, when unreferenced
+// files count as orphans and whether the janitor only reports what it
+// would remove
+type lifecycleConfig struct {
+	temporaryTTL    time.Duration
+	maxTemporaryTTL time.Duration
+	orphanGrace     time.Duration // 0 disables orphan removal
+	dryRun          bool
+	voiceURL        string
+	client          *http.Client
+}
+
+// LifecycleSettings bound temporary files and orphan removal. Orphans,
+// files no clone refers to, are kept with an ORPHAN_GRACE_DAYS of 0.
+type LifecycleSettings struct {
+	TemporaryTTL    time.Duration `env:"TEMPORARY_FILE_TTL_HOURS" default:"24" unit:"h" min:"1"`
+	MaxTemporaryTTL time.Duration `env:"TEMPORARY_FILE_MAX_TTL_HOURS" default:"168" unit:"h" min:"1"`
+	OrphanGrace     time.Duration `env:"ORPHAN_GRACE_DAYS" default:"0" unit:"d" min:"0"`
+	DryRun          bool          `env:"LIFECYCLE_DRY_RUN"`
+	VoiceURL        string        `env:"VOICE_SERVICE_URL" default:"http://localhost:8082"`
+}
+
+// newLifecycleConfig checks the lifecycle settings
+func newLifecycleConfig(settings LifecycleSettings) (lifecycleConfig, error) {
+	cfg := lifecycleConfig{
+		temporaryTTL:    settings.TemporaryTTL,
+		maxTemporaryTTL: settings.MaxTemporaryTTL,
+		orphanGrace:     settings.OrphanGrace,
+		dryRun:          settings.DryRun,
+		voiceURL:        settings.VoiceURL,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+	if cfg.maxTemporaryTTL < cfg.temporaryTTL {
+		return cfg, fmt.Errorf("TEMPORARY_FILE_TTL_HOURS must be at most TEMPORARY_FILE_MAX_TTL_HOURS")
+	}
+	return cfg, nil
+}
+
+// ttl is how long an upload marked temporary, or given a TTL in seconds, is
+// kept. 0 means the upload isn't temporary.
+func (cfg lifecycleConfig) ttl(temporary bool, seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		if temporary {
+			return cfg.temporaryTTL, nil
+		}
+		return 0, nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if seconds < 0 || ttl > cfg.maxTemporaryTTL {
+		return 0, fmt.Errorf("ttl must be between 1 and %d seconds", int64(cfg.maxTemporaryTTL/time.Second))
+	}
+	return ttl, nil
+}
+
+// formTTL parses the temporary and ttl fields of an upload form. Its errors
+// are meant for the client.
+func (cfg lifecycleConfig) formTTL(form uploadForm) (time.Duration, error) {
+	var temporary bool
+	var seconds int64
+	var err error
+	if form.temporary != "" {
+		if temporary, err = strconv.ParseBool(form.temporary); err != nil {
+			return 0, errors.New("temporary must be true or false")
+		}
+	}
+	if form.ttl != "" {
+		if seconds, err = strconv.ParseInt(form.ttl, 10, 64); err != nil || seconds == 0 {
+			return 0, errors.New("ttl must be a number of seconds")
+		}
+	}
+	return cfg.ttl(temporary, seconds)
+}
+
+// lifecycleFile is a file the janitor would remove, and why
+type lifecycleFile struct {
+	storedFile
+	Class     string     `db:"class"`
+	Size      int64      `db:"size_bytes"`
+	Temporary bool       `db:"temporary"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	Reason    string     `db:"-"`
+}
+
+const lifecycleFileColumns = storedFileColumns + `, class, size_bytes, temporary, created_at, expires_at`
+
+// LifecycleEntry describes a file in a lifecycle report
+type LifecycleEntry struct {
+	ID        string     `json:"id"`
+	Filename  string     `json:"filename"`
+	UserID    int        `json:"user_id,omitempty"`
+	Class     string     `json:"class"`
+	Size      int64      `json:"size_bytes"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LifecycleReport lists the files the janitor removes next. Orphans are
+// only listed when orphan removal is on and the voice service answered.
+type LifecycleReport struct {
+	DryRun         bool             `json:"dry_run"`
+	Files          []LifecycleEntry `json:"files"`
+	TotalBytes     int64            `json:"total_bytes"`
+	OrphansChecked bool             `json:"orphans_checked"`
+	OrphanError    string           `json:"orphan_error,omitempty"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+}
+
+// lifecycleCandidates lists a batch of the files past their expiry and of
+// orphans. With reconcile, files found referenced aren't checked again
+// until the grace period has passed.
+func (s *StorageService) lifecycleCandidates(ctx context.Context, reconcile bool) ([]lifecycleFile, LifecycleReport, error) {
+	report := LifecycleReport{DryRun: s.lifecycle.dryRun, Files: []LifecycleEntry{}, GeneratedAt: time.Now().UTC()}
+
+	var files []lifecycleFile
+	err := s.db.SelectContext(ctx, &files,
+		"SELECT "+lifecycleFileColumns+" FROM stored_files WHERE expires_at < NOW() ORDER BY expires_at LIMIT $1",
+		janitorBatchSize)
+	if err != nil {
+		return nil, report, fmt.Errorf("list expired files: %w", err)
+	}
+	for i := range files {
+		files[i].Reason = RemovalExpired
+		if files[i].Temporary {
+			files[i].Reason = RemovalTemporary
+		}
+	}
+
+	if s.lifecycle.orphanGrace > 0 {
+		orphans, err := s.orphanedFiles(ctx, reconcile)
+		if err != nil {
+			// Nothing counts as an orphan unless the voice service says so
+			slog.Error("Janitor failed to check for orphaned files", "error", err)
+			report.OrphanError = "Failed to check for orphaned files"
+		} else {
+			report.OrphansChecked = true
+			files = append(files, orphans...)
+		}
+	}
+
+	for _, f := range files {
+		report.Files = append(report.Files, LifecycleEntry{
+			ID:        f.ID,
+			Filename:  f.Filename,
+			UserID:    f.owner(),
+			Class:     f.Class,
+			Size:      f.Size,
+			Reason:    f.Reason,
+			CreatedAt: f.CreatedAt,
+			ExpiresAt: f.ExpiresAt,
+		})
+		report.TotalBytes += f.Size
+	}
+	return files, report, nil
+}
+
+// orphanedFiles lists users' files past the grace period, without expiry,
+// that no clone references. Files just uploaded for a clone not yet
+// created are protected by the grace period. Avatars belong to profiles
+// rather than clones, so they are never orphans.
+func (s *StorageService) orphanedFiles(ctx context.Context, reconcile bool) ([]lifecycleFile, error) {
+	var files []lifecycleFile
+	cutoff := time.Now().Add(-s.lifecycle.orphanGrace)
+	err := s.db.SelectContext(ctx, &files,
+		"SELECT "+lifecycleFileColumns+` FROM stored_files
+		WHERE expires_at IS NULL AND user_id IS NOT NULL AND COALESCE(updated_at, created_at) < $1
+			AND (reconciled_at IS NULL OR reconciled_at < $1) AND (file_type IS NULL OR file_type <> $3)
+		ORDER BY reconciled_at NULLS FIRST, created_at LIMIT $2`,
+		cutoff, janitorBatchSize, TypeAvatar)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	referenced, err := s.referencedFiles(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []lifecycleFile
+	var checked []string
+	for _, f := range files {
+		if referenced[f.ID] {
+			checked = append(checked, f.ID)
+			continue
+		}
+		f.Reason = RemovalOrphaned
+		orphans = append(orphans, f)
+	}
+	if reconcile && len(checked) > 0 {
+		if _, err := s.db.ExecContext(ctx,
+			"UPDATE stored_files SET reconciled_at = NOW() WHERE id = ANY($1)", pq.Array(checked)); err != nil {
+			slog.Error("Failed to record reconciled files", "error", err)
+		}
+	}
+	return orphans, nil
+}
+
+// referencedFiles asks the voice service which of the files clones use
+func (s *StorageService) referencedFiles(ctx context.Context, ids []string) (map[string]bool, error) {
+	body, err := json.Marshal(map[string][]string{"files": ids})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.lifecycle.voiceURL+"/files/references", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.lifecycle.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("voice service returned %d", resp.StatusCode)
+	}
+	var result struct {
+		Referenced []string `json:"referenced"`
+	}
+	if err := utils.DecodeData(resp, &result); err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(result.Referenced))
+	for _, id := range result.Referenced {
+		referenced[id] = true
+	}
+	return referenced, nil
+}
+
+// sweepLifecycle removes expired, temporary and orphaned files, or in
+// dry-run mode logs what it would remove
+func (s *StorageService) sweepLifecycle(ctx context.Context) {
+	files, report, err := s.lifecycleCandidates(ctx, true)
+	if err != nil {
+		slog.Error("Janitor failed to list files to remove", "error", err)
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	if s.lifecycle.dryRun {
+		for _, f := range report.Files {
+			slog.Info("Janitor dry run: would remove file", "file_id", f.ID, "reason", f.Reason, "bytes", f.Size)
+		}
+		slog.Info("Janitor dry run: would remove files", "count", len(report.Files), "bytes", report.TotalBytes)
+		return
+	}
+
+	var mu sync.Mutex
+	removed := map[string]int{}
+	group := s.janitor.Group()
+	for _, file := range files {
+		file := file
+		err := group.Submit(ctx, func(context.Context) {
+			tier, err := s.locateFile(ctx, file.Key)
+			if err != nil && !isNotExist(err) {
+				slog.Error("Janitor failed to delete file", "file_id", file.ID, "error", err)
+				return
+			}
+			if err := s.removeFile(ctx, s.tierStorage(tier), file.storedFile); err != nil {
+				slog.Error("Janitor failed to delete file", "file_id", file.ID, "error", err)
+				return
+			}
+			if file.Reason == RemovalOrphaned {
+				slog.Info("Janitor removed orphaned file", "file_id", file.ID, "user_id", file.owner())
+			}
+			mu.Lock()
+			removed[file.Reason]++
+			mu.Unlock()
+		})
+		if err != nil {
+			break
+		}
+	}
+	group.Wait()
+	slog.Info("Janitor removed files", "expired", removed[RemovalExpired],
+		"temporary", removed[RemovalTemporary], "orphaned", removed[RemovalOrphaned])
+}
+
+// getLifecycleReport lists the files the janitor removes next, without
+// removing them (admin)
+func (s *StorageService) getLifecycleReport(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	_, report, err := s.lifecycleCandidates(r.Context(), false)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to build lifecycle report", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to build lifecycle report")
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Every stored file has a row in stored_files, which is what listings,
+// ownership checks and searches read; the backend is only read for file
+// contents. Files stored before content types and checksums were recorded
+// have neither.
+
+const (
+	defaultFilePageSize = 50
+	maxFilePageSize     = 500
+)
+
+// sortableFileColumns maps sort parameter names to columns
+var sortableFileColumns = map[string]string{
+	"created_at": "created_at",
+	"name":       "filename",
+	"size":       "size_bytes",
+}
+
+// fileCursor is the keyset position after the last file of a page
+type fileCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// StoredFileInfo is a file as listed to its owner
+type StoredFileInfo struct {
+	ID          string     `json:"id" db:"id"`
+	Name        string     `json:"name" db:"filename"`
+	Size        int64      `json:"size" db:"size_bytes"`
+	Type        *string    `json:"type" db:"file_type"`
+	ContentType *string    `json:"content_type" db:"content_type"`
+	Checksum    *string    `json:"checksum_sha256" db:"checksum_sha256"`
+	Folder      string     `json:"folder" db:"folder"`
+	ACL         string     `json:"acl" db:"acl"`
+	ScanStatus  *string    `json:"scan_status" db:"scan_status"`
+	Quarantined bool       `json:"quarantined" db:"quarantined"`
+	Version     int        `json:"version" db:"version"`
+	Temporary   bool       `json:"temporary" db:"temporary"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// describeStaged detects the content type of a staged upload, falling back
+// to the declared one, and computes its SHA-256 in hex and its MD5 in base64
+func describeStaged(path, declared string) (contentType, checksum, md5sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer f.Close()
+
+	if contentType, err = detectMIMEType(f, declared); err != nil {
+		return "", "", "", err
+	}
+	hash, md5hash := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(hash, md5hash), f); err != nil {
+		return "", "", "", err
+	}
+	return contentType, hex.EncodeToString(hash.Sum(nil)), base64.StdEncoding.EncodeToString(md5hash.Sum(nil)), nil
+}
+
+// listFiles returns a page of the caller's files in the standard tier,
+// newest first. Supports ?name= (substring), ?prefix= (start of the name),
+// ?folder= (files directly in it, / for the root, or under it too with
+// ?recursive=true), ?type= (file type), ?content_type=, ?created_after= and ?created_before=
+// (RFC 3339), ?sort= (created_at, name or size, prefixed with - for
+// descending) and ?limit=/?cursor= pagination.
+func (s *StorageService) listFiles(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	q := r.URL.Query()
+	page, err := types.ParsePageRequest(q, defaultFilePageSize, maxFilePageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	sort, err := types.ParseSort(q, sortableFileColumns, "-created_at")
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	// On behalf of an org, its files are listed instead of the caller's
+	where := []string{"user_id = $1", "tier = $2"}
+	args := []interface{}{userID, TierStandard}
+	if orgID := orgs.FromRequest(r); orgID != 0 {
+		where[0], args[0] = "org_id = $1", orgID
+	}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if name := q.Get("name"); name != "" {
+		where = append(where, "filename ILIKE "+arg("%"+likeEscaper.Replace(name)+"%"))
+	}
+	if prefix := q.Get("prefix"); prefix != "" {
+		where = append(where, "filename LIKE "+arg(likeEscaper.Replace(prefix)+"%"))
+	}
+	if q.Has("folder") {
+		folder, err := cleanFolder(q.Get("folder"))
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+			return
+		}
+		if q.Get("recursive") == "true" && folder != "" {
+			where = append(where, folderTree("folder", folder, arg))
+		} else if q.Get("recursive") != "true" {
+			where = append(where, "folder = "+arg(folder))
+		}
+	}
+	if fileType := q.Get("type"); fileType != "" {
+		where = append(where, "file_type = "+arg(fileType))
+	}
+	if contentType := q.Get("content_type"); contentType != "" {
+		where = append(where, "content_type = "+arg(contentType))
+	}
+	for _, bound := range []struct{ param, op string }{{"created_after", ">="}, {"created_before", "<"}} {
+		param, op := bound.param, bound.op
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, param+" must be an RFC 3339 timestamp")
+			return
+		}
+		where = append(where, "created_at "+op+" "+arg(t))
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM stored_files WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list files")
+		return
+	}
+
+	// Keyset pagination continues after the cursor position
+	var cursor fileCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil || cursor.Sort != sort.Name {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		var value interface{} = cursor.Value
+		switch sort.Column {
+		case "created_at":
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+				return
+			}
+			value = t
+		case "size_bytes":
+			n, err := strconv.ParseInt(cursor.Value, 10, 64)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+				return
+			}
+			value = n
+		}
+		where = append(where, sort.After(value, cursor.ID, arg))
+	}
+
+	files := []StoredFileInfo{}
+	err = db.SelectContext(r.Context(), &files,
+		`SELECT id, filename, size_bytes, file_type, content_type, checksum_sha256, folder, acl, scan_status, quarantined, version, temporary, created_at, expires_at FROM stored_files
+		WHERE `+strings.Join(where, " AND ")+" "+sort.OrderBy()+" "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list files")
+		return
+	}
+
+	utils.SuccessResponse(w, types.NewPage(page, files, total, func(last StoredFileInfo) interface{} {
+		cursor := fileCursor{Sort: sort.Name, ID: last.ID}
+		switch sort.Column {
+		case "filename":
+			cursor.Value = last.Name
+		case "size_bytes":
+			cursor.Value = strconv.FormatInt(last.Size, 10)
+		default:
+			cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		return cursor
+	}))
+}
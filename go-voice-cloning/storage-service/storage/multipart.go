@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// maxFormFieldBytes bounds the value of form fields other than the file
+const maxFormFieldBytes = 1 << 10
+
+var (
+	errNoFile        = errors.New("no file provided")
+	errMalformedForm = errors.New("malformed upload form")
+)
+
+// uploadForm is an upload form read from the request, its file staged in a
+// local file
+type uploadForm struct {
+	path        string
+	filename    string
+	contentType string // as declared by the client
+	size        int64
+	fileType    string
+	temporary   string
+	ttl         string
+	folder      string
+	checksum    string // SHA-256 in hex, as sent by the client
+	contentMD5  string // base64, from a field or the file part's Content-MD5 header
+}
+
+// readUploadForm streams a multipart upload form, writing its file part to a
+// temporary file as it arrives rather than parsing the whole form first, so
+// no part of it is held in memory. The file is read up to limit(fileType)
+// bytes, with the type as far as it was sent before the file; a larger file
+// is cut off there and reported one byte over. The caller removes the staged
+// file, even on error.
+func readUploadForm(r *http.Request, limit func(fileType string) int64) (uploadForm, error) {
+	var form uploadForm
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return form, fmt.Errorf("%w: %v", errMalformedForm, err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return form, formError(err)
+		}
+
+		switch name := part.FormName(); {
+		case name == "type" || name == "temporary" || name == "ttl" || name == "checksum_sha256" || name == "content_md5" || name == "folder":
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			if err != nil {
+				return form, formError(err)
+			}
+			switch name {
+			case "type":
+				form.fileType = string(value)
+			case "temporary":
+				form.temporary = string(value)
+			case "ttl":
+				form.ttl = string(value)
+			case "checksum_sha256":
+				form.checksum = string(value)
+			case "content_md5":
+				form.contentMD5 = string(value)
+			case "folder":
+				form.folder = string(value)
+			}
+		case name == "file" && form.path == "":
+			if err := form.stage(part, limit(form.fileType)); err != nil {
+				return form, err
+			}
+		}
+		part.Close()
+	}
+
+	if form.path == "" {
+		return form, errNoFile
+	}
+	return form, nil
+}
+
+// stage writes the file part to a temporary file, up to one byte past limit
+func (form *uploadForm) stage(part *multipart.Part, limit int64) error {
+	dst, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return err
+	}
+	form.path = dst.Name()
+	form.filename = part.FileName()
+	form.contentType = part.Header.Get("Content-Type")
+	if md5 := part.Header.Get("Content-MD5"); md5 != "" && form.contentMD5 == "" {
+		form.contentMD5 = md5
+	}
+
+	form.size, err = io.Copy(dst, partReader{io.LimitReader(part, limit+1)})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// partReader marks errors reading the request, as opposed to writing the
+// staged file
+type partReader struct {
+	r io.Reader
+}
+
+func (p partReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = formError(err)
+	}
+	return n, err
+}
+
+// formError marks an error reading the form, keeping a body over its limit
+// recognisable
+func formError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errMalformedForm, err)
+}
+
+// writeFormError reports an upload form that couldn't be read
+func writeFormError(w http.ResponseWriter, err error, maxBytes int64) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		uploadTooLarge(w, maxBytes)
+	case errors.Is(err, errNoFile):
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "No file provided")
+	case errors.Is(err, errMalformedForm):
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Failed to parse form")
+	default:
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save file")
+	}
+}
+
+// uploadTooLarge answers an upload over the service's size limit
+func uploadTooLarge(w http.ResponseWriter, maxBytes int64) {
+	body := utils.ErrorBody(w, apierror.PayloadTooLarge, fmt.Sprintf("uploads are limited to %d bytes", maxBytes))
+	body["max_bytes"] = maxBytes
+	utils.JSONResponse(w, http.StatusRequestEntityTooLarge, body)
+}
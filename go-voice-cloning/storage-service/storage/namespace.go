@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+)
+
+// Files are kept under their owner's prefix, so users' files never share a
+// name in the backend. Files stored by internal callers without a user stay
+// at the root, as did every file before namespacing.
+
+// namespaceMigrationBatch bounds how many files are moved under their
+// owner's prefix per round
+const namespaceMigrationBatch = 100
+
+// userPrefix is where a user's files are kept in the backend
+func userPrefix(userID int) string {
+	return fmt.Sprintf("users/%d/", userID)
+}
+
+// objectKey is the name a file is kept under in the backend
+func objectKey(userID int, id string) string {
+	if userID == 0 {
+		return id
+	}
+	return userPrefix(userID) + id
+}
+
+// storedFile is a file's name, owner and where the backend keeps it and any
+// normalized version of it, as of its current version or an earlier one
+type storedFile struct {
+	ID            string         `db:"id"`
+	Filename      string         `db:"filename"`
+	UserID        sql.NullInt64  `db:"user_id"`
+	OrgID         sql.NullInt64  `db:"org_id"`
+	Key           string         `db:"object_key"`
+	NormalizedKey sql.NullString `db:"normalized_key"`
+	ContentType   sql.NullString `db:"content_type"`
+	Checksum      sql.NullString `db:"checksum_sha256"`
+	AudioFormat   sql.NullString `db:"audio_format"`
+	AudioCodec    sql.NullString `db:"audio_codec"`
+	Quarantined   bool           `db:"quarantined"`
+	Version       int            `db:"version"`
+	ACL           string         `db:"acl"`
+	Archived      bool           `db:"-"` // an earlier version of the file
+}
+
+// storedFileColumns selects a storedFile
+const storedFileColumns = `id, filename, user_id, org_id, COALESCE(object_key, id) AS object_key, normalized_key, content_type, checksum_sha256,
+	audio_format, audio_codec, quarantined, version, acl`
+
+// owner is the ID of the user the file belongs to, 0 for internal files
+func (f storedFile) owner() int {
+	return int(f.UserID.Int64)
+}
+
+// resolveFile looks up a file's owner and key. Files without metadata are
+// internal ones kept at the root.
+func (s *StorageService) resolveFile(ctx context.Context, id string) (storedFile, error) {
+	file := storedFile{ID: id, Filename: id, Key: id}
+	err := s.db.GetContext(ctx, &file,
+		"SELECT "+storedFileColumns+" FROM stored_files WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return file, nil
+	}
+	return file, err
+}
+
+// canAccess reports whether the caller may use a file: its owner, a member
+// of the org it belongs to acting on its behalf (the gateway checks the
+// membership), an admin (whose access is audited separately) or an internal
+// call, which carries no user. Other users are told the file doesn't exist.
+func canAccess(r *http.Request, file storedFile) bool {
+	userID := middleware.UserID(r)
+	if userID == 0 || middleware.IsAdmin(r) {
+		return true
+	}
+	if orgID := orgs.FromRequest(r); orgID != 0 && file.OrgID.Valid && int(file.OrgID.Int64) == orgID {
+		return true
+	}
+	return file.owner() == userID
+}
+
+// migrateNamespaces moves files stored before namespacing under their
+// owner's prefix. Until a file is moved it's still served from the root.
+func (s *StorageService) migrateNamespaces(ctx context.Context) {
+	moved := 0
+	for {
+		var files []struct {
+			storedFile
+			Tier string `db:"tier"`
+		}
+		err := s.db.SelectContext(ctx, &files,
+			`SELECT id, filename, user_id, id AS object_key, tier FROM stored_files
+			WHERE object_key IS NULL ORDER BY created_at LIMIT $1`,
+			namespaceMigrationBatch)
+		if err != nil {
+			slog.Error("Failed to list files to namespace", "error", err)
+			return
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, file := range files {
+			key := objectKey(file.owner(), file.ID)
+			if key != file.Key {
+				storage := s.tierStorage(file.Tier)
+				err := moveBetween(ctx, storage, storage, file.Key, key)
+				if err != nil && !isNotExist(err) {
+					// Leave the rest for the next start rather than
+					// retrying the same files forever
+					slog.Error("Failed to move file under its owner's prefix", "file", file.Filename, "user_id", file.owner(), "error", err)
+					return
+				}
+			}
+			if _, err := s.db.ExecContext(ctx,
+				"UPDATE stored_files SET object_key = $1 WHERE id = $2", key, file.ID); err != nil {
+				slog.Error("Failed to record the key of a file", "file", file.Filename, "error", err)
+				return
+			}
+			moved++
+		}
+	}
+
+	if moved > 0 {
+		slog.Info("Moved files under their owners' prefixes", "count", moved)
+	}
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// File types accepted from users. The type is chosen with the "type" form
+// field of an upload and defaults to an audio sample.
+const (
+	TypeAudioSample = "audio_sample"
+	TypeAvatar      = "avatar"
+	TypeArchive     = "archive"
+	TypeLexicon     = "lexicon"
+)
+
+// Policy violations reported in upload errors
+const (
+	ViolationUnknownType = "unknown_type"
+	ViolationMIMEType    = "mime_type"
+	ViolationMaxSize     = "max_size"
+	ViolationScan        = "scan"
+	ViolationAudioFormat = "audio_format"
+)
+
+// FilePolicy is what an upload of a file type must satisfy. Files of a
+// policy with AudioFormats must parse as audio in one of them.
+type FilePolicy struct {
+	Type         string   `json:"type"`
+	MIMETypes    []string `json:"mime_types"`
+	AudioFormats []string `json:"audio_formats,omitempty"`
+	MaxBytes     int64    `json:"max_bytes"`
+	ScanRequired bool     `json:"scan_required"`
+}
+
+// PolicyViolation is a rejected upload, reported to the client as the
+// response's error
+type PolicyViolation struct {
+	Status         int           `json:"-"`
+	Code           apierror.Code `json:"code"`
+	Message        string        `json:"message"`
+	Policy         string        `json:"policy,omitempty"`
+	Violation      string        `json:"violation"`
+	Detected       string        `json:"detected,omitempty"`
+	AllowedTypes   []string      `json:"allowed_types,omitempty"`
+	AllowedFormats []string      `json:"allowed_formats,omitempty"`
+	MaxBytes       int64         `json:"max_bytes,omitempty"`
+}
+
+func (v *PolicyViolation) Error() string { return v.Message }
+
+func defaultFilePolicies() map[string]FilePolicy {
+	return map[string]FilePolicy{
+		TypeAudioSample: {
+			Type: TypeAudioSample,
+			MIMETypes: []string{"audio/wav", "audio/wave", "audio/x-wav", "audio/mpeg", "audio/flac", "audio/x-flac",
+				"audio/ogg", "application/ogg"},
+			AudioFormats: []string{audio.FormatWAV, audio.FormatMP3, audio.FormatFLAC, audio.FormatOgg},
+			MaxBytes:     50 << 20,
+		},
+		TypeAvatar: {
+			Type:      TypeAvatar,
+			MIMETypes: []string{"image/png", "image/jpeg", "image/webp", "image/gif"},
+			MaxBytes:  2 << 20,
+		},
+		TypeArchive: {
+			Type:         TypeArchive,
+			MIMETypes:    []string{"application/zip", "application/x-gzip", "application/gzip", "application/x-tar"},
+			MaxBytes:     500 << 20,
+			ScanRequired: true,
+		},
+		TypeLexicon: {
+			Type:      TypeLexicon,
+			MIMETypes: []string{"text/plain", "application/pls+xml", "application/xml", "text/xml", "application/json"},
+			MaxBytes:  1 << 20,
+		},
+	}
+}
+
+// loadFilePolicies returns the built-in policies with any overrides from
+// the JSON array in the file at path (FILE_POLICY_PATH). An override
+// replaces the policy of the same type; new types are added.
+func loadFilePolicies(path string) (map[string]FilePolicy, error) {
+	policies := defaultFilePolicies()
+	if path == "" {
+		return policies, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides []FilePolicy
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("invalid file policies: %w", err)
+	}
+	for _, p := range overrides {
+		if p.Type == "" || len(p.MIMETypes) == 0 || p.MaxBytes <= 0 {
+			return nil, fmt.Errorf("file policy %q needs mime_types and a positive max_bytes", p.Type)
+		}
+		policies[p.Type] = p
+	}
+	return policies, nil
+}
+
+// checkUpload validates a staged upload against the policy of its type
+func (s *StorageService) checkUpload(fileType string, form uploadForm) (FilePolicy, error) {
+	policy, err := s.uploadPolicy(fileType, form.size)
+	if err != nil {
+		return policy, err
+	}
+	staged, err := os.Open(form.path)
+	if err != nil {
+		return policy, err
+	}
+	defer staged.Close()
+	return policy, checkContent(policy, staged, form.contentType)
+}
+
+// uploadPolicy returns the policy of a file type, checking an upload of size
+// bytes is within it
+func (s *StorageService) uploadPolicy(fileType string, size int64) (FilePolicy, error) {
+	policy, ok := s.policies[fileType]
+	if !ok {
+		types := make([]string, 0, len(s.policies))
+		for t := range s.policies {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return policy, &PolicyViolation{
+			Status:       http.StatusBadRequest,
+			Code:         apierror.UploadPolicy,
+			Message:      fmt.Sprintf("unknown file type %q", fileType),
+			Violation:    ViolationUnknownType,
+			AllowedTypes: types,
+		}
+	}
+
+	if size > policy.MaxBytes {
+		return policy, &PolicyViolation{
+			Status:    http.StatusRequestEntityTooLarge,
+			Code:      apierror.UploadPolicy,
+			Message:   fmt.Sprintf("%s files are limited to %d bytes", policy.Type, policy.MaxBytes),
+			Policy:    policy.Type,
+			Violation: ViolationMaxSize,
+			MaxBytes:  policy.MaxBytes,
+		}
+	}
+	return policy, nil
+}
+
+// checkContent checks a file's detected type is allowed by the policy.
+// declared is the content type the client gave.
+func checkContent(policy FilePolicy, file io.ReadSeeker, declared string) error {
+	detected, err := detectMIMEType(file, declared)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range policy.MIMETypes {
+		if detected == allowed {
+			return nil
+		}
+	}
+	return &PolicyViolation{
+		Status:       http.StatusUnsupportedMediaType,
+		Code:         apierror.UploadPolicy,
+		Message:      fmt.Sprintf("%s is not an allowed %s format", detected, policy.Type),
+		Policy:       policy.Type,
+		Violation:    ViolationMIMEType,
+		Detected:     detected,
+		AllowedTypes: policy.MIMETypes,
+	}
+}
+
+// detectMIMEType sniffs the content of an upload. Formats the sniffer doesn't
+// recognise fall back to the declared content type.
+func detectMIMEType(file io.ReadSeeker, declared string) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	detected := http.DetectContentType(buf[:n])
+	if detected == "application/octet-stream" {
+		detected = declared
+	}
+	mediaType, _, err := mime.ParseMediaType(detected)
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return mediaType, nil
+}
+
+// checkAudio parses an audio sample's container to read its format. Files
+// of a policy with AudioFormats that aren't audio in one of them are
+// rejected; for other audio samples the format is only read when it can be.
+// The zero Info is returned for files that aren't audio samples.
+func checkAudio(policy FilePolicy, path string) (audio.Info, error) {
+	if policy.Type != TypeAudioSample && len(policy.AudioFormats) == 0 {
+		return audio.Info{}, nil
+	}
+	info, err := audio.Probe(path)
+	if err == nil && (info.SampleRate == 0 || info.Channels == 0) {
+		err = fmt.Errorf("%s file has no sample rate or channels", info.Format)
+	}
+	if len(policy.AudioFormats) == 0 {
+		if err != nil {
+			return audio.Info{}, nil
+		}
+		return info, nil
+	}
+
+	if err != nil {
+		return audio.Info{}, &PolicyViolation{
+			Status:         http.StatusUnsupportedMediaType,
+			Code:           apierror.UploadPolicy,
+			Message:        fmt.Sprintf("file is not valid %s audio: %v", strings.Join(policy.AudioFormats, ", "), err),
+			Policy:         policy.Type,
+			Violation:      ViolationAudioFormat,
+			AllowedFormats: policy.AudioFormats,
+		}
+	}
+	for _, allowed := range policy.AudioFormats {
+		if info.Format == allowed {
+			return info, nil
+		}
+	}
+	return audio.Info{}, &PolicyViolation{
+		Status:         http.StatusUnsupportedMediaType,
+		Code:           apierror.UploadPolicy,
+		Message:        fmt.Sprintf("%s audio is not an allowed %s format", info.Format, policy.Type),
+		Policy:         policy.Type,
+		Violation:      ViolationAudioFormat,
+		Detected:       info.Format,
+		AllowedFormats: policy.AudioFormats,
+	}
+}
+
+// writeUploadError reports policy violations with their details and any
+// other failure as a plain error
+func writeUploadError(w http.ResponseWriter, err error, fallback string) {
+	var violation *PolicyViolation
+	if errors.As(err, &violation) {
+		utils.JSONResponse(w, violation.Status, violation)
+		return
+	}
+	utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, fallback)
+}
+
+// listPolicies publishes the upload policies so clients can validate first
+func (s *StorageService) listPolicies(w http.ResponseWriter, r *http.Request) {
+	policies := make([]FilePolicy, 0, len(s.policies))
+	for _, p := range s.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Type < policies[j].Type })
+	utils.SuccessResponse(w, policies)
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/signedurl"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// presignConfig bounds how long presigned download links stay valid
+type presignConfig struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+// PresignSettings bound how long presigned download links stay valid
+type PresignSettings struct {
+	DefaultTTL time.Duration `env:"PRESIGN_TTL_SECONDS" default:"900" min:"1"`
+	MaxTTL     time.Duration `env:"PRESIGN_MAX_TTL_SECONDS" default:"86400" min:"1"`
+}
+
+// newPresignConfig caps the default TTL at the maximum
+func newPresignConfig(settings PresignSettings) presignConfig {
+	cfg := presignConfig{defaultTTL: settings.DefaultTTL, maxTTL: settings.MaxTTL}
+	if cfg.defaultTTL > cfg.maxTTL {
+		cfg.defaultTTL = cfg.maxTTL
+	}
+	return cfg
+}
+
+// presignFile issues a signed, expiring link to download a file without a
+// token, for clients such as the web player that fetch audio directly. The
+// link is verified by the download route's signature check.
+func (s *StorageService) presignFile(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Signed links are not available")
+		return
+	}
+
+	var req struct {
+		ExpiresIn int64 `json:"expires_in"` // seconds
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Invalid request body")
+		return
+	}
+	if !validation.Check(w, &req) {
+		return
+	}
+	ttl := s.presign.defaultTTL
+	if req.ExpiresIn < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "expires_in must be positive")
+		return
+	}
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > s.presign.maxTTL {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter,
+			fmt.Sprintf("expires_in must be at most %d seconds", int64(s.presign.maxTTL/time.Second)))
+		return
+	}
+
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+
+	// Links are only issued for files that can be downloaded now
+	if _, err := s.storage.Stat(r.Context(), file.Key); err != nil {
+		if isNotExist(err) {
+			if _, err := s.cold.Stat(r.Context(), file.Key); err == nil {
+				utils.ErrorResponse(w, http.StatusConflict, apierror.FileColdStorage, "File is in cold storage")
+				return
+			}
+		}
+		storageError(w, err, file.ID, "Failed to sign link")
+		return
+	}
+	if !s.auditAdminAccess(w, r, file, accessPresign) {
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	link := s.signer.Sign("/api/public/download/"+url.PathEscape(file.ID), file.ID, signedurl.ScopeDownload, expires)
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"id":         file.ID,
+		"url":        link,
+		"expires_at": expires.UTC(),
+	})
+}
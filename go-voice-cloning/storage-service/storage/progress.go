@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// uploadProgressInterval spaces the progress events sent while a chunk is
+// being received, so a large chunk moves a progress bar without a
+// notification per read
+const uploadProgressInterval = time.Second
+
+// uploadProgress is a session as reported to its client: how many bytes
+// have been received of the expected size
+type uploadProgress struct {
+	uploadSession
+	ReceivedBytes int64 `json:"received_bytes"`
+	ExpectedBytes int64 `json:"expected_bytes"`
+	Percent       int   `json:"percent"`
+}
+
+func (session uploadSession) progress() uploadProgress {
+	return uploadProgress{
+		uploadSession: session,
+		ReceivedBytes: session.Offset,
+		ExpectedBytes: session.Size,
+		Percent:       types.UploadPercent(session.Offset, session.Size),
+	}
+}
+
+// notifyUpload tells the voice service, which pushes it to the user's
+// WebSocket, how far a session has got. Internal sessions have nobody to
+// tell. A failure is logged; the client can still get the session.
+func (s *StorageService) notifyUpload(session uploadSession, kind string) {
+	if !session.UserID.Valid {
+		return
+	}
+	event, _ := json.Marshal(types.UploadEvent{
+		Kind:          kind,
+		UploadID:      session.ID,
+		UserID:        int(session.UserID.Int64),
+		Filename:      session.Filename,
+		ReceivedBytes: session.Offset,
+		ExpectedBytes: session.Size,
+		Percent:       types.UploadPercent(session.Offset, session.Size),
+		OccurredAt:    time.Now().UTC(),
+	})
+	if _, err := s.db.Exec("SELECT pg_notify($1, $2)", types.UploadEventsChannel, string(event)); err != nil {
+		slog.Warn("Failed to notify upload progress", "session_id", session.ID, "error", err)
+	}
+}
+
+// progressReader notifies a session's progress while a chunk is read
+type progressReader struct {
+	r       io.Reader
+	s       *StorageService
+	session uploadSession
+	last    time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.session.Offset += int64(n)
+	if n > 0 && time.Since(p.last) >= uploadProgressInterval {
+		p.last = time.Now()
+		p.s.notifyUpload(p.session, types.UploadEventProgress)
+	}
+	return n, err
+}
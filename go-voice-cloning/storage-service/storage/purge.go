@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// purgeUserFiles removes everything a deleted user stored: their files with
+// their versions, unfinished uploads, folders, quota overrides and the
+// shares granted to them (internal, for the user service's account deletion
+// orchestrator). Files that fail are reported and kept, so calling again
+// retries just those. Access logs are kept for audit.
+func (s *StorageService) purgeUserFiles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	purge := types.UserFilesPurge{UserID: userID, FilesFailed: []string{}}
+	if err := s.purgeFiles(r.Context(), &purge); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to purge files")
+		return
+	}
+	if err := s.purgeUploads(r.Context(), &purge); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to purge uploads")
+		return
+	}
+	for _, table := range []string{"folders", "storage_quotas", "file_shares"} {
+		if _, err := s.db.ExecContext(r.Context(), "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to purge files")
+			return
+		}
+	}
+	if purge.FilesDeleted > 0 || len(purge.FilesFailed) > 0 {
+		logging.FromContext(r.Context()).Info("Purged the files of a deleted user", "user_id", userID, "files_deleted", purge.FilesDeleted, "files_failed", len(purge.FilesFailed))
+	}
+	utils.SuccessResponse(w, purge)
+}
+
+// purgeFiles removes a user's files a batch at a time. The ones that fail
+// are left out of the next batches.
+func (s *StorageService) purgeFiles(ctx context.Context, purge *types.UserFilesPurge) error {
+	for {
+		files := []storedFile{}
+		err := s.db.SelectContext(ctx, &files,
+			"SELECT "+storedFileColumns+" FROM stored_files WHERE user_id = $1 AND NOT (id = ANY($2)) ORDER BY id LIMIT $3",
+			purge.UserID, pq.Array(purge.FilesFailed), janitorBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		for _, file := range files {
+			tier, err := s.locateFile(ctx, file.Key)
+			if err == nil || isNotExist(err) {
+				// A file whose content is gone only has metadata left
+				err = s.removeFile(ctx, s.tierStorage(tier), file)
+			}
+			if err != nil {
+				logging.FromContext(ctx).Error("Failed to purge file", "file_id", file.ID, "user_id", purge.UserID, "error", err)
+				purge.FilesFailed = append(purge.FilesFailed, file.ID)
+				continue
+			}
+			purge.FilesDeleted++
+		}
+	}
+}
+
+// purgeUploads cancels a user's resumable and direct uploads
+func (s *StorageService) purgeUploads(ctx context.Context, purge *types.UserFilesPurge) error {
+	sessions := []string{}
+	if err := s.db.SelectContext(ctx, &sessions, "SELECT id FROM upload_sessions WHERE user_id = $1", purge.UserID); err != nil {
+		return err
+	}
+	for _, id := range sessions {
+		s.endUploadSession(id)
+	}
+	purge.UploadsCancelled += len(sessions)
+
+	direct := []string{}
+	if err := s.db.SelectContext(ctx, &direct, "SELECT id FROM direct_uploads WHERE user_id = $1", purge.UserID); err != nil {
+		return err
+	}
+	if backend, ok := s.directBackend(); ok {
+		for _, id := range direct {
+			s.endDirectUpload(ctx, backend, id)
+		}
+	} else if _, err := s.db.ExecContext(ctx, "DELETE FROM direct_uploads WHERE user_id = $1", purge.UserID); err != nil {
+		return err
+	}
+	purge.UploadsCancelled += len(direct)
+	return nil
+}
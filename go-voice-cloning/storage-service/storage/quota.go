@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Storage classes. Samples are user uploads used for training; outputs are
+// generated by the voice worker and can be regenerated, so they get a
+// larger bucket and expire by default.
+const (
+	ClassSample = "sample"
+	ClassOutput = "output"
+)
+
+// StorageClassHeader marks an upload as generated output. The gateway strips
+// it so only internal callers can use the output bucket.
+const StorageClassHeader = "X-Storage-Class"
+
+// QuotaClass is the limit and retention default of a storage class
+type QuotaClass struct {
+	Name      string
+	Limit     int64         // bytes, 0 means unlimited
+	Retention time.Duration // 0 means files are kept until deleted
+}
+
+// QuotaSettings are the class defaults. Users get the limits their plan
+// includes; the defaults apply while the user service can't be reached.
+type QuotaSettings struct {
+	SampleLimit     int64         `env:"SAMPLE_QUOTA_BYTES" default:"1073741824" min:"0"`
+	SampleRetention time.Duration `env:"SAMPLE_RETENTION_DAYS" default:"0" unit:"d" min:"0"`
+	OutputLimit     int64         `env:"OUTPUT_QUOTA_BYTES" default:"5368709120" min:"0"`
+	OutputRetention time.Duration `env:"OUTPUT_RETENTION_DAYS" default:"30" unit:"d" min:"0"`
+}
+
+// quotaClasses returns the classes with their default limits
+func quotaClasses(settings QuotaSettings) map[string]QuotaClass {
+	return map[string]QuotaClass{
+		ClassSample: {
+			Name:      ClassSample,
+			Limit:     settings.SampleLimit,
+			Retention: settings.SampleRetention,
+		},
+		ClassOutput: {
+			Name:      ClassOutput,
+			Limit:     settings.OutputLimit,
+			Retention: settings.OutputRetention,
+		},
+	}
+}
+
+// uploadClass picks the bucket an upload is counted against
+func (s *StorageService) uploadClass(r *http.Request) QuotaClass {
+	if r.Header.Get(StorageClassHeader) == ClassOutput {
+		return s.classes[ClassOutput]
+	}
+	return s.classes[ClassSample]
+}
+
+// planClass gives a class the limit the user's plan includes. While the
+// user service can't be reached, the class default applies.
+func (s *StorageService) planClass(ctx context.Context, userID int, class QuotaClass) QuotaClass {
+	e, err := s.entitlements.Get(ctx, userID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to fetch entitlements, using the class default", "user_id", userID, "class", class.Name, "error", err)
+		return class
+	}
+	switch class.Name {
+	case ClassSample:
+		class.Limit = e.SampleStorageBytes
+	case ClassOutput:
+		class.Limit = e.OutputStorageBytes
+	}
+	return class
+}
+
+// classUsage returns a user's usage of a class, against the user's own
+// limit if an admin set one. Earlier versions of files count too.
+func (s *StorageService) classUsage(db *sqlx.DB, userID int, class QuotaClass) (types.StorageClassUsage, error) {
+	usage := types.StorageClassUsage{RetentionDays: int(class.Retention / (24 * time.Hour))}
+	err := db.Get(&usage,
+		`SELECT COUNT(*) AS files,
+			COALESCE(SUM(size_bytes), 0) + COALESCE((SELECT SUM(v.size_bytes) FROM file_versions v
+				JOIN stored_files f ON f.id = v.file_id WHERE f.user_id = $1 AND f.class = $2), 0) AS bytes,
+			COALESCE((SELECT limit_bytes FROM storage_quotas WHERE user_id = $1 AND class = $2), $3) AS limit_bytes
+		FROM stored_files WHERE user_id = $1 AND class = $2`,
+		userID, class.Name, class.Limit)
+	return usage, err
+}
+
+// storageUsage returns a user's usage of every class
+func (s *StorageService) storageUsage(ctx context.Context, db *sqlx.DB, userID int) (types.StorageUsage, error) {
+	usage := types.StorageUsage{UserID: userID}
+	var err error
+	if usage.Samples, err = s.classUsage(db, userID, s.planClass(ctx, userID, s.classes[ClassSample])); err != nil {
+		return usage, err
+	}
+	if usage.Outputs, err = s.classUsage(db, userID, s.planClass(ctx, userID, s.classes[ClassOutput])); err != nil {
+		return usage, err
+	}
+	usage.TotalBytes = usage.Samples.Bytes + usage.Outputs.Bytes
+	return usage, nil
+}
+
+// usageSubject is the user whose usage is requested: the caller, or the
+// ?user_id= given by an admin or an internal caller such as billing. 0 means
+// the request has been answered.
+func usageSubject(w http.ResponseWriter, r *http.Request) int {
+	userID := middleware.UserID(r)
+	v := r.URL.Query().Get("user_id")
+	if v == "" {
+		if userID == 0 {
+			utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		}
+		return userID
+	}
+	if userID != 0 && !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return 0
+	}
+	subject, err := strconv.Atoi(v)
+	if err != nil || subject <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user_id")
+		return 0
+	}
+	return subject
+}
+
+// getUsage reports sample and output usage separately
+func (s *StorageService) getUsage(w http.ResponseWriter, r *http.Request) {
+	userID := usageSubject(w, r)
+	if userID == 0 {
+		return
+	}
+
+	usage, err := s.storageUsage(r.Context(), dbroute.Reader(r, s.db, s.replica), userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch usage")
+		return
+	}
+	utils.SuccessResponse(w, usage)
+}
+
+// setUserQuota sets or, with a null limit_bytes, clears a user's own limit
+// of a class. Admin only.
+func (s *StorageService) setUserQuota(w http.ResponseWriter, r *http.Request) {
+	adminID := middleware.UserID(r)
+	if !middleware.IsAdmin(r) || adminID == 0 {
+		s.audit.Request(r, "set_quota", "user", mux.Vars(r)["user_id"], audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	var req struct {
+		Class      string `json:"class"`
+		LimitBytes *int64 `json:"limit_bytes"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if _, ok := s.classes[req.Class]; !ok {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "class must be sample or output")
+		return
+	}
+	if req.LimitBytes != nil && *req.LimitBytes < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "limit_bytes must not be negative")
+		return
+	}
+
+	if req.LimitBytes == nil {
+		_, err = s.db.ExecContext(r.Context(),
+			"DELETE FROM storage_quotas WHERE user_id = $1 AND class = $2", userID, req.Class)
+	} else {
+		_, err = s.db.ExecContext(r.Context(),
+			`INSERT INTO storage_quotas (user_id, class, limit_bytes, updated_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, class) DO UPDATE SET limit_bytes = $3, updated_by = $4, updated_at = NOW()`,
+			userID, req.Class, *req.LimitBytes, adminID)
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to set quota")
+		return
+	}
+	s.audit.Request(r, "set_quota", "user", strconv.Itoa(userID), audit.Success,
+		map[string]interface{}{"class": req.Class, "limit_bytes": req.LimitBytes})
+
+	usage, err := s.storageUsage(r.Context(), s.db, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch usage")
+		return
+	}
+	utils.SuccessResponse(w, usage)
+}
+
+// getCalendar publishes upcoming file expirations for the user service
+// calendar
+func (s *StorageService) getCalendar(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		from = time.Now()
+	}
+	to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+	if err != nil {
+		to = from.AddDate(0, 0, 30)
+	}
+
+	var files []struct {
+		ID        string    `db:"id"`
+		Filename  string    `db:"filename"`
+		Class     string    `db:"class"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	err = dbroute.Reader(r, s.db, s.replica).Select(&files,
+		`SELECT id, filename, class, expires_at FROM stored_files
+		WHERE user_id = $1 AND expires_at BETWEEN $2 AND $3
+		ORDER BY expires_at`,
+		userID, from, to)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch expirations")
+		return
+	}
+
+	events := []types.CalendarEvent{}
+	for _, f := range files {
+		events = append(events, types.CalendarEvent{
+			Type:     types.EventFileExpiration,
+			Title:    "Stored " + f.Class + " " + f.Filename + " expires",
+			At:       f.ExpiresAt,
+			Resource: f.ID,
+		})
+	}
+
+	utils.SuccessResponse(w, events)
+}
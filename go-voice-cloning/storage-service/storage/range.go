@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// rangeHeader requests length bytes from offset on from a remote backend
+func rangeHeader(offset, length int64) http.Header {
+	return http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+}
+
+// objectReader reads a stored file as an io.ReadSeeker, so it can be served
+// with http.ServeContent. Seeking is free; each read after a seek fetches
+// the rest of the file from the new position with a ranged read, so a
+// request for part of a file only transfers that part from the backend.
+type objectReader struct {
+	ctx     context.Context
+	storage Storage
+	name    string
+	size    int64
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func newObjectReader(ctx context.Context, storage Storage, name string, size int64) *objectReader {
+	return &objectReader{ctx: ctx, storage: storage, name: name, size: size}
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.storage.GetRange(o.ctx, o.name, o.offset, o.size-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("objectReader: negative position")
+	}
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -0,0 +1,488 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Stored objects can be copied to a replica, a second backend such as
+// another bucket or region, as a backup. Objects are copied in the
+// background once stored, as they are kept, encrypted if the files are, and
+// removed from the replica once no file references them. Each object's
+// replication is tracked, and the reconcile-replicas command compares the
+// replica with the primary storage and repairs what diverged.
+
+// Replication statuses of an object
+const (
+	ReplicaPending    = "pending"
+	ReplicaCopying    = "copying"
+	ReplicaReplicated = "replicated"
+	ReplicaFailed     = "failed"
+)
+
+// liveObjectKeys selects the keys of every object a file or an earlier
+// version references
+const liveObjectKeys = `
+	SELECT object_key FROM stored_files
+	UNION SELECT normalized_key FROM stored_files WHERE normalized_key IS NOT NULL
+	UNION SELECT object_key FROM file_versions
+	UNION SELECT normalized_key FROM file_versions WHERE normalized_key IS NOT NULL`
+
+// replicationBatchSize bounds how many objects are copied, and how many
+// removed from the replica, per pass
+const replicationBatchSize = 100
+
+// replicationLease is how long a copy may take before another instance
+// takes it over
+const replicationLease = 30 * time.Minute
+
+// maxReplicationBackoff bounds the wait before a failed copy is retried
+const maxReplicationBackoff = time.Hour
+
+// ReplicaSettings configure the replica (REPLICA_BACKEND): a directory on
+// local disk or an S3 bucket configured as the primary one, with REPLICA_
+// prefixed variables such as REPLICA_S3_BUCKET and REPLICA_S3_REGION
+type ReplicaSettings struct {
+	Backend string `env:"REPLICA_BACKEND"`
+	Path    string `env:"REPLICA_STORAGE_PATH"`
+	S3      ReplicaS3Settings
+}
+
+// ReplicaS3Settings are S3Settings for the replica's bucket. Its
+// credentials default to the primary bucket's.
+type ReplicaS3Settings struct {
+	Endpoint     string `env:"REPLICA_S3_ENDPOINT"`
+	Region       string `env:"REPLICA_S3_REGION"`
+	Bucket       string `env:"REPLICA_S3_BUCKET"`
+	Prefix       string `env:"REPLICA_S3_PREFIX"`
+	PathStyle    string `env:"REPLICA_S3_PATH_STYLE"`
+	AccessKey    string `env:"REPLICA_S3_ACCESS_KEY_ID" secret:"true"`
+	SecretKey    string `env:"REPLICA_S3_SECRET_ACCESS_KEY" secret:"true"`
+	SessionToken string `env:"REPLICA_S3_SESSION_TOKEN" secret:"true"`
+}
+
+// openReplica opens the replica. Without REPLICA_BACKEND nothing is
+// replicated.
+func openReplica(settings ReplicaSettings, primary S3Settings, aws AWSSettings) (Storage, error) {
+	switch backend := settings.Backend; backend {
+	case "":
+		return nil, nil
+	case BackendLocal:
+		if settings.Path == "" {
+			return nil, fmt.Errorf("REPLICA_STORAGE_PATH is required")
+		}
+		return NewLocalStorage(settings.Path)
+	case BackendS3:
+		replica := settings.S3
+		cfg, err := s3Config("REPLICA_", S3Settings{
+			Endpoint:     replica.Endpoint,
+			Region:       replica.Region,
+			Bucket:       replica.Bucket,
+			Prefix:       replica.Prefix,
+			PathStyle:    replica.PathStyle,
+			AccessKey:    replica.AccessKey,
+			SecretKey:    replica.SecretKey,
+			SessionToken: replica.SessionToken,
+		}, awsCredentials{primary.AccessKey, primary.SecretKey, primary.SessionToken}, aws.credentials())
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Storage(cfg)
+	default:
+		return nil, fmt.Errorf("unknown REPLICA_BACKEND %q", backend)
+	}
+}
+
+// runReplication copies new objects to the replica and removes those no
+// longer referenced
+func (s *StorageService) runReplication(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.queueReplicas(ctx)
+		s.pruneReplicas(ctx)
+		// A full batch means more objects are waiting
+		if s.replicateQueued(ctx) == replicationBatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueReplicas tracks objects stored since the last pass
+func (s *StorageService) queueReplicas(ctx context.Context) {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO object_replicas (object_key)
+		SELECT object_key FROM (`+liveObjectKeys+`) live
+		WHERE NOT EXISTS (SELECT 1 FROM object_replicas r WHERE r.object_key = live.object_key)
+		LIMIT $1
+		ON CONFLICT DO NOTHING`, replicationBatchSize*10)
+	if err != nil {
+		slog.Error("Failed to queue objects for replication", "error", err)
+	}
+}
+
+// requeueReplica has an object copied again, as its content changed
+func (s *StorageService) requeueReplica(ctx context.Context, key string) {
+	if s.secondary == nil {
+		return
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE object_replicas SET status = $1, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE object_key = $2`, ReplicaPending, key); err != nil {
+		logging.FromContext(ctx).Error("Failed to queue object for replication", "key", key, "error", err)
+	}
+}
+
+// replicateQueued claims a batch of objects waiting to be copied, and copies
+// abandoned by another instance, and copies them. It returns how many were
+// claimed.
+func (s *StorageService) replicateQueued(ctx context.Context) int {
+	var objects []struct {
+		Key      string `db:"object_key"`
+		Attempts int    `db:"attempts"`
+	}
+	err := s.db.SelectContext(ctx, &objects,
+		`UPDATE object_replicas SET status = $1, attempts = attempts + 1, updated_at = NOW()
+		WHERE object_key IN (
+			SELECT object_key FROM object_replicas
+			WHERE (status IN ($2, $3) AND next_attempt_at <= NOW()) OR (status = $1 AND updated_at < $4)
+			ORDER BY next_attempt_at LIMIT $5 FOR UPDATE SKIP LOCKED)
+		RETURNING object_key, attempts`,
+		ReplicaCopying, ReplicaPending, ReplicaFailed, time.Now().Add(-replicationLease), replicationBatchSize)
+	if err != nil {
+		slog.Error("Failed to claim objects to replicate", "error", err)
+		return 0
+	}
+
+	for _, object := range objects {
+		size, err := s.replicateObject(ctx, object.Key)
+		if err != nil {
+			backoff := maxReplicationBackoff
+			if object.Attempts < 6 {
+				backoff = time.Minute << object.Attempts
+			}
+			slog.Warn("Failed to replicate object", "key", object.Key, "attempt", object.Attempts, "error", err)
+			s.db.ExecContext(ctx,
+				`UPDATE object_replicas SET status = $1, last_error = $2, next_attempt_at = $3, updated_at = NOW()
+				WHERE object_key = $4`,
+				ReplicaFailed, err.Error(), time.Now().Add(backoff), object.Key)
+			continue
+		}
+		s.recordReplicated(ctx, object.Key, size)
+	}
+	return len(objects)
+}
+
+// replicateObject copies an object, as it's stored, from its tier to the
+// replica, returning its size
+func (s *StorageService) replicateObject(ctx context.Context, key string) (int64, error) {
+	tier, err := s.locateFile(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	src := rawStorage(s.tierStorage(tier))
+	info, err := src.Stat(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	in, err := src.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	copied, err := s.secondary.Put(ctx, key, in, info.Size)
+	if err != nil {
+		return 0, err
+	}
+	if copied.Size != info.Size {
+		return 0, fmt.Errorf("replica has %d bytes of %d", copied.Size, info.Size)
+	}
+	return info.Size, nil
+}
+
+// recordReplicated records an object as copied to the replica
+func (s *StorageService) recordReplicated(ctx context.Context, key string, size int64) {
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO object_replicas (object_key, status, size_bytes, replicated_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (object_key) DO UPDATE SET status = $2, size_bytes = $3, attempts = 0, last_error = NULL,
+			replicated_at = NOW(), updated_at = NOW()`,
+		key, ReplicaReplicated, size); err != nil {
+		logging.FromContext(ctx).Error("Failed to record object as replicated", "key", key, "error", err)
+	}
+}
+
+// pruneReplicas removes objects no file references anymore from the replica
+func (s *StorageService) pruneReplicas(ctx context.Context) {
+	var keys []string
+	err := s.db.SelectContext(ctx, &keys,
+		`SELECT object_key FROM object_replicas r
+		WHERE status <> $1 AND NOT EXISTS (SELECT 1 FROM (`+liveObjectKeys+`) live WHERE live.object_key = r.object_key)
+		LIMIT $2`, ReplicaCopying, replicationBatchSize)
+	if err != nil {
+		slog.Error("Failed to list objects to remove from the replica", "error", err)
+		return
+	}
+	for _, key := range keys {
+		if err := s.removeReplica(ctx, key); err != nil {
+			slog.Error("Failed to remove object from the replica", "key", key, "error", err)
+		}
+	}
+}
+
+// removeReplica deletes an object from the replica and stops tracking it
+func (s *StorageService) removeReplica(ctx context.Context, key string) error {
+	if err := s.secondary.Delete(ctx, key); err != nil && !isNotExist(err) {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM object_replicas WHERE object_key = $1", key)
+	return err
+}
+
+// ReplicationReport is what reconciliation found: objects missing from the
+// replica, copied with a different size, lost from the primary storage but
+// kept by the replica, missing from both, and replicated objects no file
+// references anymore
+type ReplicationReport struct {
+	Checked       int      `json:"checked"`
+	InSync        int      `json:"in_sync"`
+	Missing       []string `json:"missing"`
+	Mismatched    []string `json:"mismatched"`
+	Lost          []string `json:"lost"`
+	Unrecoverable []string `json:"unrecoverable"`
+	Unreferenced  []string `json:"unreferenced"`
+	Repaired      int      `json:"repaired"`
+	Failed        []string `json:"failed"`
+}
+
+// diverged reports whether anything was found out of sync
+func (r *ReplicationReport) diverged() bool {
+	return len(r.Missing)+len(r.Mismatched)+len(r.Lost)+len(r.Unrecoverable)+len(r.Unreferenced) > 0
+}
+
+// reconcileReplicas compares every referenced object with its replica. With
+// repair, missing and mismatched objects are copied again, lost ones
+// restored to the standard tier from the replica and unreferenced ones
+// removed from it.
+func (s *StorageService) reconcileReplicas(ctx context.Context, repair bool) (*ReplicationReport, error) {
+	report := &ReplicationReport{
+		Missing: []string{}, Mismatched: []string{}, Lost: []string{}, Unrecoverable: []string{},
+		Unreferenced: []string{}, Failed: []string{},
+	}
+	fail := func(key string, err error) {
+		slog.Error("Reconciling object failed", "key", key, "error", err)
+		report.Failed = append(report.Failed, key)
+	}
+
+	after := ""
+	for {
+		var keys []string
+		if err := s.db.SelectContext(ctx, &keys,
+			`SELECT object_key FROM (`+liveObjectKeys+`) live WHERE object_key > $1 ORDER BY object_key LIMIT $2`,
+			after, replicationBatchSize); err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		for _, key := range keys {
+			after = key
+			report.Checked++
+			tier, err := s.locateFile(ctx, key)
+			var primary FileInfo
+			if err == nil {
+				primary, err = rawStorage(s.tierStorage(tier)).Stat(ctx, key)
+			}
+			if err != nil && !isNotExist(err) {
+				fail(key, err)
+				continue
+			}
+			inPrimary := err == nil
+			replica, err := s.secondary.Stat(ctx, key)
+			if err != nil && !isNotExist(err) {
+				fail(key, err)
+				continue
+			}
+			inReplica := err == nil
+
+			switch {
+			case inPrimary && inReplica && primary.Size == replica.Size:
+				report.InSync++
+				s.recordReplicated(ctx, key, replica.Size)
+				continue
+			case !inPrimary && !inReplica:
+				report.Unrecoverable = append(report.Unrecoverable, key)
+				continue
+			case !inPrimary:
+				report.Lost = append(report.Lost, key)
+				if repair {
+					if err := s.restoreFromReplica(ctx, key, replica.Size); err != nil {
+						fail(key, err)
+						continue
+					}
+					s.recordReplicated(ctx, key, replica.Size)
+					report.Repaired++
+				}
+				continue
+			case !inReplica:
+				report.Missing = append(report.Missing, key)
+			default:
+				report.Mismatched = append(report.Mismatched, key)
+			}
+			if repair {
+				size, err := s.replicateObject(ctx, key)
+				if err != nil {
+					fail(key, err)
+					continue
+				}
+				s.recordReplicated(ctx, key, size)
+				report.Repaired++
+			}
+		}
+	}
+
+	var unreferenced []string
+	if err := s.db.SelectContext(ctx, &unreferenced,
+		`SELECT object_key FROM object_replicas r
+		WHERE NOT EXISTS (SELECT 1 FROM (`+liveObjectKeys+`) live WHERE live.object_key = r.object_key)
+		ORDER BY object_key`); err != nil {
+		return nil, err
+	}
+	for _, key := range unreferenced {
+		report.Unreferenced = append(report.Unreferenced, key)
+		if repair {
+			if err := s.removeReplica(ctx, key); err != nil {
+				fail(key, err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	return report, nil
+}
+
+// restoreFromReplica copies an object lost from the primary storage back to
+// the standard tier, as it was stored
+func (s *StorageService) restoreFromReplica(ctx context.Context, key string, size int64) error {
+	in, err := s.secondary.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := rawStorage(s.storage).Put(ctx, key, in, size); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE stored_files SET tier = $1 WHERE object_key = $2", TierStandard, key)
+	return err
+}
+
+// reconcileCommand runs reconcile-replicas [-repair], printing the report.
+// It exits with status 1 if the replica diverged and wasn't repaired.
+func (s *StorageService) reconcileCommand(args []string) int {
+	flags := flag.NewFlagSet("reconcile-replicas", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "copy, restore or remove objects to bring the replica in sync")
+	flags.Parse(args)
+
+	if s.secondary == nil {
+		slog.Error("No replica is configured, set REPLICA_BACKEND")
+		return 2
+	}
+	report, err := s.reconcileReplicas(context.Background(), *repair)
+	if err != nil {
+		slog.Error("Reconciliation failed", "error", err)
+		return 2
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(report)
+	if len(report.Failed) > 0 || (!*repair && report.diverged()) {
+		return 1
+	}
+	return 0
+}
+
+// ReplicationStatus summarizes replication: objects per status, how long
+// the oldest has waited to be copied and the latest failures
+type ReplicationStatus struct {
+	Enabled       bool             `json:"enabled"`
+	Objects       map[string]int   `json:"objects"`
+	Bytes         int64            `json:"replicated_bytes"`
+	OldestPending *time.Time       `json:"oldest_pending,omitempty"`
+	Failures      []ReplicaFailure `json:"failures"`
+}
+
+// ReplicaFailure is an object whose copy failed
+type ReplicaFailure struct {
+	Key         string    `json:"object_key" db:"object_key"`
+	Attempts    int       `json:"attempts" db:"attempts"`
+	Error       string    `json:"error" db:"last_error"`
+	NextAttempt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+}
+
+// getReplicationStatus reports on replication to the replica (admin)
+func (s *StorageService) getReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	status := ReplicationStatus{Enabled: s.secondary != nil, Objects: map[string]int{}, Failures: []ReplicaFailure{}}
+	if !status.Enabled {
+		utils.JSONResponse(w, http.StatusOK, status)
+		return
+	}
+
+	var counts []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+		Bytes  int64  `db:"bytes"`
+	}
+	if err := s.db.SelectContext(r.Context(), &counts,
+		`SELECT status, COUNT(*) AS count, COALESCE(SUM(size_bytes), 0) AS bytes FROM object_replicas GROUP BY status`); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read replication status")
+		return
+	}
+	for _, c := range counts {
+		status.Objects[c.Status] = c.Count
+		if c.Status == ReplicaReplicated {
+			status.Bytes = c.Bytes
+		}
+	}
+	var oldest sql.NullTime
+	err := s.db.GetContext(r.Context(), &oldest,
+		"SELECT MIN(updated_at) FROM object_replicas WHERE status <> $1", ReplicaReplicated)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read replication status")
+		return
+	}
+	if oldest.Valid {
+		status.OldestPending = &oldest.Time
+	}
+	if err := s.db.SelectContext(r.Context(), &status.Failures,
+		`SELECT object_key, attempts, COALESCE(last_error, '') AS last_error, next_attempt_at FROM object_replicas
+		WHERE status = $1 ORDER BY updated_at DESC LIMIT 50`, ReplicaFailed); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read replication status")
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, status)
+}
@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/config"
+)
+
+// unsignedPayload lets object bodies stream without being hashed first;
+// the connection's TLS protects them instead
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash is the SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config locates a bucket and the credentials to access it
+type S3Config struct {
+	// Endpoint is the server's URL, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://minio:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the names of stored files
+	Prefix string
+	// PathStyle addresses the bucket in the path rather than the host name,
+	// as MinIO and most S3-compatible servers expect
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3Settings locate the S3 bucket: S3_BUCKET, S3_REGION (default
+// us-east-1), S3_ENDPOINT (default AWS for the region), S3_PATH_STYLE
+// (default on with a custom endpoint), S3_PREFIX and S3_COLD_PREFIX, and
+// the credentials, which default to the AWS_* ones
+type S3Settings struct {
+	Endpoint     string `env:"S3_ENDPOINT"`
+	Region       string `env:"S3_REGION"`
+	Bucket       string `env:"S3_BUCKET"`
+	Prefix       string `env:"S3_PREFIX"`
+	ColdPrefix   string `env:"S3_COLD_PREFIX"`
+	PathStyle    string `env:"S3_PATH_STYLE"`
+	AccessKey    string `env:"S3_ACCESS_KEY_ID" secret:"true"`
+	SecretKey    string `env:"S3_SECRET_ACCESS_KEY" secret:"true"`
+	SessionToken string `env:"S3_SESSION_TOKEN" secret:"true"`
+}
+
+// AWSSettings are the standard AWS variables, the default credentials of
+// S3 and KMS
+type AWSSettings struct {
+	Region       string `env:"AWS_REGION"`
+	AccessKey    string `env:"AWS_ACCESS_KEY_ID" secret:"true"`
+	SecretKey    string `env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	SessionToken string `env:"AWS_SESSION_TOKEN" secret:"true"`
+}
+
+func (s AWSSettings) credentials() awsCredentials {
+	return awsCredentials{s.AccessKey, s.SecretKey, s.SessionToken}
+}
+
+// s3Config checks a bucket's settings, named with prefix in errors, such as
+// REPLICA_. Credentials the settings leave out are taken from the first of
+// fallbacks that has them.
+func s3Config(prefix string, settings S3Settings, fallbacks ...awsCredentials) (S3Config, error) {
+	cfg := S3Config{
+		Endpoint:     settings.Endpoint,
+		Region:       settings.Region,
+		Bucket:       settings.Bucket,
+		Prefix:       settings.Prefix,
+		AccessKey:    settings.AccessKey,
+		SecretKey:    settings.SecretKey,
+		SessionToken: settings.SessionToken,
+	}
+	for _, creds := range fallbacks {
+		if cfg.AccessKey != "" {
+			break
+		}
+		cfg.AccessKey, cfg.SecretKey, cfg.SessionToken = creds.AccessKey, creds.SecretKey, creds.SessionToken
+	}
+	if cfg.Bucket == "" {
+		return cfg, fmt.Errorf("%sS3_BUCKET is required", prefix)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return cfg, fmt.Errorf("S3 credentials are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.PathStyle = cfg.Endpoint != ""
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if v := settings.PathStyle; v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %sS3_PATH_STYLE %q", prefix, v)
+		}
+		cfg.PathStyle = pathStyle
+	}
+	return cfg, nil
+}
+
+// S3Storage keeps files as objects in an S3 bucket, on AWS or an
+// S3-compatible server such as MinIO. Requests are signed with AWS
+// Signature Version 4. Objects are written in a single request, so files
+// are limited to 5 GB.
+type S3Storage struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Storage checks the configuration; the bucket must already exist
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	return &S3Storage{cfg: cfg, endpoint: endpoint, client: &http.Client{}}, nil
+}
+
+func (s *S3Storage) key(name string) string {
+	return s.cfg.Prefix + name
+}
+
+func (s *S3Storage) location(name string) string {
+	return "s3://" + s.cfg.Bucket + "/" + s.key(name)
+}
+
+// Put streams the body unhashed; S3 needs its size up front
+func (s *S3Storage) Put(ctx context.Context, name string, r io.Reader, size int64) (FileInfo, error) {
+	if size < 0 {
+		return FileInfo{}, fmt.Errorf("s3: size of %s unknown", name)
+	}
+	res, err := s.do(ctx, http.MethodPut, s.key(name), nil, nil, io.NopCloser(r), size)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res.Body.Close()
+	return FileInfo{Name: name, Size: size, ModTime: time.Now(), Location: s.location(name)}, nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, s.key(name), nil, rangeHeader(offset, length), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Delete succeeds for objects that don't exist, as S3 doesn't tell them
+// apart
+func (s *S3Storage) Delete(ctx context.Context, name string) error {
+	res, err := s.do(ctx, http.MethodDelete, s.key(name), nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// List returns the files directly under the prefix, paging through the
+// bucket listing
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var page struct {
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+		Contents              []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+	}
+
+	files := []FileInfo{}
+	query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}, "delimiter": {"/"}}
+	for {
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		page.Contents = nil
+		page.NextContinuationToken = ""
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid listing: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(obj.Key, s.key(prefix))
+			files = append(files, FileInfo{Name: name, Size: obj.Size, ModTime: obj.LastModified, Location: s.location(prefix + name)})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return files, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3Storage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	res, err := s.do(ctx, http.MethodHead, s.key(name), nil, nil, nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res.Body.Close()
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return FileInfo{Name: name, Size: res.ContentLength, ModTime: modTime, Location: s.location(name)}, nil
+}
+
+// do sends a signed request for an object, or the bucket when key is
+// empty, and turns error responses into BackendErrors
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadCloser, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		backendErr := &BackendError{Backend: BackendS3, StatusCode: res.StatusCode}
+		xml.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(backendErr)
+		// A missing bucket is a configuration error instead
+		backendErr.NotFound = key != "" && res.StatusCode == http.StatusNotFound && backendErr.Code != "NoSuchBucket"
+		return nil, backendErr
+	}
+	return res, nil
+}
+
+// objectURL is the URL of an object, or the bucket when key is empty
+func (s *S3Storage) objectURL(key string) url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	if key != "" || !s.cfg.PathStyle {
+		path += "/" + key
+	}
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	return u
+}
+
+// PresignPut returns a URL that uploads the named object with a PUT until
+// it expires, without credentials. The body isn't signed.
+func (s *S3Storage) PresignPut(name string, expires time.Duration) string {
+	u := s.objectURL(s.key(name))
+	presignV4(http.MethodPut, &u, s.credentials(), s.cfg.Region, "s3", expires, time.Now().UTC())
+	return u.String()
+}
+
+// sign adds the Signature Version 4 Authorization header to req
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, s.credentials(), s.cfg.Region, "s3", payloadHash, now)
+}
+
+// credentials are the bucket's current credentials, which may have been
+// rotated since startup when they were resolved from secret references
+func (s *S3Storage) credentials() awsCredentials {
+	return awsCredentials{s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.SessionToken}.current()
+}
+
+// awsCredentials sign requests to AWS services
+type awsCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// current returns the credentials as rotated since startup; see
+// config.Current
+func (c awsCredentials) current() awsCredentials {
+	return awsCredentials{config.Current(c.AccessKey), config.Current(c.SecretKey), config.Current(c.SessionToken)}
+}
+
+// signV4 adds the Signature Version 4 Authorization header for an AWS
+// service to req, signing the host and X-Amz-* headers
+func signV4(req *http.Request, creds awsCredentials, region, service, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	signature := signatureV4(creds, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature))
+}
+
+// presignV4 adds a Signature Version 4 signature to u's query, valid for
+// expires, so the request can be made without credentials. Only the host
+// header is signed, and the payload isn't.
+func presignV4(method string, u *url.URL, creds awsCredentials, region, service string, expires time.Duration, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", creds.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + signatureV4(creds, amzDate, scope, canonicalRequest)
+}
+
+// signatureV4 signs a canonical request for the credential scope
+func signatureV4(creds awsCredentials, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + creds.SecretKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, as
+// Signature Version 4 requires
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes a query sorted by key, as it is signed
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Uploads are scanned for malware after they are stored, by the scanner set
+// with SCANNER; types whose policy requires a scan are scanned before they
+// are stored instead, and rejected if infected. An infected file is
+// quarantined: it stays stored for investigation but can't be downloaded or
+// used as a clone source until a rescan an admin queues finds it clean, or
+// it's deleted. Files stored without a scanner, and outputs, have no scan
+// status.
+
+// Scan statuses of stored files
+const (
+	ScanPending  = "pending"
+	ScanScanning = "scanning"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanFailed   = "failed"
+)
+
+const (
+	// scanBatchSize bounds how many files a scan sweep claims at once
+	scanBatchSize = 20
+	// maxScanAttempts is how often a file is scanned before it's marked
+	// failed
+	maxScanAttempts = 3
+	// scanLease is how long a claimed scan may take before another instance
+	// retries it
+	scanLease = 15 * time.Minute
+)
+
+// ScanResult is the verdict on a file. Signature names the malware found,
+// if the scanner reports it.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner checks files for malware
+type Scanner interface {
+	Scan(ctx context.Context, path string) (ScanResult, error)
+}
+
+// ClamdScanner streams files to a ClamAV daemon with its INSTREAM command
+type ClamdScanner struct {
+	Network string // tcp or unix
+	Address string
+	Timeout time.Duration
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, path string) (ScanResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// The file is sent in chunks, each prefixed with its length, and ended
+	// with an empty one
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+	chunk := make([]byte, 4+64<<10)
+	for {
+		n, err := f.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return ScanResult{}, fmt.Errorf("clamd: %w", err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ScanResult{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+	reply = strings.TrimPrefix(strings.TrimRight(reply, "\x00\n"), "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// CommandScanner runs a command with the file path appended. Exit status 0
+// is clean and 1 is infected, following clamscan.
+type CommandScanner struct {
+	Command []string
+	Timeout time.Duration
+}
+
+func (c *CommandScanner) Scan(ctx context.Context, path string) (ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	err := exec.CommandContext(ctx, c.Command[0], append(c.Command[1:], path)...).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return ScanResult{Infected: true}, nil
+	}
+	return ScanResult{}, err
+}
+
+// ScanSettings select the scanner. "clamd" connects to the daemon at
+// CLAMD_ADDRESS (tcp://host:port or unix:///path/to/clamd.sock); "command"
+// runs SCAN_COMMAND. Without SCANNER, SCAN_COMMAND is used if set and files
+// aren't scanned otherwise.
+type ScanSettings struct {
+	Scanner      string        `env:"SCANNER"`
+	Command      string        `env:"SCAN_COMMAND"`
+	ClamdAddress string        `env:"CLAMD_ADDRESS" default:"tcp://localhost:3310"`
+	Timeout      time.Duration `env:"SCAN_TIMEOUT_SECONDS" default:"120" min:"1"`
+}
+
+// newScanner returns the configured scanner, or nil if files aren't scanned
+func newScanner(settings ScanSettings) (Scanner, error) {
+	timeout := settings.Timeout
+	command := strings.Fields(settings.Command)
+
+	name := settings.Scanner
+	if name == "" && len(command) > 0 {
+		name = "command"
+	}
+	switch name {
+	case "":
+		return nil, nil
+	case "command":
+		if len(command) == 0 {
+			return nil, errors.New("SCAN_COMMAND is not set")
+		}
+		return &CommandScanner{Command: command, Timeout: timeout}, nil
+	case "clamd":
+		address := settings.ClamdAddress
+		scanner := &ClamdScanner{Network: "tcp", Address: strings.TrimPrefix(address, "tcp://"), Timeout: timeout}
+		if path, ok := strings.CutPrefix(address, "unix://"); ok {
+			scanner.Network, scanner.Address = "unix", path
+		}
+		return scanner, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q", name)
+	}
+}
+
+// scanUpload scans a staged upload whose policy requires a scan before it is
+// stored
+func (s *StorageService) scanUpload(ctx context.Context, policy FilePolicy, path string) error {
+	if s.scanner == nil {
+		return &PolicyViolation{
+			Status:    http.StatusServiceUnavailable,
+			Code:      apierror.FeatureDisabled,
+			Message:   fmt.Sprintf("%s files must be scanned but no scanner is configured", policy.Type),
+			Policy:    policy.Type,
+			Violation: ViolationScan,
+		}
+	}
+
+	result, err := s.scanner.Scan(ctx, path)
+	if err != nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+	if result.Infected {
+		return &PolicyViolation{
+			Status:    http.StatusUnprocessableEntity,
+			Code:      apierror.UploadPolicy,
+			Message:   "file failed the malware scan",
+			Policy:    policy.Type,
+			Violation: ViolationScan,
+		}
+	}
+	return nil
+}
+
+// uploadScanStatus is the scan status a new file is recorded with, and
+// whether it's quarantined: clean if it was scanned before it was stored,
+// pending if it's to be scanned, and infected if it shares content already
+// found infected
+func (s *StorageService) uploadScanStatus(ctx context.Context, upload stagedUpload, key string, deduplicated bool) (*string, bool) {
+	status := ScanPending
+	switch {
+	case upload.policy.ScanRequired:
+		status = ScanClean
+	case s.scanner == nil || upload.class.Name == ClassOutput:
+		return nil, false
+	}
+
+	if deduplicated {
+		var infected bool
+		s.db.GetContext(ctx, &infected,
+			"SELECT EXISTS (SELECT 1 FROM stored_files WHERE object_key = $1 AND quarantined)", key)
+		if infected {
+			status = ScanInfected
+			return &status, true
+		}
+	}
+	return &status, false
+}
+
+// quarantined answers requests for a quarantined file's content, returning
+// true for them
+func quarantined(w http.ResponseWriter, file storedFile) bool {
+	if !file.Quarantined {
+		return false
+	}
+	utils.ErrorResponse(w, http.StatusForbidden, apierror.FileQuarantined, "File is quarantined after failing a malware scan")
+	return true
+}
+
+// runScanner scans queued files until ctx is done
+func (s *StorageService) runScanner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// A full batch means more files are waiting
+		if s.scanQueued(ctx) == scanBatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanQueued claims a batch of pending files, and scans abandoned by another
+// instance, and scans them. It returns how many were claimed.
+func (s *StorageService) scanQueued(ctx context.Context) int {
+	var files []struct {
+		storedFile
+		Attempts int `db:"scan_attempts"`
+	}
+	err := s.db.SelectContext(ctx, &files,
+		`UPDATE stored_files SET scan_status = $1, scan_attempts = scan_attempts + 1, scanned_at = NOW()
+		WHERE id IN (
+			SELECT id FROM stored_files
+			WHERE scan_status = $2 OR (scan_status = $1 AND scanned_at < $3)
+			ORDER BY created_at LIMIT $4 FOR UPDATE SKIP LOCKED)
+		RETURNING `+storedFileColumns+`, scan_attempts`,
+		ScanScanning, ScanPending, time.Now().Add(-scanLease), scanBatchSize)
+	if err != nil {
+		slog.Error("Failed to claim files to scan", "error", err)
+		return 0
+	}
+
+	for _, file := range files {
+		result, err := s.scanStored(ctx, file.storedFile)
+		switch {
+		case err != nil && (file.Attempts >= maxScanAttempts || isNotExist(err)):
+			slog.Error("Scan failed, giving up", "file_id", file.ID, "error", err)
+			s.db.ExecContext(ctx, "UPDATE stored_files SET scan_status = $1 WHERE id = $2", ScanFailed, file.ID)
+		case err != nil:
+			slog.Warn("Scan failed, will retry", "file_id", file.ID, "error", err)
+			s.db.ExecContext(ctx, "UPDATE stored_files SET scan_status = $1 WHERE id = $2", ScanPending, file.ID)
+		case result.Infected:
+			// Every file with the same content is infected too
+			slog.Warn("Quarantined file", "file_id", file.ID, "signature", result.Signature)
+			s.db.ExecContext(ctx,
+				`UPDATE stored_files SET scan_status = $1, quarantined = TRUE, scan_signature = NULLIF($2, ''), scanned_at = NOW()
+				WHERE id = $3 OR object_key = $4`,
+				ScanInfected, result.Signature, file.ID, file.Key)
+		default:
+			s.db.ExecContext(ctx,
+				`UPDATE stored_files SET scan_status = $1, quarantined = FALSE, scan_signature = NULL, scanned_at = NOW()
+				WHERE id = $2`,
+				ScanClean, file.ID)
+		}
+	}
+	return len(files)
+}
+
+// scanStored scans a stored file from the storage of its tier
+func (s *StorageService) scanStored(ctx context.Context, file storedFile) (ScanResult, error) {
+	tier, err := s.locateFile(ctx, file.Key)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	path, cleanup, err := localCopy(ctx, s.tierStorage(tier), file.Key)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer cleanup()
+	return s.scanner.Scan(ctx, path)
+}
+
+// rescanFile queues a file to be scanned again, such as after the scanner's
+// signatures were updated or a scan failed. Files with the same content are
+// queued with it. A quarantined file stays so until it's scanned clean.
+// Admin only.
+func (s *StorageService) rescanFile(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	if s.scanner == nil {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "No scanner is configured")
+		return
+	}
+
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	_, err := s.db.ExecContext(r.Context(),
+		"UPDATE stored_files SET scan_status = $1, scan_attempts = 0 WHERE id = $2 OR object_key = $3",
+		ScanPending, file.ID, file.Key)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to queue scan")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusAccepted, map[string]string{
+		"id":          file.ID,
+		"scan_status": ScanPending,
+	})
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"net/http"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/search"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// fileMatch is a file found by a search, with its relevance
+type fileMatch struct {
+	StoredFileInfo
+	Rank float64 `db:"rank"`
+}
+
+// searchFiles finds the caller's files in the standard tier whose name or
+// folder matches ?q=, most relevant first, up to ?limit=. Files are those
+// listFiles lists.
+func (s *StorageService) searchFiles(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	req, err := search.ParseRequest(r.URL.Query())
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	matches := []fileMatch{}
+	err = dbroute.Reader(r, s.db, s.replica).SelectContext(r.Context(), &matches,
+		`SELECT id, filename, size_bytes, file_type, content_type, checksum_sha256, folder, acl, scan_status, quarantined, version, temporary, created_at, expires_at,
+			ts_rank(search_vector, query) AS rank
+		FROM stored_files, to_tsquery('simple', $3) query
+		WHERE user_id = $1 AND tier = $2 AND search_vector @@ query
+		ORDER BY rank DESC, created_at DESC, id DESC
+		LIMIT $4`,
+		userID, TierStandard, req.TSQuery, req.Limit)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to search files")
+		return
+	}
+
+	results := make([]search.Result, len(matches))
+	for i, m := range matches {
+		results[i] = search.Result{Type: search.TypeFile, ID: m.ID, Name: m.Name, Rank: m.Rank, Item: m.StoredFileInfo}
+	}
+	utils.SuccessResponse(w, results)
+}
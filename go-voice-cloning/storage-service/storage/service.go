@@ -0,0 +1,732 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/analytics"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/cache"
+	"github.com/voice-cloning/shared/cron"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/entitlements"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/idempotency"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/signedurl"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/workerpool"
+)
+
+// StorageService keeps file contents in a Storage backend per tier and their
+// metadata in the database
+type StorageService struct {
+	storage        Storage
+	cold           Storage
+	secondary      Storage // replica of the stored objects, if any
+	db             *sqlx.DB
+	replica        *sqlx.DB
+	eventBus       events.Bus // nil without EVENT_BUS
+	audit          *audit.Log
+	analytics      *analytics.Tracker
+	classes        map[string]QuotaClass
+	entitlements   *entitlements.Client
+	policies       map[string]FilePolicy
+	signer         *signedurl.Keyring
+	presign        presignConfig
+	uploads        uploadSessionConfig
+	maxUploadBytes int64
+	archiveLimit   int64 // files per archive
+	transcoder     Transcoder
+	scanner        Scanner
+	lifecycle      lifecycleConfig
+	waveforms      waveformConfig
+	janitor        *workerpool.Pool // removes the files of a janitor sweep
+}
+
+// newService builds the storage service on a migrated database from its
+// configuration. It returns the service, the wrapper of its encryption keys
+// if files are encrypted at rest, and a function closing its audit log,
+// analytics tracker and event bus.
+func newService(cfg Config, db *sqlx.DB) (*StorageService, KeyWrapper, func()) {
+	storage, cold, err := openStorage(cfg.Backend, cfg.AWS)
+	if err != nil {
+		log.Fatal("Failed to configure storage backend:", err)
+	}
+
+	// Files are encrypted at rest once a master key is configured
+	keys, err := newKeyWrapper(cfg.Encryption, cfg.AWS)
+	if err != nil {
+		log.Fatal("Failed to configure encryption:", err)
+	}
+	if keys != nil {
+		storage, cold = NewEncryptedStorage(storage, keys), NewEncryptedStorage(cold, keys)
+	}
+
+	// Stored objects are copied to a replica once one is configured
+	secondary, err := openReplica(cfg.Replica, cfg.Backend.S3, cfg.AWS)
+	if err != nil {
+		log.Fatal("Failed to configure replica:", err)
+	}
+
+	policies, err := loadFilePolicies(cfg.Uploads.PolicyPath)
+	if err != nil {
+		log.Fatal("Failed to load file policies:", err)
+	}
+
+	uploads, err := newUploadSessionConfig(cfg.Uploads)
+	if err != nil {
+		log.Fatal("Failed to prepare upload session directory:", err)
+	}
+
+	transcoder, err := newTranscoder(cfg.Transcode)
+	if err != nil {
+		log.Fatal("Failed to configure transcoder:", err)
+	}
+
+	scanner, err := newScanner(cfg.Scan)
+	if err != nil {
+		log.Fatal("Failed to configure scanner:", err)
+	}
+
+	lifecycle, err := newLifecycleConfig(cfg.Lifecycle)
+	if err != nil {
+		log.Fatal("Failed to configure file lifecycle:", err)
+	}
+
+	// The event bus announces uploads to the other services
+	eventBus, err := events.BusFromEnv()
+	if err != nil {
+		log.Fatal("Failed to connect to event bus:", err)
+	}
+
+	// Security-relevant operations go to the central audit log
+	auditLog, err := audit.Open("storage-service", cfg.Audit, db, eventBus)
+	if err != nil {
+		log.Fatal("Invalid audit configuration:", err)
+	}
+
+	c, err := cache.New(cfg.Cache)
+	if err != nil {
+		log.Fatal("Invalid cache configuration:", err)
+	}
+
+	service := &StorageService{storage: storage, cold: cold, secondary: secondary, db: db, replica: dbroute.ConnectReplica(db, cfg.DB), eventBus: eventBus, audit: auditLog, analytics: analytics.New("storage-service", cfg.Analytics), classes: quotaClasses(cfg.Quotas), entitlements: entitlements.FromEnv(c), policies: policies, presign: newPresignConfig(cfg.Presign), uploads: uploads, maxUploadBytes: cfg.MaxUploadBytes, archiveLimit: cfg.ArchiveMaxFiles, transcoder: transcoder, scanner: scanner, lifecycle: lifecycle, waveforms: waveformConfig{peaksPerSecond: cfg.Waveform.PeaksPerSecond, maxPeaks: cfg.Waveform.MaxPeaks}}
+
+	return service, keys, func() {
+		service.analytics.Close()
+		auditLog.Close()
+		if eventBus != nil {
+			eventBus.Close()
+		}
+	}
+}
+
+// ReconcileCommand runs the reconcile-replicas command with its arguments,
+// returning its exit status
+func ReconcileCommand(cfg Config, db *sqlx.DB, args []string) int {
+	service, _, closeService := newService(cfg, db)
+	defer closeService()
+	return service.reconcileCommand(args)
+}
+
+// New builds the storage service on a migrated database and starts its
+// background jobs. It returns the service's handler, and a function
+// stopping the jobs and closing its audit log and event bus once it no
+// longer serves.
+func New(cfg Config, db *sqlx.DB) (http.Handler, func()) {
+	service, keys, closeService := newService(cfg, db)
+	ctx, stopJobs := context.WithCancel(context.Background())
+	service.janitor = workerpool.New(workerpool.Config{Name: "file-janitor", Workers: cfg.JanitorConcurrency})
+
+	// Maintenance jobs
+	jobs, err := cron.New("storage-service", cfg.Cron, db, service.audit)
+	if err != nil {
+		log.Fatal("Invalid cron configuration:", err)
+	}
+	if err := jobs.Add("file-janitor", cron.Spec(cfg.JanitorSchedule, cfg.JanitorInterval), service.fileJanitor); err != nil {
+		log.Fatal("Invalid JANITOR_SCHEDULE:", err)
+	}
+	if err := jobs.Add("usage-snapshot", cron.Spec(cfg.UsageSnapshotSchedule, cfg.UsageSnapshotInterval), service.usageSnapshot); err != nil {
+		log.Fatal("Invalid USAGE_SNAPSHOT_SCHEDULE:", err)
+	}
+	jobs.Start()
+
+	go func() {
+		service.migrateNamespaces(ctx)
+		if keys != nil {
+			service.migrateEncryption(ctx)
+		}
+	}()
+	if service.secondary != nil && cfg.ReplicationInterval > 0 {
+		go service.runReplication(ctx, cfg.ReplicationInterval)
+	}
+	if cfg.CloneGCInterval > 0 {
+		go service.runCloneGC(ctx, cfg.CloneGCInterval)
+	}
+	if service.scanner != nil && cfg.ScanInterval > 0 {
+		go service.runScanner(ctx, cfg.ScanInterval)
+	}
+
+	// Signed links are optional until a signing key is configured
+	var signedDownload func(http.Handler) http.Handler
+	if cfg.URLSigningKeys != "" {
+		keys, err := signedurl.KeyringFromEnv()
+		if err != nil {
+			log.Fatal("Invalid URL signing keys:", err)
+		}
+		service.signer = keys
+		signedDownload = signedurl.Middleware(keys, signedurl.ScopeDownload, func(r *http.Request) string {
+			return mux.Vars(r)["id"]
+		}, false)
+	}
+
+	checks := health.New("storage-service")
+	checks.Add("postgres", health.DB(db))
+	if service.replica != db {
+		checks.Add("postgres_replica", health.DB(service.replica))
+	}
+	checks.Add("storage", probeStorage(service.storage))
+	if service.secondary != nil {
+		checks.Add("storage_secondary", probeStorage(service.secondary))
+	}
+
+	// Retries of creating requests carrying an Idempotency-Key are answered
+	// with the first response
+	idempotent := idempotency.New(db, cfg.Idempotency)
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pools(db, service.replica)...)).Methods("GET")
+	r.HandleFunc("/upload", idempotent.Wrap(service.uploadFile)).Methods("POST")
+	r.HandleFunc("/uploads", idempotent.Wrap(service.createUploadSession)).Methods("POST")
+	r.HandleFunc("/uploads/direct", idempotent.Wrap(service.createDirectUpload)).Methods("POST")
+	r.HandleFunc("/uploads/direct/{id}", service.cancelDirectUpload).Methods("DELETE")
+	r.HandleFunc("/uploads/direct/{id}/complete", service.completeDirectUpload).Methods("POST")
+	r.HandleFunc("/uploads/{id}", service.getUploadSession).Methods("GET")
+	r.HandleFunc("/uploads/{id}", service.appendUploadChunk).Methods("PATCH")
+	r.HandleFunc("/uploads/{id}", service.cancelUploadSession).Methods("DELETE")
+	r.HandleFunc("/uploads/{id}/complete", service.completeUploadSession).Methods("POST")
+	download := r.PathPrefix("/download").Subrouter()
+	download.Use(service.logFileAccess(accessDownload))
+	if signedDownload != nil {
+		download.Use(signedDownload)
+	}
+	download.HandleFunc("/{id}", service.downloadFile).Methods("GET")
+	r.Handle("/public/{id}", service.logFileAccess(accessDownload)(http.HandlerFunc(service.downloadPublic))).Methods("GET")
+	r.Handle("/files/archive", service.logFileAccess(accessDownload)(http.HandlerFunc(service.downloadArchive))).Methods("POST")
+	r.HandleFunc("/files/{id}", service.replaceFile).Methods("PUT")
+	r.Handle("/files/{id}", service.logFileAccess(accessDelete)(http.HandlerFunc(service.deleteFile))).Methods("DELETE")
+	r.HandleFunc("/files/{id}/versions", service.listVersions).Methods("GET")
+	r.HandleFunc("/files/{id}/versions/{version}/restore", service.restoreVersion).Methods("POST")
+	r.HandleFunc("/files/{id}/audio", service.getAudioInfo).Methods("GET")
+	r.HandleFunc("/files/{id}/waveform", service.getWaveform).Methods("GET")
+	r.HandleFunc("/files/{id}/tier", service.setTier).Methods("PUT")
+	r.Handle("/files/{id}/presign", service.logFileAccess(accessPresign)(http.HandlerFunc(service.presignFile))).Methods("POST")
+	r.HandleFunc("/files/{id}/folder", service.moveToFolder).Methods("PUT")
+	r.HandleFunc("/files/{id}/acl", service.getFileACL).Methods("GET")
+	r.HandleFunc("/files/{id}/acl", service.setFileACL).Methods("PUT")
+	r.HandleFunc("/files/{id}/shares", service.shareFile).Methods("POST")
+	r.HandleFunc("/files/{id}/shares/{user_id}", service.unshareFile).Methods("DELETE")
+	r.HandleFunc("/folders", service.listFolders).Methods("GET")
+	r.HandleFunc("/folders", service.createFolder).Methods("POST")
+	r.HandleFunc("/folders", service.deleteFolder).Methods("DELETE")
+	r.HandleFunc("/files", service.listFiles).Methods("GET")
+	r.HandleFunc("/search", service.searchFiles).Methods("GET")
+	r.HandleFunc("/usage", service.getUsage).Methods("GET")
+	r.HandleFunc("/admin/quotas/{user_id}", service.setUserQuota).Methods("PUT")
+	r.HandleFunc("/policies", service.listPolicies).Methods("GET")
+	r.HandleFunc("/calendar", service.getCalendar).Methods("GET")
+	r.HandleFunc("/access-log", service.listAccessLog).Methods("GET")
+	r.HandleFunc("/admin/file-access", service.listFileAccess).Methods("GET")
+	r.HandleFunc("/admin/access-events", service.listAccessEvents).Methods("GET")
+	r.HandleFunc("/admin/files/{id}/scan", service.rescanFile).Methods("POST")
+	r.HandleFunc("/admin/lifecycle", service.getLifecycleReport).Methods("GET")
+	r.HandleFunc("/admin/replication", service.getReplicationStatus).Methods("GET")
+	r.HandleFunc("/admin/clone-gc", service.getCloneGCReport).Methods("GET")
+	r.PathPrefix("/admin/jobs").Handler(jobs)
+	r.HandleFunc("/users/{user_id}/files", service.purgeUserFiles).Methods("DELETE")
+
+	return middleware.Chain(middleware.UserLanguage(db)(r)), func() {
+		stopJobs()
+		jobs.Close()
+		service.janitor.Shutdown(context.Background())
+		closeService()
+	}
+}
+
+func (s *StorageService) uploadFile(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.receiveUpload(w, r, middleware.UserID(r), s.uploadClass(r), "")
+	if upload.path != "" {
+		defer os.Remove(upload.path)
+	}
+	if !ok {
+		return
+	}
+	s.storeUpload(w, r, upload)
+}
+
+// receiveUpload stages the file of an upload form, checking it against its
+// type's policy and the user's quota of the class. The type is fileType if
+// set, else the one in the form. Otherwise the request has been answered
+// and false is returned. The caller removes any staged file.
+func (s *StorageService) receiveUpload(w http.ResponseWriter, r *http.Request, userID int, class QuotaClass, fileType string) (stagedUpload, bool) {
+	// Outputs come from the voice worker; user uploads must match a file
+	// type policy
+	checked := class.Name != ClassOutput
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes+1<<20)
+
+	// Stream the form to a staged file so a rejected upload is never
+	// stored. A file whose type is sent first is only read up to that type's
+	// limit.
+	form, err := readUploadForm(r, func(formType string) int64 {
+		if fileType != "" {
+			formType = fileType
+		}
+		if policy, ok := s.policies[formType]; ok && checked && policy.MaxBytes < s.maxUploadBytes {
+			return policy.MaxBytes
+		}
+		return s.maxUploadBytes
+	})
+	upload := stagedUpload{
+		path:        form.path,
+		filename:    form.filename,
+		contentType: form.contentType,
+		size:        form.size,
+		userID:      userID,
+		class:       class,
+	}
+	if err != nil {
+		writeFormError(w, err, s.maxUploadBytes)
+		return upload, false
+	}
+	if form.size > s.maxUploadBytes {
+		uploadTooLarge(w, s.maxUploadBytes)
+		return upload, false
+	}
+	if upload.ttl, err = s.lifecycle.formTTL(form); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return upload, false
+	}
+	if upload.expected, err = parseChecksums(form.checksum, form.contentMD5); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return upload, false
+	}
+	if upload.folder, err = cleanFolder(form.folder); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return upload, false
+	}
+
+	if checked {
+		if fileType == "" {
+			fileType = form.fileType
+		}
+		if fileType == "" {
+			fileType = TypeAudioSample
+		}
+		if upload.policy, err = s.checkUpload(fileType, form); err != nil {
+			writeUploadError(w, err, "Failed to check upload")
+			return upload, false
+		}
+	}
+
+	// Enforce the quota of the class the upload counts against
+	return upload, s.checkQuota(r.Context(), w, userID, class, form.size)
+}
+
+// stagedUpload is a complete upload waiting in a local file to be stored
+type stagedUpload struct {
+	path        string
+	filename    string
+	contentType string // as declared by the client
+	size        int64
+	userID      int
+	class       QuotaClass
+	policy      FilePolicy
+	replaces    string            // the ID of the file given this content, if any
+	ttl         time.Duration     // how long a temporary file is kept, 0 if not temporary
+	expected    expectedChecksums // checksums sent by the client
+	folder      string            // of a new file
+}
+
+// checkQuota checks size more bytes fit in the user's quota of the class.
+// Otherwise the request has been answered and false is returned.
+func (s *StorageService) checkQuota(ctx context.Context, w http.ResponseWriter, userID int, class QuotaClass, size int64) bool {
+	if userID == 0 {
+		return true
+	}
+	usage, err := s.classUsage(s.db, userID, s.planClass(ctx, userID, class))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to check quota")
+		return false
+	}
+	if usage.LimitBytes > 0 && usage.Bytes+size > usage.LimitBytes {
+		body := utils.ErrorBody(w, apierror.QuotaExceeded, fmt.Sprintf("%s storage quota exceeded", class.Name))
+		body["quota"] = types.QuotaStorage
+		body["class"] = class.Name
+		body["required_bytes"] = size
+		body["usage"] = usage
+		utils.JSONResponse(w, http.StatusRequestEntityTooLarge, body)
+		return false
+	}
+	return true
+}
+
+// storeUpload scans a staged upload if its policy requires, stores it under
+// a new ID, or as the next version of the file it replaces, and records its
+// metadata. It reports whether the upload was
+// stored; either way the request has been answered.
+func (s *StorageService) storeUpload(w http.ResponseWriter, r *http.Request, upload stagedUpload) bool {
+	policy := upload.policy
+	probed, err := checkAudio(policy, upload.path)
+	if err != nil {
+		logging.FromContext(r.Context()).Info("Rejected upload", "file", upload.filename, "error", err)
+		writeUploadError(w, err, "Failed to check upload")
+		return false
+	}
+
+	if policy.ScanRequired {
+		if err := s.scanUpload(r.Context(), policy, upload.path); err != nil {
+			logging.FromContext(r.Context()).Warn("Scan rejected upload", "file", upload.filename, "error", err)
+			writeUploadError(w, err, "Failed to scan file")
+			return false
+		}
+	}
+
+	// Voice clones check the format and total length of their samples
+	var durationMS *int64
+	if probed.Format != "" {
+		ms := probed.Duration().Milliseconds()
+		durationMS = &ms
+	}
+
+	contentType, checksum, md5sum, err := describeStaged(upload.path, upload.contentType)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save file")
+		return false
+	}
+	// The content must be what the client sent
+	if mismatch := upload.expected.verify(checksum, md5sum); mismatch != nil {
+		logging.FromContext(r.Context()).Info("Rejected upload", "file", upload.filename, "error", mismatch)
+		writeChecksumMismatch(w, mismatch)
+		return false
+	}
+
+	// The file gets a generated ID, unless this is a new version of one; the
+	// client's filename is only recorded. Its content is kept by checksum and
+	// shared with the owner's identical files.
+	id := upload.replaces
+	if id == "" {
+		id = newFileID()
+	}
+	key, stored, deduplicated, err := s.storeBlob(r.Context(), upload.path, upload.userID, checksum, upload.size)
+	if err != nil {
+		storageError(w, err, upload.filename, "Failed to save file")
+		return false
+	}
+
+	// A sample that can't be normalized is still kept; consumers then fetch
+	// the original
+	var normalizedKey *string
+	var normalizedSize *int64
+	if deduplicated {
+		// Another file of the blob may have been normalized already
+		normalizedKey, normalizedSize = s.blobNormalized(r.Context(), key)
+	}
+	if normalizedKey == nil {
+		if nk, size, err := s.storeNormalized(r.Context(), upload.path, key, probed); err != nil {
+			logging.FromContext(r.Context()).Warn("Failed to normalize upload", "file", upload.filename, "error", err)
+		} else if nk != "" {
+			normalizedKey, normalizedSize = &nk, &size
+		}
+	}
+
+	if probed.Format != "" {
+		if err := s.storeWaveform(r.Context(), upload.path, key, normalizedKey); err != nil {
+			logging.FromContext(r.Context()).Warn("Failed to compute the waveform", "file", upload.filename, "error", err)
+		}
+	}
+
+	class := upload.class
+	var expiresAt *time.Time
+	if class.Retention > 0 {
+		t := time.Now().Add(class.Retention)
+		expiresAt = &t
+	}
+	// A temporary file expires after its TTL, unless its class expires it
+	// sooner
+	if upload.ttl > 0 {
+		if t := time.Now().Add(upload.ttl); expiresAt == nil || t.Before(*expiresAt) {
+			expiresAt = &t
+		}
+	}
+	var owner *int
+	if upload.userID != 0 {
+		owner = &upload.userID
+	}
+	scanStatus, quarantined := s.uploadScanStatus(r.Context(), upload, key, deduplicated)
+	// Content shared with an older file may predate encryption; the
+	// background pass checks it
+	_, encrypted := s.storage.(*EncryptedStorage)
+	encrypted = encrypted && !deduplicated
+	content := fileContent{
+		filename:       recordedName(upload.filename),
+		size:           upload.size,
+		durationMS:     durationMS,
+		key:            key,
+		format:         probed.Format,
+		codec:          probed.Codec,
+		channels:       int(probed.Channels),
+		sampleRate:     int(probed.SampleRate),
+		bitsPerSample:  int(probed.BitsPerSample),
+		normalizedKey:  normalizedKey,
+		normalizedSize: normalizedSize,
+		contentType:    contentType,
+		checksum:       checksum,
+		scanStatus:     scanStatus,
+		quarantined:    quarantined,
+		encrypted:      encrypted,
+		expiresAt:      expiresAt,
+		temporary:      upload.ttl > 0,
+	}
+	version := 1
+	if upload.replaces != "" {
+		version, err = s.replaceContent(r.Context(), id, content)
+	} else {
+		// Only users' files are in folders
+		if upload.userID == 0 {
+			upload.folder = ""
+		}
+		err = ensureFolder(r.Context(), s.db, upload.userID, upload.folder)
+		if err == nil {
+			_, err = s.db.Exec(
+				`INSERT INTO stored_files (id, filename, user_id, class, file_type, size_bytes, duration_ms, created_at, expires_at, object_key,
+					audio_format, audio_codec, channels, sample_rate, bits_per_sample, normalized_key, normalized_size,
+					content_type, checksum_sha256, scan_status, quarantined, encrypted, temporary, folder, org_id)
+				VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NOW(), $8, $9,
+					NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, 0), NULLIF($13, 0), NULLIF($14, 0), $15, $16,
+					$17, $18, $19, $20, $21, $22, $23, NULLIF($24, 0))`,
+				id, content.filename, owner, class.Name, policy.Type, content.size, content.durationMS, content.expiresAt, content.key,
+				content.format, content.codec, content.channels, content.sampleRate, content.bitsPerSample,
+				content.normalizedKey, content.normalizedSize,
+				content.contentType, content.checksum, content.scanStatus, content.quarantined, content.encrypted, content.temporary,
+				upload.folder, orgs.FromRequest(r))
+		}
+	}
+	if err != nil {
+		// Without metadata the file couldn't be found by its ID, and a
+		// replaced file keeps its current content
+		logging.FromContext(r.Context()).Error("Failed to record file metadata", "file", upload.filename, "error", err)
+		s.releaseBlob(context.Background(), s.storage, key)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save file")
+		return false
+	}
+	// Generated output isn't the user's doing, and avatars show up as
+	// profile changes
+	if owner != nil && upload.replaces == "" && class.Name != ClassOutput && policy.Type != TypeAvatar {
+		err := events.RecordActivity(r.Context(), s.db, upload.userID, types.ActivityFileUploaded, id, map[string]interface{}{
+			"filename": upload.filename,
+			"size":     upload.size,
+			"type":     policy.Type,
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to record upload in the activity feed", "file", upload.filename, "error", err)
+		}
+	}
+
+	uploaded := events.FileUploaded{FileID: id, UserID: upload.userID, Filename: upload.filename, Class: class.Name, Type: policy.Type, ContentType: contentType, Size: upload.size, Version: version}
+	if err := events.Publish(r.Context(), s.eventBus, uploaded); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to publish upload event", "file", upload.filename, "error", err)
+	}
+	// Generated output is counted as the syntheses requesting it
+	if class.Name != ClassOutput {
+		s.analytics.Track(analytics.EventUploadCompleted, upload.userID, map[string]interface{}{
+			"file_id":      id,
+			"type":         policy.Type,
+			"content_type": contentType,
+			"size":         upload.size,
+			"replaced":     upload.replaces != "",
+			"deduplicated": deduplicated,
+		})
+	}
+
+	message := "File uploaded successfully"
+	if upload.replaces != "" {
+		message = "File replaced successfully"
+	}
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":              id,
+		"filename":        upload.filename,
+		"size":            upload.size,
+		"path":            stored.Location,
+		"class":           class.Name,
+		"type":            policy.Type,
+		"content_type":    contentType,
+		"checksum_sha256": checksum,
+		"duration_ms":     durationMS,
+		"audio":           audioMetadata(probed),
+		"normalized":      normalizedKey != nil,
+		"scan_status":     scanStatus,
+		"deduplicated":    deduplicated,
+		"expires_at":      expiresAt,
+		"temporary":       upload.ttl > 0,
+		"folder":          upload.folder,
+		"version":         version,
+		"message":         message,
+	})
+	return true
+}
+
+func (s *StorageService) downloadFile(w http.ResponseWriter, r *http.Request) {
+	// Signed links are only honoured once verified, and none are without
+	// signing keys
+	if r.URL.Query().Get(signedurl.ParamSig) != "" && !signedurl.IsSigned(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.InvalidLink, "Invalid or expired link")
+		return
+	}
+
+	file, ok := s.readableFile(w, r)
+	if !ok {
+		return
+	}
+	if file, ok = s.fileVersion(w, r, file); !ok {
+		return
+	}
+	if quarantined(w, file) {
+		return
+	}
+	variant, key, filename, ok := fileVariant(w, r, file)
+	if !ok {
+		return
+	}
+	contentType := downloadContentType(file, variant, filename)
+	disposition, ok := downloadDisposition(w, r, contentType)
+	if !ok {
+		return
+	}
+
+	// Check if file exists
+	info, err := s.storage.Stat(r.Context(), key)
+	if isNotExist(err) {
+		if _, err := s.cold.Stat(r.Context(), key); err == nil {
+			utils.ErrorResponse(w, http.StatusConflict, apierror.FileColdStorage, "File is in cold storage")
+			return
+		}
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to open file")
+		return
+	}
+	if !s.auditAdminAccess(w, r, file, accessDownload) {
+		return
+	}
+
+	// Serve the file with Range and conditional request support, so players
+	// can seek and interrupted downloads resume. The original is identified
+	// by its checksum, which clients can verify it against; files stored
+	// before checksums were recorded, and normalized versions, by their size
+	// and modification time, as each version is stored anew.
+	content := newObjectReader(r.Context(), s.storage, key, info.Size)
+	defer content.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-File-Variant", variant)
+	if file.Version > 0 {
+		w.Header().Set("X-File-Version", strconv.Itoa(file.Version))
+	}
+	if variant != VariantOriginal || !file.Checksum.Valid || !setDigestHeaders(w, file.Checksum.String) {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+	}
+	http.ServeContent(w, r, "", info.ModTime, content)
+}
+
+// downloadContentType is the type a file is served as: that of its audio
+// format for audio samples, so browsers can play them, else the one
+// detected on upload, else the one its name suggests
+func downloadContentType(file storedFile, variant, filename string) string {
+	if variant == VariantNormalized {
+		return audio.ContentType(audio.FormatWAV, "")
+	}
+	if t := audio.ContentType(file.AudioFormat.String, file.AudioCodec.String); t != "" {
+		return t
+	}
+	if file.ContentType.Valid {
+		return file.ContentType.String
+	}
+	if t := mime.TypeByExtension(filepath.Ext(filename)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// downloadDisposition reads the disposition parameter: attachment by
+// default, or inline so browsers play audio in place. Only audio is served
+// inline; other files are always attachments, so uploaded content is never
+// rendered as a page. Otherwise the request has been answered and false is
+// returned.
+func downloadDisposition(w http.ResponseWriter, r *http.Request, contentType string) (string, bool) {
+	switch disposition := r.URL.Query().Get("disposition"); disposition {
+	case "", "attachment":
+		return "attachment", true
+	case "inline":
+		if strings.HasPrefix(contentType, "audio/") {
+			return "inline", true
+		}
+		return "attachment", true
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "disposition must be attachment or inline")
+		return "", false
+	}
+}
+
+func (s *StorageService) deleteFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+
+	// Check if file exists, in either tier
+	tier, err := s.locateFile(r.Context(), file.Key)
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to delete file")
+		return
+	}
+	if !s.auditAdminAccess(w, r, file, accessDelete) {
+		return
+	}
+
+	// Delete file; content shared with other files is kept for them
+	if err := s.removeFile(r.Context(), s.tierStorage(tier), file); err != nil {
+		storageError(w, err, file.ID, "Failed to delete file")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]string{
+		"message": "File deleted successfully",
+	})
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Storage tiers. Cold files are kept, such as the models of archived voice
+// clones, but can't be downloaded until moved back to the standard tier.
+const (
+	TierStandard = "standard"
+	TierCold     = "cold"
+)
+
+// coldStoragePath is where the local backend keeps cold files
+// (COLD_STORAGE_PATH), by default a cold directory in the storage path.
+// Point it at cheaper storage to offload them.
+func coldStoragePath(settings BackendSettings) string {
+	if settings.ColdPath != "" {
+		return settings.ColdPath
+	}
+	return filepath.Join(settings.Path, TierCold)
+}
+
+// coldPrefix is where a bucket or container keeps the cold tier: cold, or
+// by default a cold/ prefix under the standard tier's
+func coldPrefix(cold, prefix string) string {
+	if cold != "" {
+		return cold
+	}
+	return prefix + TierCold + "/"
+}
+
+// tierStorage is where files of the tier are kept
+func (s *StorageService) tierStorage(tier string) Storage {
+	if tier == TierCold {
+		return s.cold
+	}
+	return s.storage
+}
+
+// locateFile finds the tier of the file kept under key
+func (s *StorageService) locateFile(ctx context.Context, key string) (string, error) {
+	for _, tier := range []string{TierStandard, TierCold} {
+		if _, err := s.tierStorage(tier).Stat(ctx, key); err == nil {
+			return tier, nil
+		} else if !isNotExist(err) {
+			return "", err
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// setTier moves a file between the standard and cold tiers (internal)
+func (s *StorageService) setTier(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tier string `json:"tier"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if req.Tier != TierStandard && req.Tier != TierCold {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "tier must be standard or cold")
+		return
+	}
+
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+
+	tier, err := s.locateFile(r.Context(), file.Key)
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to move file")
+		return
+	}
+
+	if tier != req.Tier {
+		if err := moveBetween(r.Context(), s.tierStorage(tier), s.tierStorage(req.Tier), file.Key, file.Key); err != nil {
+			storageError(w, fmt.Errorf("moving to the %s tier: %w", req.Tier, err), file.ID, "Failed to move file")
+			return
+		}
+		if file.NormalizedKey.Valid {
+			key := file.NormalizedKey.String
+			if err := moveBetween(r.Context(), s.tierStorage(tier), s.tierStorage(req.Tier), key, key); err != nil && !isNotExist(err) {
+				logging.FromContext(r.Context()).Error("Failed to move the normalized version", "file_id", file.ID, "tier", req.Tier, "error", err)
+			}
+		}
+		// Files sharing the content move with it
+		s.db.Exec("UPDATE stored_files SET tier = $1 WHERE id = $2 OR object_key = $3", req.Tier, file.ID, file.Key)
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]string{
+		"id":   file.ID,
+		"tier": req.Tier,
+	})
+}
+
+// moveBetween moves the file kept under srcKey in one storage to dstKey in
+// another, or the same one, renaming it when both are on local disk
+func moveBetween(ctx context.Context, src, dst Storage, srcKey, dstKey string) error {
+	localSrc, srcOK := src.(*LocalStorage)
+	localDst, dstOK := dst.(*LocalStorage)
+	if srcOK && dstOK {
+		return moveFile(localSrc.path(srcKey), localDst.path(dstKey))
+	}
+	// Encrypted files are moved as they are stored
+	encSrc, srcOK := src.(*EncryptedStorage)
+	encDst, dstOK := dst.(*EncryptedStorage)
+	if srcOK && dstOK {
+		return moveBetween(ctx, encSrc.inner, encDst.inner, srcKey, dstKey)
+	}
+
+	info, err := src.Stat(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	in, err := src.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := dst.Put(ctx, dstKey, in, info.Size); err != nil {
+		return err
+	}
+	return src.Delete(ctx, srcKey)
+}
+
+// moveFile renames src to dst, copying when they are on different devices
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".move-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
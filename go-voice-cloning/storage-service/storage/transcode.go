@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// The cloning engine trains on mono 22.05 kHz 16-bit PCM WAV. With a
+// transcoder configured, audio samples in any other format are also stored
+// converted to it, next to the original.
+const (
+	normalizedSampleRate    = 22050
+	normalizedChannels      = 1
+	normalizedBitsPerSample = 16
+)
+
+// normalizedSuffix is appended to a file's key to keep its normalized version
+const normalizedSuffix = ".normalized.wav"
+
+// Versions of a file chosen with the variant query parameter of downloads
+// and audio info. Users get the original by default; the voice worker and
+// the voice service's source checks use the preferred one, the normalized
+// version when there is one.
+const (
+	VariantOriginal   = "original"
+	VariantNormalized = "normalized"
+	VariantPreferred  = "preferred"
+)
+
+// Transcoder converts audio to the format the cloning engine expects
+type Transcoder interface {
+	// Transcode writes the audio in src as a mono 22.05 kHz 16-bit PCM WAV
+	// file to dst
+	Transcode(ctx context.Context, src, dst string) error
+}
+
+// FFmpegTranscoder transcodes with the ffmpeg command
+type FFmpegTranscoder struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, src, dst string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Path, "-nostdin", "-v", "error", "-y", "-i", src,
+		"-ac", fmt.Sprint(normalizedChannels), "-ar", fmt.Sprint(normalizedSampleRate),
+		"-c:a", "pcm_s16le", "-f", "wav", dst)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// TranscodeSettings select the transcoder, which is off by default.
+// "ffmpeg" runs FFMPEG_PATH for at most TRANSCODE_TIMEOUT_SECONDS per file.
+type TranscodeSettings struct {
+	Transcoder string        `env:"TRANSCODER"`
+	FFmpegPath string        `env:"FFMPEG_PATH" default:"ffmpeg"`
+	Timeout    time.Duration `env:"TRANSCODE_TIMEOUT_SECONDS" default:"300" min:"1"`
+}
+
+// newTranscoder returns the configured transcoder, or nil if uploads aren't
+// transcoded
+func newTranscoder(settings TranscodeSettings) (Transcoder, error) {
+	switch name := settings.Transcoder; name {
+	case "":
+		return nil, nil
+	case "ffmpeg":
+		path, err := exec.LookPath(settings.FFmpegPath)
+		if err != nil {
+			return nil, err
+		}
+		return &FFmpegTranscoder{
+			Path:    path,
+			Timeout: settings.Timeout,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transcoder %q", name)
+	}
+}
+
+// isNormalized reports whether audio is already in the engine's format
+func isNormalized(info audio.Info) bool {
+	return info.Format == audio.FormatWAV && info.AudioFormat == 1 &&
+		info.Channels == normalizedChannels && info.SampleRate == normalizedSampleRate &&
+		info.BitsPerSample == normalizedBitsPerSample
+}
+
+// storeNormalized transcodes a staged audio sample and stores the result
+// next to the original kept under key. It returns the normalized version's
+// key and size, or an empty key when the sample needs no normalized version.
+func (s *StorageService) storeNormalized(ctx context.Context, path, key string, probed audio.Info) (string, int64, error) {
+	if s.transcoder == nil || probed.Format == "" || isNormalized(probed) {
+		return "", 0, nil
+	}
+
+	dir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "normalized.wav")
+	if err := s.transcoder.Transcode(ctx, path, dst); err != nil {
+		return "", 0, err
+	}
+
+	normalized, err := os.Open(dst)
+	if err != nil {
+		return "", 0, err
+	}
+	defer normalized.Close()
+	stat, err := normalized.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	normalizedKey := key + normalizedSuffix
+	if _, err := s.storage.Put(ctx, normalizedKey, normalized, stat.Size()); err != nil {
+		return "", 0, err
+	}
+	return normalizedKey, stat.Size(), nil
+}
+
+// fileVariant resolves the version of a file the request's variant
+// parameter asks for to its key and the name it is downloaded with.
+// Otherwise the request has been answered and false is returned.
+func fileVariant(w http.ResponseWriter, r *http.Request, file storedFile) (variant, key, filename string, ok bool) {
+	switch variant := r.URL.Query().Get("variant"); variant {
+	case "", VariantOriginal:
+		return VariantOriginal, file.Key, file.Filename, true
+	case VariantNormalized, VariantPreferred:
+		if file.NormalizedKey.Valid {
+			name := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)) + ".wav"
+			return VariantNormalized, file.NormalizedKey.String, name, true
+		}
+		if variant == VariantPreferred {
+			return VariantOriginal, file.Key, file.Filename, true
+		}
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "File has no normalized version")
+		return "", "", "", false
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "variant must be original, normalized or preferred")
+		return "", "", "", false
+	}
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Resumable uploads send a large file in chunks over several requests, so a
+// dropped connection only loses the chunk in flight. A session is created
+// with the file's size, chunks are appended at the offset the session has
+// reached, and completing the session stores the file as a regular upload.
+
+// UploadOffsetHeader carries the offset a chunk starts at, and the offset a
+// session has reached in responses
+const UploadOffsetHeader = "Upload-Offset"
+
+// uploadSessionConfig is where sessions keep their chunks and how long an
+// idle session is kept
+type uploadSessionConfig struct {
+	dir          string
+	ttl          time.Duration
+	directURLTTL time.Duration // of a direct upload's URL
+}
+
+// UploadSettings configure resumable and direct uploads. Sessions keep
+// their chunks in UPLOAD_SESSION_DIR, by default a directory in the
+// system's temporary directory; when running several instances it must be
+// shared. FILE_POLICY_PATH overrides the file type policies.
+type UploadSettings struct {
+	SessionDir   string        `env:"UPLOAD_SESSION_DIR"`
+	SessionTTL   time.Duration `env:"UPLOAD_SESSION_TTL_HOURS" default:"24" unit:"h" min:"1"`
+	DirectURLTTL time.Duration `env:"DIRECT_UPLOAD_URL_TTL_SECONDS" default:"3600" min:"1"`
+	PolicyPath   string        `env:"FILE_POLICY_PATH"`
+}
+
+// newUploadSessionConfig creates the session directory
+func newUploadSessionConfig(settings UploadSettings) (uploadSessionConfig, error) {
+	cfg := uploadSessionConfig{dir: settings.SessionDir, ttl: settings.SessionTTL, directURLTTL: settings.DirectURLTTL}
+	if cfg.dir == "" {
+		cfg.dir = filepath.Join(os.TempDir(), "upload-sessions")
+	}
+	return cfg, os.MkdirAll(cfg.dir, 0o700)
+}
+
+// uploadSession is an unfinished resumable upload. The bytes received so far
+// are kept in a local file, whose size is the session's offset.
+type uploadSession struct {
+	ID          string         `db:"id" json:"id"`
+	UserID      sql.NullInt64  `db:"user_id" json:"-"`
+	Filename    string         `db:"filename" json:"filename"`
+	FileType    sql.NullString `db:"file_type" json:"-"`
+	ContentType sql.NullString `db:"content_type" json:"-"`
+	Class       string         `db:"class" json:"class"`
+	Size        int64          `db:"size_bytes" json:"size"`
+	Offset      int64          `db:"-" json:"offset"`
+	TTL         sql.NullInt64  `db:"ttl_seconds" json:"-"` // of a temporary file
+	Checksum    sql.NullString `db:"checksum_sha256" json:"-"`
+	ContentMD5  sql.NullString `db:"content_md5" json:"-"`
+	Folder      string         `db:"folder" json:"folder"`
+	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
+	ExpiresAt   time.Time      `db:"expires_at" json:"expires_at"`
+}
+
+// uploadLocks keeps two requests from appending to a session at once
+var uploadLocks sync.Map
+
+// sessionPath is where a session's chunks are kept
+func (s *StorageService) sessionPath(id string) string {
+	return filepath.Join(s.uploads.dir, id)
+}
+
+// createUploadSession starts a resumable upload. The file's type and size
+// are checked now, so a client doesn't send a file that can't be stored; its
+// content is checked once complete.
+func (s *StorageService) createUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.newUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := os.OpenFile(s.sessionPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to create upload session file", "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create upload session")
+		return
+	}
+	f.Close()
+
+	_, err = s.db.NamedExec(
+		`INSERT INTO upload_sessions (id, user_id, filename, file_type, content_type, class, size_bytes, ttl_seconds,
+			checksum_sha256, content_md5, folder, created_at, expires_at)
+		VALUES (:id, :user_id, :filename, :file_type, :content_type, :class, :size_bytes, :ttl_seconds,
+			:checksum_sha256, :content_md5, :folder, :created_at, :expires_at)`,
+		session)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record upload session", "error", err)
+		os.Remove(s.sessionPath(session.ID))
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create upload session")
+		return
+	}
+
+	w.Header().Set(UploadOffsetHeader, "0")
+	utils.JSONResponse(w, http.StatusCreated, session)
+}
+
+// newUploadSession reads the file a client is about to upload from the
+// request, checking its type's size limit and the caller's quota.
+// Otherwise the request has been answered and false is returned.
+func (s *StorageService) newUploadSession(w http.ResponseWriter, r *http.Request) (uploadSession, bool) {
+	var req struct {
+		Filename    string `json:"filename"`
+		Size        int64  `json:"size"`
+		Type        string `json:"type"`
+		ContentType string `json:"content_type"`
+		Temporary   bool   `json:"temporary"`
+		TTL         int64  `json:"ttl"`
+		Checksum    string `json:"checksum_sha256"`
+		ContentMD5  string `json:"content_md5"`
+		Folder      string `json:"folder"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return uploadSession{}, false
+	}
+	if req.Filename == "" || req.Size <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "filename and a positive size are required")
+		return uploadSession{}, false
+	}
+	if req.Size > s.maxUploadBytes {
+		uploadTooLarge(w, s.maxUploadBytes)
+		return uploadSession{}, false
+	}
+
+	ttl, err := s.lifecycle.ttl(req.Temporary, req.TTL)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return uploadSession{}, false
+	}
+	expected, err := parseChecksums(req.Checksum, req.ContentMD5)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return uploadSession{}, false
+	}
+	folder, err := cleanFolder(req.Folder)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return uploadSession{}, false
+	}
+
+	class := s.uploadClass(r)
+	if class.Name != ClassOutput {
+		if req.Type == "" {
+			req.Type = TypeAudioSample
+		}
+		if _, err := s.uploadPolicy(req.Type, req.Size); err != nil {
+			writeUploadError(w, err, "Failed to check upload")
+			return uploadSession{}, false
+		}
+	} else {
+		req.Type = ""
+	}
+
+	userID := middleware.UserID(r)
+	if !s.checkQuota(r.Context(), w, userID, class, req.Size) {
+		return uploadSession{}, false
+	}
+
+	session := uploadSession{
+		ID:          newFileID(),
+		UserID:      sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		Filename:    recordedName(req.Filename),
+		FileType:    sql.NullString{String: req.Type, Valid: req.Type != ""},
+		ContentType: sql.NullString{String: req.ContentType, Valid: req.ContentType != ""},
+		Class:       class.Name,
+		Size:        req.Size,
+		TTL:         sql.NullInt64{Int64: int64(ttl / time.Second), Valid: ttl > 0},
+		Checksum:    sql.NullString{String: expected.sha256, Valid: expected.sha256 != ""},
+		ContentMD5:  sql.NullString{String: expected.md5, Valid: expected.md5 != ""},
+		Folder:      folder,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	session.ExpiresAt = session.CreatedAt.Add(s.uploads.ttl)
+	return session, true
+}
+
+// requestedSession resolves the session of the request's {id} and its
+// offset. Sessions are only visible to the user who created them, or to
+// internal callers. Otherwise the request has been answered and false is
+// returned.
+func (s *StorageService) requestedSession(w http.ResponseWriter, r *http.Request) (uploadSession, bool) {
+	var session uploadSession
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Upload session not found")
+		return session, false
+	}
+	err := s.db.GetContext(r.Context(), &session,
+		`SELECT id, user_id, filename, file_type, content_type, class, size_bytes, ttl_seconds, checksum_sha256, content_md5, folder,
+			created_at, expires_at
+		FROM upload_sessions WHERE id = $1 AND expires_at > NOW()`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Upload session not found")
+		return session, false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read upload session")
+		return session, false
+	}
+	if userID := middleware.UserID(r); userID != 0 && int64(userID) != session.UserID.Int64 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UploadNotFound, "Upload session not found")
+		return session, false
+	}
+
+	info, err := os.Stat(s.sessionPath(session.ID))
+	if err != nil {
+		// The chunks were lost, so the upload has to start over
+		logging.FromContext(r.Context()).Error("Upload session has no data", "session_id", session.ID, "error", err)
+		utils.ErrorResponse(w, http.StatusGone, apierror.Gone, "Upload session data was lost")
+		return session, false
+	}
+	session.Offset = info.Size()
+	return session, true
+}
+
+// lockSession takes the session's append lock, answering 409 when another
+// request holds it. The returned func releases the lock.
+func lockSession(w http.ResponseWriter, id string) (func(), bool) {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.Conflict, "Upload session is in use")
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// getUploadSession reports how much of an upload has been received, so a
+// client can resume after a dropped connection or show its progress. Bytes
+// of a chunk still being received count as they arrive.
+func (s *StorageService) getUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requestedSession(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	utils.SuccessResponse(w, session.progress())
+}
+
+// appendUploadChunk appends the request body to a session. The chunk must
+// start at the session's offset, given in the Upload-Offset header. Bytes
+// received before a connection drops are kept, so the client resumes from
+// the offset the session reports.
+func (s *StorageService) appendUploadChunk(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requestedSession(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidHeader, "A valid Upload-Offset header is required")
+		return
+	}
+
+	unlock, ok := lockSession(w, session.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// The offset may have moved while waiting for the lock
+	info, err := os.Stat(s.sessionPath(session.ID))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusGone, apierror.Gone, "Upload session data was lost")
+		return
+	}
+	session.Offset = info.Size()
+	if offset != session.Offset {
+		w.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		body := utils.ErrorBody(w, apierror.UploadOffset, "Upload-Offset does not match the session's offset")
+		body["offset"] = session.Offset
+		utils.JSONResponse(w, http.StatusConflict, body)
+		return
+	}
+	remaining := session.Size - session.Offset
+	if r.ContentLength > remaining {
+		utils.ErrorResponse(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, "Chunk exceeds the upload's size")
+		return
+	}
+
+	f, err := os.OpenFile(s.sessionPath(session.ID), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to save chunk")
+		return
+	}
+	body := &progressReader{r: r.Body, s: s, session: session, last: time.Now()}
+	n, copyErr := io.Copy(f, io.LimitReader(body, remaining))
+	// A body longer than declared is rejected whole
+	if copyErr == nil && n == remaining {
+		if extra, _ := r.Body.Read(make([]byte, 1)); extra > 0 {
+			f.Truncate(session.Offset)
+			f.Close()
+			utils.ErrorResponse(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, "Chunk exceeds the upload's size")
+			return
+		}
+	}
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	session.Offset += n
+
+	expiresAt := time.Now().Add(s.uploads.ttl)
+	s.db.Exec("UPDATE upload_sessions SET expires_at = $1 WHERE id = $2", expiresAt, session.ID)
+
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	if n > 0 {
+		s.notifyUpload(session, types.UploadEventProgress)
+	}
+	if copyErr != nil {
+		// The client is usually gone; if not, it resumes from the offset
+		logging.FromContext(r.Context()).Warn("Upload chunk ended early", "session_id", session.ID, "offset", session.Offset, "error", copyErr)
+		body := utils.ErrorBody(w, apierror.UploadIncomplete, "Chunk was not fully received")
+		body["offset"] = session.Offset
+		utils.JSONResponse(w, http.StatusBadRequest, body)
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"id":         session.ID,
+		"offset":     session.Offset,
+		"size":       session.Size,
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// completeUploadSession stores a fully received upload as a file, as an
+// upload in one request would have been, and ends the session
+func (s *StorageService) completeUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requestedSession(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(w, session.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if session.Offset != session.Size {
+		w.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		body := utils.ErrorBody(w, apierror.UploadIncomplete, "Upload is incomplete")
+		body["offset"] = session.Offset
+		body["size"] = session.Size
+		utils.JSONResponse(w, http.StatusConflict, body)
+		return
+	}
+
+	if !s.storeSession(w, r, session, s.sessionPath(session.ID)) {
+		return
+	}
+	s.endUploadSession(session.ID)
+	s.notifyUpload(session, types.UploadEventCompleted)
+}
+
+// storeSession checks the complete upload of a session, staged at path,
+// against its type's policy and the user's quota, and stores it. It reports
+// whether the upload was stored; either way the request has been answered.
+func (s *StorageService) storeSession(w http.ResponseWriter, r *http.Request, session uploadSession, path string) bool {
+	class, ok := s.classes[session.Class]
+	if !ok {
+		class = s.classes[ClassSample]
+	}
+	var policy FilePolicy
+	if session.FileType.Valid {
+		staged, err := os.Open(path)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to check upload")
+			return false
+		}
+		policy, err = s.uploadPolicy(session.FileType.String, session.Size)
+		if err == nil {
+			err = checkContent(policy, staged, session.ContentType.String)
+		}
+		staged.Close()
+		if err != nil {
+			writeUploadError(w, err, "Failed to check upload")
+			return false
+		}
+	}
+
+	// Other uploads may have used the quota since the session started
+	userID := int(session.UserID.Int64)
+	if !s.checkQuota(r.Context(), w, userID, class, session.Size) {
+		return false
+	}
+
+	return s.storeUpload(w, r, stagedUpload{
+		path:        path,
+		filename:    session.Filename,
+		contentType: session.ContentType.String,
+		size:        session.Size,
+		userID:      userID,
+		class:       class,
+		policy:      policy,
+		ttl:         time.Duration(session.TTL.Int64) * time.Second,
+		expected:    expectedChecksums{sha256: session.Checksum.String, md5: session.ContentMD5.String},
+		folder:      session.Folder,
+	})
+}
+
+// cancelUploadSession discards an unfinished upload
+func (s *StorageService) cancelUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.requestedSession(w, r)
+	if !ok {
+		return
+	}
+	unlock, ok := lockSession(w, session.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	s.endUploadSession(session.ID)
+	utils.JSONResponse(w, http.StatusOK, map[string]string{
+		"message": "Upload session cancelled",
+	})
+}
+
+// endUploadSession removes a session and its data
+func (s *StorageService) endUploadSession(id string) {
+	if _, err := s.db.Exec("DELETE FROM upload_sessions WHERE id = $1", id); err != nil {
+		slog.Error("Failed to remove upload session", "session_id", id, "error", err)
+	}
+	if err := os.Remove(s.sessionPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to remove the data of an upload session", "session_id", id, "error", err)
+	}
+	uploadLocks.Delete(id)
+}
+
+// sweepUploadSessions removes sessions left idle past their expiry
+func (s *StorageService) sweepUploadSessions(ctx context.Context) {
+	var expired []string
+	err := s.db.SelectContext(ctx, &expired,
+		"SELECT id FROM upload_sessions WHERE expires_at < NOW() ORDER BY expires_at LIMIT $1",
+		janitorBatchSize)
+	if err != nil {
+		slog.Error("Janitor failed to list expired upload sessions", "error", err)
+		return
+	}
+	group := s.janitor.Group()
+	for _, id := range expired {
+		id := id
+		if group.Submit(ctx, func(context.Context) { s.endUploadSession(id) }) != nil {
+			break
+		}
+	}
+	group.Wait()
+	if len(expired) > 0 {
+		slog.Info("Janitor removed expired upload sessions", "count", len(expired))
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/voice-cloning/shared/types"
+)
+
+// usageSnapshot meters storage: it records the bytes each user stores,
+// earlier versions of files included. It's the usage-snapshot job; the
+// scheduler runs each slot once across replicas, and snapshots are keyed by
+// the minute they're taken in, so a retried one isn't counted twice.
+func (s *StorageService) usageSnapshot(ctx context.Context) error {
+	return s.snapshotUsage(ctx, time.Now().UTC().Truncate(time.Minute))
+}
+
+func (s *StorageService) snapshotUsage(ctx context.Context, at time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO usage_events (user_id, metric, quantity, source, occurred_at)
+		SELECT user_id, $1, SUM(bytes), $2, $3 FROM (
+			SELECT user_id, size_bytes AS bytes FROM stored_files
+			UNION ALL
+			SELECT f.user_id, v.size_bytes FROM file_versions v JOIN stored_files f ON f.id = v.file_id
+		) stored
+		GROUP BY user_id
+		ON CONFLICT (user_id, metric, source) DO NOTHING`,
+		types.UsageStorageBytes, "storage:"+at.Format(time.RFC3339), at)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		slog.Info("Recorded storage usage", "users", n)
+	}
+	return nil
+}
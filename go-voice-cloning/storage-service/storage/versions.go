@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Replacing a file's content keeps its ID and numbers the new content as its
+// next version. Earlier versions are kept, holding a reference to their
+// blob, until the file is deleted, so clones can pin the version they were
+// trained on. Restoring a version makes a copy of it the latest.
+
+// versionedColumns are the columns of a file's content, kept for each
+// version. The object key is kept too, resolved for files stored before
+// namespacing.
+const versionedColumns = `filename, size_bytes, duration_ms, audio_format, audio_codec, channels, sample_rate,
+	bits_per_sample, normalized_key, normalized_size, content_type, checksum_sha256, scan_status, quarantined, encrypted`
+
+// FileVersion describes a version of a file
+type FileVersion struct {
+	Version     int       `json:"version" db:"version"`
+	Filename    string    `json:"filename" db:"filename"`
+	Size        int64     `json:"size_bytes" db:"size_bytes"`
+	ContentType *string   `json:"content_type" db:"content_type"`
+	Checksum    *string   `json:"checksum_sha256" db:"checksum_sha256"`
+	ScanStatus  *string   `json:"scan_status" db:"scan_status"`
+	Quarantined bool      `json:"quarantined" db:"quarantined"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	Current     bool      `json:"current" db:"current"`
+}
+
+// fileContent is what the new content of a file is recorded with
+type fileContent struct {
+	filename       string
+	size           int64
+	durationMS     *int64
+	key            string
+	format         string
+	codec          string
+	channels       int
+	sampleRate     int
+	bitsPerSample  int
+	normalizedKey  *string
+	normalizedSize *int64
+	contentType    string
+	checksum       string
+	scanStatus     *string
+	quarantined    bool
+	encrypted      bool
+	expiresAt      *time.Time
+	temporary      bool
+}
+
+// archiveVersion keeps the current content of a file as one of its earlier
+// versions, and returns the file's version
+func archiveVersion(ctx context.Context, tx *sqlx.Tx, id string) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO file_versions (file_id, version, object_key, created_at, `+versionedColumns+`)
+		SELECT id, version, COALESCE(object_key, id), COALESCE(updated_at, created_at), `+versionedColumns+`
+		FROM stored_files WHERE id = $1
+		RETURNING version`, id).Scan(&version)
+	return version, err
+}
+
+// replaceContent records new content of a file as its next version,
+// returning the version. Its retention starts again.
+func (s *StorageService) replaceContent(ctx context.Context, id string, content fileContent) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM stored_files WHERE id = $1 FOR UPDATE", id); err != nil {
+		return 0, err
+	}
+	if _, err := archiveVersion(ctx, tx, id); err != nil {
+		return 0, err
+	}
+	var version int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE stored_files SET filename = $2, size_bytes = $3, duration_ms = $4, object_key = $5,
+			audio_format = NULLIF($6, ''), audio_codec = NULLIF($7, ''), channels = NULLIF($8, 0),
+			sample_rate = NULLIF($9, 0), bits_per_sample = NULLIF($10, 0), normalized_key = $11, normalized_size = $12,
+			content_type = $13, checksum_sha256 = $14, scan_status = $15, quarantined = $16, encrypted = $17,
+			scan_signature = NULL, scan_attempts = 0, scanned_at = NULL, tier = $18, expires_at = $19,
+			temporary = $20, reconciled_at = NULL, version = version + 1, updated_at = NOW()
+		WHERE id = $1
+		RETURNING version`,
+		id, content.filename, content.size, content.durationMS, content.key,
+		content.format, content.codec, content.channels, content.sampleRate, content.bitsPerSample,
+		content.normalizedKey, content.normalizedSize, content.contentType, content.checksum,
+		content.scanStatus, content.quarantined, content.encrypted, TierStandard, content.expiresAt, content.temporary).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// replaceFile stores new content for a file, keeping the current content as
+// its previous version. Only the owner, or an internal caller, can replace
+// a file. The upload is checked like the file's first one.
+func (s *StorageService) replaceFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	if !canModify(w, r, file) {
+		return
+	}
+
+	var meta struct {
+		Class    string         `db:"class"`
+		FileType sql.NullString `db:"file_type"`
+		Folder   string         `db:"folder"`
+	}
+	err := s.db.GetContext(r.Context(), &meta, "SELECT class, file_type, folder FROM stored_files WHERE id = $1", file.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return
+	}
+
+	class, ok := s.classes[meta.Class]
+	if !ok {
+		class = s.classes[ClassSample]
+	}
+	upload, ok := s.receiveUpload(w, r, file.owner(), class, meta.FileType.String)
+	if upload.path != "" {
+		defer os.Remove(upload.path)
+	}
+	if !ok {
+		return
+	}
+	upload.replaces = file.ID
+	upload.folder = meta.Folder
+	s.storeUpload(w, r, upload)
+}
+
+// listVersions lists a file's versions, newest first
+func (s *StorageService) listVersions(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+
+	versions := []FileVersion{}
+	err := s.db.SelectContext(r.Context(), &versions,
+		`SELECT version, filename, size_bytes, content_type, checksum_sha256, scan_status, quarantined,
+			COALESCE(updated_at, created_at) AS created_at, TRUE AS current
+		FROM stored_files WHERE id = $1
+		UNION ALL
+		SELECT version, filename, size_bytes, content_type, checksum_sha256, scan_status, quarantined,
+			created_at, FALSE AS current
+		FROM file_versions WHERE file_id = $1
+		ORDER BY version DESC`, file.ID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to list versions")
+		return
+	}
+	if len(versions) == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.FileNotFound, "File not found")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":       file.ID,
+		"version":  versions[0].Version,
+		"versions": versions,
+	})
+}
+
+// restoreVersion makes a copy of an earlier version of a file its latest
+// version. The current content is kept as a version too.
+func (s *StorageService) restoreVersion(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.requestedFile(w, r)
+	if !ok {
+		return
+	}
+	if !canModify(w, r, file) {
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VersionNotFound, "Version not found")
+		return
+	}
+
+	var restored struct {
+		Key      string         `db:"object_key"`
+		Size     int64          `db:"size_bytes"`
+		Checksum sql.NullString `db:"checksum_sha256"`
+		Class    string         `db:"class"`
+	}
+	err = s.db.GetContext(r.Context(), &restored,
+		`SELECT v.object_key, v.size_bytes, v.checksum_sha256, f.class
+		FROM file_versions v JOIN stored_files f ON f.id = v.file_id
+		WHERE v.file_id = $1 AND v.version = $2`, file.ID, number)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VersionNotFound, "Version not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return
+	}
+	tier, err := s.locateFile(r.Context(), restored.Key)
+	if err != nil {
+		storageError(w, err, file.ID, "Failed to restore version")
+		return
+	}
+	class, ok := s.classes[restored.Class]
+	if !ok {
+		class = s.classes[ClassSample]
+	}
+	if !s.checkQuota(r.Context(), w, file.owner(), class, restored.Size) {
+		return
+	}
+
+	version, err := s.restoreContent(r.Context(), file.ID, number, restored.Key, restored.Checksum.String, restored.Size, tier)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to restore version", "version", number, "file_id", file.ID, "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to restore version")
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":            file.ID,
+		"version":       version,
+		"restored_from": number,
+		"tier":          tier,
+		"message":       "Version restored successfully",
+	})
+}
+
+// restoreContent records a copy of version number as the file's next
+// version, taking another reference to its blob
+func (s *StorageService) restoreContent(ctx context.Context, id string, number int, key, checksum string, size int64, tier string) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM stored_files WHERE id = $1 FOR UPDATE", id); err != nil {
+		return 0, err
+	}
+	// Content stored before deduplication has no blob row yet; the version
+	// holds the first reference
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO stored_blobs (object_key, checksum_sha256, size_bytes, ref_count) VALUES ($1, $2, $3, 2)
+		ON CONFLICT (object_key) DO UPDATE SET ref_count = stored_blobs.ref_count + 1`,
+		key, checksum, size)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := archiveVersion(ctx, tx, id); err != nil {
+		return 0, err
+	}
+	var version int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE stored_files SET (`+versionedColumns+`) = (SELECT `+versionedColumns+`
+			FROM file_versions WHERE file_id = $1 AND version = $2),
+			object_key = $3, tier = $4, scan_signature = NULL, scan_attempts = 0, scanned_at = NULL,
+			version = version + 1, updated_at = NOW()
+		WHERE id = $1
+		RETURNING version`,
+		id, number, key, tier).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// fileVersion resolves the version of a file requested with the version
+// query parameter, by default the current one. Otherwise the request has
+// been answered and false is returned.
+func (s *StorageService) fileVersion(w http.ResponseWriter, r *http.Request, file storedFile) (storedFile, bool) {
+	v := r.URL.Query().Get("version")
+	if v == "" {
+		return file, true
+	}
+	number, err := strconv.Atoi(v)
+	if err != nil || number < 1 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "version must be a positive integer")
+		return file, false
+	}
+	if number == file.Version {
+		return file, true
+	}
+
+	err = s.db.GetContext(r.Context(), &file,
+		`SELECT file_id AS id, filename, object_key, normalized_key, content_type, checksum_sha256,
+			audio_format, audio_codec, quarantined, version
+		FROM file_versions WHERE file_id = $1 AND version = $2`, file.ID, number)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VersionNotFound, "Version not found")
+		return file, false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return file, false
+	}
+	file.Archived = true
+	return file, true
+}
+
+// releaseVersions drops the references of a file's earlier versions to their
+// blobs and forgets the versions
+func (s *StorageService) releaseVersions(ctx context.Context, id string) error {
+	var keys []string
+	if err := s.db.SelectContext(ctx, &keys,
+		"SELECT object_key FROM file_versions WHERE file_id = $1", id); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		tier, err := s.locateFile(ctx, key)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+		if err := s.releaseBlob(ctx, s.tierStorage(tier), key); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM file_versions WHERE file_id = $1", id)
+	return err
+}
+
+// canModify checks the caller may change a file's content: its owner or an
+// internal call. Admins can read and delete users' files but not rewrite
+// them. Otherwise the request has been answered and false is returned.
+func canModify(w http.ResponseWriter, r *http.Request, file storedFile) bool {
+	if userID := middleware.UserID(r); userID != 0 && userID != file.owner() {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.FileOwnerRequired, "Only the file's owner can change it")
+		return false
+	}
+	return true
+}
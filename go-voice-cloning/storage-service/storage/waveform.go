@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// The waveform of audio samples is computed when they are uploaded, so
+// clients can draw it, to pick the parts to train on, without downloading
+// the file. It's kept by the content's key, shared by the files and
+// versions with that content, and deleted with it.
+
+// waveformConfig is the resolution waveforms are computed at
+type waveformConfig struct {
+	peaksPerSecond int
+	maxPeaks       int
+}
+
+// WaveformSettings are the resolution waveforms are computed at
+type WaveformSettings struct {
+	PeaksPerSecond int `env:"WAVEFORM_PEAKS_PER_SECOND" default:"100" min:"1"`
+	MaxPeaks       int `env:"WAVEFORM_MAX_PEAKS" default:"10000" min:"0"`
+}
+
+// storeWaveform computes and records the waveform of a staged audio sample
+// stored under key, unless the content has one already. Audio that isn't
+// PCM WAV is read from its normalized version, if it has one.
+func (s *StorageService) storeWaveform(ctx context.Context, path, key string, normalizedKey *string) error {
+	var exists bool
+	if err := s.db.GetContext(ctx, &exists,
+		"SELECT EXISTS (SELECT 1 FROM file_waveforms WHERE object_key = $1)", key); err != nil || exists {
+		return err
+	}
+
+	waveform, err := audio.ComputeWaveform(path, s.waveforms.peaksPerSecond, s.waveforms.maxPeaks)
+	if (errors.Is(err, audio.ErrUnsupportedEncoding) || errors.Is(err, audio.ErrNotWAV)) && normalizedKey != nil {
+		normalized, cleanup, copyErr := localCopy(ctx, s.storage, *normalizedKey)
+		if copyErr != nil {
+			return copyErr
+		}
+		defer cleanup()
+		waveform, err = audio.ComputeWaveform(normalized, s.waveforms.peaksPerSecond, s.waveforms.maxPeaks)
+	}
+	if err != nil {
+		return err
+	}
+
+	peaks := make([]byte, len(waveform.Peaks))
+	for i, p := range waveform.Peaks {
+		peaks[i] = byte(p)
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO file_waveforms (object_key, sample_rate, samples_per_peak, peaks) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (object_key) DO NOTHING`,
+		key, waveform.SampleRate, waveform.SamplesPerPeak, peaks)
+	return err
+}
+
+// getWaveform returns the waveform of an audio file with its format, for
+// the version the version parameter selects. length resamples the waveform
+// to at most that many peaks.
+func (s *StorageService) getWaveform(w http.ResponseWriter, r *http.Request) {
+	length := 0
+	if v := r.URL.Query().Get("length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "length must be a positive integer")
+			return
+		}
+		length = n
+	}
+
+	file, ok := s.readableFile(w, r)
+	if !ok {
+		return
+	}
+	if file, ok = s.fileVersion(w, r, file); !ok {
+		return
+	}
+	if quarantined(w, file) {
+		return
+	}
+
+	var stored struct {
+		SampleRate     uint32 `db:"sample_rate"`
+		SamplesPerPeak int    `db:"samples_per_peak"`
+		Peaks          []byte `db:"peaks"`
+	}
+	err := s.db.GetContext(r.Context(), &stored,
+		"SELECT sample_rate, samples_per_peak, peaks FROM file_waveforms WHERE object_key = $1", file.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "File has no waveform")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read waveform")
+		return
+	}
+	recorded, err := s.recordedAudio(r.Context(), file)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+		return
+	}
+
+	waveform := audio.Waveform{SampleRate: stored.SampleRate, SamplesPerPeak: stored.SamplesPerPeak, Peaks: make([]int8, len(stored.Peaks))}
+	for i, p := range stored.Peaks {
+		waveform.Peaks[i] = int8(p)
+	}
+	waveform = waveform.Resample(length)
+
+	info := map[string]interface{}{
+		"format":      recorded.Format.String,
+		"channels":    recorded.Channels.Int64,
+		"sample_rate": recorded.SampleRate.Int64,
+		"duration_ms": recorded.DurationMS.Int64,
+		"size_bytes":  recorded.Size,
+	}
+	if recorded.Codec.Valid {
+		info["codec"] = recorded.Codec.String
+	}
+	if recorded.BitsPerSample.Valid {
+		info["bits_per_sample"] = recorded.BitsPerSample.Int64
+	}
+	utils.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"id":      file.ID,
+		"version": file.Version,
+		"audio":   info,
+		// audiowaveform's JSON format
+		"waveform": map[string]interface{}{
+			"version":           2,
+			"channels":          1,
+			"sample_rate":       waveform.SampleRate,
+			"samples_per_pixel": waveform.SamplesPerPeak,
+			"bits":              8,
+			"length":            waveform.Length(),
+			"data":              waveform.Peaks,
+		},
+	})
+}
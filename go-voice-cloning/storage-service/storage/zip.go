@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// storedTypes are content types already compressed, stored in archives as
+// they are rather than deflated again
+var storedTypes = map[string]bool{
+	"audio/mpeg": true,
+	"audio/ogg":  true,
+	"audio/flac": true,
+	"audio/opus": true,
+	"video/ogg":  true,
+}
+
+// archiveEntry is a file going into an archive
+type archiveEntry struct {
+	file    storedFile
+	name    string
+	modTime time.Time
+}
+
+// downloadArchive streams the requested files as a ZIP archive, built as it
+// is sent. Every file is checked before the first byte is written: files the
+// caller can't access, quarantined files and files in cold storage are
+// refused with the IDs at fault.
+func (s *StorageService) downloadArchive(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Files []string `json:"files"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Files) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "files is required")
+		return
+	}
+	if len(req.Files) > int(s.archiveLimit) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("At most %d files can be archived at once", s.archiveLimit))
+		return
+	}
+
+	var entries []archiveEntry
+	var missing, blocked, cold []string
+	seen := map[string]bool{}
+	names := map[string]int{}
+	for _, id := range req.Files {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if !validFileID(id) {
+			missing = append(missing, id)
+			continue
+		}
+		file, err := s.resolveFile(r.Context(), id)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+			return
+		}
+		allowed, err := s.canRead(r.Context(), r, file)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to read file metadata")
+			return
+		}
+		noteFileAccess(r, file, allowed || !file.UserID.Valid)
+		if !allowed {
+			missing = append(missing, id)
+			continue
+		}
+		if file.Quarantined {
+			blocked = append(blocked, id)
+			continue
+		}
+		info, err := s.storage.Stat(r.Context(), file.Key)
+		if isNotExist(err) {
+			if _, err := s.cold.Stat(r.Context(), file.Key); err == nil {
+				cold = append(cold, id)
+			} else {
+				missing = append(missing, id)
+			}
+			continue
+		}
+		if err != nil {
+			storageError(w, err, file.ID, "Failed to open file")
+			return
+		}
+		name := file.Filename
+		if name == "" {
+			name = file.ID
+		}
+		entries = append(entries, archiveEntry{file: file, name: archiveName(names, name), modTime: info.ModTime})
+	}
+
+	switch {
+	case len(missing) > 0:
+		body := utils.ErrorBody(w, apierror.FileNotFound, "Files not found")
+		body["files"] = missing
+		utils.JSONResponse(w, http.StatusNotFound, body)
+		return
+	case len(blocked) > 0:
+		body := utils.ErrorBody(w, apierror.FileQuarantined, "Files are quarantined after failing a malware scan")
+		body["files"] = blocked
+		utils.JSONResponse(w, http.StatusForbidden, body)
+		return
+	case len(cold) > 0:
+		body := utils.ErrorBody(w, apierror.FileColdStorage, "Files are in cold storage")
+		body["files"] = cold
+		utils.JSONResponse(w, http.StatusConflict, body)
+		return
+	}
+	for _, entry := range entries {
+		if !s.auditAdminAccess(w, r, entry.file, accessDownload) {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "files-" + time.Now().UTC().Format("20060102-150405") + ".zip"}))
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		if err := s.writeArchiveEntry(r, archive, entry); err != nil {
+			// The status has been sent; leaving the archive without its
+			// central directory makes clients see it's incomplete
+			logging.FromContext(r.Context()).Error("Failed to archive file", "file_id", entry.file.ID, "error", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to finish archive", "error", err)
+	}
+}
+
+// writeArchiveEntry copies a file's content into the archive
+func (s *StorageService) writeArchiveEntry(r *http.Request, archive *zip.Writer, entry archiveEntry) error {
+	header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: entry.modTime}
+	if entry.file.ContentType.Valid && storedTypes[entry.file.ContentType.String] {
+		header.Method = zip.Store
+	}
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	src, err := s.storage.Get(r.Context(), entry.file.Key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// archiveName is the name a file gets in an archive: its filename, numbered
+// when an earlier file had the same one
+func archiveName(names map[string]int, filename string) string {
+	names[filename]++
+	n := names[filename]
+	if n == 1 {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	name := fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(filename, ext), n, ext)
+	if names[name] > 0 {
+		return archiveName(names, filename)
+	}
+	names[name]++
+	return name
+}
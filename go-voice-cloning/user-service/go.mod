@@ -11,4 +11,4 @@ require (
 	github.com/voice-cloning/shared v0.0.0-00010101000000-000000000000
 )
 
-
+require github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
@@ -0,0 +1,214 @@
+package user
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/cron"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultActivityDays = 30
+	maxActivityDays     = 366
+	dateLayout          = "2006-01-02"
+)
+
+// MemberActivity is a member's usage over a report's date range
+type MemberActivity struct {
+	UserID        int        `json:"user_id" db:"user_id"`
+	Email         string     `json:"email" db:"email"`
+	Username      string     `json:"username" db:"username"`
+	Role          string     `json:"role" db:"role"`
+	ClonesCreated int        `json:"clones_created" db:"clones_created"`
+	StorageBytes  int64      `json:"storage_bytes" db:"storage_bytes"`
+	LastActiveAt  *time.Time `json:"last_active_at" db:"last_active_at"`
+}
+
+// activityRollup refreshes the daily member activity rollup, as the
+// activity-rollup job. Reports read only the rollup, so they stay cheap
+// however large an org grows.
+func (s *UserService) activityRollup(lookbackDays int) cron.Func {
+	return func(ctx context.Context) error {
+		return s.rollupActivity(ctx, lookbackDays)
+	}
+}
+
+// rollupActivity recomputes the rollup rows of the last lookbackDays days.
+// Clone counts are recounted, so reruns are idempotent; storage is a
+// snapshot recorded on today's row.
+func (s *UserService) rollupActivity(ctx context.Context, lookbackDays int) error {
+	since := time.Now().UTC().AddDate(0, 0, -lookbackDays).Truncate(24 * time.Hour)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO member_activity_daily (user_id, day, clones_created, last_active_at, updated_at)
+		SELECT user_id, created_at::date, COUNT(*), MAX(GREATEST(created_at, updated_at)), NOW()
+		FROM voice_clones WHERE created_at >= $1 GROUP BY user_id, created_at::date
+		ON CONFLICT (user_id, day) DO UPDATE SET clones_created = EXCLUDED.clones_created,
+			last_active_at = GREATEST(member_activity_daily.last_active_at, EXCLUDED.last_active_at), updated_at = NOW()`,
+		since)
+	if err != nil {
+		return fmt.Errorf("clone activity: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO member_activity_daily (user_id, day, last_active_at, updated_at)
+		SELECT user_id, created_at::date, MAX(created_at), NOW()
+		FROM stored_files WHERE user_id IS NOT NULL AND class = 'sample' AND created_at >= $1
+		GROUP BY user_id, created_at::date
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			last_active_at = GREATEST(member_activity_daily.last_active_at, EXCLUDED.last_active_at), updated_at = NOW()`,
+		since)
+	if err != nil {
+		return fmt.Errorf("upload activity: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO member_activity_daily (user_id, day, storage_bytes, updated_at)
+		SELECT user_id, CURRENT_DATE, SUM(size_bytes), NOW()
+		FROM stored_files WHERE user_id IS NOT NULL GROUP BY user_id
+		ON CONFLICT (user_id, day) DO UPDATE SET storage_bytes = EXCLUDED.storage_bytes, updated_at = NOW()`)
+	if err != nil {
+		return fmt.Errorf("storage snapshot: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// isOrgAdmin reports whether the caller may see an org's reports: platform
+// admins, and admins imported into the org
+func (s *UserService) isOrgAdmin(r *http.Request, userID int, org string) bool {
+	if middleware.IsAdmin(r) {
+		return true
+	}
+	var ok bool
+	s.db.Get(&ok,
+		`SELECT EXISTS(SELECT 1 FROM user_invitations i JOIN users u ON u.id = i.user_id
+		WHERE i.user_id = $1 AND i.org = $2 AND u.role = $3)`,
+		userID, org, types.RoleAdmin)
+	return ok
+}
+
+// parseDateRange reads ?from= and ?to= (YYYY-MM-DD, inclusive), defaulting
+// to the last 30 days
+func parseDateRange(r *http.Request) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -(defaultActivityDays-1)), today
+
+	var err error
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(dateLayout, v); err != nil {
+			return from, to, fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		from = to.AddDate(0, 0, -(defaultActivityDays - 1))
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(dateLayout, v); err != nil {
+			return from, to, fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) >= maxActivityDays*24*time.Hour {
+		return from, to, fmt.Errorf("date range is limited to %d days", maxActivityDays)
+	}
+	return from, to, nil
+}
+
+// getOrgActivity reports each org member's clones created, storage used and
+// last activity over a date range, as JSON or CSV (?format=csv)
+func (s *UserService) getOrgActivity(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	org := mux.Vars(r)["org"]
+	if !s.isOrgAdmin(r, userID, org) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.OrgRoleRequired, "Org admin role required")
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	// Storage is the latest snapshot in the range
+	members := []MemberActivity{}
+	err = dbroute.Reader(r, s.db, s.replica).Select(&members,
+		`SELECT u.id AS user_id, u.email, u.username, u.role,
+			COALESCE(SUM(a.clones_created), 0) AS clones_created,
+			COALESCE((SELECT l.storage_bytes FROM member_activity_daily l
+				WHERE l.user_id = u.id AND l.day BETWEEN $2 AND $3 AND l.storage_bytes IS NOT NULL
+				ORDER BY l.day DESC LIMIT 1), 0) AS storage_bytes,
+			MAX(a.last_active_at) AS last_active_at
+		FROM user_invitations i
+		JOIN users u ON u.id = i.user_id
+		LEFT JOIN member_activity_daily a ON a.user_id = u.id AND a.day BETWEEN $2 AND $3
+		WHERE i.org = $1
+		GROUP BY u.id, u.email, u.username, u.role
+		ORDER BY u.email`,
+		org, from, to)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch member activity")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeActivityCSV(w, org, from, to, members)
+		return
+	}
+	utils.SuccessResponse(w, map[string]interface{}{
+		"org":     org,
+		"from":    from.Format(dateLayout),
+		"to":      to.Format(dateLayout),
+		"members": members,
+	})
+}
+
+func writeActivityCSV(w http.ResponseWriter, org string, from, to time.Time, members []MemberActivity) {
+	name := strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, org)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-activity-%s-%s.csv"`,
+		name, from.Format(dateLayout), to.Format(dateLayout)))
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"user_id", "email", "username", "role", "clones_created", "storage_bytes", "last_active_at"})
+	for _, m := range members {
+		lastActive := ""
+		if m.LastActiveAt != nil {
+			lastActive = m.LastActiveAt.UTC().Format(time.RFC3339)
+		}
+		out.Write([]string{
+			strconv.Itoa(m.UserID), m.Email, m.Username, m.Role,
+			strconv.Itoa(m.ClonesCreated), strconv.FormatInt(m.StorageBytes, 10), lastActive,
+		})
+	}
+	out.Flush()
+}
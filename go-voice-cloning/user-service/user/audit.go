@@ -0,0 +1,11 @@
+package user
+
+// Operations recorded to the audit log
+const (
+	auditUpdatePlan       = "update_plan"
+	auditDeleteUser       = "delete_user"
+	auditCloseAccount     = "close_account"
+	auditImportUsers      = "import_users"
+	auditSetOrgMemberRole = "set_org_member_role"
+	auditRemoveOrgMember  = "remove_org_member"
+)
@@ -0,0 +1,410 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// A user's avatar is stored through the storage service as the uploaded
+// image, which only its owner can download, and square PNG variants cropped
+// from its centre, which are public-read so anyone shown the profile can
+// load them without a token.
+
+const (
+	// maxAvatarBytes matches the storage service's avatar policy
+	maxAvatarBytes = 2 << 20
+	// maxAvatarSide bounds the pixels decoded, so a small file can't expand
+	// into a huge image
+	maxAvatarSide = 4096
+	// publicFilePath is where the gateway serves public-read files
+	publicFilePath = "/api/public/files/"
+	// downloadPath is where the gateway serves a user's own files
+	downloadPath = "/api/storage/download/"
+)
+
+// avatarSizes are the sides in pixels of the generated variants; the last
+// is the profile's avatar_url
+var avatarSizes = []int{64, 128, 256}
+
+// avatarFormats maps the image formats avatars can be decoded from to their
+// content type
+var avatarFormats = map[string]string{"png": "image/png", "jpeg": "image/jpeg", "gif": "image/gif"}
+
+type storedAvatar struct {
+	OriginalFile string    `db:"original_file"`
+	Variants     []byte    `db:"variants"`
+	ContentType  string    `db:"content_type"`
+	Width        int       `db:"width"`
+	Height       int       `db:"height"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+// files lists the stored files of an avatar
+func (a storedAvatar) files() []string {
+	files := []string{a.OriginalFile}
+	var variants map[string]string
+	json.Unmarshal(a.Variants, &variants)
+	for _, file := range variants {
+		files = append(files, file)
+	}
+	return files
+}
+
+func (a storedAvatar) avatar() types.Avatar {
+	var variants map[string]string
+	json.Unmarshal(a.Variants, &variants)
+	avatar := types.Avatar{
+		OriginalURL: downloadPath + a.OriginalFile,
+		Variants:    map[string]string{},
+		Width:       a.Width,
+		Height:      a.Height,
+		ContentType: a.ContentType,
+		UpdatedAt:   a.UpdatedAt,
+	}
+	for size, file := range variants {
+		avatar.Variants[size] = publicFilePath + file
+	}
+	avatar.URL = avatar.Variants[strconv.Itoa(avatarSizes[len(avatarSizes)-1])]
+	return avatar
+}
+
+// avatarURLColumn selects a profile's avatar_url from user_avatars ua
+var avatarURLColumn = fmt.Sprintf("COALESCE('%s' || (ua.variants->>'%d'), '') AS avatar_url",
+	publicFilePath, avatarSizes[len(avatarSizes)-1])
+
+// storageError is a request the storage service refused
+type storageError struct {
+	status  int
+	code    apierror.Code
+	message string
+}
+
+func (e *storageError) Error() string {
+	return fmt.Sprintf("storage service returned %d: %s", e.status, e.message)
+}
+
+// getAvatar shows the caller's avatar and its variants
+func (s *UserService) getAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var stored storedAvatar
+	err := s.db.GetContext(r.Context(), &stored,
+		"SELECT original_file, variants, content_type, width, height, updated_at FROM user_avatars WHERE user_id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "No avatar uploaded")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch avatar")
+		return
+	}
+	utils.SuccessResponse(w, stored.avatar())
+}
+
+// uploadAvatar sets the caller's avatar from the PNG, JPEG or GIF image in
+// the "avatar" form field, replacing any earlier one
+func (s *UserService) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "An image is required in the avatar field")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "Failed to read image")
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		utils.ErrorResponse(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, fmt.Sprintf("Avatars can be at most %d bytes", maxAvatarBytes))
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	contentType, ok := avatarFormats[format]
+	if err != nil || !ok {
+		utils.ErrorResponse(w, http.StatusUnsupportedMediaType, apierror.UnsupportedMediaType, "Avatars must be PNG, JPEG or GIF images")
+		return
+	}
+	if config.Width > maxAvatarSide || config.Height > maxAvatarSide {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("Avatars can be at most %dx%d pixels", maxAvatarSide, maxAvatarSide))
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnsupportedMediaType, apierror.UnsupportedMediaType, "Avatar image could not be decoded")
+		return
+	}
+
+	stored := storedAvatar{ContentType: contentType, Width: config.Width, Height: config.Height, UpdatedAt: time.Now()}
+	var uploaded []string
+	// Files stored before a failure would be left behind
+	discard := func() {
+		for _, file := range uploaded {
+			if err := s.deleteUserFile(r, file); err != nil {
+				logging.FromContext(r.Context()).Warn("Failed to remove avatar file", "file", file, "user_id", userID, "error", err)
+			}
+		}
+	}
+
+	stored.OriginalFile, err = s.storeAvatarFile(r, "avatar"+extension(format), data)
+	if err != nil {
+		avatarStorageError(w, err)
+		return
+	}
+	uploaded = append(uploaded, stored.OriginalFile)
+	variants := map[string]string{}
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, squareVariant(img, size)); err != nil {
+			discard()
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to resize avatar")
+			return
+		}
+		id, err := s.storeAvatarFile(r, fmt.Sprintf("avatar-%d.png", size), buf.Bytes())
+		if err == nil {
+			uploaded = append(uploaded, id)
+			err = s.setPublicRead(r, id)
+		}
+		if err != nil {
+			discard()
+			avatarStorageError(w, err)
+			return
+		}
+		variants[strconv.Itoa(size)] = id
+	}
+	stored.Variants, _ = json.Marshal(variants)
+
+	var previous storedAvatar
+	err = s.db.GetContext(r.Context(), &previous,
+		"SELECT original_file, variants FROM user_avatars WHERE user_id = $1", userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		discard()
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update avatar")
+		return
+	}
+	_, err = s.db.ExecContext(r.Context(),
+		`INSERT INTO user_avatars (user_id, original_file, variants, content_type, width, height, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE SET
+			original_file = EXCLUDED.original_file,
+			variants = EXCLUDED.variants,
+			content_type = EXCLUDED.content_type,
+			width = EXCLUDED.width,
+			height = EXCLUDED.height,
+			updated_at = EXCLUDED.updated_at`,
+		userID, stored.OriginalFile, stored.Variants, stored.ContentType, stored.Width, stored.Height, stored.UpdatedAt)
+	if err != nil {
+		discard()
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update avatar")
+		return
+	}
+	if previous.OriginalFile != "" {
+		s.deleteAvatarFiles(r, userID, previous)
+	}
+	s.recordProfileUpdate(r, userID, "avatar")
+
+	utils.JSONResponse(w, http.StatusCreated, stored.avatar())
+}
+
+// deleteAvatar removes the caller's avatar and its stored files
+func (s *UserService) deleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var stored storedAvatar
+	err := s.db.GetContext(r.Context(), &stored,
+		"DELETE FROM user_avatars WHERE user_id = $1 RETURNING original_file, variants", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "No avatar uploaded")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete avatar")
+		return
+	}
+	s.deleteAvatarFiles(r, userID, stored)
+	s.recordProfileUpdate(r, userID, "avatar")
+	utils.SuccessResponse(w, map[string]string{"message": "Avatar deleted"})
+}
+
+// deleteAvatarFiles removes an avatar's stored files. Failures are only
+// logged: the avatar has already been replaced or deleted.
+func (s *UserService) deleteAvatarFiles(r *http.Request, userID int, stored storedAvatar) {
+	for _, file := range stored.files() {
+		if err := s.deleteUserFile(r, file); err != nil {
+			logging.FromContext(r.Context()).Warn("Failed to remove avatar file", "file", file, "user_id", userID, "error", err)
+		}
+	}
+}
+
+// avatarStorageError answers with the storage service's refusal, such as an
+// exceeded quota, or a bad gateway when it failed
+func avatarStorageError(w http.ResponseWriter, err error) {
+	var refused *storageError
+	if errors.As(err, &refused) && refused.status >= 400 && refused.status < 500 {
+		code := refused.code
+		if code == "" {
+			code = apierror.ForStatus(refused.status)
+		}
+		utils.ErrorResponse(w, refused.status, code, refused.message)
+		return
+	}
+	slog.Error("Failed to store avatar", "error", err)
+	utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to store avatar")
+}
+
+func extension(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// squareVariant crops the centre square of an image and scales it to size
+// pixels, averaging the source pixels each one covers
+func squareVariant(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y0+y*side/size, y0+(y+1)*side/size
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x0+x*side/size, x0+(x+1)*side/size
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// storeAvatarFile stores an image as one of the caller's files, of the
+// storage service's avatar type, and returns its ID
+func (s *UserService) storeAvatarFile(r *http.Request, filename string, data []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("type", "avatar")
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	var stored struct {
+		ID string `json:"id"`
+	}
+	err = s.storageRequest(r, http.MethodPost, "/upload", writer.FormDataContentType(), &body, &stored)
+	if err == nil && stored.ID == "" {
+		err = errors.New("storage service returned no file ID")
+	}
+	return stored.ID, err
+}
+
+// setPublicRead lets anyone read one of the caller's files
+func (s *UserService) setPublicRead(r *http.Request, id string) error {
+	body, _ := json.Marshal(types.FileACLRequest{ACL: types.FileACLPublicRead})
+	return s.storageRequest(r, http.MethodPut, "/files/"+url.PathEscape(id)+"/acl", "application/json", bytes.NewReader(body), nil)
+}
+
+// deleteUserFile removes one of the caller's files. Files already gone
+// count as deleted.
+func (s *UserService) deleteUserFile(r *http.Request, id string) error {
+	err := s.storageRequest(r, http.MethodDelete, "/files/"+url.PathEscape(id), "", nil, nil)
+	var refused *storageError
+	if errors.As(err, &refused) && refused.status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// storageRequest calls the storage service on behalf of the caller and
+// decodes its response into out, if given
+func (s *UserService) storageRequest(r *http.Request, method, path, contentType string, body io.Reader, out interface{}) error {
+	// Detached from the client's request, so files stored are recorded or
+	// removed even if the client goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, s.storageServiceURL+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, header := range []string{"X-User-ID", utils.RequestIDHeader} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		refused := utils.DecodeError(resp)
+		return &storageError{status: resp.StatusCode, code: refused.Code, message: refused.Message}
+	}
+	if out != nil {
+		return utils.DecodeData(resp, out)
+	}
+	return nil
+}
@@ -0,0 +1,509 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Paid plans are sold through Stripe. Each user gets a Stripe customer the
+// first time they check out; Stripe's subscription webhooks then keep
+// users.plan in step with what the customer pays for. Webhooks are recorded
+// by event ID so a redelivered event is applied once, and each customer
+// keeps the time of the last event applied so one arriving late can't undo
+// a newer change.
+
+const (
+	// maxWebhookBytes bounds a webhook body; Stripe's events are far smaller
+	maxWebhookBytes = 1 << 20
+	// stripeSignatureHeader carries a webhook's timestamp and signatures
+	stripeSignatureHeader = "Stripe-Signature"
+)
+
+// billingConfig is the Stripe account paid plans are sold through. Billing
+// is off without STRIPE_SECRET_KEY.
+type billingConfig struct {
+	apiURL        string
+	secretKey     string
+	webhookSecret string
+	prices        map[string]string // plan -> Stripe price ID
+	successURL    string
+	cancelURL     string
+	returnURL     string
+	tolerance     time.Duration // how old a signed webhook may be
+	client        *http.Client
+}
+
+// BillingConfig is the Stripe account, the price of each paid plan and
+// where Stripe sends users back to
+type BillingConfig struct {
+	APIURL           string        `env:"STRIPE_API_URL" default:"https://api.stripe.com"`
+	SecretKey        string        `env:"STRIPE_SECRET_KEY" secret:"true"`
+	WebhookSecret    string        `env:"STRIPE_WEBHOOK_SECRET" secret:"true"`
+	WebhookTolerance time.Duration `env:"STRIPE_WEBHOOK_TOLERANCE_SECONDS" default:"300" min:"1"`
+	ProPrice         string        `env:"STRIPE_PRICE_PRO"`
+	EnterprisePrice  string        `env:"STRIPE_PRICE_ENTERPRISE"`
+	SuccessURL       string        `env:"BILLING_SUCCESS_URL" default:"http://localhost:8080/billing/success"`
+	CancelURL        string        `env:"BILLING_CANCEL_URL" default:"http://localhost:8080/billing/cancel"`
+	ReturnURL        string        `env:"BILLING_RETURN_URL" default:"http://localhost:8080/account"`
+}
+
+func newBillingConfig(cfg BillingConfig) billingConfig {
+	config := billingConfig{
+		apiURL:        cfg.APIURL,
+		secretKey:     cfg.SecretKey,
+		webhookSecret: cfg.WebhookSecret,
+		prices:        map[string]string{},
+		successURL:    cfg.SuccessURL,
+		cancelURL:     cfg.CancelURL,
+		returnURL:     cfg.ReturnURL,
+		tolerance:     cfg.WebhookTolerance,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	for plan, price := range map[string]string{types.PlanPro: cfg.ProPrice, types.PlanEnterprise: cfg.EnterprisePrice} {
+		if price != "" {
+			config.prices[plan] = price
+		}
+	}
+	return config
+}
+
+func (c billingConfig) enabled() bool {
+	return c.secretKey != ""
+}
+
+// planFor returns the plan sold at a Stripe price, "" for other prices
+func (c billingConfig) planFor(price string) string {
+	for plan, p := range c.prices {
+		if p == price {
+			return plan
+		}
+	}
+	return ""
+}
+
+// paidPlans lists the plans on sale
+func (c billingConfig) paidPlans() []string {
+	plans := []string{}
+	for _, plan := range types.Plans {
+		if _, ok := c.prices[plan]; ok {
+			plans = append(plans, plan)
+		}
+	}
+	return plans
+}
+
+// stripePost posts a form to the Stripe API and decodes the object it
+// returns into out
+func (c billingConfig) stripePost(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.secretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("stripe %s: status %d: %s", path, resp.StatusCode, body.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stripeCustomer returns the user's Stripe customer, creating it the first
+// time
+func (s *UserService) stripeCustomer(ctx context.Context, userID int) (string, error) {
+	var customerID string
+	err := s.db.GetContext(ctx, &customerID, "SELECT stripe_customer_id FROM billing_customers WHERE user_id = $1", userID)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return customerID, err
+	}
+
+	var email string
+	if err := s.db.GetContext(ctx, &email, "SELECT email FROM users WHERE id = $1", userID); err != nil {
+		return "", err
+	}
+	var customer struct {
+		ID string `json:"id"`
+	}
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("metadata[user_id]", strconv.Itoa(userID))
+	// Concurrent checkouts of a new customer create it once
+	if err := s.billing.stripePost(ctx, "/v1/customers", form, fmt.Sprintf("customer-%d", userID), &customer); err != nil {
+		return "", err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO billing_customers (user_id, stripe_customer_id) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING`,
+		userID, customer.ID)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.GetContext(ctx, &customerID, "SELECT stripe_customer_id FROM billing_customers WHERE user_id = $1", userID)
+	return customerID, err
+}
+
+// subscribed reports whether a Stripe subscription status still includes
+// its plan. Past due subscriptions keep it while Stripe retries the payment.
+func subscribed(status string) bool {
+	return status == "active" || status == "trialing" || status == "past_due"
+}
+
+// createCheckout starts the purchase of a paid plan on a Stripe Checkout
+// page. Subscribers change plans in the billing portal instead.
+func (s *UserService) createCheckout(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if !s.billing.enabled() {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Billing is not configured")
+		return
+	}
+	var req types.CheckoutRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	price, ok := s.billing.prices[req.Plan]
+	if !ok {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("plan must be one of %v", s.billing.paidPlans()))
+		return
+	}
+
+	var status sql.NullString
+	err := s.db.GetContext(r.Context(), &status, "SELECT subscription_status FROM billing_customers WHERE user_id = $1", userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to start checkout")
+		return
+	}
+	if subscribed(status.String) {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.AlreadySubscribed, "Already subscribed; change plans in the billing portal")
+		return
+	}
+
+	customerID, err := s.stripeCustomer(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to create the Stripe customer", "error", err)
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to start checkout")
+		return
+	}
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("customer", customerID)
+	form.Set("client_reference_id", strconv.Itoa(userID))
+	form.Set("line_items[0][price]", price)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", s.billing.successURL)
+	form.Set("cancel_url", s.billing.cancelURL)
+	form.Set("subscription_data[metadata][user_id]", strconv.Itoa(userID))
+	var session types.BillingSession
+	if err := s.billing.stripePost(r.Context(), "/v1/checkout/sessions", form, "", &session); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to create a checkout session", "error", err)
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to start checkout")
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, session)
+}
+
+// createBillingPortal opens the Stripe billing portal, where customers
+// change plans, update their payment method or cancel
+func (s *UserService) createBillingPortal(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if !s.billing.enabled() {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Billing is not configured")
+		return
+	}
+	var customerID string
+	err := s.db.GetContext(r.Context(), &customerID, "SELECT stripe_customer_id FROM billing_customers WHERE user_id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.BillingAccountMissing, "No billing account; check out a plan first")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to open the billing portal")
+		return
+	}
+	form := url.Values{}
+	form.Set("customer", customerID)
+	form.Set("return_url", s.billing.returnURL)
+	var session types.BillingSession
+	if err := s.billing.stripePost(r.Context(), "/v1/billing_portal/sessions", form, "", &session); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to create a billing portal session", "error", err)
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to open the billing portal")
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, session)
+}
+
+// getSubscription shows the caller's plan and its billing status
+func (s *UserService) getSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var sub types.Subscription
+	err := s.db.GetContext(r.Context(), &sub,
+		`SELECT u.plan, b.subscription_status, b.current_period_end, COALESCE(b.cancel_at_period_end, FALSE) AS cancel_at_period_end
+		FROM users u LEFT JOIN billing_customers b ON b.user_id = u.id WHERE u.id = $1`, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch subscription")
+		return
+	}
+	utils.SuccessResponse(w, sub)
+}
+
+// verifyStripeSignature checks a webhook's Stripe-Signature header: one of
+// its v1 entries must be the HMAC-SHA256, keyed with the endpoint's secret,
+// of the timestamp t, a dot and the body, and t must be within tolerance
+// of now so a captured request can't be replayed later
+func verifyStripeSignature(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.New("malformed signature header")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside the tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+	return errors.New("no matching signature")
+}
+
+// stripeEvent is the envelope of a webhook
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeSubscription is the part of a subscription object billing reads
+type stripeSubscription struct {
+	ID                string `json:"id"`
+	Customer          string `json:"customer"`
+	Status            string `json:"status"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	Items             struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// stripeWebhook applies Stripe's subscription lifecycle events to users'
+// plans (public, authenticated by the signature). Failures answer 500 so
+// Stripe delivers the event again.
+func (s *UserService) stripeWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.billing.webhookSecret == "" {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Billing is not configured")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes+1))
+	if err != nil || len(body) > maxWebhookBytes {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Invalid webhook body")
+		return
+	}
+	if err := verifyStripeSignature(r.Header.Get(stripeSignatureHeader), body, s.billing.webhookSecret, s.billing.tolerance, time.Now()); err != nil {
+		logging.FromContext(r.Context()).Warn("Rejected a Stripe webhook", "error", err)
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "Invalid signature")
+		return
+	}
+	var event stripeEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid event")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to process event")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(r.Context(),
+		"INSERT INTO stripe_events (id, type) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING", event.ID, event.Type)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to process event")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		utils.SuccessResponse(w, map[string]string{"message": "Event already processed"})
+		return
+	}
+
+	var changed *events.SubscriptionChanged
+	switch event.Type {
+	case "checkout.session.completed":
+		err = s.applyCheckout(r.Context(), tx, event)
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		changed, err = s.applySubscription(r.Context(), tx, event)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to apply Stripe event", "event_id", event.ID, "event_type", event.Type, "error", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to process event")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to process event")
+		return
+	}
+	if changed != nil {
+		if err := events.Publish(r.Context(), s.eventBus, *changed); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to publish subscription change", "user_id", changed.UserID, "error", err)
+		}
+	}
+	utils.SuccessResponse(w, map[string]string{"message": "Event processed"})
+}
+
+// applyCheckout records the subscription a completed checkout started. The
+// plan itself follows the subscription's own events.
+func (s *UserService) applyCheckout(ctx context.Context, tx *sqlx.Tx, event stripeEvent) error {
+	var session struct {
+		Customer     string `json:"customer"`
+		Subscription string `json:"subscription"`
+	}
+	if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+		return err
+	}
+	if session.Subscription == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx,
+		`UPDATE billing_customers SET subscription_id = $2, updated_at = NOW()
+		WHERE stripe_customer_id = $1 AND subscription_id IS NULL`,
+		session.Customer, session.Subscription)
+	return err
+}
+
+// applySubscription sets the plan of a subscription's customer: the plan of
+// its price while it is subscribed, the free plan once it isn't. Events
+// older than the last one applied to the customer are skipped. It returns
+// the change to announce once committed, if one was applied.
+func (s *UserService) applySubscription(ctx context.Context, tx *sqlx.Tx, event stripeEvent) (*events.SubscriptionChanged, error) {
+	var sub stripeSubscription
+	if err := json.Unmarshal(event.Data.Object, &sub); err != nil {
+		return nil, err
+	}
+	var customer struct {
+		UserID      int          `db:"user_id"`
+		LastEventAt sql.NullTime `db:"last_event_at"`
+	}
+	err := tx.GetContext(ctx, &customer,
+		"SELECT user_id, last_event_at FROM billing_customers WHERE stripe_customer_id = $1 FOR UPDATE", sub.Customer)
+	if errors.Is(err, sql.ErrNoRows) {
+		logging.FromContext(ctx).Warn("Ignoring Stripe event for unknown customer", "event_id", event.ID, "customer", sub.Customer)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	created := time.Unix(event.Created, 0).UTC()
+	if customer.LastEventAt.Valid && created.Before(customer.LastEventAt.Time) {
+		return nil, nil
+	}
+
+	plan := types.PlanFree
+	if event.Type != "customer.subscription.deleted" && subscribed(sub.Status) {
+		for _, item := range sub.Items.Data {
+			if p := s.billing.planFor(item.Price.ID); p != "" {
+				plan = p
+				break
+			}
+		}
+		if plan == types.PlanFree {
+			logging.FromContext(ctx).Warn("Subscription has no price of a known plan", "subscription", sub.ID, "user_id", customer.UserID)
+		}
+	}
+	var periodEnd *time.Time
+	if sub.CurrentPeriodEnd > 0 {
+		t := time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+		periodEnd = &t
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE billing_customers SET subscription_id = $2, subscription_status = $3, plan = $4, current_period_end = $5,
+			cancel_at_period_end = $6, last_event_at = $7, updated_at = NOW()
+		WHERE user_id = $1`,
+		customer.UserID, sub.ID, sub.Status, plan, periodEnd, sub.CancelAtPeriodEnd, created)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET plan = $1 WHERE id = $2", plan, customer.UserID); err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Plan changed by subscription", "user_id", customer.UserID, "plan", plan, "subscription", sub.ID, "status", sub.Status)
+	return &events.SubscriptionChanged{
+		UserID:            customer.UserID,
+		Plan:              plan,
+		Status:            sub.Status,
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		CurrentPeriodEnd:  periodEnd,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultCalendarDays = 30
+	maxCalendarDays     = 90
+)
+
+// calendarSource is a service that publishes upcoming events for a user
+type calendarSource struct {
+	name    string
+	baseURL string
+}
+
+// calendarSourceStatus reports how each source contributed to a response
+type calendarSourceStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // ok, unsupported, error
+	Error  string `json:"error,omitempty"`
+}
+
+type calendarResponse struct {
+	From    time.Time              `json:"from"`
+	To      time.Time              `json:"to"`
+	Events  []types.CalendarEvent  `json:"events"`
+	Sources []calendarSourceStatus `json:"sources"`
+}
+
+// calendarSourcesFromEnv lists the services queried for calendar events.
+// Billing is only queried once it is deployed.
+func calendarSourcesFromEnv() []calendarSource {
+	sources := []calendarSource{
+		{name: "voice", baseURL: envOr("VOICE_SERVICE_URL", "http://localhost:8082")},
+		{name: "storage", baseURL: envOr("STORAGE_SERVICE_URL", "http://localhost:8083")},
+	}
+	if billingURL := os.Getenv("BILLING_SERVICE_URL"); billingURL != "" {
+		sources = append(sources, calendarSource{name: "billing", baseURL: billingURL})
+	}
+	return sources
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getCalendar merges upcoming events from every source into one timeline.
+// A failing source is reported in the response instead of failing the call.
+func (s *UserService) getCalendar(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	days := defaultCalendarDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCalendarDays {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("days must be between 1 and %d", maxCalendarDays))
+			return
+		}
+		days = n
+	}
+
+	from := time.Now().UTC()
+	to := from.AddDate(0, 0, days)
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.calendarTimeout)
+	defer cancel()
+
+	resp := calendarResponse{
+		From:    from,
+		To:      to,
+		Events:  []types.CalendarEvent{},
+		Sources: make([]calendarSourceStatus, len(s.calendarSources)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, source := range s.calendarSources {
+		wg.Add(1)
+		go func(i int, source calendarSource) {
+			defer wg.Done()
+			events, status := s.fetchCalendar(ctx, source, r, from, to)
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Sources[i] = status
+			for _, event := range events {
+				if event.At.Before(from) || event.At.After(to) {
+					continue
+				}
+				event.Source = source.name
+				resp.Events = append(resp.Events, event)
+			}
+		}(i, source)
+	}
+	wg.Wait()
+
+	sort.SliceStable(resp.Events, func(i, j int) bool {
+		return resp.Events[i].At.Before(resp.Events[j].At)
+	})
+
+	utils.SuccessResponse(w, resp)
+}
+
+// fetchCalendar queries one source. Sources that do not publish a calendar
+// yet answer 404 and are reported as unsupported.
+func (s *UserService) fetchCalendar(ctx context.Context, source calendarSource, r *http.Request, from, to time.Time) ([]types.CalendarEvent, calendarSourceStatus) {
+	status := calendarSourceStatus{Name: source.name, Status: "error"}
+
+	query := url.Values{}
+	query.Set("from", from.Format(time.RFC3339))
+	query.Set("to", to.Format(time.RFC3339))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.baseURL+"/calendar?"+query.Encode(), nil)
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+	for _, header := range []string{"X-User-ID", "X-User-Role", utils.RequestIDHeader, dbroute.IntentHeader} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		status.Error = "source unavailable"
+		return nil, status
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		status.Status = "unsupported"
+		return nil, status
+	case res.StatusCode != http.StatusOK:
+		status.Error = fmt.Sprintf("source returned %d", res.StatusCode)
+		return nil, status
+	}
+
+	var events []types.CalendarEvent
+	if err := json.NewDecoder(res.Body).Decode(&events); err != nil {
+		status.Error = "invalid response"
+		return nil, status
+	}
+
+	status.Status = "ok"
+	return events, status
+}
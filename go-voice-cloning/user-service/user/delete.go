@@ -0,0 +1,71 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// deleteUser closes an account. The users row is kept, anonymized, because
+// other services' records still reference it; the user's data is removed
+// asynchronously by the account deletion orchestrator.
+func (s *UserService) deleteUser(w http.ResponseWriter, r *http.Request) {
+	adminID := middleware.UserID(r)
+	if adminID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditDeleteUser, "user", mux.Vars(r)["id"], audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+	if userID == adminID {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "Admins can't delete their own account")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	defer tx.Rollback()
+
+	eventID, ok, err := closeAccount(r.Context(), tx, userID, &adminID, DeletionSourceAdmin)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	s.announceAccountClosed(r.Context(), eventID, userID, &adminID)
+	s.audit.Request(r, auditDeleteUser, "user", strconv.Itoa(userID), audit.Success, map[string]interface{}{"event_id": eventID})
+
+	utils.JSONResponse(w, http.StatusAccepted, map[string]interface{}{
+		"user_id":  userID,
+		"event_id": eventID,
+		"message":  "User deleted; their data is being removed",
+		"deletion": fmt.Sprintf("/api/user/admin/deletions/%d", userID),
+		"cleanup":  fmt.Sprintf("/api/voice/admin/user-cleanups/%d", userID),
+	})
+}
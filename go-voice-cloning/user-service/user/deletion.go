@@ -0,0 +1,554 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Account deletions track the removal of a deleted user's data across the
+// services, one row per user_deleted event. The orchestrator runs the
+// steps in order, retrying a failed step with backoff, and records what
+// each one removed in the report.
+
+// Account deletion statuses
+const (
+	DeletionPending   = "pending"
+	DeletionRunning   = "running"
+	DeletionCompleted = "completed"
+	DeletionFailed    = "failed"
+)
+
+// Who asked for an account deletion: an admin, the auth service on the
+// user's behalf, or another publisher of the user_deleted event
+const (
+	DeletionSourceAdmin = "admin"
+	DeletionSourceAuth  = "auth"
+	DeletionSourceEvent = "event"
+)
+
+// Account deletion steps, run in this order. Clones go before files, since
+// the voice service deletes the files of the clones it removes; the files
+// step then purges whatever else the user stored.
+const (
+	DeletionStepProfile = "profile"
+	DeletionStepClones  = "clones"
+	DeletionStepFiles   = "files"
+	DeletionStepDone    = "done"
+)
+
+var deletionSteps = []string{DeletionStepProfile, DeletionStepClones, DeletionStepFiles}
+
+const (
+	// maxDeletionAttempts caps the failed attempts of a deletion; waiting on
+	// another service's cleanup isn't a failure
+	maxDeletionAttempts = 8
+	// deletionBackoff is the delay before retrying a failed step, doubled
+	// with each attempt up to maxDeletionBackoff
+	deletionBackoff    = 30 * time.Second
+	maxDeletionBackoff = 30 * time.Minute
+	// deletionPollInterval is how soon a step waiting on another service is
+	// checked again
+	deletionPollInterval = 15 * time.Second
+	// deletionStaleAfter is how long a running deletion can go without
+	// progress before another replica takes it over
+	deletionStaleAfter = 15 * time.Minute
+	// deletionCallTimeout bounds a call to another service; the voice
+	// service removes the user's clones within the call
+	deletionCallTimeout = 5 * time.Minute
+)
+
+// AccountDeletion is the completion report of a deleted user's data removal
+type AccountDeletion struct {
+	EventID       int            `json:"event_id" db:"event_id"`
+	UserID        int            `json:"user_id" db:"user_id"`
+	RequestedBy   *int           `json:"requested_by,omitempty" db:"requested_by"`
+	Source        string         `json:"source" db:"source"`
+	Status        string         `json:"status" db:"status"`
+	Step          string         `json:"step" db:"step"`
+	Attempts      int            `json:"attempts" db:"attempts"`
+	Report        deletionReport `json:"report" db:"report"`
+	Error         string         `json:"error,omitempty" db:"error"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty" db:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at" db:"created_at"`
+	CompletedAt   *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+	UpdatedAt     time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// DeletionStepReport is the outcome of one step: its status, and the
+// counts reported by the service that ran it
+type DeletionStepReport struct {
+	Status      string          `json:"status"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// deletionReport is stored as JSONB, keyed by step
+type deletionReport map[string]DeletionStepReport
+
+func (r *deletionReport) Scan(src interface{}) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("unexpected report type %T", src)
+	}
+	return json.Unmarshal(b, r)
+}
+
+const deletionColumns = `event_id, user_id, requested_by, source, status, step, attempts, report,
+	COALESCE(error, '') AS error, CASE WHEN status IN ('pending', 'running') THEN next_attempt_at END AS next_attempt_at,
+	created_at, completed_at, updated_at`
+
+// errStepWaiting means a step is waiting on another service's cleanup
+var errStepWaiting = errors.New("waiting for cleanup")
+
+// closeAccount anonymizes a user and publishes the user_deleted event that
+// starts the removal of their data, recording who asked for it. It reports
+// false for users who don't exist or were already deleted.
+func closeAccount(ctx context.Context, tx *sqlx.Tx, userID int, requestedBy *int, source string) (int, bool, error) {
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET email = $2, username = $3, password = '', deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`,
+		userID, fmt.Sprintf("deleted-%d@deleted.invalid", userID), fmt.Sprintf("deleted-%d", userID))
+	if err != nil {
+		return 0, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, false, nil
+	}
+
+	eventID, err := events.PublishUserDeleted(ctx, tx, userID, requestedBy)
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO account_deletions (event_id, user_id, requested_by, source, status, step)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		eventID, userID, requestedBy, source, DeletionPending, DeletionStepProfile)
+	return eventID, err == nil, err
+}
+
+// announceAccountClosed publishes a closed account to the event bus. The
+// user_events outbox stays the record the deletion is driven from, so a
+// failure is only logged.
+func (s *UserService) announceAccountClosed(ctx context.Context, eventID, userID int, actorID *int) {
+	err := events.Publish(ctx, s.eventBus, events.UserDeleted{EventID: eventID, UserID: userID, ActorID: actorID})
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to publish account deletion event", "user_id", userID, "error", err)
+	}
+}
+
+// requestAccountDeletion closes an account at the auth service's request,
+// when a user deletes their own account (internal). The caller is
+// expected to have confirmed the user's identity.
+func (s *UserService) requestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	defer tx.Rollback()
+
+	eventID, ok, err := closeAccount(r.Context(), tx, userID, &userID, DeletionSourceAuth)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	if !ok {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to delete user")
+		return
+	}
+	s.announceAccountClosed(r.Context(), eventID, userID, &userID)
+	s.audit.Request(r, auditCloseAccount, "user", strconv.Itoa(userID), audit.Success, map[string]interface{}{"event_id": eventID})
+
+	utils.JSONResponse(w, http.StatusAccepted, map[string]interface{}{
+		"user_id":  userID,
+		"event_id": eventID,
+		"message":  "Account deleted; its data is being removed",
+	})
+}
+
+// runAccountDeletions orchestrates the removal of deleted users' data. It
+// wakes on user_events notifications, and polls every interval for events
+// missed while no listener was connected and for steps due a retry.
+func (s *UserService) runAccountDeletions(ctx context.Context, dbURL string, interval time.Duration) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("User event listener error", "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(types.UserEventsChannel); err != nil {
+		slog.Error("Failed to listen for user events", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.claimDeletionEvents(ctx); err != nil {
+			slog.Error("Failed to read user events", "error", err)
+		}
+		for ctx.Err() == nil {
+			deletion, err := s.nextDeletion(ctx)
+			if err != nil {
+				slog.Error("Failed to start account deletion", "error", err)
+				break
+			}
+			if deletion == nil {
+				break
+			}
+			s.runDeletion(ctx, deletion)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimDeletionEvents records a deletion for every user_deleted event
+// published without one, such as by another service
+func (s *UserService) claimDeletionEvents(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO account_deletions (event_id, user_id, requested_by, source, status, step)
+		SELECT id, user_id, actor_id, $1, $2, $3 FROM user_events
+		WHERE kind = $4 AND id > (SELECT COALESCE(MAX(event_id), 0) FROM account_deletions)
+		ON CONFLICT (event_id) DO NOTHING`,
+		DeletionSourceEvent, DeletionPending, DeletionStepProfile, types.UserEventDeleted)
+	return err
+}
+
+// nextDeletion claims the oldest deletion due, or one whose replica stopped
+// reporting progress
+func (s *UserService) nextDeletion(ctx context.Context) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	err := s.db.GetContext(ctx, &deletion,
+		`UPDATE account_deletions SET status = $1, updated_at = NOW()
+		WHERE event_id = (
+			SELECT event_id FROM account_deletions
+			WHERE (status = $2 AND next_attempt_at <= NOW()) OR (status = $1 AND updated_at < $3)
+			ORDER BY next_attempt_at, event_id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING `+deletionColumns,
+		DeletionRunning, DeletionPending, time.Now().Add(-deletionStaleAfter))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// runDeletion runs a deletion's remaining steps, saving the report after
+// each. A step waiting on another service is checked again shortly; a
+// failed one is retried with backoff until the deletion has failed
+// maxDeletionAttempts times.
+func (s *UserService) runDeletion(ctx context.Context, deletion *AccountDeletion) {
+	if deletion.Report == nil {
+		deletion.Report = deletionReport{}
+	}
+	for _, step := range deletionSteps[stepIndex(deletion.Step):] {
+		deletion.Step = step
+		result, err := s.runDeletionStep(ctx, deletion, step)
+
+		report := DeletionStepReport{Status: DeletionCompleted, Result: result}
+		if err != nil {
+			report.Status = DeletionPending
+			report.Error = err.Error()
+		} else {
+			now := time.Now()
+			report.CompletedAt = &now
+		}
+		deletion.Report[step] = report
+
+		if errors.Is(err, errStepWaiting) {
+			s.saveDeletion(deletion, DeletionPending, "", deletionPollInterval)
+			return
+		}
+		if err != nil {
+			s.failDeletionStep(ctx, deletion, err)
+			return
+		}
+	}
+
+	deletion.Step = DeletionStepDone
+	s.saveDeletion(deletion, DeletionCompleted, "", 0)
+	slog.Info("Removed the data of deleted user", "user_id", deletion.UserID)
+}
+
+// failDeletionStep schedules a retry of the failed step, or gives up once
+// the deletion has been tried maxDeletionAttempts times. An attempt cut
+// short by shutdown is resumed without counting.
+func (s *UserService) failDeletionStep(ctx context.Context, deletion *AccountDeletion, err error) {
+	if ctx.Err() != nil {
+		s.saveDeletion(deletion, DeletionPending, err.Error(), 0)
+		return
+	}
+	deletion.Attempts++
+	slog.Error("Account deletion step failed", "user_id", deletion.UserID, "step", deletion.Step, "attempt", deletion.Attempts, "error", err)
+	if deletion.Attempts >= maxDeletionAttempts {
+		report := deletion.Report[deletion.Step]
+		report.Status = DeletionFailed
+		deletion.Report[deletion.Step] = report
+		s.saveDeletion(deletion, DeletionFailed, err.Error(), 0)
+		return
+	}
+	backoff := deletionBackoff << (deletion.Attempts - 1)
+	if backoff > maxDeletionBackoff {
+		backoff = maxDeletionBackoff
+	}
+	s.saveDeletion(deletion, DeletionPending, err.Error(), backoff)
+}
+
+// saveDeletion records a deletion's progress. It isn't tied to the
+// orchestrator's context, so the outcome of an attempt cut short by
+// shutdown is still saved.
+func (s *UserService) saveDeletion(deletion *AccountDeletion, status, errMsg string, retryIn time.Duration) {
+	report, _ := json.Marshal(deletion.Report)
+	_, err := s.db.Exec(
+		`UPDATE account_deletions SET status = $1, step = $2, attempts = $3, report = $4, error = NULLIF($5, ''),
+			next_attempt_at = $6, completed_at = CASE WHEN $1 = $7 THEN NOW() END, updated_at = NOW()
+		WHERE event_id = $8`,
+		status, deletion.Step, deletion.Attempts, report, errMsg, time.Now().Add(retryIn), DeletionCompleted, deletion.EventID)
+	if err != nil {
+		slog.Error("Failed to record account deletion", "user_id", deletion.UserID, "error", err)
+	}
+}
+
+func stepIndex(step string) int {
+	for i, s := range deletionSteps {
+		if s == step {
+			return i
+		}
+	}
+	return len(deletionSteps)
+}
+
+// runDeletionStep runs one step and returns what it removed
+func (s *UserService) runDeletionStep(ctx context.Context, deletion *AccountDeletion, step string) (json.RawMessage, error) {
+	switch step {
+	case DeletionStepProfile:
+		return s.deleteProfileData(ctx, deletion.UserID)
+	case DeletionStepClones:
+		return s.deleteVoiceData(ctx, deletion.UserID)
+	case DeletionStepFiles:
+		return s.deleteStoredData(ctx, deletion.UserID)
+	}
+	return nil, fmt.Errorf("unknown step %q", step)
+}
+
+// deleteProfileData removes the user's rows kept by this service
+func (s *UserService) deleteProfileData(ctx context.Context, userID int) (json.RawMessage, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted := map[string]int64{}
+	for _, table := range []string{"user_profiles", "user_avatars", "user_preferences", "user_invitations", "member_activity_daily", "user_activity", "org_members", "notifications", "referral_codes"} {
+		res, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		deleted[table], _ = res.RowsAffected()
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"rows_deleted": deleted})
+}
+
+// deleteVoiceData has the voice service run the cleanup of the user's
+// clones, syntheses and their files. The voice service also runs it on its
+// own; this waits for it while another replica has it.
+func (s *UserService) deleteVoiceData(ctx context.Context, userID int) (json.RawMessage, error) {
+	status, body, err := s.deletionCall(ctx, http.MethodPost,
+		fmt.Sprintf("%s/users/%d/cleanup", s.voiceServiceURL, userID))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK && status != http.StatusAccepted {
+		return nil, fmt.Errorf("voice service returned %d", status)
+	}
+
+	var cleanup struct {
+		Status      string   `json:"status"`
+		FilesFailed []string `json:"files_failed"`
+		Error       string   `json:"error"`
+	}
+	if err := json.Unmarshal(body, &cleanup); err != nil {
+		return nil, fmt.Errorf("decoding voice cleanup: %w", err)
+	}
+	switch {
+	case status == http.StatusAccepted:
+		return body, errStepWaiting
+	case cleanup.Status != "completed":
+		return body, fmt.Errorf("voice cleanup %s: %s", cleanup.Status, cleanup.Error)
+	case len(cleanup.FilesFailed) > 0:
+		return body, fmt.Errorf("voice cleanup couldn't delete %d files", len(cleanup.FilesFailed))
+	}
+	return body, nil
+}
+
+// deleteStoredData has the storage service purge whatever else the user
+// stored. Files it couldn't remove are retried with the step.
+func (s *UserService) deleteStoredData(ctx context.Context, userID int) (json.RawMessage, error) {
+	status, body, err := s.deletionCall(ctx, http.MethodDelete,
+		fmt.Sprintf("%s/users/%d/files", s.storageServiceURL, userID))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned %d", status)
+	}
+
+	var purge types.UserFilesPurge
+	if err := json.Unmarshal(body, &purge); err != nil {
+		return nil, fmt.Errorf("decoding storage purge: %w", err)
+	}
+	if len(purge.FilesFailed) > 0 {
+		return body, fmt.Errorf("storage service couldn't delete %d files", len(purge.FilesFailed))
+	}
+	return body, nil
+}
+
+// deletionCall calls an internal endpoint of another service and returns
+// its status and the data of its response
+func (s *UserService) deletionCall(ctx context.Context, method, url string) (int, json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, deletionCallTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	envelope, err := utils.ReadEnvelope(resp.StatusCode, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, envelope.Data, nil
+}
+
+// deletionCursor is the position after the last deletion of a page
+type deletionCursor struct {
+	EventID int `json:"event_id"`
+}
+
+// listAccountDeletions reports account deletions, newest first, optionally
+// filtered by ?status=, with ?limit=/?cursor= pagination (admin)
+func (s *UserService) listAccountDeletions(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultDirectoryPageSize, maxDirectoryPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where = append(where, "status = "+arg(status))
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM account_deletions WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch account deletions")
+		return
+	}
+
+	var cursor deletionCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, "event_id < "+arg(cursor.EventID))
+	}
+
+	deletions := []AccountDeletion{}
+	err = db.SelectContext(r.Context(), &deletions,
+		"SELECT "+deletionColumns+" FROM account_deletions WHERE "+strings.Join(where, " AND ")+" ORDER BY event_id DESC "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch account deletions")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, deletions, total, func(last AccountDeletion) interface{} {
+		return deletionCursor{EventID: last.EventID}
+	}))
+}
+
+// getAccountDeletion reports the latest deletion of a user (admin)
+func (s *UserService) getAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	var deletion AccountDeletion
+	err := dbroute.Reader(r, s.db, s.replica).GetContext(r.Context(), &deletion,
+		"SELECT "+deletionColumns+" FROM account_deletions WHERE user_id = $1 ORDER BY event_id DESC LIMIT 1",
+		mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "No account deletion found for user")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch account deletion")
+		return
+	}
+	utils.SuccessResponse(w, deletion)
+}
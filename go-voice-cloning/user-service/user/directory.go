@@ -0,0 +1,165 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultDirectoryPageSize = 50
+	maxDirectoryPageSize     = 200
+)
+
+// directorySort lists the directory newest first
+var directorySort = types.PageSort{Name: "-id", Column: "id", Descending: true}
+
+// directoryCursor is the position after the last user of a directory page
+type directoryCursor struct {
+	ID int `json:"id"`
+}
+
+// likeEscaper escapes LIKE wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// listDirectory searches users for the support team, newest first (admin).
+// ?q= matches part of the email or username; ?role= and ?plan= filter
+// exactly. Closed accounts are left out unless ?deleted=true, which lists
+// only them. Supports ?limit=/?cursor= pagination.
+func (s *UserService) listDirectory(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	query := r.URL.Query()
+	page, err := types.ParsePageRequest(query, defaultDirectoryPageSize, maxDirectoryPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	where := []string{"deleted_at IS NULL"}
+	if query.Get("deleted") == "true" {
+		where[0] = "deleted_at IS NOT NULL"
+	}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		p := arg("%" + likeEscaper.Replace(q) + "%")
+		where = append(where, "(email ILIKE "+p+" OR username ILIKE "+p+")")
+	}
+	if role := query.Get("role"); role != "" {
+		where = append(where, "role = "+arg(role))
+	}
+	if plan := query.Get("plan"); plan != "" {
+		where = append(where, "plan = "+arg(plan))
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM users WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to search users")
+		return
+	}
+
+	var cursor directoryCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, directorySort.After(nil, cursor.ID, arg))
+	}
+
+	users := []types.DirectoryUser{}
+	err = db.SelectContext(r.Context(), &users,
+		`SELECT id, email, username, role, created_at, updated_at, plan, deleted_at FROM users
+		WHERE `+strings.Join(where, " AND ")+" "+directorySort.OrderBy()+" "+page.LimitClause(arg), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to search users")
+		return
+	}
+
+	utils.SuccessResponse(w, types.NewPage(page, users, total, func(last types.DirectoryUser) interface{} {
+		return directoryCursor{ID: last.ID}
+	}))
+}
+
+// getDirectoryUser shows a user's profile with the number of their clones
+// in each status and their storage usage (admin)
+func (s *UserService) getDirectoryUser(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || userID <= 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	var user types.DirectoryUserDetail
+	err = dbroute.Reader(r, s.db, s.replica).GetContext(r.Context(), &user,
+		`SELECT u.id, u.email, u.username, u.role, u.created_at, u.updated_at, u.plan, u.deleted_at,
+			COALESCE(up.first_name, '') as first_name,
+			COALESCE(up.last_name, '') as last_name,
+			COALESCE(up.bio, '') as bio,
+			COALESCE(up.timezone, $2) as timezone,
+			COALESCE(up.locale, $3) as locale,
+			`+avatarURLColumn+`
+		FROM users u
+		LEFT JOIN user_profiles up ON u.id = up.user_id
+		LEFT JOIN user_avatars ua ON u.id = ua.user_id
+		WHERE u.id = $1`,
+		userID, locale.DefaultTimezone, locale.DefaultLocale)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch user")
+		return
+	}
+
+	user.Clones = s.cloneCounts(r, userID)
+	user.Storage = s.storageUsage(r, fmt.Sprintf("%s/usage?user_id=%d", s.storageServiceURL, userID))
+	utils.SuccessResponse(w, user)
+}
+
+// cloneCounts fetches the number of a user's clones in each status from the
+// voice service's analytics, nil if the voice service doesn't answer
+func (s *UserService) cloneCounts(r *http.Request, userID int) map[string]int {
+	req, err := forwardedRequest(r, fmt.Sprintf("%s/clones/analytics?days=1&user_id=%d", s.voiceServiceURL, userID))
+	if err != nil {
+		return nil
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to fetch clones", "user_id", userID, "error", err)
+		return nil
+	}
+	defer res.Body.Close()
+
+	var analytics types.CloneAnalytics
+	if res.StatusCode != http.StatusOK || utils.DecodeData(res, &analytics) != nil {
+		logging.FromContext(r.Context()).Error("Failed to fetch clones", "user_id", userID, "status", res.StatusCode)
+		return nil
+	}
+	return analytics.Clones
+}
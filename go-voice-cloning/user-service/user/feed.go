@@ -0,0 +1,109 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultActivityPageSize = 20
+	maxActivityPageSize     = 100
+)
+
+// activityCursor is the position after the last entry of a feed page
+type activityCursor struct {
+	ID int64 `json:"id"`
+}
+
+// getActivityFeed lists what happened recently on the caller's account,
+// newest first: clones created, completed or failed, files uploaded,
+// profile changes and logins from new devices. Supports ?kind=, which can
+// be repeated, and ?limit=/?cursor= pagination. Times are in the caller's
+// timezone.
+func (s *UserService) getActivityFeed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	limit, err := types.ParseLimit(r.URL.Query(), defaultActivityPageSize, maxActivityPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if kinds := r.URL.Query()["kind"]; len(kinds) > 0 {
+		placeholders := make([]string, len(kinds))
+		for i, kind := range kinds {
+			placeholders[i] = arg(kind)
+		}
+		where = append(where, "kind IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM user_activity WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch activity")
+		return
+	}
+
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var cursor activityCursor
+		if err := types.DecodeCursor(c, &cursor); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, "id < "+arg(cursor.ID))
+	}
+
+	activity := []types.Activity{}
+	err = db.SelectContext(r.Context(), &activity,
+		`SELECT id, kind, COALESCE(subject, '') AS subject, details, created_at FROM user_activity
+		WHERE `+strings.Join(where, " AND ")+" ORDER BY id DESC LIMIT "+arg(limit+1), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch activity")
+		return
+	}
+	settings, err := locale.ForUser(r.Context(), db, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch activity")
+		return
+	}
+	loc := settings.Location()
+	for i := range activity {
+		activity[i].OccurredAt = activity[i].OccurredAt.In(loc)
+	}
+
+	page := types.Pagination{Limit: limit, Total: total}
+	if len(activity) > limit {
+		activity = activity[:limit]
+		page.NextCursor = types.EncodeCursor(activityCursor{ID: activity[len(activity)-1].ID})
+	}
+	utils.SuccessResponse(w, types.PageResponse{Data: activity, Pagination: page})
+}
+
+// recordProfileUpdate adds a profile.updated entry, listing the fields
+// changed, to the user's feed. A failure is logged; the change stands.
+func (s *UserService) recordProfileUpdate(r *http.Request, userID int, fields ...string) {
+	err := events.RecordActivity(r.Context(), s.db, userID, types.ActivityProfileUpdated, "", map[string][]string{"fields": fields})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to record profile update in the activity feed", "error", err)
+	}
+}
@@ -0,0 +1,358 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const maxImportRows = 1000
+
+// Import row outcomes
+const (
+	importStatusInvited        = "invited"
+	importStatusReinvited      = "reinvited"
+	importStatusAlreadyInvited = "already_invited"
+	importStatusExists         = "exists"
+	importStatusFailed         = "failed"
+)
+
+// ImportUser is a single row of a bulk import batch
+type ImportUser struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Org   string `json:"org"`
+}
+
+// ImportResult reports what happened to a single row
+type ImportResult struct {
+	Row       int    `json:"row"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	UserID    int    `json:"user_id,omitempty"`
+	EmailSent bool   `json:"email_sent"`
+	Error     string `json:"error,omitempty"`
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// importUsers creates accounts for a batch of users and emails each of them an
+// invitation. Rows are keyed by email so re-running the same batch is safe:
+// existing accounts are reported and skipped rather than duplicated.
+func (s *UserService) importUsers(w http.ResponseWriter, r *http.Request) {
+	adminID := middleware.UserID(r)
+	if adminID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditImportUsers, "user", "", audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	rows, err := parseImportBody(r)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "No users provided")
+		return
+	}
+	if len(rows) > maxImportRows {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("Batch exceeds %d rows", maxImportRows))
+		return
+	}
+
+	resend := r.URL.Query().Get("resend") == "true"
+	summary := map[string]int{}
+	results := make([]ImportResult, 0, len(rows))
+	for i, row := range rows {
+		result := s.importUser(row, adminID, resend)
+		result.Row = i + 1
+		summary[result.Status]++
+		results = append(results, result)
+	}
+	s.audit.Request(r, auditImportUsers, "user", "", audit.Success, map[string]interface{}{"total": len(rows), "summary": summary})
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"total":   len(rows),
+		"summary": summary,
+		"results": results,
+	})
+}
+
+func (s *UserService) importUser(row ImportUser, adminID int, resend bool) ImportResult {
+	result := ImportResult{Email: strings.ToLower(strings.TrimSpace(row.Email))}
+
+	addr, err := mail.ParseAddress(result.Email)
+	if err != nil || addr.Address != result.Email {
+		result.Status = importStatusFailed
+		result.Error = "Invalid email address"
+		return result
+	}
+
+	role := strings.ToLower(strings.TrimSpace(row.Role))
+	if role == "" {
+		role = types.RoleUser
+	}
+	if role != types.RoleUser && role != types.RoleAdmin {
+		result.Status = importStatusFailed
+		result.Error = fmt.Sprintf("Unknown role %q", row.Role)
+		return result
+	}
+
+	// Existing accounts are never modified, only reported
+	var existing struct {
+		ID         int        `db:"id"`
+		Activated  bool       `db:"activated"`
+		Token      *string    `db:"token"`
+		ExpiresAt  *time.Time `db:"expires_at"`
+		AcceptedAt *time.Time `db:"accepted_at"`
+	}
+	err = s.db.Get(&existing,
+		`SELECT u.id, u.password <> '!' AS activated, i.token, i.expires_at, i.accepted_at
+		FROM users u
+		LEFT JOIN user_invitations i ON i.user_id = u.id
+		WHERE u.email = $1`,
+		result.Email)
+	if err == nil {
+		result.UserID = existing.ID
+		if existing.Activated || existing.AcceptedAt != nil {
+			result.Status = importStatusExists
+			return result
+		}
+
+		// Invitations that expired (or were purged) are re-issued
+		if existing.Token == nil || (existing.ExpiresAt != nil && existing.ExpiresAt.Before(time.Now())) {
+			token, err := s.reissueInvitation(existing.ID, row.Org, adminID)
+			if err != nil {
+				result.Status = importStatusFailed
+				result.Error = "Failed to re-issue invitation"
+				return result
+			}
+			result.Status = importStatusReinvited
+			result.EmailSent = s.sendInvitation(result.Email, token)
+			return result
+		}
+
+		result.Status = importStatusAlreadyInvited
+		if resend {
+			result.EmailSent = s.sendInvitation(result.Email, *existing.Token)
+		}
+		return result
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		result.Status = importStatusFailed
+		result.Error = "Failed to generate invitation"
+		return result
+	}
+
+	userID, err := s.createInvitedUser(result.Email, row, role, token, adminID)
+	if err != nil {
+		result.Status = importStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	result.UserID = userID
+	result.Status = importStatusInvited
+	result.EmailSent = s.sendInvitation(result.Email, token)
+	return result
+}
+
+func (s *UserService) createInvitedUser(email string, row ImportUser, role, token string, adminID int) (int, error) {
+	username, err := s.availableUsername(email)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create user")
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	// The password is left unusable until the invitation is accepted
+	var userID int
+	err = tx.QueryRow(
+		"INSERT INTO users (email, username, password, role, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		email, username, "!", role, now, now,
+	).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create user")
+	}
+
+	firstName, lastName := splitName(row.Name)
+	_, err = tx.Exec(
+		"INSERT INTO user_profiles (user_id, first_name, last_name, bio, updated_at) VALUES ($1, $2, $3, '', $4)",
+		userID, firstName, lastName, now)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create profile")
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_invitations (user_id, token, org, invited_by, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		userID, token, strings.TrimSpace(row.Org), adminID, now, now.Add(s.inviteTTL))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create invitation")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("Failed to create user")
+	}
+	return userID, nil
+}
+
+func (s *UserService) reissueInvitation(userID int, org string, adminID int) (string, error) {
+	token, err := generateInviteToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = s.db.Exec(
+		`INSERT INTO user_invitations (user_id, token, org, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET
+			token = EXCLUDED.token,
+			invited_by = EXCLUDED.invited_by,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at`,
+		userID, token, strings.TrimSpace(org), adminID, now, now.Add(s.inviteTTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// availableUsername derives a username from the email's local part, adding a
+// numeric suffix when it is already taken
+func (s *UserService) availableUsername(email string) (string, error) {
+	base := usernameInvalidChars.ReplaceAllString(strings.ToLower(strings.SplitN(email, "@", 2)[0]), "_")
+	if len(base) > 16 {
+		base = base[:16]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 1; i <= 100; i++ {
+		var taken bool
+		if err := s.db.Get(&taken, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", candidate); err != nil {
+			return "", fmt.Errorf("Failed to check username")
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+	return "", fmt.Errorf("Could not find an available username")
+}
+
+func (s *UserService) sendInvitation(email, token string) bool {
+	body := fmt.Sprintf("You have been invited to Voice Cloning.\n\nSet your password to activate your account:\n%s?token=%s\n",
+		s.inviteURL, token)
+	if err := s.mailer.Send(email, "You're invited to Voice Cloning", body); err != nil {
+		return false
+	}
+	return true
+}
+
+func parseImportBody(r *http.Request) ([]ImportUser, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		return parseImportCSV(r.Body)
+	}
+
+	var req struct {
+		Users []ImportUser `json:"users"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("Invalid request body")
+	}
+	return req.Users, nil
+}
+
+// parseImportCSV reads a CSV with a header row. Columns are matched by name so
+// their order does not matter; only email is required.
+func parseImportCSV(body io.Reader) ([]ImportUser, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("CSV must contain an email column")
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var users []ImportUser
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV: %v", err)
+		}
+		users = append(users, ImportUser{
+			Email: field(record, "email"),
+			Name:  field(record, "name"),
+			Role:  field(record, "role"),
+			Org:   field(record, "org"),
+		})
+		if len(users) > maxImportRows {
+			break
+		}
+	}
+	return users, nil
+}
+
+func splitName(name string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+	if len(parts) == 2 {
+		return parts[0], strings.TrimSpace(parts[1])
+	}
+	return parts[0], ""
+}
+
+func generateInviteToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
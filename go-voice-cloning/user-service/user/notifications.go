@@ -0,0 +1,344 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/i18n"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/types"
+)
+
+// Notifications are emails sent to users. Each is claimed from the event it
+// is about (source_id is the clone lifecycle event or the activity entry,
+// and for digests the Unix day of the Monday ending their week), so it is
+// sent once however many replicas dispatch. The user's preferences are
+// checked when it is due: opted out notifications are skipped and those
+// falling in quiet hours wait for them to end.
+
+// Notification kinds
+const (
+	NotificationCloneCompleted = "clone.completed"
+	NotificationWeeklyDigest   = "digest.weekly"
+	NotificationSecurityAlert  = "security.new_device"
+)
+
+// Notification statuses
+const (
+	NotificationPending = "pending"
+	NotificationSending = "sending"
+	NotificationSent    = "sent"
+	NotificationSkipped = "skipped"
+	NotificationFailed  = "failed"
+)
+
+const (
+	// notificationMaxAge keeps events older than this from being notified,
+	// such as those published before the dispatcher first ran
+	notificationMaxAge = 24 * time.Hour
+	// notificationStaleAfter is how long a notification can be sending
+	// before another replica takes it over
+	notificationStaleAfter = 10 * time.Minute
+	// maxNotificationAttempts is how many times sending is tried
+	maxNotificationAttempts = 5
+)
+
+// notificationPrefs maps each kind to the preference opting in or out of it
+var notificationPrefs = map[string]string{
+	NotificationCloneCompleted: types.PrefNotifyCloneCompleted,
+	NotificationWeeklyDigest:   types.PrefNotifyWeeklyDigest,
+	NotificationSecurityAlert:  types.PrefNotifySecurityAlerts,
+}
+
+// Notification is an email due to, or sent to, a user
+type Notification struct {
+	ID        int64           `db:"id"`
+	UserID    int             `db:"user_id"`
+	Kind      string          `db:"kind"`
+	SourceID  int64           `db:"source_id"`
+	Details   json.RawMessage `db:"details"`
+	Status    string          `db:"status"`
+	Attempts  int             `db:"attempts"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+const notificationColumns = "id, user_id, kind, source_id, details, status, attempts, created_at"
+
+// runNotifications claims notifications from clone lifecycle events, new
+// device logins and the weekly digest, and sends those due, every interval
+func (s *UserService) runNotifications(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.claimNotifications(ctx, time.Now().UTC()); err != nil {
+			slog.Error("Failed to read notification events", "error", err)
+		}
+		for ctx.Err() == nil {
+			n, err := s.nextNotification(ctx)
+			if err != nil {
+				slog.Error("Failed to start notification", "error", err)
+				break
+			}
+			if n == nil {
+				break
+			}
+			s.dispatchNotification(ctx, n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimNotifications records a pending notification for every event not
+// seen yet, and a digest of last week for the users who asked for one
+func (s *UserService) claimNotifications(ctx context.Context, now time.Time) error {
+	since := now.Add(-notificationMaxAge)
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO notifications (user_id, kind, source_id, details, status)
+		SELECT e.user_id, $1, e.id, jsonb_build_object('clone_id', e.clone_id, 'name', COALESCE(c.name, '')), $2
+		FROM clone_lifecycle_events e LEFT JOIN voice_clones c ON c.id = e.clone_id
+		WHERE e.event = $3 AND e.created_at > $4
+			AND e.id > (SELECT COALESCE(MAX(source_id), 0) FROM notifications WHERE kind = $1)
+		ON CONFLICT (kind, user_id, source_id) DO NOTHING`,
+		NotificationCloneCompleted, NotificationPending, types.LifecycleCloneCompleted, since)
+	if err != nil {
+		return fmt.Errorf("clone events: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO notifications (user_id, kind, source_id, details, status)
+		SELECT user_id, $1, id, details || jsonb_build_object('at', to_char(created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"')), $2
+		FROM user_activity
+		WHERE kind = $3 AND created_at > $4
+			AND id > (SELECT COALESCE(MAX(source_id), 0) FROM notifications WHERE kind = $1)
+		ON CONFLICT (kind, user_id, source_id) DO NOTHING`,
+		NotificationSecurityAlert, NotificationPending, types.ActivityNewDeviceLogin, since)
+	if err != nil {
+		return fmt.Errorf("login activity: %w", err)
+	}
+
+	// Weeks start on Monday (UTC); the digest covers the week before
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	to = to.AddDate(0, 0, -((int(to.Weekday()) + 6) % 7))
+	from := to.AddDate(0, 0, -7)
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO notifications (user_id, kind, source_id, details, status)
+		SELECT u.id, $1, $2, jsonb_build_object('from', $3::text, 'to', $4::text), $5
+		FROM users u JOIN user_preferences p ON p.user_id = u.id
+		WHERE u.deleted_at IS NULL AND p.preferences->>$6 = 'true'
+		ON CONFLICT (kind, user_id, source_id) DO NOTHING`,
+		NotificationWeeklyDigest, to.Unix()/86400, from.Format(time.RFC3339), to.Format(time.RFC3339), NotificationPending, types.PrefNotifyWeeklyDigest)
+	if err != nil {
+		return fmt.Errorf("weekly digest: %w", err)
+	}
+	return nil
+}
+
+// nextNotification claims the oldest notification due, or one whose
+// replica stopped while sending it
+func (s *UserService) nextNotification(ctx context.Context) (*Notification, error) {
+	var n Notification
+	err := s.db.GetContext(ctx, &n,
+		`UPDATE notifications SET status = $1, attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM notifications
+			WHERE (status = $2 AND send_after <= NOW()) OR (status = $1 AND updated_at < $3)
+			ORDER BY send_after, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING `+notificationColumns,
+		NotificationSending, NotificationPending, time.Now().Add(-notificationStaleAfter))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// dispatchNotification sends a notification unless its user opted out, or
+// defers it to the end of their quiet hours. Security alerts are sent
+// during quiet hours. A failed send is retried with backoff until it has
+// been tried maxNotificationAttempts times.
+func (s *UserService) dispatchNotification(ctx context.Context, n *Notification) {
+	var user struct {
+		Email     string     `db:"email"`
+		DeletedAt *time.Time `db:"deleted_at"`
+	}
+	err := s.db.GetContext(ctx, &user, "SELECT email, deleted_at FROM users WHERE id = $1", n.UserID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.DeletedAt != nil) {
+		s.finishNotification(ctx, n, NotificationSkipped, "account closed", 0)
+		return
+	}
+	if err != nil {
+		s.retryNotification(ctx, n, err)
+		return
+	}
+
+	stored, err := loadPreferences(ctx, s.db, n.UserID, false)
+	if err != nil {
+		s.retryNotification(ctx, n, err)
+		return
+	}
+	prefs := stored.response().Values
+	var channels []string
+	json.Unmarshal(prefs[types.PrefNotificationChannels], &channels)
+	if !contains(channels, "email") || !prefBool(prefs, notificationPrefs[n.Kind]) {
+		s.finishNotification(ctx, n, NotificationSkipped, "opted out", 0)
+		return
+	}
+	settings, err := locale.ForUser(ctx, s.db, n.UserID)
+	if err != nil {
+		s.retryNotification(ctx, n, err)
+		return
+	}
+	if n.Kind != NotificationSecurityAlert {
+		if until, quiet := quietUntil(prefs, settings.Location(), time.Now()); quiet {
+			s.finishNotification(ctx, n, NotificationPending, "", time.Until(until))
+			return
+		}
+	}
+
+	subject, body, err := s.renderNotification(ctx, n, settings)
+	if err != nil {
+		s.retryNotification(ctx, n, err)
+		return
+	}
+	if err := s.mailer.Send(user.Email, subject, body); err != nil {
+		s.retryNotification(ctx, n, err)
+		return
+	}
+	s.finishNotification(ctx, n, NotificationSent, "", 0)
+}
+
+// retryNotification puts a failed notification back, due after a minute
+// per attempt, or fails it after its last attempt
+func (s *UserService) retryNotification(ctx context.Context, n *Notification, err error) {
+	slog.Warn("Failed to send notification", "kind", n.Kind, "notification_id", n.ID, "user_id", n.UserID, "attempt", n.Attempts, "error", err)
+	if n.Attempts >= maxNotificationAttempts {
+		s.finishNotification(ctx, n, NotificationFailed, err.Error(), 0)
+		return
+	}
+	s.finishNotification(ctx, n, NotificationPending, err.Error(), time.Duration(n.Attempts)*time.Minute)
+}
+
+// finishNotification records the outcome of a dispatch. A deferred
+// notification doesn't count the attempt.
+func (s *UserService) finishNotification(ctx context.Context, n *Notification, status, errMsg string, sendIn time.Duration) {
+	attempts := n.Attempts
+	if status == NotificationPending && errMsg == "" {
+		attempts--
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE notifications SET status = $1, error = NULLIF($2, ''), attempts = $3, send_after = $4, updated_at = NOW(),
+			sent_at = CASE WHEN $1 = $5 THEN NOW() END
+		WHERE id = $6`,
+		status, errMsg, attempts, time.Now().Add(sendIn), NotificationSent, n.ID)
+	if err != nil {
+		slog.Error("Failed to save notification", "notification_id", n.ID, "error", err)
+	}
+}
+
+// renderNotification writes the email of a notification in the user's
+// language, with times in their timezone and locale
+func (s *UserService) renderNotification(ctx context.Context, n *Notification, settings locale.Settings) (string, string, error) {
+	lang, _ := i18n.Match(settings.Locale)
+	switch n.Kind {
+	case NotificationCloneCompleted:
+		var details struct {
+			CloneID int    `json:"clone_id"`
+			Name    string `json:"name"`
+		}
+		if err := json.Unmarshal(n.Details, &details); err != nil {
+			return "", "", err
+		}
+		return i18n.T(lang, i18n.CloneCompletedSubject, details.Name),
+			i18n.T(lang, i18n.CloneCompletedBody, details.Name, details.CloneID), nil
+
+	case NotificationSecurityAlert:
+		var details struct {
+			UserAgent string    `json:"user_agent"`
+			IP        string    `json:"ip"`
+			At        time.Time `json:"at"`
+		}
+		if err := json.Unmarshal(n.Details, &details); err != nil {
+			return "", "", err
+		}
+		return i18n.T(lang, i18n.SecurityAlertSubject),
+			i18n.T(lang, i18n.SecurityAlertBody, settings.FormatTime(details.At), details.UserAgent, details.IP), nil
+
+	case NotificationWeeklyDigest:
+		return s.renderDigest(ctx, n, settings, lang)
+	}
+	return "", "", fmt.Errorf("unknown notification kind %q", n.Kind)
+}
+
+// renderDigest summarizes the user's activity and synthesis over the week
+// of a digest
+func (s *UserService) renderDigest(ctx context.Context, n *Notification, settings locale.Settings, lang string) (string, string, error) {
+	var period struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	}
+	if err := json.Unmarshal(n.Details, &period); err != nil {
+		return "", "", err
+	}
+	var counts []struct {
+		Kind  string `db:"kind"`
+		Count int    `db:"count"`
+	}
+	err := s.db.SelectContext(ctx, &counts,
+		`SELECT kind, COUNT(*) AS count FROM user_activity
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3 GROUP BY kind`,
+		n.UserID, period.From, period.To)
+	if err != nil {
+		return "", "", err
+	}
+	byKind := map[string]int{}
+	for _, c := range counts {
+		byKind[c.Kind] = c.Count
+	}
+	var synthesisMS int64
+	err = s.db.GetContext(ctx, &synthesisMS,
+		`SELECT COALESCE(SUM(quantity), 0) FROM usage_events
+		WHERE user_id = $1 AND metric = $2 AND occurred_at >= $3 AND occurred_at < $4`,
+		n.UserID, types.UsageSynthesisMS, period.From, period.To)
+	if err != nil {
+		return "", "", err
+	}
+
+	var body strings.Builder
+	body.WriteString(i18n.T(lang, i18n.DigestPeriod, settings.FormatDate(period.From), settings.FormatDate(period.To.AddDate(0, 0, -1))))
+	body.WriteString(i18n.T(lang, i18n.DigestClonesCreated, byKind[types.ActivityCloneCreated]))
+	body.WriteString(i18n.T(lang, i18n.DigestClonesCompleted, byKind[types.ActivityCloneCompleted]))
+	body.WriteString(i18n.T(lang, i18n.DigestClonesFailed, byKind[types.ActivityCloneFailed]))
+	body.WriteString(i18n.T(lang, i18n.DigestFilesUploaded, byKind[types.ActivityFileUploaded]))
+	body.WriteString(i18n.T(lang, i18n.DigestMinutesSynthesized, float64(synthesisMS)/float64(time.Minute/time.Millisecond)))
+	return i18n.T(lang, i18n.DigestSubject), body.String(), nil
+}
+
+// prefBool reads a boolean preference
+func prefBool(prefs map[string]json.RawMessage, key string) bool {
+	var value bool
+	json.Unmarshal(prefs[key], &value)
+	return value
+}
+
+// quietUntil reports whether now falls in the user's quiet hours, in their
+// time zone, and when those end
+func quietUntil(prefs map[string]json.RawMessage, loc *time.Location, now time.Time) (time.Time, bool) {
+	var start, end string
+	json.Unmarshal(prefs[types.PrefQuietHoursStart], &start)
+	json.Unmarshal(prefs[types.PrefQuietHoursEnd], &end)
+	return locale.QuietUntil(start, end, loc, now)
+}
@@ -0,0 +1,541 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Invitations to join an org. The invitee accepts with the emailed token
+// while logged in to the account with the invited email address.
+
+const maxOrgNameLength = 255
+
+const orgInvitationColumns = "id, org_id, email, role, invited_by, created_at, expires_at, accepted_at"
+
+// roleRank orders the roles: an owner outranks an admin, who outranks a
+// member
+func roleRank(role string) int {
+	for i, r := range types.OrgRoles {
+		if r == role {
+			return len(types.OrgRoles) - i
+		}
+	}
+	return 0
+}
+
+func validOrgRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+// orgMember checks the caller's membership of the org in the path and that
+// their role is at least minRole. It writes the error response and reports
+// false otherwise; non-members are told the org doesn't exist.
+func (s *UserService) orgMember(w http.ResponseWriter, r *http.Request, minRole string) (orgID, userID int, role string, ok bool) {
+	userID = middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return 0, 0, "", false
+	}
+	orgID, err := strconv.Atoi(mux.Vars(r)["org"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid organization ID")
+		return 0, 0, "", false
+	}
+	role, err = orgs.Role(r.Context(), s.db, orgID, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch organization")
+		return 0, 0, "", false
+	}
+	if role == "" {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.OrganizationNotFound, "Organization not found")
+		return 0, 0, "", false
+	}
+	if roleRank(role) < roleRank(minRole) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.OrgRoleRequired, fmt.Sprintf("Organization %s role required", minRole))
+		return 0, 0, "", false
+	}
+	return orgID, userID, role, true
+}
+
+// createOrg creates an org owned by the caller
+func (s *UserService) createOrg(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxOrgNameLength {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("name is required, at most %d characters", maxOrgNameLength))
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create organization")
+		return
+	}
+	defer tx.Rollback()
+
+	org := types.Organization{Name: req.Name, CreatedBy: userID, Role: types.OrgRoleOwner}
+	err = tx.GetContext(r.Context(), &org,
+		"INSERT INTO organizations (name, created_by) VALUES ($1, $2) RETURNING id, created_at", req.Name, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create organization")
+		return
+	}
+	_, err = tx.ExecContext(r.Context(),
+		"INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)", org.ID, userID, types.OrgRoleOwner)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create organization")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create organization")
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, org)
+}
+
+// listOrgs lists the orgs the caller belongs to, with their role
+func (s *UserService) listOrgs(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	list := []types.Organization{}
+	err := s.db.SelectContext(r.Context(), &list,
+		`SELECT o.id, o.name, o.created_by, o.created_at, m.role
+		FROM organizations o JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id = $1 ORDER BY o.name, o.id`, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch organizations")
+		return
+	}
+	utils.SuccessResponse(w, list)
+}
+
+// memberCursor is the keyset position after the last member of a page
+type memberCursor struct {
+	JoinedAt time.Time `json:"j"`
+	UserID   int       `json:"user_id"`
+}
+
+// listOrgMembers lists an org's members in the order they joined, for its
+// members. Supports ?limit=/?cursor= pagination.
+func (s *UserService) listOrgMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _, ok := s.orgMember(w, r, types.OrgRoleMember)
+	if !ok {
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultDirectoryPageSize, maxDirectoryPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM org_members WHERE org_id = $1", orgID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch members")
+		return
+	}
+
+	where := []string{"m.org_id = $1"}
+	args := []interface{}{orgID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var cursor memberCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, fmt.Sprintf("(m.joined_at, m.user_id) > (%s, %s)", arg(cursor.JoinedAt), arg(cursor.UserID)))
+	}
+
+	members := []types.OrgMember{}
+	err = s.db.SelectContext(r.Context(), &members,
+		`SELECT m.org_id, m.user_id, u.email, u.username, m.role, m.joined_at
+		FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE `+strings.Join(where, " AND ")+` ORDER BY m.joined_at, m.user_id `+page.LimitClause(arg), args...)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch members")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, members, total, func(last types.OrgMember) interface{} {
+		return memberCursor{JoinedAt: last.JoinedAt, UserID: last.UserID}
+	}))
+}
+
+// getOrgMembership reports a user's membership of an org, 404 if they
+// aren't a member (internal, for the gateway's X-Org-ID check)
+func (s *UserService) getOrgMembership(w http.ResponseWriter, r *http.Request) {
+	var member types.OrgMember
+	err := s.db.GetContext(r.Context(), &member,
+		"SELECT org_id, user_id, role, joined_at FROM org_members WHERE org_id = $1 AND user_id = $2",
+		mux.Vars(r)["org"], mux.Vars(r)["user_id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.MemberNotFound, "Not a member")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch membership")
+		return
+	}
+	utils.SuccessResponse(w, member)
+}
+
+// setOrgMemberRole changes a member's role (owners only). The last owner
+// can't be demoted.
+func (s *UserService) setOrgMemberRole(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _, ok := s.orgMember(w, r, types.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if !validOrgRole(req.Role) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("role must be one of %v", types.OrgRoles))
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update member")
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := lockedMemberRole(r, tx, orgID, memberID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update member")
+		return
+	}
+	if current == "" {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.MemberNotFound, "Member not found")
+		return
+	}
+	if current == types.OrgRoleOwner && req.Role != types.OrgRoleOwner {
+		last, err := lastOwner(r, tx, orgID)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update member")
+			return
+		}
+		if last {
+			utils.ErrorResponse(w, http.StatusConflict, apierror.LastOwner, "An organization needs at least one owner")
+			return
+		}
+	}
+
+	var member types.OrgMember
+	err = tx.GetContext(r.Context(), &member,
+		"UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3 RETURNING org_id, user_id, role, joined_at",
+		req.Role, orgID, memberID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update member")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update member")
+		return
+	}
+	s.audit.Request(r, auditSetOrgMemberRole, "org_member", fmt.Sprintf("%d/%d", orgID, memberID), audit.Success,
+		map[string]interface{}{"from": current, "to": req.Role})
+	utils.SuccessResponse(w, member)
+}
+
+// removeOrgMember removes a member, or lets a member leave. Admins remove
+// members; owners remove anyone. The last owner can't leave.
+func (s *UserService) removeOrgMember(w http.ResponseWriter, r *http.Request) {
+	orgID, userID, role, ok := s.orgMember(w, r, types.OrgRoleMember)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove member")
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := lockedMemberRole(r, tx, orgID, memberID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove member")
+		return
+	}
+	if current == "" {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.MemberNotFound, "Member not found")
+		return
+	}
+	if memberID != userID && (roleRank(role) < roleRank(types.OrgRoleAdmin) ||
+		(role != types.OrgRoleOwner && current != types.OrgRoleMember)) {
+		s.audit.Request(r, auditRemoveOrgMember, "org_member", fmt.Sprintf("%d/%d", orgID, memberID), audit.Denied,
+			map[string]interface{}{"role": current})
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.OrgRoleRequired, "Only owners can remove admins and owners, and admins members")
+		return
+	}
+	if current == types.OrgRoleOwner {
+		last, err := lastOwner(r, tx, orgID)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove member")
+			return
+		}
+		if last {
+			utils.ErrorResponse(w, http.StatusConflict, apierror.LastOwner, "An organization needs at least one owner")
+			return
+		}
+	}
+
+	if _, err := tx.ExecContext(r.Context(), "DELETE FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, memberID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove member")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to remove member")
+		return
+	}
+	s.audit.Request(r, auditRemoveOrgMember, "org_member", fmt.Sprintf("%d/%d", orgID, memberID), audit.Success,
+		map[string]interface{}{"role": current})
+	utils.SuccessResponse(w, map[string]string{"message": "Member removed"})
+}
+
+// lockedMemberRole returns a member's role, locking the org's owners so
+// concurrent changes can't leave it without one
+func lockedMemberRole(r *http.Request, tx *sqlx.Tx, orgID, userID int) (string, error) {
+	if _, err := tx.ExecContext(r.Context(), "SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE", orgID); err != nil {
+		return "", err
+	}
+	return orgs.Role(r.Context(), tx, orgID, userID)
+}
+
+func lastOwner(r *http.Request, tx *sqlx.Tx, orgID int) (bool, error) {
+	var owners int
+	err := tx.GetContext(r.Context(), &owners,
+		"SELECT COUNT(*) FROM org_members WHERE org_id = $1 AND role = $2", orgID, types.OrgRoleOwner)
+	return owners <= 1, err
+}
+
+// inviteToOrg invites an email address to the org (admins and owners).
+// Only owners can invite admins and owners.
+func (s *UserService) inviteToOrg(w http.ResponseWriter, r *http.Request) {
+	orgID, userID, role, ok := s.orgMember(w, r, types.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var req types.OrgInvitationRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid email address")
+		return
+	}
+	if req.Role == "" {
+		req.Role = types.OrgRoleMember
+	}
+	if !validOrgRole(req.Role) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("role must be one of %v", types.OrgRoles))
+		return
+	}
+	if req.Role != types.OrgRoleMember && role != types.OrgRoleOwner {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.OrgRoleRequired, "Only owners can invite admins and owners")
+		return
+	}
+
+	var member bool
+	err := s.db.GetContext(r.Context(), &member,
+		`SELECT EXISTS (SELECT 1 FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND LOWER(u.email) = $2)`, orgID, req.Email)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create invitation")
+		return
+	}
+	if member {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.AlreadyMember, "Already a member")
+		return
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create invitation")
+		return
+	}
+	now := time.Now()
+	invitation := types.OrgInvitation{
+		OrgID:     orgID,
+		Email:     req.Email,
+		Role:      req.Role,
+		Token:     token,
+		InvitedBy: userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.inviteTTL),
+	}
+	err = s.db.GetContext(r.Context(), &invitation.ID,
+		`INSERT INTO org_invitations (org_id, email, role, token, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		orgID, invitation.Email, invitation.Role, token, userID, now, invitation.ExpiresAt)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to create invitation")
+		return
+	}
+
+	var name string
+	s.db.GetContext(r.Context(), &name, "SELECT name FROM organizations WHERE id = $1", orgID)
+	body := fmt.Sprintf("You have been invited to join %s on Voice Cloning as %s.\n\nLog in and accept the invitation:\n%s?token=%s\n",
+		name, invitation.Role, s.orgInviteURL, token)
+	if err := s.mailer.Send(invitation.Email, "You're invited to join "+name, body); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to email org invitation", "invitation_id", invitation.ID, "org_id", orgID, "error", err)
+	}
+
+	utils.JSONResponse(w, http.StatusCreated, invitation)
+}
+
+// listOrgInvitations lists an org's pending invitations (admins and owners)
+func (s *UserService) listOrgInvitations(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _, ok := s.orgMember(w, r, types.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	invitations := []types.OrgInvitation{}
+	err := s.db.SelectContext(r.Context(), &invitations,
+		"SELECT "+orgInvitationColumns+" FROM org_invitations WHERE org_id = $1 AND accepted_at IS NULL ORDER BY created_at DESC",
+		orgID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch invitations")
+		return
+	}
+	utils.SuccessResponse(w, invitations)
+}
+
+// revokeOrgInvitation deletes a pending invitation (admins and owners)
+func (s *UserService) revokeOrgInvitation(w http.ResponseWriter, r *http.Request) {
+	orgID, _, _, ok := s.orgMember(w, r, types.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(r.Context(),
+		"DELETE FROM org_invitations WHERE id = $1 AND org_id = $2 AND accepted_at IS NULL", mux.Vars(r)["id"], orgID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to revoke invitation")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.InvitationNotFound, "Invitation not found")
+		return
+	}
+	utils.SuccessResponse(w, map[string]string{"message": "Invitation revoked"})
+}
+
+// acceptOrgInvitation adds the caller to the org they were invited to. The
+// invitation must be for the email of their account.
+func (s *UserService) acceptOrgInvitation(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	defer tx.Rollback()
+
+	var invitation types.OrgInvitation
+	err = tx.GetContext(r.Context(), &invitation,
+		"SELECT "+orgInvitationColumns+" FROM org_invitations WHERE token = $1 FOR UPDATE", req.Token)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.InvitationNotFound, "Invitation not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	var email string
+	if err := tx.GetContext(r.Context(), &email, "SELECT email FROM users WHERE id = $1", userID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	switch {
+	case !strings.EqualFold(email, invitation.Email):
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.InvitationMismatch, "The invitation is for another email address")
+		return
+	case invitation.AcceptedAt != nil:
+		utils.ErrorResponse(w, http.StatusConflict, apierror.InvitationAccepted, "Invitation already accepted")
+		return
+	case time.Now().After(invitation.ExpiresAt):
+		utils.ErrorResponse(w, http.StatusGone, apierror.InvitationExpired, "Invitation expired")
+		return
+	}
+
+	var member types.OrgMember
+	err = tx.GetContext(r.Context(), &member,
+		`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = org_members.role
+		RETURNING org_id, user_id, role, joined_at`,
+		invitation.OrgID, userID, invitation.Role)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	if _, err := tx.ExecContext(r.Context(), "UPDATE org_invitations SET accepted_at = NOW() WHERE id = $1", invitation.ID); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to accept invitation")
+		return
+	}
+	utils.SuccessResponse(w, member)
+}
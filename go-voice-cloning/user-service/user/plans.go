@@ -0,0 +1,148 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Plans hold what each subscription plan includes. They start from the
+// defaults and admins can change them; the services enforcing them cache
+// users' entitlements, so a change reaches them within their cache TTL.
+
+const planColumns = `name, max_clones, max_concurrent_jobs, max_processing_jobs, synthesis_minutes_per_month,
+	inactive_clone_days, sample_storage_bytes, output_storage_bytes, max_priority`
+
+// seedPlans adds the plans missing from the table with their defaults
+func seedPlans(db *sqlx.DB) {
+	for _, plan := range types.Plans {
+		e := types.DefaultEntitlements(plan)
+		db.MustExec(
+			`INSERT INTO plans (`+planColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (name) DO NOTHING`,
+			e.Plan, e.MaxClones, e.MaxConcurrentJobs, e.MaxProcessingJobs, e.SynthesisMinutesPerMonth,
+			e.InactiveCloneDays, e.SampleStorageBytes, e.OutputStorageBytes, e.MaxPriority)
+	}
+}
+
+// userEntitlements returns what a user's plan includes with the synthesis
+// minutes their referrals earned, sql.ErrNoRows for unknown users. Users of
+// a plan that isn't in the table get the free plan's entitlements.
+func (s *UserService) userEntitlements(ctx context.Context, userID string) (types.Entitlements, error) {
+	var e types.Entitlements
+	err := s.db.GetContext(ctx, &e,
+		`SELECT `+planColumns+` FROM plans WHERE name = (
+			SELECT CASE WHEN EXISTS (SELECT 1 FROM plans p WHERE p.name = u.plan) THEN u.plan ELSE $2 END
+			FROM users u WHERE u.id = $1)`,
+		userID, types.PlanFree)
+	if err != nil || e.SynthesisMinutesPerMonth == 0 {
+		return e, err
+	}
+
+	conversions, err := referralConversions(ctx, s.db, userID)
+	if err != nil {
+		return e, err
+	}
+	e.BonusSynthesisMinutes = s.referrals.bonus(conversions)
+	e.SynthesisMinutesPerMonth += e.BonusSynthesisMinutes
+	return e, nil
+}
+
+// listPlans lists the plans and what each includes
+func (s *UserService) listPlans(w http.ResponseWriter, r *http.Request) {
+	plans := []types.Entitlements{}
+	err := s.db.SelectContext(r.Context(), &plans,
+		"SELECT "+planColumns+" FROM plans ORDER BY sample_storage_bytes = 0, sample_storage_bytes, name")
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch plans")
+		return
+	}
+	utils.SuccessResponse(w, plans)
+}
+
+// getEntitlements shows what the caller's plan includes
+func (s *UserService) getEntitlements(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	e, err := s.userEntitlements(r.Context(), strconv.Itoa(userID))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch entitlements")
+		return
+	}
+	utils.SuccessResponse(w, e)
+}
+
+// getUserEntitlements shows what a user's plan includes (internal, for the
+// services enforcing it)
+func (s *UserService) getUserEntitlements(w http.ResponseWriter, r *http.Request) {
+	e, err := s.userEntitlements(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch entitlements")
+		return
+	}
+	utils.SuccessResponse(w, e)
+}
+
+// updatePlan changes what a plan includes (admin only). Every entitlement
+// is given; limits of 0 are unlimited.
+func (s *UserService) updatePlan(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		s.audit.Request(r, auditUpdatePlan, "plan", mux.Vars(r)["plan"], audit.Denied, nil)
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+	var e types.Entitlements
+	if !validation.DecodeJSON(w, r, &e) {
+		return
+	}
+	if e.MaxClones < 0 || e.MaxConcurrentJobs < 0 || e.MaxProcessingJobs < 0 || e.SynthesisMinutesPerMonth < 0 ||
+		e.InactiveCloneDays < 0 || e.SampleStorageBytes < 0 || e.OutputStorageBytes < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Limits can't be negative")
+		return
+	}
+	if types.PriorityRank(e.MaxPriority) < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("max_priority must be one of %v", types.Priorities))
+		return
+	}
+
+	e.Plan = mux.Vars(r)["plan"]
+	result, err := s.db.ExecContext(r.Context(),
+		`UPDATE plans SET max_clones = $2, max_concurrent_jobs = $3, max_processing_jobs = $4, synthesis_minutes_per_month = $5,
+			inactive_clone_days = $6, sample_storage_bytes = $7, output_storage_bytes = $8, max_priority = $9,
+			updated_by = $10, updated_at = NOW()
+		WHERE name = $1`,
+		e.Plan, e.MaxClones, e.MaxConcurrentJobs, e.MaxProcessingJobs, e.SynthesisMinutesPerMonth,
+		e.InactiveCloneDays, e.SampleStorageBytes, e.OutputStorageBytes, e.MaxPriority, middleware.UserID(r))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update plan")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.PlanNotFound, "Plan not found")
+		return
+	}
+	logging.FromContext(r.Context()).Info("Admin updated a plan", "plan", e.Plan)
+	s.audit.Request(r, auditUpdatePlan, "plan", e.Plan, audit.Success, map[string]interface{}{"entitlements": e})
+	utils.SuccessResponse(w, e)
+}
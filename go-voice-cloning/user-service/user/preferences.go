@@ -0,0 +1,326 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Preferences are stored as a JSONB object of the keys a user set, with the
+// schema version they were written in. When the schema changes, a migration
+// is added to preferenceMigrations and the version goes up; stored
+// preferences are migrated when read and saved in the new version on the
+// next write.
+
+// preferenceMigration upgrades stored preferences by one version, renaming,
+// converting or dropping keys
+type preferenceMigration func(prefs map[string]json.RawMessage) error
+
+// preferenceMigrations holds the migration from each version to the next:
+// the first upgrades version 1 to 2
+var preferenceMigrations = []preferenceMigration{}
+
+// preferencesVersion is the version preferences are written in
+var preferencesVersion = len(preferenceMigrations) + 1
+
+// preference is a known key: its default and how its values are checked
+type preference struct {
+	def      interface{}
+	validate func(json.RawMessage) error
+}
+
+var (
+	clockTimePattern     = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+	outputFormats        = []string{"wav", "mp3", "flac", "ogg"}
+	notificationChannels = []string{"email", "websocket", "webhook"}
+	themes               = []string{"system", "light", "dark"}
+)
+
+// preferences are the known keys. Others are rejected.
+var preferences = map[string]preference{
+	types.PrefSynthesisLanguage: {"en", func(raw json.RawMessage) error {
+		var tag string
+		if json.Unmarshal(raw, &tag) != nil || !locale.ValidLocale(tag) {
+			return errors.New("must be a BCP 47 tag such as en or pt-BR")
+		}
+		return nil
+	}},
+	types.PrefSynthesisOutputFormat: {"wav", oneOf(outputFormats)},
+	types.PrefNotificationChannels: {[]string{"email", "websocket"}, func(raw json.RawMessage) error {
+		var channels []string
+		if json.Unmarshal(raw, &channels) != nil || channels == nil {
+			return fmt.Errorf("must be a list of %v", notificationChannels)
+		}
+		seen := map[string]bool{}
+		for _, channel := range channels {
+			if !contains(notificationChannels, channel) || seen[channel] {
+				return fmt.Errorf("must be a list of %v, each at most once", notificationChannels)
+			}
+			seen[channel] = true
+		}
+		return nil
+	}},
+	types.PrefNotifyCloneCreated:   {true, boolean},
+	types.PrefNotifyCloneCompleted: {true, boolean},
+	types.PrefNotifyFileUploaded:   {false, boolean},
+	types.PrefNotifyWeeklyDigest:   {false, boolean},
+	types.PrefNotifySecurityAlerts: {true, boolean},
+	types.PrefQuietHoursStart:      {"", clockTime},
+	types.PrefQuietHoursEnd:        {"", clockTime},
+	types.PrefProfilePublic:        {false, boolean},
+	types.PrefProfileSearchable:    {true, boolean},
+	types.PrefUITheme:              {"system", oneOf(themes)},
+	types.PrefUITimezone: {"UTC", func(raw json.RawMessage) error {
+		var name string
+		if json.Unmarshal(raw, &name) != nil || !locale.ValidTimezone(name) {
+			return errors.New("must be an IANA time zone such as Europe/Paris")
+		}
+		return nil
+	}},
+	types.PrefUICompact: {false, boolean},
+}
+
+func boolean(raw json.RawMessage) error {
+	var value bool
+	if json.Unmarshal(raw, &value) != nil {
+		return errors.New("must be true or false")
+	}
+	return nil
+}
+
+// clockTime accepts a time of day as HH:MM, or "" for none
+func clockTime(raw json.RawMessage) error {
+	var value string
+	if json.Unmarshal(raw, &value) != nil || (value != "" && !clockTimePattern.MatchString(value)) {
+		return errors.New(`must be a time of day such as 22:00, or ""`)
+	}
+	return nil
+}
+
+func oneOf(values []string) func(json.RawMessage) error {
+	return func(raw json.RawMessage) error {
+		var value string
+		if json.Unmarshal(raw, &value) != nil || !contains(values, value) {
+			return fmt.Errorf("must be one of %v", values)
+		}
+		return nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// errNewerPreferences is returned for preferences written by a newer
+// version of the service, which this one can't migrate back
+var errNewerPreferences = errors.New("preferences were saved in a newer schema version")
+
+// storedPreferences are the keys a user set, migrated to the current version
+type storedPreferences struct {
+	values    map[string]json.RawMessage
+	updatedAt *time.Time
+}
+
+// loadPreferences reads a user's preferences and migrates them to the
+// current version
+func loadPreferences(ctx context.Context, db sqlx.QueryerContext, userID int, lock bool) (storedPreferences, error) {
+	stored := storedPreferences{values: map[string]json.RawMessage{}}
+	var row struct {
+		Version     int       `db:"version"`
+		Preferences []byte    `db:"preferences"`
+		UpdatedAt   time.Time `db:"updated_at"`
+	}
+	query := "SELECT version, preferences, updated_at FROM user_preferences WHERE user_id = $1"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	err := sqlx.GetContext(ctx, db, &row, query, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stored, nil
+	}
+	if err != nil {
+		return stored, err
+	}
+	if row.Version > preferencesVersion {
+		return stored, errNewerPreferences
+	}
+	if err := json.Unmarshal(row.Preferences, &stored.values); err != nil {
+		return stored, err
+	}
+	for version := row.Version; version < preferencesVersion; version++ {
+		if err := preferenceMigrations[version-1](stored.values); err != nil {
+			return stored, fmt.Errorf("migrating preferences from version %d: %w", version, err)
+		}
+	}
+	stored.updatedAt = &row.UpdatedAt
+	return stored, nil
+}
+
+// response fills in the defaults of the keys the user hasn't set. Keys no
+// longer known are left out.
+func (p storedPreferences) response() types.Preferences {
+	resp := types.Preferences{
+		Version:   preferencesVersion,
+		Values:    map[string]json.RawMessage{},
+		Defaulted: []string{},
+		UpdatedAt: p.updatedAt,
+	}
+	for key, pref := range preferences {
+		if value, ok := p.values[key]; ok {
+			resp.Values[key] = value
+			continue
+		}
+		resp.Values[key], _ = json.Marshal(pref.def)
+		resp.Defaulted = append(resp.Defaulted, key)
+	}
+	sort.Strings(resp.Defaulted)
+	return resp
+}
+
+// validatePreferences checks the keys of a request. A null value resets a
+// key to its default, when allowed.
+func validatePreferences(values map[string]json.RawMessage, allowNull bool) error {
+	for key, value := range values {
+		pref, ok := preferences[key]
+		if !ok {
+			return fmt.Errorf("unknown preference %q", key)
+		}
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			if allowNull {
+				continue
+			}
+			return fmt.Errorf("%s can't be null", key)
+		}
+		if err := pref.validate(value); err != nil {
+			return fmt.Errorf("%s %v", key, err)
+		}
+	}
+	return nil
+}
+
+// getPreferences shows the caller's preferences, defaults included
+func (s *UserService) getPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	stored, err := loadPreferences(r.Context(), s.db, userID, false)
+	if err != nil && !errors.Is(err, errNewerPreferences) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch preferences")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.Conflict, "Preferences were saved by a newer version of the service")
+		return
+	}
+	utils.SuccessResponse(w, stored.response())
+}
+
+// replacePreferences sets the caller's preferences to the keys given; the
+// others go back to their defaults
+func (s *UserService) replacePreferences(w http.ResponseWriter, r *http.Request) {
+	s.writePreferences(w, r, true)
+}
+
+// updatePreferences changes the keys given, leaving the others. A null
+// value resets a key to its default.
+func (s *UserService) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	s.writePreferences(w, r, false)
+}
+
+func (s *UserService) writePreferences(w http.ResponseWriter, r *http.Request, replace bool) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	var req map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req == nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Request body must be an object of preferences")
+		return
+	}
+	if err := validatePreferences(req, !replace); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update preferences")
+		return
+	}
+	defer tx.Rollback()
+
+	stored, err := loadPreferences(r.Context(), tx, userID, true)
+	if errors.Is(err, errNewerPreferences) {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.Conflict, "Preferences were saved by a newer version of the service")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update preferences")
+		return
+	}
+	if replace {
+		stored.values = map[string]json.RawMessage{}
+	}
+	for key, value := range req {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(stored.values, key)
+			continue
+		}
+		stored.values[key] = value
+	}
+	// Keys dropped from the schema aren't carried over
+	for key := range stored.values {
+		if _, ok := preferences[key]; !ok {
+			delete(stored.values, key)
+		}
+	}
+
+	doc, err := json.Marshal(stored.values)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update preferences")
+		return
+	}
+	now := time.Now()
+	_, err = tx.ExecContext(r.Context(),
+		`INSERT INTO user_preferences (user_id, version, preferences, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET
+			version = EXCLUDED.version,
+			preferences = EXCLUDED.preferences,
+			updated_at = EXCLUDED.updated_at`,
+		userID, preferencesVersion, doc, now)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update preferences")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update preferences")
+		return
+	}
+
+	stored.updatedAt = &now
+	utils.SuccessResponse(w, stored.response())
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// maxPublicClones bounds the clones shown on a public profile; the library
+// lists the rest
+const maxPublicClones = 50
+
+// getPublicProfile shows a user's public profile to anyone, without a
+// token. Users who haven't turned on profile.public, and closed accounts,
+// are reported as not found, like unknown usernames, so whether an account
+// exists doesn't leak.
+func (s *UserService) getPublicProfile(w http.ResponseWriter, r *http.Request) {
+	db := dbroute.Reader(r, s.db, s.replica)
+
+	var user struct {
+		ID int `db:"id"`
+		types.PublicProfile
+	}
+	err := db.GetContext(r.Context(), &user,
+		`SELECT u.id, u.username,
+			COALESCE(NULLIF(TRIM(COALESCE(up.first_name, '') || ' ' || COALESCE(up.last_name, '')), ''), u.username) AS display_name,
+			COALESCE(up.bio, '') AS bio,
+			`+avatarURLColumn+`
+		FROM users u
+		LEFT JOIN user_profiles up ON u.id = up.user_id
+		LEFT JOIN user_avatars ua ON u.id = ua.user_id
+		WHERE LOWER(u.username) = LOWER($1) AND u.deleted_at IS NULL`,
+		mux.Vars(r)["username"])
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "Profile not found")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch profile")
+		return
+	}
+
+	stored, err := loadPreferences(r.Context(), db, user.ID, false)
+	if err != nil && !errors.Is(err, errNewerPreferences) {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch profile")
+		return
+	}
+	if err != nil || !prefBool(stored.response().Values, types.PrefProfilePublic) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "Profile not found")
+		return
+	}
+
+	// The same clones as the voice library lists for the user
+	user.Clones = []types.LibraryClone{}
+	err = db.SelectContext(r.Context(), &user.Clones,
+		`SELECT c.id, c.name, COALESCE(c.description, '') AS description, COALESCE(c.tags, '{}') AS tags,
+			$2::text AS owner, c.visibility, c.completed_at
+		FROM voice_clones c
+		WHERE c.user_id = $1 AND c.visibility = $3 AND c.status = $4 AND c.archived_at IS NULL AND c.deleted_at IS NULL
+		ORDER BY c.completed_at DESC, c.id DESC LIMIT $5`,
+		user.ID, user.Username, types.VisibilityPublic, types.StatusCompleted, maxPublicClones)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch profile")
+		return
+	}
+	utils.SuccessResponse(w, user.PublicProfile)
+}
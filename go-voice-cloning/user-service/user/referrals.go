@@ -0,0 +1,198 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultConversionsPageSize = 50
+	maxConversionsPageSize     = 200
+	referralCodeLength         = 8
+	// referralCodeAlphabet leaves out characters easily mistaken for others
+	referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// referralConfig is what a referral earns the referrer. Every user who
+// registers with their code adds bonusMinutes of synthesis a month, up to
+// maxBonusMinutes; plans with unlimited synthesis gain nothing.
+type referralConfig struct {
+	bonusMinutes    int
+	maxBonusMinutes int
+	signupURL       string
+}
+
+// referralConfigFromEnv reads REFERRAL_BONUS_MINUTES,
+// REFERRAL_BONUS_MAX_MINUTES and the sign-up page codes are shared with
+func referralConfigFromEnv() referralConfig {
+	config := referralConfig{
+		bonusMinutes:    30,
+		maxBonusMinutes: 300,
+		signupURL:       envOr("REFERRAL_SIGNUP_URL", "http://localhost:8080/signup"),
+	}
+	if n, err := strconv.Atoi(os.Getenv("REFERRAL_BONUS_MINUTES")); err == nil && n >= 0 {
+		config.bonusMinutes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("REFERRAL_BONUS_MAX_MINUTES")); err == nil && n >= 0 {
+		config.maxBonusMinutes = n
+	}
+	return config
+}
+
+// conversionsCursor is the position after the last user of a conversions page
+type conversionsCursor struct {
+	UserID int `json:"user_id"`
+}
+
+// bonus returns the minutes a number of conversions earn
+func (c referralConfig) bonus(conversions int) int {
+	minutes := conversions * c.bonusMinutes
+	if c.maxBonusMinutes > 0 && minutes > c.maxBonusMinutes {
+		minutes = c.maxBonusMinutes
+	}
+	return minutes
+}
+
+func generateReferralCode() (string, error) {
+	b := make([]byte, referralCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = referralCodeAlphabet[int(b[i])%len(referralCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// referralCode returns the user's referral code, giving them one the first
+// time
+func (s *UserService) referralCode(ctx context.Context, userID int) (string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		var code string
+		err := s.db.GetContext(ctx, &code, "SELECT code FROM referral_codes WHERE user_id = $1", userID)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+
+		// Another request may give the user a code first, and a new code may
+		// be taken; either way the insert does nothing and the code is read
+		// again
+		if code, err = generateReferralCode(); err != nil {
+			return "", err
+		}
+		if _, err := s.db.ExecContext(ctx,
+			"INSERT INTO referral_codes (user_id, code, created_at) VALUES ($1, $2, NOW()) ON CONFLICT DO NOTHING",
+			userID, code); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free referral code for user %d", userID)
+}
+
+// referralConversions counts the users still registered who signed up with
+// the user's code
+func referralConversions(ctx context.Context, db sqlx.QueryerContext, userID interface{}) (int, error) {
+	var n int
+	err := sqlx.GetContext(ctx, db, &n,
+		`SELECT COUNT(*) FROM referrals r JOIN users u ON u.id = r.referred_id
+		WHERE r.referrer_id = $1 AND u.deleted_at IS NULL`, userID)
+	return n, err
+}
+
+// getReferrals shows the caller's referral code, the link to share it with
+// and the synthesis minutes it has earned them
+func (s *UserService) getReferrals(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	code, err := s.referralCode(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch referral code")
+		return
+	}
+	conversions, err := referralConversions(r.Context(), dbroute.Reader(r, s.db, s.replica), userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch referrals")
+		return
+	}
+
+	utils.SuccessResponse(w, types.ReferralSummary{
+		Code:                  code,
+		Link:                  s.referrals.signupURL + "?ref=" + url.QueryEscape(code),
+		Conversions:           conversions,
+		BonusSynthesisMinutes: s.referrals.bonus(conversions),
+		MaxBonusMinutes:       s.referrals.maxBonusMinutes,
+	})
+}
+
+// listReferralConversions lists the users who registered with the caller's
+// code, newest first. Supports ?limit=/?cursor= pagination.
+func (s *UserService) listReferralConversions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	limit, err := types.ParseLimit(r.URL.Query(), defaultConversionsPageSize, maxConversionsPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	db := dbroute.Reader(r, s.db, s.replica)
+	total, err := referralConversions(r.Context(), db, userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch referrals")
+		return
+	}
+
+	after := 0
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var cursor conversionsCursor
+		if err := types.DecodeCursor(c, &cursor); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		after = cursor.UserID
+	}
+
+	conversions := []types.ReferralConversion{}
+	err = db.SelectContext(r.Context(), &conversions,
+		`SELECT u.id AS user_id, u.username, r.created_at AS joined_at
+		FROM referrals r JOIN users u ON u.id = r.referred_id
+		WHERE r.referrer_id = $1 AND u.deleted_at IS NULL AND ($2 = 0 OR u.id < $2)
+		ORDER BY u.id DESC LIMIT $3`,
+		userID, after, limit+1)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch referrals")
+		return
+	}
+
+	page := types.Pagination{Limit: limit, Total: total}
+	if len(conversions) > limit {
+		conversions = conversions[:limit]
+		page.NextCursor = types.EncodeCursor(conversionsCursor{UserID: conversions[len(conversions)-1].UserID})
+	}
+	utils.SuccessResponse(w, types.PageResponse{Data: conversions, Pagination: page})
+}
@@ -0,0 +1,220 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/search"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultSearchResults = 10
+	maxSearchResults     = 20
+	minSearchLength      = 2
+	// maxSearchWindows bounds the limiter; ended windows are dropped past it
+	maxSearchWindows = 10000
+)
+
+// searchLimiter allows each user a number of searches per minute. Counts
+// are kept per replica, so the effective limit grows with the replicas.
+type searchLimiter struct {
+	perMinute int
+
+	mu      sync.Mutex
+	windows map[int]*searchWindow
+}
+
+type searchWindow struct {
+	start time.Time
+	count int
+}
+
+func newSearchLimiter(perMinute int) *searchLimiter {
+	return &searchLimiter{perMinute: perMinute, windows: map[int]*searchWindow{}}
+}
+
+// allow counts a search of the user, and returns how long until they may
+// search again when they are over the limit
+func (l *searchLimiter) allow(userID int, now time.Time) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window, ok := l.windows[userID]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		if len(l.windows) >= maxSearchWindows {
+			for id, w := range l.windows {
+				if now.Sub(w.start) >= time.Minute {
+					delete(l.windows, id)
+				}
+			}
+		}
+		window = &searchWindow{start: now}
+		l.windows[userID] = window
+	}
+	if window.count >= l.perMinute {
+		return false, window.start.Add(time.Minute).Sub(now)
+	}
+	window.count++
+	return true, 0
+}
+
+// searchUsers finds users to pick as the recipient of a share or an org
+// invitation: usernames starting with ?q=, or the account with that exact
+// email. Only the ID, username and avatar are returned, and users who
+// turned off profile.searchable, closed accounts and the caller are left
+// out. Exact username matches come first.
+func (s *UserService) searchUsers(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if ok, retryAfter := s.searches.allow(userID, time.Now()); !ok {
+		utils.RetryResponse(w, http.StatusTooManyRequests, "Too many searches",
+			utils.NewRetryGuidance(utils.RetryReasonRateLimited, retryAfter))
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(q)) < minSearchLength {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("q must be at least %d characters", minSearchLength))
+		return
+	}
+	limit, err := types.ParseLimit(r.URL.Query(), defaultSearchResults, maxSearchResults)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	users := []types.UserSummary{}
+	err = dbroute.Reader(r, s.db, s.replica).SelectContext(r.Context(), &users,
+		`SELECT u.id, u.username, `+avatarURLColumn+`
+		FROM users u
+		LEFT JOIN user_avatars ua ON u.id = ua.user_id
+		WHERE (u.username ILIKE $1 OR LOWER(u.email) = LOWER($2))
+			AND u.deleted_at IS NULL AND u.id <> $3
+			AND NOT EXISTS (SELECT 1 FROM user_preferences p WHERE p.user_id = u.id AND p.preferences->>$4 = 'false')
+		ORDER BY LOWER(u.username) = LOWER($2) DESC, LENGTH(u.username), u.username
+		LIMIT $5`,
+		likeEscaper.Replace(q)+"%", q, userID, types.PrefProfileSearchable, limit)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to search users")
+		return
+	}
+	utils.SuccessResponse(w, users)
+}
+
+// librarySource is a service searched for one type of result
+type librarySource struct {
+	typ string
+	url string
+}
+
+// searchLibrary finds the caller's clones and files matching ?q=, asking
+// the voice and storage services at once, and merges their results most
+// relevant first, up to ?limit=. ?type=clone or ?type=file searches only
+// one. A service that fails is left out and its type listed in
+// unavailable, so the other's results are still shown.
+func (s *UserService) searchLibrary(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	q := r.URL.Query()
+	req, err := search.ParseRequest(q)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	sources := []librarySource{
+		{typ: search.TypeClone, url: s.voiceServiceURL},
+		{typ: search.TypeFile, url: s.storageServiceURL},
+	}
+	switch t := q.Get("type"); t {
+	case "":
+	case search.TypeClone:
+		sources = sources[:1]
+	case search.TypeFile:
+		sources = sources[1:]
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "type must be clone or file")
+		return
+	}
+
+	query := url.Values{"q": {req.Query}, "limit": {strconv.Itoa(req.Limit)}}.Encode()
+	found := make([][]search.Result, len(sources))
+	failed := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source librarySource) {
+			defer wg.Done()
+			found[i], failed[i] = s.searchService(r, source.url+"/search?"+query)
+		}(i, source)
+	}
+	wg.Wait()
+
+	results := []search.Result{}
+	unavailable := []string{}
+	for i, source := range sources {
+		if failed[i] != nil {
+			logging.FromContext(r.Context()).Error("Failed to search", "type", source.typ, "error", failed[i])
+			unavailable = append(unavailable, source.typ)
+			continue
+		}
+		results = append(results, found[i]...)
+	}
+	if len(unavailable) == len(sources) {
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Search unavailable")
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if len(results) > req.Limit {
+		results = results[:req.Limit]
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"query":       req.Query,
+		"results":     results,
+		"unavailable": unavailable,
+	})
+}
+
+// searchService runs a search of another service on behalf of the caller
+// of r
+func (s *UserService) searchService(r *http.Request, url string) ([]search.Result, error) {
+	req, err := forwardedRequest(r, url)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search answered %s", res.Status)
+	}
+	var results []search.Result
+	if err := utils.DecodeData(res, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,335 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/cron"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/idempotency"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/mail"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+type UserService struct {
+	db        *sqlx.DB
+	replica   *sqlx.DB
+	eventBus  events.Bus // nil without EVENT_BUS
+	audit     *audit.Log
+	mailer    *mail.Mailer
+	inviteURL string
+	inviteTTL time.Duration
+
+	orgInviteURL string
+
+	httpClient        *http.Client
+	voiceServiceURL   string
+	storageServiceURL string
+	calendarSources   []calendarSource
+	calendarTimeout   time.Duration
+	stats             *statsCache
+	searches          *searchLimiter
+
+	billing   billingConfig
+	referrals referralConfig
+}
+
+// New builds the user service on a migrated database and starts its
+// background jobs. It returns the service's handler, and a function
+// stopping the jobs and closing its audit log and event bus once it no
+// longer serves.
+func New(cfg Config, db *sqlx.DB) (http.Handler, func()) {
+	seedPlans(db)
+
+	// The event bus announces closed accounts to the other services
+	eventBus, err := events.BusFromEnv()
+	if err != nil {
+		log.Fatal("Failed to connect to event bus:", err)
+	}
+
+	// Security-relevant operations go to the central audit log
+	auditLog, err := audit.Open("user-service", cfg.Audit, db, eventBus)
+	if err != nil {
+		log.Fatal("Invalid audit configuration:", err)
+	}
+
+	service := &UserService{
+		db:                db,
+		replica:           dbroute.ConnectReplica(db, cfg.DB),
+		eventBus:          eventBus,
+		audit:             auditLog,
+		mailer:            mail.NewMailer(cfg.Mail),
+		inviteURL:         cfg.InviteURL,
+		inviteTTL:         cfg.InviteTTL,
+		orgInviteURL:      cfg.OrgInviteURL,
+		httpClient:        &http.Client{Timeout: cfg.CalendarTimeout},
+		voiceServiceURL:   cfg.VoiceServiceURL,
+		storageServiceURL: cfg.StorageServiceURL,
+		calendarSources:   calendarSourcesFromEnv(),
+		calendarTimeout:   cfg.CalendarTimeout,
+		stats:             newStatsCache(cfg.StatsCacheTTL),
+		searches:          newSearchLimiter(cfg.UserSearchPerMinute),
+		billing:           newBillingConfig(cfg.Billing),
+		referrals:         referralConfigFromEnv(),
+	}
+
+	ctx, stopJobs := context.WithCancel(context.Background())
+
+	// Maintenance jobs: member activity is rolled up for org reports
+	jobs, err := cron.New("user-service", cfg.Cron, db, auditLog)
+	if err != nil {
+		log.Fatal("Invalid cron configuration:", err)
+	}
+	if err := jobs.Add("activity-rollup", cron.Spec(cfg.ActivityRollupSchedule, cfg.ActivityRollupInterval), service.activityRollup(cfg.ActivityRollupLookbackDays)); err != nil {
+		log.Fatal("Invalid ACTIVITY_ROLLUP_SCHEDULE:", err)
+	}
+	jobs.Start()
+
+	// Remove deleted users' data across the services
+	go service.runAccountDeletions(ctx, cfg.DatabaseURL, cfg.AccountDeletionInterval)
+
+	// Email users about their clones, new sign-ins and their week
+	go service.runNotifications(ctx, cfg.NotificationInterval)
+
+	checks := health.New("user-service")
+	checks.Add("postgres", health.DB(db))
+	if service.replica != db {
+		checks.Add("postgres_replica", health.DB(service.replica))
+	}
+
+	// Retries of creating requests carrying an Idempotency-Key are answered
+	// with the first response
+	idempotent := idempotency.New(db, cfg.Idempotency)
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pools(db, service.replica)...)).Methods("GET")
+	r.HandleFunc("/profile", service.getProfile).Methods("GET")
+	r.HandleFunc("/profile", service.updateProfile).Methods("PUT", "PATCH")
+	r.HandleFunc("/profile/avatar", service.getAvatar).Methods("GET")
+	r.HandleFunc("/profile/avatar", service.uploadAvatar).Methods("POST")
+	r.HandleFunc("/profile/avatar", service.deleteAvatar).Methods("DELETE")
+	r.HandleFunc("/preferences", service.getPreferences).Methods("GET")
+	r.HandleFunc("/preferences", service.replacePreferences).Methods("PUT")
+	r.HandleFunc("/preferences", service.updatePreferences).Methods("PATCH")
+	r.HandleFunc("/stats", service.getStats).Methods("GET")
+	r.HandleFunc("/activity", service.getActivityFeed).Methods("GET")
+	r.HandleFunc("/calendar", service.getCalendar).Methods("GET")
+	r.HandleFunc("/plans", service.listPlans).Methods("GET")
+	r.HandleFunc("/entitlements", service.getEntitlements).Methods("GET")
+	r.HandleFunc("/referrals", service.getReferrals).Methods("GET")
+	r.HandleFunc("/referrals/conversions", service.listReferralConversions).Methods("GET")
+	r.HandleFunc("/users/search", service.searchUsers).Methods("GET")
+	r.HandleFunc("/search", service.searchLibrary).Methods("GET")
+	r.HandleFunc("/users/{id}/entitlements", service.getUserEntitlements).Methods("GET")
+	r.HandleFunc("/users/{username}/public", service.getPublicProfile).Methods("GET")
+	r.HandleFunc("/usage", service.getUsage).Methods("GET")
+	r.HandleFunc("/users/{id}/usage", service.getUserUsage).Methods("GET")
+	r.HandleFunc("/admin/plans/{plan}", service.updatePlan).Methods("PUT")
+	r.HandleFunc("/billing/checkout", idempotent.Wrap(service.createCheckout)).Methods("POST")
+	r.HandleFunc("/billing/portal", idempotent.Wrap(service.createBillingPortal)).Methods("POST")
+	r.HandleFunc("/billing/subscription", service.getSubscription).Methods("GET")
+	r.HandleFunc("/billing/webhook", service.stripeWebhook).Methods("POST")
+	r.HandleFunc("/admin/users/import", service.importUsers).Methods("POST")
+	r.HandleFunc("/admin/users", service.listDirectory).Methods("GET")
+	r.HandleFunc("/admin/users/{id}", service.getDirectoryUser).Methods("GET")
+	r.HandleFunc("/admin/users/{id}", service.deleteUser).Methods("DELETE")
+	r.HandleFunc("/admin/deletions", service.listAccountDeletions).Methods("GET")
+	r.HandleFunc("/admin/deletions/{id}", service.getAccountDeletion).Methods("GET")
+	r.PathPrefix("/admin/jobs").Handler(jobs)
+	r.HandleFunc("/users/{id}/deletion", service.requestAccountDeletion).Methods("POST")
+	r.HandleFunc("/orgs/{org}/activity", service.getOrgActivity).Methods("GET")
+	r.HandleFunc("/orgs", service.createOrg).Methods("POST")
+	r.HandleFunc("/orgs", service.listOrgs).Methods("GET")
+	r.HandleFunc("/orgs/{org}/members", service.listOrgMembers).Methods("GET")
+	r.HandleFunc("/orgs/{org}/members/{user_id}", service.getOrgMembership).Methods("GET")
+	r.HandleFunc("/orgs/{org}/members/{user_id}", service.setOrgMemberRole).Methods("PUT")
+	r.HandleFunc("/orgs/{org}/members/{user_id}", service.removeOrgMember).Methods("DELETE")
+	r.HandleFunc("/orgs/{org}/invitations", service.inviteToOrg).Methods("POST")
+	r.HandleFunc("/orgs/{org}/invitations", service.listOrgInvitations).Methods("GET")
+	r.HandleFunc("/orgs/{org}/invitations/{id}", service.revokeOrgInvitation).Methods("DELETE")
+	r.HandleFunc("/org-invitations/accept", service.acceptOrgInvitation).Methods("POST")
+
+	return middleware.Chain(middleware.UserLanguage(db)(r)), func() {
+		stopJobs()
+		jobs.Close()
+		auditLog.Close()
+		if eventBus != nil {
+			eventBus.Close()
+		}
+	}
+}
+
+// Profile field limits, in characters
+const (
+	maxNameLength = 100
+	maxBioLength  = 1000
+)
+
+// loadProfile reads a user's profile, with the defaults of the fields they
+// haven't set
+func (s *UserService) loadProfile(ctx context.Context, userID int) (types.UserProfile, error) {
+	var profile types.UserProfile
+	err := s.db.GetContext(ctx, &profile,
+		`SELECT u.id, u.email, u.username, u.created_at, u.updated_at,
+			COALESCE(up.first_name, '') as first_name,
+			COALESCE(up.last_name, '') as last_name,
+			COALESCE(up.bio, '') as bio,
+			COALESCE(up.timezone, $2) as timezone,
+			COALESCE(up.locale, $3) as locale,
+			`+avatarURLColumn+`
+		FROM users u
+		LEFT JOIN user_profiles up ON u.id = up.user_id
+		LEFT JOIN user_avatars ua ON u.id = ua.user_id
+		WHERE u.id = $1`,
+		userID, locale.DefaultTimezone, locale.DefaultLocale)
+	return profile, err
+}
+
+func (s *UserService) getProfile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	profile, err := s.loadProfile(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+
+	utils.SuccessResponse(w, profile)
+}
+
+// updateProfile changes the fields given and keeps the others, for PUT and
+// PATCH alike, and returns the updated profile. Names are trimmed and, like
+// the bio, can't hold control characters other than the bio's line breaks
+// and tabs.
+func (s *UserService) updateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Bio       *string `json:"bio"`
+		Timezone  *string `json:"timezone"`
+		Locale    *string `json:"locale"`
+	}
+
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	fields := []string{}
+	for _, name := range []struct {
+		field string
+		value *string
+	}{{"first_name", req.FirstName}, {"last_name", req.LastName}} {
+		if name.value == nil {
+			continue
+		}
+		*name.value = strings.TrimSpace(*name.value)
+		if err := validateProfileText(*name.value, maxNameLength, false); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, name.field+" "+err.Error())
+			return
+		}
+		fields = append(fields, name.field)
+	}
+	if req.Bio != nil {
+		if err := validateProfileText(*req.Bio, maxBioLength, true); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "bio "+err.Error())
+			return
+		}
+		fields = append(fields, "bio")
+	}
+	if req.Timezone != nil {
+		if !locale.ValidTimezone(*req.Timezone) {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "timezone must be an IANA time zone such as Europe/Paris")
+			return
+		}
+		fields = append(fields, "timezone")
+	}
+	if req.Locale != nil {
+		if !locale.ValidLocale(*req.Locale) {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "locale must be a BCP 47 tag such as en or pt-BR")
+			return
+		}
+		fields = append(fields, "locale")
+	}
+	if len(fields) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "No profile fields given")
+		return
+	}
+
+	// Upsert user profile
+	_, err := s.db.ExecContext(r.Context(),
+		`INSERT INTO user_profiles (user_id, first_name, last_name, bio, timezone, locale, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id) DO UPDATE SET
+			first_name = COALESCE(EXCLUDED.first_name, user_profiles.first_name),
+			last_name = COALESCE(EXCLUDED.last_name, user_profiles.last_name),
+			bio = COALESCE(EXCLUDED.bio, user_profiles.bio),
+			timezone = COALESCE(EXCLUDED.timezone, user_profiles.timezone),
+			locale = COALESCE(EXCLUDED.locale, user_profiles.locale),
+			updated_at = EXCLUDED.updated_at`,
+		userID, req.FirstName, req.LastName, req.Bio, req.Timezone, req.Locale, time.Now())
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to update profile")
+		return
+	}
+	s.recordProfileUpdate(r, userID, fields...)
+
+	profile, err := s.loadProfile(r.Context(), userID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch profile")
+		return
+	}
+	utils.SuccessResponse(w, profile)
+}
+
+// validateProfileText checks the length of a profile field and that it
+// holds no control characters, but for line breaks and tabs if multiline
+func validateProfileText(value string, maxLength int, multiline bool) error {
+	if !utf8.ValidString(value) {
+		return errors.New("must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("must be at most %d characters", maxLength)
+	}
+	for _, c := range value {
+		if multiline && (c == '\n' || c == '\r' || c == '\t') {
+			continue
+		}
+		if unicode.IsControl(c) {
+			return errors.New("can't contain control characters")
+		}
+	}
+	return nil
+}
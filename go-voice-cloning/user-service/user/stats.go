@@ -0,0 +1,210 @@
+package user
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// userStats are the figures of a user's dashboard
+type userStats struct {
+	TotalClones          int                  `json:"total_clones"`
+	CompletedClones      int                  `json:"completed_clones"`
+	PendingClones        int                  `json:"pending_clones"`
+	ProcessingClones     int                  `json:"processing_clones"`
+	SynthesesThisMonth   int                  `json:"syntheses_this_month"`
+	AvgProcessingSeconds *float64             `json:"avg_processing_seconds"`
+	StorageBytes         *int64               `json:"storage_bytes,omitempty"`
+	Storage              *types.StorageUsage  `json:"storage,omitempty"`
+	Days                 []types.AnalyticsDay `json:"days"`
+	GeneratedAt          time.Time            `json:"generated_at"`
+}
+
+// statsCache keeps users' stats for a while, so a dashboard polling them
+// doesn't query the voice and storage services each time. Stats missing
+// storage usage aren't kept, so it is fetched again on the next call.
+type statsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[statsKey]*userStats
+}
+
+type statsKey struct {
+	userID int
+	days   int
+}
+
+// maxCachedStats bounds the cache; expired entries are dropped past it
+const maxCachedStats = 10000
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, entries: map[statsKey]*userStats{}}
+}
+
+func (c *statsCache) get(key statsKey) *userStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stats, ok := c.entries[key]; ok && time.Since(stats.GeneratedAt) < c.ttl {
+		return stats
+	}
+	return nil
+}
+
+func (c *statsCache) put(key statsKey, stats *userStats) {
+	if c.ttl <= 0 || stats.Storage == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedStats {
+		for k, e := range c.entries {
+			if time.Since(e.GeneratedAt) >= c.ttl {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = stats
+}
+
+// getStats counts the user's clones by status and the syntheses completed
+// this month (UTC), with the average processing time of clone jobs and a
+// daily series over the last ?days= days (default 30, max 365). The voice
+// service owns the clones, so the figures come from its analytics rather
+// than its tables. Storage usage comes from the storage service and is left
+// out if it is unavailable.
+func (s *UserService) getStats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	days := types.DefaultAnalyticsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > types.MaxAnalyticsDays {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("days must be between 1 and %d", types.MaxAnalyticsDays))
+			return
+		}
+		days = n
+	}
+	key := statsKey{userID: userID, days: days}
+	if stats := s.stats.get(key); stats != nil {
+		utils.SuccessResponse(w, stats)
+		return
+	}
+
+	// The analytics cover the month so far too, to count its syntheses
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	window := days
+	if now.Day() > window {
+		window = now.Day()
+	}
+
+	req, err := forwardedRequest(r, fmt.Sprintf("%s/clones/analytics?days=%d", s.voiceServiceURL, window))
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch stats")
+		return
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Voice service unavailable")
+		return
+	}
+	defer res.Body.Close()
+
+	var analytics types.CloneAnalytics
+	if res.StatusCode != http.StatusOK || utils.DecodeData(res, &analytics) != nil {
+		utils.ErrorResponse(w, http.StatusBadGateway, apierror.UpstreamError, "Failed to fetch stats")
+		return
+	}
+
+	stats := &userStats{
+		CompletedClones:  analytics.Clones[types.StatusCompleted],
+		PendingClones:    analytics.Clones[types.StatusPending],
+		ProcessingClones: analytics.Clones[types.StatusProcessing],
+		Days:             []types.AnalyticsDay{},
+		Storage:          s.storageUsage(r, s.storageServiceURL+"/usage"),
+		GeneratedAt:      now,
+	}
+	for _, n := range analytics.Clones {
+		stats.TotalClones += n
+	}
+	if stats.Storage != nil {
+		stats.StorageBytes = &stats.Storage.TotalBytes
+	}
+
+	// The average is weighted by the completed jobs of each day
+	var processed int
+	var processingSeconds float64
+	first := len(analytics.Days) - days
+	for i, day := range analytics.Days {
+		if date, err := time.Parse("2006-01-02", day.Date); err == nil && !date.Before(monthStart) {
+			stats.SynthesesThisMonth += day.Syntheses
+		}
+		if i < first {
+			continue
+		}
+		stats.Days = append(stats.Days, day)
+		if day.AvgProcessingSeconds != nil {
+			processed += day.Completed
+			processingSeconds += *day.AvgProcessingSeconds * float64(day.Completed)
+		}
+	}
+	if processed > 0 {
+		avg := math.Round(processingSeconds/float64(processed)*10) / 10
+		stats.AvgProcessingSeconds = &avg
+	}
+
+	s.stats.put(key, stats)
+	utils.SuccessResponse(w, stats)
+}
+
+// storageUsage fetches storage usage from the storage service's url on
+// behalf of the caller, nil if the storage service doesn't answer
+func (s *UserService) storageUsage(r *http.Request, url string) *types.StorageUsage {
+	req, err := forwardedRequest(r, url)
+	if err != nil {
+		return nil
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to fetch storage usage", "error", err)
+		return nil
+	}
+	defer res.Body.Close()
+
+	var usage types.StorageUsage
+	if res.StatusCode != http.StatusOK || utils.DecodeData(res, &usage) != nil {
+		logging.FromContext(r.Context()).Error("Failed to fetch storage usage", "status", res.StatusCode)
+		return nil
+	}
+	return &usage
+}
+
+// forwardedRequest is a GET to another service on behalf of the caller of r
+func forwardedRequest(r *http.Request, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range []string{"X-User-ID", "X-User-Role", utils.RequestIDHeader, dbroute.IntentHeader} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	return req, nil
+}
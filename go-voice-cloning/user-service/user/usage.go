@@ -0,0 +1,120 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultUsageMonths = 6
+	maxUsageMonths     = 24
+)
+
+// usageRow is a month of the usage_events ledger, durations still in the
+// milliseconds they are recorded in
+type usageRow struct {
+	types.UsageMonth
+	AudioProcessedMS int64 `db:"audio_processed_ms"`
+	SynthesisMS      int64 `db:"synthesis_ms"`
+}
+
+// usageReport sums a user's usage events by calendar month, for the given
+// number of months up to the current one. Months without usage are zero.
+func (s *UserService) usageReport(ctx context.Context, userID, months int) (types.UsageReport, error) {
+	now := time.Now().UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	since := current.AddDate(0, -(months - 1), 0)
+
+	var rows []usageRow
+	err := s.db.SelectContext(ctx, &rows,
+		`SELECT date_trunc('month', occurred_at) AS period,
+			COALESCE(SUM(quantity) FILTER (WHERE metric = $3), 0) AS audio_processed_ms,
+			COALESCE(SUM(quantity) FILTER (WHERE metric = $4), 0) AS synthesis_ms,
+			COALESCE(SUM(quantity) FILTER (WHERE metric = $5), 0) AS synthesis_characters,
+			COALESCE((ARRAY_AGG(quantity ORDER BY occurred_at DESC) FILTER (WHERE metric = $6))[1], 0) AS storage_bytes,
+			COALESCE(MAX(quantity) FILTER (WHERE metric = $6), 0) AS storage_bytes_peak
+		FROM usage_events WHERE user_id = $1 AND occurred_at >= $2
+		GROUP BY 1`,
+		userID, since, types.UsageAudioProcessedMS, types.UsageSynthesisMS, types.UsageSynthesisCharacters, types.UsageStorageBytes)
+	if err != nil {
+		return types.UsageReport{}, err
+	}
+	byPeriod := make(map[time.Time]usageRow, len(rows))
+	for _, row := range rows {
+		byPeriod[row.Period.UTC()] = row
+	}
+
+	report := types.UsageReport{UserID: userID, Months: make([]types.UsageMonth, 0, months)}
+	for period := current; !period.Before(since); period = period.AddDate(0, -1, 0) {
+		row := byPeriod[period]
+		month := row.UsageMonth
+		month.Period = period
+		month.AudioProcessedMinutes = float64(row.AudioProcessedMS) / float64(time.Minute/time.Millisecond)
+		month.SynthesisMinutes = float64(row.SynthesisMS) / float64(time.Minute/time.Millisecond)
+		report.Months = append(report.Months, month)
+	}
+	return report, nil
+}
+
+// usageMonths reads how many months a usage report covers
+func usageMonths(w http.ResponseWriter, r *http.Request) (int, bool) {
+	months := defaultUsageMonths
+	if v := r.URL.Query().Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageMonths {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("months must be between 1 and %d", maxUsageMonths))
+			return 0, false
+		}
+		months = n
+	}
+	return months, true
+}
+
+// getUsage reports the caller's metered consumption by month
+func (s *UserService) getUsage(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	months, ok := usageMonths(w, r)
+	if !ok {
+		return
+	}
+	report, err := s.usageReport(r.Context(), userID, months)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch usage")
+		return
+	}
+	utils.SuccessResponse(w, report)
+}
+
+// getUserUsage reports a user's metered consumption by month (internal, for
+// billing)
+func (s *UserService) getUserUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+	months, ok := usageMonths(w, r)
+	if !ok {
+		return
+	}
+	report, err := s.usageReport(r.Context(), userID, months)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to fetch usage")
+		return
+	}
+	utils.SuccessResponse(w, report)
+}
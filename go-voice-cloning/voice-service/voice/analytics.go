@@ -0,0 +1,158 @@
+package voice
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// analyticsRow holds the sums of one day that the figures are derived from
+type analyticsRow struct {
+	Date              string  `db:"date"`
+	Jobs              int     `db:"jobs"`
+	Completed         int     `db:"completed"`
+	Failed            int     `db:"failed"`
+	Processed         int     `db:"processed"`
+	ProcessingSeconds float64 `db:"processing_seconds"`
+	SynthesisMS       int64   `db:"synthesis_ms"`
+	Syntheses         int     `db:"syntheses"`
+}
+
+// analyticsQuery sums the user's ($1) clone jobs and synthesis for each day
+// from $2 to $3. Clones trained before started_at was recorded are timed
+// from their creation.
+const analyticsQuery = `WITH days AS (
+		SELECT d::date AS day FROM generate_series($2::date, $3::date, '1 day') d
+	), jobs AS (
+		SELECT created_at::date AS day, COUNT(*) AS jobs,
+			COUNT(*) FILTER (WHERE status = $4) AS completed,
+			COUNT(*) FILTER (WHERE status = $5) AS failed,
+			COUNT(completed_at) FILTER (WHERE status = $4) AS processed,
+			SUM(EXTRACT(EPOCH FROM completed_at - COALESCE(started_at, created_at))) FILTER (WHERE status = $4) AS processing_seconds
+		FROM voice_clones
+		WHERE user_id = $1 AND created_at >= $2::date AND created_at < $3::date + 1
+		GROUP BY 1
+	), synthesis AS (
+		SELECT completed_at::date AS day, SUM(duration_ms) AS ms, COUNT(*) AS syntheses
+		FROM synthesis_jobs
+		WHERE user_id = $1 AND completed_at >= $2::date AND completed_at < $3::date + 1
+		GROUP BY 1
+	)
+	SELECT to_char(days.day, 'YYYY-MM-DD') AS date,
+		COALESCE(jobs.jobs, 0) AS jobs, COALESCE(jobs.completed, 0) AS completed,
+		COALESCE(jobs.failed, 0) AS failed, COALESCE(jobs.processed, 0) AS processed,
+		COALESCE(jobs.processing_seconds, 0) AS processing_seconds,
+		COALESCE(synthesis.ms, 0) AS synthesis_ms, COALESCE(synthesis.syntheses, 0) AS syntheses
+	FROM days
+	LEFT JOIN jobs ON jobs.day = days.day
+	LEFT JOIN synthesis ON synthesis.day = days.day
+	ORDER BY days.day`
+
+// getAnalytics returns daily figures of the user's clone and synthesis jobs
+// for the last ?days= days (default 30, max 365), today included, and the
+// number of their clones in each status. Admins can pass ?user_id= for
+// another user's.
+func (s *VoiceService) getAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		if !requireAdmin(w, r) {
+			return
+		}
+		subject, err := strconv.Atoi(v)
+		if err != nil || subject <= 0 {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user_id")
+			return
+		}
+		userID = subject
+	}
+
+	days := types.DefaultAnalyticsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > types.MaxAnalyticsDays {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("days must be between 1 and %d", types.MaxAnalyticsDays))
+			return
+		}
+		days = n
+	}
+
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, 1-days)
+
+	db := s.reader(r)
+	rows := []analyticsRow{}
+	err := db.SelectContext(r.Context(), &rows, analyticsQuery,
+		userID, from, to, types.StatusCompleted, types.StatusFailed)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch analytics")
+		return
+	}
+
+	var statuses []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	err = db.SelectContext(r.Context(), &statuses,
+		"SELECT status, COUNT(*) AS count FROM voice_clones WHERE user_id = $1 AND deleted_at IS NULL GROUP BY status",
+		userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch analytics")
+		return
+	}
+
+	analytics := types.CloneAnalytics{
+		From:   from,
+		To:     to.AddDate(0, 0, 1),
+		Days:   make([]types.AnalyticsDay, 0, len(rows)),
+		Clones: map[string]int{},
+	}
+	var total analyticsRow
+	for _, row := range rows {
+		analytics.Days = append(analytics.Days, types.AnalyticsDay{Date: row.Date, AnalyticsFigures: row.figures()})
+		total.Jobs += row.Jobs
+		total.Completed += row.Completed
+		total.Failed += row.Failed
+		total.Processed += row.Processed
+		total.ProcessingSeconds += row.ProcessingSeconds
+		total.SynthesisMS += row.SynthesisMS
+		total.Syntheses += row.Syntheses
+	}
+	analytics.Totals = total.figures()
+	for _, status := range statuses {
+		analytics.Clones[status.Status] = status.Count
+	}
+
+	utils.SuccessResponse(w, analytics)
+}
+
+// figures derives the rates and averages of a day's or window's sums
+func (row analyticsRow) figures() types.AnalyticsFigures {
+	f := types.AnalyticsFigures{
+		Jobs:             row.Jobs,
+		Completed:        row.Completed,
+		Failed:           row.Failed,
+		SynthesisSeconds: float64(row.SynthesisMS) / 1000,
+		Syntheses:        row.Syntheses,
+	}
+	if finished := row.Completed + row.Failed; finished > 0 {
+		rate := math.Round(float64(row.Failed)/float64(finished)*1000) / 1000
+		f.FailureRate = &rate
+	}
+	if row.Processed > 0 {
+		avg := math.Round(row.ProcessingSeconds/float64(row.Processed)*10) / 10
+		f.AvgProcessingSeconds = &avg
+	}
+	return f
+}
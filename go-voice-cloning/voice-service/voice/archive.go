@@ -0,0 +1,369 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// Storage tiers of the storage service
+const (
+	tierStandard = "standard"
+	tierCold     = "cold"
+)
+
+// artifactVoiceModel is the kind of a clone's trained model artifact
+const artifactVoiceModel = "voice_model"
+
+// offloadBatchSize bounds how many archived clones a sweep offloads
+const offloadBatchSize = 100
+
+// cloneRetention is how long deleted clones, and optionally archived ones,
+// are kept before they are purged
+type cloneRetention struct {
+	deleted  time.Duration
+	archived time.Duration // 0 keeps archived clones
+}
+
+// cloneRetentionFromEnv reads CLONE_DELETE_RETENTION_DAYS (default 30) and
+// CLONE_ARCHIVE_RETENTION_DAYS (default 0, never purged)
+func cloneRetentionFromEnv() cloneRetention {
+	day := 24 * time.Hour
+	return cloneRetention{
+		deleted:  time.Duration(envInt("CLONE_DELETE_RETENTION_DAYS", 30)) * day,
+		archived: time.Duration(envInt("CLONE_ARCHIVE_RETENTION_DAYS", 0)) * day,
+	}
+}
+
+// archiveClone puts a finished clone away: it leaves the default clone
+// listing and the library, can't synthesize until unarchived, and its model
+// is moved to cold storage by the clone janitor
+func (s *VoiceService) archiveClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL",
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if !types.IsTerminalStatus(clone.Status) {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only finished clones can be archived")
+		return
+	}
+	running, err := retraining(r.Context(), s.db, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to archive voice clone")
+		return
+	}
+	if running {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Voice clone is being retrained; wait for it to finish")
+		return
+	}
+
+	// Archiving twice keeps the first archived_at
+	err = s.db.GetContext(r.Context(), &clone,
+		`UPDATE voice_clones SET archived_at = COALESCE(archived_at, NOW()), expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL RETURNING `+cloneColumns,
+		clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to archive voice clone")
+		return
+	}
+
+	utils.SuccessResponse(w, clone)
+}
+
+// unarchiveClone brings an archived clone back, moving its model out of
+// cold storage first
+func (s *VoiceService) unarchiveClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to unarchive voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	// The lock keeps the janitor from offloading the model meanwhile
+	var clone types.VoiceClone
+	err = tx.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if clone.ArchivedAt == nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Voice clone isn't archived")
+		return
+	}
+
+	if err := s.moveModels(r.Context(), tx, clone.ID, tierStandard); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to restore a model from cold storage", "clone_id", clone.ID, "error", err)
+		w.Header().Set("Retry-After", "30")
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Failed to restore the voice model, try again later")
+		return
+	}
+	err = tx.GetContext(r.Context(), &clone,
+		`UPDATE voice_clones SET archived_at = NULL, model_offloaded_at = NULL, expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 RETURNING `+cloneColumns,
+		clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to unarchive voice clone")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to unarchive voice clone")
+		return
+	}
+
+	utils.SuccessResponse(w, clone)
+}
+
+// restoreClone takes a deleted clone out of the trash before it is purged.
+// Jobs cancelled by the deletion stay cancelled.
+func (s *VoiceService) restoreClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to restore voice clone")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to restore voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	var clone types.VoiceClone
+	err = tx.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL FOR UPDATE",
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Deleted voice clone not found")
+		return
+	}
+
+	// A restored clone counts against the plan again
+	err = lockQuota(r.Context(), tx, userID)
+	if err == nil {
+		err = s.checkCloneCount(r.Context(), tx, userID, entitlements)
+	}
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		quotaExceeded(w, quotaErr)
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to restore voice clone")
+		return
+	}
+
+	err = tx.GetContext(r.Context(), &clone,
+		"UPDATE voice_clones SET deleted_at = NULL, updated_at = NOW() WHERE id = $1 RETURNING "+cloneColumns,
+		clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to restore voice clone")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to restore voice clone")
+		return
+	}
+
+	utils.SuccessResponse(w, clone)
+}
+
+// cloneRetention expires inactive clones, offloads the models of archived
+// clones to cold storage and purges clones whose retention has passed, and
+// expired idempotency keys. It's the clone-retention job; each step logs its
+// own failures and leaves what it couldn't do to the next run.
+func (s *VoiceService) cloneRetention(ctx context.Context) error {
+	s.expireInactiveClones(ctx)
+	s.offloadArchivedModels(ctx)
+	s.purgeExpiredClones(ctx)
+	s.idempotency.Purge(ctx)
+	s.purgeLifecycleEvents(ctx)
+	return nil
+}
+
+func (s *VoiceService) offloadArchivedModels(ctx context.Context) {
+	ids := []int{}
+	err := s.db.SelectContext(ctx, &ids,
+		`SELECT id FROM voice_clones
+		WHERE archived_at IS NOT NULL AND model_offloaded_at IS NULL AND deleted_at IS NULL
+		ORDER BY archived_at LIMIT $1`,
+		offloadBatchSize)
+	if err != nil {
+		slog.Error("Clone janitor failed to list archived clones", "error", err)
+		return
+	}
+	for _, id := range ids {
+		if err := s.offloadModel(ctx, id); err != nil {
+			slog.Error("Clone janitor failed to offload a model", "clone_id", id, "error", err)
+		}
+	}
+}
+
+// offloadModel moves an archived clone's model to cold storage, unless the
+// clone was unarchived or is locked by an unarchive in progress
+func (s *VoiceService) offloadModel(ctx context.Context, cloneID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.GetContext(ctx, &locked,
+		`SELECT id FROM voice_clones WHERE id = $1 AND archived_at IS NOT NULL AND model_offloaded_at IS NULL
+		FOR UPDATE SKIP LOCKED`, cloneID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.moveModels(ctx, tx, cloneID, tierCold); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE voice_clones SET model_offloaded_at = NOW() WHERE id = $1", cloneID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// purgeExpiredClones permanently removes clones deleted longer ago than the
+// retention period, and archived ones when archived clones expire
+func (s *VoiceService) purgeExpiredClones(ctx context.Context) {
+	purged := 0
+	for ctx.Err() == nil {
+		ok, err := s.purgeNextClone(ctx)
+		if err != nil {
+			slog.Error("Clone janitor failed to purge a voice clone", "error", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		purged++
+	}
+	if purged > 0 {
+		slog.Info("Clone janitor purged voice clones", "count", purged)
+	}
+}
+
+// purgeNextClone removes one expired clone and reports whether there was one
+func (s *VoiceService) purgeNextClone(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	expired := "deleted_at < $1"
+	args := []interface{}{time.Now().Add(-s.retention.deleted)}
+	if s.retention.archived > 0 {
+		expired += " OR archived_at < $2"
+		args = append(args, time.Now().Add(-s.retention.archived))
+	}
+	var clone types.VoiceClone
+	err = tx.GetContext(ctx, &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE "+expired+" ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED",
+		args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The storage service removes the clone's files once it sees the
+	// clone.deleted event, retrying until they are gone
+	if _, err := removeClone(ctx, tx, clone); err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+	return true, nil
+}
+
+// moveModels moves a clone's model files to a storage tier. Models already
+// gone from storage are skipped.
+func (s *VoiceService) moveModels(ctx context.Context, db sqlx.QueryerContext, cloneID int, tier string) error {
+	files := []string{}
+	err := sqlx.SelectContext(ctx, db, &files,
+		`SELECT file FROM clone_artifacts WHERE clone_id = $1 AND kind = $2
+		UNION SELECT file FROM clone_models WHERE clone_id = $1 AND file IS NOT NULL`,
+		cloneID, artifactVoiceModel)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.setFileTier(ctx, file, tier); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// setFileTier moves a stored file to a storage tier
+func (s *VoiceService) setFileTier(ctx context.Context, filename, tier string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"tier": tier})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		s.storageURL+"/files/"+url.PathEscape(filename)+"/tier", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("storage service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package voice
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// listArtifacts returns the artifacts of a clone, optionally filtered by stage,
+// with download links served by the storage service through the gateway
+func (s *VoiceService) listArtifacts(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	db := s.reader(r)
+
+	var exists bool
+	err := db.GetContext(r.Context(), &exists, "SELECT EXISTS(SELECT 1 FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)", cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if !exists {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	query := "SELECT id, clone_id, stage, kind, file, content_type, created_at FROM clone_artifacts WHERE clone_id = $1"
+	args := []interface{}{cloneID}
+	if stage := r.URL.Query().Get("stage"); stage != "" {
+		query += " AND stage = $2"
+		args = append(args, stage)
+	}
+	query += " ORDER BY created_at, id"
+
+	artifacts := []types.CloneArtifact{}
+	if err := db.SelectContext(r.Context(), &artifacts, query, args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch artifacts")
+		return
+	}
+
+	for i := range artifacts {
+		artifacts[i].DownloadURL = "/api/storage/download/" + url.PathEscape(artifacts[i].File)
+	}
+
+	utils.SuccessResponse(w, artifacts)
+}
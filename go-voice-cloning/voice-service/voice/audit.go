@@ -0,0 +1,13 @@
+package voice
+
+// Operations recorded to the audit log
+const (
+	auditDeleteClone        = "delete_clone"
+	auditSetVisibility      = "set_clone_visibility"
+	auditShareClone         = "share_clone"
+	auditUnshareClone       = "unshare_clone"
+	auditPublishClone       = "publish_clone"
+	auditUnpublishClone     = "unpublish_clone"
+	auditModerateListing    = "moderate_listing"
+	auditRetentionExemption = "set_retention_exemption"
+)
@@ -0,0 +1,144 @@
+package voice
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+	"github.com/voice-cloning/shared/webhooks"
+)
+
+// CallbackSettings is the account-level webhook configuration. The secret
+// signs deliveries for both account-level and per-clone callback URLs.
+type CallbackSettings struct {
+	URL    *string `json:"url" db:"url"`
+	Secret string  `json:"secret" db:"secret"`
+}
+
+func (s *VoiceService) getCallback(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var settings CallbackSettings
+	err := s.db.GetContext(r.Context(), &settings, "SELECT url, secret FROM voice_callback_settings WHERE user_id = $1", userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.NotFound, "No callback configured")
+		return
+	}
+
+	utils.SuccessResponse(w, settings)
+}
+
+// putCallback sets the account-level callback URL, generating a signing
+// secret the first time
+func (s *VoiceService) putCallback(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	secret, err := webhooks.EnsureSecret(r.Context(), s.db, userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to save callback")
+		return
+	}
+	if _, err := s.db.ExecContext(r.Context(), "UPDATE voice_callback_settings SET url = $1, updated_at = NOW() WHERE user_id = $2", req.URL, userID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to save callback")
+		return
+	}
+
+	utils.SuccessResponse(w, CallbackSettings{URL: &req.URL, Secret: secret})
+}
+
+// deleteCallback removes the account-level URL. The secret is kept so
+// per-clone callbacks keep verifying.
+func (s *VoiceService) deleteCallback(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	if _, err := s.db.ExecContext(r.Context(), "UPDATE voice_callback_settings SET url = NULL, updated_at = NOW() WHERE user_id = $1", userID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete callback")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]string{"message": "Callback deleted successfully"})
+}
+
+// deliveryCursor is the position after the last delivery of a page
+type deliveryCursor struct {
+	ID int `json:"id"`
+}
+
+// listDeliveries reports the webhook delivery history of a clone, newest
+// first, with ?limit=/?cursor= pagination
+func (s *VoiceService) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total,
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE clone_id = $1 AND user_id = $2", cloneID, userID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch deliveries")
+		return
+	}
+
+	after := 0
+	var cursor deliveryCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		after = cursor.ID
+	}
+
+	deliveries := []webhooks.Delivery{}
+	err = db.SelectContext(r.Context(), &deliveries,
+		`SELECT id, clone_id, url, event, payload, status, attempts, last_status_code, last_error, next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE clone_id = $1 AND user_id = $2 AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4`,
+		cloneID, userID, after, page.Limit+1)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch deliveries")
+		return
+	}
+
+	utils.SuccessResponse(w, types.NewPage(page, deliveries, total, func(last webhooks.Delivery) interface{} {
+		return deliveryCursor{ID: last.ID}
+	}))
+}
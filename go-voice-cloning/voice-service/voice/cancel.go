@@ -0,0 +1,106 @@
+package voice
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// cancelClone stops a pending or processing clone. Pending jobs are skipped
+// when a worker picks them up; processing jobs have their context cancelled
+// in the worker running them. Files generated so far are deleted so they
+// stop counting against the user's output quota.
+func (s *VoiceService) cancelClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to cancel voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	var clone types.VoiceClone
+	err = tx.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	if clone.Status != types.StatusPending && clone.Status != types.StatusProcessing {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only pending or processing clones can be cancelled")
+		return
+	}
+
+	// A scheduled job is dropped before it is ever queued
+	_, err = tx.ExecContext(r.Context(), "UPDATE voice_clones SET status = $1, process_after = NULL, updated_at = $2 WHERE id = $3",
+		types.StatusCancelled, time.Now(), clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to cancel voice clone")
+		return
+	}
+	files, err := discardArtifacts(r.Context(), tx, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to cancel voice clone")
+		return
+	}
+	if err := events.PublishCloneStatus(r.Context(), tx, clone.ID, types.StatusCancelled); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to cancel voice clone")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to cancel voice clone")
+		return
+	}
+
+	if clone.Status == types.StatusProcessing {
+		if err := s.queue.Cancel(r.Context(), clone.ID); err != nil {
+			// The worker still stops at its next status update
+			logging.FromContext(r.Context()).Error("Failed to signal cancellation", "clone_id", clone.ID, "error", err)
+		}
+	}
+
+	s.deleteArtifactFiles(r.Context(), clone.ID, files)
+
+	utils.SuccessResponse(w, map[string]string{
+		"status":  types.StatusCancelled,
+		"message": "Voice clone cancelled",
+	})
+}
+
+// discardArtifacts removes a clone's artifact rows and returns their files
+func discardArtifacts(ctx context.Context, tx *sqlx.Tx, cloneID int) ([]string, error) {
+	files := []string{}
+	if err := tx.SelectContext(ctx, &files, "DELETE FROM clone_artifacts WHERE clone_id = $1 RETURNING file", cloneID); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// deleteArtifactFiles removes discarded artifact files from storage. Failures
+// are logged; the rows are already gone so the files are only orphaned.
+func (s *VoiceService) deleteArtifactFiles(ctx context.Context, cloneID int, files []string) {
+	for _, file := range files {
+		if err := s.deleteStoredFile(ctx, file); err != nil {
+			logging.FromContext(ctx).Warn("Failed to delete artifact", "file", file, "clone_id", cloneID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package voice
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/capabilities"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// languageCapability is a language any model speaks, with the models that
+// do and the accents of all of them
+type languageCapability struct {
+	Tag     string   `json:"tag"`
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+	Models  []string `json:"models"`
+}
+
+// capabilitiesResponse lists what clones can be trained with, for clients
+// to build pickers from. Defaults are the caller's effective settings.
+type capabilitiesResponse struct {
+	Models        []capabilities.Model `json:"models"`
+	Languages     []languageCapability `json:"languages"`
+	OutputFormats []string             `json:"output_formats"`
+	Defaults      types.CloneSettings  `json:"defaults"`
+}
+
+// getCapabilities lists the supported models, languages and accents. Engine
+// variants are internal and left out.
+func (s *VoiceService) getCapabilities(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	defaults, err := s.effectiveDefaults(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch capabilities")
+		return
+	}
+
+	resp := capabilitiesResponse{
+		Models:        make([]capabilities.Model, 0, len(s.capabilities.Models)),
+		Languages:     []languageCapability{},
+		OutputFormats: sortedKeys(supportedOutputFormats),
+		Defaults:      defaults.Effective,
+	}
+	byTag := map[string]int{}
+	for _, m := range s.capabilities.Models {
+		model := capabilities.Model{Name: m.Name, Description: m.Description}
+		for _, l := range m.Languages {
+			model.Languages = append(model.Languages, capabilities.Language{Tag: l.Tag, Name: l.Name, Locales: l.Locales})
+
+			i, ok := byTag[l.Tag]
+			if !ok {
+				i = len(resp.Languages)
+				byTag[l.Tag] = i
+				resp.Languages = append(resp.Languages, languageCapability{Tag: l.Tag, Name: l.Name, Locales: []string{}})
+			}
+			language := &resp.Languages[i]
+			language.Models = append(language.Models, m.Name)
+			for _, locale := range l.Locales {
+				if !contains(language.Locales, locale) {
+					language.Locales = append(language.Locales, locale)
+				}
+			}
+		}
+		resp.Models = append(resp.Models, model)
+	}
+	for _, language := range resp.Languages {
+		sort.Strings(language.Locales)
+	}
+
+	utils.SuccessResponse(w, resp)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package voice
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// dbRetryAfter is how long clients are told to wait when the database is
+// unavailable
+const dbRetryAfter = 5 * time.Second
+
+// untimedRoutes stream for as long as the client stays, or upload bodies of
+// any size, and get no request deadline
+var untimedRoutes = map[string]bool{
+	"/clones/direct":                               true,
+	"/clones/{id}/events":                          true,
+	"/clones/{id}/syntheses/{synthesis_id}/stream": true,
+	"/ws": true,
+}
+
+// requestTimeoutFromEnv bounds how long a request may spend, database
+// queries included (REQUEST_TIMEOUT_SECONDS, default 15)
+func requestTimeoutFromEnv() time.Duration {
+	return time.Duration(envInt("REQUEST_TIMEOUT_SECONDS", 15)) * time.Second
+}
+
+// withDeadline gives each request's context the service's request timeout,
+// so queries made with it are cancelled instead of hanging on a slow
+// database
+func (s *VoiceService) withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil && untimedRoutes[tpl] {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// dbUnavailable reports whether err means the database couldn't be reached
+// or didn't answer in time, rather than that the query itself failed
+func dbUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, and the server shutting down or
+		// cancelling the statement
+		class := pqErr.Code.Class()
+		return class == "08" || class == "57"
+	}
+	return false
+}
+
+// dbError answers a failed database call: 503 with retry guidance when the
+// database is unavailable, otherwise statusCode with code and message
+func dbError(w http.ResponseWriter, err error, statusCode int, code apierror.Code, message string) {
+	if dbUnavailable(err) {
+		utils.RetryResponse(w, http.StatusServiceUnavailable, "Database unavailable, try again later",
+			utils.NewRetryGuidance(utils.RetryReasonUpstreamUnavailable, dbRetryAfter))
+		return
+	}
+	utils.ErrorResponse(w, statusCode, code, message)
+}
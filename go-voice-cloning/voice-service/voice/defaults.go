@@ -0,0 +1,201 @@
+package voice
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Scopes of stored clone defaults
+const (
+	scopeOrg  = "org"
+	scopeUser = "user"
+)
+
+// builtinSettings apply when neither the org nor the user set a value
+var builtinSettings = types.CloneSettings{
+	Model:         "standard",
+	OutputFormats: []string{"wav"},
+	Preprocessing: &types.PreprocessingOptions{
+		Denoise:     boolPtr(false),
+		Normalize:   boolPtr(true),
+		TrimSilence: boolPtr(true),
+	},
+	Language: "en",
+}
+
+var (
+	supportedOutputFormats = map[string]bool{"wav": true, "mp3": true, "flac": true, "ogg": true}
+	languageTagPattern     = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	localeTagPattern       = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})+$`)
+)
+
+const maxModelLength = 100
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// validateSettings checks the fields that are set
+func validateSettings(settings types.CloneSettings) error {
+	if len(settings.Model) > maxModelLength {
+		return fmt.Errorf("model must be at most %d characters", maxModelLength)
+	}
+	for _, format := range settings.OutputFormats {
+		if !supportedOutputFormats[format] {
+			return fmt.Errorf("unsupported output format %q", format)
+		}
+	}
+	if settings.Language != "" && !languageTagPattern.MatchString(settings.Language) {
+		return fmt.Errorf("language must be a BCP 47 tag such as en or pt-BR")
+	}
+	if settings.Locale != "" && !localeTagPattern.MatchString(settings.Locale) {
+		return fmt.Errorf("locale must be a BCP 47 tag with a region such as en-GB")
+	}
+	return nil
+}
+
+// userOrg returns the org a user was invited into, if any
+func (s *VoiceService) userOrg(ctx context.Context, userID int) string {
+	var org sql.NullString
+	s.db.GetContext(ctx, &org, "SELECT org FROM user_invitations WHERE user_id = $1", userID)
+	return org.String
+}
+
+func (s *VoiceService) storedDefaults(ctx context.Context, scope, scopeID string) (types.CloneSettings, error) {
+	var settings types.CloneSettings
+	err := s.db.GetContext(ctx, &settings, "SELECT settings FROM clone_defaults WHERE scope = $1 AND scope_id = $2", scope, scopeID)
+	if err == sql.ErrNoRows {
+		return types.CloneSettings{}, nil
+	}
+	return settings, err
+}
+
+// defaultsResponse shows each layer and the result of merging them
+type defaultsResponse struct {
+	Org       string              `json:"org,omitempty"`
+	OrgLayer  types.CloneSettings `json:"org_defaults"`
+	UserLayer types.CloneSettings `json:"user_defaults"`
+	Effective types.CloneSettings `json:"effective"`
+}
+
+// effectiveDefaults merges built-in, org and user defaults for a user
+func (s *VoiceService) effectiveDefaults(ctx context.Context, userID int) (defaultsResponse, error) {
+	resp := defaultsResponse{Org: s.userOrg(ctx, userID)}
+
+	var err error
+	if resp.Org != "" {
+		if resp.OrgLayer, err = s.storedDefaults(ctx, scopeOrg, resp.Org); err != nil {
+			return resp, err
+		}
+	}
+	if resp.UserLayer, err = s.storedDefaults(ctx, scopeUser, fmt.Sprint(userID)); err != nil {
+		return resp, err
+	}
+
+	resp.Effective = builtinSettings.Merge(resp.OrgLayer).Merge(resp.UserLayer)
+	return resp, nil
+}
+
+func (s *VoiceService) getDefaults(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	resp, err := s.effectiveDefaults(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch defaults")
+		return
+	}
+	utils.SuccessResponse(w, resp)
+}
+
+// putDefaults replaces the user's own defaults
+func (s *VoiceService) putDefaults(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	if !s.saveDefaults(w, r, scopeUser, fmt.Sprint(userID)) {
+		return
+	}
+
+	resp, err := s.effectiveDefaults(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch defaults")
+		return
+	}
+	utils.SuccessResponse(w, resp)
+}
+
+// putOrgDefaults replaces an org's defaults. Admin only.
+func (s *VoiceService) putOrgDefaults(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	org := mux.Vars(r)["org"]
+	if !s.saveDefaults(w, r, scopeOrg, org) {
+		return
+	}
+
+	settings, err := s.storedDefaults(r.Context(), scopeOrg, org)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch defaults")
+		return
+	}
+	utils.SuccessResponse(w, map[string]interface{}{"org": org, "org_defaults": settings})
+}
+
+func (s *VoiceService) getOrgDefaults(w http.ResponseWriter, r *http.Request) {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return
+	}
+
+	org := mux.Vars(r)["org"]
+	settings, err := s.storedDefaults(r.Context(), scopeOrg, org)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch defaults")
+		return
+	}
+	utils.SuccessResponse(w, map[string]interface{}{"org": org, "org_defaults": settings})
+}
+
+// saveDefaults decodes, validates and stores a layer of defaults, writing an
+// error response and returning false on failure
+func (s *VoiceService) saveDefaults(w http.ResponseWriter, r *http.Request, scope, scopeID string) bool {
+	var settings types.CloneSettings
+	if !validation.DecodeJSON(w, r, &settings) {
+		return false
+	}
+	if err := validateSettings(settings); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+
+	_, err := s.db.ExecContext(r.Context(),
+		`INSERT INTO clone_defaults (scope, scope_id, settings, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (scope, scope_id) DO UPDATE SET settings = EXCLUDED.settings, updated_at = EXCLUDED.updated_at`,
+		scope, scopeID, settings)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to save defaults")
+		return false
+	}
+	return true
+}
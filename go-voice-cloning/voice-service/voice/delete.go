@@ -0,0 +1,171 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// deleteClone moves a clone to the trash. It disappears from every endpoint
+// but restore, and is purged with its artifacts and stored files once the
+// retention period passes. Queued and running jobs are cancelled; clones
+// being processed are only deleted with ?force=true.
+func (s *VoiceService) deleteClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	var clone types.VoiceClone
+	err = tx.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	if clone.Status == "processing" && r.URL.Query().Get("force") != "true" {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Voice clone is being processed; retry with force=true to delete it anyway")
+		return
+	}
+
+	status := clone.Status
+	if !types.IsTerminalStatus(status) {
+		status = types.StatusCancelled
+	}
+	var deletedAt time.Time
+	err = tx.GetContext(r.Context(), &deletedAt,
+		"UPDATE voice_clones SET deleted_at = NOW(), status = $1, updated_at = NOW() WHERE id = $2 RETURNING deleted_at",
+		status, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+		return
+	}
+	if status != clone.Status {
+		if err := events.PublishCloneStatus(r.Context(), tx, clone.ID, status); err != nil {
+			dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+			return
+		}
+	}
+	// Retrainings stop at their next progress update
+	_, err = tx.ExecContext(r.Context(), "UPDATE clone_models SET status = $1 WHERE clone_id = $2 AND status IN ($3, $4)",
+		types.StatusCancelled, clone.ID, types.StatusPending, types.StatusProcessing)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+		return
+	}
+	// A restored clone has to be published again
+	if _, err := tx.ExecContext(r.Context(), "DELETE FROM gallery_listings WHERE clone_id = $1", clone.ID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to delete voice clone")
+		return
+	}
+	s.forgetListing(r.Context(), clone.ID)
+	s.audit.Request(r, auditDeleteClone, "voice_clone", strconv.Itoa(clone.ID), audit.Success,
+		map[string]interface{}{"status": clone.Status})
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"message":    "Voice clone deleted",
+		"deleted_at": deletedAt,
+		"purge_at":   deletedAt.Add(s.retention.deleted),
+	})
+}
+
+// removeClone deletes a clone's rows and returns the stored files that
+// belonged only to it. A clone.deleted event names them, so the storage
+// service removes them even if the caller's own deletion fails.
+func removeClone(ctx context.Context, tx *sqlx.Tx, clone types.VoiceClone) ([]string, error) {
+	files := []string{}
+	err := tx.SelectContext(ctx, &files,
+		`SELECT file FROM clone_artifacts WHERE clone_id = $1
+		UNION SELECT file FROM clone_models WHERE clone_id = $1 AND file IS NOT NULL`, clone.ID)
+	if err != nil {
+		return nil, err
+	}
+	if clone.OutputFile != "" {
+		files = append(files, clone.OutputFile)
+	}
+	synthesized := []string{}
+	if err := tx.SelectContext(ctx, &synthesized, "SELECT output_file FROM synthesis_jobs WHERE clone_id = $1 AND output_file IS NOT NULL", clone.ID); err != nil {
+		return nil, err
+	}
+	files = append(files, synthesized...)
+
+	// Source samples can be shared between clones; keep them while in use
+	if err := loadSources(ctx, tx, &clone); err != nil {
+		return nil, err
+	}
+	unshared := []string{}
+	err = tx.SelectContext(ctx, &unshared,
+		`SELECT DISTINCT f FROM (
+			SELECT unnest($1::text[]) AS f
+			UNION SELECT unnest(source_files) FROM clone_models WHERE clone_id = $2
+		) sources
+		WHERE f <> '' AND NOT EXISTS (SELECT 1 FROM voice_clones WHERE source_file = f AND id <> $2)
+			AND NOT EXISTS (SELECT 1 FROM clone_sources WHERE filename = f AND clone_id <> $2)
+			AND NOT EXISTS (SELECT 1 FROM clone_models WHERE f = ANY(source_files) AND clone_id <> $2)`,
+		pq.StringArray(clone.SourceFiles), clone.ID)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, unshared...)
+
+	if err := events.PublishCloneDeleted(ctx, tx, clone.ID, files); err != nil {
+		return nil, err
+	}
+	// Sources, artifacts, models, manifests, deliveries and synthesis jobs cascade with the clone
+	if _, err := tx.ExecContext(ctx, "DELETE FROM voice_clones WHERE id = $1", clone.ID); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// deleteStoredFile removes a file from the storage service. Files that are
+// already gone count as deleted.
+func (s *VoiceService) deleteStoredFile(ctx context.Context, filename string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.storageURL+"/files/"+url.PathEscape(filename), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("storage service returned %d", resp.StatusCode)
+	}
+	return nil
+}
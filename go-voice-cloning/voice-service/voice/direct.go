@@ -0,0 +1,178 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// maxDirectMetadataBytes caps the metadata part of a direct clone request
+const maxDirectMetadataBytes = 64 << 10
+
+// storageRejection is an upload the storage service refused, such as one
+// breaking the audio sample policy or the user's quota. Its response is
+// passed on to the user.
+type storageRejection struct {
+	status int
+	body   []byte
+}
+
+func (e *storageRejection) Error() string {
+	return fmt.Sprintf("storage service rejected the upload with %d", e.status)
+}
+
+// createDirectClone uploads a source file and creates a clone from it in one
+// request. The multipart body holds a "metadata" part, the JSON of a clone
+// request without sources, followed by a "file" part that is streamed to
+// storage as it arrives. If the clone isn't created, the upload is deleted.
+func (s *VoiceService) createDirectClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Expected a multipart/form-data body")
+		return
+	}
+
+	var req types.VoiceCloneRequest
+	var haveMetadata bool
+	var source string
+	created := false
+	defer func() {
+		if source != "" && !created {
+			if err := s.deleteStoredFile(context.Background(), source); err != nil {
+				logging.FromContext(r.Context()).Warn("Failed to delete the upload of an abandoned direct clone", "file", source, "error", err)
+			}
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Failed to read form")
+			return
+		}
+
+		switch part.FormName() {
+		case "metadata":
+			err := json.NewDecoder(io.LimitReader(part, maxDirectMetadataBytes)).Decode(&req)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Invalid metadata")
+				return
+			}
+			if !validation.Check(w, &req) {
+				return
+			}
+			if req.SourceFile != "" || len(req.SourceFiles) > 0 {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "The uploaded file is the clone's source; omit source_file and source_files")
+				return
+			}
+			haveMetadata = true
+		case "file":
+			if !haveMetadata {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "The metadata part must come before the file")
+				return
+			}
+			if source != "" {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Only one file can be uploaded")
+				return
+			}
+			name := filepath.Base(part.FileName())
+			if name == "." || name == "/" {
+				name = "source"
+			}
+			id, err := s.uploadSource(r.Context(), userID, name, part)
+			var rejection *storageRejection
+			if errors.As(err, &rejection) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(rejection.status)
+				w.Write(rejection.body)
+				return
+			}
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Failed to upload the source of a direct clone", "error", err)
+				utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Failed to upload source audio")
+				return
+			}
+			source = id
+		}
+		part.Close()
+	}
+
+	if source == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "No file provided")
+		return
+	}
+	req.SourceFiles = []string{source}
+	created = s.startClone(w, r, userID, req)
+}
+
+// uploadSource streams a user's source audio to the storage service, which
+// checks it against the audio sample policy and the user's sample quota
+func (s *VoiceService) uploadSource(ctx context.Context, userID int, filename string, content io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		err := form.WriteField("type", "audio_sample")
+		var part io.Writer
+		if err == nil {
+			part, err = form.CreateFormFile("file", filename)
+		}
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.storageURL+"/upload", pr)
+	if err != nil {
+		pr.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return "", &storageRejection{status: resp.StatusCode, body: body}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("storage service returned %d", resp.StatusCode)
+	}
+	var stored struct {
+		ID string `json:"id"`
+	}
+	if err := utils.DecodeData(resp, &stored); err != nil || stored.ID == "" {
+		return "", fmt.Errorf("storage service returned no file ID")
+	}
+	return stored.ID, nil
+}
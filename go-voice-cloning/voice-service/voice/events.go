@@ -0,0 +1,282 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// subscriberSet holds the subscriber channels of each clone or user
+type subscriberSet map[int]map[chan types.CloneEvent]struct{}
+
+// EventHub fans out clone events published by the worker over Postgres
+// LISTEN/NOTIFY to the streams subscribed in this process, either to a
+// single clone or to every clone of a user. Upload progress published by the
+// storage service is fanned out to the user's streams the same way.
+type EventHub struct {
+	mu      sync.Mutex
+	clones  subscriberSet
+	users   subscriberSet
+	uploads map[int]map[chan types.UploadEvent]struct{}
+}
+
+func NewEventHub(dbURL string) *EventHub {
+	hub := &EventHub{clones: subscriberSet{}, users: subscriberSet{}, uploads: map[int]map[chan types.UploadEvent]struct{}{}}
+
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Clone event listener error", "error", err)
+		}
+	})
+	if err := listener.Listen(types.CloneEventsChannel); err != nil {
+		slog.Error("Failed to listen for clone events", "error", err)
+	}
+	if err := listener.Listen(types.UploadEventsChannel); err != nil {
+		slog.Error("Failed to listen for upload events", "error", err)
+	}
+	go hub.run(listener)
+
+	return hub
+}
+
+func (h *EventHub) run(listener *pq.Listener) {
+	for n := range listener.Notify {
+		if n == nil {
+			// Connection was re-established; streams resync by polling
+			continue
+		}
+		h.dispatch(n)
+	}
+}
+
+// dispatch passes a notification on to its subscribers. A panic is logged
+// and the notification dropped, so the listener outlives it; streams pick
+// up what they missed when they resync.
+func (h *EventHub) dispatch(n *pq.Notification) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("Panic dispatching event", "channel", n.Channel, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+		}
+	}()
+
+	if n.Channel == types.UploadEventsChannel {
+		var event types.UploadEvent
+		if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+			slog.Warn("Invalid upload event payload", "error", err)
+			return
+		}
+		h.publishUpload(event)
+		return
+	}
+	var event types.CloneEvent
+	if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+		slog.Warn("Invalid clone event payload", "error", err)
+		return
+	}
+	h.publish(event)
+}
+
+func (h *EventHub) publish(event types.CloneEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ch := range h.listeners(event) {
+		select {
+		case ch <- event:
+		default:
+			// Slow consumer; it will catch up from the database
+		}
+	}
+}
+
+func (h *EventHub) publishUpload(event types.UploadEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.uploads[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			// Slow consumer; a later event or the session reports the progress
+		}
+	}
+}
+
+func (h *EventHub) listeners(event types.CloneEvent) []chan types.CloneEvent {
+	var chans []chan types.CloneEvent
+	for ch := range h.clones[event.CloneID] {
+		chans = append(chans, ch)
+	}
+	for ch := range h.users[event.UserID] {
+		chans = append(chans, ch)
+	}
+	return chans
+}
+
+// Subscribe returns a channel of events for a clone and a function that
+// cancels the subscription
+func (h *EventHub) Subscribe(cloneID int) (<-chan types.CloneEvent, func()) {
+	return h.subscribe(h.clones, cloneID)
+}
+
+// SubscribeUser returns a channel of events for all clones of a user and a
+// function that cancels the subscription
+func (h *EventHub) SubscribeUser(userID int) (<-chan types.CloneEvent, func()) {
+	return h.subscribe(h.users, userID)
+}
+
+// SubscribeUploads returns a channel of progress events for all resumable
+// uploads of a user and a function that cancels the subscription
+func (h *EventHub) SubscribeUploads(userID int) (<-chan types.UploadEvent, func()) {
+	ch := make(chan types.UploadEvent, 16)
+
+	h.mu.Lock()
+	if h.uploads[userID] == nil {
+		h.uploads[userID] = map[chan types.UploadEvent]struct{}{}
+	}
+	h.uploads[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.uploads[userID], ch)
+		if len(h.uploads[userID]) == 0 {
+			delete(h.uploads, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *EventHub) subscribe(set subscriberSet, id int) (<-chan types.CloneEvent, func()) {
+	ch := make(chan types.CloneEvent, 16)
+
+	h.mu.Lock()
+	if set[id] == nil {
+		set[id] = map[chan types.CloneEvent]struct{}{}
+	}
+	set[id][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(set[id], ch)
+		if len(set[id]) == 0 {
+			delete(set, id)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// streamEvents streams a clone's status and progress changes as server-sent
+// events until
+// the job reaches a terminal state or the client disconnects
+func (s *VoiceService) streamEvents(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Streaming unsupported")
+		return
+	}
+
+	// Subscribe before reading the current state so no transition is missed
+	events, unsubscribe := s.events.Subscribe(cloneID)
+	defer unsubscribe()
+
+	current, err := s.currentEvent(r.Context(), cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	last := current
+	writeEvent(w, flusher, current)
+	if types.IsTerminalStatus(current.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	// Notifications can be lost while the listener reconnects, so the
+	// database is polled as a fallback
+	resync := time.NewTicker(10 * time.Second)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if sameState(event, last) {
+				continue
+			}
+			last = event
+		case <-resync.C:
+			event, err := s.currentEvent(r.Context(), cloneID, userID)
+			if err != nil || sameState(event, last) {
+				continue
+			}
+			if event.Status == last.Status {
+				event.Kind = types.EventKindProgress
+			}
+			last = event
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			continue
+		}
+
+		writeEvent(w, flusher, last)
+		if types.IsTerminalStatus(last.Status) {
+			return
+		}
+	}
+}
+
+func (s *VoiceService) currentEvent(ctx context.Context, cloneID, userID int) (types.CloneEvent, error) {
+	event := types.CloneEvent{Kind: types.EventKindStatus, CloneID: cloneID, UserID: userID}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT status, progress, COALESCE(stage, ''), updated_at FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL",
+		cloneID, userID).Scan(&event.Status, &event.Progress, &event.Stage, &event.OccurredAt)
+	return event, err
+}
+
+// sameState reports whether an event carries nothing new
+func sameState(a, b types.CloneEvent) bool {
+	return a.Status == b.Status && a.Progress == b.Progress && a.Stage == b.Stage
+}
+
+// writeEvent sends an event named after its kind, either status or progress
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event types.CloneEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data)
+	flusher.Flush()
+}
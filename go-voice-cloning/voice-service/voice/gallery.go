@@ -0,0 +1,460 @@
+package voice
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/signedurl"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Demo synthesis requests are short phrases
+const maxDemoTextLength = 200
+
+// galleryConfig holds the public gallery limits
+type galleryConfig struct {
+	demoLimit       int // demos per visitor per clone per day, and the cap owners can set
+	demoDailyCap    int // demos per clone per day across all visitors
+	reportThreshold int // open reports that send an approved listing back to review
+	signer          *signedurl.Keyring
+}
+
+func galleryConfigFromEnv() galleryConfig {
+	cfg := galleryConfig{
+		demoLimit:       envInt("GALLERY_DEMO_LIMIT", 5),
+		demoDailyCap:    envInt("GALLERY_DEMO_DAILY_CAP", 200),
+		reportThreshold: envInt("GALLERY_REPORT_THRESHOLD", 3),
+	}
+	// Demo audio is served through signed links since visitors have no token
+	if os.Getenv("URL_SIGNING_KEYS") != "" {
+		keys, err := signedurl.KeyringFromEnv()
+		if err == nil {
+			cfg.signer = keys
+		}
+	}
+	return cfg
+}
+
+func envInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return defaultValue
+}
+
+// galleryColumns selects a types.GalleryListing joined with its creator
+const galleryColumns = `g.clone_id, g.title, g.description, g.category, u.username AS creator, g.status,
+	COALESCE(g.review_note, '') AS review_note, g.demo_limit, g.submitted_at, g.published_at`
+
+const galleryFrom = ` FROM gallery_listings g JOIN users u ON u.id = g.user_id`
+
+func validCategory(category string) bool {
+	for _, c := range types.GalleryCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// publishClone publishes a completed clone to the gallery or updates its
+// listing. Every change goes through review before it is public again.
+func (s *VoiceService) publishClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req types.GalleryPublishRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || len(req.Title) > 255 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "title is required and at most 255 characters")
+		return
+	}
+	if !validCategory(req.Category) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("category must be one of %v", types.GalleryCategories))
+		return
+	}
+	demoLimit := s.gallery.demoLimit
+	if req.DemoLimit != nil {
+		if *req.DemoLimit < 0 || *req.DemoLimit > s.gallery.demoLimit {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("demo_limit must be between 0 and %d", s.gallery.demoLimit))
+			return
+		}
+		demoLimit = *req.DemoLimit
+	}
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone, "SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL", mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if clone.Status != types.StatusCompleted {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only completed clones can be published")
+		return
+	}
+
+	// Taken down listings stay down; the upsert leaves them untouched
+	res, err := s.db.ExecContext(r.Context(),
+		`INSERT INTO gallery_listings (clone_id, user_id, title, description, category, status, demo_limit, submitted_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		ON CONFLICT (clone_id) DO UPDATE SET title = EXCLUDED.title, description = EXCLUDED.description,
+			category = EXCLUDED.category, status = EXCLUDED.status, demo_limit = EXCLUDED.demo_limit,
+			review_note = NULL, submitted_at = EXCLUDED.submitted_at, updated_at = EXCLUDED.updated_at
+		WHERE gallery_listings.status <> $8`,
+		clone.ID, userID, req.Title, req.Description, req.Category, types.GalleryPendingReview, demoLimit, types.GalleryTakenDown)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to publish voice clone")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneTakenDown, "This clone was taken down and cannot be republished")
+		return
+	}
+	s.forgetListing(r.Context(), clone.ID)
+
+	listing, err := s.galleryListing(r.Context(), clone.ID, "")
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to publish voice clone")
+		return
+	}
+	s.audit.Request(r, auditPublishClone, "voice_clone", strconv.Itoa(clone.ID), audit.Success, nil)
+	utils.JSONResponse(w, http.StatusAccepted, listing)
+}
+
+// unpublishClone removes a clone's listing. Taken down listings are kept so
+// the decision survives.
+func (s *VoiceService) unpublishClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	res, err := s.db.ExecContext(r.Context(), "DELETE FROM gallery_listings WHERE clone_id = $1 AND user_id = $2 AND status <> $3",
+		mux.Vars(r)["id"], userID, types.GalleryTakenDown)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to unpublish voice clone")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone is not published")
+		return
+	}
+	s.forgetListing(r.Context(), mux.Vars(r)["id"])
+	s.audit.Request(r, auditUnpublishClone, "voice_clone", mux.Vars(r)["id"], audit.Success, nil)
+	utils.SuccessResponse(w, map[string]string{"message": "Voice clone unpublished"})
+}
+
+// galleryListing loads a listing, restricted to a status unless it's empty
+func (s *VoiceService) galleryListing(ctx context.Context, cloneID interface{}, status string) (types.GalleryListing, error) {
+	var listing types.GalleryListing
+	query := "SELECT " + galleryColumns + galleryFrom + " WHERE g.clone_id = $1"
+	args := []interface{}{cloneID}
+	if status != "" {
+		query += " AND g.status = $2"
+		args = append(args, status)
+	}
+	err := s.db.GetContext(ctx, &listing, query, args...)
+	return listing, err
+}
+
+// listingTTL is how long approved listings are cached. The voice service's
+// own changes to a listing drop it at once; a creator's new username shows
+// once it expires.
+const listingTTL = 5 * time.Minute
+
+// approvedListing returns an approved listing, from the cache while it is
+// fresh
+func (s *VoiceService) approvedListing(ctx context.Context, cloneID string) (types.GalleryListing, error) {
+	id, err := strconv.Atoi(cloneID)
+	if err != nil {
+		return s.galleryListing(ctx, cloneID, types.GalleryApproved)
+	}
+	key := strconv.Itoa(id)
+
+	var listing types.GalleryListing
+	ok, err := s.listings.Get(ctx, key, &listing)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to read cached listing", "clone_id", id, "error", err)
+	}
+	if ok {
+		return listing, nil
+	}
+	listing, err = s.galleryListing(ctx, id, types.GalleryApproved)
+	if err != nil {
+		return listing, err
+	}
+	if err := s.listings.Set(ctx, key, listing, listingTTL); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache listing", "clone_id", id, "error", err)
+	}
+	return listing, nil
+}
+
+// forgetListing drops a listing from the cache once it has changed
+func (s *VoiceService) forgetListing(ctx context.Context, cloneID interface{}) {
+	id, err := strconv.Atoi(fmt.Sprint(cloneID))
+	if err != nil {
+		return
+	}
+	if err := s.listings.Delete(ctx, strconv.Itoa(id)); err != nil {
+		logging.FromContext(ctx).Error("Failed to drop cached listing", "clone_id", cloneID, "error", err)
+	}
+}
+
+// galleryCursor is the keyset position after the last listing of a page
+type galleryCursor struct {
+	PublishedAt time.Time `json:"p"`
+	ID          int       `json:"id"`
+}
+
+// listGallery is the public gallery: approved listings, newest first.
+// Supports ?q= (title and description search), ?category= and
+// ?limit=/?cursor= pagination.
+func (s *VoiceService) listGallery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit, err := types.ParseLimit(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"g.status = $1"}
+	args := []interface{}{types.GalleryApproved}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if category := q.Get("category"); category != "" {
+		if !validCategory(category) {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("category must be one of %v", types.GalleryCategories))
+			return
+		}
+		where = append(where, "g.category = "+arg(category))
+	}
+	if search := strings.TrimSpace(q.Get("q")); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+		p := arg("%" + escaped + "%")
+		where = append(where, "(g.title ILIKE "+p+" OR g.description ILIKE "+p+")")
+	}
+
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*)"+galleryFrom+" WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch gallery")
+		return
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		var cursor galleryCursor
+		if err := types.DecodeCursor(c, &cursor); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, fmt.Sprintf("(g.published_at, g.clone_id) < (%s, %s)", arg(cursor.PublishedAt), arg(cursor.ID)))
+	}
+
+	query := fmt.Sprintf("SELECT %s%s WHERE %s ORDER BY g.published_at DESC, g.clone_id DESC LIMIT %s",
+		galleryColumns, galleryFrom, strings.Join(where, " AND "), arg(limit+1))
+
+	listings := []types.GalleryListing{}
+	if err := db.SelectContext(r.Context(), &listings, query, args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch gallery")
+		return
+	}
+
+	page := types.Pagination{Limit: limit, Total: total}
+	if len(listings) > limit {
+		listings = listings[:limit]
+		last := listings[len(listings)-1]
+		page.NextCursor = types.EncodeCursor(galleryCursor{PublishedAt: *last.PublishedAt, ID: last.CloneID})
+	}
+	for i := range listings {
+		publicListing(&listings[i])
+	}
+
+	utils.SuccessResponse(w, types.PageResponse{Data: listings, Pagination: page})
+}
+
+// publicListing hides moderation details from visitors
+func publicListing(listing *types.GalleryListing) {
+	listing.Status = ""
+	listing.ReviewNote = ""
+}
+
+// listGalleryCategories returns each category with its approved listings
+func (s *VoiceService) listGalleryCategories(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{}
+	rows := []struct {
+		Category string `db:"category"`
+		Count    int    `db:"count"`
+	}{}
+	err := s.reader(r).SelectContext(r.Context(), &rows,
+		"SELECT category, COUNT(*) AS count FROM gallery_listings WHERE status = $1 GROUP BY category",
+		types.GalleryApproved)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch categories")
+		return
+	}
+	for _, row := range rows {
+		counts[row.Category] = row.Count
+	}
+
+	categories := []map[string]interface{}{}
+	for _, c := range types.GalleryCategories {
+		categories = append(categories, map[string]interface{}{"category": c, "listings": counts[c]})
+	}
+	utils.SuccessResponse(w, categories)
+}
+
+func (s *VoiceService) getGalleryListing(w http.ResponseWriter, r *http.Request) {
+	listing, err := s.approvedListing(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.ListingNotFound, "Listing not found")
+		return
+	}
+	publicListing(&listing)
+	utils.SuccessResponse(w, listing)
+}
+
+// visitorID identifies a gallery visitor for limits and report dedup: the
+// user when signed in, otherwise a hash of the client address the gateway
+// appended to X-Forwarded-For
+func visitorID(r *http.Request) string {
+	if userID := middleware.UserID(r); userID != 0 {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	addr := r.RemoteAddr
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		addr = strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	sum := sha256.Sum256([]byte(addr))
+	return "ip:" + hex.EncodeToString(sum[:8])
+}
+
+// demoClone synthesizes a short phrase with a published clone for a
+// visitor, within the listing's per-visitor limit and the daily cap of the
+// clone. Synthesis is simulated: the clone's output stands in for it.
+func (s *VoiceService) demoClone(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" || len(req.Text) > maxDemoTextLength {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("text is required and at most %d characters", maxDemoTextLength))
+		return
+	}
+	if s.gallery.signer == nil {
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.FeatureDisabled, "Demos are not available")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to run demo")
+		return
+	}
+	defer tx.Rollback()
+
+	var listing struct {
+		DemoLimit  int    `db:"demo_limit"`
+		OutputFile string `db:"output_file"`
+	}
+	err = tx.GetContext(r.Context(), &listing,
+		`SELECT g.demo_limit, COALESCE(c.output_file, '') AS output_file
+		FROM gallery_listings g JOIN voice_clones c ON c.id = g.clone_id
+		WHERE g.clone_id = $1 AND g.status = $2 FOR UPDATE OF g`,
+		cloneID, types.GalleryApproved)
+	if err == sql.ErrNoRows || (err == nil && listing.OutputFile == "") {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.ListingNotFound, "Listing not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to run demo")
+		return
+	}
+
+	var used, usedToday int
+	visitor := visitorID(r)
+	err = tx.QueryRowContext(r.Context(),
+		`SELECT COALESCE(SUM(count) FILTER (WHERE visitor = $2), 0), COALESCE(SUM(count), 0)
+		FROM gallery_demo_usage WHERE clone_id = $1 AND day = CURRENT_DATE`,
+		cloneID, visitor).Scan(&used, &usedToday)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to run demo")
+		return
+	}
+	if used >= listing.DemoLimit || usedToday >= s.gallery.demoDailyCap {
+		w.Header().Set("Retry-After", strconv.Itoa(secondsUntilTomorrow()))
+		body := utils.ErrorBody(w, apierror.DemoLimitReached, "Demo limit reached for today")
+		body["limit"] = listing.DemoLimit
+		body["remaining"] = 0
+		utils.JSONResponse(w, http.StatusTooManyRequests, body)
+		return
+	}
+
+	_, err = tx.ExecContext(r.Context(),
+		`INSERT INTO gallery_demo_usage (clone_id, visitor, day, count) VALUES ($1, $2, CURRENT_DATE, 1)
+		ON CONFLICT (clone_id, visitor, day) DO UPDATE SET count = gallery_demo_usage.count + 1`,
+		cloneID, visitor)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to run demo")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to run demo")
+		return
+	}
+
+	expires := time.Now().Add(10 * time.Minute)
+	link := s.gallery.signer.Sign("/api/public/download/"+url.PathEscape(listing.OutputFile),
+		listing.OutputFile, signedurl.ScopeDownload, expires)
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"text":       req.Text,
+		"audio_url":  link,
+		"expires_at": expires.UTC(),
+		"remaining":  listing.DemoLimit - used - 1,
+	})
+}
+
+func secondsUntilTomorrow() int {
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	return int(tomorrow.Sub(now).Seconds()) + 1
+}
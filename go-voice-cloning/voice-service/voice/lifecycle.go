@@ -0,0 +1,125 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/types"
+)
+
+// Lifecycle relay tuning
+const (
+	lifecycleBatchSize = 100
+	// lifecycleStreamMaxLen caps the bus stream, trimmed approximately
+	lifecycleStreamMaxLen = 100000
+	// lifecycleRetention is how long published events stay in the outbox
+	lifecycleRetention = 7 * 24 * time.Hour
+)
+
+// runLifecycleRelay publishes outbox lifecycle events to the message bus
+// every interval until ctx is cancelled. clone.created, clone.completed and
+// clone.failed events also go to the event bus, when one is configured. Events the bus refuses stay in the
+// outbox and are retried on the next run.
+func (s *VoiceService) runLifecycleRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.relayLifecycleEvents(ctx)
+			if err != nil {
+				slog.Error("Lifecycle relay failed to publish clone events", "error", err)
+			}
+			// A full batch means more may be waiting
+			if err != nil || n < lifecycleBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayLifecycleEvents publishes a batch of unpublished events, oldest first,
+// and marks those the bus accepted. Relays running side by side skip each
+// other's rows; an event can be published twice if marking it fails, which
+// consumers handle by its ID.
+func (s *VoiceService) relayLifecycleEvents(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	pending := []types.CloneLifecycleEvent{}
+	err = tx.SelectContext(ctx, &pending,
+		`SELECT id, event, clone_id, user_id, status, files, created_at FROM clone_lifecycle_events
+		WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		lifecycleBatchSize)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	published := []int64{}
+	var publishErr error
+	for _, event := range pending {
+		event.OccurredAt = event.OccurredAt.UTC()
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return 0, err
+		}
+		publishErr = s.bus.XAdd(ctx, &redis.XAddArgs{
+			Stream: types.CloneLifecycleStream,
+			MaxLen: lifecycleStreamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"event": event.Event, "payload": payload},
+		}).Err()
+		if publishErr == nil {
+			switch event.Event {
+			case types.LifecycleCloneCreated:
+				publishErr = events.Publish(ctx, s.eventBus, events.CloneCreated{CloneID: event.CloneID, UserID: event.UserID, Status: event.Status})
+			case types.LifecycleCloneCompleted:
+				publishErr = events.Publish(ctx, s.eventBus, events.CloneCompleted{CloneID: event.CloneID, UserID: event.UserID})
+			case types.LifecycleCloneFailed:
+				publishErr = events.Publish(ctx, s.eventBus, events.CloneFailed{CloneID: event.CloneID, UserID: event.UserID})
+			}
+		}
+		if publishErr != nil {
+			// Keep the order: later events wait for this one
+			break
+		}
+		published = append(published, event.ID)
+	}
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE clone_lifecycle_events SET published_at = NOW() WHERE id = ANY($1)",
+			pq.Int64Array(published))
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(published), publishErr
+}
+
+// purgeLifecycleEvents deletes published events past their retention
+func (s *VoiceService) purgeLifecycleEvents(ctx context.Context) {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM clone_lifecycle_events WHERE published_at < $1",
+		time.Now().Add(-lifecycleRetention))
+	if err != nil {
+		slog.Error("Clone janitor failed to purge lifecycle events", "error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package voice
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// sortableCloneColumns maps sort parameter names to columns
+var sortableCloneColumns = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"name":       "name",
+}
+
+// cloneCursor is the keyset position after the last clone of a page
+type cloneCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// listClones returns a page of the user's clones. Supports ?status= (comma
+// separated), ?name= (substring), ?tag= (repeated or comma separated; clones
+// must have every tag), ?meta.<key>= (a metadata value), ?created_after=
+// and ?created_before= (RFC 3339), ?sort= (created_at, updated_at or name,
+// prefixed with - for descending) and ?limit=/?cursor= pagination.
+// ?archived=true lists archived clones instead, and ?deleted=true deleted
+// ones awaiting purge.
+func (s *VoiceService) listClones(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	q := r.URL.Query()
+
+	page, err := types.ParsePageRequest(q, defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	sort, err := types.ParseSort(q, sortableCloneColumns, "-created_at")
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	// Filters. Archived and deleted clones are only listed on request. On
+	// behalf of an org, its clones are listed instead of the caller's.
+	where := []string{"user_id = $1"}
+	owner := userID
+	if orgID := orgs.FromRequest(r); orgID != 0 {
+		where[0], owner = "org_id = $1", orgID
+	}
+	switch {
+	case q.Get("deleted") == "true":
+		where = append(where, "deleted_at IS NOT NULL")
+	case q.Get("archived") == "true":
+		where = append(where, "deleted_at IS NULL", "archived_at IS NOT NULL")
+	default:
+		where = append(where, "deleted_at IS NULL", "archived_at IS NULL")
+	}
+	args := []interface{}{owner}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if statuses := q.Get("status"); statuses != "" {
+		placeholders := []string{}
+		for _, status := range strings.Split(statuses, ",") {
+			placeholders = append(placeholders, arg(strings.TrimSpace(status)))
+		}
+		where = append(where, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if name := q.Get("name"); name != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name)
+		where = append(where, "name ILIKE "+arg("%"+escaped+"%"))
+	}
+	if tagParams := q["tag"]; len(tagParams) > 0 {
+		tags, err := normalizeTags(strings.Split(strings.Join(tagParams, ","), ","))
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+			return
+		}
+		if len(tags) > 0 {
+			where = append(where, "tags @> "+arg(pq.StringArray(tags)))
+		}
+	}
+	// Metadata filters match string values, using the GIN index on metadata
+	if filter := metadataFilter(q); len(filter) > 0 {
+		where = append(where, "metadata @> "+arg(filter))
+	}
+	for _, bound := range []struct{ param, op string }{{"created_after", ">="}, {"created_before", "<"}} {
+		param, op := bound.param, bound.op
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, param+" must be an RFC 3339 timestamp")
+			return
+		}
+		where = append(where, "created_at "+op+" "+arg(t))
+	}
+
+	// Listing tolerates replica lag
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM voice_clones WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch voice clones")
+		return
+	}
+
+	// Keyset pagination continues after the cursor position
+	var cursor cloneCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil || cursor.Sort != sort.Name {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		var value interface{} = cursor.Value
+		if sort.Column != "name" {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+				return
+			}
+			value = t
+		}
+		where = append(where, sort.After(value, cursor.ID, arg))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM voice_clones WHERE %s %s %s",
+		cloneColumns, strings.Join(where, " AND "), sort.OrderBy(), page.LimitClause(arg))
+
+	clones := []types.VoiceClone{}
+	if err := db.SelectContext(r.Context(), &clones, query, args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch voice clones")
+		return
+	}
+
+	for i := range clones {
+		if clones[i].UserID != userID {
+			sharedView(&clones[i])
+		}
+	}
+	utils.SuccessResponse(w, types.NewPage(page, clones, total, func(last types.VoiceClone) interface{} {
+		cursor := cloneCursor{Sort: sort.Name, ID: last.ID}
+		switch sort.Column {
+		case "name":
+			cursor.Value = last.Name
+		case "updated_at":
+			cursor.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+		default:
+			cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		return cursor
+	}))
+}
+
+// metadataFilter collects ?meta.<key>=value parameters
+func metadataFilter(q url.Values) types.CloneMetadata {
+	filter := types.CloneMetadata{}
+	for param, values := range q {
+		if key := strings.TrimPrefix(param, "meta."); key != param && key != "" && len(values) > 0 {
+			filter[key] = values[0]
+		}
+	}
+	return filter
+}
@@ -0,0 +1,49 @@
+package voice
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/manifest"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// getManifest returns the signed reproducibility manifest of a completed
+// clone. ?download=1 serves it as an attachment.
+func (s *VoiceService) getManifest(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	var signed manifest.Signed
+	var payload string
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT m.manifest, m.algorithm, m.key_id, m.public_key, m.signature
+		FROM clone_manifests m
+		JOIN voice_clones c ON c.id = m.clone_id
+		WHERE m.clone_id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL`, cloneID, userID,
+	).Scan(&payload, &signed.Algorithm, &signed.KeyID, &signed.PublicKey, &signed.Signature)
+	if err == sql.ErrNoRows {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Manifest not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch manifest")
+		return
+	}
+	signed.Payload = []byte(payload)
+
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="clone_%s_manifest.json"`, cloneID))
+	}
+	utils.SuccessResponse(w, signed)
+}
@@ -0,0 +1,223 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// modelColumns selects a full types.CloneModel row from clone_models m
+// joined with its clone c
+const modelColumns = `m.clone_id, m.version, m.status, m.source_files, m.source_versions, m.progress,
+	COALESCE(m.error_code, '') AS error_code, COALESCE(m.error_message, '') AS error_message,
+	m.quality, COALESCE(m.preview_file, '') AS preview_file, m.version = COALESCE(c.model_version, 0) AS current, m.created_at, m.completed_at`
+
+// retraining reports whether a new model version of the clone is queued or
+// being trained
+func retraining(ctx context.Context, db sqlx.QueryerContext, cloneID int) (bool, error) {
+	var running bool
+	err := sqlx.GetContext(ctx, db, &running,
+		"SELECT EXISTS (SELECT 1 FROM clone_models WHERE clone_id = $1 AND status IN ($2, $3))",
+		cloneID, types.StatusPending, types.StatusProcessing)
+	return running, err
+}
+
+// retrainClone trains a new model version of a completed clone, on new
+// source files or its current ones. The clone stays usable meanwhile and
+// switches to the new version once it completes; earlier versions can
+// still be pinned for synthesis.
+func (s *VoiceService) retrainClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	// The body is optional
+	var req types.RetrainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidRequestBody, "Invalid request body")
+		return
+	}
+	if !validation.Check(w, &req) {
+		return
+	}
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL",
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if clone.Status != types.StatusCompleted {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only completed clones can be retrained")
+		return
+	}
+	if clone.ArchivedAt != nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneArchived, "Voice clone is archived; unarchive it to retrain")
+		return
+	}
+
+	sources, versions := req.SourceFiles, types.SourceVersions{}
+	for file, version := range req.SourceVersions {
+		versions[file] = version
+	}
+	if len(sources) == 0 {
+		if err := loadSources(r.Context(), s.db, &clone); err != nil {
+			dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+			return
+		}
+		// The clone's sources at the versions it was trained on, so the
+		// retraining is reproducible
+		sources, versions = clone.SourceFiles, clone.SourceVersions
+	}
+	sources, err = cloneSources(types.VoiceCloneRequest{SourceFiles: sources})
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	// The current sources may have been deleted since the clone was trained
+	problems, err := s.validateSources(r.Context(), userID, sources, versions)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to validate the sources of a retraining", "error", err)
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Failed to validate source audio")
+		return
+	}
+	if len(problems) > 0 {
+		sourcesInvalid(w, problems)
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	// A retraining is a clone job; the quota lock also keeps two requests
+	// from retraining at once
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+		return
+	}
+	err = s.checkCloneQuota(r.Context(), tx, userID, entitlements, false)
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		quotaExceeded(w, quotaErr)
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+		return
+	}
+	running, err := retraining(r.Context(), tx, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+		return
+	}
+	if running {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Voice clone is already being retrained")
+		return
+	}
+
+	var model types.CloneModel
+	err = tx.GetContext(r.Context(), &model,
+		`INSERT INTO clone_models (clone_id, version, status, source_files, source_versions, created_at)
+		SELECT id, COALESCE((SELECT MAX(version) FROM clone_models WHERE clone_id = $1), 0) + 1, $2, $3, $6, $4
+		FROM voice_clones WHERE id = $1 AND status = $5 AND archived_at IS NULL AND deleted_at IS NULL
+		RETURNING clone_id, version, status, source_files, source_versions, progress, created_at`,
+		clone.ID, types.StatusPending, pq.StringArray(sources), time.Now(), types.StatusCompleted, versions)
+	if err != nil {
+		// Archived or deleted since it was read
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Voice clone can no longer be retrained")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retrain voice clone")
+		return
+	}
+
+	err = s.queue.Enqueue(r.Context(), jobqueue.Job{CloneID: clone.ID, Priority: clone.Priority, ModelVersion: model.Version})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to enqueue retraining", "clone_id", clone.ID, "error", err)
+		s.db.ExecContext(r.Context(), "DELETE FROM clone_models WHERE clone_id = $1 AND version = $2", clone.ID, model.Version)
+		w.Header().Set("Retry-After", "30")
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Processing queue unavailable, try again later")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/voice/clones/%d/models/%d", clone.ID, model.Version))
+	utils.JSONResponse(w, http.StatusAccepted, model)
+}
+
+// listModels returns the model versions of a clone, newest first. Users the
+// clone is shared with see them too, so they can pin one for synthesis.
+func (s *VoiceService) listModels(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	clone, err := accessibleClone(r.Context(), s.reader(r), mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	models := []types.CloneModel{}
+	err = s.reader(r).SelectContext(r.Context(), &models,
+		"SELECT "+modelColumns+" FROM clone_models m JOIN voice_clones c ON c.id = m.clone_id WHERE m.clone_id = $1 ORDER BY m.version DESC",
+		clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch model versions")
+		return
+	}
+	utils.SuccessResponse(w, models)
+}
+
+// getModel returns one model version of a clone
+func (s *VoiceService) getModel(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	clone, err := accessibleClone(r.Context(), s.db, vars["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	var model types.CloneModel
+	err = s.db.GetContext(r.Context(), &model,
+		"SELECT "+modelColumns+" FROM clone_models m JOIN voice_clones c ON c.id = m.clone_id WHERE m.clone_id = $1 AND m.version = $2",
+		clone.ID, vars["version"])
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VersionNotFound, "Model version not found")
+		return
+	}
+	utils.SuccessResponse(w, model)
+}
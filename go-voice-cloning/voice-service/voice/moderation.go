@@ -0,0 +1,345 @@
+package voice
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Abuse report states
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// queuedListing is a listing awaiting moderation with its open reports
+type queuedListing struct {
+	types.GalleryListing
+	OpenReports int `json:"open_reports" db:"open_reports"`
+}
+
+// queueCursor is the keyset position after the last listing of a
+// moderation queue page
+type queueCursor struct {
+	SubmittedAt time.Time `json:"s"`
+	ID          int       `json:"id"`
+}
+
+// reportCursor is the keyset position after the last report of a page
+type reportCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int       `json:"id"`
+}
+
+// reportSort lists the oldest reports first, so they are handled in turn
+var reportSort = types.PageSort{Name: "created_at", Column: "created_at"}
+
+func validReason(reason string) bool {
+	for _, r := range types.AbuseReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// reportListing files an abuse report against a public listing. Enough open
+// reports pull an approved listing out of the gallery until a moderator
+// reviews it.
+func (s *VoiceService) reportListing(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if !validReason(req.Reason) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("reason must be one of %v", types.AbuseReasons))
+		return
+	}
+	if len(req.Details) > 2000 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "details must be at most 2000 characters")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to file report")
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.GetContext(r.Context(), &status, "SELECT status FROM gallery_listings WHERE clone_id = $1 AND status = $2 FOR UPDATE",
+		cloneID, types.GalleryApproved)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.ListingNotFound, "Listing not found")
+		return
+	}
+
+	// One open report per visitor and listing
+	res, err := tx.ExecContext(r.Context(),
+		`INSERT INTO gallery_reports (clone_id, reason, details, reporter, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (clone_id, reporter) WHERE status = 'open' DO NOTHING`,
+		cloneID, req.Reason, req.Details, visitorID(r), ReportOpen)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to file report")
+		return
+	}
+
+	hidden := false
+	if n, _ := res.RowsAffected(); n > 0 && s.gallery.reportThreshold > 0 {
+		var open int
+		if err := tx.GetContext(r.Context(), &open, "SELECT COUNT(*) FROM gallery_reports WHERE clone_id = $1 AND status = $2", cloneID, ReportOpen); err != nil {
+			dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to file report")
+			return
+		}
+		if open >= s.gallery.reportThreshold {
+			_, err := tx.ExecContext(r.Context(), "UPDATE gallery_listings SET status = $1, review_note = $2, updated_at = NOW() WHERE clone_id = $3",
+				types.GalleryPendingReview, fmt.Sprintf("Hidden after %d abuse reports", open), cloneID)
+			if err != nil {
+				dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to file report")
+				return
+			}
+			logging.FromContext(r.Context()).Info("Gallery listing hidden for review", "clone_id", cloneID, "reports", open)
+			hidden = true
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to file report")
+		return
+	}
+	if hidden {
+		s.forgetListing(r.Context(), cloneID)
+	}
+
+	utils.JSONResponse(w, http.StatusAccepted, map[string]string{"message": "Report received"})
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !middleware.IsAdmin(r) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.AdminRequired, "Admin role required")
+		return false
+	}
+	return true
+}
+
+// listModerationQueue lists listings by moderation state, pending review by
+// default, oldest submission first, with their open report counts
+func (s *VoiceService) listModerationQueue(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = types.GalleryPendingReview
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"g.status = $1"}
+	args := []interface{}{status}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*)"+galleryFrom+" WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch moderation queue")
+		return
+	}
+
+	var cursor queueCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, fmt.Sprintf("(g.submitted_at, g.clone_id) > (%s, %s)", arg(cursor.SubmittedAt), arg(cursor.ID)))
+	}
+
+	listings := []queuedListing{}
+	err = s.db.SelectContext(r.Context(), &listings,
+		`SELECT `+galleryColumns+`,
+			(SELECT COUNT(*) FROM gallery_reports gr WHERE gr.clone_id = g.clone_id AND gr.status = 'open') AS open_reports`+
+			galleryFrom+` WHERE `+strings.Join(where, " AND ")+` ORDER BY g.submitted_at, g.clone_id `+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch moderation queue")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, listings, total, func(last queuedListing) interface{} {
+		return queueCursor{SubmittedAt: last.SubmittedAt, ID: last.CloneID}
+	}))
+}
+
+// reviewListing approves or rejects a listing. Approving dismisses open
+// reports as overruled; rejecting resolves them.
+func (s *VoiceService) reviewListing(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Decision string `json:"decision"` // approve or reject
+		Note     string `json:"note"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var status, reportStatus string
+	switch req.Decision {
+	case "approve":
+		status, reportStatus = types.GalleryApproved, ReportDismissed
+	case "reject":
+		status, reportStatus = types.GalleryRejected, ReportResolved
+	default:
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "decision must be approve or reject")
+		return
+	}
+
+	s.moderate(w, r, status, reportStatus, req.Note)
+}
+
+// takeDownListing removes a listing from the gallery for good and resolves
+// its reports. The owner cannot republish it.
+func (s *VoiceService) takeDownListing(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Note) == "" {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "note is required")
+		return
+	}
+
+	s.moderate(w, r, types.GalleryTakenDown, ReportResolved, req.Note)
+}
+
+// moderate moves a listing to status and closes its open reports
+func (s *VoiceService) moderate(w http.ResponseWriter, r *http.Request, status, reportStatus, note string) {
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update listing")
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(r.Context(),
+		`UPDATE gallery_listings SET status = $1, review_note = NULLIF($2, ''), reviewed_at = NOW(), updated_at = NOW(),
+			published_at = CASE WHEN $3 THEN COALESCE(published_at, NOW()) ELSE published_at END
+		WHERE clone_id = $4`,
+		status, note, status == types.GalleryApproved, cloneID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update listing")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.ListingNotFound, "Listing not found")
+		return
+	}
+	_, err = tx.ExecContext(r.Context(), "UPDATE gallery_reports SET status = $1, resolved_at = NOW() WHERE clone_id = $2 AND status = $3",
+		reportStatus, cloneID, ReportOpen)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update listing")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update listing")
+		return
+	}
+	s.forgetListing(r.Context(), cloneID)
+
+	listing, err := s.galleryListing(r.Context(), cloneID, "")
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update listing")
+		return
+	}
+	s.audit.Request(r, auditModerateListing, "voice_clone", cloneID, audit.Success,
+		map[string]interface{}{"status": status, "note": note})
+	utils.SuccessResponse(w, listing)
+}
+
+// listAbuseReports lists reports by state, open by default
+func (s *VoiceService) listAbuseReports(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = ReportOpen
+	}
+
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"status = $1"}
+	args := []interface{}{status}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var total int
+	if err := s.db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM gallery_reports WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch reports")
+		return
+	}
+
+	var cursor reportCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, reportSort.After(cursor.CreatedAt, cursor.ID, arg))
+	}
+
+	reports := []types.AbuseReport{}
+	err = s.db.SelectContext(r.Context(), &reports,
+		`SELECT id, clone_id, reason, COALESCE(details, '') AS details, status, created_at, resolved_at
+		FROM gallery_reports WHERE `+strings.Join(where, " AND ")+" "+reportSort.OrderBy()+" "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch reports")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, reports, total, func(last types.AbuseReport) interface{} {
+		return reportCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}))
+}
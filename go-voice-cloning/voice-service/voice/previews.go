@@ -0,0 +1,70 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+)
+
+// syncPreviewACL gives a clone's previews the ACL matching its visibility,
+// shared with the users the clone is shared with, so whoever can use a
+// clone can listen to it. Failures are logged rather than undoing the
+// sharing change; the next change syncs the previews again.
+func (s *VoiceService) syncPreviewACL(ctx context.Context, cloneID string) {
+	var visibility string
+	if err := s.db.GetContext(ctx, &visibility, "SELECT visibility FROM voice_clones WHERE id = $1", cloneID); err != nil {
+		logging.FromContext(ctx).Error("Failed to sync preview ACLs", "clone_id", cloneID, "error", err)
+		return
+	}
+	var previews []string
+	err := s.db.SelectContext(ctx, &previews,
+		`SELECT preview_file FROM voice_clones WHERE id = $1 AND preview_file IS NOT NULL
+		UNION SELECT preview_file FROM clone_models WHERE clone_id = $1 AND preview_file IS NOT NULL`, cloneID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to sync preview ACLs", "clone_id", cloneID, "error", err)
+		return
+	}
+	acl := types.FileACLRequest{ACL: types.PreviewACL(visibility), Users: []int{}}
+	if err := s.db.SelectContext(ctx, &acl.Users,
+		"SELECT user_id FROM clone_shares WHERE clone_id = $1 ORDER BY user_id", cloneID); err != nil {
+		logging.FromContext(ctx).Error("Failed to sync preview ACLs", "clone_id", cloneID, "error", err)
+		return
+	}
+
+	for _, preview := range previews {
+		if err := s.setFileACL(ctx, preview, acl); err != nil {
+			logging.FromContext(ctx).Error("Failed to set the ACL of a preview", "file", preview, "clone_id", cloneID, "error", err)
+		}
+	}
+}
+
+// setFileACL sets who can read a stored file
+func (s *VoiceService) setFileACL(ctx context.Context, filename string, acl types.FileACLRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(acl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		s.storageURL+"/files/"+url.PathEscape(filename)+"/acl", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("storage service returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+)
+
+// errPlanPriority is returned for a tier above what the user's plan allows
+var errPlanPriority = errors.New("priority not included in plan")
+
+// userEntitlements returns what a user's plan includes, as the user service
+// reports it. While the user service can't be reached, the plan's limits
+// configured here apply.
+func (s *VoiceService) userEntitlements(ctx context.Context, userID int) (types.Entitlements, error) {
+	e, err := s.entitlements.Get(ctx, userID)
+	if err == nil {
+		return e, nil
+	}
+	logging.FromContext(ctx).Warn("Failed to fetch entitlements, using the local plan limits", "user_id", userID, "error", err)
+	var plan string
+	if err := s.db.GetContext(ctx, &plan, "SELECT plan FROM users WHERE id = $1", userID); err != nil {
+		return e, err
+	}
+	return types.Entitlements{Plan: plan, PlanLimits: s.limitsFor(plan), MaxPriority: types.MaxPriority(plan)}, nil
+}
+
+// resolvePriority validates a requested priority against a plan's
+// entitlements. An empty request gets the plan's highest tier.
+func resolvePriority(e types.Entitlements, requested string) (string, error) {
+	plan, max := e.Plan, e.MaxPriority
+	if requested == "" {
+		return max, nil
+	}
+	if types.PriorityRank(requested) < 0 {
+		return "", fmt.Errorf("priority must be one of %v", types.Priorities)
+	}
+	if types.PriorityRank(requested) > types.PriorityRank(max) {
+		return "", fmt.Errorf("%w: the %s plan allows up to %s", errPlanPriority, plan, max)
+	}
+	return requested, nil
+}
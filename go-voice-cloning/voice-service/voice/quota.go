@@ -0,0 +1,243 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// quotaLockSpace namespaces the advisory locks that serialize a user's
+// quota checks, so concurrent requests can't both take the last slot
+const quotaLockSpace = 4301
+
+// planLimitsFromEnv starts from the default limits of each plan.
+// PLAN_<PLAN>_MAX_CLONES, PLAN_<PLAN>_MAX_CONCURRENT_JOBS and
+// PLAN_<PLAN>_SYNTHESIS_MINUTES override them, 0 meaning unlimited. Requests
+// are checked against the user service's entitlements; these apply to the
+// inactivity janitor and while the user service can't be reached.
+func planLimitsFromEnv() map[string]types.PlanLimits {
+	limits := map[string]types.PlanLimits{}
+	for plan, l := range types.DefaultPlanLimits {
+		prefix := "PLAN_" + strings.ToUpper(plan) + "_"
+		limits[plan] = types.PlanLimits{
+			MaxClones:                envInt(prefix+"MAX_CLONES", l.MaxClones),
+			MaxConcurrentJobs:        envInt(prefix+"MAX_CONCURRENT_JOBS", l.MaxConcurrentJobs),
+			MaxProcessingJobs:        envInt(prefix+"MAX_PROCESSING_JOBS", l.MaxProcessingJobs),
+			SynthesisMinutesPerMonth: envInt(prefix+"SYNTHESIS_MINUTES", l.SynthesisMinutesPerMonth),
+			InactiveCloneDays:        envInt(prefix+"INACTIVE_CLONE_DAYS", l.InactiveCloneDays),
+		}
+	}
+	return limits
+}
+
+// limitsFor returns a plan's limits. Unknown plans get the free plan's.
+func (s *VoiceService) limitsFor(plan string) types.PlanLimits {
+	if l, ok := s.planLimits[plan]; ok {
+		return l
+	}
+	return s.planLimits[types.PlanFree]
+}
+
+// quotaError is a request refused because it would exceed a plan limit
+type quotaError struct {
+	quota string
+	limit int
+	plan  string
+}
+
+func (e *quotaError) Error() string {
+	switch e.quota {
+	case types.QuotaClones:
+		return fmt.Sprintf("The %s plan allows %d voice clones; delete one or upgrade your plan", e.plan, e.limit)
+	case types.QuotaConcurrentJobs:
+		return fmt.Sprintf("The %s plan allows %d clone jobs at a time; wait for one to finish", e.plan, e.limit)
+	default:
+		return fmt.Sprintf("The %s plan allows %d synthesis minutes per month", e.plan, e.limit)
+	}
+}
+
+// quotaExceeded responds to a quotaError: 429 for the concurrency limit,
+// which frees up by itself, and 403 for the rest
+func quotaExceeded(w http.ResponseWriter, e *quotaError) {
+	status := http.StatusForbidden
+	if e.quota == types.QuotaConcurrentJobs {
+		w.Header().Set("Retry-After", "60")
+		status = http.StatusTooManyRequests
+	}
+	body := utils.ErrorBody(w, apierror.QuotaExceeded, e.Error())
+	body["quota"] = e.quota
+	body["limit"] = e.limit
+	body["plan"] = e.plan
+	utils.JSONResponse(w, status, body)
+}
+
+// lockQuota serializes the user's quota checks until tx ends
+func lockQuota(ctx context.Context, tx *sqlx.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", quotaLockSpace, userID)
+	return err
+}
+
+// checkCloneQuota checks that the user can start a clone job, and create a
+// new clone when newClone is set. Call it in the transaction that queues
+// the job; it holds the user's quota lock until the transaction ends.
+func (s *VoiceService) checkCloneQuota(ctx context.Context, tx *sqlx.Tx, userID int, e types.Entitlements, newClone bool) error {
+	if err := lockQuota(ctx, tx, userID); err != nil {
+		return err
+	}
+	limits := e.PlanLimits
+
+	if newClone {
+		if err := s.checkCloneCount(ctx, tx, userID, e); err != nil {
+			return err
+		}
+	}
+	if limits.MaxConcurrentJobs > 0 {
+		var running int
+		err := tx.GetContext(ctx, &running, "SELECT "+runningJobs, userID, types.StatusPending, types.StatusProcessing)
+		if err != nil {
+			return err
+		}
+		if running >= limits.MaxConcurrentJobs {
+			return &quotaError{types.QuotaConcurrentJobs, limits.MaxConcurrentJobs, e.Plan}
+		}
+	}
+	return nil
+}
+
+// runningJobs counts the user's ($1) clone jobs, including retrainings,
+// in the statuses $2 and $3. Scheduled jobs count once they are queued.
+const runningJobs = `(SELECT COUNT(*) FROM voice_clones WHERE user_id = $1 AND status IN ($2, $3) AND process_after IS NULL)
+	+ (SELECT COUNT(*) FROM clone_models m JOIN voice_clones c ON c.id = m.clone_id
+		WHERE c.user_id = $1 AND m.status IN ($2, $3))`
+
+// processingJobs counts the user's ($1) jobs in status $3 that the workers
+// are running, synthesis included. The workers enforce the plan's limit on
+// them by holding the rest back.
+const processingJobs = `((SELECT COUNT(*) FROM voice_clones WHERE user_id = $1 AND status = $3)
+	+ (SELECT COUNT(*) FROM clone_models m JOIN voice_clones c ON c.id = m.clone_id
+		WHERE c.user_id = $1 AND m.status = $3)
+	+ (SELECT COUNT(*) FROM synthesis_jobs WHERE user_id = $1 AND status = $3))`
+
+// checkCloneCount checks that the user can have another clone. The caller
+// holds the user's quota lock.
+func (s *VoiceService) checkCloneCount(ctx context.Context, tx *sqlx.Tx, userID int, e types.Entitlements) error {
+	limit := e.MaxClones
+	if limit == 0 {
+		return nil
+	}
+	var clones int
+	if err := tx.GetContext(ctx, &clones, "SELECT COUNT(*) FROM voice_clones WHERE user_id = $1 AND deleted_at IS NULL", userID); err != nil {
+		return err
+	}
+	if clones >= limit {
+		return &quotaError{types.QuotaClones, limit, e.Plan}
+	}
+	return nil
+}
+
+// checkSynthesisQuota checks that the user has synthesis minutes left this
+// month. A job's length is only known once it ran, so the job that uses up
+// the allowance may overrun it.
+func (s *VoiceService) checkSynthesisQuota(ctx context.Context, db sqlx.QueryerContext, userID int, e types.Entitlements) error {
+	limit := e.SynthesisMinutesPerMonth
+	if limit == 0 {
+		return nil
+	}
+	start, _ := quotaPeriod(time.Now())
+	used, err := synthesisMinutes(ctx, db, userID, start)
+	if err != nil {
+		return err
+	}
+	if used >= float64(limit) {
+		return &quotaError{types.QuotaSynthesisMinutes, limit, e.Plan}
+	}
+	return nil
+}
+
+// quotaPeriod is the calendar month (UTC) containing t
+func quotaPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// synthesisMinutes is the length of the speech the user synthesized, with
+// any clone, in the period starting at start. The worker adds to the usage
+// as jobs complete; it is kept apart from the jobs so deleting a clone
+// doesn't give minutes back.
+func synthesisMinutes(ctx context.Context, db sqlx.QueryerContext, userID int, start time.Time) (float64, error) {
+	var ms int64
+	err := sqlx.GetContext(ctx, db, &ms,
+		"SELECT COALESCE(SUM(duration_ms), 0) FROM synthesis_usage WHERE user_id = $1 AND period = $2",
+		userID, start)
+	return float64(ms) / float64(time.Minute/time.Millisecond), err
+}
+
+func allowance(used float64, limit int) types.QuotaAllowance {
+	a := types.QuotaAllowance{Used: used}
+	if limit > 0 {
+		l := float64(limit)
+		remaining := l - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		a.Limit, a.Remaining = &l, &remaining
+	}
+	return a
+}
+
+// getQuota reports the user's remaining allowance under their plan
+func (s *VoiceService) getQuota(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch quota")
+		return
+	}
+	limits := entitlements.PlanLimits
+	start, end := quotaPeriod(time.Now())
+
+	var usage struct {
+		Clones     int `db:"clones"`
+		Running    int `db:"running"`
+		Processing int `db:"processing"`
+	}
+	err = s.db.GetContext(r.Context(), &usage,
+		`SELECT (SELECT COUNT(*) FROM voice_clones WHERE user_id = $1 AND deleted_at IS NULL) AS clones,
+			`+runningJobs+` AS running, `+processingJobs+` AS processing`,
+		userID, types.StatusPending, types.StatusProcessing)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch quota")
+		return
+	}
+	minutes, err := synthesisMinutes(r.Context(), s.db, userID, start)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch quota")
+		return
+	}
+
+	utils.SuccessResponse(w, types.QuotaReport{
+		Plan:              entitlements.Plan,
+		InactiveCloneDays: limits.InactiveCloneDays,
+		Clones:            allowance(float64(usage.Clones), limits.MaxClones),
+		ConcurrentJobs:    allowance(float64(usage.Running), limits.MaxConcurrentJobs),
+		ProcessingJobs:    allowance(float64(usage.Processing), limits.MaxProcessingJobs),
+		SynthesisMinutes:  allowance(minutes, limits.SynthesisMinutesPerMonth),
+		PeriodStart:       start,
+		PeriodEnd:         end,
+	})
+}
@@ -0,0 +1,54 @@
+package voice
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// maxReferenceBatch bounds how many files one reference check asks about
+const maxReferenceBatch = 1000
+
+// fileReferencesQuery selects the given stored files that clones use: as
+// sources, outputs, previews, artifacts, models or synthesized audio. Clones
+// in the trash still hold their files, as they can be restored.
+const fileReferencesQuery = `
+	SELECT source_file FROM voice_clones WHERE source_file = ANY($1)
+	UNION SELECT output_file FROM voice_clones WHERE output_file = ANY($1)
+	UNION SELECT preview_file FROM voice_clones WHERE preview_file = ANY($1)
+	UNION SELECT filename FROM clone_sources WHERE filename = ANY($1)
+	UNION SELECT file FROM clone_artifacts WHERE file = ANY($1)
+	UNION SELECT file FROM clone_models WHERE file = ANY($1)
+	UNION SELECT preview_file FROM clone_models WHERE preview_file = ANY($1)
+	UNION SELECT source FROM clone_models, unnest(source_files) AS source WHERE source = ANY($1)
+	UNION SELECT output_file FROM synthesis_jobs WHERE output_file = ANY($1)`
+
+// listFileReferences reports which of the given stored files clones use.
+// The storage service asks before removing files as orphans, so it reads
+// the primary rather than a replica (internal).
+func (s *VoiceService) listFileReferences(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Files []string `json:"files"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Files) > maxReferenceBatch {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("At most %d files can be checked at once", maxReferenceBatch))
+		return
+	}
+
+	referenced := []string{}
+	if len(req.Files) > 0 {
+		if err := s.db.SelectContext(r.Context(), &referenced, fileReferencesQuery, pq.Array(req.Files)); err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Failed to check file references")
+			return
+		}
+	}
+	utils.JSONResponse(w, http.StatusOK, map[string][]string{"referenced": referenced})
+}
@@ -0,0 +1,252 @@
+package voice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/locale"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+	"github.com/voice-cloning/shared/webhooks"
+)
+
+// retentionBatchSize bounds how many clones a sweep notifies per plan
+const retentionBatchSize = 100
+
+// lastActivity is when clone c was last used: trained, retrained, edited or
+// spoken with
+const lastActivity = `GREATEST(c.completed_at, c.updated_at,
+	(SELECT MAX(m.created_at) FROM clone_models m WHERE m.clone_id = c.id),
+	(SELECT MAX(j.created_at) FROM synthesis_jobs j WHERE j.clone_id = c.id))`
+
+// inactivityNoticeFromEnv is how long before an inactive clone expires its
+// owner is notified (CLONE_INACTIVITY_NOTICE_DAYS, default 7)
+func inactivityNoticeFromEnv() time.Duration {
+	return time.Duration(envInt("CLONE_INACTIVITY_NOTICE_DAYS", 7)) * 24 * time.Hour
+}
+
+// expireInactiveClones applies the plans' inactivity windows to completed
+// clones: a clone left unused until the notice period before its window
+// ends gets an expires_at and a clone.expiring webhook; one still unused at
+// expires_at is archived and its output and intermediate artifacts are
+// deleted. Its models are kept, and moved to cold storage like any
+// archived clone's. Using the clone again in between cancels the expiry.
+func (s *VoiceService) expireInactiveClones(ctx context.Context) {
+	plans := make([]string, 0, len(s.planLimits))
+	for plan := range s.planLimits {
+		plans = append(plans, plan)
+	}
+	for plan, limits := range s.planLimits {
+		window := time.Duration(limits.InactiveCloneDays) * 24 * time.Hour
+		if err := s.renewActiveClones(ctx, plan, plans, window); err != nil {
+			slog.Error("Clone janitor failed to renew active clones", "plan", plan, "error", err)
+		}
+		if window == 0 {
+			continue
+		}
+		if err := s.noticeInactiveClones(ctx, plan, plans, window); err != nil {
+			slog.Error("Clone janitor failed to notify inactive clones", "plan", plan, "error", err)
+		}
+	}
+
+	expired := 0
+	for ctx.Err() == nil {
+		ok, err := s.expireNextClone(ctx)
+		if err != nil {
+			slog.Error("Clone janitor failed to expire a voice clone", "error", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		expired++
+	}
+	if expired > 0 {
+		slog.Info("Clone janitor expired inactive voice clones", "count", expired)
+	}
+}
+
+// planClones restricts clone c to owners on plan ($1); users on plans
+// without limits of their own ($2 lists the known plans) count as free
+const planClones = ` AND c.user_id IN (SELECT id FROM users u
+	WHERE u.plan = $1 OR ($1 = '` + types.PlanFree + `' AND NOT u.plan = ANY($2)))`
+
+// renewActiveClones cancels the expiry of clones that were used since their
+// notice, were exempted, or whose owner's plan no longer expires them yet
+func (s *VoiceService) renewActiveClones(ctx context.Context, plan string, plans []string, window time.Duration) error {
+	query := `UPDATE voice_clones c SET expires_at = NULL
+		WHERE c.expires_at IS NOT NULL AND c.archived_at IS NULL` + planClones
+	args := []interface{}{plan, pq.StringArray(plans)}
+	if window > 0 {
+		query += ` AND (c.retention_exempt OR ` + lastActivity + ` >= $3)`
+		args = append(args, time.Now().Add(-window+s.noticeBefore(window)))
+	}
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// noticeInactiveClones sets the expiry of a batch of clones entering the
+// notice period and notifies their owners, with the expiry also in each
+// owner's timezone and locale
+func (s *VoiceService) noticeInactiveClones(ctx context.Context, plan string, plans []string, window time.Duration) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// updated_at is left alone, or the notice would count as activity
+	now := time.Now()
+	expiresAt := now.Add(s.noticeBefore(window)).UTC()
+	var clones []struct {
+		ID     int `db:"id"`
+		UserID int `db:"user_id"`
+	}
+	err = tx.SelectContext(ctx, &clones,
+		`UPDATE voice_clones SET expires_at = $3 WHERE id IN (
+			SELECT c.id FROM voice_clones c
+			WHERE c.status = $4 AND c.expires_at IS NULL AND NOT c.retention_exempt
+				AND c.archived_at IS NULL AND c.deleted_at IS NULL`+planClones+`
+				AND `+lastActivity+` < $5
+			ORDER BY c.id LIMIT $6 FOR UPDATE SKIP LOCKED)
+		RETURNING id, user_id`,
+		plan, pq.StringArray(plans), expiresAt, types.StatusCompleted, now.Add(-window+s.noticeBefore(window)), retentionBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, clone := range clones {
+		settings, err := locale.ForUser(ctx, tx, clone.UserID)
+		if err != nil {
+			return fmt.Errorf("clone %d: %w", clone.ID, err)
+		}
+		err = webhooks.EnqueueCloneEvent(ctx, tx, clone.ID, webhooks.EventCloneExpiring, map[string]interface{}{
+			"expires_at":       expiresAt,
+			"expires_at_local": expiresAt.In(settings.Location()),
+			"expires_at_text":  settings.FormatTime(expiresAt),
+		})
+		if err != nil {
+			return fmt.Errorf("clone %d: %w", clone.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(clones) > 0 {
+		slog.Info("Clone janitor notified the owners of inactive clones", "count", len(clones), "plan", plan)
+	}
+	return nil
+}
+
+// noticeBefore is how long before expiring a clone its owner is notified,
+// at most the whole window
+func (s *VoiceService) noticeBefore(window time.Duration) time.Duration {
+	if s.inactivityNotice > window {
+		return window
+	}
+	return s.inactivityNotice
+}
+
+// expireNextClone archives one clone past its expires_at, deletes its
+// output and intermediate artifacts, and reports whether there was one
+func (s *VoiceService) expireNextClone(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var clone struct {
+		ID         int            `db:"id"`
+		OutputFile sql.NullString `db:"output_file"`
+	}
+	err = tx.GetContext(ctx, &clone,
+		`SELECT id, output_file FROM voice_clones
+		WHERE expires_at <= $1 AND NOT retention_exempt AND archived_at IS NULL AND deleted_at IS NULL
+		ORDER BY expires_at LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	files := []string{}
+	err = tx.SelectContext(ctx, &files,
+		"DELETE FROM clone_artifacts WHERE clone_id = $1 AND kind <> $2 RETURNING file",
+		clone.ID, artifactVoiceModel)
+	if err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+	if clone.OutputFile.Valid && clone.OutputFile.String != "" {
+		files = append(files, clone.OutputFile.String)
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE voice_clones SET archived_at = NOW(), output_file = NULL, preview_file = NULL, updated_at = NOW() WHERE id = $1",
+		clone.ID)
+	if err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+	// Previews are artifacts too, gone with the rest
+	_, err = tx.ExecContext(ctx, "UPDATE clone_models SET preview_file = NULL WHERE clone_id = $1", clone.ID)
+	if err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("clone %d: %w", clone.ID, err)
+	}
+
+	// The rows are gone either way; files left behind are only logged
+	for _, file := range files {
+		if err := s.deleteStoredFile(ctx, file); err != nil {
+			slog.Warn("Failed to delete a file of an expired voice clone", "file", file, "clone_id", clone.ID, "error", err)
+		}
+	}
+	return true, nil
+}
+
+// setRetentionExemption exempts a clone from inactivity expiry, or makes it
+// subject to it again. Exempting cancels a pending expiry.
+func (s *VoiceService) setRetentionExemption(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Exempt *bool `json:"exempt" validate:"required"`
+	}
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone,
+		`UPDATE voice_clones SET retention_exempt = $1,
+			expires_at = CASE WHEN $1 THEN NULL ELSE expires_at END
+		WHERE id = $2 AND deleted_at IS NULL RETURNING `+cloneColumns,
+		*req.Exempt, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update retention exemption")
+		return
+	}
+	s.audit.Request(r, auditRetentionExemption, "voice_clone", strconv.Itoa(clone.ID), audit.Success,
+		map[string]interface{}{"exempt": *req.Exempt})
+
+	utils.SuccessResponse(w, clone)
+}
@@ -0,0 +1,116 @@
+package voice
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// retryClone re-enqueues a failed clone with its original source file and
+// settings. Artifacts left by the failed run are discarded first.
+func (s *VoiceService) retryClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	var clone types.VoiceClone
+	err = tx.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	if clone.Status != types.StatusFailed {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only failed clones can be retried")
+		return
+	}
+	if clone.ArchivedAt != nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneArchived, "Voice clone is archived; unarchive it to retry")
+		return
+	}
+	if clone.RetryCount >= s.maxRetries {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.RetryLimitReached, "Retry limit reached, create a new clone")
+		return
+	}
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+	err = s.checkCloneQuota(r.Context(), tx, userID, entitlements, false)
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		quotaExceeded(w, quotaErr)
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+
+	_, err = tx.ExecContext(r.Context(),
+		`UPDATE voice_clones SET status = $1, retry_count = retry_count + 1, progress = 0, stage = NULL, output_file = NULL, preview_file = NULL,
+			completed_at = NULL, error_code = NULL, error_message = NULL, updated_at = $2 WHERE id = $3`,
+		types.StatusPending, time.Now(), clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+	files, err := discardArtifacts(r.Context(), tx, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+	if err := events.PublishCloneStatus(r.Context(), tx, clone.ID, types.StatusPending); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to retry voice clone")
+		return
+	}
+
+	s.deleteArtifactFiles(r.Context(), clone.ID, files)
+
+	if err := s.queue.Enqueue(r.Context(), jobqueue.Job{CloneID: clone.ID, Priority: clone.Priority}); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to enqueue retry", "clone_id", clone.ID, "error", err)
+		// Give the attempt back so the user can try again
+		s.db.ExecContext(r.Context(),
+			`UPDATE voice_clones SET status = $1, retry_count = retry_count - 1, error_code = $2, error_message = $3,
+				updated_at = $4 WHERE id = $5`,
+			types.StatusFailed, clone.ErrorCode, clone.ErrorMessage, time.Now(), clone.ID)
+		w.Header().Set("Retry-After", "30")
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Processing queue unavailable, try again later")
+		return
+	}
+
+	utils.SuccessResponse(w, map[string]interface{}{
+		"status":            types.StatusPending,
+		"retry_count":       clone.RetryCount + 1,
+		"retries_remaining": s.maxRetries - clone.RetryCount - 1,
+		"message":           "Voice clone job re-enqueued",
+	})
+}
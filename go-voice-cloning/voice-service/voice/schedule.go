@@ -0,0 +1,25 @@
+package voice
+
+import (
+	"fmt"
+	"time"
+)
+
+// scheduleHorizonFromEnv is how far ahead a clone job can be scheduled
+// (CLONE_MAX_SCHEDULE_DAYS, default 30)
+func scheduleHorizonFromEnv() time.Duration {
+	return time.Duration(envInt("CLONE_MAX_SCHEDULE_DAYS", 30)) * 24 * time.Hour
+}
+
+// processAfter checks when a new clone's job was asked to run. A time that
+// has already come runs it right away, like no time at all, and is nil.
+func (s *VoiceService) processAfter(at *time.Time, now time.Time) (*time.Time, error) {
+	if at == nil || !at.After(now) {
+		return nil, nil
+	}
+	if at.Sub(now) > s.scheduleHorizon {
+		return nil, fmt.Errorf("process_after must be within %d days", int(s.scheduleHorizon.Hours()/24))
+	}
+	t := at.UTC()
+	return &t, nil
+}
@@ -0,0 +1,53 @@
+package voice
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/search"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// cloneMatch is a clone found by a search, with its relevance
+type cloneMatch struct {
+	types.VoiceClone
+	Rank float64 `db:"rank"`
+}
+
+// searchClones finds the caller's clones whose name, tags, metadata values
+// or description match ?q=, most relevant first, up to ?limit=. Archived
+// clones are found too; deleted ones aren't.
+func (s *VoiceService) searchClones(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	req, err := search.ParseRequest(r.URL.Query())
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	matches := []cloneMatch{}
+	err = s.reader(r).SelectContext(r.Context(), &matches,
+		`SELECT `+cloneColumns+`, ts_rank(search_vector, query) AS rank
+		FROM voice_clones, to_tsquery('simple', $2) query
+		WHERE user_id = $1 AND deleted_at IS NULL AND search_vector @@ query
+		ORDER BY rank DESC, updated_at DESC, id DESC
+		LIMIT $3`,
+		userID, req.TSQuery, req.Limit)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to search voice clones")
+		return
+	}
+
+	results := make([]search.Result, len(matches))
+	for i, m := range matches {
+		results[i] = search.Result{Type: search.TypeClone, ID: strconv.Itoa(m.ID), Name: m.Name, Rank: m.Rank, Item: m.VoiceClone}
+	}
+	utils.SuccessResponse(w, results)
+}
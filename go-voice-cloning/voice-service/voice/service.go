@@ -0,0 +1,478 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	
+	"github.com/voice-cloning/shared/analytics"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/cache"
+	"github.com/voice-cloning/shared/capabilities"
+	"github.com/voice-cloning/shared/cron"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/dbroute"
+	"github.com/voice-cloning/shared/entitlements"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/featureflags"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/idempotency"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+	"github.com/voice-cloning/shared/webhooks"
+)
+
+// cloneColumns selects a full types.VoiceClone row
+const cloneColumns = `id, user_id, org_id, name, COALESCE(description, '') AS description, COALESCE(tags, '{}') AS tags,
+	COALESCE(metadata, '{}') AS metadata, 	status, source_file, COALESCE(output_file, '') AS output_file, COALESCE(preview_file, '') AS preview_file,
+	COALESCE(callback_url, '') AS callback_url, COALESCE(settings, '{}') AS settings, retry_count, priority,
+	visibility, progress, COALESCE(stage, '') AS stage, COALESCE(error_code, '') AS error_code,
+	COALESCE(error_message, '') AS error_message, COALESCE(model_version, 0) AS model_version, quality, process_after, expires_at, retention_exempt, created_at, updated_at, completed_at, archived_at, deleted_at`
+
+type VoiceService struct {
+	db               *sqlx.DB
+	queue            *jobqueue.Queue
+	bus              *redis.Client
+	eventBus         events.Bus // nil without EVENT_BUS
+	audit            *audit.Log
+	analytics        *analytics.Tracker
+	events           *EventHub
+	replica          *sqlx.DB
+	storageURL       string
+	maxRetries       int
+	gallery          galleryConfig
+	listings         cache.Cache // approved gallery listings
+	sources          sourceLimits
+	planLimits       map[string]types.PlanLimits
+	entitlements     *entitlements.Client
+	retention        cloneRetention
+	inactivityNotice time.Duration
+	idempotency      *idempotency.Store
+	scheduleHorizon  time.Duration
+	requestTimeout   time.Duration
+	capabilities     capabilities.Catalog
+}
+
+// New builds the voice service on a migrated database and starts its
+// background jobs. It returns the service's handler, and a function
+// stopping the jobs and closing its connections once it no longer serves.
+func New(cfg Config, db *sqlx.DB) (http.Handler, func()) {
+	// Read-only requests are served from a replica when one is configured
+	replica := dbroute.ConnectReplica(db, cfg.DB)
+
+	flags, err := featureflags.New(cfg.Features, map[string]featureflags.Flag{
+		flagSynthesisStreaming: {Enabled: true},
+	})
+	if err != nil {
+		log.Fatal("Failed to configure feature flags:", err)
+	}
+
+	// Jobs are executed by the voice-worker; this service only enqueues
+	queue, err := jobqueue.New(context.Background(), cfg.RedisURL, jobqueue.Options{})
+	if err != nil {
+		log.Fatal("Failed to initialize job queue:", err)
+	}
+
+	// The message bus carries lifecycle events and streamed speech
+	busOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		log.Fatal("Invalid REDIS_URL:", err)
+	}
+	bus := redis.NewClient(busOpts)
+
+	// The event bus announces new clones to the other services
+	eventBus, err := events.BusFromEnv()
+	if err != nil {
+		log.Fatal("Failed to connect to event bus:", err)
+	}
+
+	// Security-relevant operations go to the central audit log
+	auditLog, err := audit.Open("voice-service", cfg.Audit, db, eventBus)
+	if err != nil {
+		log.Fatal("Invalid audit configuration:", err)
+	}
+
+	c, err := cache.New(cfg.Cache)
+	if err != nil {
+		log.Fatal("Invalid cache configuration:", err)
+	}
+
+	// Models and languages the engines support
+	catalog, err := capabilities.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to load engine capabilities:", err)
+	}
+
+	service := &VoiceService{db: db, replica: replica, queue: queue, bus: bus, eventBus: eventBus, audit: auditLog, analytics: analytics.New("voice-service", cfg.Analytics), events: NewEventHub(cfg.DatabaseURL), storageURL: cfg.StorageServiceURL, maxRetries: cfg.CloneMaxRetries, gallery: galleryConfigFromEnv(), sources: sourceLimitsFromEnv(), planLimits: planLimitsFromEnv(), entitlements: entitlements.FromEnv(c), listings: cache.Namespace(c, "gallery"), retention: cloneRetentionFromEnv(), inactivityNotice: inactivityNoticeFromEnv(), idempotency: idempotency.New(db, cfg.Idempotency), scheduleHorizon: scheduleHorizonFromEnv(), requestTimeout: requestTimeoutFromEnv(), capabilities: catalog}
+
+	// Deleted users' data is removed in the background
+	cleanupCtx, stopCleanups := context.WithCancel(context.Background())
+	go service.runUserCleanups(cleanupCtx, cfg.DatabaseURL, cfg.UserCleanupInterval)
+
+	// Maintenance jobs: deleted clones past their retention, inactive clones,
+	// the models of archived ones, expired idempotency keys and old lifecycle
+	// events are removed on schedule
+	jobs, err := cron.New("voice-service", cfg.Cron, db, auditLog)
+	if err != nil {
+		log.Fatal("Invalid cron configuration:", err)
+	}
+	if err := jobs.Add("clone-retention", cron.Spec(cfg.CloneJanitorSchedule, cfg.CloneJanitorInterval), service.cloneRetention); err != nil {
+		log.Fatal("Invalid CLONE_JANITOR_SCHEDULE:", err)
+	}
+	jobs.Start()
+
+	// Clone lifecycle events go from the outbox to the message bus
+	go service.runLifecycleRelay(cleanupCtx, cfg.LifecycleRelayInterval)
+
+	checks := health.New("voice-service")
+	checks.Add("postgres", health.DB(db))
+	if replica != db {
+		checks.Add("postgres_replica", health.DB(replica))
+	}
+	checks.Add("job_queue", queue.Ping)
+	checks.Add("message_bus", health.Redis(bus))
+
+	// Setup routes
+	r := mux.NewRouter()
+	r.Use(service.withDeadline)
+	r.HandleFunc("/health", checks.Ready).Methods("GET")
+	r.HandleFunc("/health/live", checks.Live).Methods("GET")
+	r.HandleFunc("/health/ready", checks.Ready).Methods("GET")
+	r.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pools(db, replica)...)).Methods("GET")
+	r.HandleFunc("/clones", service.idempotency.Wrap(service.createClone)).Methods("POST")
+	r.HandleFunc("/clones/direct", service.idempotency.Wrap(service.createDirectClone)).Methods("POST")
+	r.HandleFunc("/capabilities", service.getCapabilities).Methods("GET")
+	r.HandleFunc("/clones/analytics", service.getAnalytics).Methods("GET")
+	r.HandleFunc("/clones/{id}", service.getClone).Methods("GET")
+	r.HandleFunc("/clones", service.listClones).Methods("GET")
+	r.HandleFunc("/clones/{id}", service.updateClone).Methods("PATCH")
+	r.HandleFunc("/clones/{id}", service.deleteClone).Methods("DELETE")
+	r.HandleFunc("/clones/{id}/cancel", service.cancelClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/retry", service.retryClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/retrain", service.retrainClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/models", service.listModels).Methods("GET")
+	r.HandleFunc("/clones/{id}/models/{version}", service.getModel).Methods("GET")
+	r.HandleFunc("/clones/{id}/archive", service.archiveClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/unarchive", service.unarchiveClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/restore", service.restoreClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/status", service.getStatus).Methods("GET")
+	r.HandleFunc("/clones/{id}/artifacts", service.listArtifacts).Methods("GET")
+	r.HandleFunc("/clones/{id}/deliveries", service.listDeliveries).Methods("GET")
+	r.HandleFunc("/clones/{id}/events", service.streamEvents).Methods("GET")
+	r.HandleFunc("/clones/{id}/manifest", service.getManifest).Methods("GET")
+	r.HandleFunc("/clones/{id}/synthesize", service.synthesizeClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/syntheses", service.listSyntheses).Methods("GET")
+	r.HandleFunc("/clones/{id}/syntheses/{synthesis_id}", service.getSynthesis).Methods("GET")
+	r.Handle("/clones/{id}/syntheses/{synthesis_id}/stream",
+		flags.Require(flagSynthesisStreaming)(http.HandlerFunc(service.streamSynthesis))).Methods("GET")
+	r.HandleFunc("/clones/{id}/publish", service.publishClone).Methods("PUT")
+	r.HandleFunc("/clones/{id}/publish", service.unpublishClone).Methods("DELETE")
+	r.HandleFunc("/clones/{id}/visibility", service.setVisibility).Methods("PUT")
+	r.HandleFunc("/clones/{id}/shares", service.listShares).Methods("GET")
+	r.HandleFunc("/clones/{id}/shares", service.shareClone).Methods("POST")
+	r.HandleFunc("/clones/{id}/shares/{user_id}", service.unshareClone).Methods("DELETE")
+	r.HandleFunc("/library", service.listLibrary).Methods("GET")
+	r.HandleFunc("/library/shared", service.listSharedWithMe).Methods("GET")
+	r.HandleFunc("/search", service.searchClones).Methods("GET")
+	r.HandleFunc("/gallery", service.listGallery).Methods("GET")
+	r.HandleFunc("/gallery/categories", service.listGalleryCategories).Methods("GET")
+	r.HandleFunc("/gallery/{id}", service.getGalleryListing).Methods("GET")
+	r.HandleFunc("/gallery/{id}/demo", service.demoClone).Methods("POST")
+	r.HandleFunc("/gallery/{id}/reports", service.reportListing).Methods("POST")
+	r.HandleFunc("/admin/gallery", service.listModerationQueue).Methods("GET")
+	r.HandleFunc("/admin/gallery/reports", service.listAbuseReports).Methods("GET")
+	r.HandleFunc("/admin/gallery/{id}/review", service.reviewListing).Methods("POST")
+	r.HandleFunc("/admin/gallery/{id}/takedown", service.takeDownListing).Methods("POST")
+	r.HandleFunc("/admin/user-cleanups", service.listUserCleanups).Methods("GET")
+	r.HandleFunc("/admin/user-cleanups/{user_id}", service.getUserCleanup).Methods("GET")
+	r.HandleFunc("/admin/clones/{id}/retention", service.setRetentionExemption).Methods("PUT")
+	r.PathPrefix("/admin/jobs").Handler(jobs)
+	r.HandleFunc("/files/references", service.listFileReferences).Methods("POST")
+	r.HandleFunc("/users/{user_id}/cleanup", service.runUserCleanupNow).Methods("POST")
+	r.HandleFunc("/ws", service.serveNotifications).Methods("GET")
+	r.HandleFunc("/quota", service.getQuota).Methods("GET")
+	r.HandleFunc("/defaults", service.getDefaults).Methods("GET")
+	r.HandleFunc("/defaults", service.putDefaults).Methods("PUT")
+	r.HandleFunc("/orgs/{org}/defaults", service.getOrgDefaults).Methods("GET")
+	r.HandleFunc("/orgs/{org}/defaults", service.putOrgDefaults).Methods("PUT")
+	r.HandleFunc("/callback", service.getCallback).Methods("GET")
+	r.HandleFunc("/callback", service.putCallback).Methods("PUT")
+	r.HandleFunc("/callback", service.deleteCallback).Methods("DELETE")
+
+	return middleware.Chain(middleware.UserLanguage(db)(r)), func() {
+		stopCleanups()
+		jobs.Close()
+		service.analytics.Close()
+		auditLog.Close()
+		if eventBus != nil {
+			eventBus.Close()
+		}
+		bus.Close()
+		queue.Close()
+	}
+}
+
+// reader picks the pool for queries of a request the gateway marked
+// read-only
+func (s *VoiceService) reader(r *http.Request) *sqlx.DB {
+	return dbroute.Reader(r, s.db, s.replica)
+}
+
+func (s *VoiceService) createClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req types.VoiceCloneRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	s.startClone(w, r, userID, req)
+}
+
+// startClone validates a clone request, records the clone and queues its
+// job. It writes the response either way, and reports whether the clone
+// was created.
+func (s *VoiceService) startClone(w http.ResponseWriter, r *http.Request, userID int, req types.VoiceCloneRequest) bool {
+	sources, err := cloneSources(req)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+	// Reject unusable audio now rather than failing the job later, and pin
+	// the sources to the versions checked
+	versions := types.SourceVersions{}
+	for file, version := range req.SourceVersions {
+		versions[file] = version
+	}
+	problems, err := s.validateSources(r.Context(), userID, sources, versions)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to validate the sources of a voice clone", "error", err)
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Failed to validate source audio")
+		return false
+	}
+	if len(problems) > 0 {
+		sourcesInvalid(w, problems)
+		return false
+	}
+
+	if len(req.Description) > maxDescriptionLength {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("description must be at most %d characters", maxDescriptionLength))
+		return false
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+
+	var callbackURL *string
+	if req.CallbackURL != "" {
+		if err := webhooks.ValidateURL(req.CallbackURL); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+			return false
+		}
+		callbackURL = &req.CallbackURL
+	}
+
+	// Fields omitted from the request fall back to the user's defaults
+	if err := validateSettings(req.CloneSettings); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+	defaults, err := s.effectiveDefaults(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+	settings := defaults.Effective.Merge(req.CloneSettings)
+	if _, err := s.capabilities.Route(settings.Model, settings.Language, settings.Locale); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error()+"; see GET /api/voice/capabilities")
+		return false
+	}
+
+	// Paid plans may jump ahead of free-tier jobs, and have higher quotas
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+	priority, err := resolvePriority(entitlements, req.Priority)
+	if errors.Is(err, errPlanPriority) {
+		utils.ErrorResponse(w, http.StatusForbidden, apierror.Forbidden, err.Error())
+		return false
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+	now := time.Now()
+	processAfter, err := s.processAfter(req.ProcessAfter, now)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return false
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+	defer tx.Rollback()
+
+	err = s.checkCloneQuota(r.Context(), tx, userID, entitlements, true)
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		quotaExceeded(w, quotaErr)
+		return false
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+
+	// Create voice clone record. A clone created on behalf of an org is
+	// shared among its members.
+	var cloneID int
+	err = tx.QueryRowContext(r.Context(),
+		`INSERT INTO voice_clones (user_id, org_id, name, description, tags, metadata, status, source_file, callback_url, settings, priority, process_after, created_at, updated_at)
+		VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`,
+		userID, orgs.FromRequest(r), req.Name, req.Description, pq.StringArray(tags), req.Metadata, "pending", sources[0], callbackURL, settings, priority, processAfter, now, now,
+	).Scan(&cloneID)
+
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+	if err := insertSources(r.Context(), tx, cloneID, sources, versions); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+
+	// Deliveries are signed with the account secret, so make sure one exists
+	if callbackURL != nil {
+		if _, err := webhooks.EnsureSecret(r.Context(), tx, userID); err != nil {
+			dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+			return false
+		}
+	}
+	if err := events.PublishCloneCreated(r.Context(), tx, cloneID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+
+	if err := tx.Commit(); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create voice clone")
+		return false
+	}
+
+	createdEvent := map[string]interface{}{"clone_id": cloneID, "model": settings.Model, "language": settings.Language, "scheduled": processAfter != nil}
+
+	// Scheduled jobs are queued by the worker's scheduler when their time comes
+	if processAfter != nil {
+		s.analytics.Track(analytics.EventCloneCreated, userID, createdEvent)
+		utils.JSONResponse(w, http.StatusCreated, types.VoiceCloneResponse{
+			ID:           cloneID,
+			Status:       "pending",
+			Message:      "Voice clone job scheduled",
+			ProcessAfter: processAfter,
+		})
+		return true
+	}
+
+	// Hand the job to the durable queue; workers pick it up asynchronously
+	if err := s.queue.Enqueue(r.Context(), jobqueue.Job{CloneID: cloneID, Priority: priority}); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to enqueue voice clone", "clone_id", cloneID, "error", err)
+		s.db.ExecContext(r.Context(), "DELETE FROM voice_clones WHERE id = $1", cloneID)
+		w.Header().Set("Retry-After", "30")
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Processing queue unavailable, try again later")
+		return false
+	}
+
+	s.analytics.Track(analytics.EventCloneCreated, userID, createdEvent)
+	utils.JSONResponse(w, http.StatusCreated, types.VoiceCloneResponse{
+		ID:      cloneID,
+		Status:  "pending",
+		Message: "Voice clone job created",
+	})
+	return true
+}
+
+func (s *VoiceService) getClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	cloneID := vars["id"]
+
+	clone, err := accessibleClone(r.Context(), s.db, cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if clone.UserID != userID {
+		sharedView(&clone)
+	} else if err := loadSources(r.Context(), s.db, &clone); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch voice clone")
+		return
+	}
+
+	w.Header().Set("ETag", cloneETag(clone.UpdatedAt))
+	utils.SuccessResponse(w, clone)
+}
+
+func (s *VoiceService) getStatus(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	cloneID := vars["id"]
+
+	var status struct {
+		Status       string     `json:"status" db:"status"`
+		Progress     int        `json:"progress" db:"progress"`
+		Stage        string     `json:"stage,omitempty" db:"stage"`
+		ErrorCode    string     `json:"error_code,omitempty" db:"error_code"`
+		ErrorMessage string     `json:"error_message,omitempty" db:"error_message"`
+		ProcessAfter *time.Time `json:"process_after,omitempty" db:"process_after"`
+	}
+	err := s.db.GetContext(r.Context(), &status,
+		`SELECT c.status, c.progress, COALESCE(c.stage, '') AS stage,
+		COALESCE(c.error_code, '') AS error_code, COALESCE(c.error_message, '') AS error_message, c.process_after`+accessibleWhere,
+		cloneID, userID)
+
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	utils.SuccessResponse(w, status)
+}
+
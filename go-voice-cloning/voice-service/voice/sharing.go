@@ -0,0 +1,372 @@
+package voice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/audit"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/orgs"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// maxCloneShares caps the users a clone can be shared with
+const maxCloneShares = 100
+
+// accessibleWhere matches a clone ($1) the user ($2) owns, that is public,
+// that is shared with them or that belongs to an org they are a member of
+var accessibleWhere = ` FROM voice_clones c WHERE c.id = $1 AND c.deleted_at IS NULL AND (c.user_id = $2 OR c.visibility = 'public' OR
+	(c.visibility = 'shared' AND EXISTS (SELECT 1 FROM clone_shares cs WHERE cs.clone_id = c.id AND cs.user_id = $2)) OR
+	` + orgs.MemberWhere("c.org_id", "$2") + `)`
+
+// accessibleClone loads a clone the user may view and synthesize with.
+// Everything else about a clone is limited to its owner.
+func accessibleClone(ctx context.Context, db sqlx.QueryerContext, cloneID string, userID int) (types.VoiceClone, error) {
+	var clone types.VoiceClone
+	err := sqlx.GetContext(ctx, db, &clone, "SELECT "+cloneColumns+accessibleWhere, cloneID, userID)
+	return clone, err
+}
+
+// sharedView hides the owner's source audio, webhook and metadata from
+// other users
+func sharedView(clone *types.VoiceClone) {
+	clone.Metadata = types.CloneMetadata{}
+	clone.SourceFile = ""
+	clone.SourceFiles = nil
+	clone.SourceVersions = nil
+	clone.CallbackURL = ""
+}
+
+// ownsClone reports whether the user owns the clone
+func (s *VoiceService) ownsClone(ctx context.Context, cloneID string, userID int) (bool, error) {
+	var owned bool
+	err := s.db.GetContext(ctx, &owned, "SELECT EXISTS (SELECT 1 FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)", cloneID, userID)
+	return owned, err
+}
+
+func validVisibility(visibility string) bool {
+	for _, v := range types.Visibilities {
+		if v == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// setVisibility changes who can use a clone. Only completed clones can be
+// made public. Shares are kept when a clone is made private and apply again
+// once it is shared.
+func (s *VoiceService) setVisibility(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req types.CloneVisibilityRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if !validVisibility(req.Visibility) {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("visibility must be one of %v", types.Visibilities))
+		return
+	}
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone,
+		"SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL",
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if req.Visibility == types.VisibilityPublic && clone.Status != types.StatusCompleted {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only completed clones can be made public")
+		return
+	}
+
+	err = s.db.GetContext(r.Context(), &clone,
+		"UPDATE voice_clones SET visibility = $1, updated_at = NOW() WHERE id = $2 RETURNING "+cloneColumns,
+		req.Visibility, clone.ID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update visibility")
+		return
+	}
+	s.syncPreviewACL(r.Context(), strconv.Itoa(clone.ID))
+	s.audit.Request(r, auditSetVisibility, "voice_clone", strconv.Itoa(clone.ID), audit.Success,
+		map[string]interface{}{"visibility": req.Visibility})
+	w.Header().Set("ETag", cloneETag(clone.UpdatedAt))
+	utils.SuccessResponse(w, clone)
+}
+
+const shareColumns = `cs.clone_id, cs.user_id, u.username, cs.granted_by, cs.created_at`
+
+// listShares returns the users a clone is shared with
+func (s *VoiceService) listShares(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+	owned, err := s.ownsClone(r.Context(), cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch shares")
+		return
+	}
+	if !owned {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	shares := []types.CloneShare{}
+	err = s.reader(r).SelectContext(r.Context(), &shares,
+		"SELECT "+shareColumns+" FROM clone_shares cs JOIN users u ON u.id = cs.user_id WHERE cs.clone_id = $1 ORDER BY cs.created_at",
+		cloneID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch shares")
+		return
+	}
+	utils.SuccessResponse(w, shares)
+}
+
+// shareClone grants a user, by ID or email, synthesis access to a clone.
+// Sharing a private clone makes it shared.
+func (s *VoiceService) shareClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req types.CloneShareRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if (req.UserID == 0) == (req.Email == "") {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Exactly one of user_id or email is required")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+	owned, err := s.ownsClone(r.Context(), cloneID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to share voice clone")
+		return
+	}
+	if !owned {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+
+	var granteeID int
+	if req.UserID != 0 {
+		err = s.db.GetContext(r.Context(), &granteeID, "SELECT id FROM users WHERE id = $1", req.UserID)
+	} else {
+		err = s.db.GetContext(r.Context(), &granteeID, "SELECT id FROM users WHERE LOWER(email) = LOWER($1)", req.Email)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.UserNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to share voice clone")
+		return
+	}
+	if granteeID == userID {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.BadRequest, "A clone can't be shared with its owner")
+		return
+	}
+
+	tx, err := s.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to share voice clone")
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the clone serializes share grants against the cap
+	var visibility string
+	if err := tx.GetContext(r.Context(), &visibility, "SELECT visibility FROM voice_clones WHERE id = $1 FOR UPDATE", cloneID); err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	var count int
+	if err := tx.GetContext(r.Context(), &count, "SELECT COUNT(*) FROM clone_shares WHERE clone_id = $1 AND user_id <> $2", cloneID, granteeID); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to share voice clone")
+		return
+	}
+	if count >= maxCloneShares {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.ShareLimit, fmt.Sprintf("A clone can be shared with at most %d users", maxCloneShares))
+		return
+	}
+
+	_, err = tx.ExecContext(r.Context(),
+		`INSERT INTO clone_shares (clone_id, user_id, granted_by, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (clone_id, user_id) DO NOTHING`,
+		cloneID, granteeID, userID, time.Now())
+	if err == nil && visibility == types.VisibilityPrivate {
+		_, err = tx.ExecContext(r.Context(), "UPDATE voice_clones SET visibility = $1, updated_at = NOW() WHERE id = $2",
+			types.VisibilityShared, cloneID)
+	}
+	var share types.CloneShare
+	if err == nil {
+		err = tx.GetContext(r.Context(), &share,
+			"SELECT "+shareColumns+" FROM clone_shares cs JOIN users u ON u.id = cs.user_id WHERE cs.clone_id = $1 AND cs.user_id = $2",
+			cloneID, granteeID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to share voice clone")
+		return
+	}
+	s.syncPreviewACL(r.Context(), cloneID)
+	s.audit.Request(r, auditShareClone, "voice_clone", cloneID, audit.Success, map[string]interface{}{"user_id": granteeID})
+
+	utils.JSONResponse(w, http.StatusCreated, share)
+}
+
+// unshareClone revokes a user's access to a clone. The owner can revoke
+// any share, and a user can give up a share of their own.
+func (s *VoiceService) unshareClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	granteeID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+
+	result, err := s.db.ExecContext(r.Context(),
+		`DELETE FROM clone_shares cs USING voice_clones c
+		WHERE cs.clone_id = c.id AND cs.clone_id = $1 AND cs.user_id = $2 AND (c.user_id = $3 OR cs.user_id = $3)`,
+		vars["id"], granteeID, userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to remove share")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.NotFound, "Share not found")
+		return
+	}
+	s.syncPreviewACL(r.Context(), vars["id"])
+	s.audit.Request(r, auditUnshareClone, "voice_clone", vars["id"], audit.Success, map[string]interface{}{"user_id": granteeID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// libraryColumns selects a types.LibraryClone joined with its owner
+const libraryColumns = `c.id, c.name, COALESCE(c.description, '') AS description, COALESCE(c.tags, '{}') AS tags,
+	u.username AS owner, c.visibility, c.completed_at`
+
+const libraryFrom = ` FROM voice_clones c JOIN users u ON u.id = c.user_id`
+
+// libraryCursor is the keyset position after the last clone of a page
+type libraryCursor struct {
+	CompletedAt time.Time `json:"c"`
+	ID          int       `json:"id"`
+}
+
+// listLibrary returns the public voice library: every user's completed
+// public clones, most recently completed first. Supports ?q= (name or
+// description), ?tag= and ?limit=/?cursor= pagination.
+func (s *VoiceService) listLibrary(w http.ResponseWriter, r *http.Request) {
+	if middleware.UserID(r) == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	s.listLibraryClones(w, r, []string{"c.visibility = $1"}, []interface{}{types.VisibilityPublic})
+}
+
+// listSharedWithMe returns the completed clones other users shared with the
+// caller, with the same filters as the library
+func (s *VoiceService) listSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	s.listLibraryClones(w, r,
+		[]string{"c.visibility <> $1", "EXISTS (SELECT 1 FROM clone_shares cs WHERE cs.clone_id = c.id AND cs.user_id = $2)"},
+		[]interface{}{types.VisibilityPrivate, userID})
+}
+
+func (s *VoiceService) listLibraryClones(w http.ResponseWriter, r *http.Request, where []string, args []interface{}) {
+	q := r.URL.Query()
+
+	limit, err := types.ParseLimit(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where = append(where, "c.status = "+arg(types.StatusCompleted), "c.archived_at IS NULL", "c.deleted_at IS NULL")
+	if search := strings.TrimSpace(q.Get("q")); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+		p := arg("%" + escaped + "%")
+		where = append(where, "(c.name ILIKE "+p+" OR c.description ILIKE "+p+")")
+	}
+	if tag := strings.TrimSpace(q.Get("tag")); tag != "" {
+		where = append(where, arg(tag)+" = ANY(c.tags)")
+	}
+
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*)"+libraryFrom+" WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch voice library")
+		return
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		var cursor libraryCursor
+		if err := types.DecodeCursor(c, &cursor); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, fmt.Sprintf("(c.completed_at, c.id) < (%s, %s)", arg(cursor.CompletedAt), arg(cursor.ID)))
+	}
+
+	query := fmt.Sprintf("SELECT %s%s WHERE %s ORDER BY c.completed_at DESC, c.id DESC LIMIT %s",
+		libraryColumns, libraryFrom, strings.Join(where, " AND "), arg(limit+1))
+
+	clones := []types.LibraryClone{}
+	if err := db.SelectContext(r.Context(), &clones, query, args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch voice library")
+		return
+	}
+
+	page := types.Pagination{Limit: limit, Total: total}
+	if len(clones) > limit {
+		clones = clones[:limit]
+		last := clones[len(clones)-1]
+		page.NextCursor = types.EncodeCursor(libraryCursor{CompletedAt: *last.CompletedAt, ID: last.ID})
+	}
+
+	utils.SuccessResponse(w, types.PageResponse{Data: clones, Pagination: page})
+}
@@ -0,0 +1,270 @@
+package voice
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// sourceLimits are the requirements a clone's source audio must meet
+type sourceLimits struct {
+	formats       map[string]bool
+	minSampleRate int
+	maxSampleRate int
+	minDuration   time.Duration
+	maxDuration   time.Duration
+}
+
+func sourceLimitsFromEnv() sourceLimits {
+	formats := map[string]bool{}
+	for _, format := range strings.Split(getEnvDefault("CLONE_SOURCE_FORMATS", "wav,flac"), ",") {
+		if format = strings.TrimSpace(strings.ToLower(format)); format != "" {
+			formats[format] = true
+		}
+	}
+	return sourceLimits{
+		formats:       formats,
+		minSampleRate: envInt("CLONE_MIN_SAMPLE_RATE", 16000),
+		maxSampleRate: envInt("CLONE_MAX_SAMPLE_RATE", 48000),
+		minDuration:   time.Duration(envInt("CLONE_MIN_SOURCE_SECONDS", 10)) * time.Second,
+		maxDuration:   time.Duration(envInt("CLONE_MAX_SOURCE_SECONDS", 3600)) * time.Second,
+	}
+}
+
+func getEnvDefault(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// cloneSources lists the source files of a create request in training order
+func cloneSources(req types.VoiceCloneRequest) ([]string, error) {
+	sources := make([]string, 0, len(req.SourceFiles)+1)
+	seen := map[string]bool{}
+	for _, file := range append([]string{req.SourceFile}, req.SourceFiles...) {
+		file = strings.TrimSpace(file)
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		sources = append(sources, file)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("source_files is required")
+	}
+	if len(sources) > types.MaxCloneSources {
+		return nil, fmt.Errorf("a clone can have at most %d source files", types.MaxCloneSources)
+	}
+	return sources, nil
+}
+
+// Source audio violations reported by createClone
+const (
+	SourceNotFound       = "not_found"
+	SourceFormat         = "format"
+	SourceSampleRate     = "sample_rate"
+	SourceFormatMismatch = "format_mismatch"
+	SourceDuration       = "duration"
+	SourceQuarantined    = "quarantined"
+	SourceVersion        = "version"
+)
+
+// SourceProblem is a requirement a source file fails. SourceFile is empty
+// for problems with the sources as a whole.
+type SourceProblem struct {
+	SourceFile string `json:"source_file,omitempty"`
+	Violation  string `json:"violation"`
+	Message    string `json:"message"`
+}
+
+// sourcesInvalid answers sources that failed validation with every problem,
+// both as problems and as the error's details
+func sourcesInvalid(w http.ResponseWriter, problems []SourceProblem) {
+	details := make([]apierror.Detail, len(problems))
+	for i, p := range problems {
+		details[i] = apierror.Detail{Field: p.SourceFile, Code: apierror.SourceAudioInvalid, Message: p.Message}
+	}
+	body := utils.ErrorBody(w, apierror.SourceAudioInvalid, "Source audio failed validation")
+	body["problems"] = problems
+	body["details"] = details
+	utils.JSONResponse(w, http.StatusUnprocessableEntity, body)
+}
+
+// validateSources checks that each source exists in the storage service,
+// at its pinned version if it has one, belongs to the user and is audio the
+// worker can train on, and that the sources together are long enough.
+// Every problem found is returned, so a client can fix them all at once.
+// The sources are pinned to the versions checked.
+func (s *VoiceService) validateSources(ctx context.Context, userID int, sources []string, versions types.SourceVersions) ([]SourceProblem, error) {
+	problems := []SourceProblem{}
+	infos := make([]*types.AudioInfo, 0, len(sources))
+	var total time.Duration
+	pinned := make([]string, 0, len(versions))
+	for file := range versions {
+		pinned = append(pinned, file)
+	}
+	sort.Strings(pinned)
+	for _, file := range pinned {
+		if versions[file] < 1 || !contains(sources, file) {
+			problems = append(problems, SourceProblem{file, SourceVersion, "source_versions must pin source files to positive versions"})
+		}
+	}
+	for _, file := range sources {
+		info, err := s.sourceAudioInfo(ctx, file, versions[file])
+		if err != nil {
+			return nil, err
+		}
+		// Another user's file is reported as missing rather than revealed
+		if info == nil || (info.UserID != nil && *info.UserID != userID) {
+			message := "file not found in storage"
+			if versions[file] > 0 {
+				message = fmt.Sprintf("version %d of the file not found in storage", versions[file])
+			}
+			problems = append(problems, SourceProblem{file, SourceNotFound, message})
+			continue
+		}
+		if info.Version > 0 {
+			versions[file] = info.Version
+		}
+		if info.Quarantined {
+			problems = append(problems, SourceProblem{file, SourceQuarantined, "file failed the malware scan"})
+			continue
+		}
+		if !s.sources.formats[info.Format] {
+			problems = append(problems, SourceProblem{file, SourceFormat,
+				fmt.Sprintf("unsupported audio format; use one of %s", strings.Join(sortedKeys(s.sources.formats), ", "))})
+			continue
+		}
+		if info.SampleRate < s.sources.minSampleRate || (s.sources.maxSampleRate > 0 && info.SampleRate > s.sources.maxSampleRate) {
+			problems = append(problems, SourceProblem{file, SourceSampleRate,
+				fmt.Sprintf("sample rate %d Hz is outside %d-%d Hz", info.SampleRate, s.sources.minSampleRate, s.sources.maxSampleRate)})
+		}
+		infos = append(infos, info)
+		total += time.Duration(info.DurationMS) * time.Millisecond
+	}
+
+	// Several sources are concatenated, which needs one WAV format
+	if len(sources) > 1 && len(infos) > 0 {
+		first := infos[0]
+		for _, info := range infos {
+			if info.Format != "wav" || info.SampleRate != first.SampleRate || info.Channels != first.Channels ||
+				info.BitsPerSample != first.BitsPerSample {
+				problems = append(problems, SourceProblem{info.ID, SourceFormatMismatch,
+					fmt.Sprintf("combined sources must be WAV files of one format (%d Hz, %d channels, %d bits)",
+						first.SampleRate, first.Channels, first.BitsPerSample)})
+			}
+		}
+	}
+
+	// Totals are only meaningful once every source was measured
+	if len(infos) == len(sources) {
+		if total < s.sources.minDuration {
+			problems = append(problems, SourceProblem{Violation: SourceDuration,
+				Message: fmt.Sprintf("source audio totals %s; at least %s is needed", total.Round(time.Second), s.sources.minDuration)})
+		}
+		if s.sources.maxDuration > 0 && total > s.sources.maxDuration {
+			problems = append(problems, SourceProblem{Violation: SourceDuration,
+				Message: fmt.Sprintf("source audio totals %s; the maximum is %s", total.Round(time.Second), s.sources.maxDuration)})
+		}
+	}
+	return problems, nil
+}
+
+// sourceAudioInfo asks the storage service for the audio format of a file's
+// version, its latest when version is 0. Its preferred variant is
+// described, the one normalized for the engine when there is one, as that is
+// what the worker trains on. A missing file or version returns nil.
+func (s *VoiceService) sourceAudioInfo(ctx context.Context, filename string, version int) (*types.AudioInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	u := s.storageURL + "/files/" + url.PathEscape(filename) + "/audio?variant=preferred"
+	if version > 0 {
+		u += "&version=" + strconv.Itoa(version)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage service returned %d", resp.StatusCode)
+	}
+	var info types.AudioInfo
+	if err := utils.DecodeData(resp, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// insertSources records a clone's sources in training order, with the
+// versions they are pinned to
+func insertSources(ctx context.Context, tx *sqlx.Tx, cloneID int, sources []string, versions types.SourceVersions) error {
+	for i, file := range sources {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO clone_sources (clone_id, position, filename, file_version) VALUES ($1, $2, $3, NULLIF($4, 0))",
+			cloneID, i, file, versions[file])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSources fills in a clone's source files and the versions they are
+// pinned to. Clones created before clone_sources existed have only their
+// source_file, and clones created before versioning use the latest versions.
+func loadSources(ctx context.Context, db sqlx.QueryerContext, clone *types.VoiceClone) error {
+	var sources []struct {
+		Filename string        `db:"filename"`
+		Version  sql.NullInt64 `db:"file_version"`
+	}
+	err := sqlx.SelectContext(ctx, db, &sources,
+		"SELECT filename, file_version FROM clone_sources WHERE clone_id = $1 ORDER BY position", clone.ID)
+	if err != nil {
+		return err
+	}
+	clone.SourceFiles = []string{}
+	clone.SourceVersions = types.SourceVersions{}
+	for _, source := range sources {
+		clone.SourceFiles = append(clone.SourceFiles, source.Filename)
+		if source.Version.Valid {
+			clone.SourceVersions[source.Filename] = int(source.Version.Int64)
+		}
+	}
+	if len(clone.SourceFiles) == 0 {
+		clone.SourceFiles = []string{clone.SourceFile}
+	}
+	return nil
+}
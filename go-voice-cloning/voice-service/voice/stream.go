@@ -0,0 +1,127 @@
+package voice
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/speechstream"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// flagSynthesisStreaming gates listening to syntheses as they are produced
+const flagSynthesisStreaming = "synthesis_streaming"
+
+// speechPoll is how long a listener waits for audio before checking on the
+// synthesis job
+const speechPoll = 5 * time.Second
+
+// streamSynthesis plays a synthesis job's audio as the worker produces it,
+// as a chunked audio/wav response. Listening can start as soon as the job is
+// created. Jobs whose stream has expired are redirected to their download;
+// a job that fails before any audio is sent gets a JSON error, and one that
+// fails midway ends the response early.
+func (s *VoiceService) streamSynthesis(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	var job types.SynthesisJob
+	err := s.db.GetContext(r.Context(), &job,
+		"SELECT "+synthesisColumns+" FROM synthesis_jobs WHERE id = $1 AND clone_id = $2 AND user_id = $3",
+		vars["synthesis_id"], vars["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.NotFound, "Synthesis job not found")
+		return
+	}
+
+	switch job.Status {
+	case types.StatusFailed:
+		utils.ErrorResponse(w, http.StatusConflict, apierror.SynthesisFailed, "Synthesis job failed: "+job.Error)
+		return
+	case types.StatusCompleted:
+		if live, err := speechstream.Exists(r.Context(), s.bus, job.ID); err == nil && !live {
+			setSynthesisDownload(&job)
+			http.Redirect(w, r, job.DownloadURL, http.StatusFound)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ErrorResponse(w, http.StatusInternalServerError, apierror.Internal, "Streaming unsupported")
+		return
+	}
+
+	after := "0"
+	started := false
+	for {
+		entries, last, err := speechstream.Read(r.Context(), s.bus, job.ID, after, speechPoll)
+		if err != nil {
+			if !started && r.Context().Err() == nil {
+				utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Speech stream unavailable, try again later")
+			}
+			return
+		}
+		after = last
+
+		if len(entries) == 0 {
+			// Nothing new; the job may have ended without a stream to read
+			if err := s.db.GetContext(r.Context(), &job,
+				"SELECT "+synthesisColumns+" FROM synthesis_jobs WHERE id = $1", job.ID); err != nil {
+				if !started {
+					dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch synthesis job")
+				}
+				return
+			}
+			switch job.Status {
+			case types.StatusFailed:
+				if !started {
+					utils.ErrorResponse(w, http.StatusConflict, apierror.SynthesisFailed, "Synthesis job failed: "+job.Error)
+				}
+				return
+			case types.StatusCompleted:
+				if !started {
+					setSynthesisDownload(&job)
+					http.Redirect(w, r, job.DownloadURL, http.StatusFound)
+				}
+				return
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			switch entry.End {
+			case speechstream.EndCompleted:
+				if !started {
+					w.Header().Set("Content-Type", "audio/wav")
+					w.WriteHeader(http.StatusOK)
+				}
+				return
+			case speechstream.EndInterrupted:
+				// A retry streams again from the start, which only helps
+				// listeners that haven't heard anything yet
+				if started {
+					return
+				}
+				continue
+			}
+			if !started {
+				w.Header().Set("Content-Type", "audio/wav")
+				w.WriteHeader(http.StatusOK)
+				started = true
+			}
+			if _, err := w.Write(entry.Chunk); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
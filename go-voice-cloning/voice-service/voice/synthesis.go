@@ -0,0 +1,268 @@
+package voice
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/voice-cloning/shared/analytics"
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+const synthesisColumns = `id, clone_id, user_id, COALESCE(text, '') AS text, COALESCE(ssml, '') AS ssml, status,
+	COALESCE(output_file, '') AS output_file, COALESCE(error, '') AS error,
+	COALESCE(duration_ms, 0) AS duration_ms, COALESCE(model_version, 0) AS model_version, created_at, updated_at, completed_at`
+
+// synthesisCursor is the keyset position after the last job of a page
+type synthesisCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int       `json:"id"`
+}
+
+// synthesisSort lists the newest jobs first
+var synthesisSort = types.PageSort{Name: "-created_at", Column: "created_at", Descending: true}
+
+// validateSynthesis checks that a request carries either plain text or a
+// well-formed <speak> document within the length limit
+func validateSynthesis(req types.SynthesisRequest) error {
+	text, ssml := strings.TrimSpace(req.Text), strings.TrimSpace(req.SSML)
+	switch {
+	case text == "" && ssml == "":
+		return errors.New("text or ssml is required")
+	case text != "" && ssml != "":
+		return errors.New("provide either text or ssml, not both")
+	case utf8.RuneCountInString(text+ssml) > types.MaxSynthesisText:
+		return fmt.Errorf("input is limited to %d characters", types.MaxSynthesisText)
+	}
+	if ssml == "" {
+		return nil
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(ssml))
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ssml: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+	if root != "speak" {
+		return errors.New("ssml must be a <speak> document")
+	}
+	return nil
+}
+
+// synthesizeClone queues speech generation with a completed clone's voice.
+// Clones shared with the user or public can be used too. The worker stores
+// the audio in the storage service; poll the returned job for its download
+// link.
+func (s *VoiceService) synthesizeClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	var req types.SynthesisRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateSynthesis(req); err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+	if req.ModelVersion < 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "model_version must be positive")
+		return
+	}
+
+	clone, err := accessibleClone(r.Context(), s.db, mux.Vars(r)["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if clone.Status != types.StatusCompleted {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only completed clones can synthesize speech")
+		return
+	}
+	if clone.ArchivedAt != nil {
+		utils.ErrorResponse(w, http.StatusConflict, apierror.CloneArchived, "Voice clone is archived; unarchive it to synthesize speech")
+		return
+	}
+	if req.ModelVersion != 0 {
+		var status string
+		err := s.db.GetContext(r.Context(), &status, "SELECT status FROM clone_models WHERE clone_id = $1 AND version = $2",
+			clone.ID, req.ModelVersion)
+		if err != nil {
+			dbError(w, err, http.StatusNotFound, apierror.VersionNotFound, "Model version not found")
+			return
+		}
+		if status != types.StatusCompleted {
+			utils.ErrorResponse(w, http.StatusConflict, apierror.CloneState, "Only completed model versions can synthesize speech")
+			return
+		}
+	}
+	// Minutes count against the user synthesizing, whoever owns the clone
+	entitlements, err := s.userEntitlements(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create synthesis job")
+		return
+	}
+	err = s.checkSynthesisQuota(r.Context(), s.db, userID, entitlements)
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		quotaExceeded(w, quotaErr)
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create synthesis job")
+		return
+	}
+	// Other users' syntheses run at their own plan's priority
+	priority := clone.Priority
+	if clone.UserID != userID {
+		priority = entitlements.MaxPriority
+	}
+
+	now := time.Now()
+	job := types.SynthesisJob{
+		CloneID:      clone.ID,
+		UserID:       userID,
+		Text:         strings.TrimSpace(req.Text),
+		SSML:         strings.TrimSpace(req.SSML),
+		ModelVersion: req.ModelVersion,
+		Status:       types.StatusPending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	err = s.db.GetContext(r.Context(), &job.ID,
+		`INSERT INTO synthesis_jobs (clone_id, user_id, text, ssml, model_version, status, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0), $6, $7, $7) RETURNING id`,
+		job.CloneID, job.UserID, job.Text, job.SSML, job.ModelVersion, job.Status, now)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to create synthesis job")
+		return
+	}
+
+	err = s.queue.Enqueue(r.Context(), jobqueue.Job{CloneID: clone.ID, SynthesisID: job.ID, Priority: priority})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to enqueue synthesis job", "synthesis_id", job.ID, "error", err)
+		s.db.ExecContext(r.Context(), "UPDATE synthesis_jobs SET status = $1, error = $2, updated_at = NOW() WHERE id = $3",
+			types.StatusFailed, "processing queue unavailable", job.ID)
+		w.Header().Set("Retry-After", "30")
+		utils.ErrorResponse(w, http.StatusServiceUnavailable, apierror.Unavailable, "Processing queue unavailable, try again later")
+		return
+	}
+
+	s.analytics.Track(analytics.EventSynthesisRequested, userID, map[string]interface{}{
+		"clone_id":     clone.ID,
+		"synthesis_id": job.ID,
+		"characters":   len([]rune(job.Text + job.SSML)),
+		"ssml":         job.SSML != "",
+		"shared":       clone.UserID != userID,
+	})
+	w.Header().Set("Location", fmt.Sprintf("/api/voice/clones/%d/syntheses/%d", clone.ID, job.ID))
+	utils.JSONResponse(w, http.StatusAccepted, job)
+}
+
+// listSyntheses returns the caller's synthesis jobs of a clone, newest first,
+// with ?limit=/?cursor= pagination. Owners and users a clone is shared with
+// each see only their own.
+func (s *VoiceService) listSyntheses(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"clone_id = $1", "user_id = $2"}
+	args := []interface{}{mux.Vars(r)["id"], userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM synthesis_jobs WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch synthesis jobs")
+		return
+	}
+
+	var cursor synthesisCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, synthesisSort.After(cursor.CreatedAt, cursor.ID, arg))
+	}
+
+	jobs := []types.SynthesisJob{}
+	err = db.SelectContext(r.Context(), &jobs,
+		"SELECT "+synthesisColumns+" FROM synthesis_jobs WHERE "+strings.Join(where, " AND ")+" "+synthesisSort.OrderBy()+" "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch synthesis jobs")
+		return
+	}
+	for i := range jobs {
+		setSynthesisDownload(&jobs[i])
+	}
+	utils.SuccessResponse(w, types.NewPage(page, jobs, total, func(last types.SynthesisJob) interface{} {
+		return synthesisCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}))
+}
+
+// getSynthesis returns a synthesis job with a download link once completed
+func (s *VoiceService) getSynthesis(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	var job types.SynthesisJob
+	err := s.db.GetContext(r.Context(), &job,
+		"SELECT "+synthesisColumns+" FROM synthesis_jobs WHERE id = $1 AND clone_id = $2 AND user_id = $3",
+		vars["synthesis_id"], vars["id"], userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.NotFound, "Synthesis job not found")
+		return
+	}
+	setSynthesisDownload(&job)
+	utils.SuccessResponse(w, job)
+}
+
+func setSynthesisDownload(job *types.SynthesisJob) {
+	if job.Status == types.StatusCompleted && job.OutputFile != "" {
+		job.DownloadURL = "/api/storage/download/" + url.PathEscape(job.OutputFile)
+	}
+}
@@ -0,0 +1,189 @@
+package voice
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/validation"
+)
+
+// Limits on editable clone metadata
+const (
+	maxNameLength        = 255
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 50
+	maxMetadataKeys      = 50
+	maxMetadataKeyLength = 100
+	maxMetadataBytes     = 16 << 10
+)
+
+// cloneETag identifies a clone revision by its update time
+func cloneETag(updatedAt time.Time) string {
+	return `"` + strconv.FormatInt(updatedAt.UnixMicro(), 10) + `"`
+}
+
+// parseETag returns the update time encoded in an If-Match value
+func parseETag(value string) (time.Time, bool) {
+	value = strings.Trim(strings.TrimPrefix(strings.TrimSpace(value), "W/"), `"`)
+	micros, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros).UTC(), true
+}
+
+// updateClone changes a clone's name, description, tags or metadata. If-Match (or
+// updated_at in the body) guards against overwriting a concurrent edit.
+func (s *VoiceService) updateClone(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	cloneID := mux.Vars(r)["id"]
+
+	var req types.VoiceCloneUpdateRequest
+	if !validation.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var expected *time.Time
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" {
+		t, ok := parseETag(ifMatch)
+		if !ok {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidHeader, "Invalid If-Match header")
+			return
+		}
+		expected = &t
+	} else if req.UpdatedAt != nil {
+		t := req.UpdatedAt.UTC().Truncate(time.Microsecond)
+		expected = &t
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > maxNameLength {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("name must be between 1 and %d characters", maxNameLength))
+			return
+		}
+		set("name", name)
+	}
+	if req.Description != nil {
+		if len(*req.Description) > maxDescriptionLength {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("description must be at most %d characters", maxDescriptionLength))
+			return
+		}
+		set("description", *req.Description)
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+			return
+		}
+		set("tags", pq.StringArray(tags))
+	}
+	if req.Metadata != nil {
+		if err := validateMetadata(*req.Metadata); err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+			return
+		}
+		set("metadata", *req.Metadata)
+	}
+	if len(sets) == 0 {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "No fields to update")
+		return
+	}
+	set("updated_at", time.Now())
+
+	args = append(args, cloneID, userID)
+	query := fmt.Sprintf("UPDATE voice_clones SET %s WHERE id = $%d AND user_id = $%d AND deleted_at IS NULL",
+		strings.Join(sets, ", "), len(args)-1, len(args))
+	if expected != nil {
+		args = append(args, *expected)
+		query += fmt.Sprintf(" AND updated_at = $%d", len(args))
+	}
+	query += " RETURNING " + cloneColumns
+
+	var clone types.VoiceClone
+	err := s.db.GetContext(r.Context(), &clone, query, args...)
+	if err == sql.ErrNoRows {
+		var exists bool
+		s.db.GetContext(r.Context(), &exists, "SELECT EXISTS(SELECT 1 FROM voice_clones WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)", cloneID, userID)
+		if exists {
+			utils.ErrorResponse(w, http.StatusPreconditionFailed, apierror.CloneModified, "Voice clone was modified since it was read; fetch it again and retry")
+			return
+		}
+		utils.ErrorResponse(w, http.StatusNotFound, apierror.VoiceCloneNotFound, "Voice clone not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to update voice clone")
+		return
+	}
+
+	w.Header().Set("ETag", cloneETag(clone.UpdatedAt))
+	utils.SuccessResponse(w, clone)
+}
+
+// normalizeTags trims, lowercases and de-duplicates tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return normalized, nil
+}
+
+// validateMetadata limits the size of a clone's metadata object
+func validateMetadata(metadata types.CloneMetadata) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata can have at most %d keys", maxMetadataKeys)
+	}
+	for key := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata keys must be between 1 and %d characters", maxMetadataKeyLength)
+		}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("metadata must be a JSON object")
+	}
+	if len(encoded) > maxMetadataBytes {
+		return fmt.Errorf("metadata must be at most %d bytes of JSON", maxMetadataBytes)
+	}
+	return nil
+}
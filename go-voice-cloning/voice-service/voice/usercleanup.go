@@ -0,0 +1,419 @@
+package voice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// User cleanup statuses
+const (
+	CleanupPending   = "pending"
+	CleanupRunning   = "running"
+	CleanupCompleted = "completed"
+	CleanupFailed    = "failed"
+)
+
+const (
+	// maxCleanupAttempts before a cleanup is left failed for an admin
+	maxCleanupAttempts = 5
+	// cleanupStaleAfter reclaims cleanups of a replica that died mid-run
+	cleanupStaleAfter = 10 * time.Minute
+	cleanupBatchSize  = 20
+)
+
+// UserCleanup is the progress, and once finished the report, of removing a
+// deleted user's voice data
+type UserCleanup struct {
+	ID               int            `json:"id" db:"id"`
+	EventID          int            `json:"event_id" db:"event_id"`
+	UserID           int            `json:"user_id" db:"user_id"`
+	Status           string         `json:"status" db:"status"`
+	Attempts         int            `json:"attempts" db:"attempts"`
+	ClonesTotal      int            `json:"clones_total" db:"clones_total"`
+	ClonesDeleted    int            `json:"clones_deleted" db:"clones_deleted"`
+	SynthesesDeleted int            `json:"syntheses_deleted" db:"syntheses_deleted"`
+	FilesDeleted     int            `json:"files_deleted" db:"files_deleted"`
+	FilesFailed      pq.StringArray `json:"files_failed" db:"files_failed"`
+	Error            string         `json:"error,omitempty" db:"error"`
+	CreatedAt        time.Time      `json:"created_at" db:"created_at"`
+	StartedAt        *time.Time     `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt      *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+	UpdatedAt        time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+const cleanupColumns = `id, event_id, user_id, status, attempts, clones_total, clones_deleted, syntheses_deleted,
+	files_deleted, files_failed, COALESCE(error, '') AS error, created_at, started_at, completed_at, updated_at`
+
+// runUserCleanups removes the voice data of deleted users. It wakes on
+// user_events notifications and polls the outbox every interval for events
+// missed while no listener was connected.
+func (s *VoiceService) runUserCleanups(ctx context.Context, dbURL string, interval time.Duration) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("User event listener error", "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(types.UserEventsChannel); err != nil {
+		slog.Error("Failed to listen for user events", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.claimUserEvents(ctx); err != nil {
+			slog.Error("Failed to read user events", "error", err)
+		}
+		for ctx.Err() == nil {
+			cleanup, err := s.nextUserCleanup(ctx, 0)
+			if err != nil {
+				slog.Error("Failed to start user cleanup", "error", err)
+				break
+			}
+			if cleanup == nil {
+				break
+			}
+			s.runUserCleanup(ctx, cleanup)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimUserEvents records a pending cleanup for every user_deleted event not
+// seen yet
+func (s *VoiceService) claimUserEvents(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_cleanups (event_id, user_id, status, created_at, updated_at)
+		SELECT id, user_id, $1, NOW(), NOW() FROM user_events
+		WHERE kind = $2 AND id > (SELECT COALESCE(MAX(event_id), 0) FROM user_cleanups)
+		ON CONFLICT (event_id) DO NOTHING`,
+		CleanupPending, types.UserEventDeleted)
+	return err
+}
+
+// nextUserCleanup claims the oldest pending cleanup, or one whose replica
+// stopped reporting progress, of the given user or, for 0, of any user
+func (s *VoiceService) nextUserCleanup(ctx context.Context, userID int) (*UserCleanup, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cleanup UserCleanup
+	err = tx.GetContext(ctx, &cleanup,
+		`SELECT `+cleanupColumns+` FROM user_cleanups
+		WHERE (status = $1 OR (status = $2 AND updated_at < $3)) AND ($4 = 0 OR user_id = $4)
+		ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		CleanupPending, CleanupRunning, time.Now().Add(-cleanupStaleAfter), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE user_cleanups SET status = $1, attempts = attempts + 1,
+			started_at = COALESCE(started_at, NOW()), updated_at = NOW() WHERE id = $2`,
+		CleanupRunning, cleanup.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	cleanup.Attempts++
+	return &cleanup, nil
+}
+
+// runUserCleanup removes a user's data and records the outcome. A failed
+// attempt goes back to pending until it has been tried maxCleanupAttempts
+// times; counters carry over, since work already done stays done.
+func (s *VoiceService) runUserCleanup(ctx context.Context, cleanup *UserCleanup) {
+	err := s.cleanUpUser(ctx, cleanup)
+	if err == nil {
+		_, err = s.db.ExecContext(ctx,
+			"UPDATE user_cleanups SET status = $1, error = NULL, completed_at = NOW(), updated_at = NOW() WHERE id = $2",
+			CleanupCompleted, cleanup.ID)
+		if err != nil {
+			slog.Error("Failed to complete user cleanup", "user_id", cleanup.UserID, "error", err)
+		}
+		slog.Info("Removed voice data of deleted user", "user_id", cleanup.UserID)
+		return
+	}
+
+	// An attempt cut short by shutdown is resumed by the next replica
+	status := CleanupPending
+	if cleanup.Attempts >= maxCleanupAttempts && ctx.Err() == nil {
+		status = CleanupFailed
+	}
+	slog.Error("Cleanup of deleted user failed", "user_id", cleanup.UserID, "attempt", cleanup.Attempts, "error", err)
+	_, err = s.db.ExecContext(ctx, "UPDATE user_cleanups SET status = $1, error = $2, updated_at = NOW() WHERE id = $3",
+		status, err.Error(), cleanup.ID)
+	if err != nil {
+		slog.Error("Failed to record user cleanup failure", "user_id", cleanup.UserID, "error", err)
+	}
+}
+
+// cleanUpUser deletes a user's clones one at a time, with their syntheses,
+// artifacts and files, then their remaining uploads and settings
+func (s *VoiceService) cleanUpUser(ctx context.Context, cleanup *UserCleanup) error {
+	var remaining int
+	if err := s.db.GetContext(ctx, &remaining, "SELECT COUNT(*) FROM voice_clones WHERE user_id = $1", cleanup.UserID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE user_cleanups SET clones_total = clones_deleted + $1, updated_at = NOW() WHERE id = $2",
+		remaining, cleanup.ID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		ids := []int{}
+		err := s.db.SelectContext(ctx, &ids,
+			"SELECT id FROM voice_clones WHERE user_id = $1 ORDER BY id LIMIT $2", cleanup.UserID, cleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			if err := s.cleanUpClone(ctx, cleanup, id); err != nil {
+				return fmt.Errorf("clone %d: %w", id, err)
+			}
+		}
+	}
+
+	// Syntheses the user ran with clones shared with them. Their audio is
+	// among the stored files deleted below.
+	res, err := s.db.ExecContext(ctx, "DELETE FROM synthesis_jobs WHERE user_id = $1", cleanup.UserID)
+	if err != nil {
+		return err
+	}
+	syntheses, _ := res.RowsAffected()
+	if err := s.recordDeletedFiles(ctx, cleanup, 0, int(syntheses), fileDeletion{}); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM clone_shares WHERE user_id = $1", cleanup.UserID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM synthesis_usage WHERE user_id = $1", cleanup.UserID); err != nil {
+		return err
+	}
+
+	// Whatever else the user stored, such as uploads never used as a source
+	files := []string{}
+	err = s.db.SelectContext(ctx, &files, "SELECT filename FROM stored_files WHERE user_id = $1", cleanup.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.recordDeletedFiles(ctx, cleanup, 0, 0, s.deleteStoredFiles(ctx, files)); err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM clone_defaults WHERE scope = $1 AND scope_id = $2",
+		scopeUser, strconv.Itoa(cleanup.UserID))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "DELETE FROM voice_callback_settings WHERE user_id = $1", cleanup.UserID)
+	return err
+}
+
+// cleanUpClone deletes one clone and its files and adds them to the report
+func (s *VoiceService) cleanUpClone(ctx context.Context, cleanup *UserCleanup, cloneID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var clone types.VoiceClone
+	err = tx.GetContext(ctx, &clone, "SELECT "+cloneColumns+" FROM voice_clones WHERE id = $1 FOR UPDATE", cloneID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var syntheses int
+	if err := tx.GetContext(ctx, &syntheses, "SELECT COUNT(*) FROM synthesis_jobs WHERE clone_id = $1", cloneID); err != nil {
+		return err
+	}
+	// A queued or running job is dropped by the worker once the clone is gone
+	files, err := removeClone(ctx, tx, clone)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return s.recordDeletedFiles(ctx, cleanup, 1, syntheses, s.deleteStoredFiles(ctx, files))
+}
+
+// deleteStoredFiles deletes files from the storage service, collecting the
+// ones that failed rather than stopping
+func (s *VoiceService) deleteStoredFiles(ctx context.Context, files []string) fileDeletion {
+	var result fileDeletion
+	for _, file := range files {
+		if err := s.deleteStoredFile(ctx, file); err != nil {
+			logging.FromContext(ctx).Warn("Failed to delete file", "file", file, "error", err)
+			result.failed = append(result.failed, file)
+			continue
+		}
+		result.deleted++
+	}
+	return result
+}
+
+type fileDeletion struct {
+	deleted int
+	failed  []string
+}
+
+// recordDeletedFiles adds to a cleanup's counters, which doubles as its
+// progress heartbeat
+func (s *VoiceService) recordDeletedFiles(ctx context.Context, cleanup *UserCleanup, clones, syntheses int, files fileDeletion) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE user_cleanups SET clones_deleted = clones_deleted + $1, syntheses_deleted = syntheses_deleted + $2,
+			files_deleted = files_deleted + $3, files_failed = files_failed || $4::text[], updated_at = NOW()
+		WHERE id = $5`,
+		clones, syntheses, files.deleted, pq.StringArray(files.failed), cleanup.ID)
+	return err
+}
+
+// runUserCleanupNow runs a deleted user's pending cleanup in the request,
+// for the user service's account deletion orchestrator, rather than waiting
+// for the next run (internal). It reports the user's latest cleanup: 200
+// once it has finished, completed or failed, and 202 while another replica
+// is running it.
+func (s *VoiceService) runUserCleanupNow(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, "Invalid user ID")
+		return
+	}
+	if err := s.claimUserEvents(r.Context()); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to read user events")
+		return
+	}
+	cleanup, err := s.nextUserCleanup(r.Context(), userID)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to start user cleanup")
+		return
+	}
+	if cleanup != nil {
+		s.runUserCleanup(r.Context(), cleanup)
+	}
+
+	var latest UserCleanup
+	err = s.db.GetContext(r.Context(), &latest,
+		"SELECT "+cleanupColumns+" FROM user_cleanups WHERE user_id = $1 ORDER BY id DESC LIMIT 1", userID)
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.NotFound, "No cleanup found for user")
+		return
+	}
+	status := http.StatusOK
+	if latest.Status == CleanupPending || latest.Status == CleanupRunning {
+		status = http.StatusAccepted
+	}
+	utils.JSONResponse(w, status, latest)
+}
+
+// cleanupCursor is the position after the last cleanup of a page
+type cleanupCursor struct {
+	ID int `json:"id"`
+}
+
+// listUserCleanups reports the cleanups of deleted users, newest first,
+// optionally filtered by ?status=, with ?limit=/?cursor= pagination
+func (s *VoiceService) listUserCleanups(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	page, err := types.ParsePageRequest(r.URL.Query(), defaultPageSize, maxPageSize)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidParameter, err.Error())
+		return
+	}
+
+	where := []string{"TRUE"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		where = append(where, "status = "+arg(status))
+	}
+
+	db := s.reader(r)
+
+	var total int
+	if err := db.GetContext(r.Context(), &total, "SELECT COUNT(*) FROM user_cleanups WHERE "+strings.Join(where, " AND "), args...); err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch user cleanups")
+		return
+	}
+
+	var cursor cleanupCursor
+	if ok, err := page.Position(&cursor); ok {
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return
+		}
+		where = append(where, "id < "+arg(cursor.ID))
+	}
+
+	cleanups := []UserCleanup{}
+	err = db.SelectContext(r.Context(), &cleanups,
+		"SELECT "+cleanupColumns+" FROM user_cleanups WHERE "+strings.Join(where, " AND ")+" ORDER BY id DESC "+page.LimitClause(arg),
+		args...)
+	if err != nil {
+		dbError(w, err, http.StatusInternalServerError, apierror.Internal, "Failed to fetch user cleanups")
+		return
+	}
+	utils.SuccessResponse(w, types.NewPage(page, cleanups, total, func(last UserCleanup) interface{} {
+		return cleanupCursor{ID: last.ID}
+	}))
+}
+
+// getUserCleanup reports the latest cleanup of a deleted user
+func (s *VoiceService) getUserCleanup(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var cleanup UserCleanup
+	err := s.reader(r).GetContext(r.Context(), &cleanup,
+		"SELECT "+cleanupColumns+" FROM user_cleanups WHERE user_id = $1 ORDER BY id DESC LIMIT 1",
+		mux.Vars(r)["user_id"])
+	if err != nil {
+		dbError(w, err, http.StatusNotFound, apierror.NotFound, "No cleanup found for user")
+		return
+	}
+	utils.SuccessResponse(w, cleanup)
+}
@@ -0,0 +1,99 @@
+package voice
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/voice-cloning/shared/apierror"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/utils"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 50 * time.Second
+)
+
+// Sessions authenticate with a bearer token checked by the gateway, not with
+// cookies, so cross-origin connections carry no ambient credentials
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsMessage is the envelope of every notification pushed to a client: a
+// types.CloneEvent, or a types.UploadEvent for upload.* types
+type wsMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// serveNotifications pushes status changes of all of the user's clones, and
+// the progress of their resumable uploads, over a single WebSocket
+// connection
+func (s *VoiceService) serveNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r)
+	if userID == 0 {
+		utils.ErrorResponse(w, http.StatusUnauthorized, apierror.AuthenticationRequired, "Unauthorized")
+		return
+	}
+
+	events, unsubscribe := s.events.SubscribeUser(userID)
+	defer unsubscribe()
+	uploads, unsubscribeUploads := s.events.SubscribeUploads(userID)
+	defer unsubscribeUploads()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading detects disconnects
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(wsMessage{Type: "clone." + event.Kind, Data: event}); err != nil {
+				logging.FromContext(r.Context()).Warn("Failed to push notification", "error", err)
+				return
+			}
+		case event := <-uploads:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(wsMessage{Type: "upload." + event.Kind, Data: event}); err != nil {
+				logging.FromContext(r.Context()).Warn("Failed to push notification", "error", err)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
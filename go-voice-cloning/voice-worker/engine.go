@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/capabilities"
+	"github.com/voice-cloning/shared/types"
+)
+
+// Engine is the backend that trains voice models and speaks with them.
+// Files are exchanged as local paths; the pipeline moves them to and from
+// the storage service.
+type Engine interface {
+	// Name identifies the engine in logs and manifests
+	Name() string
+	// Validate checks that a sample can be trained on. Rejections wrap
+	// errInvalidSample and fail the clone without retrying.
+	Validate(ctx context.Context, samplePath string) error
+	// Train builds a voice model from a sample and writes it to
+	// req.ModelPath, reporting progress as a fraction from 0 to 1
+	Train(ctx context.Context, req TrainRequest, progress func(fraction float64) error) error
+	// Synthesize speaks text or SSML with a model, writing WAV audio to
+	// req.OutputPath and, when set, to req.Stream
+	Synthesize(ctx context.Context, req SynthesizeRequest) error
+}
+
+// TrainRequest is the input of Engine.Train. Variant is the engine model
+// variant serving the settings' model and language, from the capabilities
+// catalog.
+type TrainRequest struct {
+	CloneID    int
+	SamplePath string
+	ModelPath  string
+	Settings   types.CloneSettings
+	Variant    string
+}
+
+// SynthesizeRequest is the input of Engine.Synthesize. Stream is sent the
+// audio as it is produced, by engines that can; the others send it once
+// done.
+type SynthesizeRequest struct {
+	ModelPath  string
+	Text       string
+	SSML       string
+	Language   string
+	Locale     string
+	Variant    string
+	OutputPath string
+	Stream     io.Writer
+}
+
+// streamFile sends a finished output file to stream, for engines that only
+// produce whole files
+func streamFile(path string, stream io.Writer) error {
+	if stream == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(stream, f)
+	return err
+}
+
+// errInvalidSample means the engine rejected a sample; retrying won't help
+var errInvalidSample = errors.New("sample rejected by the cloning engine")
+
+// errEngineTimeout and errEngineOutOfMemory mark engine failures that are
+// reported to users by cause
+var (
+	errEngineTimeout     = errors.New("cloning engine timed out")
+	errEngineOutOfMemory = errors.New("cloning engine ran out of memory")
+)
+
+// outOfMemory reports whether an engine's error output says it ran out of
+// memory
+func outOfMemory(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "out of memory") || strings.Contains(message, "memoryerror")
+}
+
+// Engine selection (ENGINE)
+const (
+	EngineMock    = "mock"
+	EngineHTTP    = "http"
+	EngineCommand = "command"
+)
+
+// EngineConfig selects the engine and configures it. The mock's training
+// duration is a Go duration such as 10s.
+type EngineConfig struct {
+	Engine               string `env:"ENGINE" default:"mock"`
+	MockTrainingDuration string `env:"ENGINE_MOCK_TRAINING_DURATION" default:"10s"`
+	URL                  string `env:"ENGINE_URL"`
+	APIKey               string `env:"ENGINE_API_KEY" secret:"true"`
+	Command              string `env:"ENGINE_COMMAND"`
+}
+
+// newEngine builds the configured engine
+func newEngine(cfg EngineConfig) (Engine, error) {
+	switch name := cfg.Engine; name {
+	case "", EngineMock:
+		duration, err := time.ParseDuration(cfg.MockTrainingDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENGINE_MOCK_TRAINING_DURATION: %w", err)
+		}
+		return &MockEngine{TrainingDuration: duration}, nil
+	case EngineHTTP:
+		if cfg.URL == "" {
+			return nil, errors.New("ENGINE_URL is required for the http engine")
+		}
+		return NewHTTPEngine(cfg.URL, cfg.APIKey), nil
+	case EngineCommand:
+		command := strings.Fields(cfg.Command)
+		if len(command) == 0 {
+			return nil, errors.New("ENGINE_COMMAND is required for the command engine")
+		}
+		return &CommandEngine{Command: command}, nil
+	default:
+		return nil, fmt.Errorf("unknown ENGINE %q (mock, http or command)", name)
+	}
+}
+
+// variant routes a clone's settings to the engine variant serving its model
+// and language. Settings the catalog no longer supports, such as those of
+// clones created before a model was dropped, run on the variant named after
+// their model.
+func variant(catalog capabilities.Catalog, settings types.CloneSettings) string {
+	v, err := catalog.Route(settings.Model, settings.Language, settings.Locale)
+	if err != nil {
+		return settings.Model
+	}
+	return v
+}
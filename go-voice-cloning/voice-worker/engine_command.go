@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// CommandEngine runs a local command-line model. The subcommand and paths
+// are appended to Command:
+//
+//	validate <sample>                 exit status 2 rejects the sample
+//	train <sample> <model> <settings> prints "progress <0-1>" lines while training
+//	synthesize <model> <output>       reads text on stdin; followed by --ssml for SSML
+//	                                  input and --language <tag> and --locale <tag> when set
+//
+// settings is the clone's settings as JSON. train and synthesize are
+// followed by --variant <name> when the job was routed to an engine variant.
+type CommandEngine struct {
+	Command []string
+}
+
+func (e *CommandEngine) Name() string { return EngineCommand }
+
+func (e *CommandEngine) Validate(ctx context.Context, samplePath string) error {
+	var stderr bytes.Buffer
+	cmd := e.command(ctx, "validate", samplePath)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return fmt.Errorf("%w: %s", errInvalidSample, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return commandError(ctx, "validate", err, stderr.String())
+	}
+	return nil
+}
+
+func (e *CommandEngine) Train(ctx context.Context, req TrainRequest, progress func(float64) error) error {
+	// Stop the model if progress reporting fails, e.g. on cancellation
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	settings, _ := json.Marshal(req.Settings)
+	var stderr bytes.Buffer
+	args := []string{"train", req.SamplePath, req.ModelPath, string(settings)}
+	if req.Variant != "" {
+		args = append(args, "--variant", req.Variant)
+	}
+	cmd := e.command(ctx, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var progressErr error
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "progress ")
+		if !ok || progressErr != nil {
+			continue
+		}
+		if fraction, err := strconv.ParseFloat(value, 64); err == nil {
+			if progressErr = progress(fraction); progressErr != nil {
+				cancel()
+			}
+		}
+	}
+
+	err = cmd.Wait()
+	if progressErr != nil {
+		return progressErr
+	}
+	if err != nil {
+		return commandError(ctx, "training", err, stderr.String())
+	}
+	return nil
+}
+
+func (e *CommandEngine) Synthesize(ctx context.Context, req SynthesizeRequest) error {
+	args := []string{"synthesize", req.ModelPath, req.OutputPath}
+	input := req.Text
+	if req.SSML != "" {
+		args = append(args, "--ssml")
+		input = req.SSML
+	}
+	if req.Language != "" {
+		args = append(args, "--language", req.Language)
+	}
+	if req.Locale != "" {
+		args = append(args, "--locale", req.Locale)
+	}
+	if req.Variant != "" {
+		args = append(args, "--variant", req.Variant)
+	}
+
+	var stderr bytes.Buffer
+	cmd := e.command(ctx, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return commandError(ctx, "synthesis", err, stderr.String())
+	}
+	return streamFile(req.OutputPath, req.Stream)
+}
+
+// commandError describes a failed run of the engine command. A run killed
+// outright (as the kernel's OOM killer does), or that says it ran out of
+// memory, is marked as running out of memory.
+func commandError(ctx context.Context, op string, err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if ctx.Err() != nil {
+		return fmt.Errorf("engine %s stopped: %w", op, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return fmt.Errorf("%w: engine %s was killed: %s", errEngineOutOfMemory, op, stderr)
+		}
+	}
+	if outOfMemory(stderr) {
+		return fmt.Errorf("%w: engine %s failed: %s", errEngineOutOfMemory, op, stderr)
+	}
+	return fmt.Errorf("engine %s failed: %w: %s", op, err, stderr)
+}
+
+func (e *CommandEngine) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, e.Command[0], append(append([]string{}, e.Command[1:]...), args...)...)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPEngine calls an external inference API:
+//
+//	POST /validate              multipart "sample"; 422 rejects it
+//	POST /train                 multipart "sample", "settings" and "variant"; returns {"job_id"}
+//	GET  /train/{job_id}        {"status": "running|completed|failed", "progress", "error"}
+//	GET  /train/{job_id}/model  the trained model
+//	POST /synthesize            multipart "model", "text" or "ssml", "language", "locale" and
+//	                            "variant"; returns WAV audio
+type HTTPEngine struct {
+	baseURL      string
+	apiKey       string
+	client       *http.Client
+	pollInterval time.Duration
+}
+
+func NewHTTPEngine(baseURL, apiKey string) *HTTPEngine {
+	return &HTTPEngine{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		apiKey:       apiKey,
+		client:       &http.Client{Timeout: 10 * time.Minute},
+		pollInterval: 2 * time.Second,
+	}
+}
+
+func (e *HTTPEngine) Name() string { return EngineHTTP }
+
+func (e *HTTPEngine) Validate(ctx context.Context, samplePath string) error {
+	resp, err := e.postFiles(ctx, "/validate", map[string]string{"sample": samplePath}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return fmt.Errorf("%w: %s", errInvalidSample, engineError(resp))
+	case resp.StatusCode >= 300:
+		return engineStatusError(resp, "validate")
+	}
+	return nil
+}
+
+func (e *HTTPEngine) Train(ctx context.Context, req TrainRequest, progress func(float64) error) error {
+	settings, _ := json.Marshal(req.Settings)
+	resp, err := e.postFiles(ctx, "/train", map[string]string{"sample": req.SamplePath},
+		map[string]string{"settings": string(settings), "variant": req.Variant})
+	if err != nil {
+		return err
+	}
+	var started struct {
+		JobID string `json:"job_id"`
+	}
+	err = decodeEngineResponse(resp, "train", &started)
+	if err != nil {
+		return err
+	}
+	if started.JobID == "" {
+		return fmt.Errorf("engine train returned no job_id")
+	}
+
+	jobPath := "/train/" + url.PathEscape(started.JobID)
+	for {
+		select {
+		case <-time.After(e.pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		resp, err := e.do(ctx, http.MethodGet, jobPath, nil, "")
+		if err != nil {
+			return err
+		}
+		var job struct {
+			Status   string  `json:"status"`
+			Progress float64 `json:"progress"`
+			Error    string  `json:"error"`
+		}
+		if err := decodeEngineResponse(resp, "train status", &job); err != nil {
+			return err
+		}
+
+		switch job.Status {
+		case "completed":
+			return e.download(ctx, jobPath+"/model", req.ModelPath)
+		case "failed":
+			if outOfMemory(job.Error) {
+				return fmt.Errorf("%w: %s", errEngineOutOfMemory, job.Error)
+			}
+			return fmt.Errorf("engine training failed: %s", job.Error)
+		}
+		if err := progress(job.Progress); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *HTTPEngine) Synthesize(ctx context.Context, req SynthesizeRequest) error {
+	fields := map[string]string{"language": req.Language, "locale": req.Locale, "variant": req.Variant}
+	if req.SSML != "" {
+		fields["ssml"] = req.SSML
+	} else {
+		fields["text"] = req.Text
+	}
+	resp, err := e.postFiles(ctx, "/synthesize", map[string]string{"model": req.ModelPath}, fields)
+	if err != nil {
+		return err
+	}
+	return saveEngineResponse(resp, "synthesize", req.OutputPath, req.Stream)
+}
+
+func (e *HTTPEngine) download(ctx context.Context, path, dst string) error {
+	resp, err := e.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	return saveEngineResponse(resp, "model download", dst, nil)
+}
+
+// postFiles sends local files and form fields as multipart/form-data
+func (e *HTTPEngine) postFiles(ctx context.Context, path string, files, fields map[string]string) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		var err error
+		for name, value := range fields {
+			if err = form.WriteField(name, value); err != nil {
+				break
+			}
+		}
+		for name, filePath := range files {
+			if err != nil {
+				break
+			}
+			err = writeFormFile(form, name, filePath)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return e.do(ctx, http.MethodPost, path, pr, form.FormDataContentType())
+}
+
+func writeFormFile(form *multipart.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := form.CreateFormFile(name, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+func (e *HTTPEngine) do(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	return e.client.Do(req)
+}
+
+func decodeEngineResponse(resp *http.Response, op string, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return engineStatusError(resp, op)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid engine %s response: %w", op, err)
+	}
+	return nil
+}
+
+func saveEngineResponse(resp *http.Response, op, dst string, stream io.Writer) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return engineStatusError(resp, op)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if stream != nil {
+		w = io.MultiWriter(out, stream)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// engineStatusError describes an error response, marking timeouts and
+// running out of memory
+func engineStatusError(resp *http.Response, op string) error {
+	message := engineError(resp)
+	err := fmt.Errorf("engine %s returned %d: %s", op, resp.StatusCode, message)
+	switch {
+	case resp.StatusCode == http.StatusGatewayTimeout || resp.StatusCode == http.StatusRequestTimeout:
+		return fmt.Errorf("%w: %v", errEngineTimeout, err)
+	case resp.StatusCode == http.StatusInsufficientStorage || outOfMemory(message):
+		return fmt.Errorf("%w: %v", errEngineOutOfMemory, err)
+	}
+	return err
+}
+
+// engineError extracts the message of an error response
+func engineError(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return body.Error
+	}
+	return strings.TrimSpace(string(bytes.TrimSpace(data)))
+}
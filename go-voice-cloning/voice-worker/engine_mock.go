@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MockEngine stands in for a real model: training waits TrainingDuration
+// and the "model" is the sample itself, which synthesis plays back
+// regardless of the text. It needs no external dependencies, for local
+// development and tests.
+type MockEngine struct {
+	TrainingDuration time.Duration
+}
+
+func (e *MockEngine) Name() string { return EngineMock }
+
+func (e *MockEngine) Validate(ctx context.Context, samplePath string) error {
+	info, err := os.Stat(samplePath)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%w: sample is empty", errInvalidSample)
+	}
+	return nil
+}
+
+func (e *MockEngine) Train(ctx context.Context, req TrainRequest, progress func(float64) error) error {
+	const steps = 10
+	if e.TrainingDuration > 0 {
+		tick := time.NewTicker(e.TrainingDuration / steps)
+		defer tick.Stop()
+		for step := 1; step <= steps; step++ {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if err := progress(float64(step) / steps); err != nil {
+				return err
+			}
+		}
+	}
+	return copyFile(req.SamplePath, req.ModelPath)
+}
+
+func (e *MockEngine) Synthesize(ctx context.Context, req SynthesizeRequest) error {
+	if err := copyFile(req.ModelPath, req.OutputPath); err != nil {
+		return err
+	}
+	return streamFile(req.OutputPath, req.Stream)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
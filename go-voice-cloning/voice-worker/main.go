@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/capabilities"
+	"github.com/voice-cloning/shared/config"
+	"github.com/voice-cloning/shared/dbpool"
+	"github.com/voice-cloning/shared/health"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/logging"
+	"github.com/voice-cloning/shared/manifest"
+	"github.com/voice-cloning/shared/middleware"
+	"github.com/voice-cloning/shared/mtls"
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+	"github.com/voice-cloning/shared/webhooks"
+	"github.com/voice-cloning/shared/workerpool"
+)
+
+// Worker executes voice clone jobs from the queue. It shares the voice
+// service's database but runs as its own binary so compute can be scaled
+// independently of the API tier.
+type Worker struct {
+	db      *sqlx.DB
+	storage *StorageClient
+	pool    *workerpool.Pool
+	engine  Engine
+	// engineTimeout bounds the training of one clone
+	engineTimeout time.Duration
+	// capabilities routes jobs to engine variants
+	capabilities capabilities.Catalog
+	// processingLimits caps the jobs each plan's users have running at
+	// once, fleet-wide; slotWait is how long jobs over it wait to retry
+	processingLimits map[string]int
+	slotWait         time.Duration
+	// bus streams synthesized speech to listeners as it is produced
+	bus *redis.Client
+
+	// previewText is spoken by every new clone as its output
+	previewText string
+
+	// Reproducibility manifest inputs
+	signer       *manifest.Signer
+	modelVersion string
+	imageDigest  string
+
+	// checks probe the worker's dependencies for its health endpoints
+	checks *health.Checker
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad("voice-worker", &cfg)
+	if err := logging.Setup("voice-worker", cfg.LogLevel); err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+	config.LogSummary(&cfg)
+
+	tlsSource, err := mtls.Setup(cfg.TLS)
+	if err != nil {
+		log.Fatal("Invalid mTLS configuration: ", err)
+	}
+
+	// Database connection
+	db, err := dbpool.Connect(cfg.DatabaseURL, cfg.DB)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	queue, err := jobqueue.New(context.Background(), cfg.RedisURL, jobqueue.Options{
+		MaxAttempts: cfg.JobMaxAttempts,
+		ClaimIdle:   cfg.JobClaimIdle,
+		Weights:     priorityWeights(cfg.JobPriorityWeights),
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize job queue:", err)
+	}
+	defer queue.Close()
+
+	busOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		log.Fatal("Invalid REDIS_URL:", err)
+	}
+	bus := redis.NewClient(busOpts)
+	defer bus.Close()
+
+	pool := workerpool.New(workerpool.Config{
+		Name:      "voice-clone",
+		Workers:   cfg.WorkerConcurrency,
+		QueueSize: cfg.WorkerBufferSize,
+		Hooks: workerpool.Hooks{
+			OnPanic: func(rec interface{}, stack []byte) {
+				slog.Error("Voice clone job panicked", "panic", fmt.Sprint(rec), "stack", string(stack))
+			},
+		},
+	})
+
+	signer, err := manifest.SignerFromEnv()
+	if err != nil {
+		log.Fatal("Failed to load manifest signing key:", err)
+	}
+
+	engine, err := newEngine(cfg.Engine)
+	if err != nil {
+		log.Fatal("Failed to configure cloning engine:", err)
+	}
+	slog.Info("Using cloning engine", "engine", engine.Name())
+
+	catalog, err := capabilities.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to load engine capabilities:", err)
+	}
+
+	worker := &Worker{
+		db:               db,
+		storage:          NewStorageClient(cfg.StorageServiceURL),
+		pool:             pool,
+		engine:           engine,
+		engineTimeout:    cfg.EngineTimeout,
+		capabilities:     catalog,
+		processingLimits: processingLimitsFromEnv(),
+		slotWait:         cfg.JobSlotWait,
+		bus:              bus,
+		previewText:      cfg.EnginePreviewText,
+		signer:           signer,
+		modelVersion:     cfg.ModelVersion,
+		imageDigest:      cfg.WorkerImageDigest,
+	}
+	queue.OnDeadLetter = worker.markFailed
+
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	go queue.Run(consumerCtx, pool, func(ctx context.Context, job jobqueue.Job) error {
+		return worker.withSlot(ctx, job, worker.processJob)
+	})
+
+	// Queue scheduled clone jobs as they come due
+	go worker.runScheduler(consumerCtx, queue, cfg.SchedulerInterval, cfg.SchedulerBatchSize)
+
+	// Deliver status webhooks recorded by the API and the pipeline
+	dispatcher := webhooks.NewDispatcher(db)
+	dispatcher.MaxAttempts = cfg.WebhookMaxAttempts
+	go dispatcher.Run(consumerCtx)
+
+	// Health endpoints for orchestration
+	worker.checks = health.New("voice-worker")
+	worker.checks.Add("postgres", health.DB(db))
+	worker.checks.Add("job_queue", queue.Ping)
+	worker.checks.Add("message_bus", health.Redis(bus))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", worker.healthCheck)
+	mux.HandleFunc("/health/live", worker.checks.Live)
+	mux.HandleFunc("/health/ready", worker.checks.Ready)
+	mux.HandleFunc("/metrics", dbpool.Metrics(dbpool.Pool{Name: "primary", DB: db}))
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: middleware.Chain(mux)}
+	go func() {
+		slog.Info("Voice Worker starting", "health_port", cfg.Port)
+		if err := tlsSource.ListenAndServe(server); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop consuming, then let running jobs drain. Jobs that do not finish
+	// in time stay unacknowledged and are reclaimed by another worker.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("Voice Worker shutting down")
+	stopConsumer()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		slog.Warn("Voice clone jobs did not drain", "error", err)
+	}
+	server.Shutdown(ctx)
+}
+
+// priorityWeights parses "high=6,normal=3,low=1". Tiers left out get no
+// guaranteed share; an empty or invalid value keeps the queue defaults.
+func priorityWeights(value string) map[string]int {
+	if value == "" {
+		return nil
+	}
+	weights := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		tier, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || err != nil || n < 0 || types.PriorityRank(tier) < 0 {
+			slog.Warn("Ignoring invalid JOB_PRIORITY_WEIGHTS", "value", value)
+			return nil
+		}
+		weights[tier] = n
+	}
+	return weights
+}
+
+// healthCheck serves the readiness report along with the job pool's
+// counters
+func (wk *Worker) healthCheck(w http.ResponseWriter, r *http.Request) {
+	report := wk.checks.Run(r.Context())
+	utils.JSONResponse(w, report.HTTPStatus(), struct {
+		health.Report
+		Pool workerpool.Stats `json:"pool"`
+	}{report, wk.pool.Stats()})
+}
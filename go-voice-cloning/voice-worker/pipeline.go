@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/manifest"
+	"github.com/voice-cloning/shared/types"
+)
+
+// preprocessingChain lists the preprocessing steps a clone's settings enable
+func preprocessingChain(settings types.CloneSettings) []string {
+	chain := []string{}
+	if p := settings.Preprocessing; p != nil {
+		for _, step := range []struct {
+			name    string
+			enabled *bool
+		}{{"denoise", p.Denoise}, {"normalize", p.Normalize}, {"trim_silence", p.TrimSilence}} {
+			if step.enabled != nil && *step.enabled {
+				chain = append(chain, step.name)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		chain = append(chain, "passthrough")
+	}
+	return chain
+}
+
+// errCloneAbandoned means the clone was deleted or cancelled while its job
+// was queued or running
+var errCloneAbandoned = errors.New("voice clone deleted or cancelled")
+
+func (wk *Worker) processJob(ctx context.Context, job jobqueue.Job) error {
+	if job.SynthesisID != 0 {
+		return wk.processSynthesis(ctx, job.SynthesisID)
+	}
+	if job.ModelVersion != 0 {
+		return wk.processRetrainJob(ctx, job)
+	}
+	err := wk.withCloneLease(ctx, job, func(ctx context.Context, job jobqueue.Job) error {
+		return wk.processVoiceClone(ctx, job.CloneID)
+	})
+	if errors.Is(err, errInvalidSample) {
+		// Retrying the same sample gets the same answer
+		slog.Error("Voice clone failed", "clone_id", job.CloneID, "error", err)
+		wk.markFailed(job, err)
+		return nil
+	}
+	if err != nil && wk.abandoned(job.CloneID, err) {
+		// Nothing left to do; acknowledge instead of retrying
+		slog.Info("Voice clone was deleted or cancelled, dropping job", "clone_id", job.CloneID)
+		return nil
+	}
+	return err
+}
+
+// abandoned reports whether a job failed because its clone was deleted or
+// cancelled. Cancellation interrupts the job's context, which is otherwise
+// indistinguishable from a shutdown, so the clone's status decides.
+func (wk *Worker) abandoned(cloneID int, err error) bool {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errCloneAbandoned) {
+		return true
+	}
+	var status string
+	err = wk.db.Get(&status, "SELECT status FROM voice_clones WHERE id = $1", cloneID)
+	return err == sql.ErrNoRows || status == types.StatusCancelled
+}
+
+// processVoiceClone runs the cloning pipeline for a single clone: fetch the
+// source audio, validate and preprocess it, train a model with the engine,
+// evaluate it and store a synthesized preview as the output
+func (wk *Worker) processVoiceClone(ctx context.Context, cloneID int) error {
+	var clone types.VoiceClone
+	err := wk.db.GetContext(ctx, &clone,
+		"SELECT id, user_id, name, status, source_file, COALESCE(settings, '{}') AS settings FROM voice_clones WHERE id = $1", cloneID)
+	if err != nil {
+		return fmt.Errorf("failed to load clone: %w", err)
+	}
+	if clone.Status == types.StatusCancelled {
+		return errCloneAbandoned
+	}
+	if clone.Status == types.StatusCompleted {
+		// A duplicate of a job that already succeeded
+		slog.Info("Voice clone already completed, dropping job", "clone_id", cloneID)
+		return nil
+	}
+
+	// Update status to processing
+	err = wk.setStatus(ctx, cloneID, "processing", map[string]interface{}{
+		"started_at": time.Now(),
+		"progress":   0,
+		"stage":      types.StagePreprocessing,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark clone processing: %w", err)
+	}
+
+	sources, versions, err := wk.cloneSources(ctx, clone)
+	if err != nil {
+		return fmt.Errorf("failed to load source files: %w", err)
+	}
+	samplePaths, sourcePath, cleanup, err := wk.fetchSamples(ctx, sources, versions)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// Preprocessing (simulated: the source is used as-is)
+	preprocessed, err := wk.storage.UploadFile(ctx, clone.UserID, artifactFile(cloneID, "preprocessed.wav"), sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to store preprocessed sample: %w", err)
+	}
+	wk.registerArtifact(cloneID, types.StagePreprocessing, "preprocessed_sample", preprocessed, "audio/wav")
+
+	// Training, reporting progress from 10 to 80%
+	if err := wk.setProgress(ctx, cloneID, types.StageTraining, 10); err != nil {
+		return err
+	}
+	modelPath := sourcePath + ".model"
+	defer os.Remove(modelPath)
+	reported := 10
+	err = wk.train(ctx, TrainRequest{
+		CloneID:    cloneID,
+		SamplePath: sourcePath,
+		ModelPath:  modelPath,
+		Settings:   clone.Settings,
+		Variant:    variant(wk.capabilities, clone.Settings),
+	}, func(fraction float64) error {
+		pct := 10 + int(math.Min(math.Max(fraction, 0), 1)*70)
+		if pct <= reported {
+			return nil
+		}
+		reported = pct
+		return wk.setProgress(ctx, cloneID, types.StageTraining, pct)
+	})
+	if err != nil {
+		return err
+	}
+	model, err := wk.storage.UploadFile(ctx, clone.UserID, artifactFile(cloneID, "model.bin"), modelPath)
+	if err != nil {
+		return fmt.Errorf("failed to store voice model: %w", err)
+	}
+	wk.registerArtifact(cloneID, types.StageTraining, ArtifactVoiceModel, model, "application/octet-stream")
+	if err := wk.recordModel(ctx, cloneID, 1, model, sources, versions); err != nil {
+		return fmt.Errorf("failed to record voice model: %w", err)
+	}
+
+	// Evaluation: a preview is synthesized and scored against the sample
+	if err := wk.setProgress(ctx, cloneID, types.StageEvaluation, 85); err != nil {
+		return err
+	}
+	previewPath := sourcePath + ".preview.wav"
+	defer os.Remove(previewPath)
+	err = wk.engine.Synthesize(ctx, SynthesizeRequest{
+		ModelPath:  modelPath,
+		Text:       wk.previewText,
+		Language:   clone.Settings.Language,
+		Locale:     clone.Settings.Locale,
+		Variant:    variant(wk.capabilities, clone.Settings),
+		OutputPath: previewPath,
+	})
+	if err != nil {
+		return fmt.Errorf("preview synthesis failed: %w", err)
+	}
+	quality := assessQuality(cloneID, sourcePath, previewPath)
+	preview, err := wk.storage.UploadFile(ctx, clone.UserID, artifactFile(cloneID, "preview_v1.wav"), previewPath)
+	if err != nil {
+		return fmt.Errorf("failed to store preview: %w", err)
+	}
+	wk.registerArtifact(cloneID, types.StageEvaluation, ArtifactPreview, preview, "audio/wav")
+	wk.sharePreview(ctx, cloneID, preview)
+	if err := wk.setModelEvaluation(ctx, cloneID, 1, quality, preview); err != nil {
+		return fmt.Errorf("failed to record quality metrics: %w", err)
+	}
+	report, _ := json.Marshal(map[string]interface{}{
+		"clone_id":     cloneID,
+		"source_files": sources,
+		"quality":      quality,
+		"evaluated_at": time.Now().UTC(),
+	})
+	evaluation, err := wk.storage.UploadBytes(ctx, clone.UserID, artifactFile(cloneID, "evaluation.json"), report)
+	if err != nil {
+		return fmt.Errorf("failed to store evaluation report: %w", err)
+	}
+	wk.registerArtifact(cloneID, types.StageEvaluation, "evaluation_report", evaluation, "application/json")
+
+	// The preview becomes the clone's output
+	if err := wk.setProgress(ctx, cloneID, types.StageSynthesis, 90); err != nil {
+		return err
+	}
+	outputFile, err := wk.storage.UploadFile(ctx, clone.UserID, fmt.Sprintf("clone_%d.wav", cloneID), previewPath)
+	if err != nil {
+		return fmt.Errorf("failed to store output: %w", err)
+	}
+
+	if err := wk.storeManifest(ctx, clone, sources, samplePaths, outputFile, previewPath); err != nil {
+		return fmt.Errorf("failed to store manifest: %w", err)
+	}
+
+	// Update status to completed
+	err = wk.setStatus(ctx, cloneID, "completed", map[string]interface{}{
+		"output_file":   outputFile,
+		"completed_at":  time.Now(),
+		"progress":      100,
+		"model_version": 1,
+		"quality":       quality,
+		"preview_file":  preview,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark clone completed: %w", err)
+	}
+	wk.recordTrainingUsage(ctx, clone.UserID, cloneID, 1, sourcePath)
+
+	slog.Info("Voice clone processing completed", "clone_id", cloneID)
+	return nil
+}
+
+// fetchSamples downloads and validates a clone's sources, at the versions
+// they are pinned to, and combines several into one training sample.
+// cleanup removes the local files.
+func (wk *Worker) fetchSamples(ctx context.Context, sources []string, versions types.SourceVersions) (samplePaths []string, samplePath string, cleanup func(), err error) {
+	paths := make([]string, 0, len(sources))
+	sample := ""
+	cleanup = func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+		if sample != "" && len(paths) > 1 {
+			os.Remove(sample)
+		}
+	}
+
+	for _, source := range sources {
+		path, err := wk.storage.DownloadSource(ctx, source, versions[source])
+		if err != nil {
+			cleanup()
+			return nil, "", nil, fmt.Errorf("failed to fetch source audio %s: %w", source, err)
+		}
+		paths = append(paths, path)
+		if err := wk.engine.Validate(ctx, path); err != nil {
+			cleanup()
+			return nil, "", nil, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	sample = paths[0]
+	if len(paths) > 1 {
+		sample = paths[0] + ".combined.wav"
+		if err := audio.ConcatWAV(sample, paths); err != nil {
+			cleanup()
+			return nil, "", nil, fmt.Errorf("%w: cannot combine sources: %v", errInvalidSample, err)
+		}
+	}
+	return paths, sample, cleanup, nil
+}
+
+// train runs the engine within the engine timeout
+func (wk *Worker) train(ctx context.Context, req TrainRequest, progress func(fraction float64) error) error {
+	trainCtx, cancel := context.WithTimeout(ctx, wk.engineTimeout)
+	defer cancel()
+	err := wk.engine.Train(trainCtx, req, progress)
+	if err != nil {
+		if errors.Is(trainCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			err = fmt.Errorf("%w after %s", errEngineTimeout, wk.engineTimeout)
+		}
+		return fmt.Errorf("training failed: %w", err)
+	}
+	return nil
+}
+
+// setStatus transitions a clone and records the webhook deliveries for the
+// transition in the same transaction. extra holds additional columns to set.
+func (wk *Worker) setStatus(ctx context.Context, cloneID int, status string, extra map[string]interface{}) error {
+	tx, err := wk.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE voice_clones SET status = $1, updated_at = $2"
+	args := []interface{}{status, time.Now()}
+	for _, column := range []string{"started_at", "output_file", "completed_at", "progress", "stage", "error_code", "error_message", "model_version", "quality", "preview_file"} {
+		if value, ok := extra[column]; ok {
+			args = append(args, value)
+			query += fmt.Sprintf(", %s = $%d", column, len(args))
+		}
+	}
+	args = append(args, cloneID, types.StatusCancelled)
+	query += fmt.Sprintf(" WHERE id = $%d AND status <> $%d", len(args)-1, len(args))
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCloneAbandoned
+	}
+	if err := events.PublishCloneStatus(ctx, tx, cloneID, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setProgress records the stage and percentage of a processing clone. It
+// doubles as a cancellation checkpoint: a clone that is no longer processing
+// stops the job. updated_at is left alone so progress doesn't invalidate the
+// clone's ETag.
+func (wk *Worker) setProgress(ctx context.Context, cloneID int, stage string, progress int) error {
+	tx, err := wk.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE voice_clones SET stage = $1, progress = $2 WHERE id = $3 AND status = $4",
+		stage, progress, cloneID, types.StatusProcessing)
+	if err != nil {
+		return fmt.Errorf("failed to record progress: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCloneAbandoned
+	}
+	if err := events.PublishCloneProgress(ctx, tx, cloneID); err != nil {
+		return fmt.Errorf("failed to record progress: %w", err)
+	}
+	return tx.Commit()
+}
+
+// cloneSources lists a clone's source files in training order, and the
+// versions they are pinned to. Clones created before clone_sources existed
+// have only their source_file.
+func (wk *Worker) cloneSources(ctx context.Context, clone types.VoiceClone) ([]string, types.SourceVersions, error) {
+	var rows []struct {
+		Filename string        `db:"filename"`
+		Version  sql.NullInt64 `db:"file_version"`
+	}
+	err := wk.db.SelectContext(ctx, &rows,
+		"SELECT filename, file_version FROM clone_sources WHERE clone_id = $1 ORDER BY position", clone.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources, versions := []string{}, types.SourceVersions{}
+	for _, row := range rows {
+		sources = append(sources, row.Filename)
+		if row.Version.Valid {
+			versions[row.Filename] = int(row.Version.Int64)
+		}
+	}
+	if len(sources) == 0 {
+		sources = append(sources, clone.SourceFile)
+	}
+	return sources, versions, nil
+}
+
+// storeManifest signs and records the reproducibility manifest of a job.
+// Retried jobs replace the manifest of their earlier attempt.
+func (wk *Worker) storeManifest(ctx context.Context, clone types.VoiceClone, sources, samplePaths []string, outputFile, outputPath string) error {
+	samples := make([]manifest.File, len(sources))
+	for i, source := range sources {
+		sample, err := manifest.Digest(source, samplePaths[i])
+		if err != nil {
+			return err
+		}
+		samples[i] = sample
+	}
+	output, err := manifest.Digest(outputFile, outputPath)
+	if err != nil {
+		return err
+	}
+
+	signed, err := wk.signer.Sign(manifest.Manifest{
+		SchemaVersion: manifest.SchemaVersion,
+		CloneID:       clone.ID,
+		ModelVersion:  wk.modelVersion,
+		Parameters: map[string]interface{}{
+			"engine":         wk.engine.Name(),
+			"model":          clone.Settings.Model,
+			"language":       clone.Settings.Language,
+			"locale":         clone.Settings.Locale,
+			"variant":        variant(wk.capabilities, clone.Settings),
+			"output_formats": clone.Settings.OutputFormats,
+		},
+		Samples:            samples,
+		PreprocessingChain: preprocessingChain(clone.Settings),
+		Outputs:            []manifest.File{output},
+		WorkerImage:        wk.imageDigest,
+		GeneratedAt:        time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = wk.db.ExecContext(ctx,
+		`INSERT INTO clone_manifests (clone_id, manifest, algorithm, key_id, public_key, signature, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (clone_id) DO UPDATE SET manifest = EXCLUDED.manifest, algorithm = EXCLUDED.algorithm,
+			key_id = EXCLUDED.key_id, public_key = EXCLUDED.public_key, signature = EXCLUDED.signature,
+			created_at = EXCLUDED.created_at`,
+		clone.ID, string(signed.Payload), signed.Algorithm, signed.KeyID, signed.PublicKey, signed.Signature, time.Now())
+	return err
+}
+
+// markFailed records that a job exhausted its retries, or failed in a way
+// retrying won't fix, and why
+func (wk *Worker) markFailed(job jobqueue.Job, cause error) {
+	if job.SynthesisID != 0 {
+		wk.failSynthesis(job.SynthesisID, cause)
+		return
+	}
+	code, message := cloneFailure(cause)
+	if job.ModelVersion != 0 {
+		// The clone keeps its current model
+		wk.failModel(job.CloneID, job.ModelVersion, code, message)
+		return
+	}
+	err := wk.setStatus(context.Background(), job.CloneID, "failed", map[string]interface{}{
+		"error_code":    code,
+		"error_message": message,
+	})
+	if err != nil {
+		slog.Error("Failed to mark voice clone failed", "clone_id", job.CloneID, "error", err)
+	}
+}
+
+// cloneFailure turns the error a clone job gave up on into an error code and
+// a message for its owner. Only rejections of the audio are passed on
+// verbatim; other errors can name internal hosts and paths, and are logged.
+func cloneFailure(err error) (string, string) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errInvalidSample):
+		return types.CloneErrorInvalidAudio, err.Error()
+	case errors.Is(err, errEngineOutOfMemory):
+		return types.CloneErrorOutOfMemory, "The cloning engine ran out of memory; try shorter or fewer source files"
+	case errors.Is(err, errEngineTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return types.CloneErrorEngineTimeout, "The cloning engine timed out; retry the clone"
+	}
+	return types.CloneErrorInternal, "Processing failed; retry the clone"
+}
+
+// registerArtifact records a file produced by a pipeline stage. Failures are
+// logged rather than failing the job since artifacts are diagnostic only.
+func (wk *Worker) registerArtifact(cloneID int, stage, kind, file, contentType string) {
+	_, err := wk.db.Exec(
+		"INSERT INTO clone_artifacts (clone_id, stage, kind, file, content_type, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		cloneID, stage, kind, file, contentType, time.Now())
+	if err != nil {
+		slog.Error("Failed to register artifact", "kind", kind, "clone_id", cloneID, "error", err)
+	}
+}
+
+// sharePreview gives a new preview the ACL matching its clone's visibility,
+// shared with the users the clone is shared with. Failures are logged: the
+// voice service syncs the previews again when the clone's sharing changes.
+func (wk *Worker) sharePreview(ctx context.Context, cloneID int, preview string) {
+	var visibility string
+	if err := wk.db.GetContext(ctx, &visibility, "SELECT visibility FROM voice_clones WHERE id = $1", cloneID); err != nil {
+		slog.Error("Failed to share preview", "clone_id", cloneID, "error", err)
+		return
+	}
+	acl := types.FileACLRequest{ACL: types.PreviewACL(visibility), Users: []int{}}
+	if err := wk.db.SelectContext(ctx, &acl.Users,
+		"SELECT user_id FROM clone_shares WHERE clone_id = $1 ORDER BY user_id", cloneID); err != nil {
+		slog.Error("Failed to share preview", "clone_id", cloneID, "error", err)
+		return
+	}
+	if err := wk.storage.SetACL(ctx, preview, acl); err != nil {
+		slog.Error("Failed to share preview", "clone_id", cloneID, "error", err)
+	}
+}
+
+// ArtifactVoiceModel is the kind of the trained model artifact that
+// synthesis jobs speak with
+const ArtifactVoiceModel = "voice_model"
+
+// ArtifactPreview is the kind of a model version's preview sample, the
+// standard preview phrase spoken with it
+const ArtifactPreview = "preview"
+
+// artifactFile is the filename a clone's artifact is stored with; the storage
+// service references it by the ID it returns
+func artifactFile(cloneID int, name string) string {
+	return fmt.Sprintf("clone_%d_%s", cloneID, name)
+}
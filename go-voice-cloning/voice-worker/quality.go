@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/types"
+)
+
+// assessQuality measures the training sample and, when a preview was
+// synthesized, how alike the two sound. Audio the analysis can't decode
+// leaves its metrics out rather than failing the job.
+func assessQuality(cloneID int, samplePath, previewPath string) *types.QualityMetrics {
+	quality := &types.QualityMetrics{}
+	sample, err := audio.Analyze(samplePath)
+	if err != nil {
+		slog.Warn("Skipping quality metrics", "clone_id", cloneID, "error", err)
+		return quality
+	}
+	total := round(sample.Duration.Seconds(), 1)
+	usable := round(sample.SpeechDuration.Seconds(), 1)
+	snr := round(sample.SNR, 1)
+	quality.TotalAudioSeconds = &total
+	quality.UsableAudioSeconds = &usable
+	quality.SNRDB = &snr
+	if snr < types.MinSNRDB {
+		quality.Warnings = append(quality.Warnings, types.QualityWarningNoisy)
+	}
+	if usable < types.MinUsableAudioSeconds {
+		quality.Warnings = append(quality.Warnings, types.QualityWarningShort)
+	}
+
+	if previewPath == "" {
+		return quality
+	}
+	preview, err := audio.Analyze(previewPath)
+	if err != nil {
+		slog.Warn("Skipping similarity score", "clone_id", cloneID, "error", err)
+		return quality
+	}
+	similarity := round(audio.Similarity(sample, preview), 3)
+	quality.SimilarityScore = &similarity
+	if similarity < types.MinSimilarityScore {
+		quality.Warnings = append(quality.Warnings, types.QualityWarningLowSimilarity)
+	}
+	return quality
+}
+
+// setModelEvaluation records the quality metrics and preview sample of a
+// model version
+func (wk *Worker) setModelEvaluation(ctx context.Context, cloneID, version int, quality *types.QualityMetrics, preview string) error {
+	_, err := wk.db.ExecContext(ctx,
+		"UPDATE clone_models SET quality = $1, preview_file = $2 WHERE clone_id = $3 AND version = $4",
+		quality, preview, cloneID, version)
+	return err
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/types"
+)
+
+// processRetrainJob trains a new model version of a clone, dropping the job
+// if the clone was deleted meanwhile
+func (wk *Worker) processRetrainJob(ctx context.Context, job jobqueue.Job) error {
+	err := wk.processRetrain(ctx, job.CloneID, job.ModelVersion)
+	if errors.Is(err, errInvalidSample) {
+		slog.Error("Retraining failed", "clone_id", job.CloneID, "version", job.ModelVersion, "error", err)
+		wk.markFailed(job, err)
+		return nil
+	}
+	if err != nil && wk.retrainAbandoned(job.CloneID, job.ModelVersion, err) {
+		slog.Info("Retraining was cancelled, dropping job", "clone_id", job.CloneID)
+		return nil
+	}
+	return err
+}
+
+// retrainAbandoned reports whether a retraining failed because its clone was
+// deleted, which cancels the model version
+func (wk *Worker) retrainAbandoned(cloneID, version int, err error) bool {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errCloneAbandoned) {
+		return true
+	}
+	var status string
+	err = wk.db.Get(&status, "SELECT status FROM clone_models WHERE clone_id = $1 AND version = $2", cloneID, version)
+	return err == sql.ErrNoRows || status == types.StatusCancelled
+}
+
+// processRetrain trains a model version from the sources recorded with it.
+// The clone keeps synthesizing with its current model until the new one is
+// stored, then switches to it.
+func (wk *Worker) processRetrain(ctx context.Context, cloneID, version int) error {
+	var model struct {
+		types.CloneModel
+		UserID   int                 `db:"user_id"`
+		Settings types.CloneSettings `db:"settings"`
+	}
+	err := wk.db.GetContext(ctx, &model,
+		`SELECT m.clone_id, m.version, m.status, m.source_files, m.source_versions, c.user_id, COALESCE(c.settings, '{}') AS settings
+		FROM clone_models m JOIN voice_clones c ON c.id = m.clone_id
+		WHERE m.clone_id = $1 AND m.version = $2 AND c.deleted_at IS NULL`,
+		cloneID, version)
+	if err != nil {
+		return fmt.Errorf("failed to load model version: %w", err)
+	}
+	switch model.Status {
+	case types.StatusCompleted, types.StatusFailed:
+		return nil
+	case types.StatusCancelled:
+		return errCloneAbandoned
+	}
+
+	if err := wk.setModelProgress(ctx, cloneID, version, 0); err != nil {
+		return err
+	}
+	_, samplePath, cleanup, err := wk.fetchSamples(ctx, model.SourceFiles, model.SourceVersions)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// Training reports progress up to 90%; storing the model is the rest
+	modelPath := samplePath + ".model"
+	defer os.Remove(modelPath)
+	reported := 0
+	err = wk.train(ctx, TrainRequest{
+		CloneID:    cloneID,
+		SamplePath: samplePath,
+		ModelPath:  modelPath,
+		Settings:   model.Settings,
+		Variant:    variant(wk.capabilities, model.Settings),
+	}, func(fraction float64) error {
+		pct := int(math.Min(math.Max(fraction, 0), 1) * 90)
+		if pct <= reported {
+			return nil
+		}
+		reported = pct
+		return wk.setModelProgress(ctx, cloneID, version, pct)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A preview scores the new model like the clone job's
+	previewPath := samplePath + ".preview.wav"
+	defer os.Remove(previewPath)
+	err = wk.engine.Synthesize(ctx, SynthesizeRequest{
+		ModelPath:  modelPath,
+		Text:       wk.previewText,
+		Language:   model.Settings.Language,
+		Locale:     model.Settings.Locale,
+		Variant:    variant(wk.capabilities, model.Settings),
+		OutputPath: previewPath,
+	})
+	if err != nil {
+		return fmt.Errorf("preview synthesis failed: %w", err)
+	}
+	quality := assessQuality(cloneID, samplePath, previewPath)
+	preview, err := wk.storage.UploadFile(ctx, model.UserID, artifactFile(cloneID, fmt.Sprintf("preview_v%d.wav", version)), previewPath)
+	if err != nil {
+		return fmt.Errorf("failed to store preview: %w", err)
+	}
+	wk.registerArtifact(cloneID, types.StageEvaluation, ArtifactPreview, preview, "audio/wav")
+	wk.sharePreview(ctx, cloneID, preview)
+
+	file, err := wk.storage.UploadFile(ctx, model.UserID, artifactFile(cloneID, fmt.Sprintf("model_v%d.bin", version)), modelPath)
+	if err != nil {
+		return fmt.Errorf("failed to store voice model: %w", err)
+	}
+	if err := wk.completeModel(ctx, cloneID, version, file, preview, quality); err != nil {
+		return fmt.Errorf("failed to mark model version completed: %w", err)
+	}
+	wk.recordTrainingUsage(ctx, model.UserID, cloneID, version, samplePath)
+
+	slog.Info("Voice clone retrained", "clone_id", cloneID, "version", version)
+	return nil
+}
+
+// setModelProgress marks a model version processing at a percentage. Like
+// setProgress it is a cancellation checkpoint: a deleted clone stops the job.
+func (wk *Worker) setModelProgress(ctx context.Context, cloneID, version, progress int) error {
+	res, err := wk.db.ExecContext(ctx,
+		`UPDATE clone_models SET status = $1, progress = $2
+		WHERE clone_id = $3 AND version = $4 AND status IN ($5, $1)
+			AND EXISTS (SELECT 1 FROM voice_clones WHERE id = $3 AND deleted_at IS NULL)`,
+		types.StatusProcessing, progress, cloneID, version, types.StatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to record progress: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCloneAbandoned
+	}
+	return nil
+}
+
+// completeModel stores a trained model version and makes it the clone's
+// current one, unless a later version already is. The clone's quality
+// metrics and preview follow its current model.
+func (wk *Worker) completeModel(ctx context.Context, cloneID, version int, file, preview string, quality *types.QualityMetrics) error {
+	tx, err := wk.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The file is recorded even if the clone was deleted, so purging it
+	// deletes the file too
+	res, err := tx.ExecContext(ctx,
+		`UPDATE clone_models SET file = $1, quality = $2, preview_file = $8,
+			status = CASE WHEN status = $3 THEN $4 ELSE status END,
+			progress = CASE WHEN status = $3 THEN 100 ELSE progress END,
+			completed_at = CASE WHEN status = $3 THEN $5 ELSE completed_at END
+		WHERE clone_id = $6 AND version = $7`,
+		file, quality, types.StatusProcessing, types.StatusCompleted, time.Now(), cloneID, version, preview)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCloneAbandoned
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE voice_clones SET model_version = $1, quality = $2, preview_file = $5, updated_at = NOW()
+		WHERE id = $3 AND COALESCE(model_version, 0) < $1
+			AND EXISTS (SELECT 1 FROM clone_models WHERE clone_id = $3 AND version = $1 AND status = $4)`,
+		version, quality, cloneID, types.StatusCompleted, preview)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordModel records the model trained by a clone job as a completed
+// model version
+func (wk *Worker) recordModel(ctx context.Context, cloneID, version int, file string, sources []string, versions types.SourceVersions) error {
+	_, err := wk.db.ExecContext(ctx,
+		`INSERT INTO clone_models (clone_id, version, status, file, source_files, source_versions, progress, created_at, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $7, 100, $6, $6)
+		ON CONFLICT (clone_id, version) DO UPDATE SET status = EXCLUDED.status, file = EXCLUDED.file,
+			source_files = EXCLUDED.source_files, source_versions = EXCLUDED.source_versions, progress = 100,
+			completed_at = EXCLUDED.completed_at`,
+		cloneID, version, types.StatusCompleted, file, pq.StringArray(sources), time.Now(), versions)
+	return err
+}
+
+// failModel records why a retraining gave up
+func (wk *Worker) failModel(cloneID, version int, code, message string) {
+	_, err := wk.db.Exec(
+		`UPDATE clone_models SET status = $1, error_code = $2, error_message = $3
+		WHERE clone_id = $4 AND version = $5 AND status IN ($6, $7)`,
+		types.StatusFailed, code, message, cloneID, version, types.StatusPending, types.StatusProcessing)
+	if err != nil {
+		slog.Error("Failed to mark model version failed", "clone_id", cloneID, "version", version, "error", err)
+	}
+}
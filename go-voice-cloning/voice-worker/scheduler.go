@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/types"
+)
+
+// runScheduler queues scheduled clone jobs once their process_after time
+// has come, checking every interval until ctx is cancelled
+func (wk *Worker) runScheduler(ctx context.Context, queue *jobqueue.Queue, interval time.Duration, batch int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := wk.releaseScheduled(ctx, queue, batch)
+			if err != nil {
+				slog.Error("Scheduler failed to queue scheduled clones", "error", err)
+			}
+			// A full batch means more may be due
+			if err != nil || n < batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseScheduled queues up to batch clone jobs that are due, earliest
+// first, and clears their process_after. Workers running the scheduler side
+// by side skip each other's rows; a job that can't be queued stays due for
+// the next run.
+func (wk *Worker) releaseScheduled(ctx context.Context, queue *jobqueue.Queue, batch int) (int, error) {
+	tx, err := wk.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var due []struct {
+		ID       int    `db:"id"`
+		Priority string `db:"priority"`
+	}
+	err = tx.SelectContext(ctx, &due,
+		`SELECT id, priority FROM voice_clones
+		WHERE process_after <= $1 AND status = $2 AND deleted_at IS NULL
+		ORDER BY process_after LIMIT $3 FOR UPDATE SKIP LOCKED`,
+		time.Now(), types.StatusPending, batch)
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	released := 0
+	for _, clone := range due {
+		if err := queue.Enqueue(ctx, jobqueue.Job{CloneID: clone.ID, Priority: clone.Priority}); err != nil {
+			// Queue what was queued; the rest waits for the next run
+			slog.Error("Scheduler failed to queue voice clone", "clone_id", clone.ID, "error", err)
+			break
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE voice_clones SET process_after = NULL WHERE id = $1", clone.ID); err != nil {
+			return 0, err
+		}
+		released++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if released > 0 {
+		slog.Info("Scheduler queued scheduled voice clones", "count", released)
+	}
+	return released, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/voice-cloning/shared/jobqueue"
+	"github.com/voice-cloning/shared/types"
+)
+
+// Processing slot leases. A job renews its lease while it runs, so the slot
+// of a worker that dies frees up once the lease runs out.
+const (
+	slotLease   = 2 * time.Minute
+	slotRenewal = 30 * time.Second
+)
+
+// acquireSlotScript takes one of a user's processing slots (KEYS[1]) unless
+// all ARGV[4] are leased: ARGV[1] is now, ARGV[2] the lease's expiry and
+// ARGV[3] the holder, all times in milliseconds
+var acquireSlotScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2] - ARGV[1])
+return 1
+`)
+
+// processingLimitsFromEnv starts from the default processing limit of each
+// plan; PLAN_<PLAN>_MAX_PROCESSING_JOBS overrides it, 0 meaning unlimited
+func processingLimitsFromEnv() map[string]int {
+	limits := map[string]int{}
+	for plan, l := range types.DefaultPlanLimits {
+		limits[plan] = l.MaxProcessingJobs
+		if value, ok := os.LookupEnv("PLAN_" + strings.ToUpper(plan) + "_MAX_PROCESSING_JOBS"); ok {
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				limits[plan] = n
+			}
+		}
+	}
+	return limits
+}
+
+// releaseLeaseScript deletes a clone's lease (KEYS[1]) if ARGV[1] still
+// holds it, and renewLeaseScript extends it to ARGV[2] milliseconds
+var (
+	releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+func slotsKey(userID int) string {
+	return fmt.Sprintf("voice:jobs:user:%d:slots", userID)
+}
+
+// withSlot runs a job in one of its user's processing slots, shared by the
+// whole worker fleet. A job whose user has every slot taken is put back in
+// the queue to wait its turn. The limit is best effort: when the user or
+// the slots can't be looked up the job runs anyway.
+func (wk *Worker) withSlot(ctx context.Context, job jobqueue.Job, handler jobqueue.Handler) error {
+	userID, plan, err := wk.jobOwner(ctx, job)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted; the handler drops it
+		return handler(ctx, job)
+	}
+	if err != nil {
+		slog.Warn("Failed to look up the user of a job, running it unlimited", "clone_id", job.CloneID, "error", err)
+		return handler(ctx, job)
+	}
+	limit, ok := wk.processingLimits[plan]
+	if !ok {
+		limit = wk.processingLimits[types.PlanFree]
+	}
+	if limit == 0 {
+		return handler(ctx, job)
+	}
+
+	holder := newSlotHolder()
+	now := time.Now()
+	acquired, err := acquireSlotScript.Run(ctx, wk.bus, []string{slotsKey(userID)},
+		now.UnixMilli(), now.Add(slotLease).UnixMilli(), holder, limit).Int()
+	if err != nil {
+		slog.Warn("Failed to take a processing slot, running the job unlimited", "user_id", userID, "clone_id", job.CloneID, "error", err)
+		return handler(ctx, job)
+	}
+	if acquired == 0 {
+		return jobqueue.Defer(wk.slotWait)
+	}
+	defer wk.bus.ZRem(context.Background(), slotsKey(userID), holder)
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go wk.renewSlot(renewCtx, userID, holder)
+
+	return handler(ctx, job)
+}
+
+// renewSlot extends a slot's lease until ctx is cancelled
+func (wk *Worker) renewSlot(ctx context.Context, userID int, holder string) {
+	ticker := time.NewTicker(slotRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expiry := time.Now().Add(slotLease)
+			_, err := wk.bus.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZAdd(ctx, slotsKey(userID), redis.Z{Score: float64(expiry.UnixMilli()), Member: holder})
+				pipe.PExpire(ctx, slotsKey(userID), slotLease)
+				return nil
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to renew processing slot", "user_id", userID, "error", err)
+			}
+		}
+	}
+}
+
+func cloneLeaseKey(cloneID int) string {
+	return fmt.Sprintf("voice:jobs:clone:%d:lease", cloneID)
+}
+
+// withCloneLease runs a clone's job holding the clone's lease, so two
+// attempts at one clone, such as a job and its reclaimed copy, never run at
+// once. A job whose clone is leased by another attempt is put back in the
+// queue; once that attempt is done it finds the clone completed and is
+// dropped, or runs if the attempt failed. Like slots, the lease is best
+// effort: when it can't be taken the job runs anyway.
+func (wk *Worker) withCloneLease(ctx context.Context, job jobqueue.Job, handler jobqueue.Handler) error {
+	key, holder := cloneLeaseKey(job.CloneID), newSlotHolder()
+	acquired, err := wk.bus.SetNX(ctx, key, holder, slotLease).Result()
+	if err != nil {
+		slog.Warn("Failed to take a clone's lease, running the job anyway", "clone_id", job.CloneID, "error", err)
+		return handler(ctx, job)
+	}
+	if !acquired {
+		slog.Info("Voice clone is being processed by another attempt, deferring job", "clone_id", job.CloneID)
+		return jobqueue.Defer(wk.slotWait)
+	}
+	defer releaseLeaseScript.Run(context.Background(), wk.bus, []string{key}, holder)
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	defer stopRenewing()
+	go wk.renewCloneLease(renewCtx, key, holder)
+
+	return handler(ctx, job)
+}
+
+// renewCloneLease extends a clone's lease until ctx is cancelled
+func (wk *Worker) renewCloneLease(ctx context.Context, key, holder string) {
+	ticker := time.NewTicker(slotRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := renewLeaseScript.Run(ctx, wk.bus, []string{key}, holder, slotLease.Milliseconds()).Err()
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to renew a clone's lease", "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// jobOwner returns the user a job runs for and their plan: the requester
+// of a synthesis job, else the clone's owner
+func (wk *Worker) jobOwner(ctx context.Context, job jobqueue.Job) (int, string, error) {
+	var owner struct {
+		UserID int    `db:"user_id"`
+		Plan   string `db:"plan"`
+	}
+	var err error
+	if job.SynthesisID != 0 {
+		err = wk.db.GetContext(ctx, &owner,
+			`SELECT s.user_id, COALESCE(u.plan, '') AS plan
+			FROM synthesis_jobs s LEFT JOIN users u ON u.id = s.user_id WHERE s.id = $1`, job.SynthesisID)
+	} else {
+		err = wk.db.GetContext(ctx, &owner,
+			`SELECT c.user_id, COALESCE(u.plan, '') AS plan
+			FROM voice_clones c LEFT JOIN users u ON u.id = c.user_id WHERE c.id = $1`, job.CloneID)
+	}
+	return owner.UserID, owner.Plan, err
+}
+
+func newSlotHolder() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
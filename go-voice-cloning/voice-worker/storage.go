@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voice-cloning/shared/types"
+	"github.com/voice-cloning/shared/utils"
+)
+
+// StorageClient moves audio between the worker and the storage service
+type StorageClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewStorageClient(baseURL string) *StorageClient {
+	return &StorageClient{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Download streams a stored file to a local temporary file and returns its path
+func (c *StorageClient) Download(ctx context.Context, id string) (string, error) {
+	return c.download(ctx, id, "", 0)
+}
+
+// DownloadSource is Download for source audio at a version of its content,
+// the latest when version is 0, fetching the variant normalized for the
+// engine when the storage service has one
+func (c *StorageClient) DownloadSource(ctx context.Context, id string, version int) (string, error) {
+	return c.download(ctx, id, "preferred", version)
+}
+
+func (c *StorageClient) download(ctx context.Context, id, variant string, version int) (string, error) {
+	query := url.Values{}
+	if variant != "" {
+		query.Set("variant", variant)
+	}
+	if version > 0 {
+		query.Set("version", strconv.Itoa(version))
+	}
+	u := c.baseURL + "/download/" + url.PathEscape(id)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("storage download of %s returned %d", id, resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp("", "voice-source-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	// The storage service sends the digest of stored content, so a file
+	// corrupted on disk or on the way isn't trained on
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if expected := digestSHA256(resp.Header.Get("Digest")); expected != nil && !bytes.Equal(expected, hash.Sum(nil)) {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("storage download of %s doesn't match its digest", id)
+	}
+	return tmp.Name(), nil
+}
+
+// digestSHA256 returns the SHA-256 in a Digest header, nil if it has none
+func digestSHA256(header string) []byte {
+	for _, digest := range strings.Split(header, ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(digest), "=")
+		if !ok || !strings.EqualFold(alg, "sha-256") {
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == sha256.Size {
+			return sum
+		}
+	}
+	return nil
+}
+
+// Upload stores content named filename and returns the ID the storage
+// service gave it. Everything the worker writes is generated output, counted
+// against the owner's output quota. The content's checksum follows it, so
+// the storage service refuses it if it arrives corrupted.
+func (c *StorageClient) Upload(ctx context.Context, userID int, filename string, content io.Reader) (string, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		hash := sha256.New()
+		part, err := writer.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(io.MultiWriter(part, hash), content)
+		}
+		if err == nil {
+			err = writer.WriteField("checksum_sha256", hex.EncodeToString(hash.Sum(nil)))
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/upload", pr)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	req.Header.Set("X-Storage-Class", "output")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("storage upload of %s returned %d", filename, resp.StatusCode)
+	}
+	var stored struct {
+		ID string `json:"id"`
+	}
+	if err := utils.DecodeData(resp, &stored); err != nil || stored.ID == "" {
+		return "", fmt.Errorf("storage upload of %s returned no file ID", filename)
+	}
+	return stored.ID, nil
+}
+
+// UploadFile stores a local file named filename
+func (c *StorageClient) UploadFile(ctx context.Context, userID int, filename, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return c.Upload(ctx, userID, filename, f)
+}
+
+// UploadBytes stores an in-memory payload named filename
+func (c *StorageClient) UploadBytes(ctx context.Context, userID int, filename string, data []byte) (string, error) {
+	return c.Upload(ctx, userID, filename, bytes.NewReader(data))
+}
+
+// SetACL sets who can read a stored file
+func (c *StorageClient) SetACL(ctx context.Context, id string, acl types.FileACLRequest) error {
+	body, _ := json.Marshal(acl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/files/"+url.PathEscape(id)+"/acl", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage ACL update of %s returned %d", id, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/speechstream"
+	"github.com/voice-cloning/shared/types"
+)
+
+// processSynthesis generates speech with a completed clone's voice and
+// stores it as the job's output
+func (wk *Worker) processSynthesis(ctx context.Context, synthesisID int) error {
+	var job struct {
+		types.SynthesisJob
+		CloneStatus string              `db:"clone_status"`
+		Settings    types.CloneSettings `db:"settings"`
+		Model       string              `db:"model"`
+	}
+	// The pinned model version, else the clone's current one. Clones trained
+	// before models were versioned, or kept, fall back to their model
+	// artifact or their output.
+	err := wk.db.GetContext(ctx, &job,
+		`SELECT s.id, s.clone_id, s.user_id, COALESCE(s.text, '') AS text, COALESCE(s.ssml, '') AS ssml, s.status,
+			c.status AS clone_status, COALESCE(c.settings, '{}') AS settings,
+			COALESCE((SELECT m.file FROM clone_models m WHERE m.clone_id = c.id AND m.status = $3
+					AND m.version = COALESCE(s.model_version, c.model_version)),
+				CASE WHEN s.model_version IS NULL THEN COALESCE(
+					(SELECT a.file FROM clone_artifacts a WHERE a.clone_id = c.id AND a.kind = $2 ORDER BY a.id DESC LIMIT 1),
+					c.output_file) END,
+				'') AS model
+		FROM synthesis_jobs s JOIN voice_clones c ON c.id = s.clone_id WHERE s.id = $1`,
+		synthesisID, ArtifactVoiceModel, types.StatusCompleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted along with its clone
+		slog.Info("Synthesis job no longer exists, dropping job", "synthesis_id", synthesisID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load synthesis job: %w", err)
+	}
+	if job.Status == types.StatusCompleted || job.Status == types.StatusFailed {
+		return nil
+	}
+	if job.CloneStatus != types.StatusCompleted || job.Model == "" {
+		wk.failSynthesis(synthesisID, errors.New("voice clone is no longer available"))
+		return nil
+	}
+
+	_, err = wk.db.ExecContext(ctx, "UPDATE synthesis_jobs SET status = $1, updated_at = $2 WHERE id = $3",
+		types.StatusProcessing, time.Now(), synthesisID)
+	if err != nil {
+		return fmt.Errorf("failed to mark synthesis processing: %w", err)
+	}
+
+	modelPath, err := wk.storage.Download(ctx, job.Model)
+	if err != nil {
+		return fmt.Errorf("failed to fetch voice model: %w", err)
+	}
+	defer os.Remove(modelPath)
+
+	// Listeners hear the speech as it is produced
+	stream := speechstream.NewWriter(ctx, wk.bus, synthesisID)
+	outputPath := modelPath + ".wav"
+	defer os.Remove(outputPath)
+	err = wk.engine.Synthesize(ctx, SynthesizeRequest{
+		ModelPath:  modelPath,
+		Text:       job.Text,
+		SSML:       job.SSML,
+		Language:   job.Settings.Language,
+		Locale:     job.Settings.Locale,
+		Variant:    variant(wk.capabilities, job.Settings),
+		OutputPath: outputPath,
+		Stream:     stream,
+	})
+	if err := stream.Close(err); err != nil {
+		slog.Warn("Failed to stream synthesis job", "synthesis_id", synthesisID, "error", err)
+	}
+	if err != nil {
+		return fmt.Errorf("synthesis failed: %w", err)
+	}
+
+	outputFile, err := wk.storage.UploadFile(ctx, job.UserID, fmt.Sprintf("synthesis_%d.wav", synthesisID), outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to store synthesized speech: %w", err)
+	}
+
+	// The length counts against the user's monthly synthesis minutes
+	var durationMS int64
+	if info, err := audio.Probe(outputPath); err == nil {
+		durationMS = info.Duration().Milliseconds()
+	} else {
+		slog.Warn("Failed to measure synthesis output", "synthesis_id", synthesisID, "error", err)
+	}
+
+	if err := wk.completeSynthesis(ctx, job.SynthesisJob, outputFile, durationMS); err != nil {
+		return fmt.Errorf("failed to mark synthesis completed: %w", err)
+	}
+
+	slog.Info("Synthesis job completed", "synthesis_id", synthesisID, "clone_id", job.CloneID)
+	return nil
+}
+
+// completeSynthesis records a job's output and adds its length to the
+// user's usage for the month, and its length and characters to the metered
+// usage
+func (wk *Worker) completeSynthesis(ctx context.Context, job types.SynthesisJob, outputFile string, durationMS int64) error {
+	tx, err := wk.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		"UPDATE synthesis_jobs SET status = $1, output_file = $2, duration_ms = $3, completed_at = $4, updated_at = $4 WHERE id = $5",
+		types.StatusCompleted, outputFile, durationMS, now, job.ID)
+	if err != nil {
+		return err
+	}
+	utc := now.UTC()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO synthesis_usage (user_id, period, duration_ms) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, period) DO UPDATE SET duration_ms = synthesis_usage.duration_ms + EXCLUDED.duration_ms`,
+		job.UserID, time.Date(utc.Year(), utc.Month(), 1, 0, 0, 0, 0, time.UTC), durationMS)
+	if err != nil {
+		return err
+	}
+	source := fmt.Sprintf("synthesis:%d", job.ID)
+	if err := events.RecordUsage(ctx, tx, job.UserID, types.UsageSynthesisMS, source, durationMS, now); err != nil {
+		return err
+	}
+	text := job.Text
+	if text == "" {
+		text = job.SSML
+	}
+	if err := events.RecordUsage(ctx, tx, job.UserID, types.UsageSynthesisCharacters, source, int64(utf8.RuneCountInString(text)), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// failSynthesis records why a synthesis job gave up
+func (wk *Worker) failSynthesis(synthesisID int, cause error) {
+	_, err := wk.db.Exec("UPDATE synthesis_jobs SET status = $1, error = $2, updated_at = $3 WHERE id = $4",
+		types.StatusFailed, cause.Error(), time.Now(), synthesisID)
+	if err != nil {
+		slog.Error("Failed to mark synthesis job failed", "synthesis_id", synthesisID, "error", err)
+	}
+}
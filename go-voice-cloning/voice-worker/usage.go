@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/voice-cloning/shared/audio"
+	"github.com/voice-cloning/shared/events"
+	"github.com/voice-cloning/shared/types"
+)
+
+// recordTrainingUsage meters the audio a clone job or retraining processed:
+// the length of its training sample, once per model version. The model is
+// already stored, so a failure is only logged.
+func (wk *Worker) recordTrainingUsage(ctx context.Context, userID, cloneID, version int, samplePath string) {
+	info, err := audio.Probe(samplePath)
+	if err != nil {
+		slog.Warn("Failed to measure the training sample for usage", "clone_id", cloneID, "version", version, "error", err)
+		return
+	}
+	source := fmt.Sprintf("clone:%d:v%d", cloneID, version)
+	err = events.RecordUsage(ctx, wk.db, userID, types.UsageAudioProcessedMS, source, info.Duration().Milliseconds(), time.Now())
+	if err != nil {
+		slog.Error("Failed to record training usage", "clone_id", cloneID, "version", version, "error", err)
+	}
+}